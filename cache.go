@@ -0,0 +1,164 @@
+package lxd
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// NameCacheTTL is how long the names listed on a remote and the targets of
+// its image aliases are reused for, sparing the shell completion a round trip
+// on every key press. Any change made through a Client drops the cache of its
+// remote.
+var NameCacheTTL = 30 * time.Second
+
+type nameCacheEntry struct {
+	Updated time.Time `yaml:"updated"`
+	Names   []string  `yaml:"names,omitempty"`
+	Target  string    `yaml:"target,omitempty"`
+}
+
+// nameCache is what's cached about a remote: the names of its collections
+// (containers, images/aliases...) and the targets of its aliases.
+type nameCache struct {
+	Collections map[string]nameCacheEntry `yaml:"collections"`
+	Aliases     map[string]nameCacheEntry `yaml:"aliases"`
+}
+
+func nameCachePath(remote string) string {
+	return path.Join(ConfigPath("cache"), fmt.Sprintf("%s.yml", remote))
+}
+
+// loadNameCache reads the cache of a remote, a missing or broken one being
+// empty.
+func loadNameCache(remote string) *nameCache {
+	cache := nameCache{}
+
+	data, err := ioutil.ReadFile(nameCachePath(remote))
+	if err == nil {
+		yaml.Unmarshal(data, &cache)
+	}
+
+	if cache.Collections == nil {
+		cache.Collections = map[string]nameCacheEntry{}
+	}
+
+	if cache.Aliases == nil {
+		cache.Aliases = map[string]nameCacheEntry{}
+	}
+
+	return &cache
+}
+
+func (cache *nameCache) save(remote string) error {
+	fname := nameCachePath(remote)
+
+	data, err := yaml.Marshal(cache)
+	if err != nil {
+		return err
+	}
+
+	os.MkdirAll(filepath.Dir(fname), 0700)
+	err = ioutil.WriteFile(fname+".new", data, 0600)
+	if err != nil {
+		return err
+	}
+
+	// Shells may complete concurrently, the file gets replaced at once
+	return shared.FileMove(fname+".new", fname)
+}
+
+func nameCacheFresh(entry nameCacheEntry) bool {
+	return time.Since(entry.Updated) < NameCacheTTL
+}
+
+// CachedNames returns the names of a collection of the remote if they were
+// listed less than NameCacheTTL ago.
+func CachedNames(remote string, collection string) ([]string, bool) {
+	entry, ok := loadNameCache(remote).Collections[collection]
+	if !ok || !nameCacheFresh(entry) {
+		return nil, false
+	}
+
+	return entry.Names, true
+}
+
+// CacheNames stores the names of a collection of the remote.
+func CacheNames(remote string, collection string, names []string) error {
+	cache := loadNameCache(remote)
+	cache.Collections[collection] = nameCacheEntry{Updated: time.Now(), Names: names}
+	return cache.save(remote)
+}
+
+// ClearNameCache drops everything cached about the remote.
+func ClearNameCache(remote string) error {
+	err := os.Remove(nameCachePath(remote))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// ListCachedNames is ListNames going through the cache of the remote.
+func (c *Client) ListCachedNames(collection string) ([]string, error) {
+	names, ok := CachedNames(c.Name, collection)
+	if ok {
+		return names, nil
+	}
+
+	names, err := c.ListNames(collection)
+	if err != nil {
+		return nil, err
+	}
+
+	CacheNames(c.Name, collection, names)
+	return names, nil
+}
+
+// RefreshNameCache lists again the collections the shell completion uses.
+func (c *Client) RefreshNameCache() error {
+	err := ClearNameCache(c.Name)
+	if err != nil {
+		return err
+	}
+
+	for _, collection := range []string{"containers", "images", "images/aliases", "profiles"} {
+		_, err := c.ListCachedNames(collection)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (c *Client) cachedAlias(alias string) (string, bool) {
+	if c.Name == "" {
+		return "", false
+	}
+
+	entry, ok := loadNameCache(c.Name).Aliases[alias]
+	if !ok || !nameCacheFresh(entry) {
+		return "", false
+	}
+
+	return entry.Target, true
+}
+
+func (c *Client) cacheAlias(alias string, target string) {
+	if c.Name == "" {
+		return
+	}
+
+	cache := loadNameCache(c.Name)
+	cache.Aliases[alias] = nameCacheEntry{Updated: time.Now(), Target: target}
+	cache.save(c.Name)
+}
@@ -1,12 +1,15 @@
 package lxd
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"crypto/x509"
 	"encoding/base64"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -18,8 +21,12 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/websocket"
 
@@ -41,12 +48,147 @@ type Client struct {
 	keyf            string
 	websocketDialer websocket.Dialer
 
+	// Transport without response header timeout, for the requests the
+	// daemon only answers once done waiting
+	waitTransport http.RoundTripper
+
 	scert *x509.Certificate // the cert stored on disk
 
 	scertWire          *x509.Certificate // the cert from the tls connection
 	scertIntermediates *x509.CertPool
 	scertDigest        [sha256.Size]byte // fingerprint of server cert from connection
 	scertDigestSet     bool              // whether we've stored the fingerprint
+
+	// GET responses which came with an ETag, shared with the copies made
+	// by WithContext
+	cache *responseCache
+
+	ctx context.Context
+
+	options ClientOptions
+}
+
+// ClientOptions tunes the network behaviour of a Client.
+type ClientOptions struct {
+	// Timeout for connecting to the daemon, 0 meaning no timeout
+	DialTimeout time.Duration
+
+	// Timeout for connecting, when DialTimeout isn't set, and then for
+	// getting the headers of the response, 0 meaning no timeout. Reading
+	// the body, like a file or image download, isn't limited, nor are
+	// the requests waiting on an operation or a container state.
+	RequestTimeout time.Duration
+
+	// Use HTTP/2 when the remote supports it
+	HTTP2 bool
+
+	// Number of times the idempotent requests (GET, HEAD and DELETE) are
+	// retried after a transient failure: a connection reset, a timeout or
+	// a 502 or 503 answer. 0 means no retry. PUT isn't retried: a state
+	// change like a restart would happen twice, and a config update sent
+	// with its ETag would fail once applied.
+	Retries int
+
+	// Delay before the first retry, doubled before each of the next ones,
+	// 0 meaning clientRetryBackoff
+	RetryBackoff time.Duration
+}
+
+// Default delay before retrying a request
+const clientRetryBackoff = 500 * time.Millisecond
+
+// Number of idle connections kept open to each remote
+const clientMaxIdleConns = 16
+
+// HTTP transports are shared between all the clients talking to the same
+// remote with the same options, so that connections get reused instead of
+// paying for a new TLS handshake on every request.
+var clientTransportsLock sync.Mutex
+var clientTransports = map[string]*http.Transport{}
+
+func clientTransport(r RemoteConfig, options ClientOptions, create func() *http.Transport) *http.Transport {
+	key := fmt.Sprintf("%s|%s|%s|%s|%s|%v", r.Addr, r.Via, r.Proxy, options.DialTimeout, options.RequestTimeout, options.HTTP2)
+
+	clientTransportsLock.Lock()
+	defer clientTransportsLock.Unlock()
+
+	tr, ok := clientTransports[key]
+	if ok {
+		return tr
+	}
+
+	tr = create()
+	tr.MaxIdleConnsPerHost = clientMaxIdleConns
+	tr.IdleConnTimeout = 90 * time.Second
+	tr.ResponseHeaderTimeout = options.RequestTimeout
+	tr.ForceAttemptHTTP2 = options.HTTP2
+	clientTransports[key] = tr
+
+	return tr
+}
+
+// Number of GET responses a client keeps around for their ETag
+const clientCacheEntries = 256
+
+type responseCache struct {
+	lock    sync.Mutex
+	entries map[string]cachedResponse
+}
+
+type cachedResponse struct {
+	etag     string
+	response *Response
+	added    time.Time
+}
+
+// get returns a copy of the cached response to a GET, which the caller is
+// free to modify.
+func (rc *responseCache) get(uri string) (cachedResponse, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	cached, ok := rc.entries[uri]
+	if ok {
+		cached.response = cached.response.copy()
+	}
+
+	return cached, ok
+}
+
+// set caches a copy of the response to a GET, making room for it by
+// dropping the oldest entry when the cache is full.
+func (rc *responseCache) set(uri string, etag string, response *Response) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	if etag == "" {
+		delete(rc.entries, uri)
+		return
+	}
+
+	_, ok := rc.entries[uri]
+	if !ok && len(rc.entries) >= clientCacheEntries {
+		oldest := ""
+		for k, v := range rc.entries {
+			if oldest == "" || v.added.Before(rc.entries[oldest].added) {
+				oldest = k
+			}
+		}
+		delete(rc.entries, oldest)
+	}
+
+	rc.entries[uri] = cachedResponse{etag: etag, response: response.copy(), added: time.Now()}
+}
+
+// take removes the cached response to a GET, returning it.
+func (rc *responseCache) take(uri string) (cachedResponse, bool) {
+	rc.lock.Lock()
+	defer rc.lock.Unlock()
+
+	cached, ok := rc.entries[uri]
+	delete(rc.entries, uri)
+
+	return cached, ok
 }
 
 type ResponseType string
@@ -62,9 +204,10 @@ var (
 	// to these errors internally so that user code can compare against
 	// them. We probably shouldn't hoist BadRequest or InternalError, since
 	// LXD passes an error string along which is more informative than
-	// whatever static error message we would put here.
+	// whatever static error message we would put here. All the other
+	// errors are returned as *ServerError, see IsNotFound and friends.
 	LXDErrors = map[int]error{
-		http.StatusNotFound: fmt.Errorf("not found"),
+		http.StatusNotFound: &ServerError{StatusCode: http.StatusNotFound, Message: "not found"},
 	}
 )
 
@@ -84,6 +227,20 @@ type Response struct {
 
 	/* Valid for Sync and Error responses */
 	Metadata json.RawMessage `json:"metadata"`
+
+	/* Valid for the pages of listings */
+	Total *int `json:"total"`
+}
+
+func (r *Response) copy() *Response {
+	dup := *r
+	dup.Metadata = append(json.RawMessage(nil), r.Metadata...)
+	if r.Total != nil {
+		total := *r.Total
+		dup.Total = &total
+	}
+
+	return &dup
 }
 
 func (r *Response) MetadataAsMap() (*shared.Jmap, error) {
@@ -140,7 +297,7 @@ func HoistResponse(r *http.Response, rtype ResponseType) (*Response, error) {
 		// Try and use a known error if we have one for this code.
 		err, ok := LXDErrors[resp.Code]
 		if !ok {
-			return nil, fmt.Errorf(resp.Error)
+			return nil, &ServerError{StatusCode: resp.Code, Message: resp.Error}
 		}
 		return nil, err
 	}
@@ -174,11 +331,40 @@ func (c *Client) loadServerCert() {
 	c.scert = cert
 }
 
+// LocalSocketPath returns the path of the unix socket the local remote talks
+// to. $LXD_SOCKET takes precedence, then the socket in $LXD_DIR and finally the
+// one in the default server directory.
+func LocalSocketPath() string {
+	path := os.Getenv("LXD_SOCKET")
+	if path != "" {
+		return path
+	}
+
+	dir := os.Getenv("LXD_DIR")
+	if dir != "" {
+		return filepath.Join(dir, "unix.socket")
+	}
+
+	return shared.VarPath("unix.socket")
+}
+
 // NewClient returns a new LXD client.
 func NewClient(config *Config, remote string) (*Client, error) {
+	return NewClientWithOptions(config, remote, ClientOptions{})
+}
+
+// NewClientWithOptions returns a new LXD client using the given options.
+func NewClientWithOptions(config *Config, remote string, options ClientOptions) (*Client, error) {
+	// Connecting is part of the request
+	if options.DialTimeout == 0 {
+		options.DialTimeout = options.RequestTimeout
+	}
+
 	c := Client{
 		Config: *config,
-		Http:   http.Client{},
+		cache:  &responseCache{entries: map[string]cachedResponse{}},
+
+		options: options,
 	}
 
 	c.Name = remote
@@ -188,9 +374,21 @@ func NewClient(config *Config, remote string) (*Client, error) {
 	}
 
 	if r, ok := config.Remotes[remote]; ok {
+		if r.Protocol != "" && !shared.StringInSlice(r.Protocol, remoteProtocols) {
+			return nil, fmt.Errorf(i18n.G("Unsupported protocol for remote %s: %s"), remote, r.Protocol)
+		}
+
+		if r.AuthType != "" && !shared.StringInSlice(r.AuthType, remoteAuthTypes) {
+			return nil, fmt.Errorf(i18n.G("Unsupported auth type for remote %s: %s"), remote, r.AuthType)
+		}
+
 		if r.Addr[0:5] == "unix:" {
+			if r.Via != "" {
+				return nil, fmt.Errorf(i18n.G("Remote %s is a unix socket, it can't be reached through %s"), remote, r.Via)
+			}
+
 			if r.Addr == "unix://" {
-				r.Addr = fmt.Sprintf("unix:%s", shared.VarPath("unix.socket"))
+				r.Addr = fmt.Sprintf("unix:%s", LocalSocketPath())
 			}
 
 			c.BaseURL = "http://unix.socket"
@@ -207,10 +405,13 @@ func NewClient(config *Config, remote string) (*Client, error) {
 				if err != nil {
 					return nil, err
 				}
-				return net.DialUnix("unix", nil, raddr)
+				return net.DialTimeout("unix", raddr.String(), options.DialTimeout)
 			}
-			c.Http.Transport = &http.Transport{Dial: uDial}
+			c.setTransport(r, func() *http.Transport {
+				return &http.Transport{Dial: uDial}
+			})
 			c.websocketDialer.NetDial = uDial
+			c.websocketDialer.HandshakeTimeout = options.DialTimeout
 			c.Remote = &r
 		} else {
 			certf, keyf, err := readMyCert()
@@ -223,15 +424,47 @@ func NewClient(config *Config, remote string) (*Client, error) {
 				return nil, err
 			}
 
-			tr := &http.Transport{
-				TLSClientConfig: tlsconfig,
-				Dial:            shared.RFC3493Dialer,
-				Proxy:           http.ProxyFromEnvironment,
+			// A pinned certificate is checked on every handshake, before
+			// anything gets sent to the server
+			if r.CertFingerprint != "" {
+				fingerprint := r.CertFingerprint
+				tlsconfig.VerifyPeerCertificate = func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error {
+					if len(rawCerts) == 0 || fmt.Sprintf("%x", sha256.Sum256(rawCerts[0])) != fingerprint {
+						return fmt.Errorf(i18n.G("Server certificate for remote %s doesn't match the pinned fingerprint %s"), remote, fingerprint)
+					}
+
+					return nil
+				}
+			}
+
+			// Proxies are dealt with by the dialer, which the
+			// websockets use too
+			dialer := shared.RFC3493DialerTimeout(options.DialTimeout)
+			if r.Via != "" {
+				err := config.CheckVia(remote)
+				if err != nil {
+					return nil, err
+				}
+
+				dialer = viaDialer(config, r.Via, options)
+			} else {
+				dialer = proxyDialer(r.Proxy, dialer)
 			}
 
+			c.setTransport(r, func() *http.Transport {
+				// Websockets need HTTP/1.1, so the transport gets its
+				// own copy of the TLS config to negotiate HTTP/2 with.
+				return &http.Transport{
+					TLSClientConfig:     tlsconfig.Clone(),
+					Dial:                dialer,
+					TLSHandshakeTimeout: options.DialTimeout,
+				}
+			})
+
 			c.websocketDialer = websocket.Dialer{
-				NetDial:         shared.RFC3493Dialer,
-				TLSClientConfig: tlsconfig,
+				NetDial:          dialer,
+				TLSClientConfig:  tlsconfig,
+				HandshakeTimeout: options.DialTimeout,
 			}
 
 			c.certf = certf
@@ -245,7 +478,6 @@ func NewClient(config *Config, remote string) (*Client, error) {
 				c.BaseWSURL = "wss://" + r.Addr
 			}
 			c.Transport = "https"
-			c.Http.Transport = tr
 			c.loadServerCert()
 			c.Remote = &r
 		}
@@ -260,6 +492,160 @@ func NewClient(config *Config, remote string) (*Client, error) {
 	return &c, nil
 }
 
+// setTransport sets up the transports of the client to the remote, the
+// requests waiting on the daemon going through one without response header
+// timeout.
+func (c *Client) setTransport(r RemoteConfig, create func() *http.Transport) {
+	c.Http.Transport = clientTransport(r, c.options, create)
+
+	if c.options.RequestTimeout != 0 {
+		options := c.options
+		options.RequestTimeout = 0
+		c.waitTransport = clientTransport(r, options, create)
+	}
+}
+
+// waiting returns whether the daemon only answers the request once done
+// waiting on something, an operation or a container state.
+func waiting(req *http.Request) bool {
+	return strings.HasSuffix(req.URL.Path, "/wait") || req.URL.Query().Get("wait") != ""
+}
+
+// WithContext returns a copy of the client whose HTTP requests are bound to
+// ctx, cancelling it aborts any pending request. Websockets, once
+// established, aren't affected.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	client := *c
+	client.ctx = ctx
+	return &client
+}
+
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.ctx != nil {
+		req = req.WithContext(c.ctx)
+	}
+
+	// Whatever gets changed, the names cached for the remote may be stale
+	if req.Method != "GET" && c.Name != "" {
+		ClearNameCache(c.Name)
+	}
+
+	// Let the daemon compress large answers
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", strings.Join(shared.ContentEncodings(), ", "))
+	}
+
+	resp, err := c.doRetry(req)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := resp.Header.Get("Content-Encoding")
+	if encoding != "" {
+		body, err := shared.NewContentDecoder(resp.Body, encoding)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+
+		resp.Body = body
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+
+		// The daemon tells how long the content really is
+		length, err := strconv.ParseInt(resp.Header.Get(shared.ContentEncodingLengthHeader), 10, 64)
+		if err == nil && length >= 0 {
+			resp.ContentLength = length
+			resp.Header.Set("Content-Length", strconv.FormatInt(length, 10))
+		}
+	}
+
+	return resp, nil
+}
+
+// doRetry sends the request, retrying it after transient failures with an
+// exponential backoff as much as the options of the client allow.
+func (c *Client) doRetry(req *http.Request) (*http.Response, error) {
+	backoff := c.options.RetryBackoff
+	if backoff == 0 {
+		backoff = clientRetryBackoff
+	}
+
+	client := &c.Http
+	if c.waitTransport != nil && waiting(req) {
+		waitClient := c.Http
+		waitClient.Transport = c.waitTransport
+		client = &waitClient
+	}
+
+	for attempt := 0; ; attempt++ {
+		resp, err := client.Do(req)
+		if attempt >= c.options.Retries || !c.retryable(req, resp, err) {
+			return resp, err
+		}
+
+		// The body was consumed, it needs to be sent again
+		if req.Body != nil {
+			if req.GetBody == nil {
+				return resp, err
+			}
+
+			body, err2 := req.GetBody()
+			if err2 != nil {
+				return resp, err
+			}
+			req.Body = body
+		}
+
+		if resp != nil {
+			shared.Debugf("Retrying %s %s in %s: %s", req.Method, req.URL, backoff, resp.Status)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			shared.Debugf("Retrying %s %s in %s: %s", req.Method, req.URL, backoff, err)
+		}
+
+		if c.ctx != nil {
+			select {
+			case <-time.After(backoff):
+			case <-c.ctx.Done():
+				return nil, c.ctx.Err()
+			}
+		} else {
+			time.Sleep(backoff)
+		}
+
+		backoff *= 2
+	}
+}
+
+// retryable returns whether a request which failed may be sent again: it
+// must be idempotent and the failure transient.
+func (c *Client) retryable(req *http.Request, resp *http.Response, err error) bool {
+	if !shared.StringInSlice(req.Method, []string{"GET", "HEAD", "DELETE"}) {
+		return false
+	}
+
+	if err == nil {
+		return resp.StatusCode == http.StatusBadGateway || resp.StatusCode == http.StatusServiceUnavailable
+	}
+
+	// Cancelled on purpose
+	if c.ctx != nil && c.ctx.Err() != nil {
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF) {
+		return true
+	}
+
+	// TLS handshake and dial timeouts included
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
 func (c *Client) Addresses() ([]string, error) {
 	addresses := make([]string, 0)
 
@@ -296,7 +682,12 @@ func (c *Client) baseGet(getUrl string) (*Response, error) {
 
 	req.Header.Set("User-Agent", shared.UserAgent)
 
-	resp, err := c.Http.Do(req)
+	cached, ok := c.cache.get(getUrl)
+	if ok {
+		req.Header.Set("If-None-Match", cached.etag)
+	}
+
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -318,7 +709,20 @@ func (c *Client) baseGet(getUrl string) (*Response, error) {
 		c.scertDigestSet = true
 	}
 
-	return HoistResponse(resp, Sync)
+	// We already have the current version
+	if ok && resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return cached.response, nil
+	}
+
+	result, err := HoistResponse(resp, Sync)
+	if err != nil {
+		return nil, err
+	}
+
+	c.cache.set(getUrl, resp.Header.Get("ETag"), result)
+
+	return result, nil
 }
 
 func (c *Client) put(base string, args shared.Jmap, rtype ResponseType) (*Response, error) {
@@ -339,7 +743,14 @@ func (c *Client) put(base string, args shared.Jmap, rtype ResponseType) (*Respon
 	req.Header.Set("User-Agent", shared.UserAgent)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.Http.Do(req)
+	// Only apply the change if nobody else modified the object since we
+	// last looked at it.
+	cached, ok := c.cache.take(uri)
+	if ok {
+		req.Header.Set("If-Match", cached.etag)
+	}
+
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -365,7 +776,7 @@ func (c *Client) post(base string, args shared.Jmap, rtype ResponseType) (*Respo
 	req.Header.Set("User-Agent", shared.UserAgent)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.Http.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +791,7 @@ func (c *Client) getRaw(uri string) (*http.Response, error) {
 	}
 	req.Header.Set("User-Agent", shared.UserAgent)
 
-	raw, err := c.Http.Do(req)
+	raw, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -415,7 +826,7 @@ func (c *Client) delete(base string, args shared.Jmap, rtype ResponseType) (*Res
 	req.Header.Set("User-Agent", shared.UserAgent)
 	req.Header.Set("Content-Type", "application/json")
 
-	resp, err := c.Http.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -503,22 +914,62 @@ func (c *Client) IsPublic() bool {
 	return public
 }
 
+// listPageSize is the number of objects listings are got by.
+const listPageSize = 500
+
+// getPages gets all the pages of a listing and returns them as one. Servers
+// without pagination send everything in the first one.
+func (c *Client) getPages(base string) (json.RawMessage, error) {
+	all := []json.RawMessage{}
+	for {
+		resp, err := c.get(fmt.Sprintf("%s&limit=%d&offset=%d", base, listPageSize, len(all)))
+		if err != nil {
+			return nil, err
+		}
+
+		page := []json.RawMessage{}
+		if err := json.Unmarshal(resp.Metadata, &page); err != nil {
+			return nil, err
+		}
+
+		all = append(all, page...)
+		if resp.Total == nil || len(page) == 0 || len(all) >= *resp.Total {
+			break
+		}
+	}
+
+	return json.Marshal(all)
+}
+
+// ListContainers returns all the containers along with their state and
+// snapshots, in pages of listPageSize containers.
 func (c *Client) ListContainers() ([]shared.ContainerInfo, error) {
-	resp, err := c.get("containers?recursion=1")
+	metadata, err := c.getPages("containers?recursion=2")
 	if err != nil {
 		return nil, err
 	}
 
 	var result []shared.ContainerInfo
 
-	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
-		return nil, err
+	if err := json.Unmarshal(metadata, &result); err != nil {
+		// Older servers only return URLs for recursion=2
+		metadata, err = c.getPages("containers?recursion=1")
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(metadata, &result); err != nil {
+			return nil, err
+		}
 	}
 
 	return result, nil
 }
 
-func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliases []string, public bool) error {
+// CopyImage copies an image to dest, which pulls it from c in pull mode and
+// gets it relayed by the client in relay mode, when it can't reach c. The
+// transfer is limited to bwlimit bytes per second when it's set.
+func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliases []string, public bool, mode string, bwlimit string) error {
 	fingerprint := c.GetAlias(image)
 	if fingerprint == "" {
 		fingerprint = image
@@ -529,12 +980,25 @@ func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliase
 		return err
 	}
 
+	if mode == "relay" {
+		err = c.relayImage(fingerprint, dest, public, bwlimit)
+		if err != nil {
+			return err
+		}
+
+		return dest.copyImageAliases(info, copy_aliases, aliases)
+	}
+
 	source := shared.Jmap{
 		"type":        "image",
 		"mode":        "pull",
 		"server":      c.BaseURL,
 		"fingerprint": fingerprint}
 
+	if bwlimit != "" {
+		source["bandwidth-limit"] = bwlimit
+	}
+
 	// FIXME: InterfaceToBool is there for backward compatibility
 	if !shared.InterfaceToBool(info.Public) {
 		var secret string
@@ -591,11 +1055,17 @@ func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliase
 		return err
 	}
 
+	return dest.copyImageAliases(info, copy_aliases, aliases)
+}
+
+// copyImageAliases adds the aliases of a copied image, the ones it had on
+// the source when copy_aliases is set.
+func (c *Client) copyImageAliases(info *shared.ImageInfo, copy_aliases bool, aliases []string) error {
 	/* copy aliases from source image */
 	if copy_aliases {
 		for _, alias := range info.Aliases {
-			dest.DeleteAlias(alias.Name)
-			err = dest.PostAlias(alias.Name, alias.Description, info.Fingerprint)
+			c.DeleteAlias(alias.Name)
+			err := c.PostAlias(alias.Name, alias.Description, info.Fingerprint)
 			if err != nil {
 				fmt.Printf(i18n.G("Error adding alias %s")+"\n", alias.Name)
 			}
@@ -604,8 +1074,8 @@ func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliase
 
 	/* add new aliases */
 	for _, alias := range aliases {
-		dest.DeleteAlias(alias)
-		err = dest.PostAlias(alias, alias, info.Fingerprint)
+		c.DeleteAlias(alias)
+		err := c.PostAlias(alias, alias, info.Fingerprint)
 		if err != nil {
 			fmt.Printf(i18n.G("Error adding alias %s")+"\n", alias)
 		}
@@ -614,6 +1084,44 @@ func (c *Client) CopyImage(image string, dest *Client, copy_aliases bool, aliase
 	return nil
 }
 
+// relayImage streams the export of an image on c to an upload to dest.
+func (c *Client) relayImage(fingerprint string, dest *Client, public bool, bwlimit string) error {
+	limit, err := shared.ParseBandwidthLimit(bwlimit)
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.getRaw(c.url(shared.APIVersion, "images", fingerprint, "export"))
+	if err != nil {
+		return err
+	}
+	defer raw.Body.Close()
+
+	req, err := imageStreamRequest(dest.url(shared.APIVersion, "images"), shared.BandwidthReader(raw.Body, limit))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", shared.UserAgent)
+
+	if public {
+		req.Header.Set("X-LXD-public", "1")
+	} else {
+		req.Header.Set("X-LXD-public", "0")
+	}
+
+	uploaded, err := dest.do(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := HoistResponse(uploaded, Async)
+	if err != nil {
+		return err
+	}
+
+	return dest.WaitForSuccess(resp.Operation)
+}
+
 func (c *Client) ExportImage(image string, target string) (*Response, string, error) {
 	uri := c.url(shared.APIVersion, "images", image, "export")
 	raw, err := c.getRaw(uri)
@@ -628,6 +1136,17 @@ func (c *Client) ExportImage(image string, target string) (*Response, string, er
 
 	// Deal with split images
 	if ctype == "multipart/form-data" {
+		// Stream them as is, the importer finds the boundary at the
+		// start of the stream
+		if target == "-" {
+			_, err = io.Copy(os.Stdout, raw.Body)
+			if err != nil {
+				return nil, "", err
+			}
+
+			return nil, "stdout", nil
+		}
+
 		if !shared.IsDir(target) {
 			return nil, "", fmt.Errorf(i18n.G("Split images can only be written to a directory."))
 		}
@@ -645,7 +1164,7 @@ func (c *Client) ExportImage(image string, target string) (*Response, string, er
 			return nil, "", fmt.Errorf("Invalid multipart image")
 		}
 
-		imageTarf, err := os.OpenFile(part.FileName(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		imageTarf, err := os.OpenFile(filepath.Join(target, filepath.Base(part.FileName())), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			return nil, "", err
 		}
@@ -667,7 +1186,7 @@ func (c *Client) ExportImage(image string, target string) (*Response, string, er
 			return nil, "", fmt.Errorf("Invalid multipart image")
 		}
 
-		rootfsTarf, err := os.OpenFile(part.FileName(), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		rootfsTarf, err := os.OpenFile(filepath.Join(target, filepath.Base(part.FileName())), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 		if err != nil {
 			return nil, "", err
 		}
@@ -790,13 +1309,20 @@ func (c *Client) PostImage(imageFile string, rootfsFile string, properties []str
 	var fRootfs *os.File
 	var req *http.Request
 
-	fImage, err = os.Open(imageFile)
-	if err != nil {
-		return "", err
+	if imageFile == "-" {
+		fImage = os.Stdin
+	} else {
+		fImage, err = os.Open(imageFile)
+		if err != nil {
+			return "", err
+		}
+		defer fImage.Close()
 	}
-	defer fImage.Close()
 
-	if rootfsFile != "" {
+	if imageFile == "-" {
+		// A tarball or the stream of a split image exported to stdout
+		req, err = imageStreamRequest(uri, fImage)
+	} else if rootfsFile != "" {
 		fRootfs, err = os.Open(rootfsFile)
 		if err != nil {
 			return "", err
@@ -867,7 +1393,7 @@ func (c *Client) PostImage(imageFile string, rootfsFile string, properties []str
 		req.Header.Set("X-LXD-properties", imgProps.Encode())
 	}
 
-	raw, err := c.Http.Do(req)
+	raw, err := c.do(req)
 	if err != nil {
 		return "", err
 	}
@@ -899,6 +1425,35 @@ func (c *Client) PostImage(imageFile string, rootfsFile string, properties []str
 	return fingerprint, nil
 }
 
+// imageStreamRequest builds the upload of an image read from stream, split
+// images being recognized by the boundary their multipart stream starts with.
+func imageStreamRequest(uri string, stream io.Reader) (*http.Request, error) {
+	rd := bufio.NewReader(stream)
+
+	// A boundary is at most 70 characters, after "--" and before "\r\n"
+	head, _ := rd.Peek(74)
+
+	req, err := http.NewRequest("POST", uri, rd)
+	if err != nil {
+		return nil, err
+	}
+
+	if !bytes.HasPrefix(head, []byte("--")) {
+		req.Header.Set("Content-Type", "application/octet-stream")
+		return req, nil
+	}
+
+	end := bytes.Index(head, []byte("\r\n"))
+	if end < 0 {
+		return nil, fmt.Errorf(i18n.G("Invalid multipart image"))
+	}
+
+	boundary := map[string]string{"boundary": string(head[2:end])}
+	req.Header.Set("Content-Type", mime.FormatMediaType("multipart/form-data", boundary))
+
+	return req, nil
+}
+
 func (c *Client) GetImageInfo(image string) (*shared.ImageInfo, error) {
 	resp, err := c.get(fmt.Sprintf("images/%s", image))
 	if err != nil {
@@ -923,13 +1478,13 @@ func (c *Client) PutImageInfo(name string, p shared.BriefImageInfo) error {
 }
 
 func (c *Client) ListImages() ([]shared.ImageInfo, error) {
-	resp, err := c.get("images?recursion=1")
+	metadata, err := c.getPages("images?recursion=1")
 	if err != nil {
 		return nil, err
 	}
 
 	var result []shared.ImageInfo
-	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+	if err := json.Unmarshal(metadata, &result); err != nil {
 		return nil, err
 	}
 
@@ -977,6 +1532,11 @@ func (c *Client) UserAuthServerCert(name string, acceptCert bool) error {
 		return nil
 	}
 
+	// A pinned fingerprint is checked on every handshake
+	if c.Remote != nil && c.Remote.CertFingerprint != "" {
+		acceptCert = true
+	}
+
 	_, err := c.scertWire.Verify(x509.VerifyOptions{
 		DNSName:       name,
 		Intermediates: c.scertIntermediates,
@@ -1034,9 +1594,9 @@ func (c *Client) AddMyCertToServer(pwd string) error {
 	return err
 }
 
-func (c *Client) CertificateAdd(cert *x509.Certificate, name string) error {
+func (c *Client) CertificateAdd(cert *x509.Certificate, name string, readOnly bool) error {
 	b64 := base64.StdEncoding.EncodeToString(cert.Raw)
-	_, err := c.post("certificates", shared.Jmap{"type": "client", "certificate": b64, "name": name}, Sync)
+	_, err := c.post("certificates", shared.Jmap{"type": "client", "certificate": b64, "name": name, "read_only": readOnly}, Sync)
 	return err
 }
 
@@ -1048,7 +1608,7 @@ func (c *Client) CertificateRemove(fingerprint string) error {
 func (c *Client) IsAlias(alias string) (bool, error) {
 	_, err := c.get(fmt.Sprintf("images/aliases/%s", alias))
 	if err != nil {
-		if err == LXDErrors[http.StatusNotFound] {
+		if IsNotFound(err) {
 			return false, nil
 		}
 		return false, err
@@ -1057,6 +1617,23 @@ func (c *Client) IsAlias(alias string) (bool, error) {
 	return true, nil
 }
 
+// AliasInfo returns the target and description of an image alias.
+func (c *Client) AliasInfo(alias string) (*shared.ImageAlias, error) {
+	resp, err := c.get(fmt.Sprintf("images/aliases/%s", alias))
+	if err != nil {
+		return nil, err
+	}
+
+	result := shared.ImageAlias{}
+	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	return &result, nil
+}
+
+// GetAlias returns the fingerprint an alias points to, empty when there's no
+// such alias. The server is always asked, the target being acted upon.
 func (c *Client) GetAlias(alias string) string {
 	resp, err := c.get(fmt.Sprintf("images/aliases/%s", alias))
 	if err != nil {
@@ -1071,25 +1648,41 @@ func (c *Client) GetAlias(alias string) string {
 	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
 		return ""
 	}
+
+	c.cacheAlias(alias, result.Name)
 	return result.Name
 }
 
-// Init creates a container from either a fingerprint or an alias; you must
+// GetCachedAlias is GetAlias reusing a target looked up less than
+// NameCacheTTL ago, which may be stale. It's only meant for displaying
+// images, not for changing them or creating containers from them.
+func (c *Client) GetCachedAlias(alias string) string {
+	target, ok := c.cachedAlias(alias)
+	if ok {
+		return target
+	}
+
+	return c.GetAlias(alias)
+}
+
+// Init creates a container from either a fingerprint or an alias; you must
 // provide at least one.
-func (c *Client) Init(name string, imgremote string, image string, profiles *[]string, config map[string]string, ephem bool) (*Response, error) {
+// imageSource returns the source of a container creation from an image of
+// imgremote, along with a client of imgremote when it isn't this server.
+func (c *Client) imageSource(imgremote string, image string) (shared.Jmap, *Client, error) {
 	var tmpremote *Client
 	var err error
 
 	serverStatus, err := c.ServerStatus()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	architectures := serverStatus.Environment.Architectures
 
 	source := shared.Jmap{"type": "image"}
 
 	if image == "" {
-		return nil, fmt.Errorf(i18n.G("You must provide an image hash or alias name."))
+		return nil, nil, fmt.Errorf(i18n.G("You must provide an image hash or alias name."))
 	}
 
 	if imgremote != c.Name {
@@ -1097,7 +1690,7 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 		source["mode"] = "pull"
 		tmpremote, err = NewClient(&c.Config, imgremote)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		fingerprint := tmpremote.GetAlias(image)
@@ -1107,11 +1700,11 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 
 		imageinfo, err := tmpremote.GetImageInfo(fingerprint)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if len(architectures) != 0 && !shared.IntInSlice(imageinfo.Architecture, architectures) {
-			return nil, fmt.Errorf(i18n.G("The image architecture is incompatible with the target server"))
+			return nil, nil, fmt.Errorf(i18n.G("The image architecture is incompatible with the target server"))
 		}
 
 		// FIXME: InterfaceToBool is there for backward compatibility
@@ -1120,20 +1713,20 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 
 			resp, err := tmpremote.post("images/"+fingerprint+"/secret", nil, Async)
 			if err != nil {
-				return nil, err
+				return nil, nil, err
 			}
 
 			op, err := resp.MetadataAsOperation()
 			if err == nil && op.Metadata != nil {
 				secret, err = op.Metadata.GetString("secret")
 				if err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 			} else {
 				// FIXME: This is a backward compatibility codepath
 				md := secretMd{}
 				if err := json.Unmarshal(resp.Metadata, &md); err != nil {
-					return nil, err
+					return nil, nil, err
 				}
 
 				secret = md.Secret
@@ -1152,15 +1745,24 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 
 		imageinfo, err := c.GetImageInfo(fingerprint)
 		if err != nil {
-			return nil, fmt.Errorf(i18n.G("can't get info for image '%s': %s"), image, err)
+			return nil, nil, fmt.Errorf(i18n.G("can't get info for image '%s': %s"), image, err)
 		}
 
 		if len(architectures) != 0 && !shared.IntInSlice(imageinfo.Architecture, architectures) {
-			return nil, fmt.Errorf(i18n.G("The image architecture is incompatible with the target server"))
+			return nil, nil, fmt.Errorf(i18n.G("The image architecture is incompatible with the target server"))
 		}
 		source["fingerprint"] = fingerprint
 	}
 
+	return source, tmpremote, nil
+}
+
+func (c *Client) Init(name string, imgremote string, image string, profiles *[]string, config map[string]string, ephem bool) (*Response, error) {
+	source, tmpremote, err := c.imageSource(imgremote, image)
+	if err != nil {
+		return nil, err
+	}
+
 	body := shared.Jmap{"source": source}
 
 	if name != "" {
@@ -1179,9 +1781,16 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 		body["ephemeral"] = ephem
 	}
 
+	return c.postImageSource("containers", body, tmpremote)
+}
+
+// postImageSource posts a request with an image source. When the image comes
+// from another server, each of its addresses is tried until one works.
+func (c *Client) postImageSource(base string, body shared.Jmap, tmpremote *Client) (*Response, error) {
 	var resp *Response
+	var err error
 
-	if imgremote != c.Name {
+	if tmpremote != nil {
 		var addresses []string
 		addresses, err = tmpremote.Addresses()
 		if err != nil {
@@ -1191,7 +1800,7 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 		for _, addr := range addresses {
 			body["source"].(shared.Jmap)["server"] = "https://" + addr
 
-			resp, err = c.post("containers", body, Async)
+			resp, err = c.post(base, body, Async)
 			if err != nil {
 				continue
 			}
@@ -1199,11 +1808,11 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 			break
 		}
 	} else {
-		resp, err = c.post("containers", body, Async)
+		resp, err = c.post(base, body, Async)
 	}
 
 	if err != nil {
-		if LXDErrors[http.StatusNotFound] == err {
+		if IsNotFound(err) {
 			return nil, fmt.Errorf("image doesn't exist")
 		}
 		return nil, err
@@ -1212,11 +1821,32 @@ func (c *Client) Init(name string, imgremote string, image string, profiles *[]s
 	return resp, nil
 }
 
-func (c *Client) LocalCopy(source string, name string, config map[string]string, profiles []string, ephemeral bool) (*Response, error) {
+// Rebuild replaces the rootfs of a stopped container with a fresh copy of an
+// image, keeping its config, devices, profiles and snapshots. Without an
+// image, the one the container was created from is used again.
+func (c *Client) Rebuild(name string, imgremote string, image string) (*Response, error) {
+	body := shared.Jmap{}
+	var tmpremote *Client
+	if image != "" {
+		source, remote, err := c.imageSource(imgremote, image)
+		if err != nil {
+			return nil, err
+		}
+
+		body["source"] = source
+		tmpremote = remote
+	}
+
+	return c.postImageSource(fmt.Sprintf("containers/%s/rebuild", name), body, tmpremote)
+}
+
+func (c *Client) LocalCopy(source string, name string, config map[string]string, profiles []string, ephemeral bool, refreshIdentity bool, refresh bool) (*Response, error) {
 	body := shared.Jmap{
 		"source": shared.Jmap{
-			"type":   "copy",
-			"source": source,
+			"type":             "copy",
+			"source":           source,
+			"refresh-identity": refreshIdentity,
+			"refresh":          refresh,
 		},
 		"name":      name,
 		"config":    config,
@@ -1266,6 +1896,164 @@ func (c *Client) Monitor(types []string, handler func(interface{})) error {
 	return nil
 }
 
+// Events subscribes to the given event types (all of them if empty) and
+// returns a channel of decoded events along with a function to stop the
+// subscription. The connection is re-established if it drops, in which case
+// the operations which changed in the meantime are sent as operation events.
+func (c *Client) Events(types []string) (<-chan shared.Event, func(), error) {
+	url := c.BaseWSURL + path.Join("/", "1.0", "events")
+	if len(types) != 0 {
+		url += "?type=" + strings.Join(types, ",")
+	}
+
+	conn, err := WebsocketDial(c.websocketDialer, url)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	events := make(chan shared.Event, 64)
+	stop := make(chan bool)
+
+	var lock sync.Mutex
+	var stopOnce sync.Once
+	stopFunc := func() {
+		stopOnce.Do(func() {
+			close(stop)
+
+			lock.Lock()
+			conn.Close()
+			lock.Unlock()
+		})
+	}
+
+	if c.ctx != nil {
+		go func() {
+			select {
+			case <-c.ctx.Done():
+				stopFunc()
+			case <-stop:
+			}
+		}()
+	}
+
+	send := func(event shared.Event) bool {
+		select {
+		case events <- event:
+			return true
+		case <-stop:
+			return false
+		}
+	}
+
+	go func() {
+		defer close(events)
+
+		since := time.Now()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err == nil {
+				event := shared.Event{}
+				if json.Unmarshal(data, &event) != nil {
+					continue
+				}
+
+				if event.Timestamp.After(since) {
+					since = event.Timestamp
+				}
+
+				if !send(event) {
+					return
+				}
+
+				continue
+			}
+
+			// Reconnect, unless we were told to stop
+			conn.Close()
+			delay := time.Second
+			for {
+				select {
+				case <-stop:
+					return
+				case <-time.After(delay):
+				}
+
+				newConn, err := WebsocketDial(c.websocketDialer, url)
+				if err == nil {
+					lock.Lock()
+					conn = newConn
+					lock.Unlock()
+					break
+				}
+
+				shared.Debugf("Failed to reconnect to the event stream: %s", err)
+				delay *= 2
+				if delay > 30*time.Second {
+					delay = 30 * time.Second
+				}
+			}
+
+			// The stop function may have been called while we were
+			// reconnecting.
+			select {
+			case <-stop:
+				conn.Close()
+				return
+			default:
+			}
+
+			if len(types) == 0 || shared.StringInSlice("operation", types) {
+				for _, event := range c.eventsMissedOperations(since) {
+					if !send(event) {
+						return
+					}
+				}
+			}
+		}
+	}()
+
+	return events, stopFunc, nil
+}
+
+// eventsMissedOperations returns operation events for the operations which
+// were updated after the given time.
+func (c *Client) eventsMissedOperations(since time.Time) []shared.Event {
+	resp, err := c.get("operations?recursion=1")
+	if err != nil {
+		return nil
+	}
+
+	ops := map[string][]shared.Operation{}
+	if err := json.Unmarshal(resp.Metadata, &ops); err != nil {
+		return nil
+	}
+
+	events := []shared.Event{}
+	for _, list := range ops {
+		for _, op := range list {
+			if !op.UpdatedAt.After(since) {
+				continue
+			}
+
+			body, err := json.Marshal(op)
+			if err != nil {
+				continue
+			}
+
+			events = append(events, shared.Event{Type: "operation", Timestamp: op.UpdatedAt, Metadata: body})
+		}
+	}
+
+	sort.Sort(eventsByTime(events))
+	return events
+}
+
+type eventsByTime []shared.Event
+
+func (a eventsByTime) Len() int           { return len(a) }
+func (a eventsByTime) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a eventsByTime) Less(i, j int) bool { return a[i].Timestamp.Before(a[j].Timestamp) }
+
 // Exec runs a command inside the LXD container. For "interactive" use such as
 // `lxc exec ...`, one should pass a controlHandler that talks over the control
 // socket and handles things like SIGWINCH. If running non-interactive, passing
@@ -1275,16 +2063,71 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 	stdin io.ReadCloser, stdout io.WriteCloser,
 	stderr io.WriteCloser, controlHandler func(*Client, *websocket.Conn)) (int, error) {
 
+	op, err := c.exec(name, cmd, env, ExecOptions{}, stdin, stdout, stderr, controlHandler)
+	if err != nil {
+		return -1, err
+	}
+
+	return op.Metadata.GetInt("return")
+}
+
+// ExecOptions are the optional parameters of ExecWithOptions.
+type ExecOptions struct {
+	// User and group to run the command as, names or numeric ids, empty
+	// values meaning root
+	User  string
+	Group string
+
+	// Directory to run the command in, HOME by default
+	Cwd string
+
+	// Seconds after which the daemon kills the command, if positive
+	Timeout int
+}
+
+// ExecWithOptions runs a command like Exec, with the extra options. It
+// returns the command's exit code rather than its raw wait status, 128+N if
+// it was killed by signal N.
+func (c *Client) ExecWithOptions(name string, cmd []string, env map[string]string,
+	options ExecOptions, stdin io.ReadCloser, stdout io.WriteCloser,
+	stderr io.WriteCloser, controlHandler func(*Client, *websocket.Conn)) (int, error) {
+
+	op, err := c.exec(name, cmd, env, options, stdin, stdout, stderr, controlHandler)
+	if err != nil {
+		return -1, err
+	}
+
+	// Older daemons only send the raw wait status
+	if _, ok := (*op.Metadata)["exit_code"]; !ok {
+		status, err := op.Metadata.GetInt("return")
+		if err != nil {
+			return -1, err
+		}
+
+		return status >> 8, nil
+	}
+
+	return op.Metadata.GetInt("exit_code")
+}
+
+func (c *Client) exec(name string, cmd []string, env map[string]string,
+	options ExecOptions, stdin io.ReadCloser, stdout io.WriteCloser,
+	stderr io.WriteCloser, controlHandler func(*Client, *websocket.Conn)) (*shared.Operation, error) {
+
 	body := shared.Jmap{
 		"command":            cmd,
 		"wait-for-websocket": true,
 		"interactive":        controlHandler != nil,
 		"environment":        env,
+		"user":               options.User,
+		"group":              options.Group,
+		"cwd":                options.Cwd,
+		"timeout":            options.Timeout,
 	}
 
 	resp, err := c.post(fmt.Sprintf("containers/%s/exec", name), body, Async)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
 	var fds shared.Jmap
@@ -1293,18 +2136,18 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 	if err == nil && op.Metadata != nil {
 		fds, err = op.Metadata.GetMap("fds")
 		if err != nil {
-			return -1, err
+			return nil, err
 		}
 	} else {
 		// FIXME: This is a backward compatibility codepath
 		md := execMd{}
 		if err := json.Unmarshal(resp.Metadata, &md); err != nil {
-			return -1, err
+			return nil, err
 		}
 
 		fds, err = shared.ParseMetadata(md.FDs)
 		if err != nil {
-			return -1, err
+			return nil, err
 		}
 	}
 
@@ -1313,7 +2156,7 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 		if wsControl, ok := fds["control"]; ok {
 			control, err = c.websocket(resp.Operation, wsControl.(string))
 			if err != nil {
-				return -1, err
+				return nil, err
 			}
 			defer control.Close()
 
@@ -1322,7 +2165,7 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 
 		conn, err := c.websocket(resp.Operation, fds["0"].(string))
 		if err != nil {
-			return -1, err
+			return nil, err
 		}
 
 		shared.WebsocketSendStream(conn, stdin)
@@ -1335,7 +2178,7 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 
 		conns[0], err = c.websocket(resp.Operation, fds[strconv.Itoa(0)].(string))
 		if err != nil {
-			return -1, err
+			return nil, err
 		}
 		defer conns[0].Close()
 
@@ -1345,7 +2188,7 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 		for i := 1; i < 3; i++ {
 			conns[i], err = c.websocket(resp.Operation, fds[strconv.Itoa(i)].(string))
 			if err != nil {
-				return -1, err
+				return nil, err
 			}
 			defer conns[i].Close()
 
@@ -1375,22 +2218,49 @@ func (c *Client) Exec(name string, cmd []string, env map[string]string,
 	// Now, get the operation's status too.
 	op, err = c.WaitFor(resp.Operation)
 	if err != nil {
-		return -1, err
+		return nil, err
 	}
 
 	if op.StatusCode == shared.Failure {
-		return -1, fmt.Errorf(op.Err)
+		return nil, &OperationError{Operation: op}
 	}
 
 	if op.StatusCode != shared.Success {
-		return -1, fmt.Errorf(i18n.G("got bad op status %s"), op.Status)
+		return nil, fmt.Errorf(i18n.G("got bad op status %s"), op.Status)
 	}
 
 	if op.Metadata == nil {
-		return -1, fmt.Errorf(i18n.G("no metadata received"))
+		return nil, fmt.Errorf(i18n.G("no metadata received"))
 	}
 
-	return op.Metadata.GetInt("return")
+	return op, nil
+}
+
+// sftpServerPaths lists the usual locations of the OpenSSH sftp-server.
+var sftpServerPaths = []string{
+	"/usr/lib/openssh/sftp-server",
+	"/usr/libexec/openssh/sftp-server",
+	"/usr/lib/ssh/sftp-server",
+	"/usr/libexec/sftp-server",
+}
+
+// SFTP runs an SFTP server inside the container over the exec websockets,
+// speaking the protocol on stdin and stdout. This is meant to be plugged
+// into a local client such as sshfs running in slave mode. It returns once
+// the server exits, which happens when stdin is closed.
+func (c *Client) SFTP(name string, stdin io.ReadCloser, stdout io.WriteCloser, stderr io.WriteCloser) error {
+	script := fmt.Sprintf(`for p in %s; do [ -x "$p" ] && exec "$p"; done; exit 127`, strings.Join(sftpServerPaths, " "))
+
+	ret, err := c.ExecWithOptions(name, []string{"/bin/sh", "-c", script}, map[string]string{}, ExecOptions{}, stdin, stdout, stderr, nil)
+	if err != nil {
+		return err
+	}
+
+	if ret == 127 {
+		return fmt.Errorf(i18n.G("No sftp-server found in the container"))
+	}
+
+	return nil
 }
 
 func (c *Client) Action(name string, action shared.ContainerAction, timeout int, force bool) (*Response, error) {
@@ -1432,6 +2302,159 @@ func (c *Client) ServerStatus() (*shared.ServerState, error) {
 	return &ss, nil
 }
 
+func (c *Client) ServerResources() (*shared.Resources, error) {
+	res := shared.Resources{}
+
+	resp, err := c.get("resources")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(resp.Metadata, &res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
+
+// StorageInfo returns the space left on the storage of the server.
+func (c *Client) StorageInfo() (*shared.StoragePool, error) {
+	pool := shared.StoragePool{}
+
+	resp, err := c.get("storage")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(resp.Metadata, &pool); err != nil {
+		return nil, err
+	}
+
+	return &pool, nil
+}
+
+// ListOperations returns the operations of the server, oldest first. Given a
+// status or a since, a RFC3339 date or a duration before now like 2h, the
+// matching ones are returned, the done ones from the history too.
+func (c *Client) ListOperations(status string, since string) ([]shared.Operation, error) {
+	query := url.Values{}
+	query.Set("recursion", "1")
+	if status != "" {
+		query.Set("status", status)
+	}
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	resp, err := c.get(fmt.Sprintf("operations?%s", query.Encode()))
+	if err != nil {
+		return nil, err
+	}
+
+	ops := map[string][]shared.Operation{}
+	if err := json.Unmarshal(resp.Metadata, &ops); err != nil {
+		return nil, err
+	}
+
+	result := []shared.Operation{}
+	for _, list := range ops {
+		result = append(result, list...)
+	}
+
+	sort.Sort(operationsByCreation(result))
+	return result, nil
+}
+
+// GetOperation returns the operation with the UUID, running or from the
+// history of the server.
+func (c *Client) GetOperation(id string) (*shared.Operation, error) {
+	resp, err := c.get(fmt.Sprintf("operations/%s", id))
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.MetadataAsOperation()
+}
+
+// CancelOperation cancels the running operation with the UUID.
+func (c *Client) CancelOperation(id string) error {
+	_, err := c.delete(fmt.Sprintf("operations/%s", id), nil, Sync)
+	return err
+}
+
+type operationsByCreation []shared.Operation
+
+func (a operationsByCreation) Len() int           { return len(a) }
+func (a operationsByCreation) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a operationsByCreation) Less(i, j int) bool { return a[i].CreatedAt.Before(a[j].CreatedAt) }
+
+// NetworksInfo returns the network interfaces of the server's host.
+func (c *Client) NetworksInfo() ([]shared.NetworkInfo, error) {
+	resp, err := c.get("networks?recursion=1")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []shared.NetworkInfo
+
+	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NetworkACLs returns the network ACLs of the server.
+func (c *Client) NetworkACLs() ([]shared.NetworkACL, error) {
+	resp, err := c.get("network-acls?recursion=1")
+	if err != nil {
+		return nil, err
+	}
+
+	var result []shared.NetworkACL
+
+	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// NetworkACL returns the network ACL with the name.
+func (c *Client) NetworkACL(name string) (*shared.NetworkACL, error) {
+	resp, err := c.get(fmt.Sprintf("network-acls/%s", name))
+	if err != nil {
+		return nil, err
+	}
+
+	acl := shared.NetworkACL{}
+	if err := json.Unmarshal(resp.Metadata, &acl); err != nil {
+		return nil, err
+	}
+
+	return &acl, nil
+}
+
+func (c *Client) NetworkACLCreate(acl shared.NetworkACL) error {
+	body := shared.Jmap{"name": acl.Name, "description": acl.Description, "rules": acl.Rules, "networks": acl.Networks}
+
+	_, err := c.post("network-acls", body, Sync)
+	return err
+}
+
+// NetworkACLUpdate replaces the description, rules and networks of an ACL.
+func (c *Client) NetworkACLUpdate(acl shared.NetworkACL) error {
+	body := shared.Jmap{"description": acl.Description, "rules": acl.Rules, "networks": acl.Networks}
+
+	_, err := c.put(fmt.Sprintf("network-acls/%s", acl.Name), body, Sync)
+	return err
+}
+
+func (c *Client) NetworkACLDelete(name string) error {
+	_, err := c.delete(fmt.Sprintf("network-acls/%s", name), nil, Sync)
+	return err
+}
+
 func (c *Client) ContainerStatus(name string) (*shared.ContainerState, error) {
 	ct := shared.ContainerState{}
 
@@ -1447,6 +2470,57 @@ func (c *Client) ContainerStatus(name string) (*shared.ContainerState, error) {
 	return &ct, nil
 }
 
+// ContainerStateWait blocks until the container's state meets the wait
+// conditions (see shared.ContainerStatusMatches) or until timeout seconds
+// went by, a negative timeout meaning to wait forever.
+func (c *Client) ContainerStateWait(name string, wait string, timeout int) (*shared.ContainerStatus, error) {
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(time.Duration(timeout) * time.Second)
+	}
+
+	for {
+		// Long-poll in chunks so that dead connections get noticed
+		chunk := 30
+		if timeout >= 0 {
+			chunk = int((deadline.Sub(time.Now()) + time.Second - 1) / time.Second)
+			if chunk > 30 {
+				chunk = 30
+			} else if chunk < 0 {
+				chunk = 0
+			}
+		}
+
+		resp, err := c.get(fmt.Sprintf("containers/%s/state?wait=%s&timeout=%d", name, url.QueryEscape(wait), chunk))
+		if err != nil && !IsTimeout(err) {
+			return nil, err
+		}
+
+		if err == nil {
+			status := shared.ContainerStatus{}
+			if err := json.Unmarshal(resp.Metadata, &status); err != nil {
+				return nil, err
+			}
+
+			// Older daemons ignore the wait and answer right away
+			matches, err := shared.ContainerStatusMatches(&status, wait)
+			if err != nil {
+				return nil, err
+			}
+
+			if matches {
+				return &status, nil
+			}
+
+			time.Sleep(time.Second)
+		}
+
+		if timeout >= 0 && !time.Now().Before(deadline) {
+			return nil, fmt.Errorf(i18n.G("Timed out waiting for container %s to reach: %s"), name, wait)
+		}
+	}
+}
+
 func (c *Client) GetLog(container string, log string) (io.Reader, error) {
 	uri := c.url(shared.APIVersion, "containers", container, "logs", log)
 	resp, err := c.getRaw(uri)
@@ -1472,7 +2546,47 @@ func (c *Client) ProfileConfig(name string) (*shared.ProfileConfig, error) {
 	return &ct, nil
 }
 
-func (c *Client) PushFile(container string, p string, gid int, uid int, mode os.FileMode, buf io.ReadSeeker) error {
+// FileInfo describes a file pulled from a container.
+type FileInfo struct {
+	UID  int
+	GID  int
+	Mode os.FileMode
+
+	// One of "file", "symlink", "char" or "block"
+	Type string
+
+	// Size of the content, -1 if unknown
+	Size int64
+
+	// SHA256 of the content as computed by the daemon, empty if it
+	// didn't send one
+	SHA256 string
+}
+
+// PushFile creates or replaces the file at p inside the container, streaming
+// its content from r.
+func (c *Client) PushFile(container string, p string, r io.Reader, mode os.FileMode, uid int, gid int) error {
+	return c.pushFile(container, p, "file", r, mode, uid, gid, "")
+}
+
+// PushFileChecksum pushes a file like PushFile, the daemon refusing it if
+// the SHA256 of what it received isn't sum, see IsChecksumMismatch.
+func (c *Client) PushFileChecksum(container string, p string, r io.Reader, mode os.FileMode, uid int, gid int, sum string) error {
+	return c.pushFile(container, p, "file", r, mode, uid, gid, sum)
+}
+
+// PushSymlink creates a symlink at p inside the container pointing to target.
+func (c *Client) PushSymlink(container string, p string, target string, uid int, gid int) error {
+	return c.pushFile(container, p, "symlink", strings.NewReader(target), 0777, uid, gid, "")
+}
+
+// PushDevice creates a device node at p inside the container. ftype is
+// either "char" or "block".
+func (c *Client) PushDevice(container string, p string, ftype string, major int, minor int, mode os.FileMode, uid int, gid int) error {
+	return c.pushFile(container, p, ftype, strings.NewReader(fmt.Sprintf("%d:%d", major, minor)), mode, uid, gid, "")
+}
+
+func (c *Client) pushFile(container string, p string, ftype string, buf io.Reader, mode os.FileMode, uid int, gid int, sum string) error {
 	query := url.Values{"path": []string{p}}
 	uri := c.url(shared.APIVersion, "containers", container, "files") + "?" + query.Encode()
 
@@ -1485,8 +2599,12 @@ func (c *Client) PushFile(container string, p string, gid int, uid int, mode os.
 	req.Header.Set("X-LXD-mode", fmt.Sprintf("%04o", mode))
 	req.Header.Set("X-LXD-uid", strconv.FormatUint(uint64(uid), 10))
 	req.Header.Set("X-LXD-gid", strconv.FormatUint(uint64(gid), 10))
+	req.Header.Set("X-LXD-type", ftype)
+	if sum != "" {
+		req.Header.Set("X-LXD-sha256", sum)
+	}
 
-	raw, err := c.Http.Do(req)
+	raw, err := c.do(req)
 	if err != nil {
 		return err
 	}
@@ -1495,18 +2613,30 @@ func (c *Client) PushFile(container string, p string, gid int, uid int, mode os.
 	return err
 }
 
-func (c *Client) PullFile(container string, p string) (int, int, os.FileMode, io.ReadCloser, error) {
+// PullFile fetches a file from the container, the returned reader streams
+// its content and must be closed by the caller. For a "symlink" the reader
+// yields the link target and for a "char" or "block" device the
+// "major:minor" device number.
+func (c *Client) PullFile(container string, p string) (io.ReadCloser, FileInfo, error) {
 	uri := c.url(shared.APIVersion, "containers", container, "files")
 	query := url.Values{"path": []string{p}}
 
 	r, err := c.getRaw(uri + "?" + query.Encode())
 	if err != nil {
-		return 0, 0, 0, nil, err
+		return nil, FileInfo{}, err
 	}
 
-	uid, gid, mode := shared.ParseLXDFileHeaders(r.Header)
+	uid, gid, mode, ftype := shared.ParseLXDFileHeaders(r.Header)
+	info := FileInfo{
+		UID:    uid,
+		GID:    gid,
+		Mode:   mode,
+		Type:   ftype,
+		Size:   r.ContentLength,
+		SHA256: r.Header.Get("X-LXD-sha256"),
+	}
 
-	return uid, gid, mode, r.Body, nil
+	return r.Body, info, nil
 }
 
 func (c *Client) GetMigrationSourceWS(container string) (*Response, error) {
@@ -1524,13 +2654,29 @@ func (c *Client) GetMigrationSourceWS(container string) (*Response, error) {
 	return c.post(url, body, Async)
 }
 
-func (c *Client) MigrateFrom(name string, operation string, secrets map[string]string, architecture int, config map[string]string, devices shared.Devices, profiles []string, baseImage string, ephemeral bool) (*Response, error) {
+func (c *Client) MigrateFrom(name string, operation string, secrets map[string]string, architecture int, config map[string]string, devices shared.Devices, profiles []string, baseImage string, ephemeral bool, refreshIdentity bool, refresh bool, bwlimit string) (*Response, error) {
+	return c.migrateFrom("pull", name, operation, secrets, architecture, config, devices, profiles, baseImage, ephemeral, refreshIdentity, refresh, bwlimit)
+}
+
+// MigrateFromPush creates a container whose migration websockets get
+// connected by the client, which relays those of the source with
+// RelayMigration. The operation is the one of the source.
+func (c *Client) MigrateFromPush(name string, operation string, secrets map[string]string, architecture int, config map[string]string, devices shared.Devices, profiles []string, baseImage string, ephemeral bool, refreshIdentity bool, refresh bool, bwlimit string) (*Response, error) {
+	return c.migrateFrom("push", name, operation, secrets, architecture, config, devices, profiles, baseImage, ephemeral, refreshIdentity, refresh, bwlimit)
+}
+
+func (c *Client) migrateFrom(mode string, name string, operation string, secrets map[string]string, architecture int, config map[string]string, devices shared.Devices, profiles []string, baseImage string, ephemeral bool, refreshIdentity bool, refresh bool, bwlimit string) (*Response, error) {
 	source := shared.Jmap{
-		"type":       "migration",
-		"mode":       "pull",
-		"operation":  operation,
-		"secrets":    secrets,
-		"base-image": baseImage,
+		"type":             "migration",
+		"mode":             mode,
+		"operation":        operation,
+		"secrets":          secrets,
+		"base-image":       baseImage,
+		"refresh-identity": refreshIdentity,
+		"refresh":          refresh,
+	}
+	if bwlimit != "" {
+		source["bandwidth-limit"] = bwlimit
 	}
 	body := shared.Jmap{
 		"architecture": architecture,
@@ -1545,6 +2691,61 @@ func (c *Client) MigrateFrom(name string, operation string, secrets map[string]s
 	return c.post("containers", body, Async)
 }
 
+// RelayMigration connects the websockets of a migration from c to the sink
+// created by MigrateFromPush on dest, relaying them until they're done, at
+// most at bwlimit bytes per second when it's set.
+func (c *Client) RelayMigration(operation string, secrets map[string]string, dest *Client, destOperation string, destSecrets map[string]string, bwlimit string) error {
+	limit, err := shared.ParseBandwidthLimit(bwlimit)
+	if err != nil {
+		return err
+	}
+
+	// What comes from the source is throttled, slowing it down
+	dialer := c.websocketDialer
+	dialer.NetDial = shared.BandwidthDialer(dialer.NetDial, limit)
+
+	conns := []*websocket.Conn{}
+	defer func() {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}()
+
+	pairs := [][2]*websocket.Conn{}
+	for _, name := range []string{"control", "fs", "criu"} {
+		destSecret, ok := destSecrets[name]
+		if !ok {
+			continue
+		}
+
+		query := url.Values{"secret": []string{secrets[name]}}
+		source, err := WebsocketDial(dialer, c.BaseWSURL+path.Join(operation, "websocket")+"?"+query.Encode())
+		if err != nil {
+			return err
+		}
+		conns = append(conns, source)
+
+		sink, err := dest.websocket(destOperation, destSecret)
+		if err != nil {
+			return err
+		}
+		conns = append(conns, sink)
+
+		pairs = append(pairs, [2]*websocket.Conn{source, sink})
+	}
+
+	done := []chan bool{}
+	for _, pair := range pairs {
+		done = append(done, shared.WebsocketProxy(pair[0], pair[1]))
+	}
+
+	for _, ch := range done {
+		<-ch
+	}
+
+	return nil
+}
+
 func (c *Client) Rename(name string, newName string) (*Response, error) {
 	oldNameParts := strings.SplitN(name, "/", 2)
 	newNameParts := strings.SplitN(newName, "/", 2)
@@ -1592,7 +2793,7 @@ func (c *Client) WaitForSuccess(waitURL string) error {
 		return nil
 	}
 
-	return fmt.Errorf(op.Err)
+	return &OperationError{Operation: op}
 }
 
 func (c *Client) RestoreSnapshot(container string, snapshotName string, stateful bool) (*Response, error) {
@@ -1600,11 +2801,41 @@ func (c *Client) RestoreSnapshot(container string, snapshotName string, stateful
 	return c.put(fmt.Sprintf("containers/%s", container), body, Async)
 }
 
-func (c *Client) Snapshot(container string, snapshotName string, stateful bool) (*Response, error) {
+// Snapshot takes a snapshot of the container, which gets deleted at
+// expiresAt unless that's the zero time.
+func (c *Client) Snapshot(container string, snapshotName string, stateful bool, expiresAt time.Time) (*Response, error) {
 	body := shared.Jmap{"name": snapshotName, "stateful": stateful}
+	if !expiresAt.IsZero() {
+		body["expires_at"] = expiresAt.Format(time.RFC3339)
+	}
+
 	return c.post(fmt.Sprintf("containers/%s/snapshots", container), body, Async)
 }
 
+// SetSnapshotExpiry changes when a snapshot gets deleted, the zero time
+// meaning never.
+func (c *Client) SetSnapshotExpiry(container string, snapshotName string, expiresAt time.Time) error {
+	body := shared.Jmap{"expires_at": expiresAt.Format(time.RFC3339)}
+	_, err := c.put(fmt.Sprintf("containers/%s/snapshots/%s", container, snapshotName), body, Sync)
+	return err
+}
+
+// SnapshotInfo returns a snapshot along with the configuration the container
+// had when it was taken.
+func (c *Client) SnapshotInfo(container string, snapshotName string) (*shared.ContainerSnapshot, error) {
+	resp, err := c.get(fmt.Sprintf("containers/%s/snapshots/%s", container, snapshotName))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot := shared.ContainerSnapshot{}
+	if err := json.Unmarshal(resp.Metadata, &snapshot); err != nil {
+		return nil, err
+	}
+
+	return &snapshot, nil
+}
+
 func (c *Client) ListSnapshots(container string) ([]string, error) {
 	qUrl := fmt.Sprintf("containers/%s/snapshots?recursion=1", container)
 	resp, err := c.get(qUrl)
@@ -1631,6 +2862,22 @@ func (c *Client) ListSnapshots(container string) ([]string, error) {
 	return names, nil
 }
 
+// SnapshotsInfo returns the snapshots of the container with their creation
+// date and size.
+func (c *Client) SnapshotsInfo(container string) ([]shared.ContainerSnapshot, error) {
+	resp, err := c.get(fmt.Sprintf("containers/%s/snapshots?recursion=1", container))
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []shared.ContainerSnapshot{}
+	if err := json.Unmarshal(resp.Metadata, &snapshots); err != nil {
+		return nil, err
+	}
+
+	return snapshots, nil
+}
+
 func (c *Client) GetServerConfigString() ([]string, error) {
 	ss, err := c.ServerStatus()
 	var resp []string
@@ -1727,8 +2974,15 @@ func (c *Client) ProfileCreate(p string) error {
 	return err
 }
 
-func (c *Client) ProfileDelete(p string) error {
-	_, err := c.delete(fmt.Sprintf("profiles/%s", p), nil, Sync)
+// ProfileDelete deletes a profile, force allowing it while containers still
+// use it.
+func (c *Client) ProfileDelete(p string, force bool) error {
+	url := fmt.Sprintf("profiles/%s", p)
+	if force {
+		url += "?force=true"
+	}
+
+	_, err := c.delete(url, nil, Sync)
 	return err
 }
 
@@ -1768,6 +3022,23 @@ func (c *Client) PutProfile(name string, profile shared.ProfileConfig) error {
 	return err
 }
 
+// ApplyPreseed applies a declarative description of the server's setup and
+// returns what had to change.
+func (c *Client) ApplyPreseed(preseed shared.Preseed) ([]string, error) {
+	body := shared.Jmap{"config": preseed.Config, "networks": preseed.Networks, "profiles": preseed.Profiles, "aliases": preseed.Aliases}
+	resp, err := c.put("preseed", body, Sync)
+	if err != nil {
+		return nil, err
+	}
+
+	result := shared.PreseedResult{}
+	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	return result.Changes, nil
+}
+
 func (c *Client) ListProfiles() ([]string, error) {
 	resp, err := c.get("profiles")
 	if err != nil {
@@ -1805,6 +3076,60 @@ func (c *Client) ListProfiles() ([]string, error) {
 	return names, nil
 }
 
+// ListNames returns the names of the objects of a collection (containers,
+// images, images/aliases or profiles) without fetching anything else.
+func (c *Client) ListNames(collection string) ([]string, error) {
+	resp, err := c.get(fmt.Sprintf("%s?names-only=true", collection))
+	if err != nil {
+		return nil, err
+	}
+
+	var result []string
+
+	if err := json.Unmarshal(resp.Metadata, &result); err != nil {
+		return nil, err
+	}
+
+	// Older servers ignore names-only and return URLs
+	prefix := fmt.Sprintf("/%s/%s/", shared.APIVersion, collection)
+	for i, entry := range result {
+		result[i] = strings.TrimPrefix(entry, prefix)
+	}
+
+	return result, nil
+}
+
+// MatchContainers returns the names of the containers matching a glob
+// pattern. The server does the matching, the names are matched again in case
+// it's one which ignores the pattern.
+func (c *Client) MatchContainers(pattern string) ([]string, error) {
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf(i18n.G("Invalid name pattern: %s"), pattern)
+	}
+
+	resp, err := c.get(fmt.Sprintf("containers?names-only=true&name=%s", url.QueryEscape(pattern)))
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+
+	if err := json.Unmarshal(resp.Metadata, &names); err != nil {
+		return nil, err
+	}
+
+	result := []string{}
+	prefix := fmt.Sprintf("/%s/containers/", shared.APIVersion)
+	for _, name := range names {
+		name = strings.TrimPrefix(name, prefix)
+		if ok, _ := path.Match(pattern, name); ok {
+			result = append(result, name)
+		}
+	}
+
+	return result, nil
+}
+
 func (c *Client) ApplyProfile(container, profile string) (*Response, error) {
 	st, err := c.ContainerStatus(container)
 	if err != nil {
@@ -1962,7 +3287,7 @@ func (c *Client) AsyncWaitMeta(resp *Response) (*shared.Jmap, error) {
 	}
 
 	if op.StatusCode == shared.Failure {
-		return nil, fmt.Errorf(op.Err)
+		return nil, &OperationError{Operation: op}
 	}
 
 	if op.StatusCode != shared.Success {
@@ -1972,12 +3297,72 @@ func (c *Client) AsyncWaitMeta(resp *Response) (*shared.Jmap, error) {
 	return op.Metadata, nil
 }
 
-func (c *Client) ImageFromContainer(cname string, public bool, aliases []string, properties map[string]string) (string, error) {
+// ExportContainerOCI writes the rootfs of a container or snapshot to w as an
+// OCI image layout tarball, which docker load accepts too. This goes through
+// a temporary uncompressed image, deleted once done.
+func (c *Client) ExportContainerOCI(cname string, tags []string, labels map[string]string, w io.Writer) error {
+	source := shared.Jmap{"type": "container", "name": cname}
+	if shared.IsSnapshot(cname) {
+		source["type"] = "snapshot"
+	}
+	body := shared.Jmap{"public": false, "source": source, "compression_algorithm": "none"}
+
+	resp, err := c.post("images", body, Async)
+	if err != nil {
+		return err
+	}
+
+	jmap, err := c.AsyncWaitMeta(resp)
+	if err != nil {
+		return err
+	}
+
+	fingerprint, err := jmap.GetString("fingerprint")
+	if err != nil {
+		return err
+	}
+	defer c.DeleteImage(fingerprint)
+
+	info, err := c.GetImageInfo(fingerprint)
+	if err != nil {
+		return err
+	}
+
+	arch, err := shared.ArchitectureName(info.Architecture)
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.getRaw(c.url(shared.APIVersion, "images", fingerprint, "export"))
+	if err != nil {
+		return err
+	}
+	defer raw.Body.Close()
+
+	created := time.Now()
+	if info.CreationDate > 0 {
+		created = time.Unix(info.CreationDate, 0)
+	}
+
+	return shared.OCIFromImage(raw.Body, w, arch, created, tags, labels)
+}
+
+// ImageFromContainer publishes a container or snapshot as an image.
+// compression is ALGORITHM[:LEVEL], empty for the images.compression_algorithm
+// and images.compression_level of the daemon.
+func (c *Client) ImageFromContainer(cname string, public bool, aliases []string, properties map[string]string, compression string) (string, error) {
 	source := shared.Jmap{"type": "container", "name": cname}
 	if shared.IsSnapshot(cname) {
 		source["type"] = "snapshot"
 	}
 	body := shared.Jmap{"public": public, "source": source, "properties": properties}
+	if compression != "" {
+		fields := strings.SplitN(compression, ":", 2)
+		body["compression_algorithm"] = fields[0]
+		if len(fields) == 2 {
+			body["compression_level"] = fields[1]
+		}
+	}
 
 	resp, err := c.post("images", body, Async)
 	if err != nil {
@@ -0,0 +1,206 @@
+package lxd
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// retryServer answers 503 to the first failures requests, 200 afterwards,
+// counting how many it got.
+func retryServer(failures int32) (*httptest.Server, *int32) {
+	count := new(int32)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ioutil.ReadAll(r.Body)
+		if atomic.AddInt32(count, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	return ts, count
+}
+
+func retryClient(retries int) *Client {
+	return &Client{options: ClientOptions{Retries: retries, RetryBackoff: time.Millisecond}}
+}
+
+func TestDoRetry(t *testing.T) {
+	ts, count := retryServer(1)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := retryClient(3).doRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(count) != 2 {
+		t.Errorf("Got %d after %d requests instead of 200 after 2", resp.StatusCode, atomic.LoadInt32(count))
+	}
+}
+
+func TestDoRetryBody(t *testing.T) {
+	ts, count := retryServer(1)
+	defer ts.Close()
+
+	// The body can be sent again
+	req, err := http.NewRequest("DELETE", ts.URL, bytes.NewReader([]byte("{}")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := retryClient(3).doRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || atomic.LoadInt32(count) != 2 {
+		t.Errorf("Got %d after %d requests instead of 200 after 2", resp.StatusCode, atomic.LoadInt32(count))
+	}
+
+	// It can't, without GetBody
+	atomic.StoreInt32(count, 0)
+	req, err = http.NewRequest("DELETE", ts.URL, ioutil.NopCloser(bytes.NewReader([]byte("{}"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = retryClient(3).doRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(count) != 1 {
+		t.Errorf("Request without GetBody got retried: %d after %d requests", resp.StatusCode, atomic.LoadInt32(count))
+	}
+}
+
+func TestDoRetryNotIdempotent(t *testing.T) {
+	for _, method := range []string{"POST", "PUT"} {
+		ts, count := retryServer(1)
+
+		req, err := http.NewRequest(method, ts.URL, bytes.NewReader([]byte("{}")))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := retryClient(3).doRetry(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		ts.Close()
+
+		if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(count) != 1 {
+			t.Errorf("%s got retried: %d after %d requests", method, resp.StatusCode, atomic.LoadInt32(count))
+		}
+	}
+}
+
+func TestDoRetryGivesUp(t *testing.T) {
+	ts, count := retryServer(10)
+	defer ts.Close()
+
+	req, err := http.NewRequest("GET", ts.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := retryClient(2).doRetry(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(count) != 3 {
+		t.Errorf("Got %d after %d requests instead of 503 after 3", resp.StatusCode, atomic.LoadInt32(count))
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_client_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	socket := filepath.Join(dir, "unix.socket")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A daemon slow to answer anything but its fingerprint
+	ts := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/1.0":
+			fmt.Fprintf(w, `{"type": "sync", "metadata": {"api_compat": %d}}`, shared.APICompat)
+		case "/1.0/body":
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("done"))
+		default:
+			time.Sleep(300 * time.Millisecond)
+			w.Write([]byte("done"))
+		}
+	}))
+	ts.Listener = listener
+	ts.Start()
+	defer ts.Close()
+
+	config := &Config{Remotes: map[string]RemoteConfig{"slow": {Addr: "unix:" + socket}}}
+	c, err := NewClientWithOptions(config, "slow", ClientOptions{RequestTimeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	get := func(path string) (string, error) {
+		req, err := http.NewRequest("GET", c.BaseURL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := c.doRetry(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	_, err = get("/1.0/headers")
+	if err == nil {
+		t.Errorf("Waiting for the headers didn't time out")
+	}
+
+	// Only the headers count
+	for _, path := range []string{"/1.0/body", "/1.0/operations/1234/wait", "/1.0/containers/foo/state?wait=running"} {
+		body, err := get(path)
+		if err != nil || body != "done" {
+			t.Errorf("Got %q (%v) for %s instead of the whole answer", body, err, path)
+		}
+	}
+}
@@ -13,8 +13,16 @@ import (
 	"github.com/krschwab/xlxd/shared"
 )
 
+// ConfigVersion is the current version of the config file format. Version 1
+// (files without a version) only had an address and a public flag for each
+// remote.
+const ConfigVersion = 2
+
 // Config holds settings to be used by a client or daemon.
 type Config struct {
+	// Version of the format the config was written with.
+	Version int `yaml:"version"`
+
 	// DefaultRemote holds the remote daemon name from the Remotes map
 	// that the client should communicate with by default.
 	// If empty it defaults to "local".
@@ -34,19 +42,162 @@ type Config struct {
 type RemoteConfig struct {
 	Addr   string `yaml:"addr"`
 	Public bool   `yaml:"public"`
+
+	// Protocol spoken by the remote, only "lxd" is supported.
+	Protocol string `yaml:"protocol,omitempty"`
+
+	// SHA256 fingerprint the server certificate must have, in hex.
+	CertFingerprint string `yaml:"certificate-fingerprint,omitempty"`
+
+	// How the client authenticates, only "tls" is supported (the unix
+	// socket doesn't need any).
+	AuthType string `yaml:"auth-type,omitempty"`
+
+	// Jump host the remote is reached through, for daemons on private
+	// networks: the name of another remote whose daemon relays the
+	// connections, or an SSH bastion as ssh://[user@]host[:port].
+	Via string `yaml:"via,omitempty"`
+
+	// Proxy the remote is reached through, as http://, https://,
+	// socks5:// or socks5h://[user:password@]host:port, "none" to go
+	// direct. By default, HTTPS_PROXY or ALL_PROXY is used.
+	Proxy string `yaml:"proxy,omitempty"`
 }
 
+// Values the remote settings can take.
+var remoteProtocols = []string{"lxd"}
+var remoteAuthTypes = []string{"tls"}
+
 var LocalRemote = RemoteConfig{
-	Addr:   "unix://",
-	Public: false}
+	Addr:     "unix://",
+	Public:   false,
+	Protocol: "lxd"}
 var defaultRemote = map[string]RemoteConfig{"local": LocalRemote}
 
 var DefaultConfig = Config{
+	Version:       ConfigVersion,
 	Remotes:       defaultRemote,
 	DefaultRemote: "local",
 	Aliases:       map[string]string{},
 }
 
+// RemoteSettings lists the per-remote settings which can be changed with
+// SetRemoteSetting.
+var RemoteSettings = []string{"auth-type", "certificate-fingerprint", "protocol", "proxy", "via"}
+
+// SetRemoteSetting changes one of the settings of a remote, an empty value
+// restoring the default.
+func (c *Config) SetRemoteSetting(remote string, key string, value string) error {
+	rc, ok := c.Remotes[remote]
+	if !ok {
+		return fmt.Errorf("unknown remote name: %q", remote)
+	}
+
+	switch key {
+	case "auth-type":
+		if value != "" && !shared.StringInSlice(value, remoteAuthTypes) {
+			return fmt.Errorf("unsupported auth type: %s", value)
+		}
+		rc.AuthType = value
+	case "certificate-fingerprint":
+		value = strings.ToLower(strings.Replace(value, ":", "", -1))
+		if value != "" && len(value) != 64 {
+			return fmt.Errorf("invalid SHA256 fingerprint: %s", value)
+		}
+		rc.CertFingerprint = value
+	case "proxy":
+		if value != "" && value != "none" {
+			_, err := shared.ParseProxyURL(value)
+			if err != nil {
+				return err
+			}
+		}
+		rc.Proxy = value
+	case "protocol":
+		if value != "" && !shared.StringInSlice(value, remoteProtocols) {
+			return fmt.Errorf("unsupported protocol: %s", value)
+		}
+		rc.Protocol = value
+	case "via":
+		value = strings.TrimSuffix(value, ":")
+		if value != "" && strings.HasPrefix(rc.Addr, "unix:") {
+			return fmt.Errorf("remotes on a unix socket can't be reached through another host")
+		}
+
+		old := rc.Via
+		rc.Via = value
+		c.Remotes[remote] = rc
+
+		err := c.CheckVia(remote)
+		if err != nil {
+			rc.Via = old
+			c.Remotes[remote] = rc
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown remote setting: %s", key)
+	}
+
+	c.Remotes[remote] = rc
+	return nil
+}
+
+// CheckVia checks the chain of jump hosts a remote is reached through: each
+// must be another remote reachable over the network or an SSH bastion, and
+// none may be reached through the remote itself.
+func (c *Config) CheckVia(remote string) error {
+	seen := []string{remote}
+	for {
+		via := c.Remotes[remote].Via
+		if via == "" {
+			return nil
+		}
+
+		if strings.HasPrefix(via, "ssh://") {
+			_, err := sshBastionParse(via)
+			return err
+		}
+
+		rc, ok := c.Remotes[via]
+		if !ok {
+			return fmt.Errorf("unknown remote to connect through: %s", via)
+		}
+
+		if strings.HasPrefix(rc.Addr, "unix:") {
+			return fmt.Errorf("can't connect through %s, it's a unix socket", via)
+		}
+
+		if shared.StringInSlice(via, seen) {
+			return fmt.Errorf("remotes connected through each other: %s", strings.Join(append(seen, via), " -> "))
+		}
+
+		seen = append(seen, via)
+		remote = via
+	}
+}
+
+// upgrade brings a config read from an older file to the current format,
+// the file itself gets rewritten the next time the config is saved.
+func (c *Config) upgrade() {
+	if c.Version >= ConfigVersion {
+		return
+	}
+
+	for name, rc := range c.Remotes {
+		if rc.Protocol == "" {
+			rc.Protocol = "lxd"
+		}
+
+		if rc.AuthType == "" && !strings.HasPrefix(rc.Addr, "unix:") {
+			rc.AuthType = "tls"
+		}
+
+		c.Remotes[name] = rc
+	}
+
+	c.Version = ConfigVersion
+}
+
 var ConfigDir = "$HOME/.config/lxc"
 var configFileName = "config.yml"
 
@@ -77,6 +228,7 @@ func LoadConfig() (*Config, error) {
 	if c.Remotes == nil {
 		c.Remotes = make(map[string]RemoteConfig)
 	}
+	c.upgrade()
 
 	return &c, nil
 }
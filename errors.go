@@ -0,0 +1,73 @@
+package lxd
+
+import (
+	"net/http"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// ServerError is returned when the daemon answers a request with an error, the
+// status code tells what kind of error it was.
+type ServerError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *ServerError) Error() string {
+	return e.Message
+}
+
+// OperationError is returned when a background operation failed, the whole
+// operation is available with its metadata.
+type OperationError struct {
+	Operation *shared.Operation
+}
+
+func (e *OperationError) Error() string {
+	return e.Operation.Err
+}
+
+func hasStatusCode(err error, code int) bool {
+	lxdErr, ok := err.(*ServerError)
+	return ok && lxdErr.StatusCode == code
+}
+
+// IsNotFound returns whether err means that the object doesn't exist.
+func IsNotFound(err error) bool {
+	return hasStatusCode(err, http.StatusNotFound)
+}
+
+// IsConflict returns whether err means that the object already exists.
+func IsConflict(err error) bool {
+	return hasStatusCode(err, http.StatusConflict)
+}
+
+// IsForbidden returns whether err means that the client isn't trusted.
+func IsForbidden(err error) bool {
+	return hasStatusCode(err, http.StatusForbidden)
+}
+
+// IsPreconditionFailed returns whether err means that the object was
+// modified by someone else since it was last retrieved.
+func IsPreconditionFailed(err error) bool {
+	return hasStatusCode(err, http.StatusPreconditionFailed)
+}
+
+// IsChecksumMismatch returns whether err means that the content received by
+// the daemon didn't match the checksum it was sent with.
+func IsChecksumMismatch(err error) bool {
+	return hasStatusCode(err, http.StatusUnprocessableEntity)
+}
+
+// IsTimeout returns whether err means that the daemon gave up waiting for
+// something to happen.
+func IsTimeout(err error) bool {
+	return hasStatusCode(err, http.StatusRequestTimeout)
+}
+
+// IsOperationFailed returns whether err comes from a failed background
+// operation.
+func IsOperationFailed(err error) bool {
+	_, ok := err.(*OperationError)
+	return ok
+}
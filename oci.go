@@ -0,0 +1,413 @@
+package lxd
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+)
+
+// The registry used for references which don't name one.
+const dockerHubRegistry = "registry-1.docker.io"
+
+var ociManifestTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// ociManifest covers both image indexes and image manifests, in their OCI
+// and Docker flavours.
+type ociManifest struct {
+	MediaType string `json:"mediaType"`
+
+	// Index
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			Architecture string `json:"architecture"`
+			OS           string `json:"os"`
+			Variant      string `json:"variant"`
+		} `json:"platform"`
+	} `json:"manifests"`
+
+	// Manifest
+	Config struct {
+		Digest string `json:"digest"`
+	} `json:"config"`
+	Layers []struct {
+		Digest string `json:"digest"`
+	} `json:"layers"`
+}
+
+type ociConfig struct {
+	Architecture string `json:"architecture"`
+	Variant      string `json:"variant"`
+	OS           string `json:"os"`
+	Created      string `json:"created"`
+	Config       struct {
+		Entrypoint []string `json:"Entrypoint"`
+		Cmd        []string `json:"Cmd"`
+	} `json:"config"`
+}
+
+// ociRegistry talks to a Docker registry (v2 API) on behalf of a single
+// repository, getting an anonymous token when the registry asks for one.
+type ociRegistry struct {
+	http       http.Client
+	registry   string
+	repository string
+	token      string
+}
+
+// parseDockerReference splits docker://[registry/]name[:tag|@digest] into
+// the registry, the repository and the tag or digest.
+func parseDockerReference(ref string) (string, string, string, error) {
+	name := strings.TrimPrefix(ref, "docker://")
+	if name == "" {
+		return "", "", "", fmt.Errorf(i18n.G("Invalid image reference: %s"), ref)
+	}
+
+	registry := dockerHubRegistry
+	fields := strings.SplitN(name, "/", 2)
+	if len(fields) == 2 && (strings.ContainsAny(fields[0], ".:") || fields[0] == "localhost") {
+		registry = fields[0]
+		name = fields[1]
+	}
+
+	if registry == "docker.io" {
+		registry = dockerHubRegistry
+	}
+
+	reference := "latest"
+	if i := strings.Index(name, "@"); i >= 0 {
+		reference = name[i+1:]
+		name = name[:i]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		reference = name[i+1:]
+		name = name[:i]
+	}
+
+	if registry == dockerHubRegistry && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+
+	if name == "" || reference == "" {
+		return "", "", "", fmt.Errorf(i18n.G("Invalid image reference: %s"), ref)
+	}
+
+	return registry, name, reference, nil
+}
+
+var ociChallengeParam = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+func (r *ociRegistry) authenticate(challenge string) error {
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		return fmt.Errorf(i18n.G("Unsupported registry authentication: %s"), challenge)
+	}
+
+	params := url.Values{}
+	realm := ""
+	for _, match := range ociChallengeParam.FindAllStringSubmatch(challenge, -1) {
+		if match[1] == "realm" {
+			realm = match[2]
+		} else {
+			params.Set(match[1], match[2])
+		}
+	}
+
+	if realm == "" {
+		return fmt.Errorf(i18n.G("Unsupported registry authentication: %s"), challenge)
+	}
+
+	resp, err := r.http.Get(realm + "?" + params.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf(i18n.G("Failed to get a registry token: %s"), resp.Status)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	if err != nil {
+		return err
+	}
+
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+
+	return nil
+}
+
+func (r *ociRegistry) get(kind string, reference string, accept []string) (*http.Response, error) {
+	uri := fmt.Sprintf("https://%s/v2/%s/%s/%s", r.registry, r.repository, kind, reference)
+
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequest("GET", uri, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("User-Agent", shared.UserAgent)
+		req.Header.Set("Accept", strings.Join(accept, ", "))
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		}
+
+		return r.http.Do(req)
+	}
+
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && r.token == "" {
+		resp.Body.Close()
+
+		err = r.authenticate(resp.Header.Get("WWW-Authenticate"))
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = do()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf(i18n.G("Failed to get %s from %s/%s: %s"), reference, r.registry, r.repository, resp.Status)
+	}
+
+	return resp, nil
+}
+
+func (r *ociRegistry) manifest(reference string) (*ociManifest, error) {
+	resp, err := r.get("manifests", reference, ociManifestTypes)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	manifest := ociManifest{}
+	err = json.NewDecoder(resp.Body).Decode(&manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// blob downloads a blob to a file of dir, checking its digest on the way.
+func (r *ociRegistry) blob(digest string, dir string) (string, error) {
+	if !strings.HasPrefix(digest, "sha256:") {
+		return "", fmt.Errorf(i18n.G("Unsupported digest: %s"), digest)
+	}
+
+	resp, err := r.get("blobs", digest, []string{"*/*"})
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	name := filepath.Join(dir, strings.TrimPrefix(digest, "sha256:"))
+	f, err := os.Create(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(io.MultiWriter(f, hash), resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	if fmt.Sprintf("sha256:%x", hash.Sum(nil)) != digest {
+		return "", fmt.Errorf(i18n.G("Corrupted download of %s"), digest)
+	}
+
+	return name, nil
+}
+
+// PostImageDocker imports an image from a Docker registry, referred to as
+// docker://[registry/]name[:tag|@digest]. Its layers are flattened into the
+// rootfs of an image for the first of the daemon's architectures which the
+// registry has.
+func (c *Client) PostImageDocker(ref string, properties []string, public bool, aliases []string) (string, error) {
+	registry, repository, reference, err := parseDockerReference(ref)
+	if err != nil {
+		return "", err
+	}
+
+	status, err := c.ServerStatus()
+	if err != nil {
+		return "", err
+	}
+
+	r := &ociRegistry{registry: registry, repository: repository}
+	manifest, err := r.manifest(reference)
+	if err != nil {
+		return "", err
+	}
+
+	// Multi-architecture images point to one manifest per platform
+	if len(manifest.Manifests) > 0 {
+		digest := ""
+		for _, id := range status.Environment.Architectures {
+			arch, err := shared.ArchitectureName(id)
+			if err != nil {
+				continue
+			}
+
+			name, variant, err := shared.OCIArchitectureName(arch)
+			if err != nil {
+				continue
+			}
+
+			for _, entry := range manifest.Manifests {
+				platform := entry.Platform
+				if platform.OS == "linux" && platform.Architecture == name && (variant == "" || platform.Variant == "" || platform.Variant == variant) {
+					digest = entry.Digest
+					break
+				}
+			}
+
+			if digest != "" {
+				break
+			}
+		}
+
+		if digest == "" {
+			return "", fmt.Errorf(i18n.G("%s isn't available for any of the server's architectures"), ref)
+		}
+
+		manifest, err = r.manifest(digest)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "lxd_oci_")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	configFile, err := r.blob(manifest.Config.Digest, dir)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadFile(configFile)
+	if err != nil {
+		return "", err
+	}
+
+	config := ociConfig{}
+	err = json.Unmarshal(content, &config)
+	if err != nil {
+		return "", err
+	}
+
+	arch, err := shared.OCIArchitecture(config.Architecture, config.Variant)
+	if err != nil {
+		return "", err
+	}
+
+	layers := []string{}
+	for _, layer := range manifest.Layers {
+		name, err := r.blob(layer.Digest, dir)
+		if err != nil {
+			return "", err
+		}
+
+		layers = append(layers, name)
+	}
+
+	created := time.Now().UTC()
+	if config.Created != "" {
+		created, err = time.Parse(time.RFC3339Nano, config.Created)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	metadata, err := yaml.Marshal(map[string]interface{}{
+		"architecture":  arch,
+		"creation_date": created.Unix(),
+		"properties": map[string]string{
+			"architecture":   arch,
+			"description":    fmt.Sprintf("docker://%s/%s:%s", registry, repository, reference),
+			"oci.entrypoint": strings.Join(append(config.Config.Entrypoint, config.Config.Cmd...), " "),
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Build a unified tarball out of the metadata and the layers
+	imageFile := filepath.Join(dir, "image.tar.gz")
+	f, err := os.Create(imageFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = tw.WriteHeader(&tar.Header{Name: "metadata.yaml", Mode: 0644, Size: int64(len(metadata)), ModTime: created, Typeflag: tar.TypeReg})
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tw.Write(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	err = shared.OCIFlatten(layers, tw, "rootfs")
+	if err != nil {
+		return "", err
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return "", err
+	}
+
+	err = gz.Close()
+	if err != nil {
+		return "", err
+	}
+
+	return c.PostImage(imageFile, "", properties, public, aliases)
+}
@@ -0,0 +1,218 @@
+package shared
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The transfers can be limited to a number of bytes per second, the image
+// downloads and the migrations getting throttled by whoever receives them,
+// which slows the sender down through TCP flow control.
+
+var bandwidthUnits = map[string]int64{
+	"kB": 1024,
+	"MB": 1024 * 1024,
+	"GB": 1024 * 1024 * 1024,
+}
+
+// ParseBandwidthLimit returns the bytes per second of a limit like "10MB",
+// a number of bytes without unit, 0 for no limit.
+func ParseBandwidthLimit(value string) (int64, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	multiplier := int64(1)
+	number := value
+	for unit, size := range bandwidthUnits {
+		if strings.HasSuffix(value, unit) {
+			multiplier = size
+			number = strings.TrimSuffix(value, unit)
+			break
+		}
+	}
+
+	limit, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || limit < 0 {
+		return -1, fmt.Errorf("Invalid bandwidth limit, must be a number of bytes per second with an optional kB, MB or GB unit: %s", value)
+	}
+
+	if limit > math.MaxInt64/multiplier {
+		return -1, fmt.Errorf("Bandwidth limit too large: %s", value)
+	}
+
+	return limit * multiplier, nil
+}
+
+// BandwidthLimiter spaces out what goes through it to keep the rate under
+// its limit, the bursts being at most a tenth of a second of traffic. All
+// the readers and connections of a transfer share the same limiter. A nil
+// limiter doesn't limit anything.
+type BandwidthLimiter struct {
+	limit int64
+	start time.Time
+	total int64
+
+	lock sync.Mutex
+}
+
+// NewBandwidthLimiter returns a limiter of limit bytes per second, nil when
+// there's no limit.
+func NewBandwidthLimiter(limit int64) *BandwidthLimiter {
+	if limit <= 0 {
+		return nil
+	}
+
+	return &BandwidthLimiter{limit: limit}
+}
+
+func (l *BandwidthLimiter) chunk(size int) int {
+	max := l.limit / 10
+	if max < 1 {
+		max = 1
+	}
+
+	if int64(size) > max {
+		return int(max)
+	}
+
+	return size
+}
+
+func (l *BandwidthLimiter) wait(n int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.start.IsZero() {
+		l.start = time.Now()
+	}
+
+	l.total += int64(n)
+	due := l.start.Add(time.Duration(float64(l.total) / float64(l.limit) * float64(time.Second)))
+	time.Sleep(due.Sub(time.Now()))
+}
+
+type bandwidthReader struct {
+	io.Reader
+	limiter *BandwidthLimiter
+}
+
+// Reader returns a reader going through the limiter, r itself when there's
+// no limit.
+func (l *BandwidthLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+
+	return &bandwidthReader{Reader: r, limiter: l}
+}
+
+// BandwidthReader returns a reader reading at most limit bytes per second,
+// r itself when there's no limit.
+func BandwidthReader(r io.Reader, limit int64) io.Reader {
+	return NewBandwidthLimiter(limit).Reader(r)
+}
+
+func (r *bandwidthReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p[:r.limiter.chunk(len(p))])
+	if n > 0 {
+		r.limiter.wait(n)
+	}
+
+	return n, err
+}
+
+type bandwidthConn struct {
+	net.Conn
+	limiter *BandwidthLimiter
+}
+
+func (c *bandwidthConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p[:c.limiter.chunk(len(p))])
+	if n > 0 {
+		c.limiter.wait(n)
+	}
+
+	return n, err
+}
+
+// Conn returns a connection whose reads go through the limiter, conn itself
+// when there's no limit.
+func (l *BandwidthLimiter) Conn(conn net.Conn) net.Conn {
+	if l == nil {
+		return conn
+	}
+
+	return &bandwidthConn{Conn: conn, limiter: l}
+}
+
+// Dialer returns a dialer whose connections receive through the limiter,
+// dial itself when there's no limit.
+func (l *BandwidthLimiter) Dialer(dial func(network, address string) (net.Conn, error)) func(network, address string) (net.Conn, error) {
+	if dial == nil {
+		dial = net.Dial
+	}
+
+	if l == nil {
+		return dial
+	}
+
+	return func(network, address string) (net.Conn, error) {
+		conn, err := dial(network, address)
+		if err != nil {
+			return nil, err
+		}
+
+		return l.Conn(conn), nil
+	}
+}
+
+// BandwidthDialer returns a dialer whose connections together receive at
+// most limit bytes per second, dial itself when there's no limit.
+func BandwidthDialer(dial func(network, address string) (net.Conn, error), limit int64) func(network, address string) (net.Conn, error) {
+	return NewBandwidthLimiter(limit).Dialer(dial)
+}
+
+type bandwidthResponseWriter struct {
+	http.ResponseWriter
+	limiter *BandwidthLimiter
+}
+
+func (w *bandwidthResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("The connection can't be hijacked")
+	}
+
+	conn, brw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Have what's still to come go through the limiter too
+	conn = w.limiter.Conn(conn)
+	if brw.Reader.Buffered() == 0 {
+		brw.Reader.Reset(conn)
+	}
+
+	return conn, brw, nil
+}
+
+// ResponseWriter returns a response writer whose hijacked connection, like
+// the one of a websocket, receives through the limiter, w itself when
+// there's no limit.
+func (l *BandwidthLimiter) ResponseWriter(w http.ResponseWriter) http.ResponseWriter {
+	if l == nil {
+		return w
+	}
+
+	return &bandwidthResponseWriter{ResponseWriter: w, limiter: l}
+}
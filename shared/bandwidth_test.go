@@ -0,0 +1,88 @@
+package shared
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestParseBandwidthLimit(t *testing.T) {
+	tests := map[string]int64{
+		"":      0,
+		"0":     0,
+		"1000":  1000,
+		"512kB": 512 * 1024,
+		"10MB":  10 * 1024 * 1024,
+		"1GB":   1024 * 1024 * 1024,
+	}
+
+	for value, expected := range tests {
+		limit, err := ParseBandwidthLimit(value)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", value, err)
+			continue
+		}
+
+		if limit != expected {
+			t.Errorf("Got %d instead of %d for %q", limit, expected, value)
+		}
+	}
+
+	for _, value := range []string{"fast", "10mb", "-1MB", "MB", "1.5MB", "9000000000GB"} {
+		_, err := ParseBandwidthLimit(value)
+		if err == nil {
+			t.Errorf("Invalid limit %q was accepted", value)
+		}
+	}
+}
+
+func TestBandwidthReader(t *testing.T) {
+	data := bytes.Repeat([]byte("x"), 1000)
+
+	r := BandwidthReader(bytes.NewReader(data), 0)
+	if _, ok := r.(*bytes.Reader); !ok {
+		t.Errorf("The reader got wrapped without limit")
+	}
+
+	start := time.Now()
+	out, err := ioutil.ReadAll(BandwidthReader(bytes.NewReader(data), 4000))
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(out, data) {
+		t.Errorf("Got %d bytes instead of %d", len(out), len(data))
+	}
+
+	// 1000 bytes at 4000 bytes per second
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Reading took %s instead of about 250ms", elapsed)
+	}
+}
+
+func TestBandwidthLimiterShared(t *testing.T) {
+	limiter := NewBandwidthLimiter(4000)
+	first := limiter.Reader(bytes.NewReader(bytes.Repeat([]byte("x"), 500)))
+	second := limiter.Reader(bytes.NewReader(bytes.Repeat([]byte("y"), 500)))
+
+	// 1000 bytes at 4000 bytes per second, whichever reader they come from
+	start := time.Now()
+	for _, r := range []io.Reader{first, second} {
+		_, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	elapsed := time.Since(start)
+	if elapsed < 200*time.Millisecond || elapsed > 2*time.Second {
+		t.Errorf("Reading took %s instead of about 250ms", elapsed)
+	}
+
+	if NewBandwidthLimiter(0) != nil {
+		t.Errorf("Got a limiter without limit")
+	}
+}
@@ -27,6 +27,7 @@ type CertInfo struct {
 	Certificate string `json:"certificate"`
 	Fingerprint string `json:"fingerprint"`
 	Type        string `json:"type"`
+	ReadOnly    bool   `json:"read_only"`
 }
 
 /*
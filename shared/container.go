@@ -1,7 +1,11 @@
 package shared
 
 import (
+	"fmt"
+	"net"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Ip struct {
@@ -9,14 +13,57 @@ type Ip struct {
 	Protocol  string `json:"protocol"`
 	Address   string `json:"address"`
 	HostVeth  string `json:"host_veth"`
+
+	// "global", "link" or "host"
+	Scope string `json:"scope"`
+
+	// How a global address was configured: "static", "dhcp" or "slaac",
+	// empty when unknown
+	Source string `json:"source"`
+
+	// Seconds the address remains preferred, -1 for ever and 0 once
+	// deprecated
+	PreferredLifetime int64 `json:"preferred_lifetime"`
+}
+
+// IpScope returns the scope of an address, for the servers which don't
+// report it.
+func IpScope(address string) string {
+	ip := net.ParseIP(address)
+	switch {
+	case ip == nil:
+		return ""
+	case ip.IsLoopback():
+		return "host"
+	case ip.IsLinkLocalUnicast():
+		return "link"
+	}
+
+	return "global"
+}
+
+type ContainerDisk struct {
+	Usage     int64                    `json:"usage"`
+	Quota     int64                    `json:"quota"`
+	Snapshots map[string]ContainerDisk `json:"snapshots,omitempty"`
 }
 
 type ContainerStatus struct {
-	Status       string     `json:"status"`
-	StatusCode   StatusCode `json:"status_code"`
-	Init         int        `json:"init"`
-	Processcount int        `json:"processcount"`
-	Ips          []Ip       `json:"ips"`
+	Status       string        `json:"status"`
+	StatusCode   StatusCode    `json:"status_code"`
+	Init         int           `json:"init"`
+	Processcount int           `json:"processcount"`
+	Ips          []Ip          `json:"ips"`
+	Disk         ContainerDisk `json:"disk"`
+	Ready        bool          `json:"ready"`
+
+	// Seconds since the container was frozen
+	FrozenDuration int64 `json:"frozen_duration"`
+
+	// Config keys and devices changed since the container was started
+	// which only apply once it's restarted
+	RestartRequired bool     `json:"restart_required"`
+	PendingKeys     []string `json:"pending_keys"`
 }
 
 type ContainerExecControl struct {
@@ -34,6 +81,64 @@ type ContainerState struct {
 	Name            string            `json:"name"`
 	Profiles        []string          `json:"profiles"`
 	Status          ContainerStatus   `json:"status"`
+	UUID            string            `json:"uuid"`
+}
+
+// ContainerStatusMatches tells whether the status meets all of the comma
+// separated wait conditions, which are status:<STATUS>, ready, ipv4 and ipv6.
+// The address ones are met once the container has a global address of that
+// family, link-local and loopback addresses don't count.
+func ContainerStatusMatches(status *ContainerStatus, wait string) (bool, error) {
+	matches := true
+	for _, condition := range strings.Split(wait, ",") {
+		switch {
+		case strings.HasPrefix(condition, "status:"):
+			if !strings.EqualFold(status.Status, strings.TrimPrefix(condition, "status:")) {
+				matches = false
+			}
+		case condition == "ready":
+			if !status.Ready {
+				matches = false
+			}
+		case condition == "ipv4" || condition == "ipv6":
+			if !containerHasAddress(status, condition == "ipv6") {
+				matches = false
+			}
+		default:
+			return false, fmt.Errorf("Invalid wait condition: %s", condition)
+		}
+	}
+
+	return matches, nil
+}
+
+func containerHasAddress(status *ContainerStatus, ipv6 bool) bool {
+	for _, entry := range status.Ips {
+		ip := net.ParseIP(entry.Address)
+		if ip == nil || !ip.IsGlobalUnicast() {
+			continue
+		}
+
+		if (ip.To4() == nil) == ipv6 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ContainerUpdatePreview is what a container update would change, as reported
+// by a dry-run.
+type ContainerUpdatePreview struct {
+	// Expanded config keys and devices which would change
+	Config  []string `json:"config"`
+	Devices []string `json:"devices"`
+
+	// The LXC config the container would get
+	LxcConfig string `json:"lxc_config"`
+
+	// Whether the running container must be restarted to fully apply it
+	RestartRequired bool `json:"restart_required"`
 }
 
 /*
@@ -66,9 +171,32 @@ func (c *ContainerState) BriefStateExpanded() BriefContainerState {
 	return retstate
 }
 
+type ContainerSnapshot struct {
+	Name         string    `json:"name"`
+	Stateful     bool      `json:"stateful"`
+	CreationDate time.Time `json:"created_at"`
+
+	// The snapshot gets deleted once expired, the zero time means never
+	ExpiresAt time.Time `json:"expires_at"`
+
+	// Disk space used by the snapshot, -1 if unknown
+	Size int64 `json:"size"`
+
+	// Only filled when getting the snapshot itself, this is the
+	// configuration of the container when the snapshot was taken
+	Architecture int               `json:"architecture,omitempty"`
+	Config       map[string]string `json:"config,omitempty"`
+	Devices      Devices           `json:"devices,omitempty"`
+	Ephemeral    bool              `json:"ephemeral,omitempty"`
+	Profiles     []string          `json:"profiles,omitempty"`
+}
+
 type ContainerInfo struct {
 	State ContainerState `json:"state"`
 	Snaps []string       `json:"snaps"`
+
+	// Only filled with recursion=2
+	Snapshots []ContainerSnapshot `json:"snapshots,omitempty"`
 }
 
 type ContainerInfoList []ContainerInfo
@@ -108,4 +236,7 @@ type ProfileConfig struct {
 	Name    string            `json:"name"`
 	Config  map[string]string `json:"config"`
 	Devices Devices           `json:"devices"`
+
+	// URLs of the containers and snapshots using the profile
+	UsedBy []string `json:"used_by"`
 }
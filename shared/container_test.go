@@ -0,0 +1,72 @@
+package shared
+
+import (
+	"testing"
+)
+
+func TestContainerStatusMatches(t *testing.T) {
+	status := &ContainerStatus{
+		Status: "Running",
+		Ips: []Ip{
+			{Interface: "lo", Protocol: "IPV4", Address: "127.0.0.1"},
+			{Interface: "eth0", Protocol: "IPV6", Address: "fe80::216:3eff:fe00:1"},
+			{Interface: "eth0", Protocol: "IPV4", Address: "10.0.3.5"},
+		},
+	}
+
+	tests := []struct {
+		wait    string
+		matches bool
+	}{
+		{"status:RUNNING", true},
+		{"status:running", true},
+		{"status:STOPPED", false},
+		{"ipv4", true},
+		{"ipv6", false},
+		{"status:RUNNING,ipv4", true},
+		{"status:RUNNING,ipv6", false},
+		{"ready", false},
+	}
+
+	for _, test := range tests {
+		matches, err := ContainerStatusMatches(status, test.wait)
+		if err != nil {
+			t.Errorf("Failed to check %q: %v", test.wait, err)
+			continue
+		}
+
+		if matches != test.matches {
+			t.Errorf("Checking %q gave %v instead of %v", test.wait, matches, test.matches)
+		}
+	}
+
+	status.Ready = true
+	matches, err := ContainerStatusMatches(status, "status:RUNNING,ready")
+	if err != nil || !matches {
+		t.Errorf("Ready container didn't match: %v", err)
+	}
+
+	_, err = ContainerStatusMatches(status, "bogus")
+	if err == nil {
+		t.Error("Invalid condition wasn't caught")
+	}
+}
+
+func TestIpScope(t *testing.T) {
+	tests := map[string]string{
+		"127.0.0.1":             "host",
+		"::1":                   "host",
+		"fe80::216:3eff:fe00:1": "link",
+		"169.254.10.1":          "link",
+		"fd42:1::10":            "global",
+		"2001:db8::1":           "global",
+		"10.0.3.5":              "global",
+		"bogus":                 "",
+	}
+
+	for address, scope := range tests {
+		if IpScope(address) != scope {
+			t.Errorf("Wrong scope for %s: %s", address, IpScope(address))
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package shared
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+/*
+ * Content-Encoding negotiation between the client and the daemon. zstd is
+ * much faster than gzip for the same ratio, both are done in process.
+ */
+
+// ContentEncodingLengthHeader carries the length of a response before it got
+// compressed, for the clients to track progress with.
+const ContentEncodingLengthHeader = "X-LXD-Content-Length"
+
+// ContentEncodings returns the supported Content-Encoding values, the
+// preferred one first.
+func ContentEncodings() []string {
+	return []string{"zstd", "gzip"}
+}
+
+// NegotiateContentEncoding returns the preferred encoding of ours accepted
+// by the given Accept-Encoding header, an empty string if there's none.
+func NegotiateContentEncoding(accept string) string {
+	accepted := []string{}
+	for _, entry := range strings.Split(accept, ",") {
+		fields := strings.Split(entry, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		if name == "" {
+			continue
+		}
+
+		// A zero weight means that the encoding is refused
+		refused := false
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if !strings.HasPrefix(param, "q=") {
+				continue
+			}
+
+			weight, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+			if err == nil && weight == 0 {
+				refused = true
+			}
+		}
+
+		if !refused {
+			accepted = append(accepted, name)
+		}
+	}
+
+	for _, encoding := range ContentEncodings() {
+		if StringInSlice(encoding, accepted) {
+			return encoding
+		}
+	}
+
+	return ""
+}
+
+// NewContentEncoder returns a writer compressing what it gets into w with
+// the given encoding, it has to be closed for everything to reach w.
+func NewContentEncoder(w io.Writer, encoding string) (io.WriteCloser, error) {
+	switch encoding {
+	case "gzip":
+		return gzip.NewWriter(w), nil
+	case "zstd":
+		return zstd.NewWriter(w)
+	default:
+		return nil, fmt.Errorf("Unsupported content encoding: %s", encoding)
+	}
+}
+
+// NewContentDecoder returns a reader of the decompressed content of r,
+// closing it closes r.
+func NewContentDecoder(r io.ReadCloser, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case "gzip":
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return &decoderReader{Reader: gz, source: r}, nil
+	case "zstd":
+		zd, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+
+		return &decoderReader{Reader: zd, source: r, release: zd.Close}, nil
+	default:
+		return nil, fmt.Errorf("Unsupported content encoding: %s", encoding)
+	}
+}
+
+// decoderReader reads from a decompressing reader, closing it closes the
+// compressed source.
+type decoderReader struct {
+	io.Reader
+	source  io.Closer
+	release func()
+}
+
+func (d *decoderReader) Close() error {
+	if d.release != nil {
+		d.release()
+	}
+
+	return d.source.Close()
+}
@@ -0,0 +1,74 @@
+package shared
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestNegotiateContentEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                    "",
+		"identity":            "",
+		"gzip":                "gzip",
+		"GZIP":                "gzip",
+		"deflate, gzip;q=0.5": "gzip",
+		"gzip;q=0":            "",
+		"gzip;q=0.000, br":    "",
+		"zstd, gzip":          "zstd",
+		"gzip, zstd":          "zstd",
+	}
+
+	for accept, expected := range tests {
+		encoding := NegotiateContentEncoding(accept)
+		if encoding != expected {
+			t.Errorf("Got %q instead of %q for %q", encoding, expected, accept)
+		}
+	}
+}
+
+func TestContentEncodingRoundTrip(t *testing.T) {
+	content := bytes.Repeat([]byte("some very compressible content\n"), 10000)
+
+	for _, encoding := range ContentEncodings() {
+		compressed := &bytes.Buffer{}
+		encoder, err := NewContentEncoder(compressed, encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = encoder.Write(content)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = encoder.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if compressed.Len() >= len(content) {
+			t.Errorf("%s didn't compress anything", encoding)
+		}
+
+		decoder, err := NewContentDecoder(ioutil.NopCloser(compressed), encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		decompressed, err := ioutil.ReadAll(decoder)
+		decoder.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(decompressed, content) {
+			t.Errorf("%s mangled the content", encoding)
+		}
+	}
+
+	_, err := NewContentEncoder(&bytes.Buffer{}, "br")
+	if err == nil {
+		t.Error("Unsupported encoding was accepted")
+	}
+}
@@ -0,0 +1,13 @@
+package shared
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Event is a message sent by the daemon over /1.0/events.
+type Event struct {
+	Type      string          `json:"type"`
+	Timestamp time.Time       `json:"timestamp"`
+	Metadata  json.RawMessage `json:"metadata"`
+}
@@ -8,8 +8,11 @@ import (
 	"os/user"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 )
 
 /*
@@ -222,7 +225,10 @@ func GetOwner(path string) (int, int, error) {
 	return uid, gid, err
 }
 
-func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how string) error {
+// shiftWorkers is the number of goroutines changing ownership in parallel.
+var shiftWorkers = runtime.NumCPU()
+
+func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how string, progress func(percent int)) error {
 	// Expand any symlink in dir and cleanup resulting path
 	dir, err := filepath.EvalSymlinks(dir)
 	if err != nil {
@@ -230,7 +236,7 @@ func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how stri
 	}
 	dir = strings.TrimRight(dir, "/")
 
-	convert := func(path string, fi os.FileInfo, err error) (e error) {
+	convert := func(path string) error {
 		uid, gid, err := GetOwner(path)
 		if err != nil {
 			return err
@@ -256,23 +262,124 @@ func (set *IdmapSet) doUidshiftIntoContainer(dir string, testmode bool, how stri
 	if !PathExists(dir) {
 		return fmt.Errorf("No such file or directory: %q", dir)
 	}
-	return filepath.Walk(dir, convert)
+
+	// Count the entries first so progress can be reported as a percentage
+	var total int64
+	if progress != nil {
+		filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			total++
+			return nil
+		})
+	}
+
+	// Keep the output in order when only pretending
+	workers := shiftWorkers
+	if testmode || workers < 1 {
+		workers = 1
+	}
+
+	var done int64
+	var reported int64 = -1
+	var progressLock sync.Mutex
+
+	var failure error
+	var failOnce sync.Once
+	stop := make(chan struct{})
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failure = err
+			close(stop)
+		})
+	}
+
+	paths := make(chan string, 1024)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for path := range paths {
+				select {
+				case <-stop:
+					continue
+				default:
+				}
+
+				err := convert(path)
+				if err != nil {
+					fail(err)
+					continue
+				}
+
+				if progress == nil || total == 0 {
+					continue
+				}
+
+				percent := atomic.AddInt64(&done, 1) * 100 / total
+				if percent > 100 {
+					percent = 100
+				}
+
+				if percent > atomic.LoadInt64(&reported) {
+					progressLock.Lock()
+					if percent > reported {
+						atomic.StoreInt64(&reported, percent)
+						progress(int(percent))
+					}
+					progressLock.Unlock()
+				}
+			}
+		}()
+	}
+
+	err = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		select {
+		case paths <- path:
+			return nil
+		case <-stop:
+			return failure
+		}
+	})
+	close(paths)
+	wg.Wait()
+
+	if failure != nil {
+		return failure
+	}
+
+	return err
 }
 
 func (set *IdmapSet) UidshiftIntoContainer(dir string, testmode bool) error {
-	return set.doUidshiftIntoContainer(dir, testmode, "in")
+	return set.doUidshiftIntoContainer(dir, testmode, "in", nil)
 }
 
 func (set *IdmapSet) UidshiftFromContainer(dir string, testmode bool) error {
-	return set.doUidshiftIntoContainer(dir, testmode, "out")
+	return set.doUidshiftIntoContainer(dir, testmode, "out", nil)
 }
 
 func (set *IdmapSet) ShiftRootfs(p string) error {
-	return set.doUidshiftIntoContainer(p, false, "in")
+	return set.doUidshiftIntoContainer(p, false, "in", nil)
 }
 
 func (set *IdmapSet) UnshiftRootfs(p string) error {
-	return set.doUidshiftIntoContainer(p, false, "out")
+	return set.doUidshiftIntoContainer(p, false, "out", nil)
+}
+
+// ShiftRootfsWithProgress is ShiftRootfs calling progress with the
+// percentage of the entries done so far.
+func (set *IdmapSet) ShiftRootfsWithProgress(p string, progress func(percent int)) error {
+	return set.doUidshiftIntoContainer(p, false, "in", progress)
+}
+
+// UnshiftRootfsWithProgress is UnshiftRootfs calling progress with the
+// percentage of the entries done so far.
+func (set *IdmapSet) UnshiftRootfsWithProgress(p string, progress func(percent int)) error {
+	return set.doUidshiftIntoContainer(p, false, "out", progress)
 }
 
 func (set *IdmapSet) ShiftFile(p string) error {
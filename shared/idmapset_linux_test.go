@@ -0,0 +1,56 @@
+package shared
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestShiftRootfsWithProgress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i := 0; i < 10; i++ {
+		sub := filepath.Join(dir, fmt.Sprintf("dir%d", i))
+		if err := os.Mkdir(sub, 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		for j := 0; j < 20; j++ {
+			if err := ioutil.WriteFile(filepath.Join(sub, fmt.Sprintf("file%d", j)), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// The owner of the files is outside of the map, so they're left as is
+	uid := os.Getuid()
+	set := IdmapSet{Idmap: []IdmapEntry{
+		{Isuid: true, Isgid: true, Hostid: uid + 100000, Nsid: uid + 100000, Maprange: 65536},
+	}}
+
+	last := -1
+	err = set.ShiftRootfsWithProgress(dir, func(percent int) {
+		if percent <= last {
+			t.Errorf("Progress went from %d%% to %d%%", last, percent)
+		}
+		last = percent
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if last != 100 {
+		t.Errorf("Progress ended at %d%%", last)
+	}
+
+	err = set.ShiftRootfsWithProgress(filepath.Join(dir, "missing"), nil)
+	if err == nil {
+		t.Errorf("Shifting a missing path should fail")
+	}
+}
@@ -23,6 +23,8 @@ type ImageInfo struct {
 	CreationDate int64 `json:"created_at"`
 	ExpiryDate   int64 `json:"expires_at"`
 	UploadDate   int64 `json:"uploaded_at"`
+
+	UUID string `json:"uuid"`
 }
 
 /*
@@ -56,4 +58,5 @@ type ImageBaseInfo struct {
 	CreationDate int64
 	ExpiryDate   int64
 	UploadDate   int64
+	UUID         string
 }
@@ -5,28 +5,45 @@ import (
 	"io"
 	"io/ioutil"
 	"net"
+	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+// NetworkInfo is a network interface of the host.
+type NetworkInfo struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Members []string `json:"members"`
+}
+
 func RFC3493Dialer(network, address string) (net.Conn, error) {
-	host, port, err := net.SplitHostPort(address)
-	if err != nil {
-		return nil, err
-	}
+	return RFC3493DialerTimeout(0)(network, address)
+}
 
-	addrs, err := net.LookupHost(host)
-	if err != nil {
-		return nil, err
-	}
-	for _, a := range addrs {
-		c, err := net.Dial(network, net.JoinHostPort(a, port))
+// RFC3493DialerTimeout returns a dialer like RFC3493Dialer which gives up on
+// each address after the timeout, 0 meaning no timeout.
+func RFC3493DialerTimeout(timeout time.Duration) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(address)
 		if err != nil {
-			continue
+			return nil, err
 		}
-		return c, err
+
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+		for _, a := range addrs {
+			c, err := net.DialTimeout(network, net.JoinHostPort(a, port), timeout)
+			if err != nil {
+				continue
+			}
+			return c, err
+		}
+		return nil, fmt.Errorf("Unable to connect to: " + address)
 	}
-	return nil, fmt.Errorf("Unable to connect to: " + address)
 }
 
 func IsLoopback(iface *net.Interface) bool {
@@ -193,3 +210,142 @@ func WebsocketMirror(conn *websocket.Conn, w io.WriteCloser, r io.ReadCloser) (c
 
 	return readDone, writeDone
 }
+
+// WebsocketProxy relays the messages of two websockets to each other, as is,
+// the text barriers included. When one of them ends, the other one gets
+// closed, the channel getting a value once both directions are done.
+func WebsocketProxy(a *websocket.Conn, b *websocket.Conn) chan bool {
+	forward := func(src *websocket.Conn, dst *websocket.Conn, done chan bool) {
+		for {
+			mt, r, err := src.NextReader()
+			if err != nil {
+				Debugf("Got error getting next reader %s", err)
+				break
+			}
+
+			w, err := dst.NextWriter(mt)
+			if err != nil {
+				Debugf("Got error getting next writer %s", err)
+				break
+			}
+
+			_, err = io.Copy(w, r)
+			w.Close()
+			if err != nil {
+				Debugf("Got error relaying message %s", err)
+				break
+			}
+		}
+
+		closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		dst.WriteMessage(websocket.CloseMessage, closeMsg)
+		done <- true
+	}
+
+	aDone := make(chan bool, 1)
+	bDone := make(chan bool, 1)
+	go forward(a, b, bDone)
+	go forward(b, a, aDone)
+
+	ch := make(chan bool, 1)
+	go func() {
+		<-aDone
+		<-bDone
+		ch <- true
+	}()
+
+	return ch
+}
+
+// websocketConn is a connection carried by a websocket as binary messages,
+// the way WebsocketMirror relays it, a text message ending the stream.
+type websocketConn struct {
+	conn   *websocket.Conn
+	reader io.Reader
+
+	writeLock sync.Mutex
+}
+
+// WebsocketConn returns a net.Conn reading and writing through the websocket.
+func WebsocketConn(conn *websocket.Conn) net.Conn {
+	return &websocketConn{conn: conn}
+}
+
+func (c *websocketConn) Read(p []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			mt, r, err := c.conn.NextReader()
+			if err != nil {
+				if _, ok := err.(*websocket.CloseError); ok {
+					return 0, io.EOF
+				}
+
+				return 0, err
+			}
+
+			if mt != websocket.BinaryMessage {
+				return 0, io.EOF
+			}
+
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(p)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+
+			err = nil
+		}
+
+		return n, err
+	}
+}
+
+func (c *websocketConn) Write(p []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	err := c.conn.WriteMessage(websocket.BinaryMessage, p)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+func (c *websocketConn) Close() error {
+	c.writeLock.Lock()
+	closeMsg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+	c.conn.WriteMessage(websocket.CloseMessage, closeMsg)
+	c.writeLock.Unlock()
+
+	return c.conn.Close()
+}
+
+func (c *websocketConn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+func (c *websocketConn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+func (c *websocketConn) SetDeadline(t time.Time) error {
+	err := c.conn.SetReadDeadline(t)
+	if err != nil {
+		return err
+	}
+
+	return c.conn.SetWriteDeadline(t)
+}
+
+func (c *websocketConn) SetReadDeadline(t time.Time) error {
+	return c.conn.SetReadDeadline(t)
+}
+
+func (c *websocketConn) SetWriteDeadline(t time.Time) error {
+	return c.conn.SetWriteDeadline(t)
+}
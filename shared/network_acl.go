@@ -0,0 +1,160 @@
+package shared
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// NetworkACL is a named set of firewall rules, applied to the containers
+// whose security.acls key lists it and to all the containers on its
+// networks.
+type NetworkACL struct {
+	Name        string           `json:"name"`
+	Description string           `json:"description"`
+	Rules       []NetworkACLRule `json:"rules"`
+
+	// The bridges whose containers all get the rules
+	Networks []string `json:"networks"`
+
+	// URLs of the containers and profiles listing it in security.acls
+	UsedBy []string `json:"used_by"`
+}
+
+// NetworkACLRule matches traffic of a container, the first matching rule
+// deciding what happens to it and traffic no rule matches being allowed.
+type NetworkACLRule struct {
+	// "ingress" for the traffic to the container, "egress" for the traffic
+	// it sends
+	Direction string `json:"direction"`
+
+	// "allow" or "drop"
+	Action string `json:"action"`
+
+	// "tcp", "udp", "icmp" or "icmpv6", any protocol when empty
+	Protocol string `json:"protocol"`
+
+	// Ports of the container for ingress, of the peer for egress, like
+	// "22", "80,443" or "8000-8099", only with tcp and udp
+	Port string `json:"port"`
+
+	// Addresses or subnets of the peer, separated by commas, any peer when
+	// empty
+	Subject string `json:"subject"`
+}
+
+var networkACLName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_-]*$`)
+
+// NetworkACLNameValid checks the name of an ACL.
+func NetworkACLNameValid(name string) error {
+	if !networkACLName.MatchString(name) {
+		return fmt.Errorf("Invalid ACL name: %s", name)
+	}
+
+	return nil
+}
+
+// Validate checks every field of the rule.
+func (r NetworkACLRule) Validate() error {
+	if !StringInSlice(r.Direction, []string{"ingress", "egress"}) {
+		return fmt.Errorf("Invalid rule direction, must be ingress or egress: %s", r.Direction)
+	}
+
+	if !StringInSlice(r.Action, []string{"allow", "drop"}) {
+		return fmt.Errorf("Invalid rule action, must be allow or drop: %s", r.Action)
+	}
+
+	if !StringInSlice(r.Protocol, []string{"", "tcp", "udp", "icmp", "icmpv6"}) {
+		return fmt.Errorf("Invalid rule protocol: %s", r.Protocol)
+	}
+
+	if r.Port != "" {
+		if r.Protocol != "tcp" && r.Protocol != "udp" {
+			return fmt.Errorf("Rule ports need the tcp or udp protocol")
+		}
+
+		_, err := NetworkACLPorts(r.Port)
+		if err != nil {
+			return err
+		}
+	}
+
+	v4, v6, err := NetworkACLSubjects(r.Subject)
+	if err != nil {
+		return err
+	}
+
+	if (r.Protocol == "icmp" && len(v4) == 0 && len(v6) > 0) || (r.Protocol == "icmpv6" && len(v6) == 0 && len(v4) > 0) {
+		return fmt.Errorf("The rule subject doesn't match its protocol %s", r.Protocol)
+	}
+
+	return nil
+}
+
+// NetworkACLPorts splits the port field of a rule into ports and ranges of
+// ports.
+func NetworkACLPorts(value string) ([]string, error) {
+	ports := []string{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		fields := strings.SplitN(entry, "-", 2)
+
+		bounds := []int{}
+		for _, field := range fields {
+			port, err := strconv.Atoi(field)
+			if err != nil || port < 1 || port > 65535 {
+				return nil, fmt.Errorf("Invalid rule port: %s", entry)
+			}
+
+			bounds = append(bounds, port)
+		}
+
+		if len(bounds) == 2 && bounds[0] >= bounds[1] {
+			return nil, fmt.Errorf("Invalid rule port range: %s", entry)
+		}
+
+		ports = append(ports, entry)
+	}
+
+	return ports, nil
+}
+
+// NetworkACLSubjects splits the subject field of a rule into its IPv4 and
+// IPv6 subnets, single addresses becoming /32 and /128.
+func NetworkACLSubjects(value string) ([]string, []string, error) {
+	v4 := []string{}
+	v6 := []string{}
+	if value == "" {
+		return v4, v6, nil
+	}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+
+		_, subnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, nil, fmt.Errorf("Invalid rule subject: %s", entry)
+			}
+
+			bits := 128
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 32
+			}
+
+			subnet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		}
+
+		if subnet.IP.To4() != nil {
+			v4 = append(v4, subnet.String())
+		} else {
+			v6 = append(v6, subnet.String())
+		}
+	}
+
+	return v4, v6, nil
+}
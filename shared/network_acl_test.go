@@ -0,0 +1,68 @@
+package shared
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNetworkACLRuleValidate(t *testing.T) {
+	for _, rule := range []NetworkACLRule{
+		{Direction: "ingress", Action: "allow"},
+		{Direction: "egress", Action: "drop", Protocol: "tcp", Port: "80,443,8000-8099"},
+		{Direction: "ingress", Action: "allow", Protocol: "icmp", Subject: "10.0.0.0/8"},
+		{Direction: "ingress", Action: "allow", Protocol: "icmpv6", Subject: "fd00::1"},
+		{Direction: "egress", Action: "drop", Subject: "10.0.0.1, fd00::/64"},
+	} {
+		err := rule.Validate()
+		if err != nil {
+			t.Errorf("Refused the rule %+v: %s", rule, err)
+		}
+	}
+
+	for _, rule := range []NetworkACLRule{
+		{Direction: "in", Action: "allow"},
+		{Direction: "ingress", Action: "reject"},
+		{Direction: "ingress", Action: "allow", Protocol: "sctp"},
+		{Direction: "ingress", Action: "allow", Port: "22"},
+		{Direction: "ingress", Action: "allow", Protocol: "icmp", Port: "22"},
+		{Direction: "ingress", Action: "allow", Protocol: "tcp", Port: "0"},
+		{Direction: "ingress", Action: "allow", Protocol: "tcp", Port: "90-80"},
+		{Direction: "ingress", Action: "allow", Protocol: "udp", Port: "65536"},
+		{Direction: "ingress", Action: "allow", Subject: "10.0.0.300"},
+		{Direction: "ingress", Action: "allow", Protocol: "icmp", Subject: "fd00::1"},
+		{Direction: "ingress", Action: "allow", Protocol: "icmpv6", Subject: "10.0.0.1"},
+	} {
+		if rule.Validate() == nil {
+			t.Errorf("Accepted the rule %+v", rule)
+		}
+	}
+}
+
+func TestNetworkACLSubjects(t *testing.T) {
+	v4, v6, err := NetworkACLSubjects("10.0.0.1, 192.168.1.17/24,fd00::1,fd00:1::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(v4, []string{"10.0.0.1/32", "192.168.1.0/24"}) {
+		t.Errorf("Wrong IPv4 subjects: %v", v4)
+	}
+
+	if !reflect.DeepEqual(v6, []string{"fd00::1/128", "fd00:1::/64"}) {
+		t.Errorf("Wrong IPv6 subjects: %v", v6)
+	}
+}
+
+func TestNetworkACLNameValid(t *testing.T) {
+	for _, name := range []string{"web", "web-1", "db_2"} {
+		if NetworkACLNameValid(name) != nil {
+			t.Errorf("Refused the name %q", name)
+		}
+	}
+
+	for _, name := range []string{"", "-web", "web/1", "web acl"} {
+		if NetworkACLNameValid(name) == nil {
+			t.Errorf("Accepted the name %q", name)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package shared
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// websocketPair returns both ends of a websocket.
+func websocketPair(t *testing.T) (*websocket.Conn, *websocket.Conn) {
+	server := make(chan *websocket.Conn, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := WebsocketUpgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		server <- conn
+	}))
+	defer ts.Close()
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return client, <-server
+}
+
+func TestWebsocketProxy(t *testing.T) {
+	source, sourceRelay := websocketPair(t)
+	sinkRelay, sink := websocketPair(t)
+	defer source.Close()
+	defer sink.Close()
+
+	done := WebsocketProxy(sourceRelay, sinkRelay)
+
+	messages := []struct {
+		mt   int
+		data string
+	}{
+		{websocket.BinaryMessage, "data"},
+		{websocket.TextMessage, ""},
+		{websocket.BinaryMessage, "more data"},
+	}
+
+	for _, msg := range messages {
+		err := source.WriteMessage(msg.mt, []byte(msg.data))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		mt, data, err := sink.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mt != msg.mt || string(data) != msg.data {
+			t.Errorf("Got message %d %q instead of %d %q", mt, data, msg.mt, msg.data)
+		}
+	}
+
+	err := sink.WriteMessage(websocket.TextMessage, []byte("reply"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, data, err := source.ReadMessage()
+	if err != nil || string(data) != "reply" {
+		t.Errorf("Got %q (%v) instead of the reply", data, err)
+	}
+
+	// Closing one end closes the other one
+	source.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	_, _, err = sink.ReadMessage()
+	if _, ok := err.(*websocket.CloseError); !ok {
+		t.Errorf("The sink wasn't closed: %v", err)
+	}
+
+	<-done
+}
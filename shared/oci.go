@@ -0,0 +1,471 @@
+package shared
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// Markers used by OCI image layers to delete files from the layers below.
+const ociWhiteoutPrefix = ".wh."
+const ociWhiteoutOpaque = ".wh..wh..opq"
+
+var ociArchitectures = map[string]string{
+	"386":     "i686",
+	"amd64":   "x86_64",
+	"arm64":   "aarch64",
+	"ppc64":   "ppc64",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// OCIArchitecture returns our name for the architecture of an OCI image.
+func OCIArchitecture(arch string, variant string) (string, error) {
+	if arch == "arm" && (variant == "" || variant == "v7") {
+		return "armv7l", nil
+	}
+
+	name, ok := ociArchitectures[arch]
+	if !ok {
+		return "", fmt.Errorf("Unsupported OCI architecture: %s %s", arch, variant)
+	}
+
+	return name, nil
+}
+
+// OCIArchitectureName is the reverse of OCIArchitecture, the variant is only
+// set for ARM.
+func OCIArchitectureName(arch string) (string, string, error) {
+	if arch == "armv7l" {
+		return "arm", "v7", nil
+	}
+
+	for name, entry := range ociArchitectures {
+		if entry == arch {
+			return name, "", nil
+		}
+	}
+
+	return "", "", fmt.Errorf("Architecture isn't supported: %s", arch)
+}
+
+// ociTarReader reads a tarball, gzip compressed or not.
+func ociTarReader(r io.Reader) (*tar.Reader, error) {
+	buf := bufio.NewReader(r)
+	magic, err := buf.Peek(4)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		return tar.NewReader(gz), nil
+	}
+
+	if bytes.Equal(magic, []byte{0x28, 0xb5, 0x2f, 0xfd}) {
+		return nil, fmt.Errorf("zstd compressed tarballs aren't supported")
+	}
+
+	return tar.NewReader(buf), nil
+}
+
+// ociLayerOpen opens a layer tarball.
+func ociLayerOpen(name string) (*tar.Reader, io.Closer, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tr, err := ociTarReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	return tr, f, nil
+}
+
+// ociPath normalizes a path found in a layer, "." being the root.
+func ociPath(name string) string {
+	return path.Clean(strings.TrimPrefix(path.Clean("/"+name), "/"))
+}
+
+// ociAncestors returns the parent directories of a path, closest first.
+func ociAncestors(name string) []string {
+	ancestors := []string{}
+	for name != "." && name != "/" {
+		name = path.Dir(name)
+		ancestors = append(ancestors, name)
+	}
+
+	return ancestors
+}
+
+// ociLayers tracks, for each path, the last layer which touched it in some
+// way, which is all that's needed to tell whether an entry survives.
+type ociLayers struct {
+	written map[string]int
+	removed map[string]int
+	opaque  map[string]int
+	nonDir  map[string]int
+}
+
+func (l *ociLayers) scan(i int, hdr *tar.Header) {
+	name := ociPath(hdr.Name)
+	dir, base := path.Split(name)
+	dir = ociPath(dir)
+
+	if base == ociWhiteoutOpaque {
+		l.opaque[dir] = i
+		return
+	}
+
+	if strings.HasPrefix(base, ociWhiteoutPrefix) {
+		l.removed[path.Join(dir, strings.TrimPrefix(base, ociWhiteoutPrefix))] = i
+		return
+	}
+
+	l.written[name] = i
+	if hdr.Typeflag != tar.TypeDir {
+		l.nonDir[name] = i
+	}
+}
+
+// keep tells whether the entry of layer i is still visible in the final tree.
+func (l *ociLayers) keep(i int, name string) bool {
+	if l.written[name] > i {
+		return false
+	}
+
+	if removed, ok := l.removed[name]; ok && removed > i {
+		return false
+	}
+
+	for _, ancestor := range ociAncestors(name) {
+		for _, changes := range []map[string]int{l.removed, l.opaque, l.nonDir} {
+			if layer, ok := changes[ancestor]; ok && layer > i {
+				return false
+			}
+		}
+	}
+
+	return true
+}
+
+// OCIFlatten writes the union of the layer tarballs, lowest layer first, to
+// tw with all paths under prefix. Whiteouts are applied instead of being
+// copied so the result is a plain filesystem tree.
+func OCIFlatten(layers []string, tw *tar.Writer, prefix string) error {
+	state := ociLayers{
+		written: map[string]int{},
+		removed: map[string]int{},
+		opaque:  map[string]int{},
+		nonDir:  map[string]int{},
+	}
+
+	walk := func(fn func(i int, hdr *tar.Header, tr *tar.Reader) error) error {
+		for i, layer := range layers {
+			tr, closer, err := ociLayerOpen(layer)
+			if err != nil {
+				return err
+			}
+
+			for {
+				hdr, err := tr.Next()
+				if err == io.EOF {
+					break
+				}
+
+				if err == nil {
+					err = fn(i, hdr, tr)
+				}
+
+				if err != nil {
+					closer.Close()
+					return fmt.Errorf("Bad layer %d: %v", i, err)
+				}
+			}
+
+			closer.Close()
+		}
+
+		return nil
+	}
+
+	// First find out what each layer overrides, then copy what's left
+	err := walk(func(i int, hdr *tar.Header, tr *tar.Reader) error {
+		state.scan(i, hdr)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return walk(func(i int, hdr *tar.Header, tr *tar.Reader) error {
+		name := ociPath(hdr.Name)
+		if strings.HasPrefix(path.Base(name), ociWhiteoutPrefix) || !state.keep(i, name) {
+			return nil
+		}
+
+		hdr.Name = path.Join(prefix, name)
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = path.Join(prefix, ociPath(hdr.Linkname))
+		}
+
+		err := tw.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, tr)
+		return err
+	})
+}
+
+// ociBlob is a blob of an OCI image layout, the layer's content isn't kept
+// in memory.
+type ociBlob struct {
+	mediaType string
+	digest    string
+	size      int64
+	data      []byte
+}
+
+func ociBlobJSON(mediaType string, v interface{}) (*ociBlob, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ociBlob{
+		mediaType: mediaType,
+		digest:    fmt.Sprintf("sha256:%x", sha256.Sum256(data)),
+		size:      int64(len(data)),
+		data:      data,
+	}, nil
+}
+
+func (b *ociBlob) descriptor() map[string]interface{} {
+	return map[string]interface{}{
+		"mediaType": b.mediaType,
+		"digest":    b.digest,
+		"size":      b.size,
+	}
+}
+
+func (b *ociBlob) path() string {
+	return "blobs/sha256/" + strings.TrimPrefix(b.digest, "sha256:")
+}
+
+// OCIFromImage turns an image tarball (metadata and rootfs in one, gzip
+// compressed or not) into a tarball holding an OCI image layout with a single
+// layer, which docker load understands too. The image is tagged with each of
+// the tags and the labels end up in the image config.
+func OCIFromImage(image io.Reader, w io.Writer, arch string, created time.Time, tags []string, labels map[string]string) error {
+	ociArch, variant, err := OCIArchitectureName(arch)
+	if err != nil {
+		return err
+	}
+
+	tr, err := ociTarReader(image)
+	if err != nil {
+		return err
+	}
+
+	// The rootfs becomes the only layer, it's kept uncompressed
+	layerFile, err := ioutil.TempFile("", "lxd_oci_layer_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(layerFile.Name())
+	defer layerFile.Close()
+
+	hash := sha256.New()
+	counter := &ociCounter{}
+	layer := tar.NewWriter(io.MultiWriter(layerFile, hash, counter))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		name := ociPath(hdr.Name)
+		if name != "rootfs" && !strings.HasPrefix(name, "rootfs/") {
+			continue
+		}
+
+		hdr.Name = ociPath(strings.TrimPrefix(name, "rootfs"))
+		if hdr.Typeflag == tar.TypeDir {
+			hdr.Name += "/"
+		}
+
+		if hdr.Typeflag == tar.TypeLink {
+			hdr.Linkname = ociPath(strings.TrimPrefix(ociPath(hdr.Linkname), "rootfs"))
+		}
+
+		err = layer.WriteHeader(hdr)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(layer, tr)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = layer.Close()
+	if err != nil {
+		return err
+	}
+
+	layerBlob := &ociBlob{
+		mediaType: "application/vnd.oci.image.layer.v1.tar",
+		digest:    fmt.Sprintf("sha256:%x", hash.Sum(nil)),
+		size:      counter.size,
+	}
+
+	config := map[string]interface{}{
+		"architecture": ociArch,
+		"os":           "linux",
+		"created":      created.UTC().Format(time.RFC3339),
+		"config": map[string]interface{}{
+			"Cmd":    []string{"/sbin/init"},
+			"Labels": labels,
+		},
+		"rootfs": map[string]interface{}{
+			"type":     "layers",
+			"diff_ids": []string{layerBlob.digest},
+		},
+	}
+
+	if variant != "" {
+		config["variant"] = variant
+	}
+
+	configBlob, err := ociBlobJSON("application/vnd.oci.image.config.v1+json", config)
+	if err != nil {
+		return err
+	}
+
+	manifestBlob, err := ociBlobJSON("application/vnd.oci.image.manifest.v1+json", map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.manifest.v1+json",
+		"config":        configBlob.descriptor(),
+		"layers":        []interface{}{layerBlob.descriptor()},
+	})
+	if err != nil {
+		return err
+	}
+
+	manifests := []interface{}{}
+	for _, tag := range tags {
+		descriptor := manifestBlob.descriptor()
+		descriptor["annotations"] = map[string]string{"org.opencontainers.image.ref.name": tag}
+		manifests = append(manifests, descriptor)
+	}
+
+	if len(manifests) == 0 {
+		manifests = append(manifests, manifestBlob.descriptor())
+	}
+
+	index, err := json.Marshal(map[string]interface{}{
+		"schemaVersion": 2,
+		"mediaType":     "application/vnd.oci.image.index.v1+json",
+		"manifests":     manifests,
+	})
+	if err != nil {
+		return err
+	}
+
+	// What docker load looks for
+	dockerManifest, err := json.Marshal([]map[string]interface{}{{
+		"Config":   configBlob.path(),
+		"RepoTags": tags,
+		"Layers":   []string{layerBlob.path()},
+	}})
+	if err != nil {
+		return err
+	}
+
+	out := tar.NewWriter(w)
+	writeFile := func(name string, data []byte) error {
+		err := out.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(data)), ModTime: created, Typeflag: tar.TypeReg})
+		if err != nil {
+			return err
+		}
+
+		_, err = out.Write(data)
+		return err
+	}
+
+	err = writeFile("oci-layout", []byte(`{"imageLayoutVersion": "1.0.0"}`))
+	if err != nil {
+		return err
+	}
+
+	for _, blob := range []*ociBlob{configBlob, manifestBlob} {
+		err = writeFile(blob.path(), blob.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = out.WriteHeader(&tar.Header{Name: layerBlob.path(), Mode: 0644, Size: layerBlob.size, ModTime: created, Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+
+	_, err = layerFile.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, layerFile)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile("index.json", index)
+	if err != nil {
+		return err
+	}
+
+	err = writeFile("manifest.json", dockerManifest)
+	if err != nil {
+		return err
+	}
+
+	return out.Close()
+}
+
+type ociCounter struct {
+	size int64
+}
+
+func (c *ociCounter) Write(p []byte) (int, error) {
+	c.size += int64(len(p))
+	return len(p), nil
+}
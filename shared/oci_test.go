@@ -0,0 +1,244 @@
+package shared
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+type ociTestEntry struct {
+	name    string
+	content string
+}
+
+func ociTestLayer(t *testing.T, dir string, name string, compress bool, entries []ociTestEntry) string {
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	for _, entry := range entries {
+		hdr := &tar.Header{Name: entry.name, Mode: 0644, Typeflag: tar.TypeReg, Size: int64(len(entry.content))}
+		if strings.HasSuffix(entry.name, "/") {
+			hdr = &tar.Header{Name: entry.name, Mode: 0755, Typeflag: tar.TypeDir}
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tw.Close()
+
+	data := buf.Bytes()
+	if compress {
+		gzBuf := &bytes.Buffer{}
+		gz := gzip.NewWriter(gzBuf)
+		gz.Write(data)
+		gz.Close()
+		data = gzBuf.Bytes()
+	}
+
+	layer := path.Join(dir, name)
+	if err := ioutil.WriteFile(layer, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return layer
+}
+
+func TestOCIFlatten(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	long := strings.Repeat("x", 120)
+	layers := []string{
+		ociTestLayer(t, dir, "0", true, []ociTestEntry{
+			{"./", ""},
+			{"etc/", ""},
+			{"etc/hostname", "old"},
+			{"etc/removed", "gone"},
+			{"var/", ""},
+			{"var/cache/", ""},
+			{"var/cache/a", "gone"},
+			{"opt/", ""},
+			{"opt/app/", ""},
+			{"opt/app/bin", "gone"},
+			{"usr/" + long, "long"},
+		}),
+		ociTestLayer(t, dir, "1", false, []ociTestEntry{
+			{"etc/hostname", "new"},
+			{"etc/.wh.removed", ""},
+			{"var/cache/.wh..wh..opq", ""},
+			{"var/cache/b", "kept"},
+			{"opt/app", "now a file"},
+		}),
+	}
+
+	buf := &bytes.Buffer{}
+	tw := tar.NewWriter(buf)
+	err = OCIFlatten(layers, tw, "rootfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+
+	result := map[string]string{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+
+		content, _ := ioutil.ReadAll(tr)
+		result[hdr.Name] = string(content)
+	}
+
+	names := []string{}
+	for name := range result {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	expected := []string{
+		"rootfs/",
+		"rootfs/etc/",
+		"rootfs/etc/hostname",
+		"rootfs/opt/",
+		"rootfs/opt/app",
+		"rootfs/usr/" + long,
+		"rootfs/var/",
+		"rootfs/var/cache/",
+		"rootfs/var/cache/b",
+	}
+
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("Wrong flattened tree: %v", names)
+	}
+
+	if result["rootfs/etc/hostname"] != "new" || result["rootfs/opt/app"] != "now a file" {
+		t.Errorf("Files weren't overridden: %v", result)
+	}
+}
+
+func TestOCIFromImage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "oci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	image := ociTestLayer(t, dir, "image", true, []ociTestEntry{
+		{"metadata.yaml", "architecture: x86_64\n"},
+		{"rootfs/", ""},
+		{"rootfs/etc/", ""},
+		{"rootfs/etc/hostname", "c1"},
+		{"templates/", ""},
+	})
+
+	f, err := os.Open(image)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	buf := &bytes.Buffer{}
+	err = OCIFromImage(f, buf, "x86_64", time.Unix(0, 0), []string{"c1:latest"}, map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(buf)
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+
+		files[hdr.Name], _ = ioutil.ReadAll(tr)
+	}
+
+	docker := []struct {
+		Config   string
+		RepoTags []string
+		Layers   []string
+	}{}
+	err = json.Unmarshal(files["manifest.json"], &docker)
+	if err != nil || len(docker) != 1 || len(docker[0].Layers) != 1 {
+		t.Fatalf("Bad docker manifest: %s", files["manifest.json"])
+	}
+
+	if !reflect.DeepEqual(docker[0].RepoTags, []string{"c1:latest"}) {
+		t.Errorf("Wrong tags: %v", docker[0].RepoTags)
+	}
+
+	layer := files[docker[0].Layers[0]]
+	if fmt.Sprintf("blobs/sha256/%x", sha256.Sum256(layer)) != docker[0].Layers[0] {
+		t.Errorf("Layer digest doesn't match its content")
+	}
+
+	names := []string{}
+	tr = tar.NewReader(bytes.NewReader(layer))
+	for {
+		hdr, err := tr.Next()
+		if err != nil {
+			break
+		}
+
+		names = append(names, hdr.Name)
+	}
+
+	if !reflect.DeepEqual(names, []string{"./", "etc/", "etc/hostname"}) {
+		t.Errorf("Wrong layer content: %v", names)
+	}
+
+	config := map[string]interface{}{}
+	err = json.Unmarshal(files[docker[0].Config], &config)
+	if err != nil || config["architecture"] != "amd64" {
+		t.Errorf("Bad image config: %s", files[docker[0].Config])
+	}
+
+	for _, name := range []string{"oci-layout", "index.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("Missing %s", name)
+		}
+	}
+}
+
+func TestOCIArchitecture(t *testing.T) {
+	arch, err := OCIArchitecture("amd64", "")
+	if err != nil || arch != "x86_64" {
+		t.Errorf("Wrong architecture for amd64: %s %v", arch, err)
+	}
+
+	arch, err = OCIArchitecture("arm", "v7")
+	if err != nil || arch != "armv7l" {
+		t.Errorf("Wrong architecture for arm v7: %s %v", arch, err)
+	}
+
+	_, err = OCIArchitecture("arm", "v5")
+	if err == nil {
+		t.Error("Unsupported architecture wasn't caught")
+	}
+
+	name, variant, err := OCIArchitectureName("aarch64")
+	if err != nil || name != "arm64" || variant != "" {
+		t.Errorf("Wrong OCI name for aarch64: %s %s %v", name, variant, err)
+	}
+}
@@ -24,4 +24,9 @@ type Operation struct {
 	Metadata   *Jmap               `json:"metadata"`
 	MayCancel  bool                `json:"may_cancel"`
 	Err        string              `json:"err"`
+
+	// The request which created the operation and the client which sent
+	// it, the fingerprint of its certificate or "unix"
+	Description string `json:"description"`
+	Initiator   string `json:"initiator"`
 }
@@ -0,0 +1,33 @@
+package shared
+
+// Preseed is a declarative description of a daemon's setup, applying it
+// only changes what differs.
+type Preseed struct {
+	// Server config keys, including those of the storage backend
+	Config map[string]string `json:"config"`
+
+	Networks []PreseedNetwork `json:"networks"`
+	Profiles []ProfileConfig  `json:"profiles"`
+	Aliases  []PreseedAlias   `json:"aliases"`
+}
+
+// PreseedNetwork is a host network the containers may get connected to.
+type PreseedNetwork struct {
+	Name string `json:"name"`
+
+	// Only "bridge" is supported
+	Type string `json:"type"`
+}
+
+// PreseedAlias is an image alias, its target being the fingerprint (or a
+// unique prefix of it) of an image the daemon already has.
+type PreseedAlias struct {
+	Name        string `json:"name"`
+	Target      string `json:"target"`
+	Description string `json:"description"`
+}
+
+// PreseedResult lists what applying a preseed changed.
+type PreseedResult struct {
+	Changes []string `json:"changes"`
+}
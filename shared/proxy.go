@@ -0,0 +1,336 @@
+package shared
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ParseProxyURL parses the address of a proxy: http://, https://, socks5://
+// (names resolved by the client) or socks5h:// (by the proxy), a plain
+// host:port being an HTTP proxy.
+func ParseProxyURL(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+
+	u, err := url.Parse(value)
+	if err != nil {
+		return nil, err
+	}
+
+	if !StringInSlice(u.Scheme, []string{"http", "https", "socks5", "socks5h"}) {
+		return nil, fmt.Errorf("Unsupported proxy scheme: %s", u.Scheme)
+	}
+
+	if u.Hostname() == "" {
+		return nil, fmt.Errorf("Invalid proxy: %s", value)
+	}
+
+	return u, nil
+}
+
+func proxyEnv(name string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		value = os.Getenv(strings.ToLower(name))
+	}
+
+	return value
+}
+
+// ProxyFromEnvironment returns the proxy to connect to the address through,
+// from HTTPS_PROXY or else ALL_PROXY, nil when there's none or when the host
+// is on the loopback or excluded by NO_PROXY.
+func ProxyFromEnvironment(address string) (*url.URL, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	if host == "localhost" || (ip != nil && ip.IsLoopback()) {
+		return nil, nil
+	}
+
+	if proxyExcluded(host, proxyEnv("NO_PROXY")) {
+		return nil, nil
+	}
+
+	for _, name := range []string{"HTTPS_PROXY", "ALL_PROXY"} {
+		value := proxyEnv(name)
+		if value != "" {
+			return ParseProxyURL(value)
+		}
+	}
+
+	return nil, nil
+}
+
+// proxyExcluded returns whether the host matches NO_PROXY, a comma separated
+// list of domains (matching their subdomains too), IP addresses, CIDR ranges
+// or "*".
+func proxyExcluded(host string, noProxy string) bool {
+	ip := net.ParseIP(host)
+
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+
+		if entry == "*" {
+			return true
+		}
+
+		_, cidr, err := net.ParseCIDR(entry)
+		if err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+
+			continue
+		}
+
+		entryHost, _, err := net.SplitHostPort(entry)
+		if err == nil {
+			entry = entryHost
+		}
+
+		entry = strings.TrimPrefix(entry, ".")
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ProxyDialer returns a dialer connecting through the proxy, with HTTP
+// CONNECT or SOCKS5, dial being used to reach the proxy itself.
+func ProxyDialer(proxy *url.URL, dial func(network, address string) (net.Conn, error)) func(network, address string) (net.Conn, error) {
+	return func(network, address string) (net.Conn, error) {
+		proxyAddress := proxy.Host
+		if proxy.Port() == "" {
+			port := map[string]string{"http": "80", "https": "443", "socks5": "1080", "socks5h": "1080"}[proxy.Scheme]
+			proxyAddress = net.JoinHostPort(proxy.Hostname(), port)
+		}
+
+		conn, err := dial("tcp", proxyAddress)
+		if err != nil {
+			return nil, err
+		}
+
+		var tunnel net.Conn
+		switch proxy.Scheme {
+		case "socks5", "socks5h":
+			tunnel, err = proxySocks5Connect(conn, proxy, address)
+		case "https":
+			conn = tls.Client(conn, &tls.Config{ServerName: proxy.Hostname()})
+			tunnel, err = proxyHTTPConnect(conn, proxy, address)
+		default:
+			tunnel, err = proxyHTTPConnect(conn, proxy, address)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+
+		return tunnel, nil
+	}
+}
+
+// bufferedConn is a connection some of whose data was already read in a
+// buffer.
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func proxyHTTPConnect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: http.Header{},
+	}
+
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+
+	err := req.Write(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Proxy refused the connection to %s: %s", address, resp.Status)
+	}
+
+	if reader.Buffered() > 0 {
+		return &bufferedConn{conn, reader}, nil
+	}
+
+	return conn, nil
+}
+
+// proxySocks5Connect negotiates a connection to the address with a SOCKS5
+// proxy as of RFC 1928, authenticating with a username and password (RFC
+// 1929) when the proxy URL has some.
+func proxySocks5Connect(conn net.Conn, proxy *url.URL, address string) (net.Conn, error) {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, err
+	}
+
+	// socks5:// leaves the name resolution to the client
+	if proxy.Scheme == "socks5" && net.ParseIP(host) == nil {
+		addrs, err := net.LookupHost(host)
+		if err != nil {
+			return nil, err
+		}
+
+		host = addrs[0]
+	}
+
+	methods := []byte{0x00}
+	if proxy.User != nil {
+		methods = append(methods, 0x02)
+	}
+
+	_, err = conn.Write(append([]byte{0x05, byte(len(methods))}, methods...))
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, 2)
+	_, err = io.ReadFull(conn, reply)
+	if err != nil {
+		return nil, err
+	}
+
+	if reply[0] != 0x05 {
+		return nil, fmt.Errorf("Not a SOCKS5 proxy: %s", proxy.Host)
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		if proxy.User == nil {
+			return nil, fmt.Errorf("SOCKS5 proxy requires authentication")
+		}
+
+		username := proxy.User.Username()
+		password, _ := proxy.User.Password()
+		if len(username) > 255 || len(password) > 255 {
+			return nil, fmt.Errorf("SOCKS5 credentials are too long")
+		}
+
+		msg := []byte{0x01, byte(len(username))}
+		msg = append(msg, username...)
+		msg = append(msg, byte(len(password)))
+		msg = append(msg, password...)
+
+		_, err = conn.Write(msg)
+		if err != nil {
+			return nil, err
+		}
+
+		_, err = io.ReadFull(conn, reply)
+		if err != nil {
+			return nil, err
+		}
+
+		if reply[1] != 0x00 {
+			return nil, fmt.Errorf("SOCKS5 proxy authentication failed")
+		}
+	default:
+		return nil, fmt.Errorf("No supported SOCKS5 authentication method")
+	}
+
+	req := []byte{0x05, 0x01, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		if len(host) > 255 {
+			return nil, fmt.Errorf("Host name too long: %s", host)
+		}
+
+		req = append(req, 0x03, byte(len(host)))
+		req = append(req, host...)
+	} else if ip.To4() != nil {
+		req = append(req, 0x01)
+		req = append(req, ip.To4()...)
+	} else {
+		req = append(req, 0x04)
+		req = append(req, ip.To16()...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+
+	_, err = conn.Write(req)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 4)
+	_, err = io.ReadFull(conn, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header[1] != 0x00 {
+		return nil, fmt.Errorf("SOCKS5 proxy refused the connection to %s: error %d", address, header[1])
+	}
+
+	// Skip the address the proxy bound and its port
+	length := 0
+	switch header[3] {
+	case 0x01:
+		length = net.IPv4len
+	case 0x04:
+		length = net.IPv6len
+	case 0x03:
+		_, err = io.ReadFull(conn, reply[:1])
+		if err != nil {
+			return nil, err
+		}
+
+		length = int(reply[0])
+	default:
+		return nil, fmt.Errorf("Invalid SOCKS5 address type: %d", header[3])
+	}
+
+	_, err = io.ReadFull(conn, make([]byte, length+2))
+	if err != nil {
+		return nil, err
+	}
+
+	return conn, nil
+}
@@ -0,0 +1,196 @@
+package shared
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+)
+
+func TestParseProxyURL(t *testing.T) {
+	tests := map[string]string{
+		"proxy:3128":                  "http://proxy:3128",
+		"http://proxy:3128":           "http://proxy:3128",
+		"socks5h://user:pass@gw:1080": "socks5h://user:pass@gw:1080",
+		"https://proxy.example.com":   "https://proxy.example.com",
+		"ftp://proxy:21":              "",
+		"http://":                     "",
+	}
+
+	for value, expected := range tests {
+		u, err := ParseProxyURL(value)
+		if expected == "" {
+			if err == nil {
+				t.Errorf("Invalid proxy %q was accepted", value)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", value, err)
+			continue
+		}
+
+		if u.String() != expected {
+			t.Errorf("Got %q instead of %q for %q", u.String(), expected, value)
+		}
+	}
+}
+
+func TestProxyFromEnvironment(t *testing.T) {
+	for _, name := range []string{"HTTPS_PROXY", "https_proxy", "ALL_PROXY", "all_proxy", "NO_PROXY", "no_proxy"} {
+		defer os.Setenv(name, os.Getenv(name))
+		os.Unsetenv(name)
+	}
+
+	os.Setenv("ALL_PROXY", "socks5h://gw:1080")
+	os.Setenv("NO_PROXY", "example.com, 10.0.0.0/8")
+
+	tests := map[string]string{
+		"lxd.example.org:8443": "socks5h://gw:1080",
+		"lxd.example.com:8443": "",
+		"example.com:8443":     "",
+		"10.1.2.3:8443":        "",
+		"127.0.0.1:8443":       "",
+		"[::1]:8443":           "",
+		"localhost:8443":       "",
+	}
+
+	for address, expected := range tests {
+		u, err := ProxyFromEnvironment(address)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (u == nil && expected != "") || (u != nil && u.String() != expected) {
+			t.Errorf("Got %v instead of %q for %s", u, expected, address)
+		}
+	}
+
+	// HTTPS_PROXY comes first
+	os.Setenv("HTTPS_PROXY", "proxy:3128")
+	u, err := ProxyFromEnvironment("lxd.example.org:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if u == nil || u.String() != "http://proxy:3128" {
+		t.Errorf("HTTPS_PROXY wasn't used: %v", u)
+	}
+}
+
+// proxyTestServer accepts a single connection, which serve negotiates a
+// tunnel on, and then echoes what it gets.
+func proxyTestServer(t *testing.T, serve func(conn net.Conn, reader *bufio.Reader) error) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go func() {
+		defer listener.Close()
+
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		err = serve(conn, reader)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+
+		io.Copy(conn, reader)
+	}()
+
+	return listener.Addr().String()
+}
+
+func proxyTestEcho(t *testing.T, proxy string) {
+	u, err := ParseProxyURL(proxy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := ProxyDialer(u, net.Dial)("tcp", "lxd.example.org:8443")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 5)
+	_, err = io.ReadFull(conn, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "hello" {
+		t.Errorf("Unexpected data through %s: %q", proxy, buf)
+	}
+}
+
+func TestProxyDialerHTTP(t *testing.T) {
+	address := proxyTestServer(t, func(conn net.Conn, reader *bufio.Reader) error {
+		req, err := http.ReadRequest(reader)
+		if err != nil {
+			return err
+		}
+
+		if req.Method != "CONNECT" || req.Host != "lxd.example.org:8443" {
+			conn.Write([]byte("HTTP/1.1 400 Bad Request\r\n\r\n"))
+			return nil
+		}
+
+		if req.Header.Get("Proxy-Authorization") != "Basic dXNlcjpwYXNz" {
+			conn.Write([]byte("HTTP/1.1 407 Proxy Authentication Required\r\n\r\n"))
+			return nil
+		}
+
+		_, err = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+		return err
+	})
+
+	proxyTestEcho(t, "http://user:pass@"+address)
+}
+
+func TestProxyDialerSocks5(t *testing.T) {
+	address := proxyTestServer(t, func(conn net.Conn, reader *bufio.Reader) error {
+		greeting := make([]byte, 3)
+		_, err := io.ReadFull(reader, greeting)
+		if err != nil {
+			return err
+		}
+
+		_, err = conn.Write([]byte{0x05, 0x00})
+		if err != nil {
+			return err
+		}
+
+		// The name is left to the proxy with socks5h
+		req := make([]byte, 5+len("lxd.example.org")+2)
+		_, err = io.ReadFull(reader, req)
+		if err != nil {
+			return err
+		}
+
+		if req[3] != 0x03 || string(req[5:5+req[4]]) != "lxd.example.org" || req[len(req)-2] != 8443>>8 || req[len(req)-1] != 8443&0xff {
+			conn.Write([]byte{0x05, 0x04, 0x00, 0x01, 0, 0, 0, 0, 0, 0})
+			return nil
+		}
+
+		_, err = conn.Write([]byte{0x05, 0x00, 0x00, 0x01, 127, 0, 0, 1, 0x1f, 0x90})
+		return err
+	})
+
+	proxyTestEcho(t, "socks5h://"+address)
+}
@@ -0,0 +1,70 @@
+package shared
+
+type ResourcesCPUCore struct {
+	Core     int   `json:"core"`
+	NUMANode int   `json:"numa_node"`
+	Threads  []int `json:"threads"`
+}
+
+type ResourcesCPUSocket struct {
+	Socket    int                `json:"socket"`
+	Vendor    string             `json:"vendor"`
+	Name      string             `json:"name"`
+	Frequency int64              `json:"frequency"`
+	Cores     []ResourcesCPUCore `json:"cores"`
+}
+
+type ResourcesCPU struct {
+	Sockets []ResourcesCPUSocket `json:"sockets"`
+	Total   int                  `json:"total"`
+}
+
+type ResourcesMemoryNode struct {
+	Node       int      `json:"node"`
+	CPUs       []int    `json:"cpus"`
+	Total      uint64   `json:"total"`
+	Used       uint64   `json:"used"`
+	Free       uint64   `json:"free"`
+	Containers []string `json:"containers"`
+}
+
+type ResourcesMemory struct {
+	Total uint64                `json:"total"`
+	Used  uint64                `json:"used"`
+	Nodes []ResourcesMemoryNode `json:"nodes"`
+}
+
+type ResourcesDisk struct {
+	Name      string `json:"name"`
+	Device    string `json:"device"`
+	Model     string `json:"model"`
+	Serial    string `json:"serial"`
+	Type      string `json:"type"`
+	Removable bool   `json:"removable"`
+	Size      uint64 `json:"size"`
+}
+
+type ResourcesNetworkCard struct {
+	Name       string `json:"name"`
+	Address    string `json:"address"`
+	Driver     string `json:"driver"`
+	PCIAddress string `json:"pci_address"`
+	VendorID   string `json:"vendor_id"`
+	ProductID  string `json:"product_id"`
+	Speed      int    `json:"speed"`
+}
+
+type ResourcesGPU struct {
+	PCIAddress string `json:"pci_address"`
+	Driver     string `json:"driver"`
+	VendorID   string `json:"vendor_id"`
+	ProductID  string `json:"product_id"`
+}
+
+type Resources struct {
+	CPU     ResourcesCPU           `json:"cpu"`
+	Memory  ResourcesMemory        `json:"memory"`
+	Disks   []ResourcesDisk        `json:"disks"`
+	Network []ResourcesNetworkCard `json:"network"`
+	GPUs    []ResourcesGPU         `json:"gpus"`
+}
@@ -5,6 +5,8 @@ type ServerStateEnvironment struct {
 	Architectures      []int    `json:"architectures"`
 	Driver             string   `json:"driver"`
 	DriverVersion      string   `json:"driver_version"`
+	Drivers            map[string]ServerStateDriver `json:"drivers"`
+	DriverFeatures     map[string]bool              `json:"driver_features"`
 	Kernel             string   `json:"kernel"`
 	KernelArchitecture string   `json:"kernel_architecture"`
 	KernelVersion      string   `json:"kernel_version"`
@@ -18,6 +20,16 @@ type ServerStateEnvironment struct {
 	Memory              string  `json:"memory"`
 }
 
+// ServerStateDriver is a runtime the containers can be run with.
+type ServerStateDriver struct {
+	Version      string   `json:"version"`
+	VM           bool     `json:"vm"`
+	Capabilities []string `json:"capabilities"`
+
+	// The capabilities it lacks on this host, with the missing feature
+	Missing map[string]string `json:"missing,omitempty"`
+}
+
 type ServerState struct {
 	APICompat   int                    `json:"api_compat"`
 	Auth        string                 `json:"auth"`
@@ -0,0 +1,28 @@
+package shared
+
+// StoragePoolThin is the usage of the LVM thin pool holding the containers,
+// in percent of its data and metadata space.
+type StoragePoolThin struct {
+	Name          string  `json:"name"`
+	DataUsage     float64 `json:"data_usage"`
+	MetadataUsage float64 `json:"metadata_usage"`
+}
+
+// StoragePool describes the space left on the storage backing the daemon.
+type StoragePool struct {
+	Driver        string `json:"driver"`
+	DriverVersion string `json:"driver_version"`
+
+	// The path, volume group or zpool the containers are stored on
+	Source string `json:"source"`
+
+	Total     uint64 `json:"total"`
+	Used      uint64 `json:"used"`
+	Available uint64 `json:"available"`
+
+	// Only reported by ZFS
+	Health string `json:"health,omitempty"`
+
+	// Only reported by LVM once the thin pool exists
+	ThinPool *StoragePoolThin `json:"thin_pool,omitempty"`
+}
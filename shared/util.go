@@ -97,7 +97,7 @@ func LogPath(path ...string) string {
 	return filepath.Join(items...)
 }
 
-func ParseLXDFileHeaders(headers http.Header) (uid int, gid int, mode os.FileMode) {
+func ParseLXDFileHeaders(headers http.Header) (uid int, gid int, mode os.FileMode, ftype string) {
 	uid, err := strconv.Atoi(headers.Get("X-LXD-uid"))
 	if err != nil {
 		uid = 0
@@ -116,7 +116,15 @@ func ParseLXDFileHeaders(headers http.Header) (uid int, gid int, mode os.FileMod
 	}
 	mode = os.FileMode(rawMode)
 
-	return uid, gid, mode
+	/* The file type is one of "file", "symlink", "char" or "block". For
+	 * symlinks the body is the link target, for devices it is the
+	 * "major:minor" device number. */
+	ftype = headers.Get("X-LXD-type")
+	if ftype == "" {
+		ftype = "file"
+	}
+
+	return uid, gid, mode, ftype
 }
 
 func ReadToJSON(r io.Reader, req interface{}) error {
@@ -271,6 +279,61 @@ func FileCopy(source string, dest string) error {
 	return err
 }
 
+// WriteSparse copies r into f, seeking over blocks of zeroes instead of
+// writing them so that the resulting file is sparse. The file is truncated
+// to the amount of data read, which also takes care of any trailing hole.
+func WriteSparse(f *os.File, r io.Reader) (int64, error) {
+	buf := make([]byte, 4096)
+	zero := make([]byte, 4096)
+	total := int64(0)
+
+	for {
+		n, err := io.ReadFull(r, buf)
+		if n > 0 {
+			if bytes.Equal(buf[:n], zero[:n]) {
+				_, err := f.Seek(int64(n), os.SEEK_CUR)
+				if err != nil {
+					return total, err
+				}
+			} else {
+				err := WriteAll(f, buf[:n])
+				if err != nil {
+					return total, err
+				}
+			}
+
+			total += int64(n)
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, f.Truncate(total)
+}
+
+// Major returns the major component of a Linux device number.
+func Major(dev uint64) int {
+	return int(((dev >> 8) & 0xfff) | ((dev >> 32) & ^uint64(0xfff)))
+}
+
+// Minor returns the minor component of a Linux device number.
+func Minor(dev uint64) int {
+	return int((dev & 0xff) | ((dev >> 12) & ^uint64(0xff)))
+}
+
+// Mkdev builds a Linux device number from its major and minor components.
+func Mkdev(major int, minor int) uint64 {
+	ma := uint64(major)
+	mi := uint64(minor)
+	return (mi & 0xff) | ((ma & 0xfff) << 8) | ((mi & ^uint64(0xff)) << 12) | ((ma & ^uint64(0xfff)) << 32)
+}
+
 type BytesReadCloser struct {
 	Buf *bytes.Buffer
 }
@@ -593,3 +656,30 @@ func ParseMetadata(metadata interface{}) (map[string]interface{}, error) {
 
 	return newMetadata, nil
 }
+
+// GetByteSizeString returns a human readable version of a size in bytes.
+func GetByteSizeString(input int64) string {
+	if input < 1024 {
+		return fmt.Sprintf("%dB", input)
+	}
+
+	value := float64(input)
+	for _, unit := range []string{"kB", "MB", "GB", "TB", "PB", "EB"} {
+		value = value / 1024
+		if value < 1024 {
+			return fmt.Sprintf("%.2f%s", value, unit)
+		}
+	}
+
+	return fmt.Sprintf("%.2fEB", value)
+}
+
+// IsTrue returns whether a boolean config value is set ("1" or "true").
+func IsTrue(value string) bool {
+	switch strings.ToLower(value) {
+	case "1", "true":
+		return true
+	}
+
+	return false
+}
@@ -1,6 +1,7 @@
 package shared
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -101,3 +102,66 @@ func TestReadLastNLines(t *testing.T) {
 		}
 	}
 }
+
+func TestWriteSparse(t *testing.T) {
+	content := append([]byte("hello"), make([]byte, 3*4096)...)
+	content = append(content, []byte("world")...)
+	content = append(content, make([]byte, 4096)...)
+
+	dest, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer os.Remove(dest.Name())
+	defer dest.Close()
+
+	n, err := WriteSparse(dest, bytes.NewReader(content))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if n != int64(len(content)) {
+		t.Error(fmt.Sprintf("wrote %d bytes, expected %d", n, len(content)))
+		return
+	}
+
+	written, err := ioutil.ReadFile(dest.Name())
+	if err != nil {
+		t.Error(err)
+		return
+	}
+
+	if !bytes.Equal(written, content) {
+		t.Error("content mismatch")
+		return
+	}
+}
+
+func TestMkdev(t *testing.T) {
+	for _, dev := range [][]int{{1, 3}, {8, 17}, {259, 65536}, {4095, 1048575}} {
+		rdev := Mkdev(dev[0], dev[1])
+		if Major(rdev) != dev[0] || Minor(rdev) != dev[1] {
+			t.Error(fmt.Sprintf("got %d:%d expected %d:%d", Major(rdev), Minor(rdev), dev[0], dev[1]))
+			return
+		}
+	}
+}
+
+func TestGetByteSizeString(t *testing.T) {
+	tests := map[int64]string{
+		0:                      "0B",
+		1023:                   "1023B",
+		1024:                   "1.00kB",
+		1536:                   "1.50kB",
+		5 * 1024 * 1024:        "5.00MB",
+		3 * 1024 * 1024 * 1024: "3.00GB",
+	}
+
+	for input, expected := range tests {
+		if result := GetByteSizeString(input); result != expected {
+			t.Error(fmt.Sprintf("%d: got %s expected %s", input, result, expected))
+		}
+	}
+}
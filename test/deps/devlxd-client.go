@@ -5,6 +5,7 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -63,7 +64,39 @@ func main() {
 		os.Exit(1)
 	}
 
-	if len(os.Args) > 1 {
+	if len(os.Args) > 1 && os.Args[1] == "ready" {
+		req, err := http.NewRequest("PATCH", "http://meshuggah-rocks/1.0", bytes.NewBufferString(`{"state": "Ready"}`))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		raw, err := c.Do(req)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if raw.StatusCode != http.StatusOK {
+			fmt.Println("http error", raw.StatusCode)
+			os.Exit(1)
+		}
+
+		fmt.Println("ready")
+	} else if len(os.Args) > 2 && os.Args[1] == "image" {
+		raw, err := c.Get(fmt.Sprintf("http://meshuggah-rocks/1.0/images/%s/export", os.Args[2]))
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if raw.StatusCode != http.StatusOK {
+			fmt.Println("http error", raw.StatusCode)
+			os.Exit(1)
+		}
+
+		fmt.Println("image ok")
+	} else if len(os.Args) > 1 {
 		raw, err := c.Get(fmt.Sprintf("http://meshuggah-rocks/1.0/config/%s", os.Args[1]))
 		if err != nil {
 			fmt.Println(err)
@@ -395,6 +395,10 @@ echo "==> TEST: server config"
 TEST_CURRENT=test_server_config
 test_server_config
 
+echo "==> TEST: lxd init"
+TEST_CURRENT=test_lxd_init
+test_lxd_init
+
 echo "==> TEST: filemanip"
 TEST_CURRENT=test_filemanip
 test_filemanip
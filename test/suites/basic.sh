@@ -17,6 +17,7 @@ test_basic_usage() {
 
   # Test image export
   sum=$(lxc image info testimage | grep ^Fingerprint | cut -d' ' -f2)
+  lxc image info "$(echo "${sum}" | cut -c1-12)" | grep -q "^Fingerprint: ${sum}"
   lxc image export testimage "${LXD_DIR}/"
   if [ -e "${LXD_TEST_IMAGE:-}" ]; then
     name=$(basename "${LXD_TEST_IMAGE}")
@@ -36,6 +37,47 @@ test_basic_usage() {
   # Test filename for image export (should be "out")
   lxc image export testimage "${LXD_DIR}/"
   [ "${sum}" = "$(sha256sum "${LXD_DIR}/testimage.tar.xz" | cut -d' ' -f1)" ]
+
+  # Test image export to stdout and import from stdin
+  [ "${sum}" = "$(lxc image export testimage - | sha256sum | cut -d' ' -f1)" ]
+  lxc image delete testimage
+  lxc image import - --alias testimage < "${LXD_DIR}/testimage.tar.xz" | grep -q "${sum}"
+
+  # Test split images going through a pipe
+  mkdir "${LXD_DIR}/split"
+  tar -xf "${LXD_DIR}/testimage.tar.xz" -C "${LXD_DIR}/split"
+  tar -cf "${LXD_DIR}/split-meta.tar" -C "${LXD_DIR}/split" metadata.yaml
+  tar -cf "${LXD_DIR}/split-rootfs.tar" -C "${LXD_DIR}/split/rootfs" .
+  split_sum=$(cat "${LXD_DIR}/split-meta.tar" "${LXD_DIR}/split-rootfs.tar" | sha256sum | cut -d' ' -f1)
+  lxc image import "${LXD_DIR}/split-meta.tar" "${LXD_DIR}/split-rootfs.tar" --alias splitimage
+  lxc image export splitimage - > "${LXD_DIR}/split.stream"
+  head -n1 "${LXD_DIR}/split.stream" | grep -q "^--"
+  lxc image delete splitimage
+  lxc image import - --alias splitimage < "${LXD_DIR}/split.stream" | grep -q "${split_sum}"
+
+  # Test bundles of several images, with their aliases
+  lxc image alias create splitalias "${split_sum}"
+  lxc image export-bundle testimage splitimage --output "${LXD_DIR}/bundle.tar"
+  tar -tf "${LXD_DIR}/bundle.tar" | grep -qx index.yaml
+  tar -tf "${LXD_DIR}/bundle.tar" | grep -q "^${split_sum}/meta-"
+  lxc image delete splitimage
+  lxc image import-bundle "${LXD_DIR}/bundle.tar" | grep -q "fingerprint: ${split_sum}"
+  lxc image info splitalias | grep -q "^Fingerprint: ${split_sum}"
+  lxc image info splitimage | grep -q "^Fingerprint: ${split_sum}"
+  [ "$(lxc image import-bundle "${LXD_DIR}/bundle.tar" | grep -c "already there")" = "2" ]
+  rm "${LXD_DIR}/bundle.tar"
+  mkdir "${LXD_DIR}/bundle"
+  printf 'images:\n- fingerprint: ../../etc\n  files:\n  - passwd\n' > "${LXD_DIR}/bundle/index.yaml"
+  tar -cf "${LXD_DIR}/bundle.tar" -C "${LXD_DIR}/bundle" index.yaml
+  ! lxc image import-bundle "${LXD_DIR}/bundle.tar"
+  rm -rf "${LXD_DIR}/bundle" "${LXD_DIR}/bundle.tar"
+  lxc image delete splitimage
+  rm -rf "${LXD_DIR}/split" "${LXD_DIR}/split-meta.tar" "${LXD_DIR}/split-rootfs.tar" "${LXD_DIR}/split.stream"
+
+  # Test the compression of large answers
+  my_curl -D - -o /dev/null -H "Accept-Encoding: gzip" "https://${LXD_ADDR}/1.0/images/${sum}/export" | grep -qi "^Content-Encoding: gzip"
+  [ "${sum}" = "$(my_curl --compressed "https://${LXD_ADDR}/1.0/images/${sum}/export" | sha256sum | cut -d' ' -f1)" ]
+  ! my_curl -D - -o /dev/null "https://${LXD_ADDR}/1.0/images/${sum}/export" | grep -qi "^Content-Encoding"
   rm "${LXD_DIR}/testimage.tar.xz"
 
   # Test container creation
@@ -43,15 +85,98 @@ test_basic_usage() {
   lxc list | grep foo | grep STOPPED
   lxc list fo | grep foo | grep STOPPED
 
+  # Test the policy on image properties
+  props_url="https://${LXD_ADDR}/1.0/images/${sum}"
+  props=$(my_curl "${props_url}" | jq -c .metadata.properties)
+  lxc config set images.block_properties '!user.scan.status, user.scan.status=failed'
+  ! lxc config set images.block_properties user.scan.status
+  ! lxc init testimage blocked
+  [ "$(my_curl -X POST -d '{"name": "blocked", "source": {"type": "image", "alias": "testimage"}}' "https://${LXD_ADDR}/1.0/containers" | jq -r .metadata.rule)" = "!user.scan.status" ]
+  [ "$(my_curl -X PUT -d "{\"properties\": $(echo "${props}" | jq -c '. + {"requirements.secureboot": "maybe"}')}" "${props_url}" | jq -r .error_code)" = "400" ]
+  my_curl -X PUT -d "{\"properties\": $(echo "${props}" | jq -c '. + {"user.scan.status": "failed"}')}" "${props_url}"
+  ! lxc init testimage blocked
+  my_curl -X PUT -d "{\"properties\": $(echo "${props}" | jq -c '. + {"user.scan.status": "passed"}')}" "${props_url}"
+  lxc init testimage blocked
+  lxc delete blocked
+  lxc config unset images.block_properties
+  my_curl -X PUT -d "{\"properties\": ${props}}" "${props_url}"
+
   # Test container rename
   lxc move foo bar
   lxc list | grep -v foo
   lxc list | grep bar
 
-  # Test container copy
+  # Test container copy, cloned rather than copied when the backend can
   lxc copy bar foo
+  if [ "${LXD_BACKEND}" = "btrfs" ]; then
+    btrfs subvolume show "${LXD_DIR}/containers/foo" >/dev/null
+  elif [ "${LXD_BACKEND}" = "zfs" ]; then
+    zfs get -H -o value origin "lxdtest-$(basename "${LXD_DIR}")/containers/foo" | grep -q "/containers/bar@copy-"
+  elif [ "${LXD_BACKEND}" = "lvm" ]; then
+    [ "$(lvs --noheadings -o origin "lxdtest-$(basename "${LXD_DIR}")/foo" | tr -d ' ')" = "bar" ]
+  fi
   lxc delete foo
 
+  # Copies can get a machine identity of their own
+  if [ "${LXD_BACKEND}" = "dir" ] || [ "${LXD_BACKEND}" = "btrfs" ]; then
+    echo 0123456789abcdef > "${LXD_DIR}/containers/bar/rootfs/etc/machine-id"
+    lxc copy bar foo --refresh-identity
+    [ ! -s "${LXD_DIR}/containers/foo/rootfs/etc/machine-id" ]
+    [ -s "${LXD_DIR}/containers/bar/rootfs/etc/machine-id" ]
+    lxc delete foo
+    rm "${LXD_DIR}/containers/bar/rootfs/etc/machine-id"
+  fi
+
+  # Test the names-only listings and the shell completion using them
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?names-only=true" | jq -r .metadata[0])" = "bar" ]
+  my_curl "https://${LXD_ADDR}/1.0/images/aliases?names-only=true" | jq -r .metadata[] | grep -x testimage
+  lxc completion bash | grep -q "complete -F _lxc lxc"
+  lxc completion __complete bash "lxc sta" | grep -x start
+  lxc completion __complete bash "lxc start b" | grep -x bar
+  lxc completion __complete bash "lxc init test" | grep -x testimage
+  lxc completion __complete bash "lxc config set bar limits.mem" | grep -x limits.memory
+
+  # The names are cached for a while, changes made with lxc dropping them
+  grep -q "^  containers:" "${LXD_CONF}/cache/local.yml"
+  lxc init testimage cachetest
+  lxc completion __complete bash "lxc start cache" | grep -x cachetest
+  lxc delete cachetest
+  ! lxc completion __complete bash "lxc start cache" | grep -x cachetest
+  lxc remote refresh-cache
+  grep -q "^  profiles:" "${LXD_CONF}/cache/local.yml"
+
+  # Test the output formats
+  [ "$(lxc list --quiet)" = "bar" ]
+  lxc list --format=csv | grep -q "^bar,STOPPED,"
+  [ "$(lxc list --format=csv | head -n1)" = "NAME,STATE,IPV4,IPV6,EPHEMERAL,SNAPSHOTS,DISK" ]
+  lxc list --format=compact | grep -q "^bar  *STOPPED"
+  [ "$(lxc list --format=json | jq -r .[0].state.name)" = "bar" ]
+  lxc list --format=yaml | grep -q "name: bar"
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?recursion=1&fields=name,status" | jq -r '.metadata[] | select(.name == "bar") | keys | join(",")')" = "name,status" ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?recursion=1&fields=name,status" | jq -r '.metadata[] | select(.name == "bar") | .status')" = "Stopped" ]
+  total=$(my_curl "https://${LXD_ADDR}/1.0/containers" | jq -r '.metadata | length')
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?limit=1" | jq -r .total)" = "${total}" ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?limit=1&offset=1" | jq -r '.metadata | length')" -le 1 ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/images?recursion=1&limit=1" | jq -r '.metadata | length')" = "1" ]
+
+  # Containers and images can be addressed by their UUID, which survives renames
+  uuid=$(my_curl "https://${LXD_ADDR}/1.0/containers/bar" | jq -r .metadata.uuid)
+  lxc info bar | grep -qx "UUID: ${uuid}"
+  lxc move bar bar-renamed
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/${uuid}" | jq -r .metadata.name)" = "bar-renamed" ]
+  lxc move bar-renamed bar
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/${uuid}/state" | jq -r .metadata.status)" = "Stopped" ]
+  image_uuid=$(lxc image info testimage | sed -n 's/^UUID: //p')
+  [ -n "${image_uuid}" ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/images/${image_uuid}" | jq -r .metadata.uuid)" = "${image_uuid}" ]
+  lxc config set bar user.ansible_group web
+  [ "$(lxc list --format=ansible-inventory | jq -r .web.hosts[0])" = "bar" ]
+  [ "$(lxc list --format=ansible-inventory | jq -r .status_stopped.hosts[0])" = "bar" ]
+  lxc config unset bar user.ansible_group
+  lxc image list --format=json | jq -r .[].fingerprint | grep -q "${sum}"
+  lxc profile list --quiet | grep -x default
+  ! lxc list --format=bogus
+
   # gen untrusted cert
   gen_third_cert
 
@@ -72,14 +197,71 @@ test_basic_usage() {
   lxc image show foo-image | grep val1
   curl -k -s --cert "${LXD_CONF}/client3.crt" --key "${LXD_CONF}/client3.key" -X GET "https://${LXD_ADDR}/1.0/images" | grep "/1.0/images/" && false
   lxc image delete foo-image
+  lxc profile show priv --usedby | grep -x barpriv
+  ! lxc profile delete priv
   lxc delete barpriv
   lxc profile delete priv
+  lxc profile create inuse
+  lxc init testimage inuse -p default -p inuse
+  lxc profile delete inuse --force
+  lxc info inuse | grep -x "Profiles: default"
+  lxc delete inuse
 
   # Test public images
   lxc publish --public bar --alias=foo-image2
   curl -k -s --cert "${LXD_CONF}/client3.crt" --key "${LXD_CONF}/client3.key" -X GET "https://${LXD_ADDR}/1.0/images" | grep "/1.0/images/"
   lxc image delete foo-image2
 
+  # Test the image compression settings
+  ! lxc config set images.compression_algorithm bogus
+  ! lxc config set images.compression_level 42
+  lxc config set images.compression_algorithm xz
+  lxc config set images.compression_level 1
+  lxc publish bar --alias=foo-image2
+  lxc image export foo-image2 "${LXD_DIR}/foo-image2"
+  xz -t "${LXD_DIR}/foo-image2"
+  lxc image delete foo-image2
+  rm "${LXD_DIR}/foo-image2"
+  ! lxc publish bar --alias=foo-image2 --compression=gzip:15
+  ! lxc publish bar --alias=foo-image2 --compression=bogus
+  lxc publish bar --alias=foo-image2 --compression=bzip2
+  lxc image export foo-image2 "${LXD_DIR}/foo-image2"
+  bzip2 -t "${LXD_DIR}/foo-image2"
+  lxc image delete foo-image2
+  rm "${LXD_DIR}/foo-image2"
+  if which zstd >/dev/null 2>&1; then
+    lxc publish bar --alias=foo-image2 --compression=zstd:3
+    lxc image export foo-image2 "${LXD_DIR}/foo-image2"
+    zstd -t "${LXD_DIR}/foo-image2"
+    lxc init foo-image2 zstd-image
+    lxc delete zstd-image
+    lxc image delete foo-image2
+    rm "${LXD_DIR}/foo-image2"
+  fi
+  lxc config unset images.compression_algorithm
+  lxc config unset images.compression_level
+
+  # Test filtered and batch image delete
+  lxc publish bar --alias=foo-image3 prop1=batch
+  ! echo no | lxc image delete --filter prop1=batch
+  lxc image show foo-image3
+  lxc image delete --filter prop1=batch --force
+  ! lxc image show foo-image3
+  lxc publish bar --alias=foo-image3
+  ! lxc image delete foo-image3 not-an-image
+  ! lxc image show foo-image3
+
+  # Test OCI export, the temporary image goes away
+  images=$(lxc image list --format=csv | wc -l)
+  lxc publish bar --format=oci "${LXD_DIR}/bar-oci.tar" --alias=bar:1 label1=val1
+  [ "$(lxc image list --format=csv | wc -l)" = "${images}" ]
+  tar -tf "${LXD_DIR}/bar-oci.tar" | grep -x oci-layout
+  tar -tf "${LXD_DIR}/bar-oci.tar" | grep -x index.json
+  tar -xOf "${LXD_DIR}/bar-oci.tar" manifest.json | jq -e '.[0].RepoTags[0] == "bar:1"'
+  config=$(tar -xOf "${LXD_DIR}/bar-oci.tar" manifest.json | jq -r '.[0].Config')
+  tar -xOf "${LXD_DIR}/bar-oci.tar" "${config}" | jq -e '.config.Labels.label1 == "val1"'
+  rm "${LXD_DIR}/bar-oci.tar"
+
   # Test invalid container names
   ! lxc init testimage -abc
   ! lxc init testimage abc-
@@ -87,6 +269,22 @@ test_basic_usage() {
   ! lxc init testimage 12test
   ! lxc init testimage a_b_c
   ! lxc init testimage aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa
+  [ "$(my_curl -X POST "https://${LXD_ADDR}/1.0/containers" -d '{"name": "a_b_c", "source": {"type": "none"}}' | jq -r .metadata.rule)" = "charset" ]
+
+  # Test the container name policy
+  [ "$(my_curl -X POST "https://${LXD_ADDR}/1.0/containers" -d '{"name": "12test", "source": {"type": "none"}}' | jq -r .metadata.rule)" = "leading_digit" ]
+  lxc config set containers.names.leading_digit true
+  lxc init testimage 12test
+  ! lxc move 12test 34test/snap
+  lxc delete 12test
+  lxc config unset containers.names.leading_digit
+  ! lxc config set containers.names.reserved "ci-["
+  lxc config set containers.names.reserved "localhost,ci-*"
+  [ "$(my_curl -X POST "https://${LXD_ADDR}/1.0/containers" -d '{"name": "CI-1", "source": {"type": "none"}}' | jq -r .metadata.rule)" = "reserved" ]
+  lxc init testimage reservedrename
+  ! lxc move reservedrename localhost
+  lxc delete reservedrename
+  lxc config unset containers.names.reserved
 
   # Test snapshot publish
   lxc snapshot bar
@@ -160,9 +358,65 @@ test_basic_usage() {
     false
   fi
 
+  # batch operations
+  lxc launch testimage bar
+  lxc stop foo bar --force
+  lxc list | grep foo | grep STOPPED
+  lxc list | grep bar | grep STOPPED
+  lxc start foo bar
+  lxc list | grep foo | grep RUNNING
+  lxc list | grep bar | grep RUNNING
+  ! lxc start foo bar
+  lxc stop bar --timeout=1
+  lxc list | grep bar | grep STOPPED
+
+  # waiting on the container state
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/foo/state?wait=status:RUNNING&timeout=5" | jq -r .metadata.status)" = "Running" ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/bar/state?wait=status:RUNNING&timeout=1" | jq -r .error_code)" = "408" ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/bar/state?wait=bogus" | jq -r .error_code)" = "400" ]
+  (sleep 2 && lxc start bar) &
+  my_curl "https://${LXD_ADDR}/1.0/containers/bar/state?wait=status:RUNNING&timeout=30" | jq -e '.metadata.status == "Running"'
+  wait
+  lxc stop bar --force
+  # whether the container gets an address depends on the host's bridge
+  lxc start bar --wait-ip --timeout=2 || true
+  lxc list | grep bar | grep RUNNING
+  lxc stop bar --force
+  lxc delete bar
+
   # check that we can set the environment
   lxc exec foo pwd | grep /root
   lxc exec --env BEST_BAND=meshuggah foo env | grep meshuggah
+  lxc exec --user=1000 --group=1001 foo -- id -u | grep -x 1000
+  lxc exec --user=1000 --group=1001 foo -- id -g | grep -x 1001
+  lxc exec --cwd=/tmp foo pwd | grep -x /tmp
+
+  # exec timeout
+  ! lxc exec --timeout=1 foo -- sleep 30
+  lxc exec --timeout=30 foo -- true
+
+  # The exit code of the command is passed through
+  ret=0
+  lxc exec foo -- sh -c "exit 42" || ret=$?
+  [ "${ret}" = "42" ]
+  ret=0
+  lxc exec foo -- sh -c 'kill -9 $$' || ret=$?
+  [ "${ret}" = "137" ]
+  ret=0
+  lxc exec nonexistent -- true || ret=$?
+  [ "${ret}" = "255" ]
+
+  # Test the aliases
+  lxc alias list | grep -q shell
+  lxc alias add say "exec @ARG@ -- echo"
+  lxc say foo hello | grep -x hello
+  ! lxc alias add list "exec @ARG@ -- true"
+  lxc alias remove say
+  ! lxc say foo hello
+
+  lxc config set foo environment.FAVORITE_BAND gojira
+  lxc exec foo env | grep gojira
+  lxc config unset foo environment.FAVORITE_BAND
   lxc exec foo ip link show | grep eth0
 
   # test file transfer
@@ -194,10 +448,117 @@ test_basic_usage() {
 
   lxc launch testimage deleterunning
   my_curl -X DELETE "https://${LXD_ADDR}/1.0/containers/deleterunning" | grep "container is running"
-  lxc delete deleterunning
+  ! lxc delete deleterunning
+  lxc list deleterunning | grep -q RUNNING
+
+  # protected containers can't be deleted, even with --force
+  lxc config set deleterunning security.protection.delete true
+  ! lxc delete deleterunning --force
+  lxc list deleterunning | grep -q RUNNING
+  lxc stop deleterunning --force
+  my_curl -X DELETE "https://${LXD_ADDR}/1.0/containers/deleterunning" | grep -q "container is protected"
+  lxc config unset deleterunning security.protection.delete
+  lxc start deleterunning
+  lxc delete deleterunning --force
+
+  # bulk delete with patterns
+  lxc init testimage bulk-1
+  lxc launch testimage bulk-2
+  lxc init testimage bulkkeep
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?names-only=true&name=bulk-*" | jq -r '.metadata | join(",")')" = "bulk-1,bulk-2" ]
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?name=%5B" | jq -r .error_code)" = "400" ]
+  [ "$(lxc delete 'bulk-*' --force --dry-run | grep -c bulk-)" = "2" ]
+  ! echo n | lxc delete 'bulk-*' --force
+  lxc list bulk-2 | grep -q RUNNING
+  echo y | lxc delete 'bulk-*' --force
+  ! lxc list --format=csv | grep -q "^bulk-"
+  lxc list --format=csv | grep -q "^bulkkeep,"
+  lxc delete 'bulk*' --yes
+  ! lxc list --format=csv | grep -q "^bulkkeep,"
+
+  # config changes can be dry-run
+  preview=$(my_curl -X PUT "https://${LXD_ADDR}/1.0/containers/foo?dry-run=true" -d '{"config": {"security.nesting": "true"}, "profiles": ["default"]}')
+  echo "${preview}" | jq -r '.metadata.config[]' | grep -x security.nesting
+  [ "$(echo "${preview}" | jq -r .metadata.restart_required)" = "true" ]
+  echo "${preview}" | jq -r .metadata.lxc_config | grep -q "lxc.mount.auto"
+  ! lxc config show foo | grep -q security.nesting
+  my_curl -X PUT "https://${LXD_ADDR}/1.0/containers/foo?dry-run=true" -d '{"config": {"invalid.key": "true"}}' | grep -q "Bad key"
 
   # cleanup
-  lxc delete foo
+  lxc delete foo --force
+
+  # changes which only apply on restart are reported
+  lxc launch testimage pending
+  ! lxc info pending | grep -q "Restart required"
+  lxc config set pending user.foo bar
+  ! lxc info pending | grep -q "Restart required"
+  lxc config set pending security.nesting true
+  lxc info pending | grep -q "Restart required: security.nesting"
+  lxc restart pending --force
+  ! lxc info pending | grep -q "Restart required"
+  lxc stop pending --force
+  lxc delete pending
+
+  # pause and resume
+  lxc launch testimage paused
+  lxc pause paused --timeout=10
+  lxc info paused | grep -q "Status: Frozen"
+  ! lxc pause paused
+  lxc resume paused
+  lxc info paused | grep -q "Status: Running"
+  ! lxc config show paused | grep -q volatile.last_state.frozen
+  lxc stop paused --force
+  lxc delete paused
+
+  # read-only rootfs with writable tmpfs
+  lxc launch testimage readonly
+  lxc exec readonly -- touch /var/state
+  lxc stop readonly --force
+  lxc config set readonly security.readonly_rootfs true
+  lxc start readonly
+  ! lxc exec readonly -- touch /readonly
+  lxc exec readonly -- touch /tmp/writable
+  ! lxc exec readonly -- touch /var/writable
+  lxc exec readonly -- test -e /var/state
+  lxc stop readonly --force
+  ! lxc config set readonly security.readonly_rootfs.tmpfs relative
+  lxc config set readonly security.readonly_rootfs.tmpfs /tmp
+  lxc start readonly
+  ! lxc exec readonly -- touch /run/writable
+  lxc exec readonly -- touch /tmp/writable
+  lxc stop readonly --force
+  lxc delete readonly
+
+  # syscalls can be blocked through the seccomp policy
+  ! lxc init testimage seccomp -c security.syscalls.blacklist="mkdir errno 1"
+  lxc launch testimage seccomp -c security.syscalls.blacklist=mkdir,mkdirat
+  ! lxc exec seccomp -- mkdir /tmp/blocked
+  grep -q "^mkdirat errno 1" "${LXD_DIR}/security/seccomp/seccomp"
+  ! lxc config set seccomp security.syscalls.whitelist read
+  lxc stop seccomp --force
+  lxc delete seccomp
+
+  # whitelisted device nodes can be created when mknod is intercepted
+  if grep -qw user_notif /proc/sys/kernel/seccomp/actions_avail 2>/dev/null && \
+      [ "$(printf "3.2\n%s\n" "$(lxc-start --version)" | sort -V | head -n1)" = "3.2" ]; then
+    lxc launch testimage intercept -c security.syscalls.intercept.mknod=true
+    lxc exec intercept -- mknod /tmp/null c 1 3
+    [ "$(lxc exec intercept -- stat -c %t:%T /tmp/null)" = "1:3" ]
+    ! lxc exec intercept -- mknod /tmp/sda b 8 0
+    lxc stop intercept --force
+    lxc delete intercept
+  fi
+
+  # the rootfs is left unshifted on disk when idmapped mounts are used
+  if grep -q "Using idmapped mounts" "${LXD_DIR}/lxd.log" 2>/dev/null; then
+    lxc launch testimage idmapped
+    [ "$(stat -c %u "${LXD_DIR}/containers/idmapped/rootfs/bin")" = "0" ]
+    [ "$(lxc exec idmapped -- stat -c %u /bin)" = "0" ]
+    mountpoint -q "${LXD_DIR}/idmapped/idmapped"
+    lxc stop idmapped --force
+    [ ! -d "${LXD_DIR}/idmapped/idmapped" ]
+    lxc delete idmapped
+  fi
 
   # check that an apparmor profile is created for this container, that it is
   # unloaded on stop, and that it is deleted when the container is deleted
@@ -208,6 +569,15 @@ test_basic_usage() {
   lxc delete lxd-apparmor-test
   [ ! -f "${LXD_DIR}/security/apparmor/profiles/lxd-lxd-apparmor-test" ]
 
+  # a profile loaded outside of LXD can be used instead of the generated one
+  lxc init testimage lxd-apparmor-test -c security.apparmor.profile=lxd-not-loaded
+  ! lxc start lxd-apparmor-test
+  lxc config set lxd-apparmor-test security.apparmor.profile unconfined
+  lxc start lxd-apparmor-test
+  [ "$(lxc exec lxd-apparmor-test -- cat /proc/self/attr/current)" = "unconfined" ]
+  lxc stop lxd-apparmor-test --force
+  lxc delete lxd-apparmor-test
+
   # make sure that privileged containers are not world-readable
   lxc profile create unconfined
   lxc profile set unconfined security.privileged true
@@ -216,6 +586,56 @@ test_basic_usage() {
   lxc delete foo2
   lxc profile delete unconfined
 
+  # classic LXC containers can be imported
+  mkdir -p "${LXD_DIR}/lxc/lxcimport/rootfs/etc"
+  echo lxcimport > "${LXD_DIR}/lxc/lxcimport/rootfs/etc/hostname"
+  cat > "${LXD_DIR}/lxc/lxcimport/config" << EOF
+lxc.include = /usr/share/lxc/config/common.conf
+lxc.arch = $(uname -m)
+lxc.utsname = lxcimport
+lxc.network.type = veth
+lxc.network.hwaddr = 00:16:3e:12:34:56
+lxc.start.auto = 1
+EOF
+  lxd import-lxc --lxcpath="${LXD_DIR}/lxc" lxcimport
+  lxc config show lxcimport | grep -q "hwaddr: 00:16:3e:12:34:56"
+  lxc config get lxcimport boot.autostart | grep -q true
+  lxc config get lxcimport security.privileged | grep -q true
+  [ -f "${LXD_DIR}/lxc/lxcimport/config.imported" ] || [ -d "${LXD_DIR}/lxc/lxcimport/rootfs" ]
+  ! lxd import-lxc --lxcpath="${LXD_DIR}/lxc" lxcimport
+  lxc delete lxcimport
+  rm -rf "${LXD_DIR}/lxc"
+
+  # containers can be recovered from the storage after losing their record
+  lxc init testimage recovery
+  lxc snapshot recovery snap0
+  sqlite3 "${LXD_DIR}/lxd.db" "DELETE FROM containers WHERE name LIKE 'recovery%';"
+  ! lxc info recovery
+  lxd recover --auto | grep -q "recovery/snap0: recovered"
+  lxc info recovery | grep -q snap0
+  lxd recover --auto | grep -q "No unknown containers"
+  lxc delete recovery
+
+  # daemon backups hold the database and certificates
+  lxd backup create "${LXD_DIR}/backup.tar.gz"
+  tar -tzf "${LXD_DIR}/backup.tar.gz" | grep -x lxd.db
+  tar -xzOf "${LXD_DIR}/backup.tar.gz" backup.yaml | grep -q "^schema:"
+  [ "$(stat -c "%a" "${LXD_DIR}/backup.tar.gz")" = "600" ]
+  ! lxd backup restore "${LXD_DIR}/backup.tar.gz"
+  rm "${LXD_DIR}/backup.tar.gz"
+
+  # containers can be reset to their image, keeping their config
+  lxc launch testimage rebuild1
+  HWADDR=$(lxc config get rebuild1 volatile.eth0.hwaddr)
+  lxc exec rebuild1 -- touch /rebuilt
+  ! lxc rebuild rebuild1
+  lxc rebuild rebuild1 testimage --force
+  lxc start rebuild1
+  ! lxc exec rebuild1 -- test -e /rebuilt
+  [ "$(lxc config get rebuild1 volatile.eth0.hwaddr)" = "${HWADDR}" ]
+  lxc stop rebuild1 --force
+  lxc delete rebuild1
+
   # Ephemeral
   lxc launch testimage foo -e
 
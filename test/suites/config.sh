@@ -92,6 +92,61 @@ test_config_profiles() {
   # into the database and never let the user edit the container again.
   ! lxc config set foo raw.lxc "lxc.notaconfigkey = invalid"
 
+  # a container can't become a virtual machine, nor can a profile make some
+  ! lxc config set foo instance.type vm
+  ! lxc profile set default instance.type vm
+  ! lxc init testimage bar -c instance.type=bogus
+  ! lxc init testimage bar -c driver=qemu
+  ! lxc config set foo driver bogus
+  lxc config set foo driver lxc
+  lxc config unset foo driver
+
+  # time zones must exist in the container, time offsets are for VMs only
+  ! lxc config set foo timezone Not/A_Zone
+  ! lxc config set foo timezone ../../etc/passwd
+  mkdir -p "${LXD_DIR}/containers/foo/rootfs/usr/share/zoneinfo/Etc"
+  ! lxc config set foo timezone Etc/UTC
+  touch "${LXD_DIR}/containers/foo/rootfs/usr/share/zoneinfo/Etc/UTC"
+  lxc config set foo timezone Etc/UTC
+  lxc config unset foo timezone
+  rm -rf "${LXD_DIR}/containers/foo/rootfs/usr/share/zoneinfo"
+  ! lxc config set foo security.time_offset soon
+  ! lxc config set foo security.time_offset -24h
+
+  # NUMA nodes are checked to exist on start only
+  ! lxc config set foo limits.cpu.nodes bogus
+  ! lxc config set foo limits.cpu.nodes 1-0
+  lxc config set foo limits.cpu.nodes 0
+  lxc info --resources | grep -qx " *- foo" || ! [ -d /sys/devices/system/node/node0 ]
+  lxc config unset foo limits.cpu.nodes
+
+  # the idle freezing policy takes minutes and a percentage of a CPU
+  ! lxc config set foo limits.autofreeze.idle_timeout soon
+  ! lxc config set foo limits.autofreeze.idle_threshold -1
+  lxc config set foo limits.autofreeze.idle_timeout 30
+  lxc config set foo limits.autofreeze.idle_threshold 2.5
+  lxc config unset foo limits.autofreeze.idle_timeout
+  lxc config unset foo limits.autofreeze.idle_threshold
+
+  # kernel modules are loaded on start, with their names checked right away
+  ! lxc config set foo linux.kernel_modules "ip_vs,../evil"
+  ! lxc config set foo linux.kernel_modules "ip_vs nf_nat"
+  lxc config set foo linux.kernel_modules ip_vs,nf-nat
+  lxc config unset foo linux.kernel_modules
+
+  lxc network acl create web "Web servers"
+  lxc network acl rule add web ingress action=allow protocol=tcp port=80,443
+  ! lxc network acl rule add web ingress action=allow port=22
+  ! lxc network acl rule add web sideways action=drop
+  lxc network acl show web | grep -q "port: 80,443"
+  ! lxc network acl create web
+  ! lxc config set foo security.acls "web,bad/name"
+  lxc config set foo security.acls web
+  ! lxc network acl delete web
+  lxc config unset foo security.acls
+  lxc network acl rule remove web 0
+  lxc network acl delete web
+
   lxc profile create stdintest
   echo "BADCONF" | lxc profile set stdintest user.user_data -
   lxc profile show stdintest | grep BADCONF
@@ -117,6 +172,22 @@ test_config_profiles() {
   lxc profile device list onenic | grep eth0
   lxc profile device show onenic | grep lxcbr0
 
+  # profiles round-trip through YAML
+  lxc profile export onenic > "${TEST_DIR}/profiles.yml"
+  lxc profile import < "${TEST_DIR}/profiles.yml" | grep -qx "Nothing to change"
+  sed -i "s/name: onenic/name: imported/" "${TEST_DIR}/profiles.yml"
+  lxc profile import "${TEST_DIR}/profiles.yml" | grep -qx "profile imported created"
+  lxc profile device show imported | grep lxcbr0
+  lxc profile delete imported
+  rm "${TEST_DIR}/profiles.yml"
+
+  lxc config dump > "${TEST_DIR}/dump.yml"
+  grep -q "name: unconfined" "${TEST_DIR}/dump.yml"
+  grep -q "target: $(lxc image info testimage | awk '/^Fingerprint/ {print $2}')" "${TEST_DIR}/dump.yml"
+  ! grep -q "core.trust_password" "${TEST_DIR}/dump.yml"
+  lxc config load "${TEST_DIR}/dump.yml" | grep -qx "Nothing to change"
+  rm "${TEST_DIR}/dump.yml"
+
   # test live-adding a nic
   lxc start foo
   ! lxc config show foo | grep -q "raw.lxc"
@@ -129,6 +200,14 @@ test_config_profiles() {
   lxc config device list foo | grep eth2
   lxc config device remove foo eth2
 
+  # test the VLAN and bond keys of nics
+  ! lxc config device add foo eth3 nic nictype=bridged parent=lxcbr0 vlan=4095
+  ! lxc config device add foo eth3 nic nictype=p2p vlan=10
+  ! lxc config device add foo eth3 nic nictype=bridged parent=lxcbr0 bond.slaves=eth0
+  ! lxc config device add foo eth3 nic nictype=macvlan parent=bond0 bond.slaves=bond0
+  ! lxc config device add foo eth3 nic nictype=macvlan parent=bond0 bond.slaves=eth0 bond.mode=fast
+  ! lxc config device list foo | grep eth3
+
   # test live-adding a disk
   mkdir "${TEST_DIR}/mnt2"
   touch "${TEST_DIR}/mnt2/hosts"
@@ -144,6 +223,36 @@ test_config_profiles() {
   ! lxc exec foo -- ls /mnt2/hosts
   lxc stop foo --force
 
+  # a failed start keeps its config around and shows its log
+  lxc config set foo raw.lxc "lxc.cgroup.invalid.key = 1"
+  ! lxc start foo
+  grep -q "lxc.cgroup.invalid.key" "${LXD_DIR}/logs/foo/lxc.conf"
+  lxc info foo --show-log | grep -q "Log:"
+  lxc config unset foo raw.lxc
+
+  # the console log of the previous start is kept aside
+  echo previous > "${LXD_DIR}/logs/foo/console.log"
+  lxc start foo
+  [ "$(cat "${LXD_DIR}/logs/foo/console.log.old")" = "previous" ]
+  ! grep -q previous "${LXD_DIR}/logs/foo/console.log"
+  lxc stop foo --force
+
+  # test the host hooks
+  lxc config set foo hooks.pre-start "exit 1"
+  ! lxc start foo
+  lxc config set foo hooks.pre-start "echo \${LXD_CONTAINER_NAME} > ${TEST_DIR}/hook-pre-start"
+  lxc config set foo hooks.post-start "echo \${LXD_CONTAINER_PID} > ${TEST_DIR}/hook-post-start"
+  lxc config set foo hooks.pre-stop "touch ${TEST_DIR}/hook-pre-stop"
+  lxc start foo
+  [ "$(cat "${TEST_DIR}/hook-pre-start")" = "foo" ]
+  [ "$(cat "${TEST_DIR}/hook-post-start")" -gt 0 ]
+  lxc stop foo --force
+  [ -e "${TEST_DIR}/hook-pre-stop" ]
+  lxc config unset foo hooks.pre-start
+  lxc config unset foo hooks.post-start
+  lxc config unset foo hooks.pre-stop
+  rm -f "${TEST_DIR}"/hook-*
+
   lxc config set foo user.prop value
   lxc list user.prop=value | grep foo
   lxc config unset foo user.prop
@@ -170,7 +279,7 @@ test_config_profiles() {
 
   testloopmounts
 
-  lxc delete foo
+  lxc delete foo --force
 
   lxc init testimage foo
   lxc profile apply foo onenic,unconfined
@@ -16,5 +16,31 @@ test_devlxd() {
   lxc config set devlxd user.foo bar
   lxc exec devlxd devlxd-client user.foo | grep bar
 
+  # images are only shared with nesting containers allowed to get them
+  fingerprint=$(lxc image info testimage | grep ^Fingerprint | cut -d' ' -f2)
+  ! lxc exec devlxd devlxd-client image "${fingerprint}"
+  lxc config set devlxd security.nesting true
+  lxc config set devlxd security.nesting.share_images true
+  lxc exec devlxd devlxd-client image "${fingerprint}" | grep "image ok"
+  ! lxc exec devlxd devlxd-client image "$(echo "${fingerprint}" | cut -c1-12)"
+  lxc config unset devlxd security.nesting.share_images
+  lxc config unset devlxd security.nesting
+
+  # readiness signal
+  lxc info devlxd | grep -x "Ready: yes"
+  lxc config set devlxd user.ready-signal true
+  lxc info devlxd | grep -x "Ready: no"
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/devlxd/state?wait=ready&timeout=1" | jq -r .error_code)" = "408" ]
+  lxc exec devlxd devlxd-client ready
+  my_curl "https://${LXD_ADDR}/1.0/containers/devlxd/state?wait=ready&timeout=5" | jq -e .metadata.ready
+  lxc info devlxd | grep -x "Ready: yes"
+  lxc restart devlxd --force
+  lxc info devlxd | grep -x "Ready: no"
+  lxc stop devlxd --force
+  (for i in $(seq 30); do sleep 1; lxc exec devlxd devlxd-client ready && break; done) &
+  lxc start devlxd --wait-ready --timeout=60
+  wait
+  lxc info devlxd | grep -x "Ready: yes"
+
   lxc stop devlxd --force
 }
@@ -10,5 +10,34 @@ test_filemanip() {
   [ ! -f /tmp/main.sh ]
   [ -f "${LXD_DIR}/containers/filemanip/rootfs/tmp/main.sh" ]
 
-  lxc delete filemanip
+  # symlinks are recreated rather than followed
+  lxc file pull filemanip/tmp/outside "${TEST_DIR}/outside"
+  [ -L "${TEST_DIR}/outside" ]
+  [ "$(readlink "${TEST_DIR}/outside")" = "/tmp/" ]
+  ln -s /etc/hostname "${TEST_DIR}/hostname-link"
+  lxc file push "${TEST_DIR}/hostname-link" filemanip/tmp/
+  [ "$(lxc exec filemanip -- readlink /tmp/hostname-link)" = "/etc/hostname" ]
+  rm "${TEST_DIR}/outside" "${TEST_DIR}/hostname-link"
+
+  # sparse files stay sparse
+  truncate -s 100M "${TEST_DIR}/sparse"
+  lxc file push "${TEST_DIR}/sparse" filemanip/tmp/
+  [ "$(lxc exec filemanip -- stat -c %s /tmp/sparse)" = "104857600" ]
+  [ "$(lxc exec filemanip -- stat -c %b /tmp/sparse)" = "0" ]
+  lxc file pull filemanip/tmp/sparse "${TEST_DIR}/sparse.out"
+  [ "$(stat -c %b "${TEST_DIR}/sparse.out")" = "0" ]
+  rm "${TEST_DIR}/sparse" "${TEST_DIR}/sparse.out"
+
+  # checksums are verified on both ends
+  sum=$(sha256sum main.sh | cut -d' ' -f1)
+  lxc file push --verify main.sh filemanip/tmp/verified
+  lxc file pull --verify filemanip/tmp/verified "${TEST_DIR}/verified"
+  [ "$(sha256sum "${TEST_DIR}/verified" | cut -d' ' -f1)" = "${sum}" ]
+  [ "$(lxc file pull --verify filemanip/tmp/verified - | sha256sum | cut -d' ' -f1)" = "${sum}" ]
+  my_curl -i "https://${LXD_ADDR}/1.0/containers/filemanip/files?path=/tmp/verified" | grep -qi "^X-LXD-sha256: ${sum}"
+  my_curl -X POST -H "X-LXD-sha256: ${sum}" --data-binary "corrupted" "https://${LXD_ADDR}/1.0/containers/filemanip/files?path=/tmp/corrupted" | grep -q "checksum mismatch"
+  ! lxc exec filemanip -- test -e /tmp/corrupted
+  rm "${TEST_DIR}/verified"
+
+  lxc delete filemanip --force
 }
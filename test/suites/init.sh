@@ -0,0 +1,48 @@
+#!/bin/sh
+
+test_lxd_init() {
+  LXD_INIT_DIR=$(mktemp -d -p "${TEST_DIR}" XXX)
+  chmod +x "${LXD_INIT_DIR}"
+  spawn_lxd "${LXD_INIT_DIR}"
+
+  (
+    set -e
+    # shellcheck disable=SC2030
+    LXD_DIR=${LXD_INIT_DIR}
+
+    ! lxd init --auto --network-port 9443
+    lxd init --auto --network-bridge lxdtbr0
+    [ -e /sys/class/net/lxdtbr0/bridge ]
+    lxc profile show default --force-local | grep -q "parent: lxdtbr0"
+    ip link del lxdtbr0
+
+    # preseeding only changes what differs
+    cat > "${LXD_INIT_DIR}/preseed.yaml" <<EOF
+config:
+  images.remote_cache_expiry: 15
+networks:
+- name: lxdtbr1
+  type: bridge
+profiles:
+- name: default
+  devices:
+    eth0:
+      type: nic
+      nictype: bridged
+      parent: lxdtbr1
+- name: preseeded
+  config:
+    limits.memory: 1GB
+EOF
+    lxd init --preseed < "${LXD_INIT_DIR}/preseed.yaml" | grep -q "profile preseeded created"
+    [ -e /sys/class/net/lxdtbr1/bridge ]
+    lxc config get images.remote_cache_expiry --force-local | grep -qx "images.remote_cache_expiry: 15"
+    lxc profile show default --force-local | grep -q "parent: lxdtbr1"
+    lxd init --preseed < "${LXD_INIT_DIR}/preseed.yaml" | grep -q "already configured"
+    ip link del lxdtbr1
+  )
+
+  # shellcheck disable=SC2031
+  LXD_DIR=${LXD_DIR}
+  kill_lxd "${LXD_INIT_DIR}"
+}
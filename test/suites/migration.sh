@@ -36,6 +36,7 @@ test_migration() {
   fi
 
   lxc_remote copy l1:nonlive2/snap0 l2:nonlive3
+  lxc_remote config show l2:nonlive3 | grep -qx -- "- default"
   # FIXME: make this backend agnostic
   if [ "${LXD_BACKEND}" != "lvm" ]; then
     [ -d "${LXD2_DIR}/containers/nonlive3/rootfs/bin" ]
@@ -47,6 +48,20 @@ test_migration() {
   # check that nonlive2 has a new addr in volatile
   [ "$(lxc_remote config get l2:nonlive volatile.eth0.hwaddr)" != "$(lxc_remote config get l2:nonlive2 volatile.eth0.hwaddr)" ]
 
+  # Relayed through the client rather than pulled by the destination
+  ! lxc_remote copy l2:nonlive l1:relayed --mode=bogus
+  lxc_remote copy l2:nonlive l1:relayed --mode=relay
+  [ -d "${LXD_DIR}/containers/relayed" ]
+  lxc_remote snapshot list l1:relayed --format=csv | grep -q "^snap0,"
+  lxc_remote delete l1:relayed
+
+  # Limited to a number of bytes per second, by the destination or the relay
+  ! lxc_remote copy l2:nonlive l1:limited --bwlimit=fast
+  lxc_remote copy l2:nonlive l1:limited --bwlimit=100MB
+  lxc_remote delete l1:limited
+  lxc_remote copy l2:nonlive l1:limited --bwlimit=100MB --mode=relay
+  lxc_remote delete l1:limited
+
   lxc_remote config unset l2:nonlive volatile.base_image
   lxc_remote copy l2:nonlive l1:nobase
   lxc_remote delete l1:nobase
@@ -59,6 +74,18 @@ test_migration() {
   lxc_remote list l2: | grep RUNNING | grep nonlive
   lxc_remote stop l2:nonlive --force
 
+  # Refreshing existing copies only sends the new snapshots and changes
+  ! lxc_remote copy l2:nonlive l1:nonlive2
+  lxc_remote snapshot l2:nonlive refreshed
+  lxc_remote copy l2:nonlive l1:nonlive2 --refresh
+  lxc_remote snapshot list l1:nonlive2 --format=csv | grep -q "^snap0,"
+  lxc_remote snapshot list l1:nonlive2 --format=csv | grep -q "^refreshed,"
+  lxc_remote copy l2:nonlive l2:nonlive2 --refresh
+  lxc_remote snapshot list l2:nonlive2 --format=csv | grep -q "^refreshed,"
+  lxc_remote start l1:nonlive2
+  ! lxc_remote copy l2:nonlive l1:nonlive2 --refresh
+  lxc_remote stop l1:nonlive2 --force
+
   if ! which criu >/dev/null 2>&1; then
     echo "==> SKIP: live migration with CRIU (missing binary)"
     return
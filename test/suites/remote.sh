@@ -11,6 +11,17 @@ gen_second_cert() {
   mv "${LXD_CONF}/client.key.bak" "${LXD_CONF}/client.key"
 }
 
+gen_read_only_cert() {
+  [ -f "${LXD_CONF}/client4.crt" ] && return
+  mv "${LXD_CONF}/client.crt" "${LXD_CONF}/client.crt.bak"
+  mv "${LXD_CONF}/client.key" "${LXD_CONF}/client.key.bak"
+  lxc_remote list > /dev/null 2>&1
+  mv "${LXD_CONF}/client.crt" "${LXD_CONF}/client4.crt"
+  mv "${LXD_CONF}/client.key" "${LXD_CONF}/client4.key"
+  mv "${LXD_CONF}/client.crt.bak" "${LXD_CONF}/client.crt"
+  mv "${LXD_CONF}/client.key.bak" "${LXD_CONF}/client.key"
+}
+
 test_remote_url() {
   for url in "${LXD_ADDR}" "https://${LXD_ADDR}"; do
     lxc_remote remote add test "${url}" --accept-certificate --password foo
@@ -31,6 +42,13 @@ test_remote_url() {
     lxc_remote finger test:
     lxc_remote remote remove test
   done
+
+  # The local remote follows LXD_SOCKET and --socket
+  ! LXD_SOCKET=/nonexistent lxc_remote finger local:
+  ! lxc_remote finger local: --socket /nonexistent
+  LXD_SOCKET="${LXD2_DIR}/unix.socket" lxc_remote finger local:
+  lxc_remote finger local: --socket "${LXD2_DIR}/unix.socket"
+  [ "$(lxc_remote info local: | grep PID)" != "$(lxc_remote info local: --socket "${LXD2_DIR}/unix.socket" | grep PID)" ]
 }
 
 test_remote_admin() {
@@ -48,6 +66,26 @@ test_remote_admin() {
   lxc_remote remote list | grep -v 'localhost'
   [ "$(lxc_remote remote get-default)" = "foo" ]
 
+  # per-remote settings
+  grep -q "^version: 2" "${LXD_CONF}/config.yml"
+  lxc_remote remote list --format=csv | grep -q "^foo,.*,lxd,tls,"
+  ! lxc_remote config set-remote foo protocol bogus
+  ! lxc_remote config set-remote foo certificate-fingerprint 1234
+  fingerprint=$(openssl x509 -in "${LXD_DIR}/server.crt" -noout -fingerprint -sha256 | cut -d= -f2)
+  lxc_remote config set-remote foo certificate-fingerprint "${fingerprint}"
+  lxc_remote list foo:
+  lxc_remote config set-remote foo certificate-fingerprint "$(printf '0%.0s' $(seq 64))"
+  ! lxc_remote list foo:
+  lxc_remote config set-remote foo certificate-fingerprint
+  lxc_remote list foo:
+  ! lxc_remote config set-remote foo proxy ftp://proxy:21
+  lxc_remote config set-remote foo proxy socks5h://127.0.0.1:1
+  ! lxc_remote list foo:
+  lxc_remote config set-remote foo proxy none
+  ALL_PROXY=socks5h://127.0.0.1:1 lxc_remote list foo:
+  lxc_remote config set-remote foo proxy
+  lxc_remote list foo:
+
   ! lxc_remote remote remove foo
   lxc_remote remote set-default local
   lxc_remote remote remove foo
@@ -69,6 +107,29 @@ test_remote_admin() {
     echo "wrong number of certs"
   fi
 
+  # a read-only certificate can GET but can't change anything
+  gen_read_only_cert
+  lxc_remote config trust add "${LXD_CONF}/client4.crt" --read-only
+  lxc_remote config trust list --format=json | jq -r '.[] | select(.read_only) | .certificate' | grep -q BEGIN
+  ro_curl() {
+    curl -k -s --cert "${LXD_CONF}/client4.crt" --key "${LXD_CONF}/client4.key" "$@"
+  }
+  [ "$(ro_curl -X GET "https://${LXD_ADDR}/1.0/profiles" | jq -r .status_code)" = "200" ]
+  [ "$(ro_curl -X POST -d '{"name": "ro"}' "https://${LXD_ADDR}/1.0/profiles" | jq -r .error_code)" = "403" ]
+  [ "$(ro_curl -X DELETE "https://${LXD_ADDR}/1.0/profiles/default" | jq -r .error_code)" = "403" ]
+  ! lxc_remote profile show ro
+
+  # the web UI is served to trusted clients, which may only use the API
+  # from its pages
+  lxc_remote config set core.webui true
+  my_curl "https://${LXD_ADDR}/ui/" | grep -q "<title>LXD</title>"
+  my_curl "https://${LXD_ADDR}/ui/webui.js" | grep -q "use strict"
+  curl -k -s "https://${LXD_ADDR}/ui/" | grep -q "not authorized"
+  my_curl -H "Origin: https://${LXD_ADDR}" "https://${LXD_ADDR}/1.0/containers" | grep -q Success
+  my_curl -H "Origin: https://example.com" "https://${LXD_ADDR}/1.0/containers" | grep -q "not authorized"
+  lxc_remote config unset core.webui
+  my_curl "https://${LXD_ADDR}/ui/" | grep -q "not found"
+
   # Check that we can add domains with valid certs without confirmation:
 
   # avoid default high port behind some proxies:
@@ -102,6 +163,19 @@ test_remote_usage() {
   lxc_remote image copy "localhost:$(echo "${sum}" | colrm 3)" lxd2:
   lxc_remote image delete "lxd2:${sum}"
 
+  # relayed through the client
+  ! lxc_remote image copy "localhost:${sum}" lxd2: --mode=bogus
+  lxc_remote image copy "localhost:${sum}" lxd2: --mode=relay --copy-aliases
+  lxc_remote image info lxd2:testimage | grep -q "${sum}"
+  lxc_remote image delete "lxd2:${sum}"
+
+  # limited to a number of bytes per second
+  ! lxc_remote image copy "localhost:${sum}" lxd2: --bwlimit=fast
+  lxc_remote image copy "localhost:${sum}" lxd2: --bwlimit=100MB
+  lxc_remote image delete "lxd2:${sum}"
+  lxc_remote image copy "localhost:${sum}" lxd2: --bwlimit=100MB --mode=relay
+  lxc_remote image delete "lxd2:${sum}"
+
   # test a private image
   lxc_remote image copy "localhost:${sum}" lxd2:
   lxc_remote image delete "localhost:${sum}"
@@ -135,6 +209,29 @@ test_remote_usage() {
   # make sure it is running
   lxc_remote list lxd2: | grep c1 | grep RUNNING
   lxc_remote info lxd2:c1
+
+  # listing several remotes at once
+  lxc_remote list localhost: lxd2: --format=csv | grep -q "^lxd2,c1,RUNNING,"
+  lxc_remote list localhost: lxd2: --format=json | jq -e '.lxd2[0].state.name == "c1"'
+  lxc_remote list --all-remotes --format=csv | grep -q "^lxd2,c1,"
+  lxc_remote image list local: lxd2: --format=csv | grep -q "^local,testimage,"
+
+  # reaching a remote through the daemon of another one
+  ! lxc_remote remote add lxd2via "${LXD2_ADDR}" --accept-certificate --via localhost:
+  ! lxc_remote config set core.tunnel_addresses bogus
+  lxc_remote config set core.tunnel_addresses 127.0.0.1
+  lxc_remote remote add lxd2via "${LXD2_ADDR}" --accept-certificate --via localhost:
+  lxc_remote remote list --format=csv | grep -q "^lxd2via,.*,localhost$"
+  lxc_remote list lxd2via: --format=csv | grep -q "^c1,RUNNING,"
+  [ "$(lxc_remote exec lxd2via:c1 -- echo tunneled)" = "tunneled" ]
+  ! lxc_remote config set-remote localhost via lxd2via
+  ! lxc_remote config set-remote lxd2via via nonexistent
+  ! lxc_remote config set-remote local via localhost
+  ! lxc_remote remote remove localhost
+  lxc_remote remote remove lxd2via
+  lxc_remote config unset core.tunnel_addresses
+  ! lxc_remote remote add lxd2ssh "${LXD2_ADDR}" --accept-certificate --via ssh://-oProxyCommand=true
+
   lxc_remote stop lxd2:c1 --force
   lxc_remote delete lxd2:c1
 }
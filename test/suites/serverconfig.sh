@@ -13,6 +13,93 @@ test_server_config() {
   lxc config unset core.trust_password
   lxc config show | grep -q -v "trust_password"
 
+  # test the server info
+  lxc info | grep -q "^Auth: trusted"
+  lxc info | grep -q "^Kernel: Linux"
+  lxc info --format=json | jq -r .environment.server_version | grep -q .
+  lxc info --format=json | jq -r '.environment.drivers.lxc.capabilities[]' | grep -q '^exec.interactive$'
+  lxc info --format=json | jq -e '.environment.driver_features | has("seccomp_notify")'
+
+  # test the host resources
+  lxc info --resources | grep -q "sockets:"
+  lxc info --resources | grep -q "total:"
+
+  # test the storage reporting
+  lxc storage info | grep -q "^Driver: ${LXD_BACKEND}"
+  lxc storage info | grep -q "^Available: "
+  [ "$(lxc storage info --format=json | jq -r .total)" -gt 0 ]
+
+  # container creation can be refused past the storage usage threshold
+  ensure_import_testimage
+  ! lxc config set storage.usage_threshold 150
+  lxc config set storage.usage_threshold 0.001
+  lxc config set storage.usage_refuse_create true
+  ! lxc init testimage refused
+  lxc config unset storage.usage_refuse_create
+  lxc init testimage refused
+
+  # failed operations are kept in the history
+  ! lxc config set core.operations_history_expiry -1
+  ! lxc config set refused invalid.key true
+  lxc operation list --status=failure --since=1h --format=csv | grep -q "PUT /1.0/containers/refused,Failure,"
+  op=$(lxc operation list --status=failure --since=1h --format=json | jq -r '.[-1].id')
+  [ "$(lxc operation list --status=failure --since=1h --format=json | jq -r '.[-1].initiator')" = "unix" ]
+  ! lxc operation list --since=yesterday
+  sleep 6
+  my_curl "https://${LXD_ADDR}/1.0/operations/${op}" | jq -r .metadata.err | grep -q "invalid.key"
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/operations?status=failure&since=1h" | jq -r '.metadata.failure | length')" -ge 1 ]
+  lxc operation show "${op}" | grep -q "^Status: Failure"
+  lxc operation show "${op}" | grep -q "^  containers/refused"
+  [ "$(lxc operation show "${op}" --format=json | jq -r .id)" = "${op}" ]
+  # done operations can't be cancelled
+  ! lxc operation delete "${op}"
+  lxc delete refused
+  lxc config unset storage.usage_threshold
+
+  # the dnsmasq of the bridges get the names of the containers from the daemon
+  ! lxc config set network.dns.domain "bad..domain"
+  lxc config set network.dns.domain containers.example
+  grep -q "^domain=containers.example$" "${LXD_SERVERCONFIG_DIR}/networks/dnsmasq.conf"
+  grep -q "^dhcp-hostsdir=${LXD_SERVERCONFIG_DIR}/networks/dhcp-hosts$" "${LXD_SERVERCONFIG_DIR}/networks/dnsmasq.conf"
+  lxc config unset network.dns.domain
+  grep -q "^domain=lxd$" "${LXD_SERVERCONFIG_DIR}/networks/dnsmasq.conf"
+
+  # the address the migration tunnels are reached at takes no port
+  ! lxc config set migration.wireguard_address "10.0.0.1:51820"
+  ! lxc config set migration.wireguard_address "[fd00::1]"
+  lxc config set migration.wireguard_address fd00::1
+  lxc config set migration.wireguard_address lxd1.example
+  lxc config unset migration.wireguard_address
+
+  # the transfers can be limited to a number of bytes per second
+  ! lxc config set images.bandwidth_limit fast
+  ! lxc config set migration.bandwidth_limit 10mb
+  lxc config set images.bandwidth_limit 10MB
+  lxc config set migration.bandwidth_limit 512kB
+  lxc config unset images.bandwidth_limit
+  lxc config unset migration.bandwidth_limit
+
   # test untrusted server GET
   my_curl -X GET "https://$(cat "${LXD_SERVERCONFIG_DIR}/lxd.addr")/1.0" | grep -v -q environment
+
+  # the https address can be moved and the certificate reloaded at runtime
+  old_addr=$(cat "${LXD_SERVERCONFIG_DIR}/lxd.addr")
+  new_addr="127.0.0.1:$(local_tcp_port)"
+  LXD_DIR="${LXD_SERVERCONFIG_DIR}" lxc config set core.https_address "${new_addr}"
+  my_curl -X GET "https://${new_addr}/1.0" | grep -q untrusted
+  ! my_curl -X GET "https://${old_addr}/1.0"
+  kill -HUP "$(cat "${LXD_SERVERCONFIG_DIR}/lxd.pid")"
+  my_curl -X GET "https://${new_addr}/1.0" | grep -q untrusted
+  LXD_DIR="${LXD_SERVERCONFIG_DIR}" lxc config set core.https_address "${old_addr}"
+  my_curl -X GET "https://${old_addr}/1.0" | grep -q untrusted
+
+  # several addresses can be listened on, each with its own policy
+  images_addr="127.0.0.1:$(local_tcp_port)"
+  LXD_DIR="${LXD_SERVERCONFIG_DIR}" lxc config set core.https_address "${old_addr},${images_addr};images"
+  my_curl -X GET "https://${old_addr}/1.0" | grep -q untrusted
+  my_curl -X GET "https://${images_addr}/1.0/images" | grep -q Success
+  my_curl -X GET "https://${images_addr}/1.0/containers" | grep -q "not authorized"
+  ! LXD_DIR="${LXD_SERVERCONFIG_DIR}" lxc config set core.https_address "${old_addr};bogus"
+  LXD_DIR="${LXD_SERVERCONFIG_DIR}" lxc config set core.https_address "${old_addr}"
+  ! my_curl -X GET "https://${images_addr}/1.0"
 }
@@ -18,13 +18,25 @@ test_snapshots() {
     [ -d "${LXD_DIR}/snapshots/foo/snap1" ]
   fi
 
+  lxc config set foo user.state tester
   lxc snapshot foo tester
+  lxc config set foo user.state after
   # FIXME: make this backend agnostic
   if [ "${LXD_BACKEND}" = "dir" ]; then
     [ -d "${LXD_DIR}/snapshots/foo/tester" ]
   fi
 
+  # disk usage is reported for the container and its snapshots
+  lxc info foo | grep -q "^Disk usage: "
+  lxc info foo | grep -q "^  tester ("
+
+  # recursion=2 includes the snapshots
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers?recursion=2" | jq -r '.metadata[] | select(.state.name == "foo") | .snapshots[].name' | sort | tr '\n' ' ')" = "snap0 snap1 tester " ]
+
+  # containers created from a snapshot get the configuration it was taken with
+  my_curl "https://${LXD_ADDR}/1.0/containers/foo/snapshots/tester" | jq -r '.metadata.config["user.state"]' | grep -qx tester
   lxc copy foo/tester foosnap1
+  lxc config get foosnap1 user.state | grep -q tester
   # FIXME: make this backend agnostic
   if [ "${LXD_BACKEND}" != "lvm" ]; then
     [ -d "${LXD_DIR}/containers/foosnap1/rootfs" ]
@@ -36,8 +48,24 @@ test_snapshots() {
     [ ! -d "${LXD_DIR}/snapshots/foo/snap0" ]
   fi
 
-  # no CLI for this, so we use the API directly (rename a snapshot)
-  wait_for "${LXD_ADDR}" my_curl -X POST "https://${LXD_ADDR}/1.0/containers/foo/snapshots/tester" -d "{\"name\":\"tester2\"}"
+  # snapshots are listed oldest first with their creation date and size
+  [ "$(lxc snapshot list foo --format=csv | cut -d, -f1 | tr '\n' ' ')" = "snap1 tester " ]
+  lxc snapshot list foo --format=csv | grep -q "^tester,[0-9/]* [0-9:]* UTC,no,"
+  [ "$(lxc snapshot list foo --format=json | jq -r '.[1].created_at')" != "0001-01-01T00:00:00Z" ]
+
+  # snapshots can expire, the expiry can be changed afterwards
+  lxc snapshot foo expiring --expiry=7d
+  lxc snapshot list foo --format=csv | grep -q "^expiring,.*,[0-9/]* [0-9:]* UTC$"
+  lxc snapshot expiry foo expiring 2099-01-01
+  [ "$(my_curl "https://${LXD_ADDR}/1.0/containers/foo/snapshots/expiring" | jq -r .metadata.expires_at)" = "2099-01-01T00:00:00Z" ]
+  lxc snapshot expiry foo expiring never
+  lxc snapshot list foo --format=csv | grep -q "^expiring,.*,$"
+  ! lxc snapshot expiry foo expiring soon
+  lxc delete foo/expiring
+
+  lxc snapshot rename foo tester tester2
+  ! lxc snapshot rename foo snap1 tester2
+  ! lxc snapshot rename foo snap1 a/b
   # FIXME: make this backend agnostic
   if [ "${LXD_BACKEND}" = "dir" ]; then
     [ ! -d "${LXD_DIR}/snapshots/foo/tester" ]
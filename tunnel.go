@@ -0,0 +1,204 @@
+package lxd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// viaDialer returns a dialer connecting through the jump host of a remote,
+// see RemoteConfig.Via, whatever the network is. The API requests and the
+// websockets of the remote then all go through it.
+func viaDialer(config *Config, via string, options ClientOptions) func(network, addr string) (net.Conn, error) {
+	if strings.HasPrefix(via, "ssh://") {
+		return func(network, addr string) (net.Conn, error) {
+			return sshDial(via, addr)
+		}
+	}
+
+	// The client of the daemon in the middle is only made once needed, it
+	// may be behind a jump host of its own
+	var lock sync.Mutex
+	var jump *Client
+	return func(network, addr string) (net.Conn, error) {
+		lock.Lock()
+		if jump == nil {
+			c, err := NewClientWithOptions(config, via, options)
+			if err != nil {
+				lock.Unlock()
+				return nil, err
+			}
+
+			jump = c
+		}
+		lock.Unlock()
+
+		return jump.Tunnel(addr)
+	}
+}
+
+// Tunnel opens a TCP connection from the daemon to the address, which the
+// client may not be able to reach on its own.
+func (c *Client) Tunnel(address string) (net.Conn, error) {
+	if c.Transport != "https" {
+		return nil, fmt.Errorf("can't connect through %s, it's a unix socket", c.Name)
+	}
+
+	query := url.Values{"address": []string{address}}
+	conn, err := WebsocketDial(c.websocketDialer, c.BaseWSURL+"/"+path.Join(shared.APIVersion, "tunnel")+"?"+query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	return shared.WebsocketConn(conn), nil
+}
+
+// sshConn is a connection forwarded by "ssh -W" through a bastion, as
+// OpenSSH's ProxyJump does. Deadlines aren't supported.
+type sshConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   sshAddr
+}
+
+type sshAddr string
+
+func (a sshAddr) Network() string {
+	return "ssh"
+}
+
+func (a sshAddr) String() string {
+	return string(a)
+}
+
+// sshBastionParse parses an SSH bastion given as ssh://[user@]host[:port].
+// Nothing of it may be taken for an option of ssh, like -oProxyCommand=.
+func sshBastionParse(via string) (*url.URL, error) {
+	u, err := url.Parse(via)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "ssh" || u.Hostname() == "" || strings.HasPrefix(u.Hostname(), "-") || (u.User != nil && strings.HasPrefix(u.User.Username(), "-")) {
+		return nil, fmt.Errorf("invalid SSH bastion: %s", via)
+	}
+
+	return u, nil
+}
+
+// sshDial connects to the address through the SSH bastion given as
+// ssh://[user@]host[:port], with the ssh command so that the user's keys,
+// agent and ssh_config all apply.
+func sshDial(via string, address string) (net.Conn, error) {
+	u, err := sshBastionParse(via)
+	if err != nil {
+		return nil, err
+	}
+
+	if strings.HasPrefix(address, "-") {
+		return nil, fmt.Errorf("invalid address: %s", address)
+	}
+
+	args := []string{"-W", address}
+	if u.Port() != "" {
+		args = append(args, "-p", u.Port())
+	}
+
+	host := u.Hostname()
+	if u.User != nil {
+		host = u.User.Username() + "@" + host
+	}
+	args = append(args, "--", host)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &sshConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: sshAddr(address)}, nil
+}
+
+func (c *sshConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *sshConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+func (c *sshConn) Close() error {
+	c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *sshConn) LocalAddr() net.Addr {
+	return sshAddr("ssh")
+}
+
+func (c *sshConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *sshConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sshConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *sshConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+// proxyDialer returns a dialer connecting through the proxy of a remote, see
+// RemoteConfig.Proxy, or else the one of the environment.
+func proxyDialer(proxy string, dial func(network, addr string) (net.Conn, error)) func(network, addr string) (net.Conn, error) {
+	if proxy == "none" {
+		return dial
+	}
+
+	return func(network, addr string) (net.Conn, error) {
+		var u *url.URL
+		var err error
+		if proxy != "" {
+			u, err = shared.ParseProxyURL(proxy)
+		} else {
+			u, err = shared.ProxyFromEnvironment(addr)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if u == nil {
+			return dial(network, addr)
+		}
+
+		return shared.ProxyDialer(u, dial)(network, addr)
+	}
+}
@@ -2,6 +2,9 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
@@ -22,12 +25,51 @@ func (c *actionCmd) showByDefault() bool {
 
 var timeout = -1
 var force = false
+var actionAll = false
+var actionWaitIP = false
+var actionWaitReady = false
 
 func (c *actionCmd) usage() string {
+	if c.hasTimeout {
+		return fmt.Sprintf(i18n.G(
+			`Changes state of one or more containers to %s.
+
+lxc %s <name> [<name>...] [--timeout=<seconds>] [--force]
+lxc %s [<remote>:] --all [--timeout=<seconds>] [--force]
+
+With --timeout, the containers are asked to shutdown cleanly and then
+killed if they're still running after that many seconds.`), c.name, c.name, c.name)
+	}
+
+	if c.action == shared.Start {
+		return fmt.Sprintf(i18n.G(
+			`Changes state of one or more containers to %s.
+
+lxc %s <name> [<name>...] [--wait-ip] [--wait-ready] [--timeout=<seconds>]
+lxc %s [<remote>:] --all
+
+With --wait-ip, only return once the containers have a global IPv4 address.
+With --wait-ready, only return once the containers are ready, which for those
+with user.ready-signal=true means they signaled it through /dev/lxd.
+Waiting gives up after --timeout seconds if given.`), c.name, c.name, c.name)
+	}
+
+	if c.action == shared.Freeze {
+		return fmt.Sprintf(i18n.G(
+			`Changes state of one or more containers to %s.
+
+lxc %s <name> [<name>...] [--timeout=<seconds>]
+lxc %s [<remote>:] --all [--timeout=<seconds>]
+
+Containers which can't be frozen within --timeout seconds (30 by default),
+usually because of a process stuck in the kernel, are thawed back.`), c.name, c.name, c.name)
+	}
+
 	return fmt.Sprintf(i18n.G(
 		`Changes state of one or more containers to %s.
 
-lxc %s <name> [<name>...]`), c.name, c.name)
+lxc %s <name> [<name>...]
+lxc %s [<remote>:] --all`), c.name, c.name, c.name)
 }
 
 func (c *actionCmd) flags() {
@@ -35,32 +77,138 @@ func (c *actionCmd) flags() {
 		gnuflag.IntVar(&timeout, "timeout", -1, i18n.G("Time to wait for the container before killing it."))
 		gnuflag.BoolVar(&force, "force", false, i18n.G("Force the container to shutdown."))
 	}
+	if c.action == shared.Start {
+		gnuflag.BoolVar(&actionWaitIP, "wait-ip", false, i18n.G("Wait for the containers to get an IPv4 address."))
+		gnuflag.BoolVar(&actionWaitReady, "wait-ready", false, i18n.G("Wait for the containers to be ready."))
+		gnuflag.IntVar(&timeout, "timeout", -1, i18n.G("Time to wait for the containers."))
+	}
+	if c.action == shared.Freeze {
+		gnuflag.IntVar(&timeout, "timeout", -1, i18n.G("Time to wait for the containers to freeze."))
+	}
+	gnuflag.BoolVar(&actionAll, "all", false, i18n.G("Run against all the containers"))
 }
 
-func (c *actionCmd) run(config *lxd.Config, args []string) error {
-	if len(args) == 0 {
-		return errArgs
+// actionApplies tells whether the action makes sense for a container in the
+// given state, this is used to pick the containers for --all.
+func (c *actionCmd) actionApplies(status shared.StatusCode) bool {
+	switch c.action {
+	case shared.Start:
+		return status == shared.Stopped || status == shared.Frozen
+	case shared.Freeze:
+		return status == shared.Running
+	case shared.Unfreeze:
+		return status == shared.Frozen
+	default:
+		return status == shared.Running || status == shared.Frozen
+	}
+}
+
+func (c *actionCmd) doAction(config *lxd.Config, nameArg string) error {
+	remote, name := config.ParseRemoteAndContainer(nameArg)
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.Action(name, c.action, timeout, force)
+	if err != nil {
+		return err
+	}
+
+	if resp.Type != lxd.Async {
+		return fmt.Errorf(i18n.G("bad result type from action"))
+	}
+
+	if err := d.WaitForSuccess(resp.Operation); err != nil {
+		return fmt.Errorf("%s\n"+i18n.G("Try `lxc info --show-log %s` for more info"), err, nameArg)
+	}
+
+	conditions := []string{}
+	if actionWaitIP {
+		conditions = append(conditions, "ipv4")
 	}
 
-	for _, nameArg := range args {
-		remote, name := config.ParseRemoteAndContainer(nameArg)
+	if actionWaitReady {
+		conditions = append(conditions, "ready")
+	}
+
+	if len(conditions) > 0 {
+		_, err := d.ContainerStateWait(name, strings.Join(conditions, ","), timeout)
+		return err
+	}
+
+	return nil
+}
+
+func (c *actionCmd) run(config *lxd.Config, args []string) error {
+	if actionAll {
+		if len(args) > 1 {
+			return errArgs
+		}
+
+		remote := config.DefaultRemote
+		if len(args) == 1 {
+			remote, _ = config.ParseRemoteAndContainer(args[0])
+		}
+
 		d, err := lxd.NewClient(config, remote)
 		if err != nil {
 			return err
 		}
 
-		resp, err := d.Action(name, c.action, timeout, force)
+		cts, err := d.ListContainers()
 		if err != nil {
 			return err
 		}
 
-		if resp.Type != lxd.Async {
-			return fmt.Errorf(i18n.G("bad result type from action"))
+		args = []string{}
+		for _, ct := range cts {
+			if !c.actionApplies(ct.State.Status.StatusCode) {
+				continue
+			}
+
+			args = append(args, fmt.Sprintf("%s:%s", remote, ct.State.Name))
+		}
+
+		if len(args) == 0 {
+			return nil
 		}
+	}
+
+	if len(args) == 0 {
+		return errArgs
+	}
+
+	// Only one container, no need to decorate the error
+	if len(args) == 1 {
+		return c.doAction(config, args[0])
+	}
+
+	// Run everything in parallel and report all the failures at the end
+	var wg sync.WaitGroup
+	errs := make([]error, len(args))
+	for i, nameArg := range args {
+		wg.Add(1)
+		go func(i int, nameArg string) {
+			defer wg.Done()
+			errs[i] = c.doAction(config, nameArg)
+		}(i, nameArg)
+	}
+	wg.Wait()
 
-		if err := d.WaitForSuccess(resp.Operation); err != nil {
-			return fmt.Errorf("%s\n"+i18n.G("Try `lxc info --show-log %s` for more info"), err, name)
+	failed := []string{}
+	for i, err := range errs {
+		if err == nil {
+			continue
 		}
+
+		failed = append(failed, args[i])
+		fmt.Fprintf(os.Stderr, i18n.G("error: %s: %s")+"\n", args[i], err)
 	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf(i18n.G("Failed to %s %d of %d containers: %s"), c.name, len(failed), len(args), strings.Join(failed, ", "))
+	}
+
 	return nil
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+)
+
+// Aliases available out of the box, those in the client config override them.
+var defaultAliases = map[string]string{
+	"shell": "exec @ARG@ -- su -l",
+}
+
+type aliasCmd struct{}
+
+func (c *aliasCmd) showByDefault() bool {
+	return true
+}
+
+func (c *aliasCmd) usage() string {
+	return i18n.G(
+		`Manage command aliases.
+
+lxc alias add <alias> <target>      Add a new alias <alias> pointing to <target>.
+lxc alias remove <alias>            Remove the alias <alias>.
+lxc alias list                      List all the aliases.
+
+In the target, @ARG@ is replaced by the next argument given to the alias and
+@ARGS@ by all the remaining ones, those are appended to the command otherwise.
+
+Example:
+lxc alias add shell "exec @ARG@ -- su -l"`)
+}
+
+func (c *aliasCmd) flags() {}
+
+func (c *aliasCmd) run(config *lxd.Config, args []string) error {
+	if len(args) < 1 {
+		return errArgs
+	}
+
+	switch args[0] {
+	case "add":
+		if len(args) != 3 {
+			return errArgs
+		}
+
+		if _, ok := commands[args[1]]; ok {
+			return fmt.Errorf(i18n.G("%s is a command and can't be used as an alias"), args[1])
+		}
+
+		target := strings.Fields(args[2])
+		if len(target) == 0 {
+			return fmt.Errorf(i18n.G("The alias target can't be empty"))
+		}
+
+		if _, ok := commands[target[0]]; !ok {
+			return fmt.Errorf(i18n.G("Unknown command in the alias target: %s"), target[0])
+		}
+
+		if config.Aliases == nil {
+			config.Aliases = map[string]string{}
+		}
+		config.Aliases[args[1]] = args[2]
+
+		return lxd.SaveConfig(config)
+
+	case "remove":
+		if len(args) != 2 {
+			return errArgs
+		}
+
+		if _, ok := config.Aliases[args[1]]; !ok {
+			if _, ok := defaultAliases[args[1]]; ok {
+				return fmt.Errorf(i18n.G("%s is a built-in alias, it can only be overridden"), args[1])
+			}
+
+			return fmt.Errorf(i18n.G("Alias %s doesn't exist"), args[1])
+		}
+
+		delete(config.Aliases, args[1])
+
+		return lxd.SaveConfig(config)
+
+	case "list":
+		aliases := aliasesGet(config)
+
+		data := [][]string{}
+		for name, target := range aliases {
+			data = append(data, []string{name, target})
+		}
+
+		sort.Sort(ByName(data))
+		list := outputList{
+			header: []string{
+				i18n.G("ALIAS"),
+				i18n.G("TARGET")},
+			rows: data,
+			data: aliases,
+		}
+
+		return list.render()
+	}
+
+	return errArgs
+}
+
+// aliasesGet returns the aliases from the config on top of the built-in ones.
+func aliasesGet(config *lxd.Config) map[string]string {
+	aliases := map[string]string{}
+	for name, target := range defaultAliases {
+		aliases[name] = target
+	}
+
+	for name, target := range config.Aliases {
+		aliases[name] = target
+	}
+
+	return aliases
+}
+
+// expandAlias builds the command line an alias stands for. @ARG@ takes the
+// next argument and @ARGS@ all of the remaining ones, which are appended to
+// the command if it doesn't use them.
+func expandAlias(target string, args []string) ([]string, error) {
+	result := []string{}
+	usedArgs := false
+
+	for _, field := range strings.Fields(target) {
+		switch field {
+		case "@ARG@":
+			if len(args) == 0 {
+				return nil, fmt.Errorf(i18n.G("Not enough arguments for the alias: %s"), target)
+			}
+
+			result = append(result, args[0])
+			args = args[1:]
+		case "@ARGS@":
+			result = append(result, args...)
+			args = nil
+			usedArgs = true
+		default:
+			result = append(result, field)
+		}
+	}
+
+	if !usedArgs {
+		result = append(result, args...)
+	}
+
+	return result, nil
+}
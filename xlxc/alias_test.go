@@ -0,0 +1,36 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExpandAlias(t *testing.T) {
+	tests := []struct {
+		target   string
+		args     []string
+		expected []string
+	}{
+		{"exec @ARG@ -- su -l", []string{"c1"}, []string{"exec", "c1", "--", "su", "-l"}},
+		{"exec @ARG@ -- su -l", []string{"c1", "user"}, []string{"exec", "c1", "--", "su", "-l", "user"}},
+		{"list @ARGS@ -c n", []string{"local:", "foo"}, []string{"list", "local:", "foo", "-c", "n"}},
+		{"list", nil, []string{"list"}},
+	}
+
+	for _, test := range tests {
+		result, err := expandAlias(test.target, test.args)
+		if err != nil {
+			t.Errorf("Failed to expand %q: %v", test.target, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Expanding %q with %v gave %v instead of %v", test.target, test.args, result, test.expected)
+		}
+	}
+
+	_, err := expandAlias("exec @ARG@ -- su -l", nil)
+	if err == nil {
+		t.Error("Missing alias argument wasn't caught")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// The config key holding the extra groups of a container, comma separated.
+const ansibleGroupKey = "user.ansible_group"
+
+var ansibleInvalidChars = regexp.MustCompile("[^A-Za-z0-9_]")
+
+// ansibleGroupName turns a name into something Ansible accepts as a group.
+func ansibleGroupName(name string) string {
+	return ansibleInvalidChars.ReplaceAllString(name, "_")
+}
+
+// ansibleHost returns the first global address of the container, if any.
+func ansibleHost(status *shared.ContainerStatus) string {
+	for _, entry := range status.Ips {
+		ip := net.ParseIP(entry.Address)
+		if entry.Interface != "lo" && ip != nil && ip.IsGlobalUnicast() {
+			return entry.Address
+		}
+	}
+
+	return ""
+}
+
+// ansibleInventory builds an Ansible dynamic inventory out of the containers,
+// hosts[i] being the host name to use for cinfos[i]. The hosts are grouped by
+// profile, status and the groups listed in user.ansible_group.
+func ansibleInventory(hosts []string, cinfos []shared.ContainerInfo, remotes []string) map[string]interface{} {
+	groups := map[string][]string{}
+	hostvars := map[string]map[string]string{}
+
+	for i, cinfo := range cinfos {
+		host := hosts[i]
+		state := cinfo.State
+		vars := map[string]string{}
+
+		address := ansibleHost(&state.Status)
+		if address != "" {
+			vars["ansible_host"] = address
+		}
+
+		memberOf := []string{"status_" + strings.ToLower(state.Status.Status)}
+		for _, profile := range state.Profiles {
+			memberOf = append(memberOf, "profile_"+profile)
+		}
+
+		for _, group := range strings.Split(state.ExpandedConfig[ansibleGroupKey], ",") {
+			group = strings.TrimSpace(group)
+			if group != "" {
+				memberOf = append(memberOf, group)
+			}
+		}
+
+		if remotes != nil {
+			vars["lxd_remote"] = remotes[i]
+			memberOf = append(memberOf, "remote_"+remotes[i])
+		}
+
+		for _, group := range memberOf {
+			group = ansibleGroupName(group)
+			if !shared.StringInSlice(host, groups[group]) {
+				groups[group] = append(groups[group], host)
+			}
+		}
+
+		hostvars[host] = vars
+	}
+
+	inventory := map[string]interface{}{
+		"_meta": map[string]interface{}{"hostvars": hostvars},
+	}
+
+	for group, members := range groups {
+		sort.Strings(members)
+		inventory[group] = map[string]interface{}{"hosts": members}
+	}
+
+	return inventory
+}
+
+func ansibleRender(inventory map[string]interface{}) error {
+	out, err := json.MarshalIndent(inventory, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s\n", out)
+	return nil
+}
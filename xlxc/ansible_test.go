@@ -0,0 +1,59 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestAnsibleInventory(t *testing.T) {
+	cinfos := []shared.ContainerInfo{
+		{State: shared.ContainerState{
+			Name:           "web-1",
+			Profiles:       []string{"default", "web.prod"},
+			ExpandedConfig: map[string]string{"user.ansible_group": "webservers, frontend"},
+			Status: shared.ContainerStatus{Status: "Running", Ips: []shared.Ip{
+				{Interface: "lo", Protocol: "IPV4", Address: "127.0.0.1"},
+				{Interface: "eth0", Protocol: "IPV6", Address: "fe80::1"},
+				{Interface: "eth0", Protocol: "IPV4", Address: "10.0.3.5"},
+			}},
+		}},
+		{State: shared.ContainerState{
+			Name:     "db",
+			Profiles: []string{"default"},
+			Status:   shared.ContainerStatus{Status: "Stopped"},
+		}},
+	}
+
+	inventory := ansibleInventory([]string{"web-1", "db"}, cinfos, nil)
+
+	groups := map[string][]string{
+		"status_running":   {"web-1"},
+		"status_stopped":   {"db"},
+		"profile_default":  {"db", "web-1"},
+		"profile_web_prod": {"web-1"},
+		"webservers":       {"web-1"},
+		"frontend":         {"web-1"},
+	}
+
+	for group, hosts := range groups {
+		entry, ok := inventory[group].(map[string]interface{})
+		if !ok || !reflect.DeepEqual(entry["hosts"], hosts) {
+			t.Errorf("Wrong hosts for %s: %v", group, inventory[group])
+		}
+	}
+
+	if len(inventory) != len(groups)+1 {
+		t.Errorf("Unexpected groups: %v", inventory)
+	}
+
+	hostvars := inventory["_meta"].(map[string]interface{})["hostvars"].(map[string]map[string]string)
+	if hostvars["web-1"]["ansible_host"] != "10.0.3.5" {
+		t.Errorf("Wrong ansible_host: %v", hostvars["web-1"])
+	}
+
+	if _, ok := hostvars["db"]["ansible_host"]; ok {
+		t.Errorf("Stopped container has an ansible_host: %v", hostvars["db"])
+	}
+}
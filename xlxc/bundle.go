@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+)
+
+// bundle is the YAML document written by the export commands and read by
+// the import ones, so that the setup of a daemon can be kept under version
+// control. It's also a valid `lxd init --preseed` document.
+type bundle struct {
+	Config   map[string]string       `yaml:"config,omitempty"`
+	Networks []shared.PreseedNetwork `yaml:"networks,omitempty"`
+	Profiles []bundleProfile         `yaml:"profiles,omitempty"`
+	Aliases  []shared.PreseedAlias   `yaml:"aliases,omitempty"`
+}
+
+// bundleProfile is a profile without what the server computes.
+type bundleProfile struct {
+	Name    string            `yaml:"name"`
+	Config  map[string]string `yaml:"config,omitempty"`
+	Devices shared.Devices    `yaml:"devices,omitempty"`
+}
+
+func (b *bundle) preseed() shared.Preseed {
+	preseed := shared.Preseed{
+		Config:   b.Config,
+		Networks: b.Networks,
+		Aliases:  b.Aliases,
+	}
+
+	for _, profile := range b.Profiles {
+		preseed.Profiles = append(preseed.Profiles, shared.ProfileConfig{
+			Name:    profile.Name,
+			Config:  profile.Config,
+			Devices: profile.Devices,
+		})
+	}
+
+	return preseed
+}
+
+func (b *bundle) write() error {
+	data, err := yaml.Marshal(b)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", data)
+	return nil
+}
+
+// bundleImportArgs splits the [<remote>:] [<file>] arguments of the import
+// commands.
+func bundleImportArgs(config *lxd.Config, args []string) (string, string, error) {
+	remote := config.DefaultRemote
+	if len(args) > 0 && strings.HasSuffix(args[0], ":") {
+		remote = config.ParseRemote(args[0])
+		args = args[1:]
+	}
+
+	switch len(args) {
+	case 0:
+		return remote, "", nil
+	case 1:
+		return remote, args[0], nil
+	default:
+		return "", "", errArgs
+	}
+}
+
+// bundleRead parses the bundle in the file, or stdin if there's none.
+func bundleRead(path string) (*bundle, error) {
+	var content []byte
+	var err error
+	if path == "" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		content, err = ioutil.ReadFile(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	b := bundle{}
+	err = yaml.Unmarshal(content, &b)
+	if err != nil {
+		return nil, fmt.Errorf(i18n.G("Failed to parse the bundle: %s"), err)
+	}
+
+	return &b, nil
+}
+
+// bundleApply changes what differs from the bundle on the server and prints
+// what that was.
+func bundleApply(d *lxd.Client, b *bundle) error {
+	changes, err := d.ApplyPreseed(b.preseed())
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Println(i18n.G("Nothing to change"))
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+
+	return nil
+}
+
+// bundleProfiles returns the given profiles, all of them if none is given.
+func bundleProfiles(d *lxd.Client, names []string) ([]bundleProfile, error) {
+	if len(names) == 0 {
+		var err error
+		names, err = d.ListProfiles()
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(names)
+
+	profiles := []bundleProfile{}
+	for _, name := range names {
+		profile, err := d.ProfileConfig(name)
+		if err != nil {
+			return nil, err
+		}
+
+		profiles = append(profiles, bundleProfile{
+			Name:    profile.Name,
+			Config:  profile.Config,
+			Devices: profile.Devices,
+		})
+	}
+
+	return profiles, nil
+}
+
+// bundleNetworks returns the given bridges, all of them if none is given.
+// Those are the only networks the server knows how to create.
+func bundleNetworks(d *lxd.Client, names []string) ([]shared.PreseedNetwork, error) {
+	all, err := d.NetworksInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	types := map[string]string{}
+	for _, network := range all {
+		types[network.Name] = network.Type
+		if len(names) == 0 && network.Type == "bridge" {
+			names = append(names, network.Name)
+		}
+	}
+	sort.Strings(names)
+
+	networks := []shared.PreseedNetwork{}
+	for _, name := range names {
+		switch types[name] {
+		case "bridge":
+			networks = append(networks, shared.PreseedNetwork{Name: name, Type: "bridge"})
+		case "":
+			return nil, fmt.Errorf(i18n.G("Network %s not found"), name)
+		default:
+			return nil, fmt.Errorf(i18n.G("Only bridges can be exported, %s is of type %s"), name, types[name])
+		}
+	}
+
+	return networks, nil
+}
+
+// bundleAliases returns all the image aliases.
+func bundleAliases(d *lxd.Client) ([]shared.PreseedAlias, error) {
+	names, err := d.ListNames("images/aliases")
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(names)
+
+	aliases := []shared.PreseedAlias{}
+	for _, name := range names {
+		alias, err := d.AliasInfo(name)
+		if err != nil {
+			return nil, err
+		}
+
+		aliases = append(aliases, shared.PreseedAlias{Name: name, Target: alias.Name, Description: alias.Description})
+	}
+
+	return aliases, nil
+}
+
+// bundleServerConfig returns the server config, without the trust password
+// which can't be read back.
+func bundleServerConfig(d *lxd.Client) (map[string]string, error) {
+	status, err := d.ServerStatus()
+	if err != nil {
+		return nil, err
+	}
+
+	config := map[string]string{}
+	for key, value := range status.Config {
+		if key == "core.trust_password" {
+			continue
+		}
+
+		config[key] = fmt.Sprintf("%v", value)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestBundleRoundTrip(t *testing.T) {
+	b := bundle{
+		Config:   map[string]string{"images.remote_cache_expiry": "15"},
+		Networks: []shared.PreseedNetwork{{Name: "lxdbr0", Type: "bridge"}},
+		Profiles: []bundleProfile{
+			{Name: "default", Devices: shared.Devices{"eth0": shared.Device{"type": "nic", "nictype": "bridged", "parent": "lxdbr0"}}},
+			{Name: "small", Config: map[string]string{"limits.memory": "1GB"}},
+		},
+		Aliases: []shared.PreseedAlias{{Name: "xenial", Target: "abcdef", Description: "xenial"}},
+	}
+
+	data, err := yaml.Marshal(&b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := bundle{}
+	err = yaml.Unmarshal(data, &parsed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(parsed, b) {
+		t.Errorf("Bundle changed through YAML:\n%s", data)
+	}
+
+	// lxd init --preseed has to take it as well
+	preseed := shared.Preseed{}
+	err = yaml.Unmarshal(data, &preseed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(preseed, b.preseed()) {
+		t.Errorf("Bundle isn't a valid preseed:\n%s", data)
+	}
+
+	// Only what was exported is there
+	data, err = yaml.Marshal(&bundle{Networks: b.Networks})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != "networks:\n- name: lxdbr0\n  type: bridge\n" {
+		t.Errorf("Unexpected network bundle:\n%s", data)
+	}
+}
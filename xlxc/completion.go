@@ -0,0 +1,334 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+)
+
+type completionCmd struct{}
+
+func (c *completionCmd) showByDefault() bool {
+	return false
+}
+
+func (c *completionCmd) usage() string {
+	return i18n.G(
+		`Prints a shell completion script.
+
+lxc completion bash
+lxc completion zsh
+
+To enable completion, add this to your shell startup file:
+    source <(lxc completion bash)`)
+}
+
+func (c *completionCmd) flags() {}
+
+const completionBash = `_lxc() {
+    local IFS=$'\n'
+    COMPREPLY=($(lxc completion __complete bash "${COMP_LINE:0:${COMP_POINT}}" 2>/dev/null))
+    if [ "${#COMPREPLY[@]}" = "1" ] && [ "${COMPREPLY[0]: -1}" = ":" ]; then
+        compopt -o nospace
+    fi
+}
+
+complete -F _lxc lxc
+`
+
+const completionZsh = `#compdef lxc
+
+_lxc() {
+    local -a candidates
+    candidates=("${(@f)$(lxc completion __complete zsh "${LBUFFER}" 2>/dev/null)}")
+    compadd -S '' -- ${(M)candidates:#*:}
+    compadd -- ${candidates:#*:}
+}
+
+compdef _lxc lxc
+`
+
+// Container configuration keys worth completing, volatile ones are left out.
+var completionConfigKeys = []string{
+	"boot.autostart",
+	"boot.autostart.delay",
+	"boot.autostart.priority",
+	"boot.restart.max_retries",
+	"boot.restart.policy",
+	"copy.identity_reset",
+	"hooks.post-start",
+	"hooks.pre-start",
+	"hooks.pre-stop",
+	"limits.autofreeze.idle_threshold",
+	"limits.autofreeze.idle_timeout",
+	"limits.cpu",
+	"limits.cpu.allowance",
+	"limits.cpu.nodes",
+	"limits.cpu.priority",
+	"limits.memory",
+	"limits.memory.enforce",
+	"limits.memory.swap",
+	"limits.memory.swap.priority",
+	"linux.kernel_modules",
+	"raw.apparmor",
+	"raw.lxc",
+	"raw.qemu",
+	"raw.seccomp",
+	"security.acls",
+	"security.apparmor.profile",
+	"security.exec_record",
+	"security.nesting",
+	"security.nesting.share_images",
+	"security.privileged",
+	"security.protection.delete",
+	"security.readonly_rootfs",
+	"security.readonly_rootfs.tmpfs",
+	"security.syscalls.blacklist",
+	"security.syscalls.intercept.mknod",
+	"security.syscalls.intercept.setxattr",
+	"security.syscalls.whitelist",
+	"security.time_offset",
+	"timezone",
+	"user.ansible_group",
+	"user.ready-signal",
+	"vm.cmdline",
+	"vm.initrd",
+	"vm.kernel",
+}
+
+// Subcommands of the commands which have some.
+var completionSubcommands = map[string][]string{
+	"alias":     {"add", "list", "remove"},
+	"config":    {"device", "dump", "edit", "get", "load", "set", "show", "trust", "unset"},
+	"file":      {"edit", "mount", "pull", "push"},
+	"image":     {"alias", "copy", "delete", "edit", "export", "export-bundle", "import", "import-bundle", "info", "list", "show"},
+	"network":   {"export", "import"},
+	"operation": {"list", "show", "delete"},
+	"profile":   {"apply", "copy", "create", "delete", "device", "edit", "export", "get", "import", "list", "set", "show", "unset"},
+	"remote":    {"add", "get-default", "list", "refresh-cache", "remove", "rename", "set-default", "set-url"},
+	"storage":   {"info"},
+}
+
+func (c *completionCmd) run(config *lxd.Config, args []string) error {
+	if len(args) == 3 && args[0] == "__complete" {
+		for _, candidate := range c.complete(config, args[1], args[2]) {
+			fmt.Println(candidate)
+		}
+
+		return nil
+	}
+
+	if len(args) != 1 {
+		return errArgs
+	}
+
+	switch args[0] {
+	case "bash":
+		fmt.Print(completionBash)
+	case "zsh":
+		fmt.Print(completionZsh)
+	default:
+		return fmt.Errorf(i18n.G("Unsupported shell: %s"), args[0])
+	}
+
+	return nil
+}
+
+// complete returns the candidates for the last word of a command line.
+func (c *completionCmd) complete(config *lxd.Config, shell string, line string) []string {
+	words := strings.Fields(line)
+	if len(words) == 0 {
+		return nil
+	}
+
+	current := ""
+	if !strings.HasSuffix(line, " ") {
+		current = words[len(words)-1]
+		words = words[:len(words)-1]
+	}
+
+	// Flags aren't completed and don't count as arguments
+	if strings.HasPrefix(current, "-") {
+		return nil
+	}
+
+	args := []string{}
+	for _, word := range words[1:] {
+		if !strings.HasPrefix(word, "-") {
+			args = append(args, word)
+		}
+	}
+
+	candidates := []string{}
+	seen := map[string]bool{}
+	for _, candidate := range c.candidates(config, args, current) {
+		if !strings.HasPrefix(candidate, current) || seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		// Bash treats the colon as a word separator
+		if shell == "bash" && strings.Contains(current, ":") {
+			candidate = candidate[strings.LastIndex(current, ":")+1:]
+		}
+
+		candidates = append(candidates, candidate)
+	}
+
+	sort.Strings(candidates)
+	return candidates
+}
+
+func (c *completionCmd) candidates(config *lxd.Config, args []string, current string) []string {
+	if len(args) == 0 {
+		names := []string{}
+		for name := range commands {
+			names = append(names, name)
+		}
+
+		for name := range aliasesGet(config) {
+			names = append(names, name)
+		}
+
+		return names
+	}
+
+	containers := func() []string { return c.names(config, "containers", current) }
+	position := len(args) - 1
+
+	switch args[0] {
+	case "help":
+		return c.candidates(config, nil, current)
+	case "delete", "info", "pause", "restart", "resume", "start", "stop":
+		return containers()
+	case "exec", "publish", "restore":
+		if position == 0 {
+			return containers()
+		}
+	case "snapshot":
+		if position == 0 {
+			return append(containers(), "expiry", "list", "rename")
+		} else if position == 1 && (args[1] == "expiry" || args[1] == "list" || args[1] == "rename") {
+			return containers()
+		}
+	case "copy", "move":
+		if position <= 1 {
+			return containers()
+		}
+	case "launch", "init":
+		if position == 0 {
+			return append(c.names(config, "images/aliases", current), c.names(config, "images", current)...)
+		}
+	case "finger", "list":
+		return c.remotes(config)
+	case "shell":
+		return containers()
+	case "alias", "config", "file", "image", "network", "operation", "profile", "remote", "storage":
+		if position == 0 {
+			return completionSubcommands[args[0]]
+		}
+
+		return c.subcommandCandidates(config, args, current)
+	}
+
+	return nil
+}
+
+func (c *completionCmd) subcommandCandidates(config *lxd.Config, args []string, current string) []string {
+	position := len(args) - 2
+
+	switch args[0] + " " + args[1] {
+	case "config get", "config set", "config unset":
+		if position == 0 {
+			return c.names(config, "containers", current)
+		} else if position == 1 {
+			return completionConfigKeys
+		}
+	case "config device", "config edit", "config show":
+		if position == 0 {
+			return c.names(config, "containers", current)
+		}
+	case "image delete", "image edit", "image export", "image export-bundle", "image info", "image show", "image copy":
+		if position == 0 {
+			return append(c.names(config, "images/aliases", current), c.names(config, "images", current)...)
+		}
+	case "profile apply":
+		if position == 0 {
+			return c.names(config, "containers", current)
+		} else if position == 1 {
+			return c.names(config, "profiles", current)
+		}
+	case "profile get", "profile set", "profile unset":
+		if position == 0 {
+			return c.names(config, "profiles", current)
+		} else if position == 1 {
+			return completionConfigKeys
+		}
+	case "profile copy", "profile delete", "profile device", "profile edit", "profile export", "profile show":
+		if position == 0 {
+			return c.names(config, "profiles", current)
+		}
+	case "remote refresh-cache", "remote remove", "remote rename", "remote set-default", "remote set-url":
+		if position == 0 {
+			names := []string{}
+			for name := range config.Remotes {
+				names = append(names, name)
+			}
+
+			return names
+		}
+	case "config dump", "config load", "network export", "network import", "profile import", "storage info":
+		if position == 0 {
+			return c.remotes(config)
+		}
+	}
+
+	return nil
+}
+
+func (c *completionCmd) remotes(config *lxd.Config) []string {
+	names := []string{}
+	for name := range config.Remotes {
+		names = append(names, name+":")
+	}
+
+	return names
+}
+
+// names lists a collection on the remote the current word refers to, the
+// remotes themselves are offered until one was picked. The names listed
+// recently are reused without connecting to the remote.
+func (c *completionCmd) names(config *lxd.Config, collection string, current string) []string {
+	candidates := []string{}
+
+	remote := config.DefaultRemote
+	prefix := ""
+	if strings.Contains(current, ":") {
+		remote = strings.SplitN(current, ":", 2)[0]
+		prefix = remote + ":"
+	} else {
+		candidates = append(candidates, c.remotes(config)...)
+	}
+
+	names, ok := lxd.CachedNames(remote, collection)
+	if !ok {
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return candidates
+		}
+
+		names, err = d.ListCachedNames(collection)
+		if err != nil {
+			return candidates
+		}
+	}
+
+	for _, name := range names {
+		candidates = append(candidates, prefix+name)
+	}
+
+	return candidates
+}
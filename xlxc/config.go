@@ -9,7 +9,6 @@ import (
 	"strings"
 	"syscall"
 
-	"github.com/olekukonko/tablewriter"
 	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/yaml.v2"
 
@@ -28,9 +27,11 @@ func (c *configCmd) showByDefault() bool {
 }
 
 var expanded bool
+var trustReadOnly bool
 
 func (c *configCmd) flags() {
 	gnuflag.BoolVar(&expanded, "expanded", false, i18n.G("Whether to show the expanded configuration"))
+	gnuflag.BoolVar(&trustReadOnly, "read-only", false, i18n.G("Only allow the certificate to read from the server"))
 }
 
 var configEditHelp string = i18n.G(
@@ -72,22 +73,76 @@ lxc config edit [remote:]<container>                                        Edit
     Example: lxc config edit <container> # launch editor
              cat config.yml | lxc config edit <config> # read from config.yml
 
+lxc config set-remote <remote> <key> [<value>]                              Set (or reset without a value) a setting of a remote.
+    The settings are protocol (lxd), certificate-fingerprint (SHA256 the
+    server certificate must match), auth-type (tls), via (remote or
+    ssh://[user@]host[:port] bastion the remote is reached through) and
+    proxy (http://, https://, socks5:// or socks5h:// URL, or none to ignore
+    HTTPS_PROXY and ALL_PROXY).
+
 lxc config trust list [remote]                                              List all trusted certs.
-lxc config trust add [remote] <certfile.crt>                                Add certfile.crt to trusted hosts.
+lxc config trust add [remote] <certfile.crt> [--read-only]                  Add certfile.crt to trusted hosts.
+    With --read-only, the client can only GET and listen to events, as
+    needed by monitoring dashboards.
 lxc config trust remove [remote] [hostname|fingerprint]                     Remove the cert from trusted hosts.
 
+lxc config dump [remote:]                                                   Export the server config, bridges, profiles and image aliases as YAML.
+lxc config load [remote:] [<file>]                                          Apply a dumped file, or STDIN, changing only what differs.
+    The dump leaves the trust password out and is also valid for
+    lxd init --preseed.
+
 Examples:
 To mount host's /share/c1 onto /opt in the container:
    lxc config device add [remote:]container1 <device-name> disk source=/share/c1 path=opt
 
+To put a container on VLAN 10 of a bond of eth0 and eth1:
+   lxc config device add [remote:]container1 eth1 nic nictype=macvlan parent=bond0 bond.slaves=eth0,eth1 vlan=10
+
 To set an lxc config value:
     lxc config set [remote:]<container> raw.lxc 'lxc.aa_allow_incomplete = 1'
 
+To run a container in the time zone of the host:
+    lxc config set [remote:]<container> timezone host
+
+To start a virtual machine with its clock a year ahead:
+    lxc config set [remote:]<vm> security.time_offset 8760h
+
+To keep the CPUs and memory of a container on the first NUMA node:
+    lxc config set [remote:]<container> limits.cpu.nodes 0
+
+To load the kernel modules a container needs on the host when it starts:
+    lxc config set [remote:]<container> linux.kernel_modules ip_vs,wireguard
+
 To listen on IPv4 and IPv6 port 9443 (you can omit the 9443 its the default):
     lxc config set core.https_address [::]:9443
 
 To set the server trust password:
-    lxc config set core.trust_password blah`)
+    lxc config set core.trust_password blah
+
+To serve the web UI on https://<address>/ui/ to the trusted clients:
+    lxc config set core.webui true
+
+To refuse the container names starting with "ci-":
+    lxc config set containers.names.reserved 'ci-*'
+
+To refuse creating containers from the images not marked as scanned:
+    lxc config set images.block_properties 'user.scan.status!=passed'
+
+To resolve the containers as <name>.containers.example from the host, once
+the dnsmasq of the bridge includes /var/lib/xlxd/networks/dnsmasq.conf:
+    lxc config set network.dns.domain containers.example
+    lxc config set network.dns.host true
+
+To encrypt the transfer of the containers copied or moved from the server in a
+WireGuard tunnel between the two servers, reached at another address (the
+servers must trust each other's certificate):
+    lxc config set migration.wireguard true
+    lxc config set migration.wireguard_address 203.0.113.10
+
+To keep the image downloads and the containers copied or moved to the server
+from saturating its links, limiting them in bytes per second:
+    lxc config set images.bandwidth_limit 10MB
+    lxc config set migration.bandwidth_limit 50MB`)
 }
 
 func doSet(config *lxd.Config, args []string) error {
@@ -116,6 +171,33 @@ func doSet(config *lxd.Config, args []string) error {
 	return d.SetContainerConfig(container, key, value)
 }
 
+func doConfigDump(d *lxd.Client) error {
+	var err error
+	b := bundle{}
+
+	b.Config, err = bundleServerConfig(d)
+	if err != nil {
+		return err
+	}
+
+	b.Networks, err = bundleNetworks(d, nil)
+	if err != nil {
+		return err
+	}
+
+	b.Profiles, err = bundleProfiles(d, nil)
+	if err != nil {
+		return err
+	}
+
+	b.Aliases, err = bundleAliases(d)
+	if err != nil {
+		return err
+	}
+
+	return b.write()
+}
+
 func (c *configCmd) run(config *lxd.Config, args []string) error {
 	if len(args) < 1 {
 		return errArgs
@@ -123,6 +205,23 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 
 	switch args[0] {
 
+	case "set-remote":
+		if len(args) != 3 && len(args) != 4 {
+			return errArgs
+		}
+
+		value := ""
+		if len(args) == 4 {
+			value = args[3]
+		}
+
+		err := config.SetRemoteSetting(config.ParseRemote(args[1]), args[2], value)
+		if err != nil {
+			return err
+		}
+
+		return lxd.SaveConfig(config)
+
 	case "unset":
 		if len(args) < 2 {
 			return errArgs
@@ -186,6 +285,41 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 		// Deal with container
 		return doSet(config, args)
 
+	case "dump":
+		if len(args) > 2 {
+			return errArgs
+		}
+
+		remote := config.DefaultRemote
+		if len(args) == 2 {
+			remote = config.ParseRemote(args[1])
+		}
+
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		return doConfigDump(d)
+
+	case "load":
+		remote, path, err := bundleImportArgs(config, args[1:])
+		if err != nil {
+			return err
+		}
+
+		b, err := bundleRead(path)
+		if err != nil {
+			return err
+		}
+
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		return bundleApply(d, b)
+
 	case "trust":
 		if len(args) < 2 {
 			return errArgs
@@ -213,6 +347,10 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 			data := [][]string{}
 			for _, cert := range trust {
 				fp := cert.Fingerprint[0:12]
+				readOnly := i18n.G("NO")
+				if cert.ReadOnly {
+					readOnly = i18n.G("YES")
+				}
 
 				certBlock, _ := pem.Decode([]byte(cert.Certificate))
 				cert, err := x509.ParseCertificate(certBlock.Bytes)
@@ -223,22 +361,21 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 				const layout = "Jan 2, 2006 at 3:04pm (MST)"
 				issue := cert.NotBefore.Format(layout)
 				expiry := cert.NotAfter.Format(layout)
-				data = append(data, []string{fp, cert.Subject.CommonName, issue, expiry})
+				data = append(data, []string{fp, cert.Subject.CommonName, issue, expiry, readOnly})
 			}
 
-			table := tablewriter.NewWriter(os.Stdout)
-			table.SetHeader([]string{
-				i18n.G("FINGERPRINT"),
-				i18n.G("COMMON NAME"),
-				i18n.G("ISSUE DATE"),
-				i18n.G("EXPIRY DATE")})
-
-			for _, v := range data {
-				table.Append(v)
+			list := outputList{
+				header: []string{
+					i18n.G("FINGERPRINT"),
+					i18n.G("COMMON NAME"),
+					i18n.G("ISSUE DATE"),
+					i18n.G("EXPIRY DATE"),
+					i18n.G("READ-ONLY")},
+				rows: data,
+				data: trust,
 			}
-			table.Render()
 
-			return nil
+			return list.render()
 		case "add":
 			var remote string
 			if len(args) < 3 {
@@ -261,7 +398,7 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 			}
 
 			name, _ := shared.SplitExt(fname)
-			return d.CertificateAdd(cert, name)
+			return d.CertificateAdd(cert, name, trustReadOnly)
 		case "remove":
 			var remote string
 			if len(args) < 3 {
@@ -294,7 +431,7 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		var data []byte
+		var brief interface{}
 
 		if len(args) == 1 || container == "" {
 			config, err := d.ServerStatus()
@@ -302,19 +439,27 @@ func (c *configCmd) run(config *lxd.Config, args []string) error {
 				return err
 			}
 
-			brief := config.BriefState()
-			data, err = yaml.Marshal(&brief)
+			brief = config.BriefState()
 		} else {
 			config, err := d.ContainerStatus(container)
 			if err != nil {
 				return err
 			}
 
-			brief := config.BriefState()
+			brief = config.BriefState()
 			if expanded {
 				brief = config.BriefStateExpanded()
 			}
-			data, err = yaml.Marshal(&brief)
+		}
+
+		if outputFormat == "json" {
+			_, err := outputObject(brief)
+			return err
+		}
+
+		data, err := yaml.Marshal(brief)
+		if err != nil {
+			return err
 		}
 
 		fmt.Printf("%s", data)
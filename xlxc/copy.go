@@ -12,7 +12,26 @@ import (
 )
 
 type copyCmd struct {
-	ephem bool
+	ephem           bool
+	refreshIdentity bool
+	refresh         bool
+	mode            string
+	bwlimit         string
+}
+
+// How the data gets from a server to another, the destination pulling it
+// from the source by default or the client relaying it when they can't
+// reach each other.
+var transferModes = []string{"pull", "relay"}
+
+func transferModeCheck(mode string) error {
+	for _, entry := range transferModes {
+		if entry == mode {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(i18n.G("Invalid transfer mode %q, must be one of: %s"), mode, strings.Join(transferModes, ", "))
 }
 
 func (c *copyCmd) showByDefault() bool {
@@ -23,15 +42,47 @@ func (c *copyCmd) usage() string {
 	return i18n.G(
 		`Copy containers within or in between lxd instances.
 
-lxc copy [remote:]<source container> [remote:]<destination container> [--ephemeral|e]`)
+lxc copy [remote:]<source container>[/<snapshot>] [remote:]<destination container> [--ephemeral|e] [--refresh-identity] [--refresh] [--mode=pull|relay] [--bwlimit=<limit>]
+
+A new container can be created from a snapshot, with the configuration the
+container had when it was taken. The storage backend clones it when it can.
+
+--refresh-identity resets what identifies the source on the network in the
+copy: the machine-id, the SSH host keys and the DHCP leases by default, the
+comma separated paths of copy.identity_reset if it's set.
+
+--refresh brings an existing (stopped) copy up to date rather than failing:
+only the snapshots it lacks and what changed in the container get
+transferred. Its configuration and its own snapshots are left alone.
+
+--mode=relay relays the transfer between two servers through the client,
+when the destination can't connect to the source. The default, pull, has
+the destination connect to the source directly.
+
+--bwlimit limits the transfer between two servers to a number of bytes per
+second, like 10MB, under the migration.bandwidth_limit of the destination.`)
 }
 
 func (c *copyCmd) flags() {
 	gnuflag.BoolVar(&c.ephem, "ephemeral", false, i18n.G("Ephemeral container"))
 	gnuflag.BoolVar(&c.ephem, "e", false, i18n.G("Ephemeral container"))
+	gnuflag.BoolVar(&c.refreshIdentity, "refresh-identity", false, i18n.G("Give the copy a machine identity of its own"))
+	gnuflag.BoolVar(&c.refresh, "refresh", false, i18n.G("Update an existing copy, only transferring what changed"))
+	gnuflag.StringVar(&c.mode, "mode", "pull", i18n.G("Transfer mode, pull or relay"))
+	gnuflag.StringVar(&c.bwlimit, "bwlimit", "", i18n.G("Bandwidth limit of the transfer, in bytes per second"))
 }
 
-func copyContainer(config *lxd.Config, sourceResource string, destResource string, keepVolatile bool, ephemeral int) error {
+func copyContainer(config *lxd.Config, sourceResource string, destResource string, keepVolatile bool, ephemeral int, refreshIdentity bool, refresh bool, mode string, bwlimit string) error {
+	err := transferModeCheck(mode)
+	if err != nil {
+		return err
+	}
+
+	_, err = shared.ParseBandwidthLimit(bwlimit)
+	if err != nil {
+		return err
+	}
+
 	sourceRemote, sourceName := config.ParseRemoteAndContainer(sourceResource)
 	destRemote, destName := config.ParseRemoteAndContainer(destResource)
 
@@ -50,25 +101,33 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 
 	status := &shared.ContainerState{}
 
-	// TODO: presumably we want to do this for copying snapshots too? We
-	// need to think a bit more about how we track the baseImage in the
-	// face of LVM and snapshots in general; this will probably make more
-	// sense once that work is done.
-	baseImage := ""
+	if shared.IsSnapshot(sourceName) {
+		// The new container starts from the configuration the
+		// container had when the snapshot was taken
+		fields := strings.SplitN(sourceName, shared.SnapshotDelimiter, 2)
+		snapshot, err := source.SnapshotInfo(fields[0], fields[1])
+		if err != nil {
+			return err
+		}
 
-	if !shared.IsSnapshot(sourceName) {
+		status.Architecture = snapshot.Architecture
+		status.Config = snapshot.Config
+		status.Devices = snapshot.Devices
+		status.Ephemeral = snapshot.Ephemeral
+		status.Profiles = snapshot.Profiles
+	} else {
 		status, err = source.ContainerStatus(sourceName)
 		if err != nil {
 			return err
 		}
+	}
 
-		baseImage = status.Config["volatile.base_image"]
+	baseImage := status.Config["volatile.base_image"]
 
-		if !keepVolatile {
-			for k := range status.Config {
-				if strings.HasPrefix(k, "volatile") {
-					delete(status.Config, k)
-				}
+	if !keepVolatile {
+		for k := range status.Config {
+			if strings.HasPrefix(k, "volatile") {
+				delete(status.Config, k)
 			}
 		}
 	}
@@ -79,7 +138,7 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 			return fmt.Errorf(i18n.G("can't copy to the same container name"))
 		}
 
-		cp, err := source.LocalCopy(sourceName, destName, status.Config, status.Profiles, ephemeral == 1)
+		cp, err := source.LocalCopy(sourceName, destName, status.Config, status.Profiles, ephemeral == 1, refreshIdentity, refresh)
 		if err != nil {
 			return err
 		}
@@ -102,12 +161,7 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 		}
 
 		if ephemeral == -1 {
-			ct, err := source.ContainerStatus(sourceName)
-			if err != nil {
-				return err
-			}
-
-			if ct.Ephemeral {
+			if status.Ephemeral {
 				ephemeral = 1
 			} else {
 				ephemeral = 0
@@ -138,11 +192,29 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 			return err
 		}
 
+		if mode == "relay" {
+			if len(addresses) == 0 {
+				return fmt.Errorf(i18n.G("The source server has no address"))
+			}
+
+			if secrets["wireguard_key"] != "" {
+				return fmt.Errorf(i18n.G("The source server requires a WireGuard tunnel (migration.wireguard), which can't be relayed, use --mode=pull"))
+			}
+
+			sourceWSUrl := "https://" + addresses[0] + sourceWSResponse.Operation
+			migration, err := dest.MigrateFromPush(destName, sourceWSUrl, secrets, status.Architecture, status.Config, status.Devices, status.Profiles, baseImage, ephemeral == 1, refreshIdentity, refresh, bwlimit)
+			if err != nil {
+				return err
+			}
+
+			return relayMigration(source, sourceWSResponse.Operation, secrets, dest, migration, bwlimit)
+		}
+
 		for _, addr := range addresses {
 			var migration *lxd.Response
 
 			sourceWSUrl := "https://" + addr + sourceWSResponse.Operation
-			migration, err = dest.MigrateFrom(destName, sourceWSUrl, secrets, status.Architecture, status.Config, status.Devices, status.Profiles, baseImage, ephemeral == 1)
+			migration, err = dest.MigrateFrom(destName, sourceWSUrl, secrets, status.Architecture, status.Config, status.Devices, status.Profiles, baseImage, ephemeral == 1, refreshIdentity, refresh, bwlimit)
 			if err != nil {
 				shared.Debugf("intermediate error: %s", err)
 				continue
@@ -153,7 +225,7 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 				// FIXME: This is a backward compatibility codepath
 				sourceWSUrl := "wss://" + addr + sourceWSResponse.Operation + "/websocket"
 
-				migration, err = dest.MigrateFrom(destName, sourceWSUrl, secrets, status.Architecture, status.Config, status.Devices, status.Profiles, baseImage, ephemeral == 1)
+				migration, err = dest.MigrateFrom(destName, sourceWSUrl, secrets, status.Architecture, status.Config, status.Devices, status.Profiles, baseImage, ephemeral == 1, refreshIdentity, refresh, bwlimit)
 				if err != nil {
 					shared.Debugf("intermediate error: %s", err)
 					continue
@@ -172,6 +244,49 @@ func copyContainer(config *lxd.Config, sourceResource string, destResource strin
 	}
 }
 
+// relayMigration relays the websockets of a migration to the destination
+// until its operation is done.
+func relayMigration(source *lxd.Client, operation string, secrets map[string]string, dest *lxd.Client, migration *lxd.Response, bwlimit string) error {
+	op, err := migration.MetadataAsOperation()
+	if err != nil {
+		return err
+	}
+
+	destSecrets := map[string]string{}
+	if op.Metadata != nil {
+		for k, v := range *op.Metadata {
+			destSecrets[k], _ = v.(string)
+		}
+	}
+
+	relayDone := make(chan error, 1)
+	go func() {
+		relayDone <- source.RelayMigration(operation, secrets, dest, migration.Operation, destSecrets, bwlimit)
+	}()
+
+	destDone := make(chan error, 1)
+	go func() {
+		destDone <- dest.WaitForSuccess(migration.Operation)
+	}()
+
+	// The destination waits for the websockets, not failing when they
+	// can't be relayed
+	select {
+	case err = <-relayDone:
+		if err != nil {
+			return err
+		}
+
+		return <-destDone
+	case err = <-destDone:
+		if err != nil {
+			return err
+		}
+
+		return <-relayDone
+	}
+}
+
 func (c *copyCmd) run(config *lxd.Config, args []string) error {
 	if len(args) != 2 {
 		return errArgs
@@ -182,5 +297,5 @@ func (c *copyCmd) run(config *lxd.Config, args []string) error {
 		ephem = 1
 	}
 
-	return copyContainer(config, args[0], args[1], false, ephem)
+	return copyContainer(config, args[0], args[1], false, ephem, c.refreshIdentity, c.refresh, c.mode, c.bwlimit)
 }
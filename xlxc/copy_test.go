@@ -0,0 +1,19 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTransferModeCheck(t *testing.T) {
+	for _, mode := range []string{"pull", "relay"} {
+		if err := transferModeCheck(mode); err != nil {
+			t.Errorf("Valid mode %q was refused: %s", mode, err)
+		}
+	}
+
+	for _, mode := range []string{"", "push", "Relay"} {
+		if err := transferModeCheck(mode); err == nil {
+			t.Errorf("Invalid mode %q was accepted", mode)
+		}
+	}
+}
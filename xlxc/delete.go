@@ -1,14 +1,22 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
+	"os"
+	"strings"
 
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
 	"github.com/krschwab/xlxd/shared"
+	"github.com/krschwab/xlxd/shared/gnuflag"
 )
 
-type deleteCmd struct{}
+type deleteCmd struct {
+	force  bool
+	yes    bool
+	dryRun bool
+}
 
 func (c *deleteCmd) showByDefault() bool {
 	return true
@@ -18,12 +26,63 @@ func (c *deleteCmd) usage() string {
 	return i18n.G(
 		`Delete containers or container snapshots.
 
-lxc delete [remote:]<container>[/<snapshot>] [remote:][<container>[/<snapshot>]...]
+lxc delete [remote:]<container>[/<snapshot>] [remote:][<container>[/<snapshot>]...] [--force]
+
+Destroy containers or snapshots with any attached data (configuration, snapshots, ...).
+
+Running containers are only deleted with --force, which stops them first.
+Containers with security.protection.delete set can't be deleted until it's unset.
+
+Containers may be given as glob patterns, like 'test-*', the matching ones
+are then listed and deleted once confirmed.
+
+Examples:
+    lxc delete 'test-*' --force --dry-run
+    lxc delete 'test-*' --force --yes`)
+}
 
-Destroy containers or snapshots with any attached data (configuration, snapshots, ...).`)
+func (c *deleteCmd) flags() {
+	gnuflag.BoolVar(&c.force, "force", false, i18n.G("Stop the container first if it's running"))
+	gnuflag.BoolVar(&c.yes, "yes", false, i18n.G("Don't ask for confirmation before deleting the containers matching patterns"))
+	gnuflag.BoolVar(&c.dryRun, "dry-run", false, i18n.G("Only list what would be deleted"))
 }
 
-func (c *deleteCmd) flags() {}
+// deleteTargets expands the glob patterns of the arguments to the names of the
+// matching containers on their remote. It also returns whether there were
+// patterns.
+func deleteTargets(config *lxd.Config, args []string) ([]string, bool, error) {
+	targets := []string{}
+	patterns := false
+	for _, nameArg := range args {
+		remote, name := config.ParseRemoteAndContainer(nameArg)
+		if !strings.ContainsAny(name, "*?[") {
+			targets = append(targets, nameArg)
+			continue
+		}
+
+		if shared.IsSnapshot(name) {
+			return nil, false, fmt.Errorf(i18n.G("Patterns can't match snapshots: %s"), nameArg)
+		}
+
+		patterns = true
+
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return nil, false, err
+		}
+
+		names, err := d.MatchContainers(name)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, match := range names {
+			targets = append(targets, fmt.Sprintf("%s:%s", remote, match))
+		}
+	}
+
+	return targets, patterns, nil
+}
 
 func doDelete(d *lxd.Client, name string) error {
 	resp, err := d.Delete(name)
@@ -39,7 +98,40 @@ func (c *deleteCmd) run(config *lxd.Config, args []string) error {
 		return errArgs
 	}
 
-	for _, nameArg := range args {
+	targets, patterns, err := deleteTargets(config, args)
+	if err != nil {
+		return err
+	}
+
+	if patterns || c.dryRun {
+		if len(targets) == 0 {
+			fmt.Println(i18n.G("No container matches the patterns"))
+			return nil
+		}
+
+		for _, target := range targets {
+			fmt.Println(target)
+		}
+
+		if c.dryRun {
+			return nil
+		}
+
+		if !c.yes {
+			fmt.Printf(i18n.G("Delete these %d containers? [y/N]: "), len(targets))
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil {
+				return err
+			}
+
+			answer := strings.ToLower(strings.TrimSpace(line))
+			if answer != i18n.G("y") && answer != i18n.G("yes") {
+				return fmt.Errorf(i18n.G("Aborted"))
+			}
+		}
+	}
+
+	for _, nameArg := range targets {
 		remote, name := config.ParseRemoteAndContainer(nameArg)
 
 		d, err := lxd.NewClient(config, remote)
@@ -54,7 +146,15 @@ func (c *deleteCmd) run(config *lxd.Config, args []string) error {
 			return doDelete(d, name)
 		}
 
+		if shared.IsTrue(ct.ExpandedConfig["security.protection.delete"]) {
+			return fmt.Errorf(i18n.G("The container %s is protected, unset security.protection.delete to delete it"), name)
+		}
+
 		if ct.Status.StatusCode != 0 && ct.Status.StatusCode != shared.Stopped {
+			if !c.force {
+				return fmt.Errorf(i18n.G("The container %s is running, stop it first or use --force"), name)
+			}
+
 			resp, err := d.Action(name, shared.Stop, -1, true)
 			if err != nil {
 				return err
@@ -27,10 +27,27 @@ func (c *execCmd) usage() string {
 	return i18n.G(
 		`Execute the specified command in a container.
 
-lxc exec [remote:]container [--mode=auto|interactive|non-interactive] [--env EDITOR=/usr/bin/vim]... <command>`)
+lxc exec [remote:]container [--mode=auto|interactive|non-interactive] [--env EDITOR=/usr/bin/vim]... [--user=<user>] [--group=<group>] [--cwd=<path>] [--timeout=<seconds>] <command>
+
+The user and group can be names (looked up in the container) or numeric ids.
+Commands run as root in its home directory by default. Environment variables
+set in the container's environment.* config keys apply to every command.
+With --timeout, the command is killed if it's still running after that many
+seconds.
+
+lxc exec exits with the exit code of the command, 128+N if it was killed by
+signal N, or 255 if the command couldn't be run at all.`)
 }
 
+// Exit code used when the command couldn't be run at all (connection, daemon
+// or operation failure), as opposed to the command itself failing.
+const execErrorExitCode = 255
+
 var modeFlag string
+var userFlag string
+var groupFlag string
+var cwdFlag string
+var timeoutFlag int
 
 type envFlag []string
 
@@ -52,6 +69,10 @@ var envArgs envFlag
 func (c *execCmd) flags() {
 	gnuflag.Var(&envArgs, "env", i18n.G("An environment variable of the form HOME=/home/foo"))
 	gnuflag.StringVar(&modeFlag, "mode", "auto", i18n.G("Override the terminal mode (auto, interactive or non-interactive)"))
+	gnuflag.StringVar(&userFlag, "user", "", i18n.G("User (name or uid) to run the command as"))
+	gnuflag.StringVar(&groupFlag, "group", "", i18n.G("Group (name or gid) to run the command as"))
+	gnuflag.StringVar(&cwdFlag, "cwd", "", i18n.G("Directory to run the command in"))
+	gnuflag.IntVar(&timeoutFlag, "timeout", 0, i18n.G("Kill the command after this many seconds"))
 }
 
 func sendTermSize(control *websocket.Conn) error {
@@ -88,13 +109,32 @@ func (c *execCmd) run(config *lxd.Config, args []string) error {
 		return errArgs
 	}
 
+	/* We want to exit with the same code as the process inside the
+	 * container, so we explicitly exit here instead of returning an error.
+	 */
+	ret, err := c.exec(config, args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, errorMessage(err))
+		os.Exit(execErrorExitCode)
+	}
+
+	os.Exit(ret)
+	return fmt.Errorf(i18n.G("unreachable return reached"))
+}
+
+func (c *execCmd) exec(config *lxd.Config, args []string) (int, error) {
 	remote, name := config.ParseRemoteAndContainer(args[0])
 	d, err := lxd.NewClient(config, remote)
 	if err != nil {
-		return err
+		return -1, err
 	}
 
-	env := map[string]string{"HOME": "/root", "USER": "root"}
+	// The daemon figures out HOME and USER for other users
+	env := map[string]string{}
+	if userFlag == "" {
+		env["HOME"] = "/root"
+		env["USER"] = "root"
+	}
 	myEnv := os.Environ()
 	for _, ent := range myEnv {
 		if strings.HasPrefix(ent, "TERM=") {
@@ -126,7 +166,7 @@ func (c *execCmd) run(config *lxd.Config, args []string) error {
 	if interactive {
 		oldttystate, err = terminal.MakeRaw(cfd)
 		if err != nil {
-			return err
+			return -1, err
 		}
 		defer terminal.Restore(cfd, oldttystate)
 	}
@@ -137,23 +177,6 @@ func (c *execCmd) run(config *lxd.Config, args []string) error {
 	}
 
 	stdout := getStdout()
-	ret, err := d.Exec(name, args[1:], env, os.Stdin, stdout, os.Stderr, handler)
-	if err != nil {
-		return err
-	}
-
-	if oldttystate != nil {
-		/* A bit of a special case here: we want to exit with the same code as
-		 * the process inside the container, so we explicitly exit here
-		 * instead of returning an error.
-		 *
-		 * Additionally, since os.Exit() exits without running deferred
-		 * functions, we restore the terminal explicitly.
-		 */
-		terminal.Restore(cfd, oldttystate)
-	}
-
-	/* we get the result of waitpid() here so we need to transform it */
-	os.Exit(ret >> 8)
-	return fmt.Errorf(i18n.G("unreachable return reached"))
+	options := lxd.ExecOptions{User: userFlag, Group: groupFlag, Cwd: cwdFlag, Timeout: timeoutFlag}
+	return d.ExecWithOptions(name, args[1:], env, options, os.Stdin, stdout, os.Stderr, handler)
 }
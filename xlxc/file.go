@@ -1,11 +1,14 @@
 package main
 
 import (
+	"bytes"
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path"
 	"path/filepath"
 	"strconv"
@@ -16,13 +19,19 @@ import (
 
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
 	"github.com/krschwab/xlxd/shared/gnuflag"
 )
 
+// fileVerifyAttempts is how many times a regular file is transferred with
+// --verify before giving up on checksum mismatches.
+const fileVerifyAttempts = 3
+
 type fileCmd struct {
-	uid  int
-	gid  int
-	mode string
+	uid    int
+	gid    int
+	mode   string
+	verify bool
 }
 
 func (c *fileCmd) showByDefault() bool {
@@ -33,11 +42,18 @@ func (c *fileCmd) usage() string {
 	return i18n.G(
 		`Manage files on a container.
 
-lxc file pull <source> [<source>...] <target>
-lxc file push [--uid=UID] [--gid=GID] [--mode=MODE] <source> [<source>...] <target>
+lxc file pull [--verify] <source> [<source>...] <target>
+lxc file push [--uid=UID] [--gid=GID] [--mode=MODE] [--verify] <source> [<source>...] <target>
 lxc file edit <file>
+lxc file mount <source> <target>
 
 <source> in the case of pull, <target> in the case of push and <file> in the case of edit are <container name>/<path>
+Symlinks and device nodes are transferred as such rather than followed.
+With --verify, the SHA256 of regular files is checked on the other end and
+a corrupted transfer is retried before failing.
+
+mount makes <source> (a <container name>/<path>) available at the local directory <target> until interrupted.
+It requires sshfs locally and the OpenSSH sftp-server in the container.
 This operation is only supported on containers that are currently running`)
 }
 
@@ -45,6 +61,7 @@ func (c *fileCmd) flags() {
 	gnuflag.IntVar(&c.uid, "uid", -1, i18n.G("Set the file's uid on push"))
 	gnuflag.IntVar(&c.gid, "gid", -1, i18n.G("Set the file's gid on push"))
 	gnuflag.StringVar(&c.mode, "mode", "0644", i18n.G("Set the file's perms on push"))
+	gnuflag.BoolVar(&c.verify, "verify", false, i18n.G("Check the SHA256 of transferred files"))
 }
 
 func (c *fileCmd) push(config *lxd.Config, args []string) error {
@@ -100,13 +117,25 @@ func (c *fileCmd) push(config *lxd.Config, args []string) error {
 	}
 
 	/* Make sure all of the files are accessible by us before trying to
-	 * push any of them. */
+	 * push any of them. Symlinks and device nodes aren't opened, they
+	 * are recreated as such in the container. */
 	var files []*os.File
+	var special []string
 	for _, f := range sourcefilenames {
 		var file *os.File
 		if f == "-" {
 			file = os.Stdin
 		} else {
+			fInfo, err := os.Lstat(f)
+			if err != nil {
+				return err
+			}
+
+			if fInfo.Mode()&(os.ModeSymlink|os.ModeDevice) != 0 {
+				special = append(special, f)
+				continue
+			}
+
 			file, err = os.Open(f)
 			if err != nil {
 				return err
@@ -117,12 +146,59 @@ func (c *fileCmd) push(config *lxd.Config, args []string) error {
 		files = append(files, file)
 	}
 
+	for _, f := range special {
+		fpath := targetPath
+		if targetfilename == "" {
+			fpath = path.Join(fpath, path.Base(f))
+		}
+
+		fInfo, err := os.Lstat(f)
+		if err != nil {
+			return err
+		}
+
+		if fInfo.Mode()&os.ModeSymlink != 0 {
+			linkTarget, err := os.Readlink(f)
+			if err != nil {
+				return err
+			}
+
+			err = d.PushSymlink(container, fpath, linkTarget, uid, gid)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		devType := "block"
+		if fInfo.Mode()&os.ModeCharDevice != 0 {
+			devType = "char"
+		}
+
+		rdev, err := fileDeviceNumber(fInfo)
+		if err != nil {
+			return err
+		}
+
+		err = d.PushDevice(container, fpath, devType, shared.Major(rdev), shared.Minor(rdev), mode, uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
 	for _, f := range files {
 		fpath := targetPath
 		if targetfilename == "" {
 			fpath = path.Join(fpath, path.Base(f.Name()))
 		}
-		err := d.PushFile(container, fpath, gid, uid, mode, f)
+
+		var err error
+		if c.verify {
+			err = c.pushVerified(d, container, fpath, f, mode, uid, gid)
+		} else {
+			err = d.PushFile(container, fpath, f, mode, uid, gid)
+		}
+
 		if err != nil {
 			return err
 		}
@@ -131,6 +207,42 @@ func (c *fileCmd) push(config *lxd.Config, args []string) error {
 	return nil
 }
 
+// pushVerified pushes f along with its SHA256, sending it again when the
+// daemon received something else.
+func (c *fileCmd) pushVerified(d *lxd.Client, container string, p string, f *os.File, mode os.FileMode, uid int, gid int) error {
+	// Stdin can't be rewound, keep what was read from it
+	var content io.ReadSeeker = f
+	if f == os.Stdin {
+		buf, err := ioutil.ReadAll(f)
+		if err != nil {
+			return err
+		}
+
+		content = bytes.NewReader(buf)
+	}
+
+	hash := sha256.New()
+	_, err := io.Copy(hash, content)
+	if err != nil {
+		return err
+	}
+	sum := fmt.Sprintf("%x", hash.Sum(nil))
+
+	for attempt := 1; ; attempt++ {
+		_, err = content.Seek(0, 0)
+		if err != nil {
+			return err
+		}
+
+		err = d.PushFileChecksum(container, p, content, mode, uid, gid, sum)
+		if !lxd.IsChecksumMismatch(err) || attempt == fileVerifyAttempts {
+			return err
+		}
+
+		fmt.Fprintf(os.Stderr, i18n.G("Checksum mismatch pushing %s, retrying")+"\n", p)
+	}
+}
+
 func (c *fileCmd) pull(config *lxd.Config, args []string) error {
 	if len(args) < 2 {
 		return errArgs
@@ -174,7 +286,7 @@ func (c *fileCmd) pull(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		_, _, _, buf, err := d.PullFile(container, pathSpec[1])
+		buf, info, err := d.PullFile(container, pathSpec[1])
 		if err != nil {
 			return err
 		}
@@ -186,24 +298,157 @@ func (c *fileCmd) pull(config *lxd.Config, args []string) error {
 			targetPath = target
 		}
 
-		var f *os.File
+		// Each file is closed before pulling the next one
+		err = c.pullOne(d, container, pathSpec[1], targetPath, buf, info)
+		buf.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// pullOne writes a pulled file, whatever its type, to targetPath.
+func (c *fileCmd) pullOne(d *lxd.Client, container string, p string, targetPath string, buf io.ReadCloser, info lxd.FileInfo) error {
+	if info.Type != "file" {
 		if targetPath == "-" {
-			f = os.Stdout
-		} else {
-			f, err = os.Create(targetPath)
-			if err != nil {
-				return err
-			}
-			defer f.Close()
+			return fmt.Errorf(i18n.G("Can't pull a %s to stdout"), info.Type)
 		}
 
-		_, err = io.Copy(f, buf)
+		return c.pullSpecial(targetPath, info.Type, info.Mode, buf)
+	}
+
+	if c.verify {
+		return c.pullVerified(d, container, p, targetPath, buf, info)
+	}
+
+	if targetPath == "-" {
+		_, err := io.Copy(os.Stdout, buf)
+		return err
+	}
+
+	f, err := os.Create(targetPath)
+	if err != nil {
+		return err
+	}
+
+	_, err = shared.WriteSparse(f, buf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// pullVerified writes a pulled file to targetPath, checking it against the
+// SHA256 sent by the daemon and pulling it again on mismatches. Nothing
+// corrupted is left behind, content for stdout is only written once
+// verified.
+func (c *fileCmd) pullVerified(d *lxd.Client, container string, p string, targetPath string, buf io.ReadCloser, info lxd.FileInfo) error {
+	var f *os.File
+	var err error
+	if targetPath == "-" {
+		f, err = ioutil.TempFile("", "lxd_file_pull_")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+	} else {
+		f, err = os.Create(targetPath)
 		if err != nil {
 			return err
 		}
 	}
+	defer f.Close()
 
-	return nil
+	for attempt := 1; ; attempt++ {
+		if info.SHA256 == "" {
+			err = fmt.Errorf(i18n.G("No checksum was sent for %s"), p)
+			break
+		}
+
+		hash := sha256.New()
+		_, err = shared.WriteSparse(f, io.TeeReader(buf, hash))
+		buf.Close()
+		if err != nil {
+			break
+		}
+
+		sum := fmt.Sprintf("%x", hash.Sum(nil))
+		if sum == info.SHA256 {
+			break
+		}
+
+		if attempt == fileVerifyAttempts {
+			err = fmt.Errorf(i18n.G("Checksum mismatch pulling %s, got %s expected %s"), p, sum, info.SHA256)
+			break
+		}
+
+		fmt.Fprintf(os.Stderr, i18n.G("Checksum mismatch pulling %s, retrying")+"\n", p)
+
+		err = f.Truncate(0)
+		if err == nil {
+			_, err = f.Seek(0, 0)
+		}
+
+		if err == nil {
+			buf, info, err = d.PullFile(container, p)
+		}
+
+		if err != nil {
+			break
+		}
+	}
+
+	if targetPath != "-" {
+		if err != nil {
+			os.Remove(targetPath)
+		}
+
+		return err
+	}
+
+	if err != nil {
+		return err
+	}
+
+	_, err = f.Seek(0, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(os.Stdout, f)
+	return err
+}
+
+// pullSpecial recreates a symlink or device node pulled from a container.
+func (c *fileCmd) pullSpecial(targetPath string, fileType string, mode os.FileMode, buf io.Reader) error {
+	content, err := ioutil.ReadAll(buf)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	switch fileType {
+	case "symlink":
+		return os.Symlink(string(content), targetPath)
+	case "char", "block":
+		var major, minor int
+		_, err := fmt.Sscanf(string(content), "%d:%d", &major, &minor)
+		if err != nil {
+			return err
+		}
+
+		return fileMknod(targetPath, fileType, mode, shared.Mkdev(major, minor))
+	default:
+		return fmt.Errorf(i18n.G("Unknown file type '%s'"), fileType)
+	}
 }
 
 func (c *fileCmd) edit(config *lxd.Config, args []string) error {
@@ -228,6 +473,15 @@ func (c *fileCmd) edit(config *lxd.Config, args []string) error {
 		return err
 	}
 
+	fInfo, err := os.Lstat(fname)
+	if err != nil {
+		return err
+	}
+
+	if !fInfo.Mode().IsRegular() {
+		return fmt.Errorf(i18n.G("Only regular files can be edited"))
+	}
+
 	editor := os.Getenv("VISUAL")
 	if editor == "" {
 		editor = os.Getenv("EDITOR")
@@ -254,6 +508,72 @@ func (c *fileCmd) edit(config *lxd.Config, args []string) error {
 	return err
 }
 
+func (c *fileCmd) mount(config *lxd.Config, args []string) error {
+	if len(args) != 2 {
+		return errArgs
+	}
+
+	pathSpec := strings.SplitN(args[0], "/", 2)
+	if len(pathSpec) != 2 {
+		return fmt.Errorf(i18n.G("Invalid source %s"), args[0])
+	}
+
+	target := args[1]
+	if !shared.IsDir(target) {
+		return fmt.Errorf(i18n.G("Target path %s must be an existing directory"), target)
+	}
+
+	_, err := exec.LookPath("sshfs")
+	if err != nil {
+		return fmt.Errorf(i18n.G("sshfs is required to mount container paths"))
+	}
+
+	remote, container := config.ParseRemoteAndContainer(pathSpec[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	/* sshfs in slave mode speaks SFTP on its stdin/stdout, which we then
+	 * hand over to a sftp-server running inside the container. */
+	sshfs := exec.Command("sshfs", "-f", "-o", "slave", fmt.Sprintf("%s:/%s", container, pathSpec[1]), target)
+	sshfs.Stderr = os.Stderr
+
+	sshfsIn, err := sshfs.StdinPipe()
+	if err != nil {
+		return err
+	}
+
+	sshfsOut, err := sshfs.StdoutPipe()
+	if err != nil {
+		return err
+	}
+
+	err = sshfs.Start()
+	if err != nil {
+		return err
+	}
+
+	/* Unmounting makes sshfs exit which in turn closes the sftp session */
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigs
+		exec.Command("fusermount", "-u", target).Run()
+	}()
+
+	fmt.Fprintf(os.Stderr, i18n.G("%s is mounted on %s, press Ctrl+C to unmount")+"\n", args[0], target)
+
+	err = d.SFTP(container, sshfsOut, sshfsIn, os.Stderr)
+	if err != nil {
+		sshfs.Process.Kill()
+		sshfs.Wait()
+		return err
+	}
+
+	return sshfs.Wait()
+}
+
 func (c *fileCmd) run(config *lxd.Config, args []string) error {
 	if len(args) < 1 {
 		return errArgs
@@ -266,6 +586,8 @@ func (c *fileCmd) run(config *lxd.Config, args []string) error {
 		return c.pull(config, args[1:])
 	case "edit":
 		return c.edit(config, args[1:])
+	case "mount":
+		return c.mount(config, args[1:])
 	default:
 		return errArgs
 	}
@@ -0,0 +1,27 @@
+// +build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+func fileDeviceNumber(fInfo os.FileInfo) (uint64, error) {
+	stat, ok := fInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("Unable to read the device number of %s", fInfo.Name())
+	}
+
+	return uint64(stat.Rdev), nil
+}
+
+func fileMknod(path string, fileType string, mode os.FileMode, rdev uint64) error {
+	devMode := uint32(syscall.S_IFBLK)
+	if fileType == "char" {
+		devMode = syscall.S_IFCHR
+	}
+
+	return syscall.Mknod(path, devMode|uint32(mode.Perm()), int(rdev))
+}
@@ -0,0 +1,16 @@
+// +build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func fileDeviceNumber(fInfo os.FileInfo) (uint64, error) {
+	return 0, fmt.Errorf("Device files aren't supported on Windows")
+}
+
+func fileMknod(path string, fileType string, mode os.FileMode, rdev uint64) error {
+	return fmt.Errorf("Device files aren't supported on Windows")
+}
@@ -63,11 +63,15 @@ func (c *helpCmd) run(_ *lxd.Config, args []string) error {
 		fmt.Println(i18n.G("Options:"))
 		fmt.Println("  --all              " + i18n.G("Print less common commands."))
 		fmt.Println("  --debug            " + i18n.G("Print debug information."))
+		fmt.Println("  --format           " + i18n.G("Output format (table, json, yaml, csv or compact)."))
+		fmt.Println("  --quiet            " + i18n.G("Only print the names of the listed objects."))
+		fmt.Println("  --socket           " + i18n.G("Path to the unix socket of the local daemon."))
 		fmt.Println("  --verbose          " + i18n.G("Print verbose information."))
 		fmt.Println()
 		fmt.Println(i18n.G("Environment:"))
 		fmt.Println("  LXD_CONF           " + i18n.G("Path to an alternate client configuration directory."))
 		fmt.Println("  LXD_DIR            " + i18n.G("Path to an alternate server directory."))
+		fmt.Println("  LXD_SOCKET         " + i18n.G("Path to the unix socket of the local daemon, overrides LXD_DIR."))
 	}
 	return nil
 }
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io/ioutil"
 	"os"
-	"sort"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -38,12 +39,37 @@ func (c *imageCmd) usage() string {
 		`Manipulate container images.
 
 lxc image import <tarball> [rootfs tarball|URL] [target] [--public] [--created-at=ISO-8601] [--expires-at=ISO-8601] [--fingerprint=FINGERPRINT] [prop=value]
-
-lxc image copy [remote:]<image> <remote>: [--alias=ALIAS].. [--copy-aliases] [--public]
-lxc image delete [remote:]<image>
-lxc image export [remote:]<image>
+    A tarball of "-" reads the image from stdin, as written by
+    "lxc image export <image> -".
+lxc image import docker://[registry/]<name>[:tag] [target] [--public] [--alias=ALIAS].. [prop=value]
+    Flatten the layers of an image from a Docker registry (Docker Hub by
+    default) into a new image, its entrypoint is kept in the
+    oci.entrypoint property.
+
+lxc image copy [remote:]<image> <remote>: [--alias=ALIAS].. [--copy-aliases] [--public] [--mode=pull|relay] [--bwlimit=<limit>]
+    With --mode=relay, the image is streamed through the client rather
+    than pulled by the destination, which then needn't reach the source.
+    --bwlimit limits the transfer to a number of bytes per second, like
+    10MB.
+lxc image delete [remote:]<image> [[remote:]<image>...]
+lxc image delete [remote:] --filter key=value [--filter key=value...] [--force]
+    Delete all the images matching the filters (image properties, or
+    fingerprint and alias prefixes), after asking for confirmation.
+lxc image export [remote:]<image> [target]
+    Export the image to a directory (default: current one), a file or,
+    with "-", stream it to stdout. Split images are then written as a
+    multipart stream, for example:
+    lxc image export <image> - | ssh otherhost lxc image import -
+lxc image export-bundle [remote:]<image> [[remote:]<image>...] --output=<file>
+    Write the images with their aliases and properties to a single
+    tarball ("-" being stdout), to carry them to servers without network
+    access.
+lxc image import-bundle <file> [remote:] [--public]
+    Import the images and aliases of a bundle, skipping those already there.
 lxc image info [remote:]<image>
 lxc image list [remote:] [filter]
+lxc image list <remote>: <remote>: [<remote>:...]
+lxc image list --all-remotes
 lxc image show [remote:]<image>
 lxc image edit [remote:]<image>
     Edit image, either by launching external editor or reading STDIN.
@@ -51,7 +77,8 @@ lxc image edit [remote:]<image>
              cat image.yml | lxc image edit <image> # read from image.yml
 
 Lists the images at specified remote, or local images.
-Filters are not yet supported.
+Filters are not yet supported. Several remotes, or all of them with
+--all-remotes, are queried at once and shown with a REMOTE column.
 
 lxc image alias create <alias> <target>
 lxc image alias delete <alias>
@@ -77,14 +104,35 @@ func (f *aliasList) Set(value string) error {
 	return nil
 }
 
+type filterList []string
+
+func (f *filterList) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *filterList) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
 var addAliases aliasList
 var publicImage bool = false
 var copyAliases bool = false
+var imageFilters filterList
+var imageForce bool = false
+var imageCopyMode string = "pull"
+var imageCopyBwlimit string
 
 func (c *imageCmd) flags() {
 	gnuflag.BoolVar(&publicImage, "public", false, i18n.G("Make image public"))
 	gnuflag.BoolVar(&copyAliases, "copy-aliases", false, i18n.G("Copy aliases from source"))
 	gnuflag.Var(&addAliases, "alias", i18n.G("New alias to define at target"))
+	gnuflag.Var(&imageFilters, "filter", i18n.G("Delete the images matching this filter"))
+	gnuflag.BoolVar(&imageForce, "force", false, i18n.G("Don't ask for confirmation"))
+	gnuflag.BoolVar(&listAllRemotes, "all-remotes", false, i18n.G("List the images of all the remotes"))
+	gnuflag.StringVar(&imageBundleOutput, "output", "", i18n.G("File to write the bundle to"))
+	gnuflag.StringVar(&imageCopyMode, "mode", "pull", i18n.G("Transfer mode, pull or relay"))
+	gnuflag.StringVar(&imageCopyBwlimit, "bwlimit", "", i18n.G("Bandwidth limit of the transfer, in bytes per second"))
 }
 
 func doImageAlias(config *lxd.Config, args []string) error {
@@ -167,6 +215,12 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		if outName != "" {
 			return errArgs
 		}
+		if err := transferModeCheck(imageCopyMode); err != nil {
+			return err
+		}
+		if _, err := shared.ParseBandwidthLimit(imageCopyBwlimit); err != nil {
+			return err
+		}
 		d, err := lxd.NewClient(config, remote)
 		if err != nil {
 			return err
@@ -176,24 +230,24 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 		image := dereferenceAlias(d, inName)
-		return d.CopyImage(image, dest, copyAliases, addAliases, publicImage)
+		return d.CopyImage(image, dest, copyAliases, addAliases, publicImage, imageCopyMode, imageCopyBwlimit)
 
 	case "delete":
-		/* delete [<remote>:]<image> */
-		if len(args) < 2 {
-			return errArgs
+		/* delete [<remote>:]<image> [[<remote>:]<image>...] */
+		if len(imageFilters) > 0 {
+			return doImageDeleteFiltered(config, args[1:])
 		}
-		remote, inName := config.ParseRemoteAndContainer(args[1])
-		if inName == "" {
+
+		if len(args) < 2 {
 			return errArgs
 		}
-		d, err := lxd.NewClient(config, remote)
-		if err != nil {
-			return err
+
+		// Only one image, no need to decorate the error
+		if len(args) == 2 {
+			return doImageDelete(config, args[1])
 		}
-		image := dereferenceAlias(d, inName)
-		err = d.DeleteImage(image)
-		return err
+
+		return doImageDeleteMany(config, args[1:])
 
 	case "info":
 		if len(args) < 2 {
@@ -208,12 +262,21 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		image := dereferenceAlias(d, inName)
+		image := dereferenceCachedAlias(d, inName)
 		info, err := d.GetImageInfo(image)
 		if err != nil {
 			return err
 		}
+
+		done, err := outputObject(info)
+		if done {
+			return err
+		}
+
 		fmt.Printf(i18n.G("Fingerprint: %s")+"\n", info.Fingerprint)
+		if info.UUID != "" {
+			fmt.Printf(i18n.G("UUID: %s")+"\n", info.UUID)
+		}
 		public := i18n.G("no")
 
 		// FIXME: InterfaceToBool is there for backward compatibility
@@ -289,6 +352,8 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 
 		if strings.HasPrefix(imageFile, "https://") {
 			fingerprint, err = d.PostImageURL(imageFile, publicImage, addAliases)
+		} else if strings.HasPrefix(imageFile, "docker://") {
+			fingerprint, err = d.PostImageDocker(imageFile, properties, publicImage, addAliases)
 		} else if strings.HasPrefix(imageFile, "http://") {
 			return fmt.Errorf(i18n.G("Only https:// is supported for remote image import."))
 		} else {
@@ -303,6 +368,19 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		return nil
 
 	case "list":
+		remotes, _ := remotesShift(config, args[1:])
+		if listAllRemotes {
+			if len(remotes) > 0 {
+				return errArgs
+			}
+
+			return showRemotesImages(config, remotesAll(config, true))
+		}
+
+		if len(remotes) > 1 {
+			return showRemotesImages(config, remotes)
+		}
+
 		if len(args) > 1 {
 			remote, _ = config.ParseRemoteAndContainer(args[1])
 		} else {
@@ -374,6 +452,12 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 		}
 		return nil
 
+	case "export-bundle":
+		return doImageExportBundle(config, args[1:])
+
+	case "import-bundle":
+		return doImageImportBundle(config, args[1:])
+
 	case "show":
 		if len(args) < 2 {
 			return errArgs
@@ -387,13 +471,17 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 			return err
 		}
 
-		image := dereferenceAlias(d, inName)
+		image := dereferenceCachedAlias(d, inName)
 		info, err := d.GetImageInfo(image)
 		if err != nil {
 			return err
 		}
 
 		properties := info.BriefInfo()
+		if outputFormat == "json" {
+			_, err := outputObject(properties)
+			return err
+		}
 
 		data, err := yaml.Marshal(&properties)
 		fmt.Printf("%s", data)
@@ -404,6 +492,137 @@ func (c *imageCmd) run(config *lxd.Config, args []string) error {
 	}
 }
 
+func doImageDelete(config *lxd.Config, nameArg string) error {
+	remote, inName := config.ParseRemoteAndContainer(nameArg)
+	if inName == "" {
+		return errArgs
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	image := dereferenceAlias(d, inName)
+	return d.DeleteImage(image)
+}
+
+// doImageDeleteMany deletes all the images in parallel and reports all the
+// failures at the end.
+func doImageDeleteMany(config *lxd.Config, names []string) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(names))
+	for i, nameArg := range names {
+		wg.Add(1)
+		go func(i int, nameArg string) {
+			defer wg.Done()
+			errs[i] = doImageDelete(config, nameArg)
+		}(i, nameArg)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failed = append(failed, names[i])
+		fmt.Fprintf(os.Stderr, i18n.G("error: %s: %s")+"\n", names[i], err)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf(i18n.G("Failed to delete %d of %d images: %s"), len(failed), len(names), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+func doImageDeleteFiltered(config *lxd.Config, args []string) error {
+	if len(args) > 1 {
+		return errArgs
+	}
+
+	remote := config.DefaultRemote
+	if len(args) == 1 {
+		var name string
+		remote, name = config.ParseRemoteAndContainer(args[0])
+		if name != "" {
+			return errArgs
+		}
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	images, err := d.ListImages()
+	if err != nil {
+		return err
+	}
+
+	names := []string{}
+	for _, image := range images {
+		if !imageShouldShow(imageFilters, &image) {
+			continue
+		}
+
+		fmt.Printf("%s\t%s\n", image.Fingerprint[0:12], findDescription(image.Properties))
+		names = append(names, fmt.Sprintf("%s:%s", remote, image.Fingerprint))
+	}
+
+	if len(names) == 0 {
+		fmt.Println(i18n.G("No image matches the filters"))
+		return nil
+	}
+
+	if !imageForce {
+		fmt.Printf(i18n.G("Delete these %d images? (yes/no): "), len(names))
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return err
+		}
+
+		if strings.ToLower(strings.TrimSpace(line)) != i18n.G("yes") {
+			return fmt.Errorf(i18n.G("Aborted"))
+		}
+	}
+
+	return doImageDeleteMany(config, names)
+}
+
+// imageShouldShow checks an image against a list of filters, key=value
+// filters match the image properties while the others are fingerprint or
+// alias prefixes.
+func imageShouldShow(filters []string, image *shared.ImageInfo) bool {
+	for _, filter := range filters {
+		if strings.Contains(filter, "=") {
+			membs := strings.SplitN(filter, "=", 2)
+
+			value, ok := image.Properties[membs[0]]
+			if !ok || value != membs[1] {
+				return false
+			}
+
+			continue
+		}
+
+		found := strings.HasPrefix(image.Fingerprint, filter)
+		for _, alias := range image.Aliases {
+			if strings.HasPrefix(alias.Name, filter) {
+				found = true
+			}
+		}
+
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
 func dereferenceAlias(d *lxd.Client, inName string) string {
 	result := d.GetAlias(inName)
 	if result == "" {
@@ -412,6 +631,16 @@ func dereferenceAlias(d *lxd.Client, inName string) string {
 	return result
 }
 
+// dereferenceCachedAlias is dereferenceAlias going through the cache, for
+// the commands which only show images.
+func dereferenceCachedAlias(d *lxd.Client, inName string) string {
+	result := d.GetCachedAlias(inName)
+	if result == "" {
+		return inName
+	}
+	return result
+}
+
 func shortestAlias(list shared.ImageAliases) string {
 	shortest := ""
 	for _, l := range list {
@@ -436,7 +665,9 @@ func findDescription(props map[string]string) string {
 	return ""
 }
 
-func showImages(images []shared.ImageInfo) error {
+// imageRows returns the table rows of the images, sorting them by alias
+// along with the images.
+func imageRows(images []shared.ImageInfo) [][]string {
 	data := [][]string{}
 	for _, image := range images {
 		shortest := shortestAlias(image.Aliases)
@@ -459,21 +690,75 @@ func showImages(images []shared.ImageInfo) error {
 		data = append(data, []string{shortest, fp, public, description, arch, size, uploaded})
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetColWidth(50)
-	table.SetHeader([]string{
+	outputSort(data, images)
+	return data
+}
+
+func imageHeader() []string {
+	return []string{
 		i18n.G("ALIAS"),
 		i18n.G("FINGERPRINT"),
 		i18n.G("PUBLIC"),
 		i18n.G("DESCRIPTION"),
 		i18n.G("ARCH"),
 		i18n.G("SIZE"),
-		i18n.G("UPLOAD DATE")})
-	sort.Sort(ByName(data))
-	table.AppendBulk(data)
-	table.Render()
+		i18n.G("UPLOAD DATE")}
+}
 
-	return nil
+func imageTable(table *tablewriter.Table) {
+	table.SetColWidth(50)
+}
+
+func showImages(images []shared.ImageInfo) error {
+	list := outputList{
+		header:     imageHeader(),
+		rows:       imageRows(images),
+		nameColumn: 1,
+		data:       images,
+		table:      imageTable,
+	}
+
+	return list.render()
+}
+
+// showRemotesImages lists the images of several remotes at once, whatever
+// could be listed is shown even if some of the remotes failed.
+func showRemotesImages(config *lxd.Config, remotes []string) error {
+	rows := make([][][]string, len(remotes))
+	images := make([][]shared.ImageInfo, len(remotes))
+	queryErr := remotesQuery(config, remotes, func(i int, d *lxd.Client) error {
+		list, err := d.ListImages()
+		if err != nil {
+			return err
+		}
+
+		images[i] = list
+		rows[i] = imageRows(list)
+		return nil
+	})
+
+	data := map[string][]shared.ImageInfo{}
+	for i, remote := range remotes {
+		if rows[i] != nil {
+			data[remote] = images[i]
+		}
+	}
+
+	header, merged := remotesMerge(imageHeader(), remotes, rows)
+	list := outputList{
+		header:     header,
+		rows:       merged,
+		nameColumn: 2,
+		data:       data,
+		table:      imageTable,
+	}
+
+	err := list.render()
+	if err != nil {
+		return err
+	}
+
+	return queryErr
 }
 
 func showAliases(aliases []shared.ImageAlias) error {
@@ -482,17 +767,15 @@ func showAliases(aliases []shared.ImageAlias) error {
 		data = append(data, []string{alias.Description, alias.Name[0:12]})
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetHeader([]string{
-		i18n.G("ALIAS"),
-		i18n.G("FINGERPRINT")})
-
-	for _, v := range data {
-		table.Append(v)
+	list := outputList{
+		header: []string{
+			i18n.G("ALIAS"),
+			i18n.G("FINGERPRINT")},
+		rows: data,
+		data: aliases,
 	}
-	table.Render()
 
-	return nil
+	return list.render()
 }
 
 func doImageEdit(client *lxd.Client, image string) error {
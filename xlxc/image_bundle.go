@@ -0,0 +1,347 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+)
+
+// imageBundleIndex is the index.yaml of the tarballs written by "lxc image
+// export-bundle", the files of each image being in a directory named after
+// its fingerprint.
+type imageBundleIndex struct {
+	Images []imageBundleImage `yaml:"images"`
+}
+
+type imageBundleImage struct {
+	Fingerprint string             `yaml:"fingerprint"`
+	Aliases     []imageBundleAlias `yaml:"aliases,omitempty"`
+	Properties  map[string]string  `yaml:"properties,omitempty"`
+	Public      bool               `yaml:"public"`
+
+	// The image tarball, or the metadata and rootfs ones of a split image
+	Files []string `yaml:"files"`
+}
+
+type imageBundleAlias struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+}
+
+var imageBundleOutput string
+
+func doImageExportBundle(config *lxd.Config, args []string) error {
+	if len(args) < 1 || imageBundleOutput == "" {
+		return errArgs
+	}
+
+	tmpdir, err := ioutil.TempDir("", "lxc_bundle_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	index := imageBundleIndex{}
+	for _, arg := range args {
+		remote, inName := config.ParseRemoteAndContainer(arg)
+		if inName == "" {
+			return errArgs
+		}
+
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		info, err := d.GetImageInfo(dereferenceAlias(d, inName))
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Join(tmpdir, info.Fingerprint)
+		if shared.PathExists(dir) {
+			continue
+		}
+
+		err = os.Mkdir(dir, 0700)
+		if err != nil {
+			return err
+		}
+
+		_, _, err = d.ExportImage(info.Fingerprint, dir)
+		if err != nil {
+			return err
+		}
+
+		image := imageBundleImage{
+			Fingerprint: info.Fingerprint,
+			Properties:  info.Properties,
+			Public:      info.Public == true,
+		}
+
+		for _, alias := range info.Aliases {
+			image.Aliases = append(image.Aliases, imageBundleAlias{Name: alias.Name, Description: alias.Description})
+		}
+
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return err
+		}
+
+		for _, f := range files {
+			image.Files = append(image.Files, f.Name())
+		}
+
+		// The metadata of split images comes first
+		sort.Sort(imageBundleFiles(image.Files))
+		index.Images = append(index.Images, image)
+	}
+
+	out := os.Stdout
+	if imageBundleOutput != "-" {
+		out, err = os.Create(imageBundleOutput)
+		if err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+
+	tw := tar.NewWriter(out)
+
+	data, err := yaml.Marshal(&index)
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: "index.yaml", Typeflag: tar.TypeReg, Mode: 0600, Size: int64(len(data))})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(data)
+	if err != nil {
+		return err
+	}
+
+	for _, image := range index.Images {
+		for _, name := range image.Files {
+			err := imageBundleAddFile(tw, tmpdir, path.Join(image.Fingerprint, name))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	if imageBundleOutput != "-" {
+		fmt.Printf(i18n.G("Output is in %s")+"\n", imageBundleOutput)
+	}
+
+	return nil
+}
+
+func imageBundleAddFile(tw *tar.Writer, dir string, name string) error {
+	f, err := os.Open(filepath.Join(dir, name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	err = tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0600, Size: fi.Size(), ModTime: fi.ModTime()})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+type imageBundleFiles []string
+
+func (a imageBundleFiles) Len() int      { return len(a) }
+func (a imageBundleFiles) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a imageBundleFiles) Less(i, j int) bool {
+	return strings.HasPrefix(a[i], "meta-") && !strings.HasPrefix(a[j], "meta-")
+}
+
+func doImageImportBundle(config *lxd.Config, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errArgs
+	}
+
+	remote := config.DefaultRemote
+	if len(args) == 2 {
+		remote = config.ParseRemote(args[1])
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	in := os.Stdin
+	if args[0] != "-" {
+		in, err = os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+	}
+
+	tmpdir, err := ioutil.TempDir("", "lxc_bundle_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpdir)
+
+	var index *imageBundleIndex
+	tr := tar.NewReader(in)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || strings.HasPrefix(name, "..") {
+			return fmt.Errorf(i18n.G("Invalid path in the bundle: %s"), hdr.Name)
+		}
+
+		if name == "index.yaml" {
+			data, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+
+			index = &imageBundleIndex{}
+			err = yaml.Unmarshal(data, index)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if hdr.Typeflag != tar.TypeReg && hdr.Typeflag != tar.TypeRegA {
+			continue
+		}
+
+		target := filepath.Join(tmpdir, filepath.FromSlash(name))
+		err = os.MkdirAll(filepath.Dir(target), 0700)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, tr)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if index == nil {
+		return fmt.Errorf(i18n.G("Not an image bundle, index.yaml is missing"))
+	}
+
+	for _, image := range index.Images {
+		err := imageBundleImport(d, tmpdir, image)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// imageBundleImport adds an image of a bundle and its aliases to the server,
+// unless it's already there.
+func imageBundleImport(d *lxd.Client, dir string, image imageBundleImage) error {
+	// The fingerprint ends up in the paths of the files
+	if len(image.Fingerprint) != 64 || strings.Trim(image.Fingerprint, "0123456789abcdef") != "" {
+		return fmt.Errorf(i18n.G("Invalid fingerprint in the bundle: %s"), image.Fingerprint)
+	}
+
+	if len(image.Files) < 1 || len(image.Files) > 2 {
+		return fmt.Errorf(i18n.G("Invalid files for image %s in the bundle"), image.Fingerprint)
+	}
+
+	_, err := d.GetImageInfo(image.Fingerprint)
+	if err == nil {
+		fmt.Printf(i18n.G("Image %s is already there")+"\n", image.Fingerprint)
+	} else {
+		files := []string{}
+		for _, name := range image.Files {
+			files = append(files, filepath.Join(dir, image.Fingerprint, filepath.Base(name)))
+		}
+
+		rootfsFile := ""
+		if len(files) == 2 {
+			rootfsFile = files[1]
+		}
+
+		properties := []string{}
+		for key, value := range image.Properties {
+			properties = append(properties, fmt.Sprintf("%s=%s", key, value))
+		}
+		sort.Strings(properties)
+
+		fingerprint, err := d.PostImage(files[0], rootfsFile, properties, image.Public || publicImage, nil)
+		if err != nil {
+			return err
+		}
+
+		if fingerprint != image.Fingerprint {
+			d.DeleteImage(fingerprint)
+			return fmt.Errorf(i18n.G("Image %s is corrupted in the bundle"), image.Fingerprint)
+		}
+
+		fmt.Printf(i18n.G("Image imported with fingerprint: %s")+"\n", fingerprint)
+	}
+
+	for _, alias := range image.Aliases {
+		target := d.GetAlias(alias.Name)
+		if target == image.Fingerprint {
+			continue
+		}
+
+		if target != "" {
+			return fmt.Errorf(i18n.G("Alias %s already exists for image %s"), alias.Name, target)
+		}
+
+		err := d.PostAlias(alias.Name, alias.Description, image.Fingerprint)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
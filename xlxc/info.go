@@ -3,16 +3,25 @@ package main
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"gopkg.in/yaml.v2"
 
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
 	"github.com/krschwab/xlxd/shared/gnuflag"
 )
 
+// How many of the last lines of the container's log --show-log prints.
+const infoLogLines = 100
+
 type infoCmd struct {
-	showLog bool
+	showLog       bool
+	showResources bool
 }
 
 func (c *infoCmd) showByDefault() bool {
@@ -21,15 +30,20 @@ func (c *infoCmd) showByDefault() bool {
 
 func (c *infoCmd) usage() string {
 	return i18n.G(
-		`List information on containers.
+		`Show information on the server or on a container.
 
-This will support remotes and images as well, but only containers for now.
+lxc info [<remote>:]container [--show-log]
+lxc info [<remote>:] [--resources]
 
-lxc info [<remote>:]container [--show-log]`)
+Without a container, the server's version, kernel, storage backend, network
+addresses, processors and memory are shown. With one, its state, processes,
+addresses, disk usage and snapshots are shown, --show-log adding the last
+lines of its log and console output.`)
 }
 
 func (c *infoCmd) flags() {
 	gnuflag.BoolVar(&c.showLog, "show-log", false, i18n.G("Show the container's last 100 log lines?"))
+	gnuflag.BoolVar(&c.showResources, "resources", false, i18n.G("Show the resources available to the server"))
 }
 
 func (c *infoCmd) run(config *lxd.Config, args []string) error {
@@ -47,6 +61,10 @@ func (c *infoCmd) run(config *lxd.Config, args []string) error {
 	}
 
 	if cName == "" {
+		if c.showResources {
+			return remoteResources(d)
+		}
+
 		return remoteInfo(d)
 	} else {
 		return containerInfo(d, cName, c.showLog)
@@ -59,7 +77,84 @@ func remoteInfo(d *lxd.Client) error {
 		return err
 	}
 
-	data, err := yaml.Marshal(&serverStatus)
+	done, err := outputObject(serverStatus)
+	if done {
+		return err
+	}
+
+	fmt.Printf(i18n.G("Auth: %s")+"\n", serverStatus.Auth)
+	if serverStatus.Auth != "trusted" {
+		return nil
+	}
+
+	env := serverStatus.Environment
+	fmt.Printf(i18n.G("Server version: %s")+"\n", env.ServerVersion)
+	fmt.Printf(i18n.G("API compatibility: %d")+"\n", serverStatus.APICompat)
+	fmt.Printf(i18n.G("Server PID: %d")+"\n", env.ServerPid)
+	fmt.Printf(i18n.G("Kernel: %s %s (%s)")+"\n", env.Kernel, env.KernelVersion, env.KernelArchitecture)
+	fmt.Printf(i18n.G("Driver: %s %s")+"\n", env.Driver, env.DriverVersion)
+	if len(env.Drivers) > 0 {
+		fmt.Println(i18n.G("Drivers:"))
+		names := []string{}
+		for name := range env.Drivers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			driver := env.Drivers[name]
+			kind := i18n.G("containers")
+			if driver.VM {
+				kind = i18n.G("virtual machines")
+			}
+
+			fmt.Printf("  %s %s (%s): %s\n", name, driver.Version, kind, strings.Join(driver.Capabilities, ", "))
+		}
+	}
+	if len(env.DriverFeatures) > 0 {
+		features := []string{}
+		for feature, enabled := range env.DriverFeatures {
+			if enabled {
+				features = append(features, feature)
+			}
+		}
+		sort.Strings(features)
+
+		fmt.Printf(i18n.G("Driver features: %s")+"\n", strings.Join(features, ", "))
+	}
+	fmt.Printf(i18n.G("Storage: %s %s")+"\n", env.Storage, env.StorageVersion)
+	fmt.Printf(i18n.G("Processors: %s")+"\n", env.Processors)
+	fmt.Printf(i18n.G("Cores: %s")+"\n", env.Cores)
+
+	// The daemon reports the memory in kB, as found in /proc/meminfo
+	memory, err := strconv.ParseInt(env.Memory, 10, 64)
+	if err == nil {
+		fmt.Printf(i18n.G("Memory: %s")+"\n", shared.GetByteSizeString(memory*1024))
+	}
+
+	fmt.Println(i18n.G("Addresses:"))
+	if len(env.Addresses) == 0 {
+		fmt.Println("  " + i18n.G("(none)"))
+	}
+	for _, address := range env.Addresses {
+		fmt.Printf("  %s\n", address)
+	}
+
+	return nil
+}
+
+func remoteResources(d *lxd.Client) error {
+	resources, err := d.ServerResources()
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == "json" {
+		_, err := outputObject(resources)
+		return err
+	}
+
+	data, err := yaml.Marshal(&resources)
 	if err != nil {
 		return err
 	}
@@ -75,11 +170,33 @@ func containerInfo(d *lxd.Client, name string, showLog bool) error {
 		return err
 	}
 
+	done, err := outputObject(ct)
+	if done {
+		return err
+	}
+
 	fmt.Printf(i18n.G("Name: %s")+"\n", ct.Name)
+	if ct.UUID != "" {
+		fmt.Printf(i18n.G("UUID: %s")+"\n", ct.UUID)
+	}
+	arch, _ := shared.ArchitectureName(ct.Architecture)
+	fmt.Printf(i18n.G("Architecture: %s")+"\n", arch)
+	fmt.Printf(i18n.G("Profiles: %s")+"\n", strings.Join(ct.Profiles, ", "))
 	fmt.Printf(i18n.G("Status: %s")+"\n", ct.Status.Status)
+	if ct.Status.StatusCode == shared.Frozen && ct.Status.FrozenDuration > 0 {
+		fmt.Printf(i18n.G("Frozen for: %s")+"\n", time.Duration(ct.Status.FrozenDuration)*time.Second)
+	}
 	if ct.Status.Init != 0 {
 		fmt.Printf(i18n.G("Init: %d")+"\n", ct.Status.Init)
+		if ct.Status.Ready {
+			fmt.Printf(i18n.G("Ready: yes") + "\n")
+		} else {
+			fmt.Printf(i18n.G("Ready: no") + "\n")
+		}
 		fmt.Printf(i18n.G("Processcount: %d")+"\n", ct.Status.Processcount)
+		if ct.Status.RestartRequired {
+			fmt.Printf(i18n.G("Restart required: %s")+"\n", strings.Join(ct.Status.PendingKeys, ", "))
+		}
 		fmt.Printf(i18n.G("Ips:") + "\n")
 		foundone := false
 		for _, ip := range ct.Status.Ips {
@@ -88,7 +205,7 @@ func containerInfo(d *lxd.Client, name string, showLog bool) error {
 				vethStr = fmt.Sprintf("\t%s", ip.HostVeth)
 			}
 
-			fmt.Printf("  %s:\t%s\t%s%s\n", ip.Interface, ip.Protocol, ip.Address, vethStr)
+			fmt.Printf("  %s:\t%s\t%s%s%s\n", ip.Interface, ip.Protocol, ip.Address, vethStr, ipDetails(ip))
 			foundone = true
 		}
 		if !foundone {
@@ -96,6 +213,10 @@ func containerInfo(d *lxd.Client, name string, showLog bool) error {
 		}
 	}
 
+	if ct.Status.Disk.Usage >= 0 {
+		fmt.Printf(i18n.G("Disk usage: %s")+"\n", diskUsageString(ct.Status.Disk))
+	}
+
 	// List snapshots
 	first_snapshot := true
 	snaps, err := d.ListSnapshots(name)
@@ -106,23 +227,90 @@ func containerInfo(d *lxd.Client, name string, showLog bool) error {
 		if first_snapshot {
 			fmt.Println(i18n.G("Snapshots:"))
 		}
-		fmt.Printf("  %s\n", snap)
+		usage, ok := ct.Status.Disk.Snapshots[snap]
+		if ok && usage.Usage >= 0 {
+			fmt.Printf("  %s (%s)\n", snap, diskUsageString(usage))
+		} else {
+			fmt.Printf("  %s\n", snap)
+		}
 		first_snapshot = false
 	}
 
 	if showLog {
-		log, err := d.GetLog(name, "lxc.log")
+		lines, err := containerLogTail(d, name, "lxc.log")
 		if err != nil {
 			return err
 		}
 
-		stuff, err := ioutil.ReadAll(log)
-		if err != nil {
-			return err
-		}
+		fmt.Printf("\n"+i18n.G("Log:")+"\n\n%s\n", lines)
 
-		fmt.Printf("\n"+i18n.G("Log:")+"\n\n%s\n", string(stuff))
+		// Containers started before the console got logged don't have one
+		lines, err = containerLogTail(d, name, "console.log")
+		if err == nil && lines != "" {
+			fmt.Printf("\n"+i18n.G("Console:")+"\n\n%s\n", lines)
+		}
 	}
 
 	return nil
 }
+
+// containerLogTail returns the last lines of one of the container's logs.
+func containerLogTail(d *lxd.Client, name string, file string) (string, error) {
+	log, err := d.GetLog(name, file)
+	if err != nil {
+		return "", err
+	}
+
+	stuff, err := ioutil.ReadAll(log)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(stuff), "\n"), "\n")
+	if len(lines) > infoLogLines {
+		lines = lines[len(lines)-infoLogLines:]
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+func diskUsageString(disk shared.ContainerDisk) string {
+	if disk.Usage < 0 {
+		return ""
+	}
+
+	if disk.Quota < 0 {
+		return shared.GetByteSizeString(disk.Usage)
+	}
+
+	return fmt.Sprintf("%s / %s", shared.GetByteSizeString(disk.Usage), shared.GetByteSizeString(disk.Quota))
+}
+
+// ipScope returns the scope of an address, computing it for the servers
+// which don't report it.
+func ipScope(ip shared.Ip) string {
+	if ip.Scope != "" {
+		return ip.Scope
+	}
+
+	return shared.IpScope(ip.Address)
+}
+
+// ipDetails renders the scope, source and preferred lifetime of an address.
+func ipDetails(ip shared.Ip) string {
+	details := []string{ipScope(ip)}
+	if ip.Source != "" {
+		details = append(details, ip.Source)
+	}
+
+	// Older servers report neither the scope nor the lifetimes
+	if ip.Scope != "" {
+		if ip.PreferredLifetime == 0 {
+			details = append(details, i18n.G("deprecated"))
+		} else if ip.PreferredLifetime > 0 {
+			details = append(details, fmt.Sprintf(i18n.G("preferred for %s"), time.Duration(ip.PreferredLifetime)*time.Second))
+		}
+	}
+
+	return fmt.Sprintf("\t(%s)", strings.Join(details, ", "))
+}
@@ -21,13 +21,16 @@ func (c *initCmd) usage() string {
 	return i18n.G(
 		`Initialize a container from a particular image.
 
-lxc init [remote:]<image> [remote:][<name>] [--ephemeral|-e] [--profile|-p <profile>...] [--config|-c <key=value>...]
+lxc init [remote:]<image> [remote:][<name>] [--ephemeral|-e] [--profile|-p <profile>...] [--config|-c <key=value>...] [--vm]
 
 Initializes a container using the specified image and name.
 
 Not specifying -p will result in the default profile.
 Specifying "-p" with no argument will result in no profile.
 
+With --vm, a virtual machine is created instead, running the kernel of the
+image under qemu/KVM.
+
 Example:
 lxc init ubuntu u1`)
 }
@@ -81,6 +84,20 @@ var profArgs profileList
 var confArgs configList
 var requested_empty_profiles bool = false
 var ephem bool = false
+var vm bool = false
+
+// vmConfig asks for a virtual machine in the config of the new container.
+func vmConfig() {
+	if !vm {
+		return
+	}
+
+	if configMap == nil {
+		configMap = map[string]string{}
+	}
+
+	configMap["instance.type"] = "vm"
+}
 
 func is_ephem(s string) bool {
 	switch s {
@@ -135,6 +152,7 @@ func (c *initCmd) flags() {
 	gnuflag.Var(&profArgs, "p", i18n.G("Profile to apply to the new container"))
 	gnuflag.BoolVar(&ephem, "ephemeral", false, i18n.G("Ephemeral container"))
 	gnuflag.BoolVar(&ephem, "e", false, i18n.G("Ephemeral container"))
+	gnuflag.BoolVar(&vm, "vm", false, i18n.G("Create a virtual machine"))
 }
 
 func (c *initCmd) run(config *lxd.Config, args []string) error {
@@ -174,6 +192,7 @@ func (c *initCmd) run(config *lxd.Config, args []string) error {
 	} else {
 		fmt.Printf(i18n.G("Creating %s")+" ", name)
 	}
+	vmConfig()
 	if !requested_empty_profiles && len(profiles) == 0 {
 		resp, err = d.Init(name, iremote, image, nil, configMap, ephem)
 	} else {
@@ -20,13 +20,16 @@ func (c *launchCmd) usage() string {
 	return i18n.G(
 		`Launch a container from a particular image.
 
-lxc launch [remote:]<image> [remote:][<name>] [--ephemeral|-e] [--profile|-p <profile>...] [--config|-c <key=value>...]
+lxc launch [remote:]<image> [remote:][<name>] [--ephemeral|-e] [--profile|-p <profile>...] [--config|-c <key=value>...] [--vm]
 
 Launches a container using the specified image and name.
 
 Not specifying -p will result in the default profile.
 Specifying "-p" with no argument will result in no profile.
 
+With --vm, a virtual machine is created instead, running the kernel of the
+image under qemu/KVM.
+
 Example:
 lxc launch ubuntu u1`)
 }
@@ -39,6 +42,7 @@ func (c *launchCmd) flags() {
 	gnuflag.Var(&profArgs, "p", i18n.G("Profile to apply to the new container"))
 	gnuflag.BoolVar(&ephem, "ephemeral", false, i18n.G("Ephemeral container"))
 	gnuflag.BoolVar(&ephem, "e", false, i18n.G("Ephemeral container"))
+	gnuflag.BoolVar(&vm, "vm", false, i18n.G("Create a virtual machine"))
 }
 
 func (c *launchCmd) run(config *lxd.Config, args []string) error {
@@ -70,6 +74,7 @@ func (c *launchCmd) run(config *lxd.Config, args []string) error {
 	for _, p := range profArgs {
 		profiles = append(profiles, p)
 	}
+	vmConfig()
 	if !requested_empty_profiles && len(profiles) == 0 {
 		resp, err = d.Init(name, iremote, image, nil, configMap, ephem)
 	} else {
@@ -2,8 +2,6 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"sort"
 	"strings"
 
 	"github.com/olekukonko/tablewriter"
@@ -11,6 +9,7 @@ import (
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
 	"github.com/krschwab/xlxd/shared"
+	"github.com/krschwab/xlxd/shared/gnuflag"
 )
 
 type ByName [][]string
@@ -46,6 +45,8 @@ func (c *listCmd) usage() string {
 		`Lists the available resources.
 
 lxc list [resource] [filters]
+lxc list <remote>: <remote>: [<remote>:...] [filters]
+lxc list --all-remotes [filters]
 
 The filters are:
 * A single keyword like "web" which will list any container with "web" in its name.
@@ -53,10 +54,25 @@ The filters are:
 * "user.blah=abc" will list all containers with the "blah" user property set to "abc"
 * "u.blah=abc" will do the same
 * "security.privileged=1" will list all privileged containers
-* "s.privileged=1" will do the same`)
+* "s.privileged=1" will do the same
+
+When given several remotes or --all-remotes, they're all queried at once
+and a REMOTE column tells where each container lives.
+
+--format=ansible-inventory prints an Ansible dynamic inventory of the
+containers, grouped by profile, status and the comma separated groups of
+user.ansible_group. The first global address of a container is its
+ansible_host.`)
 }
 
-func (c *listCmd) flags() {}
+// list takes its own --format values on top of the output formats.
+func (c *listCmd) formats() []string {
+	return []string{"ansible-inventory"}
+}
+
+func (c *listCmd) flags() {
+	gnuflag.BoolVar(&listAllRemotes, "all-remotes", false, i18n.G("List the containers of all the remotes"))
+}
 
 // This seems a little excessive.
 func dotPrefixMatch(short string, full string) bool {
@@ -115,8 +131,11 @@ func shouldShow(filters []string, state *shared.ContainerState) bool {
 	return true
 }
 
-func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps bool) error {
+// containerRows returns the table rows of the containers matching the
+// filters, along with those containers, both sorted by name.
+func containerRows(cinfos []shared.ContainerInfo, filters []string) ([][]string, shared.ContainerInfoList) {
 	data := [][]string{}
+	shown := shared.ContainerInfoList{}
 
 	for _, cinfo := range cinfos {
 		cstate := cinfo.State
@@ -125,12 +144,13 @@ func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps b
 		if !shouldShow(filters, &cstate) {
 			continue
 		}
+		shown = append(shown, cinfo)
 
 		if cstate.Status.StatusCode == shared.Running || cstate.Status.StatusCode == shared.Frozen {
 			ipv4s := []string{}
 			ipv6s := []string{}
 			for _, ip := range cstate.Status.Ips {
-				if ip.Interface == "lo" {
+				if ip.Interface == "lo" || ipScope(ip) == "link" {
 					continue
 				}
 
@@ -156,25 +176,55 @@ func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps b
 		// List snapshots
 		csnaps := cinfo.Snaps
 		d = append(d, fmt.Sprintf("%d", len(csnaps)))
+		d = append(d, diskUsageString(cstate.Status.Disk))
 
 		data = append(data, d)
 	}
 
-	table := tablewriter.NewWriter(os.Stdout)
-	table.SetAutoWrapText(false)
-	table.SetRowLine(true)
-	table.SetHeader([]string{
+	outputSort(data, shown)
+	return data, shown
+}
+
+func containerHeader() []string {
+	return []string{
 		i18n.G("NAME"),
 		i18n.G("STATE"),
 		i18n.G("IPV4"),
 		i18n.G("IPV6"),
 		i18n.G("EPHEMERAL"),
-		i18n.G("SNAPSHOTS")})
-	sort.Sort(ByName(data))
-	table.AppendBulk(data)
-	table.Render()
+		i18n.G("SNAPSHOTS"),
+		i18n.G("DISK")}
+}
+
+func containerTable(table *tablewriter.Table) {
+	table.SetAutoWrapText(false)
+	table.SetRowLine(true)
+}
+
+func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps bool) error {
+	data, shown := containerRows(cinfos, filters)
+	if outputFormat == "ansible-inventory" {
+		hosts := []string{}
+		for _, cinfo := range shown {
+			hosts = append(hosts, cinfo.State.Name)
+		}
+
+		return ansibleRender(ansibleInventory(hosts, shown, nil))
+	}
+
+	list := outputList{
+		header: containerHeader(),
+		rows:   data,
+		data:   shown,
+		table:  containerTable,
+	}
 
-	if listsnaps && len(cinfos) == 1 {
+	err := list.render()
+	if err != nil {
+		return err
+	}
+
+	if listsnaps && len(cinfos) == 1 && outputFormat == "table" && !outputQuiet {
 		csnaps := cinfos[0].Snaps
 		first_snapshot := true
 		for _, snap := range csnaps {
@@ -188,7 +238,83 @@ func listContainers(cinfos []shared.ContainerInfo, filters []string, listsnaps b
 	return nil
 }
 
+// listRemotesContainers lists the containers of several remotes at once,
+// whatever could be listed is shown even if some of the remotes failed.
+func listRemotesContainers(config *lxd.Config, remotes []string, filters []string) error {
+	rows := make([][][]string, len(remotes))
+	shown := make([]shared.ContainerInfoList, len(remotes))
+	queryErr := remotesQuery(config, remotes, func(i int, d *lxd.Client) error {
+		cts, err := d.ListContainers()
+		if err != nil {
+			return err
+		}
+
+		rows[i], shown[i] = containerRows(cts, filters)
+		return nil
+	})
+
+	data := map[string]shared.ContainerInfoList{}
+	for i, remote := range remotes {
+		if shown[i] != nil {
+			data[remote] = shown[i]
+		}
+	}
+
+	if outputFormat == "ansible-inventory" {
+		hosts := []string{}
+		hostRemotes := []string{}
+		cinfos := []shared.ContainerInfo{}
+		for i, remote := range remotes {
+			for _, cinfo := range shown[i] {
+				hosts = append(hosts, remote+":"+cinfo.State.Name)
+				hostRemotes = append(hostRemotes, remote)
+				cinfos = append(cinfos, cinfo)
+			}
+		}
+
+		err := ansibleRender(ansibleInventory(hosts, cinfos, hostRemotes))
+		if err != nil {
+			return err
+		}
+
+		return queryErr
+	}
+
+	header, merged := remotesMerge(containerHeader(), remotes, rows)
+	list := outputList{
+		header:     header,
+		rows:       merged,
+		nameColumn: 1,
+		data:       data,
+		table:      containerTable,
+	}
+
+	err := list.render()
+	if err != nil {
+		return err
+	}
+
+	return queryErr
+}
+
 func (c *listCmd) run(config *lxd.Config, args []string) error {
+	remotes, args := remotesShift(config, args)
+	if listAllRemotes {
+		if len(remotes) > 0 {
+			return errArgs
+		}
+
+		remotes = remotesAll(config, false)
+	}
+
+	if len(remotes) > 1 || listAllRemotes {
+		return listRemotesContainers(config, remotes, args)
+	}
+
+	if len(remotes) == 1 {
+		args = append([]string{remotes[0] + ":"}, args...)
+	}
+
 	var remote string
 	name := ""
 
@@ -42,3 +42,17 @@ func TestShouldShow(t *testing.T) {
 		t.Errorf("value filter didn't work")
 	}
 }
+
+func TestContainerRowsSorted(t *testing.T) {
+	cinfos := []shared.ContainerInfo{}
+	for _, name := range []string{"c3", "c1", "c2"} {
+		cinfos = append(cinfos, shared.ContainerInfo{State: shared.ContainerState{Name: name}})
+	}
+
+	rows, shown := containerRows(cinfos, nil)
+	for i, name := range []string{"c1", "c2", "c3"} {
+		if rows[i][0] != name || shown[i].State.Name != name {
+			t.Errorf("Got %s and %s instead of %s", rows[i][0], shown[i].State.Name, name)
+		}
+	}
+}
@@ -18,39 +18,47 @@ import (
 
 func main() {
 	if err := run(); err != nil {
-		// The action we take depends on the error we get.
-		msg := fmt.Sprintf(i18n.G("error: %v"), err)
-		switch t := err.(type) {
-		case *url.Error:
-			switch u := t.Err.(type) {
-			case *net.OpError:
-				if u.Op == "dial" && u.Net == "unix" {
-					switch errno := u.Err.(type) {
-					case syscall.Errno:
-						switch errno {
-						case syscall.ENOENT:
-							msg = i18n.G("LXD socket not found; is LXD running?")
-						case syscall.ECONNREFUSED:
-							msg = i18n.G("Connection refused; is LXD running?")
-						case syscall.EACCES:
-							msg = i18n.G("Permisson denied, are you in the lxd group?")
-						default:
-							msg = fmt.Sprintf("%d %s", uintptr(errno), errno.Error())
-						}
+		fmt.Fprintln(os.Stderr, errorMessage(err))
+		os.Exit(1)
+	}
+}
+
+// errorMessage turns an error into something meaningful to the user.
+func errorMessage(err error) string {
+	// The action we take depends on the error we get.
+	msg := fmt.Sprintf(i18n.G("error: %v"), err)
+	switch t := err.(type) {
+	case *url.Error:
+		switch u := t.Err.(type) {
+		case *net.OpError:
+			if u.Op == "dial" && u.Net == "unix" {
+				switch errno := u.Err.(type) {
+				case syscall.Errno:
+					switch errno {
+					case syscall.ENOENT:
+						msg = i18n.G("LXD socket not found; is LXD running?")
+					case syscall.ECONNREFUSED:
+						msg = i18n.G("Connection refused; is LXD running?")
+					case syscall.EACCES:
+						msg = i18n.G("Permisson denied, are you in the lxd group?")
+					default:
+						msg = fmt.Sprintf("%d %s", uintptr(errno), errno.Error())
 					}
 				}
 			}
 		}
-
-		fmt.Fprintln(os.Stderr, fmt.Sprintf("%s", msg))
-		os.Exit(1)
 	}
+
+	return msg
 }
 
 func run() error {
 	verbose := gnuflag.Bool("verbose", false, i18n.G("Enables verbose mode."))
 	debug := gnuflag.Bool("debug", false, i18n.G("Enables debug mode."))
 	forceLocal := gnuflag.Bool("force-local", false, i18n.G("Force using the local unix socket."))
+	format := gnuflag.String("format", "table", i18n.G("Output format (table, json, yaml, csv or compact)."))
+	quiet := gnuflag.Bool("quiet", false, i18n.G("Only print the names of the listed objects."))
+	socket := gnuflag.String("socket", "", i18n.G("Path to the unix socket of the local daemon."))
 
 	configDir := os.Getenv("LXD_CONF")
 	if configDir != "" {
@@ -110,6 +118,20 @@ func run() error {
 	origArgs := os.Args
 	name := os.Args[1]
 	cmd, ok := commands[name]
+	if !ok {
+		target, isAlias := aliasesGet(config)[name]
+		if isAlias {
+			aliasArgs, err := expandAlias(target, os.Args[2:])
+			if err != nil {
+				return err
+			}
+
+			os.Args = append([]string{os.Args[0]}, aliasArgs...)
+			name = os.Args[1]
+			cmd, ok = commands[name]
+		}
+	}
+
 	if !ok {
 		execIfAliases(config, origArgs)
 		fmt.Fprintf(os.Stderr, i18n.G("error: unknown command: %s")+"\n", name)
@@ -125,6 +147,25 @@ func run() error {
 	os.Args = os.Args[1:]
 	gnuflag.Parse(true)
 
+	formats, ok := cmd.(formatsCommand)
+	if !ok || !shared.StringInSlice(*format, formats.formats()) {
+		err = outputFormatCheck(*format)
+		if err != nil {
+			return err
+		}
+	}
+	outputFormat = *format
+	outputQuiet = *quiet
+
+	// Exported so that the client library and anything we spawn agree on
+	// which daemon the local remote is
+	if *socket != "" {
+		err = os.Setenv("LXD_SOCKET", *socket)
+		if err != nil {
+			return err
+		}
+	}
+
 	shared.Log, err = logging.GetLogger("", "", *verbose, *debug, nil)
 	if err != nil {
 		return err
@@ -133,7 +174,7 @@ func run() error {
 	certf := lxd.ConfigPath("client.crt")
 	keyf := lxd.ConfigPath("client.key")
 
-	if !*forceLocal && os.Args[0] != "help" && os.Args[0] != "version" && (!shared.PathExists(certf) || !shared.PathExists(keyf)) {
+	if !*forceLocal && os.Args[0] != "help" && os.Args[0] != "version" && os.Args[0] != "completion" && (!shared.PathExists(certf) || !shared.PathExists(keyf)) {
 		fmt.Fprintf(os.Stderr, i18n.G("Generating a client certificate. This may take a minute...")+"\n")
 
 		err = shared.FindOrGenCert(certf, keyf)
@@ -161,31 +202,44 @@ type command interface {
 	run(config *lxd.Config, args []string) error
 }
 
+// formatsCommand is implemented by the commands which take --format values
+// of their own besides the output formats.
+type formatsCommand interface {
+	formats() []string
+}
+
 var commands = map[string]command{
-	"config":   &configCmd{},
-	"copy":     &copyCmd{},
-	"delete":   &deleteCmd{},
-	"exec":     &execCmd{},
-	"file":     &fileCmd{},
-	"finger":   &fingerCmd{},
-	"help":     &helpCmd{},
-	"image":    &imageCmd{},
-	"info":     &infoCmd{},
-	"init":     &initCmd{},
-	"launch":   &launchCmd{},
-	"list":     &listCmd{},
-	"monitor":  &monitorCmd{},
-	"move":     &moveCmd{},
-	"pause":    &actionCmd{shared.Freeze, false, false, "pause"},
-	"profile":  &profileCmd{},
-	"publish":  &publishCmd{},
-	"remote":   &remoteCmd{},
-	"restart":  &actionCmd{shared.Restart, true, true, "restart"},
-	"restore":  &restoreCmd{},
-	"snapshot": &snapshotCmd{},
-	"start":    &actionCmd{shared.Start, false, true, "start"},
-	"stop":     &actionCmd{shared.Stop, true, true, "stop"},
-	"version":  &versionCmd{},
+	"alias":      &aliasCmd{},
+	"completion": &completionCmd{},
+	"config":     &configCmd{},
+	"copy":       &copyCmd{},
+	"delete":     &deleteCmd{},
+	"exec":       &execCmd{},
+	"file":       &fileCmd{},
+	"finger":     &fingerCmd{},
+	"help":       &helpCmd{},
+	"image":      &imageCmd{},
+	"info":       &infoCmd{},
+	"init":       &initCmd{},
+	"launch":     &launchCmd{},
+	"list":       &listCmd{},
+	"monitor":    &monitorCmd{},
+	"move":       &moveCmd{},
+	"network":    &networkCmd{},
+	"operation":  &operationCmd{},
+	"pause":      &actionCmd{shared.Freeze, false, true, "pause"},
+	"profile":    &profileCmd{},
+	"publish":    &publishCmd{},
+	"rebuild":    &rebuildCmd{},
+	"remote":     &remoteCmd{},
+	"restart":    &actionCmd{shared.Restart, true, true, "restart"},
+	"restore":    &restoreCmd{},
+	"resume":     &actionCmd{shared.Unfreeze, false, true, "resume"},
+	"snapshot":   &snapshotCmd{},
+	"start":      &actionCmd{shared.Start, false, true, "start"},
+	"stop":       &actionCmd{shared.Stop, true, true, "stop"},
+	"storage":    &storageCmd{},
+	"version":    &versionCmd{},
 }
 
 var errArgs = fmt.Errorf(i18n.G("wrong number of subcommand arguments"))
@@ -4,10 +4,13 @@ import (
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
 	"github.com/krschwab/xlxd/shared"
+	"github.com/krschwab/xlxd/shared/gnuflag"
 )
 
 type moveCmd struct {
 	httpAddr string
+	mode     string
+	bwlimit  string
 }
 
 func (c *moveCmd) showByDefault() bool {
@@ -18,10 +21,19 @@ func (c *moveCmd) usage() string {
 	return i18n.G(
 		`Move containers within or in between lxd instances.
 
-lxc move [remote:]<source container> [remote:]<destination container>`)
+lxc move [remote:]<source container> [remote:]<destination container> [--mode=pull|relay] [--bwlimit=<limit>]
+
+--mode=relay relays the transfer between two servers through the client,
+when the destination can't connect to the source.
+
+--bwlimit limits the transfer between two servers to a number of bytes per
+second, like 10MB.`)
 }
 
-func (c *moveCmd) flags() {}
+func (c *moveCmd) flags() {
+	gnuflag.StringVar(&c.mode, "mode", "pull", i18n.G("Transfer mode, pull or relay"))
+	gnuflag.StringVar(&c.bwlimit, "bwlimit", "", i18n.G("Bandwidth limit of the transfer, in bytes per second"))
+}
 
 func (c *moveCmd) run(config *lxd.Config, args []string) error {
 	if len(args) != 2 {
@@ -64,7 +76,7 @@ func (c *moveCmd) run(config *lxd.Config, args []string) error {
 
 	// A move is just a copy followed by a delete; however, we want to
 	// keep the volatile entries around since we are moving the container.
-	if err := copyContainer(config, args[0], args[1], true, -1); err != nil {
+	if err := copyContainer(config, args[0], args[1], true, -1, false, false, c.mode, c.bwlimit); err != nil {
 		return err
 	}
 
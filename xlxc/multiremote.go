@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+)
+
+// Set by --all-remotes on the listing commands.
+var listAllRemotes = false
+
+// How many remotes are queried at the same time.
+const remotesParallel = 10
+
+// remotesAll returns the name of every configured remote, public ones (image
+// servers) are only included when asked for.
+func remotesAll(config *lxd.Config, public bool) []string {
+	remotes := []string{}
+	for name, remote := range config.Remotes {
+		if remote.Public && !public {
+			continue
+		}
+
+		remotes = append(remotes, name)
+	}
+
+	sort.Strings(remotes)
+	return remotes
+}
+
+// remotesShift takes the leading "<remote>:" arguments off args.
+func remotesShift(config *lxd.Config, args []string) ([]string, []string) {
+	remotes := []string{}
+	for len(args) > 0 && strings.HasSuffix(args[0], ":") {
+		remote := strings.TrimSuffix(args[0], ":")
+		if _, ok := config.Remotes[remote]; !ok {
+			break
+		}
+
+		remotes = append(remotes, remote)
+		args = args[1:]
+	}
+
+	return remotes, args
+}
+
+// remotesQuery runs fn against all the remotes in parallel, with the index
+// of the remote so results can be stored without locking. Failing remotes
+// don't stop the others, they're reported once everything is done.
+func remotesQuery(config *lxd.Config, remotes []string, fn func(i int, d *lxd.Client) error) error {
+	var wg sync.WaitGroup
+	slots := make(chan bool, remotesParallel)
+	errs := make([]error, len(remotes))
+	for i, remote := range remotes {
+		wg.Add(1)
+		go func(i int, remote string) {
+			defer wg.Done()
+			slots <- true
+			defer func() { <-slots }()
+
+			d, err := lxd.NewClient(config, remote)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			errs[i] = fn(i, d)
+		}(i, remote)
+	}
+	wg.Wait()
+
+	failed := []string{}
+	for i, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		failed = append(failed, remotes[i])
+		fmt.Fprintf(os.Stderr, i18n.G("error: %s: %s")+"\n", remotes[i], errorMessage(err))
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf(i18n.G("Failed to query %d of %d remotes: %s"), len(failed), len(remotes), strings.Join(failed, ", "))
+	}
+
+	return nil
+}
+
+// remotesMerge puts together the rows listed on each remote, prefixing them
+// with a REMOTE column and keeping them grouped by remote.
+func remotesMerge(header []string, remotes []string, rows [][][]string) ([]string, [][]string) {
+	merged := [][]string{}
+	for i, remote := range remotes {
+		for _, row := range rows[i] {
+			merged = append(merged, append([]string{remote}, row...))
+		}
+	}
+
+	return append([]string{i18n.G("REMOTE")}, header...), merged
+}
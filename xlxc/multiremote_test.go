@@ -0,0 +1,45 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/krschwab/xlxd"
+)
+
+func TestRemotesShift(t *testing.T) {
+	config := &lxd.Config{Remotes: map[string]lxd.RemoteConfig{
+		"r1": {Addr: "https://r1:8443"},
+		"r2": {Addr: "https://r2:8443"},
+	}}
+
+	remotes, args := remotesShift(config, []string{"r1:", "r2:", "web", "r1:c1"})
+	if !reflect.DeepEqual(remotes, []string{"r1", "r2"}) {
+		t.Errorf("Wrong remotes: %v", remotes)
+	}
+
+	if !reflect.DeepEqual(args, []string{"web", "r1:c1"}) {
+		t.Errorf("Wrong remaining arguments: %v", args)
+	}
+
+	remotes, args = remotesShift(config, []string{"unknown:", "r1:"})
+	if len(remotes) != 0 || len(args) != 2 {
+		t.Errorf("Unknown remote was shifted: %v %v", remotes, args)
+	}
+}
+
+func TestRemotesMerge(t *testing.T) {
+	header, rows := remotesMerge(
+		[]string{"NAME"},
+		[]string{"r1", "r2", "r3"},
+		[][][]string{{{"c1"}, {"c2"}}, nil, {{"c3"}}})
+
+	if !reflect.DeepEqual(header, []string{"REMOTE", "NAME"}) {
+		t.Errorf("Wrong header: %v", header)
+	}
+
+	expected := [][]string{{"r1", "c1"}, {"r1", "c2"}, {"r3", "c3"}}
+	if !reflect.DeepEqual(rows, expected) {
+		t.Errorf("Wrong rows: %v", rows)
+	}
+}
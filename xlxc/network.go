@@ -0,0 +1,341 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+)
+
+type networkCmd struct{}
+
+func (c *networkCmd) showByDefault() bool {
+	return true
+}
+
+func (c *networkCmd) usage() string {
+	return i18n.G(
+		`Manage the networks of the server.
+
+lxc network export [<remote>:][<network>...]   Export bridges, all of them by default, as YAML.
+lxc network import [<remote>:] [<file>]        Create the bridges of an exported file, or STDIN, which are missing.
+    Example: lxc network export > networks.yml
+             lxc network import remote: networks.yml
+
+lxc network acl list [<remote>:]                             List the network ACLs.
+lxc network acl show [<remote>:]<acl>                        Show the rules and networks of an ACL.
+lxc network acl create [<remote>:]<acl> [<description>]      Create an ACL without rules.
+lxc network acl delete [<remote>:]<acl>                      Delete an ACL no container or profile uses.
+lxc network acl rule add [<remote>:]<acl> <direction> <key>=<value>...
+                                                             Append a rule, direction being ingress or egress,
+                                                             with the keys action, protocol, port and subject.
+lxc network acl rule remove [<remote>:]<acl> <index>         Remove the rule at an index, starting at 0.
+lxc network acl assign [<remote>:]<acl> <network>            Apply the ACL to all the containers of a bridge.
+lxc network acl unassign [<remote>:]<acl> <network>          Stop applying the ACL to the containers of a bridge.
+    Example: lxc network acl create web "Web servers"
+             lxc network acl rule add web ingress action=allow protocol=tcp port=80,443
+             lxc network acl rule add web ingress action=drop
+             lxc config set c1 security.acls web`)
+}
+
+func (c *networkCmd) flags() {}
+
+func (c *networkCmd) run(config *lxd.Config, args []string) error {
+	if len(args) < 1 {
+		return errArgs
+	}
+
+	switch args[0] {
+	case "export":
+		return doNetworkExport(config, args[1:])
+	case "import":
+		return doNetworkImport(config, args[1:])
+	case "acl":
+		return doNetworkACL(config, args[1:])
+	default:
+		return errArgs
+	}
+}
+
+func doNetworkExport(config *lxd.Config, args []string) error {
+	remote := config.DefaultRemote
+	names := []string{}
+	if len(args) > 0 {
+		var name string
+		remote, name = config.ParseRemoteAndContainer(args[0])
+		if name != "" {
+			names = append(names, name)
+		}
+		names = append(names, args[1:]...)
+	}
+
+	client, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	networks, err := bundleNetworks(client, names)
+	if err != nil {
+		return err
+	}
+
+	b := bundle{Networks: networks}
+	return b.write()
+}
+
+func doNetworkImport(config *lxd.Config, args []string) error {
+	remote, path, err := bundleImportArgs(config, args)
+	if err != nil {
+		return err
+	}
+
+	b, err := bundleRead(path)
+	if err != nil {
+		return err
+	}
+
+	client, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	return bundleApply(client, &bundle{Networks: b.Networks})
+}
+
+func doNetworkACL(config *lxd.Config, args []string) error {
+	if len(args) < 1 {
+		return errArgs
+	}
+
+	if args[0] == "list" {
+		if len(args) > 2 {
+			return errArgs
+		}
+
+		remote := config.DefaultRemote
+		if len(args) == 2 {
+			remote, _ = config.ParseRemoteAndContainer(args[1])
+		}
+
+		client, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		return doNetworkACLList(client)
+	}
+
+	if args[0] == "rule" {
+		if len(args) < 2 {
+			return errArgs
+		}
+
+		args = append([]string{"rule " + args[1]}, args[2:]...)
+	}
+
+	if len(args) < 2 {
+		return errArgs
+	}
+
+	remote, name := config.ParseRemoteAndContainer(args[1])
+	client, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		return doNetworkACLShow(client, name)
+	case "create":
+		if len(args) > 3 {
+			return errArgs
+		}
+
+		acl := shared.NetworkACL{Name: name}
+		if len(args) == 3 {
+			acl.Description = args[2]
+		}
+
+		err = client.NetworkACLCreate(acl)
+		if err == nil {
+			fmt.Printf(i18n.G("Network ACL %s created")+"\n", name)
+		}
+		return err
+	case "delete":
+		if len(args) != 2 {
+			return errArgs
+		}
+
+		err = client.NetworkACLDelete(name)
+		if err == nil {
+			fmt.Printf(i18n.G("Network ACL %s deleted")+"\n", name)
+		}
+		return err
+	case "rule add":
+		if len(args) < 3 {
+			return errArgs
+		}
+
+		rule, err := networkACLRuleParse(args[2], args[3:])
+		if err != nil {
+			return err
+		}
+
+		return doNetworkACLEdit(client, name, func(acl *shared.NetworkACL) error {
+			acl.Rules = append(acl.Rules, *rule)
+			return nil
+		})
+	case "rule remove":
+		if len(args) != 3 {
+			return errArgs
+		}
+
+		index, err := strconv.Atoi(args[2])
+		if err != nil {
+			return err
+		}
+
+		return doNetworkACLEdit(client, name, func(acl *shared.NetworkACL) error {
+			if index < 0 || index >= len(acl.Rules) {
+				return fmt.Errorf(i18n.G("The ACL has no rule %d"), index)
+			}
+
+			acl.Rules = append(acl.Rules[:index], acl.Rules[index+1:]...)
+			return nil
+		})
+	case "assign", "unassign":
+		if len(args) != 3 {
+			return errArgs
+		}
+
+		return doNetworkACLEdit(client, name, func(acl *shared.NetworkACL) error {
+			assigned := shared.StringInSlice(args[2], acl.Networks)
+			if args[0] == "assign" {
+				if assigned {
+					return fmt.Errorf(i18n.G("The ACL is already assigned to %s"), args[2])
+				}
+
+				acl.Networks = append(acl.Networks, args[2])
+				return nil
+			}
+
+			if !assigned {
+				return fmt.Errorf(i18n.G("The ACL isn't assigned to %s"), args[2])
+			}
+
+			networks := []string{}
+			for _, network := range acl.Networks {
+				if network != args[2] {
+					networks = append(networks, network)
+				}
+			}
+			acl.Networks = networks
+
+			return nil
+		})
+	default:
+		return errArgs
+	}
+}
+
+// networkACLRuleParse builds a rule from its direction and the key=value
+// arguments of "rule add".
+func networkACLRuleParse(direction string, args []string) (*shared.NetworkACLRule, error) {
+	rule := shared.NetworkACLRule{Direction: direction}
+	for _, arg := range args {
+		fields := strings.SplitN(arg, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf(i18n.G("Bad key=value pair: %s"), arg)
+		}
+
+		switch fields[0] {
+		case "action":
+			rule.Action = fields[1]
+		case "protocol":
+			rule.Protocol = fields[1]
+		case "port":
+			rule.Port = fields[1]
+		case "subject":
+			rule.Subject = fields[1]
+		default:
+			return nil, fmt.Errorf(i18n.G("Unknown rule key: %s"), fields[0])
+		}
+	}
+
+	err := rule.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return &rule, nil
+}
+
+// doNetworkACLEdit fetches an ACL, changes it and sends it back.
+func doNetworkACLEdit(client *lxd.Client, name string, edit func(acl *shared.NetworkACL) error) error {
+	acl, err := client.NetworkACL(name)
+	if err != nil {
+		return err
+	}
+
+	err = edit(acl)
+	if err != nil {
+		return err
+	}
+
+	return client.NetworkACLUpdate(*acl)
+}
+
+func doNetworkACLList(client *lxd.Client) error {
+	acls, err := client.NetworkACLs()
+	if err != nil {
+		return err
+	}
+
+	rows := [][]string{}
+	for _, acl := range acls {
+		rows = append(rows, []string{
+			acl.Name,
+			acl.Description,
+			strconv.Itoa(len(acl.Rules)),
+			strings.Join(acl.Networks, "\n"),
+			strconv.Itoa(len(acl.UsedBy))})
+	}
+
+	list := outputList{
+		header: []string{
+			i18n.G("NAME"),
+			i18n.G("DESCRIPTION"),
+			i18n.G("RULES"),
+			i18n.G("NETWORKS"),
+			i18n.G("USED BY")},
+		rows: rows,
+		data: acls,
+	}
+
+	return list.render()
+}
+
+func doNetworkACLShow(client *lxd.Client, name string) error {
+	acl, err := client.NetworkACL(name)
+	if err != nil {
+		return err
+	}
+
+	if outputFormat == "json" {
+		_, err := outputObject(acl)
+		return err
+	}
+
+	data, err := yaml.Marshal(acl)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s", data)
+	return nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+	"github.com/krschwab/xlxd/shared/gnuflag"
+)
+
+type operationCmd struct {
+	status string
+	since  string
+}
+
+func (c *operationCmd) showByDefault() bool {
+	return true
+}
+
+func (c *operationCmd) usage() string {
+	return i18n.G(
+		`Manage the operations of the server.
+
+lxc operation list [remote:] [--status=<status>] [--since=<date|duration>]
+    List the operations in progress. Given a status (running, success,
+    failure, cancelled...) or a date (RFC3339) or a duration (like 2h) they
+    were created since, the matching ones are listed, including those done
+    which the server keeps for core.operations_history_expiry days.
+
+lxc operation show [remote:]<uuid>
+    Show the details of an operation, running or done.
+
+lxc operation delete [remote:]<uuid>
+    Cancel a running operation.
+
+Example:
+    lxc operation list --status=failure --since=24h`)
+}
+
+func (c *operationCmd) flags() {
+	gnuflag.StringVar(&c.status, "status", "", i18n.G("Only list the operations with this status"))
+	gnuflag.StringVar(&c.since, "since", "", i18n.G("Only list the operations created since this date or duration"))
+}
+
+func (c *operationCmd) run(config *lxd.Config, args []string) error {
+	if len(args) < 1 {
+		return errArgs
+	}
+
+	switch args[0] {
+	case "list":
+		return c.doList(config, args[1:])
+	case "show":
+		return c.doShow(config, args[1:])
+	case "delete":
+		return c.doDelete(config, args[1:])
+	default:
+		return errArgs
+	}
+}
+
+func (c *operationCmd) doList(config *lxd.Config, args []string) error {
+	if len(args) > 1 {
+		return errArgs
+	}
+
+	remote := config.DefaultRemote
+	if len(args) == 1 {
+		remote = config.ParseRemote(args[0])
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	ops, err := d.ListOperations(c.status, c.since)
+	if err != nil {
+		return err
+	}
+
+	const layout = "2006/01/02 15:04:05 UTC"
+	rows := [][]string{}
+	for _, op := range ops {
+		end := op.UpdatedAt
+		if !op.StatusCode.IsFinal() {
+			end = time.Now()
+		}
+
+		rows = append(rows, []string{
+			op.Id,
+			op.Description,
+			op.Status,
+			strings.Join(operationResources(op), "\n"),
+			strings.Join(operationProgress(op), "\n"),
+			op.CreatedAt.UTC().Format(layout),
+			(end.Sub(op.CreatedAt) / time.Second * time.Second).String(),
+			op.Err})
+	}
+
+	list := outputList{
+		header: []string{
+			i18n.G("ID"),
+			i18n.G("DESCRIPTION"),
+			i18n.G("STATUS"),
+			i18n.G("RESOURCES"),
+			i18n.G("PROGRESS"),
+			i18n.G("CREATED AT"),
+			i18n.G("DURATION"),
+			i18n.G("ERROR")},
+		rows: rows,
+		data: ops,
+	}
+
+	return list.render()
+}
+
+func (c *operationCmd) doShow(config *lxd.Config, args []string) error {
+	if len(args) != 1 {
+		return errArgs
+	}
+
+	remote, id := config.ParseRemoteAndContainer(args[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	op, err := d.GetOperation(id)
+	if err != nil {
+		return err
+	}
+
+	if ok, err := outputObject(op); ok {
+		return err
+	}
+
+	const layout = "2006/01/02 15:04:05 UTC"
+	fmt.Printf(i18n.G("ID: %s")+"\n", op.Id)
+	fmt.Printf(i18n.G("Class: %s")+"\n", op.Class)
+	if op.Description != "" {
+		fmt.Printf(i18n.G("Description: %s")+"\n", op.Description)
+	}
+	if op.Initiator != "" {
+		fmt.Printf(i18n.G("Initiator: %s")+"\n", op.Initiator)
+	}
+	fmt.Printf(i18n.G("Status: %s")+"\n", op.Status)
+	fmt.Printf(i18n.G("Created: %s")+"\n", op.CreatedAt.UTC().Format(layout))
+	fmt.Printf(i18n.G("Updated: %s")+"\n", op.UpdatedAt.UTC().Format(layout))
+	fmt.Printf(i18n.G("Cancelable: %t")+"\n", op.MayCancel)
+
+	resources := operationResources(*op)
+	if len(resources) > 0 {
+		fmt.Println(i18n.G("Resources:"))
+		for _, resource := range resources {
+			fmt.Printf("  %s\n", resource)
+		}
+	}
+
+	progress := operationProgress(*op)
+	if len(progress) > 0 {
+		fmt.Println(i18n.G("Progress:"))
+		for _, p := range progress {
+			fmt.Printf("  %s\n", p)
+		}
+	}
+
+	if op.Err != "" {
+		fmt.Printf(i18n.G("Error: %s")+"\n", op.Err)
+	}
+
+	return nil
+}
+
+func (c *operationCmd) doDelete(config *lxd.Config, args []string) error {
+	if len(args) != 1 {
+		return errArgs
+	}
+
+	remote, id := config.ParseRemoteAndContainer(args[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	return d.CancelOperation(id)
+}
+
+// operationResources returns the resources of an operation as their paths
+// under the API, like containers/foo.
+func operationResources(op shared.Operation) []string {
+	prefix := fmt.Sprintf("/%s/", shared.APIVersion)
+
+	resources := []string{}
+	for _, urls := range op.Resources {
+		for _, url := range urls {
+			resources = append(resources, strings.TrimPrefix(url, prefix))
+		}
+	}
+
+	sort.Strings(resources)
+	return resources
+}
+
+// operationProgress returns the progress the server reports in the metadata
+// of an operation, like "download: 40%".
+func operationProgress(op shared.Operation) []string {
+	progress := []string{}
+	if op.Metadata == nil {
+		return progress
+	}
+
+	for key, value := range *op.Metadata {
+		if !strings.HasSuffix(key, "_progress") {
+			continue
+		}
+
+		progress = append(progress, fmt.Sprintf("%s: %v", strings.TrimSuffix(key, "_progress"), value))
+	}
+
+	sort.Strings(progress)
+	return progress
+}
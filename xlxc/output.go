@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/olekukonko/tablewriter"
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd/i18n"
+)
+
+// The output controls shared by all the commands, set from --format and
+// --quiet.
+var outputFormat = "table"
+var outputQuiet = false
+
+var outputFormats = []string{"table", "json", "yaml", "csv", "compact"}
+
+func outputFormatCheck(format string) error {
+	for _, entry := range outputFormats {
+		if entry == format {
+			return nil
+		}
+	}
+
+	return fmt.Errorf(i18n.G("Invalid output format %q, must be one of: %s"), format, strings.Join(outputFormats, ", "))
+}
+
+// outputList is a listing which can be rendered in any of the output formats.
+type outputList struct {
+	header []string
+	rows   [][]string
+
+	// The column printed with --quiet
+	nameColumn int
+
+	// The objects the rows were built from, printed by json and yaml
+	data interface{}
+
+	// Tweaks to the default table rendering
+	table func(table *tablewriter.Table)
+}
+
+func (l *outputList) render() error {
+	if outputQuiet {
+		for _, row := range l.rows {
+			fmt.Println(row[l.nameColumn])
+		}
+
+		return nil
+	}
+
+	switch outputFormat {
+	case "json", "yaml":
+		_, err := outputObject(l.data)
+		return err
+	case "csv":
+		return csv.NewWriter(os.Stdout).WriteAll(append([][]string{l.header}, l.rows...))
+	case "compact":
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, strings.Join(l.header, "\t"))
+		for _, row := range l.rows {
+			cells := []string{}
+			for _, cell := range row {
+				cells = append(cells, strings.Replace(cell, "\n", ", ", -1))
+			}
+
+			fmt.Fprintln(w, strings.Join(cells, "\t"))
+		}
+
+		return w.Flush()
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	if l.table != nil {
+		l.table(table)
+	}
+	table.SetHeader(l.header)
+	table.AppendBulk(l.rows)
+	table.Render()
+
+	return nil
+}
+
+// outputRows sorts rows by name along with the objects they were built
+// from, so that json and yaml list them in the same order as the table.
+type outputRows struct {
+	rows [][]string
+	swap func(i, j int)
+}
+
+func (r outputRows) Len() int {
+	return len(r.rows)
+}
+
+func (r outputRows) Swap(i, j int) {
+	r.rows[i], r.rows[j] = r.rows[j], r.rows[i]
+	r.swap(i, j)
+}
+
+func (r outputRows) Less(i, j int) bool {
+	return ByName(r.rows).Less(i, j)
+}
+
+// outputSort sorts the rows and objects, a slice holding the object of
+// each row, by name.
+func outputSort(rows [][]string, objects interface{}) {
+	sort.Sort(outputRows{rows: rows, swap: reflect.Swapper(objects)})
+}
+
+// outputObject prints a single object when json or yaml was asked for, it
+// returns false when the command should print its usual output instead.
+func outputObject(data interface{}) (bool, error) {
+	var out []byte
+	var err error
+
+	switch outputFormat {
+	case "json":
+		out, err = json.MarshalIndent(data, "", "    ")
+		if err == nil {
+			out = append(out, '\n')
+		}
+	case "yaml":
+		out, err = yaml.Marshal(data)
+	default:
+		return false, nil
+	}
+
+	if err != nil {
+		return true, err
+	}
+
+	fmt.Printf("%s", out)
+	return true, nil
+}
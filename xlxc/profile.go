@@ -13,10 +13,13 @@ import (
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
 	"github.com/krschwab/xlxd/shared"
+	"github.com/krschwab/xlxd/shared/gnuflag"
 )
 
 type profileCmd struct {
 	httpAddr string
+	force    bool
+	usedBy   bool
 }
 
 func (c *profileCmd) showByDefault() bool {
@@ -47,15 +50,19 @@ func (c *profileCmd) usage() string {
 		`Manage configuration profiles.
 
 lxc profile list [filters]                     List available profiles.
-lxc profile show <profile>                     Show details of a profile.
+lxc profile show <profile> [--usedby]         Show details of a profile, or the containers using it.
 lxc profile create <profile>                   Create a profile.
 lxc profile copy <profile> <remote>            Copy the profile to the specified remote.
 lxc profile set <profile> <key> <value>        Set profile configuration.
-lxc profile delete <profile>                   Delete a profile.
+lxc profile delete <profile> [--force]         Delete a profile, --force even if containers use it.
 lxc profile edit <profile>                     
     Edit profile, either by launching external editor or reading STDIN.
     Example: lxc profile edit <profile> # launch editor
              cat profile.yml | lxc profile edit <profile> # read from profile.yml
+lxc profile export [<remote>:][<profile>...]   Export profiles, all of them by default, as YAML.
+lxc profile import [<remote>:] [<file>]        Create or update the profiles of an exported file, or STDIN.
+    Example: lxc profile export > profiles.yml
+             lxc profile import remote: profiles.yml
 lxc profile apply <container> <profiles>
     Apply a comma-separated list of profiles to a container, in order.
     All profiles passed in this call (and only those) will be applied
@@ -64,6 +71,8 @@ lxc profile apply <container> <profiles>
              lxc profile apply foo default # Only default is active
              lxc profile apply '' # no profiles are applied anymore
              lxc profile apply bar,default # Apply default second now
+    When several profiles set the same key, the last one wins and the
+    container's own config overrides them all.
 
 Devices:
 lxc profile device list <profile>              List devices in the given profile.
@@ -74,7 +83,10 @@ lxc profile device add <profile name> <device name> <device type> [key=value]...
     using the specified profile.`)
 }
 
-func (c *profileCmd) flags() {}
+func (c *profileCmd) flags() {
+	gnuflag.BoolVar(&c.force, "force", false, i18n.G("Delete the profile even if containers use it"))
+	gnuflag.BoolVar(&c.usedBy, "usedby", false, i18n.G("Only show the containers using the profile"))
+}
 
 func (c *profileCmd) run(config *lxd.Config, args []string) error {
 	if len(args) < 1 {
@@ -85,6 +97,14 @@ func (c *profileCmd) run(config *lxd.Config, args []string) error {
 		return doProfileList(config, args)
 	}
 
+	if args[0] == "export" {
+		return doProfileExport(config, args[1:])
+	}
+
+	if args[0] == "import" {
+		return doProfileImport(config, args[1:])
+	}
+
 	if len(args) < 2 {
 		return errArgs
 	}
@@ -99,7 +119,7 @@ func (c *profileCmd) run(config *lxd.Config, args []string) error {
 	case "create":
 		return doProfileCreate(client, profile)
 	case "delete":
-		return doProfileDelete(client, profile)
+		return doProfileDelete(client, profile, c.force)
 	case "device":
 		return doProfileDevice(config, args)
 	case "edit":
@@ -124,7 +144,7 @@ func (c *profileCmd) run(config *lxd.Config, args []string) error {
 	case "copy":
 		return doProfileCopy(config, client, profile, args[2:])
 	case "show":
-		return doProfileShow(client, profile)
+		return doProfileShow(client, profile, c.usedBy)
 	default:
 		return errArgs
 	}
@@ -200,8 +220,8 @@ func doProfileEdit(client *lxd.Client, p string) error {
 	return nil
 }
 
-func doProfileDelete(client *lxd.Client, p string) error {
-	err := client.ProfileDelete(p)
+func doProfileDelete(client *lxd.Client, p string, force bool) error {
+	err := client.ProfileDelete(p, force)
 	if err == nil {
 		fmt.Printf(i18n.G("Profile %s deleted")+"\n", p)
 	}
@@ -221,18 +241,77 @@ func doProfileApply(client *lxd.Client, c string, p string) error {
 	return client.WaitForSuccess(resp.Operation)
 }
 
-func doProfileShow(client *lxd.Client, p string) error {
+func doProfileShow(client *lxd.Client, p string, usedBy bool) error {
 	profile, err := client.ProfileConfig(p)
 	if err != nil {
 		return err
 	}
 
+	if usedBy {
+		for _, url := range profile.UsedBy {
+			name := strings.TrimPrefix(url, fmt.Sprintf("/%s/containers/", shared.APIVersion))
+			fmt.Println(strings.Replace(name, "/snapshots/", shared.SnapshotDelimiter, 1))
+		}
+
+		return nil
+	}
+
+	if outputFormat == "json" {
+		_, err := outputObject(profile)
+		return err
+	}
+
 	data, err := yaml.Marshal(&profile)
 	fmt.Printf("%s", data)
 
 	return nil
 }
 
+func doProfileExport(config *lxd.Config, args []string) error {
+	remote := config.DefaultRemote
+	names := []string{}
+	if len(args) > 0 {
+		var name string
+		remote, name = config.ParseRemoteAndContainer(args[0])
+		if name != "" {
+			names = append(names, name)
+		}
+		names = append(names, args[1:]...)
+	}
+
+	client, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	profiles, err := bundleProfiles(client, names)
+	if err != nil {
+		return err
+	}
+
+	b := bundle{Profiles: profiles}
+	return b.write()
+}
+
+func doProfileImport(config *lxd.Config, args []string) error {
+	remote, path, err := bundleImportArgs(config, args)
+	if err != nil {
+		return err
+	}
+
+	b, err := bundleRead(path)
+	if err != nil {
+		return err
+	}
+
+	client, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	return bundleApply(client, &bundle{Profiles: b.Profiles})
+}
+
 func doProfileCopy(config *lxd.Config, client *lxd.Client, p string, args []string) error {
 	if len(args) != 1 {
 		return errArgs
@@ -336,6 +415,17 @@ func doProfileList(config *lxd.Config, args []string) error {
 	if err != nil {
 		return err
 	}
-	fmt.Printf("%s\n", strings.Join(profiles, "\n"))
-	return nil
+
+	data := [][]string{}
+	for _, profile := range profiles {
+		data = append(data, []string{profile})
+	}
+
+	list := outputList{
+		header: []string{i18n.G("NAME")},
+		rows:   data,
+		data:   profiles,
+	}
+
+	return list.render()
 }
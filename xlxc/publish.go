@@ -2,6 +2,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 
 	"github.com/krschwab/xlxd"
@@ -19,15 +20,31 @@ func (c *publishCmd) usage() string {
 	return i18n.G(
 		`Publish containers as images.
 
-lxc publish [remote:]container [remote:] [--alias=ALIAS]... [prop-key=prop-value]...`)
+lxc publish [remote:]container [remote:] [--alias=ALIAS]... [--compression=ALGORITHM[:LEVEL]] [prop-key=prop-value]...
+lxc publish [remote:]container --format=oci [<file>] [--alias=NAME[:TAG]]... [label-key=label-value]...
+
+With --format=oci, the container is written to <file> (<container>.tar by
+default) as an OCI image layout, which docker load accepts too. The aliases
+are used as the image's tags.
+
+--compression overrides the images.compression_algorithm (bzip2, gzip, lzma,
+xz, zstd or none) and images.compression_level of the server, zstd being the
+fastest.`)
+}
+
+// publish takes its own --format values on top of the output formats.
+func (c *publishCmd) formats() []string {
+	return []string{"oci"}
 }
 
 var pAliases aliasList // aliasList defined in lxc/image.go
 var makePublic bool
+var pCompression string
 
 func (c *publishCmd) flags() {
 	gnuflag.BoolVar(&makePublic, "public", false, i18n.G("Make the image public"))
 	gnuflag.Var(&pAliases, "alias", i18n.G("New alias to define at target"))
+	gnuflag.StringVar(&pCompression, "compression", "", i18n.G("Compression algorithm and level of the image"))
 }
 
 func (c *publishCmd) run(config *lxd.Config, args []string) error {
@@ -43,6 +60,10 @@ func (c *publishCmd) run(config *lxd.Config, args []string) error {
 	}
 
 	cRemote, cName = config.ParseRemoteAndContainer(args[0])
+	if outputFormat == "oci" {
+		return c.publishOCI(config, cRemote, cName, args[1:])
+	}
+
 	if len(args) >= 2 && !strings.Contains(args[1], "=") {
 		firstprop = 2
 		iRemote, iName = config.ParseRemoteAndContainer(args[1])
@@ -74,7 +95,7 @@ func (c *publishCmd) run(config *lxd.Config, args []string) error {
 
 	// Optimized local publish
 	if cRemote == iRemote {
-		fp, err = d.ImageFromContainer(cName, makePublic, pAliases, properties)
+		fp, err = d.ImageFromContainer(cName, makePublic, pAliases, properties, pCompression)
 		if err != nil {
 			return err
 		}
@@ -87,13 +108,13 @@ func (c *publishCmd) run(config *lxd.Config, args []string) error {
 		return err
 	}
 
-	fp, err = s.ImageFromContainer(cName, false, nil, properties)
+	fp, err = s.ImageFromContainer(cName, false, nil, properties, pCompression)
 	if err != nil {
 		return err
 	}
 	defer s.DeleteImage(fp)
 
-	err = s.CopyImage(fp, d, false, pAliases, makePublic)
+	err = s.CopyImage(fp, d, false, pAliases, makePublic, "pull", "")
 	if err != nil {
 		return err
 	}
@@ -102,3 +123,52 @@ func (c *publishCmd) run(config *lxd.Config, args []string) error {
 
 	return nil
 }
+
+func (c *publishCmd) publishOCI(config *lxd.Config, remote string, name string, args []string) error {
+	if name == "" {
+		return fmt.Errorf(i18n.G("Container name is mandatory"))
+	}
+
+	target := fmt.Sprintf("%s.tar", strings.Replace(name, "/", "_", -1))
+	if len(args) >= 1 && !strings.Contains(args[0], "=") {
+		target = args[0]
+		args = args[1:]
+	}
+
+	labels := map[string]string{}
+	for _, arg := range args {
+		entry := strings.SplitN(arg, "=", 2)
+		if len(entry) < 2 {
+			return errArgs
+		}
+		labels[entry[0]] = entry[1]
+	}
+
+	tags := []string{}
+	for _, alias := range pAliases {
+		if !strings.Contains(alias, ":") {
+			alias += ":latest"
+		}
+		tags = append(tags, alias)
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	err = d.ExportContainerOCI(name, tags, labels, f)
+	if err != nil {
+		os.Remove(target)
+		return err
+	}
+
+	fmt.Printf(i18n.G("Container exported to: %s")+"\n", target)
+	return nil
+}
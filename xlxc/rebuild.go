@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+	"github.com/krschwab/xlxd/shared/gnuflag"
+)
+
+type rebuildCmd struct {
+	force bool
+}
+
+func (c *rebuildCmd) showByDefault() bool {
+	return true
+}
+
+func (c *rebuildCmd) usage() string {
+	return i18n.G(
+		`Reset a container to a fresh copy of an image.
+
+lxc rebuild [remote:]<container> [[remote:]<image>] [--force]
+
+The root filesystem of the container is replaced by the image it was
+created from, or by the given image which is looked for on the container's
+remote unless another one is named. Its configuration, devices, profiles
+and snapshots are kept, and so are its MAC and IP addresses.
+
+The container must be stopped, --force stops it first.`)
+}
+
+func (c *rebuildCmd) flags() {
+	gnuflag.BoolVar(&c.force, "force", false, i18n.G("Stop the container first if it's running"))
+}
+
+func (c *rebuildCmd) run(config *lxd.Config, args []string) error {
+	if len(args) < 1 || len(args) > 2 {
+		return errArgs
+	}
+
+	remote, name := config.ParseRemoteAndContainer(args[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	iremote := remote
+	image := ""
+	if len(args) == 2 {
+		image = args[1]
+		if strings.Contains(image, ":") {
+			iremote, image = config.ParseRemoteAndContainer(image)
+		}
+	}
+
+	ct, err := d.ContainerStatus(name)
+	if err != nil {
+		return err
+	}
+
+	if ct.Status.StatusCode != shared.Stopped {
+		if !c.force {
+			return fmt.Errorf(i18n.G("The container is running, stop it first or use --force"))
+		}
+
+		resp, err := d.Action(name, shared.Stop, -1, true)
+		if err != nil {
+			return err
+		}
+
+		err = d.WaitForSuccess(resp.Operation)
+		if err != nil {
+			return err
+		}
+	}
+
+	resp, err := d.Rebuild(name, iremote, image)
+	if err != nil {
+		return err
+	}
+
+	return d.WaitForSuccess(resp.Operation)
+}
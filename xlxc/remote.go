@@ -8,7 +8,6 @@ import (
 	"sort"
 	"strings"
 
-	"github.com/olekukonko/tablewriter"
 	"golang.org/x/crypto/ssh/terminal"
 
 	"github.com/krschwab/xlxd"
@@ -22,6 +21,7 @@ type remoteCmd struct {
 	acceptCert bool
 	password   string
 	public     bool
+	via        string
 }
 
 func (c *remoteCmd) showByDefault() bool {
@@ -33,21 +33,26 @@ func (c *remoteCmd) usage() string {
 		`Manage remote LXD servers.
 
 lxc remote add <name> <url> [--accept-certificate] [--password=PASSWORD] [--public]    Add the remote <name> at <url>.
+    [--via=<remote>:|ssh://[user@]host[:port]]
+    With --via, the remote is reached through the daemon of another remote or
+    an SSH bastion, for the servers on private networks.
 lxc remote remove <name>                                                               Remove the remote <name>.
 lxc remote list                                                                        List all remotes.
 lxc remote rename <old> <new>                                                          Rename remote <old> to <new>.
 lxc remote set-url <name> <url>                                                        Update <name>'s url to <url>.
 lxc remote set-default <name>                                                          Set the default remote.
-lxc remote get-default                                                                 Print the default remote.`)
+lxc remote get-default                                                                 Print the default remote.
+lxc remote refresh-cache [<name>]                                                      Refresh the names cached for the shell completion.`)
 }
 
 func (c *remoteCmd) flags() {
 	gnuflag.BoolVar(&c.acceptCert, "accept-certificate", false, i18n.G("Accept certificate"))
 	gnuflag.StringVar(&c.password, "password", "", i18n.G("Remote admin password"))
 	gnuflag.BoolVar(&c.public, "public", false, i18n.G("Public image server"))
+	gnuflag.StringVar(&c.via, "via", "", i18n.G("Remote or SSH bastion to connect through"))
 }
 
-func addServer(config *lxd.Config, server string, addr string, acceptCert bool, password string, public bool) error {
+func addServer(config *lxd.Config, server string, addr string, acceptCert bool, password string, public bool, via string) error {
 	var r_scheme string
 	var r_host string
 	var r_port string
@@ -118,7 +123,19 @@ func addServer(config *lxd.Config, server string, addr string, acceptCert bool,
 	}
 
 	/* Actually add the remote */
-	config.Remotes[server] = lxd.RemoteConfig{Addr: addr}
+	config.Remotes[server] = lxd.RemoteConfig{Addr: addr, Protocol: "lxd"}
+	if r_scheme == "https" {
+		rc := config.Remotes[server]
+		rc.AuthType = "tls"
+		config.Remotes[server] = rc
+	}
+
+	if via != "" {
+		err := config.SetRemoteSetting(server, "via", via)
+		if err != nil {
+			return err
+		}
+	}
 
 	remote := config.ParseRemote(server)
 	c, err := lxd.NewClient(config, remote)
@@ -138,7 +155,9 @@ func addServer(config *lxd.Config, server string, addr string, acceptCert bool,
 	}
 
 	if c.IsPublic() || public {
-		config.Remotes[server] = lxd.RemoteConfig{Addr: addr, Public: true}
+		rc := config.Remotes[server]
+		rc.Public = true
+		config.Remotes[server] = rc
 
 		if err := c.Finger(); err != nil {
 			return err
@@ -185,6 +204,7 @@ func removeCertificate(remote string) {
 	shared.Debugf("Trying to remove %s", certf)
 
 	os.Remove(certf)
+	lxd.ClearNameCache(remote)
 }
 
 func (c *remoteCmd) run(config *lxd.Config, args []string) error {
@@ -202,7 +222,7 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 			return fmt.Errorf(i18n.G("remote %s exists as <%s>"), args[1], rc.Addr)
 		}
 
-		err := addServer(config, args[1], args[2], c.acceptCert, c.password, c.public)
+		err := addServer(config, args[1], args[2], c.acceptCert, c.password, c.public, c.via)
 		if err != nil {
 			delete(config.Remotes, args[1])
 			removeCertificate(args[1])
@@ -222,6 +242,12 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 			return fmt.Errorf(i18n.G("can't remove the default remote"))
 		}
 
+		for name, rc := range config.Remotes {
+			if rc.Via == args[1] {
+				return fmt.Errorf(i18n.G("can't remove remote %s, %s is reached through it"), args[1], name)
+			}
+		}
+
 		delete(config.Remotes, args[1])
 
 		removeCertificate(args[1])
@@ -229,23 +255,28 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 	case "list":
 		data := [][]string{}
 		for name, rc := range config.Remotes {
+			public := i18n.G("NO")
 			if rc.Public {
-				data = append(data, []string{name, rc.Addr, i18n.G("YES")})
-			} else {
-				data = append(data, []string{name, rc.Addr, i18n.G("NO")})
+				public = i18n.G("YES")
 			}
+
+			data = append(data, []string{name, rc.Addr, rc.Protocol, rc.AuthType, public, rc.Via})
 		}
 
-		table := tablewriter.NewWriter(os.Stdout)
-		table.SetHeader([]string{
-			i18n.G("NAME"),
-			i18n.G("URL"),
-			i18n.G("PUBLIC")})
 		sort.Sort(ByName(data))
-		table.AppendBulk(data)
-		table.Render()
+		list := outputList{
+			header: []string{
+				i18n.G("NAME"),
+				i18n.G("URL"),
+				i18n.G("PROTOCOL"),
+				i18n.G("AUTH TYPE"),
+				i18n.G("PUBLIC"),
+				i18n.G("VIA")},
+			rows: data,
+			data: config.Remotes,
+		}
 
-		return nil
+		return list.render()
 
 	case "rename":
 		if len(args) != 3 {
@@ -263,6 +294,14 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 
 		config.Remotes[args[2]] = rc
 		delete(config.Remotes, args[1])
+		lxd.ClearNameCache(args[1])
+
+		for name, rc := range config.Remotes {
+			if rc.Via == args[1] {
+				rc.Via = args[2]
+				config.Remotes[name] = rc
+			}
+		}
 
 		if config.DefaultRemote == args[1] {
 			config.DefaultRemote = args[2]
@@ -272,11 +311,13 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 		if len(args) != 3 {
 			return errArgs
 		}
-		_, ok := config.Remotes[args[1]]
+		rc, ok := config.Remotes[args[1]]
 		if !ok {
 			return fmt.Errorf(i18n.G("remote %s doesn't exist"), args[1])
 		}
-		config.Remotes[args[1]] = lxd.RemoteConfig{Addr: args[2]}
+		rc.Addr = args[2]
+		config.Remotes[args[1]] = rc
+		lxd.ClearNameCache(args[1])
 
 	case "set-default":
 		if len(args) != 2 {
@@ -295,6 +336,23 @@ func (c *remoteCmd) run(config *lxd.Config, args []string) error {
 		}
 		fmt.Println(config.DefaultRemote)
 		return nil
+
+	case "refresh-cache":
+		if len(args) > 2 {
+			return errArgs
+		}
+
+		remote := config.DefaultRemote
+		if len(args) == 2 {
+			remote = config.ParseRemote(args[1])
+		}
+
+		d, err := lxd.NewClient(config, remote)
+		if err != nil {
+			return err
+		}
+
+		return d.RefreshNameCache()
 	default:
 		return errArgs
 	}
@@ -2,6 +2,10 @@ package main
 
 import (
 	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/i18n"
@@ -11,6 +15,7 @@ import (
 
 type snapshotCmd struct {
 	stateful bool
+	expiry   string
 }
 
 func (c *snapshotCmd) showByDefault() bool {
@@ -19,13 +24,33 @@ func (c *snapshotCmd) showByDefault() bool {
 
 func (c *snapshotCmd) usage() string {
 	return i18n.G(
-		`Create a read-only snapshot of a container.
+		`Create, list and rename the read-only snapshots of a container.
 
-lxc snapshot [remote:]<source> <snapshot name> [--stateful]`)
+lxc snapshot [remote:]<source> <snapshot name> [--stateful] [--expiry=<expiry>]
+    Create a snapshot of the container.
+
+lxc snapshot list [remote:]<source>
+    List the snapshots of the container with their creation date, whether
+    they're stateful, the disk space they use and when they expire.
+
+lxc snapshot rename [remote:]<source> <old name> <new name>
+    Rename a snapshot.
+
+lxc snapshot expiry [remote:]<source> <snapshot name> <expiry>
+    Change when a snapshot expires.
+
+Expired snapshots are deleted by the daemon. The expiry is either a delay
+from now like 12h or 7d, a date like 2006-01-02 or 2006-01-02T15:04:05Z, or
+"never".
+
+Snapshots are deleted with "lxc delete <source>/<snapshot name>". A container
+named list or rename is snapshotted by giving its remote, e.g.
+"lxc snapshot local:list".`)
 }
 
 func (c *snapshotCmd) flags() {
 	gnuflag.BoolVar(&c.stateful, "stateful", false, i18n.G("Whether or not to snapshot the container's running state"))
+	gnuflag.StringVar(&c.expiry, "expiry", "never", i18n.G("When the snapshot expires"))
 }
 
 func (c *snapshotCmd) run(config *lxd.Config, args []string) error {
@@ -33,6 +58,15 @@ func (c *snapshotCmd) run(config *lxd.Config, args []string) error {
 		return errArgs
 	}
 
+	switch args[0] {
+	case "list":
+		return c.doList(config, args[1:])
+	case "rename":
+		return c.doRename(config, args[1:])
+	case "expiry":
+		return c.doExpiry(config, args[1:])
+	}
+
 	var snapname string
 	if len(args) < 2 {
 		snapname = ""
@@ -51,10 +85,166 @@ func (c *snapshotCmd) run(config *lxd.Config, args []string) error {
 		return fmt.Errorf(i18n.G("'/' not allowed in snapshot name"))
 	}
 
-	resp, err := d.Snapshot(name, snapname, c.stateful)
+	expiry, err := snapshotExpiryParse(c.expiry, time.Now())
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.Snapshot(name, snapname, c.stateful, expiry)
+	if err != nil {
+		return err
+	}
+
+	return d.WaitForSuccess(resp.Operation)
+}
+
+// snapshotsByDate sorts snapshots from the oldest to the newest.
+type snapshotsByDate []shared.ContainerSnapshot
+
+func (a snapshotsByDate) Len() int {
+	return len(a)
+}
+
+func (a snapshotsByDate) Swap(i, j int) {
+	a[i], a[j] = a[j], a[i]
+}
+
+func (a snapshotsByDate) Less(i, j int) bool {
+	if a[i].CreationDate.Equal(a[j].CreationDate) {
+		return a[i].Name < a[j].Name
+	}
+
+	return a[i].CreationDate.Before(a[j].CreationDate)
+}
+
+func (c *snapshotCmd) doList(config *lxd.Config, args []string) error {
+	if len(args) != 1 {
+		return errArgs
+	}
+
+	remote, name := config.ParseRemoteAndContainer(args[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	snapshots, err := d.SnapshotsInfo(name)
+	if err != nil {
+		return err
+	}
+
+	sort.Sort(snapshotsByDate(snapshots))
+
+	const layout = "2006/01/02 15:04 UTC"
+	rows := [][]string{}
+	for _, snapshot := range snapshots {
+		// Snapshots taken before creation dates were recorded don't
+		// have one
+		taken := ""
+		if snapshot.CreationDate.Unix() > 0 {
+			taken = snapshot.CreationDate.UTC().Format(layout)
+		}
+
+		stateful := i18n.G("no")
+		if snapshot.Stateful {
+			stateful = i18n.G("yes")
+		}
+
+		size := ""
+		if snapshot.Size >= 0 {
+			size = shared.GetByteSizeString(snapshot.Size)
+		}
+
+		expires := ""
+		if !snapshot.ExpiresAt.IsZero() {
+			expires = snapshot.ExpiresAt.UTC().Format(layout)
+		}
+
+		rows = append(rows, []string{snapshot.Name, taken, stateful, size, expires})
+	}
+
+	list := outputList{
+		header: []string{
+			i18n.G("NAME"),
+			i18n.G("TAKEN AT"),
+			i18n.G("STATEFUL"),
+			i18n.G("SIZE"),
+			i18n.G("EXPIRES AT")},
+		rows: rows,
+		data: snapshots,
+	}
+
+	return list.render()
+}
+
+func (c *snapshotCmd) doRename(config *lxd.Config, args []string) error {
+	if len(args) != 3 {
+		return errArgs
+	}
+
+	remote, name := config.ParseRemoteAndContainer(args[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	if shared.IsSnapshot(args[2]) {
+		return fmt.Errorf(i18n.G("'/' not allowed in snapshot name"))
+	}
+
+	resp, err := d.Rename(name+shared.SnapshotDelimiter+args[1], name+shared.SnapshotDelimiter+args[2])
 	if err != nil {
 		return err
 	}
 
 	return d.WaitForSuccess(resp.Operation)
 }
+
+func (c *snapshotCmd) doExpiry(config *lxd.Config, args []string) error {
+	if len(args) != 3 {
+		return errArgs
+	}
+
+	remote, name := config.ParseRemoteAndContainer(args[0])
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	expiry, err := snapshotExpiryParse(args[2], time.Now())
+	if err != nil {
+		return err
+	}
+
+	return d.SetSnapshotExpiry(name, args[1], expiry)
+}
+
+// snapshotExpiryParse returns the expiry date described by value, the zero
+// time for "never". Delays are counted from now.
+func snapshotExpiryParse(value string, now time.Time) (time.Time, error) {
+	if value == "" || value == "never" {
+		return time.Time{}, nil
+	}
+
+	// Go durations don't have days
+	if strings.HasSuffix(value, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(value, "d"))
+		if err == nil && days > 0 {
+			return now.Add(time.Duration(days) * 24 * time.Hour), nil
+		}
+	}
+
+	delay, err := time.ParseDuration(value)
+	if err == nil && delay > 0 {
+		return now.Add(delay), nil
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		date, err := time.Parse(layout, value)
+		if err == nil {
+			return date, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf(i18n.G("Invalid expiry: %s"), value)
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSnapshotExpiryParse(t *testing.T) {
+	now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := map[string]time.Time{
+		"never":                time.Time{},
+		"":                     time.Time{},
+		"12h":                  now.Add(12 * time.Hour),
+		"7d":                   now.Add(7 * 24 * time.Hour),
+		"2016-04-01":           time.Date(2016, 4, 1, 0, 0, 0, 0, time.UTC),
+		"2016-04-01T10:30:00Z": time.Date(2016, 4, 1, 10, 30, 0, 0, time.UTC),
+	}
+
+	for value, expected := range tests {
+		expiry, err := snapshotExpiryParse(value, now)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %s", value, err)
+			continue
+		}
+
+		if !expiry.Equal(expected) {
+			t.Errorf("Got %s instead of %s for %q", expiry, expected, value)
+		}
+	}
+
+	for _, value := range []string{"soon", "-1d", "0h", "2016-13-01"} {
+		_, err := snapshotExpiryParse(value, now)
+		if err == nil {
+			t.Errorf("Invalid expiry %q was accepted", value)
+		}
+	}
+}
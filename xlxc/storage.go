@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/i18n"
+	"github.com/krschwab/xlxd/shared"
+)
+
+type storageCmd struct{}
+
+func (c *storageCmd) showByDefault() bool {
+	return true
+}
+
+func (c *storageCmd) usage() string {
+	return i18n.G(
+		`Show the storage of the server.
+
+lxc storage info [remote:]
+    Show the total, used and available space of the storage the containers
+    are on, the usage of the thin pool with LVM and the pool health with ZFS.
+
+The server emits a warning event once the storage usage goes above
+storage.usage_threshold (90% by default) and refuses to create containers
+past it if storage.usage_refuse_create is set.`)
+}
+
+func (c *storageCmd) flags() {}
+
+func (c *storageCmd) run(config *lxd.Config, args []string) error {
+	if len(args) < 1 || args[0] != "info" {
+		return errArgs
+	}
+
+	if len(args) > 2 {
+		return errArgs
+	}
+
+	var remote string
+	if len(args) == 2 {
+		remote = config.ParseRemote(args[1])
+	} else {
+		remote = config.DefaultRemote
+	}
+
+	d, err := lxd.NewClient(config, remote)
+	if err != nil {
+		return err
+	}
+
+	pool, err := d.StorageInfo()
+	if err != nil {
+		return err
+	}
+
+	done, err := outputObject(pool)
+	if done {
+		return err
+	}
+
+	fmt.Printf(i18n.G("Driver: %s %s")+"\n", pool.Driver, pool.DriverVersion)
+	fmt.Printf(i18n.G("Source: %s")+"\n", pool.Source)
+	fmt.Printf(i18n.G("Total: %s")+"\n", shared.GetByteSizeString(int64(pool.Total)))
+	fmt.Printf(i18n.G("Used: %s")+"\n", shared.GetByteSizeString(int64(pool.Used)))
+	fmt.Printf(i18n.G("Available: %s")+"\n", shared.GetByteSizeString(int64(pool.Available)))
+
+	if pool.Health != "" {
+		fmt.Printf(i18n.G("Health: %s")+"\n", pool.Health)
+	}
+
+	if pool.ThinPool != nil {
+		fmt.Printf(i18n.G("Thin pool: %s")+"\n", pool.ThinPool.Name)
+		fmt.Printf("  "+i18n.G("Data usage: %.2f%%")+"\n", pool.ThinPool.DataUsage)
+		fmt.Printf("  "+i18n.G("Metadata usage: %.2f%%")+"\n", pool.ThinPool.MetadataUsage)
+	}
+
+	return nil
+}
@@ -22,6 +22,7 @@ var api10 = []Command{
 	containerSnapshotsCmd,
 	containerSnapshotCmd,
 	containerExecCmd,
+	containerRebuildCmd,
 	aliasCmd,
 	aliasesCmd,
 	eventsCmd,
@@ -35,11 +36,17 @@ var api10 = []Command{
 	operationWebsocket,
 	networksCmd,
 	networkCmd,
+	networkACLsCmd,
+	networkACLCmd,
 	api10Cmd,
 	certificatesCmd,
 	certificateFingerprintCmd,
 	profilesCmd,
 	profileCmd,
+	preseedCmd,
+	resourcesCmd,
+	storageCmd,
+	tunnelCmd,
 }
 
 func api10Get(d *Daemon, r *http.Request) Response {
@@ -106,6 +113,8 @@ func api10Get(d *Daemon, r *http.Request) Response {
 			"architectures":       d.architectures,
 			"driver":              "lxc",
 			"driver_version":      lxc.Version(),
+			"drivers":             containerDriversInfo(d),
+			"driver_features":     lxcFeatures(),
 			"kernel":              kernel,
 			"kernel_architecture": kernelArchitecture,
 			"kernel_version":      kernelVersion,
@@ -145,6 +154,10 @@ func api10Get(d *Daemon, r *http.Request) Response {
 
 type apiPut struct {
 	Config shared.Jmap `json:"config"`
+
+	// PEM encoded replacement for the server certificate and key
+	Certificate string `json:"certificate"`
+	Key         string `json:"key"`
 }
 
 func api10Put(d *Daemon, r *http.Request) Response {
@@ -154,64 +167,215 @@ func api10Put(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	if req.Certificate != "" || req.Key != "" {
+		if req.Certificate == "" || req.Key == "" {
+			return BadRequest(fmt.Errorf("Both the certificate and the key must be provided"))
+		}
+
+		err := d.CertificateReplace([]byte(req.Certificate), []byte(req.Key))
+		if err != nil {
+			return BadRequest(err)
+		}
+	}
+
 	for key, value := range req.Config {
-		if !d.ConfigKeyIsValid(key) {
-			return BadRequest(fmt.Errorf("Bad server config key: '%s'", key))
+		resp := api10ConfigSet(d, key, value.(string))
+		if resp != nil {
+			return resp
 		}
+	}
 
-		if key == "core.trust_password" {
-			err := d.PasswordSet(value.(string))
-			if err != nil {
-				return InternalError(err)
-			}
-		} else if key == "storage.lvm_vg_name" {
-			err := storageLVMSetVolumeGroupNameConfig(d, value.(string))
-			if err != nil {
-				return InternalError(err)
-			}
-			if err = d.SetupStorageDriver(); err != nil {
-				return InternalError(err)
-			}
-		} else if key == "storage.lvm_thinpool_name" {
-			err := storageLVMSetThinPoolNameConfig(d, value.(string))
-			if err != nil {
-				return InternalError(err)
-			}
-		} else if key == "storage.zfs_pool_name" {
-			err := storageZFSSetPoolNameConfig(d, value.(string))
-			if err != nil {
-				return InternalError(err)
-			}
-			if err = d.SetupStorageDriver(); err != nil {
-				return InternalError(err)
-			}
-		} else if key == "core.https_address" {
-			old_address, err := d.ConfigValueGet("core.https_address")
+	return EmptySyncResponse
+}
+
+// api10ConfigSet sets a server config key, applying the change right away,
+// and returns a failure response or nil.
+func api10ConfigSet(d *Daemon, key string, value string) Response {
+	if !d.ConfigKeyIsValid(key) {
+		return BadRequest(fmt.Errorf("Bad server config key: '%s'", key))
+	}
+
+	if key == "core.trust_password" {
+		err := d.PasswordSet(value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "storage.lvm_vg_name" {
+		err := storageLVMSetVolumeGroupNameConfig(d, value)
+		if err != nil {
+			return InternalError(err)
+		}
+		if err = d.SetupStorageDriver(); err != nil {
+			return InternalError(err)
+		}
+	} else if key == "storage.lvm_thinpool_name" {
+		err := storageLVMSetThinPoolNameConfig(d, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "storage.zfs_pool_name" {
+		err := storageZFSSetPoolNameConfig(d, value)
+		if err != nil {
+			return InternalError(err)
+		}
+		if err = d.SetupStorageDriver(); err != nil {
+			return InternalError(err)
+		}
+	} else if key == "core.https_trusted_proxy" {
+		_, err := proxyTrustedParse(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "core.tunnel_addresses" {
+		_, err := tunnelAddressesParse(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "storage.usage_threshold" {
+		err := storageUsageThresholdValidate(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		go storageMonitorCheck(d)
+	} else if key == "images.compression_algorithm" || key == "images.compression_level" {
+		err := imageCompressionConfigValidate(key, value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "images.block_properties" {
+		_, err := imagePolicyRules(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "images.bandwidth_limit" || key == "migration.bandwidth_limit" {
+		_, err := shared.ParseBandwidthLimit(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "network.dns.domain" || key == "network.dns.host" {
+		if key == "network.dns.domain" {
+			err := networkDNSDomainValidate(value)
 			if err != nil {
-				return InternalError(err)
+				return BadRequest(err)
 			}
+		}
+
+		wasHost := shared.IsTrue(networkDNSHostConfig(d))
+		err := d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
 
-			err = d.UpdateHTTPsPort(old_address, value.(string))
+		if wasHost && key == "network.dns.host" && !shared.IsTrue(value) {
+			err = networkDNSHostRevert(d)
 			if err != nil {
 				return InternalError(err)
 			}
+		}
 
-			err = d.ConfigValueSet(key, value.(string))
+		err = networkDNSSetup(d)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "migration.wireguard" || key == "migration.wireguard_address" {
+		if key == "migration.wireguard" && shared.IsTrue(value) {
+			err := migrationWireGuardCheck()
 			if err != nil {
-				return InternalError(err)
+				return BadRequest(err)
 			}
-		} else {
-			err := d.ConfigValueSet(key, value.(string))
+		} else if key == "migration.wireguard_address" {
+			err := migrationWireGuardAddressValidate(value)
 			if err != nil {
-				return InternalError(err)
-			}
-			if key == "images.remote_cache_expiry" {
-				d.pruneChan <- true
+				return BadRequest(err)
 			}
 		}
+
+		err := d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "core.operations_history_expiry" {
+		days, err := strconv.Atoi(value)
+		if value != "" && (err != nil || days < 0) {
+			return BadRequest(fmt.Errorf("Invalid core.operations_history_expiry, must be a number of days: %s", value))
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "containers.names.reserved" {
+		err := containerNameReservedValidate(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else if key == "core.https_address" {
+		_, err := httpsAddressesParse(value)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		old_address, err := d.ConfigValueGet("core.https_address")
+		if err != nil {
+			return InternalError(err)
+		}
+
+		err = d.UpdateHTTPsPort(old_address, value)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		err = d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+	} else {
+		err := d.ConfigValueSet(key, value)
+		if err != nil {
+			return InternalError(err)
+		}
+		if key == "images.remote_cache_expiry" {
+			d.pruneChan <- true
+		}
 	}
 
-	return EmptySyncResponse
+	return nil
 }
 
 var api10Cmd = Command{name: "", untrustedGet: true, get: api10Get, put: api10Put}
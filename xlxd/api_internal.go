@@ -11,6 +11,8 @@ var apiInternal = []Command{
 	internalShutdownCmd,
 	internalContainerOnStartCmd,
 	internalContainerOnStopCmd,
+	internalImportLXCCmd,
+	internalRecoverCmd,
 }
 
 func internalShutdown(d *Daemon, r *http.Request) Response {
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path"
+	"sort"
 	"strings"
 
 	"github.com/krschwab/xlxd/shared"
@@ -31,8 +32,11 @@ const NESTING_AA_PROFILE = `
   mount fstype=sysfs -> /usr/lib/x86_64-linux-gnu/lxc/**,
   mount options=(rw,bind),
   mount options=(rw,rbind),
-  deny /dev/.lxd/proc/** rw,
-  deny /dev/.lxd/sys/** rw,
+
+  # nested LXC mounts the full proc and sysfs under /dev/.lxc to set up its
+  # containers (there's no /dev/.lxd), they mustn't be written through there
+  deny /dev/.lxc/proc/** rw,
+  deny /dev/.lxc/sys/** rw,
   mount options=(rw,make-rshared),
 
   # there doesn't seem to be a way to ask for:
@@ -60,6 +64,35 @@ profile "%s" flags=(attach_disconnected,mediate_deleted) {
     change_profile -> "%s",
 }`
 
+// The profile of the qemu process of a virtual machine, which only gets to
+// use KVM, its taps and the files of the virtual machine.
+const QEMU_AA_PROFILE = `
+#include <tunables/global>
+profile "%s" flags=(attach_disconnected) {
+    #include <abstractions/base>
+
+    capability net_admin,
+    network,
+
+    /dev/kvm rw,
+    /dev/net/tun rw,
+    /dev/vhost-net rw,
+    /dev/ptmx rw,
+    /dev/pts/* rw,
+
+    /{usr/,}bin/qemu-system-* mrix,
+    /usr/share/{qemu,seabios,ipxe}/** r,
+    /usr/lib/** mr,
+    /etc/qemu/** r,
+    /proc/*/{auxv,cmdline,stat,status,task/*/comm} r,
+    /sys/devices/** r,
+    /sys/fs/cgroup/** r,
+
+%s
+    # user input raw.apparmor below here
+    %s
+}`
+
 func AAProfileFull(c container) string {
 	lxddir := shared.VarPath("")
 	if len(c.Name())+len(lxddir)+7 >= 253 {
@@ -75,6 +108,42 @@ func AAProfileShort(c container) string {
 	return fmt.Sprintf("lxd-%s", c.Name())
 }
 
+// AAProfileCustom returns the profile set through security.apparmor.profile,
+// which replaces the generated one and must be loaded by the administrator.
+// It's ignored when LXD can't switch profiles.
+func AAProfileCustom(c container) string {
+	if !aaAvailable || aaConfined {
+		return ""
+	}
+
+	return c.ExpandedConfig()["security.apparmor.profile"]
+}
+
+// Whether a profile is currently loaded in the kernel.
+func aaProfileLoaded(name string) bool {
+	if name == "unconfined" {
+		return true
+	}
+
+	content, err := ioutil.ReadFile("/sys/kernel/security/apparmor/profiles")
+	if err != nil {
+		return false
+	}
+
+	// One "name (mode)" line per profile
+	for _, line := range strings.Split(string(content), "\n") {
+		if i := strings.LastIndex(line, " ("); i >= 0 {
+			line = line[:i]
+		}
+
+		if line == name {
+			return true
+		}
+	}
+
+	return false
+}
+
 // getProfileContent generates the apparmor profile template from the given
 // container. This includes the stock lxc includes as well as stuff from
 // raw.apparmor.
@@ -84,6 +153,10 @@ func getAAProfileContent(c container) string {
 		rawApparmor = ""
 	}
 
+	if c.IsVM() {
+		return fmt.Sprintf(QEMU_AA_PROFILE, AAProfileFull(c), aaQemuPaths(c), rawApparmor)
+	}
+
 	nesting := ""
 	if c.IsNesting() {
 		nesting = NESTING_AA_PROFILE
@@ -92,6 +165,46 @@ func getAAProfileContent(c container) string {
 	return fmt.Sprintf(DEFAULT_AA_PROFILE, AAProfileFull(c), rawApparmor, nesting, AAProfileFull(c))
 }
 
+// aaQemuPaths returns the rules giving qemu the files of the virtual machine
+// and the sources of its disks.
+func aaQemuPaths(c container) string {
+	rules := []string{
+		fmt.Sprintf("    \"%s/\" r,", c.RootfsPath()),
+		fmt.Sprintf("    \"%s/**\" rwlk,", c.RootfsPath()),
+		fmt.Sprintf("    \"%s/\" r,", c.LogPath()),
+		fmt.Sprintf("    \"%s/**\" rwk,", c.LogPath()),
+	}
+
+	names := []string{}
+	devices := c.ExpandedDevices()
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		m := devices[name]
+		if m["type"] != "disk" || m["path"] == "/" || m["source"] == "" {
+			continue
+		}
+
+		access := "rwk"
+		if shared.IsTrue(m["readonly"]) {
+			access = "r"
+		}
+
+		if deviceIsDevice(m["source"]) {
+			rules = append(rules, fmt.Sprintf("    \"%s\" %s,", m["source"], access))
+		} else {
+			rules = append(rules,
+				fmt.Sprintf("    \"%s/\" r,", m["source"]),
+				fmt.Sprintf("    \"%s/**\" %s,", m["source"], access))
+		}
+	}
+
+	return strings.Join(rules, "\n") + "\n"
+}
+
 func runApparmor(command string, c container) error {
 	if !aaAvailable {
 		return nil
@@ -115,6 +228,20 @@ func runApparmor(command string, c container) error {
 // Ensure that the container's policy is loaded into the kernel so the
 // container can boot.
 func AALoadProfile(c container) error {
+	custom := AAProfileCustom(c)
+	if custom == "" && c.ExpandedConfig()["security.apparmor.profile"] != "" {
+		shared.Log.Warn("Ignoring security.apparmor.profile as LXD can't switch AppArmor profiles",
+			log.Ctx{"container": c.Name()})
+	}
+
+	if custom != "" {
+		if !aaProfileLoaded(custom) {
+			return fmt.Errorf("The AppArmor profile '%s' isn't loaded", custom)
+		}
+
+		return nil
+	}
+
 	if !aaAdmin {
 		return nil
 	}
@@ -158,7 +285,7 @@ func AALoadProfile(c container) error {
 // Ensure that the container's policy is unloaded to free kernel memory. This
 // does not delete the policy from disk or cache.
 func AAUnloadProfile(c container) error {
-	if !aaAdmin {
+	if !aaAdmin || AAProfileCustom(c) != "" {
 		return nil
 	}
 
@@ -167,7 +294,8 @@ func AAUnloadProfile(c container) error {
 
 // Parse the profile without loading it into the kernel.
 func AAParseProfile(c container) error {
-	if !aaAvailable {
+	if !aaAvailable || AAProfileCustom(c) != "" {
+		return nil
 	}
 
 	return runApparmor(APPARMOR_CMD_PARSE, c)
@@ -0,0 +1,244 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Default CPU usage (in percent of a single CPU) under which a container is
+// considered idle.
+const autofreezeDefaultThreshold = 1.0
+
+type autofreezeState struct {
+	usage     int64
+	checked   time.Time
+	idleSince time.Time
+	frozen    bool
+
+	// Closed once the freeze in progress, if any, is done
+	freezing chan bool
+}
+
+var autofreezeLock sync.Mutex
+var autofreezeStates = map[string]*autofreezeState{}
+
+// autofreezeTask periodically looks at the CPU usage of the running
+// containers which have limits.autofreeze.idle_timeout set and freezes those
+// which have been idle for long enough.
+func autofreezeTask(d *Daemon) {
+	// Don't bother running when CGroup support isn't there
+	if (!cgCpuacctController && !featureFreezerUnified) || !cgFreezerController {
+		return
+	}
+
+	for {
+		time.Sleep(time.Minute)
+		autofreezeCheck(d)
+	}
+}
+
+// autofreezeConfig returns the idle timeout and threshold of a container, a
+// zero timeout when the policy isn't set.
+func autofreezeConfig(config map[string]string) (time.Duration, float64, error) {
+	timeout := 0
+	if config["limits.autofreeze.idle_timeout"] != "" {
+		var err error
+		timeout, err = strconv.Atoi(config["limits.autofreeze.idle_timeout"])
+		if err != nil || timeout < 0 {
+			return 0, 0, fmt.Errorf("Invalid value for limits.autofreeze.idle_timeout, must be a number of minutes: %s", config["limits.autofreeze.idle_timeout"])
+		}
+	}
+
+	threshold := autofreezeDefaultThreshold
+	if config["limits.autofreeze.idle_threshold"] != "" {
+		var err error
+		threshold, err = strconv.ParseFloat(config["limits.autofreeze.idle_threshold"], 64)
+		if err != nil || threshold < 0 {
+			return 0, 0, fmt.Errorf("Invalid value for limits.autofreeze.idle_threshold, must be a percentage of a CPU: %s", config["limits.autofreeze.idle_threshold"])
+		}
+	}
+
+	return time.Duration(timeout) * time.Minute, threshold, nil
+}
+
+// update records the CPU usage of the container at now and tells whether it
+// has now been idle for timeout.
+func (s *autofreezeState) update(usage int64, now time.Time, timeout time.Duration, threshold float64) bool {
+	if !s.checked.IsZero() {
+		percent := float64(usage-s.usage) / float64(now.Sub(s.checked).Nanoseconds()) * 100
+		if percent >= threshold {
+			s.idleSince = time.Time{}
+		} else if s.idleSince.IsZero() {
+			s.idleSince = s.checked
+		}
+	}
+
+	s.usage = usage
+	s.checked = now
+
+	return !s.idleSince.IsZero() && now.Sub(s.idleSince) >= timeout
+}
+
+// reset gives the container a full idle period before it gets frozen again.
+func (s *autofreezeState) reset() {
+	s.checked = time.Time{}
+	s.idleSince = time.Time{}
+}
+
+func autofreezeCheck(d *Daemon) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		shared.Log.Error("autofreeze: Unable to list containers", log.Ctx{"err": err})
+		return
+	}
+
+	seen := map[string]bool{}
+	idle := []container{}
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			continue
+		}
+
+		timeout, threshold, err := autofreezeConfig(c.ExpandedConfig())
+		if err != nil {
+			shared.Log.Warn("autofreeze: Invalid policy", log.Ctx{"container": name, "err": err})
+			continue
+		}
+
+		if timeout == 0 || !c.IsRunning() {
+			continue
+		}
+
+		seen[name] = true
+		frozen := c.IsFrozen()
+
+		usage := int64(-1)
+		if !frozen {
+			usage, err = autofreezeUsage(c)
+			if err != nil {
+				continue
+			}
+		}
+
+		autofreezeLock.Lock()
+		state, ok := autofreezeStates[name]
+		if !ok {
+			state = &autofreezeState{}
+			autofreezeStates[name] = state
+		}
+
+		if state.freezing != nil {
+			// Still being frozen from the last round
+		} else if frozen {
+			// Time spent frozen doesn't count as idle time
+			state.reset()
+		} else {
+			// Someone else thawed it
+			state.frozen = false
+
+			if state.update(usage, time.Now(), timeout, threshold) {
+				state.freezing = make(chan bool)
+				idle = append(idle, c)
+			}
+		}
+		autofreezeLock.Unlock()
+	}
+
+	// Forget about containers which went away or had the policy removed
+	autofreezeLock.Lock()
+	for name, state := range autofreezeStates {
+		if !seen[name] && state.freezing == nil {
+			delete(autofreezeStates, name)
+		}
+	}
+	autofreezeLock.Unlock()
+
+	// Freezing waits for the processes to stop, without holding up the
+	// thawing of the other containers
+	for _, c := range idle {
+		shared.Log.Info("autofreeze: Freezing idle container", log.Ctx{"container": c.Name()})
+		err := c.Freeze(containerFreezeTimeout)
+		if err != nil {
+			shared.Log.Error("autofreeze: Failed to freeze container", log.Ctx{"container": c.Name(), "err": err})
+		}
+
+		autofreezeLock.Lock()
+		state := autofreezeStates[c.Name()]
+		state.frozen = err == nil
+		state.reset()
+		close(state.freezing)
+		state.freezing = nil
+		autofreezeLock.Unlock()
+	}
+}
+
+// autofreezeUsage returns the CPU time the container used in nanoseconds,
+// from cpuacct or from the usage_usec line cpu.stat starts with on cgroup2.
+func autofreezeUsage(c container) (int64, error) {
+	if !featureFreezerUnified {
+		value, err := c.CGroupGet("cpuacct.usage")
+		if err != nil {
+			return -1, err
+		}
+
+		return strconv.ParseInt(value, 10, 64)
+	}
+
+	value, err := c.CGroupGet("cpu.stat")
+	if err != nil {
+		return -1, err
+	}
+
+	fields := strings.Fields(value)
+	if len(fields) < 2 || fields[0] != "usage_usec" {
+		return -1, fmt.Errorf("Unexpected cpu.stat: %s", value)
+	}
+
+	usage, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return -1, err
+	}
+
+	return usage * 1000, nil
+}
+
+// autofreezeThaw unfreezes a container if it was frozen by the idle policy,
+// this should be called whenever something needs the container to run. A
+// freeze still in progress gets waited for first.
+func autofreezeThaw(c container) error {
+	autofreezeLock.Lock()
+	state, ok := autofreezeStates[c.Name()]
+	for ok && state.freezing != nil {
+		freezing := state.freezing
+		autofreezeLock.Unlock()
+		<-freezing
+		autofreezeLock.Lock()
+	}
+
+	defer autofreezeLock.Unlock()
+
+	if !ok || !state.frozen {
+		return nil
+	}
+
+	// Thawing doesn't wait on the processes, unlike freezing
+	shared.Log.Info("autofreeze: Thawing container", log.Ctx{"container": c.Name()})
+	err := c.Unfreeze()
+	if err != nil {
+		return err
+	}
+
+	// Give it a full idle period before freezing it again
+	state.frozen = false
+	state.reset()
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAutofreezeConfig(t *testing.T) {
+	timeout, threshold, err := autofreezeConfig(map[string]string{})
+	if err != nil || timeout != 0 || threshold != autofreezeDefaultThreshold {
+		t.Errorf("Wrong defaults: %s %f %v", timeout, threshold, err)
+	}
+
+	timeout, threshold, err = autofreezeConfig(map[string]string{
+		"limits.autofreeze.idle_timeout":   "30",
+		"limits.autofreeze.idle_threshold": "2.5",
+	})
+	if err != nil || timeout != 30*time.Minute || threshold != 2.5 {
+		t.Errorf("Wrong policy: %s %f %v", timeout, threshold, err)
+	}
+
+	invalid := []map[string]string{
+		{"limits.autofreeze.idle_timeout": "soon"},
+		{"limits.autofreeze.idle_timeout": "-1"},
+		{"limits.autofreeze.idle_threshold": "low"},
+		{"limits.autofreeze.idle_threshold": "-5"},
+	}
+
+	for _, config := range invalid {
+		_, _, err := autofreezeConfig(config)
+		if err == nil {
+			t.Errorf("Accepted invalid policy %v", config)
+		}
+	}
+}
+
+func TestAutofreezeStateUpdate(t *testing.T) {
+	state := &autofreezeState{}
+	now := time.Now()
+	timeout := 10 * time.Minute
+
+	// The first sample only sets the baseline
+	if state.update(0, now, timeout, 1) {
+		t.Errorf("Idle on the first sample")
+	}
+
+	// 0.5% of a CPU for a minute, idle since the previous sample
+	now = now.Add(time.Minute)
+	if state.update(int64(300*time.Millisecond), now, timeout, 1) {
+		t.Errorf("Idle before the timeout")
+	}
+
+	// Busy again, the idle period starts over
+	now = now.Add(time.Minute)
+	if state.update(int64(30*time.Second), now, timeout, 1) || !state.idleSince.IsZero() {
+		t.Errorf("Still idle after using half a CPU")
+	}
+
+	usage := int64(30 * time.Second)
+	idle := false
+	for i := 0; i < 11; i++ {
+		now = now.Add(time.Minute)
+		idle = state.update(usage, now, timeout, 1)
+	}
+
+	if !idle {
+		t.Errorf("Not idle after the timeout")
+	}
+
+	state.reset()
+	if state.update(usage, now.Add(time.Minute), timeout, 1) {
+		t.Errorf("Idle right after a reset")
+	}
+}
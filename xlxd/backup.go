@@ -0,0 +1,253 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd"
+	"github.com/krschwab/xlxd/shared"
+)
+
+// What a daemon backup holds besides its metadata, the containers and
+// images aren't part of it.
+var backupFiles = []string{"lxd.db", "server.crt", "server.key"}
+
+const backupMetadataName = "backup.yaml"
+
+type backupMetadata struct {
+	Version      string `yaml:"version"`
+	Schema       int    `yaml:"schema"`
+	CreationDate int64  `yaml:"creation_date"`
+}
+
+func backupAddFile(tw *tar.Writer, name string, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr, err := tar.FileInfoHeader(fi, "")
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+
+	err = tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// backupCreate writes the database, the server certificate and key to a
+// tarball. The daemon can keep running, a write transaction keeps it from
+// changing the database while it's being copied.
+func backupCreate(target string) error {
+	dbPath := shared.VarPath("lxd.db")
+	if !shared.PathExists(dbPath) {
+		return fmt.Errorf("No database found in %s", shared.VarPath(""))
+	}
+
+	db, err := sql.Open("sqlite3", fmt.Sprintf("%s?_busy_timeout=%d&_txlock=immediate", dbPath, 5000))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	metadata := backupMetadata{Version: shared.Version, CreationDate: time.Now().UTC().Unix()}
+	err = tx.QueryRow("SELECT max(version) FROM schema").Scan(&metadata.Schema)
+	if err != nil {
+		return err
+	}
+
+	content, err := yaml.Marshal(&metadata)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	err = tw.WriteHeader(&tar.Header{Name: backupMetadataName, Mode: 0644, Size: int64(len(content)), ModTime: time.Now(), Typeflag: tar.TypeReg})
+	if err != nil {
+		return err
+	}
+
+	_, err = tw.Write(content)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range backupFiles {
+		err = backupAddFile(tw, name, shared.VarPath(name))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tw.Close()
+	if err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// backupRestore puts the content of a backup in place, the daemon must be
+// stopped. Backups of older versions are fine, their database is updated on
+// the next start, but newer schemas are refused.
+func backupRestore(source string) error {
+	c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+	if err == nil && c.Finger() == nil {
+		return fmt.Errorf("LXD must be stopped before restoring a backup")
+	}
+
+	f, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(shared.VarPath(""), 0711)
+	if err != nil {
+		return err
+	}
+
+	// Unpack next to the destination so the files can be renamed in place
+	tmp, err := ioutil.TempDir(shared.VarPath(""), "backup_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+
+	var metadata *backupMetadata
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if hdr.Name == backupMetadataName {
+			content, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+
+			metadata = &backupMetadata{}
+			err = yaml.Unmarshal(content, metadata)
+			if err != nil {
+				return err
+			}
+
+			continue
+		}
+
+		if !shared.StringInSlice(hdr.Name, backupFiles) {
+			return fmt.Errorf("Unexpected file in backup: %s", hdr.Name)
+		}
+
+		out, err := os.OpenFile(filepath.Join(tmp, hdr.Name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, os.FileMode(hdr.Mode))
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(out, tr)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if metadata == nil {
+		return fmt.Errorf("%s isn't a LXD backup", source)
+	}
+
+	if metadata.Schema > DB_CURRENT_VERSION {
+		return fmt.Errorf("The backup was made by LXD %s with database schema %d, this LXD only supports up to %d", metadata.Version, metadata.Schema, DB_CURRENT_VERSION)
+	}
+
+	for _, name := range backupFiles {
+		if !shared.PathExists(filepath.Join(tmp, name)) {
+			return fmt.Errorf("The backup is missing %s", name)
+		}
+	}
+
+	// Make sure the database is what the metadata says
+	db, err := sql.Open("sqlite3", filepath.Join(tmp, "lxd.db"))
+	if err != nil {
+		return err
+	}
+
+	schema := dbGetSchema(db)
+	db.Close()
+	if schema != metadata.Schema {
+		return fmt.Errorf("The backup database has schema %d instead of %d", schema, metadata.Schema)
+	}
+
+	for _, name := range backupFiles {
+		err = os.Rename(filepath.Join(tmp, name), shared.VarPath(name))
+		if err != nil {
+			return err
+		}
+	}
+
+	if metadata.Schema < DB_CURRENT_VERSION {
+		fmt.Printf("The database will be updated from schema %d to %d on the next start\n", metadata.Schema, DB_CURRENT_VERSION)
+	}
+
+	return nil
+}
+
+func backup(args []string) error {
+	if len(args) != 3 {
+		return fmt.Errorf("Usage: lxd backup create|restore PATH")
+	}
+
+	switch args[1] {
+	case "create":
+		return backupCreate(args[2])
+	case "restore":
+		return backupRestore(args[2])
+	}
+
+	return fmt.Errorf("Unknown backup command: %s", args[1])
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"github.com/krschwab/xlxd/shared"
+)
+
+// bandwidthLimit returns the bytes per second a transfer is limited to, the
+// lowest of the limit of the server in key and of the one the operation
+// asked for, 0 for no limit.
+func bandwidthLimit(d *Daemon, key string, value string) (int64, error) {
+	limit, err := shared.ParseBandwidthLimit(value)
+	if err != nil {
+		return -1, err
+	}
+
+	config, err := d.ConfigValueGet(key)
+	if err != nil {
+		return -1, err
+	}
+
+	serverLimit, err := shared.ParseBandwidthLimit(config)
+	if err != nil {
+		return -1, err
+	}
+
+	if limit == 0 || (serverLimit > 0 && serverLimit < limit) {
+		return serverLimit, nil
+	}
+
+	return limit, nil
+}
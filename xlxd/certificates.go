@@ -32,6 +32,7 @@ func certificatesGet(d *Daemon, r *http.Request) Response {
 			resp := shared.CertInfo{}
 			resp.Fingerprint = baseCert.Fingerprint
 			resp.Certificate = baseCert.Certificate
+			resp.ReadOnly = baseCert.ReadOnly
 			if baseCert.Type == 1 {
 				resp.Type = "client"
 			} else {
@@ -39,7 +40,13 @@ func certificatesGet(d *Daemon, r *http.Request) Response {
 			}
 			certResponses = append(certResponses, resp)
 		}
-		return SyncResponse(true, certResponses)
+
+		result, err := fieldsFilter(r, certResponses, nil)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		return SyncResponse(true, result)
 	}
 
 	body := []string{}
@@ -56,10 +63,12 @@ type certificatesPostBody struct {
 	Certificate string `json:"certificate"`
 	Name        string `json:"name"`
 	Password    string `json:"password"`
+	ReadOnly    bool   `json:"read_only"`
 }
 
 func readSavedClientCAList(d *Daemon) {
 	d.clientCerts = []x509.Certificate{}
+	d.clientCertsReadOnly = map[string]bool{}
 
 	dbCerts, err := dbCertsGet(d.db)
 	if err != nil {
@@ -75,14 +84,18 @@ func readSavedClientCAList(d *Daemon) {
 			continue
 		}
 		d.clientCerts = append(d.clientCerts, *cert)
+		if dbCert.ReadOnly {
+			d.clientCertsReadOnly[dbCert.Fingerprint] = true
+		}
 	}
 }
 
-func saveCert(d *Daemon, host string, cert *x509.Certificate) error {
+func saveCert(d *Daemon, host string, cert *x509.Certificate, readOnly bool) error {
 	baseCert := new(dbCertInfo)
 	baseCert.Fingerprint = certGenerateFingerprint(cert)
 	baseCert.Type = 1
 	baseCert.Name = host
+	baseCert.ReadOnly = readOnly
 	baseCert.Certificate = string(
 		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}),
 	)
@@ -144,12 +157,18 @@ func certificatesPost(d *Daemon, r *http.Request) Response {
 		return Forbidden
 	}
 
-	err := saveCert(d, name, cert)
+	err := saveCert(d, name, cert, req.ReadOnly)
 	if err != nil {
 		return SmartError(err)
 	}
 
 	d.clientCerts = append(d.clientCerts, *cert)
+	if req.ReadOnly {
+		if d.clientCertsReadOnly == nil {
+			d.clientCertsReadOnly = map[string]bool{}
+		}
+		d.clientCertsReadOnly[fingerprint] = true
+	}
 
 	return EmptySyncResponse
 }
@@ -185,6 +204,7 @@ func doCertificateGet(d *Daemon, fingerprint string) (shared.CertInfo, error) {
 
 	resp.Fingerprint = dbCertInfo.Fingerprint
 	resp.Certificate = dbCertInfo.Certificate
+	resp.ReadOnly = dbCertInfo.ReadOnly
 	if dbCertInfo.Type == 1 {
 		resp.Type = "client"
 	} else {
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// compressThreshold is the size from which responses get compressed, the
+// smaller ones aren't worth it.
+const compressThreshold = 64 * 1024
+
+// compressWriter compresses the responses larger than compressThreshold
+// with the encoding negotiated with the client. The start of the response
+// is held back until it's known to be large enough.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	pending bytes.Buffer
+
+	// Set once the headers went out, passthrough if nothing gets
+	// compressed
+	started     bool
+	passthrough bool
+	encoder     io.WriteCloser
+}
+
+// newCompressWriter returns w wrapped to compress the response to r if the
+// client accepts an encoding we support, w as is otherwise.
+func newCompressWriter(w http.ResponseWriter, r *http.Request) http.ResponseWriter {
+	// Websockets need the connection itself
+	if r.Header.Get("Upgrade") != "" || r.Method == "HEAD" {
+		return w
+	}
+
+	// Images are compressed already
+	if strings.HasPrefix(r.URL.Path, fmt.Sprintf("/%s/images/", shared.APIVersion)) && strings.HasSuffix(r.URL.Path, "/export") {
+		return w
+	}
+
+	encoding := shared.NegotiateContentEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return w
+	}
+
+	return &compressWriter{ResponseWriter: w, encoding: encoding, status: http.StatusOK}
+}
+
+func (w *compressWriter) WriteHeader(status int) {
+	w.status = status
+
+	// Partial, empty or already encoded contents are sent as they are
+	if status != http.StatusOK || w.Header().Get("Content-Encoding") != "" {
+		w.start(false)
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+
+	if w.encoder != nil {
+		return w.encoder.Write(p)
+	}
+
+	w.pending.Write(p)
+	if w.pending.Len() < compressThreshold {
+		return len(p), nil
+	}
+
+	err := w.flushPending(true)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(p), nil
+}
+
+// start sends the headers, with the encoding if compressing.
+func (w *compressWriter) start(compress bool) {
+	if w.started {
+		return
+	}
+	w.started = true
+
+	if !compress {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	// The length, if known, is still passed along for progress tracking
+	length := w.Header().Get("Content-Length")
+	if length != "" {
+		w.Header().Set(shared.ContentEncodingLengthHeader, length)
+	}
+
+	w.Header().Set("Content-Encoding", w.encoding)
+	w.Header().Add("Vary", "Accept-Encoding")
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// flushPending sends what was held back so far, compressed or not.
+func (w *compressWriter) flushPending(compress bool) error {
+	w.start(compress)
+
+	if w.passthrough {
+		_, err := w.ResponseWriter.Write(w.pending.Bytes())
+		w.pending.Reset()
+		return err
+	}
+
+	encoder, err := shared.NewContentEncoder(w.ResponseWriter, w.encoding)
+	if err != nil {
+		return err
+	}
+	w.encoder = encoder
+
+	_, err = w.encoder.Write(w.pending.Bytes())
+	w.pending.Reset()
+	return err
+}
+
+// Close sends the rest of the response, uncompressed if it remained small.
+func (w *compressWriter) Close() error {
+	if w.encoder != nil {
+		return w.encoder.Close()
+	}
+
+	if w.passthrough {
+		return nil
+	}
+
+	return w.flushPending(false)
+}
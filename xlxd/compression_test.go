@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestCompressWriter(t *testing.T) {
+	r, err := http.NewRequest("GET", "/1.0/images", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing to negotiate, nothing to wrap
+	rec := httptest.NewRecorder()
+	if newCompressWriter(rec, r) != http.ResponseWriter(rec) {
+		t.Error("Response got wrapped without Accept-Encoding")
+	}
+
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	// Nor images, which are compressed already
+	export, err := http.NewRequest("GET", "/1.0/images/abcd/export", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	export.Header.Set("Accept-Encoding", "gzip")
+	if newCompressWriter(rec, export) != http.ResponseWriter(rec) {
+		t.Error("Image export got wrapped")
+	}
+
+	// Small answers are sent as they are
+	rec = httptest.NewRecorder()
+	w := newCompressWriter(rec, r).(*compressWriter)
+	w.Write([]byte("small"))
+	w.Close()
+
+	if rec.Header().Get("Content-Encoding") != "" || rec.Body.String() != "small" {
+		t.Errorf("Small answer got encoded: %q", rec.Body.String())
+	}
+
+	// Large ones are compressed
+	content := bytes.Repeat([]byte("x"), compressThreshold*2)
+	rec = httptest.NewRecorder()
+	w = newCompressWriter(rec, r).(*compressWriter)
+	w.Header().Set("Content-Length", "131072")
+	w.Write(content[:10])
+	w.Write(content[10:])
+	w.Close()
+
+	if rec.Header().Get("Content-Encoding") != "gzip" || rec.Header().Get("Content-Length") != "" || rec.Header().Get(shared.ContentEncodingLengthHeader) != "131072" {
+		t.Fatalf("Large answer has wrong headers: %v", rec.Header())
+	}
+
+	gz, err := gzip.NewReader(rec.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decompressed, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(decompressed, content) {
+		t.Error("Large answer got mangled")
+	}
+
+	// Partial contents are left alone
+	rec = httptest.NewRecorder()
+	w = newCompressWriter(rec, r).(*compressWriter)
+	w.WriteHeader(http.StatusPartialContent)
+	w.Write(content)
+	w.Close()
+
+	if rec.Code != http.StatusPartialContent || rec.Header().Get("Content-Encoding") != "" || rec.Body.Len() != len(content) {
+		t.Error("Partial content got encoded")
+	}
+}
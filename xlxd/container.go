@@ -4,9 +4,11 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
+	"github.com/pborman/uuid"
 	"gopkg.in/lxc/go-lxc.v2"
 
 	"github.com/krschwab/xlxd/shared"
@@ -23,20 +25,6 @@ func containerPath(name string, isSnapshot bool) string {
 	return shared.VarPath("containers", name)
 }
 
-func containerValidName(name string) error {
-	if strings.Contains(name, shared.SnapshotDelimiter) {
-		return fmt.Errorf(
-			"The character '%s' is reserved for snapshots.",
-			shared.SnapshotDelimiter)
-	}
-
-	if !shared.ValidHostname(name) {
-		return fmt.Errorf("Container name isn't a valid hostname.")
-	}
-
-	return nil
-}
-
 func containerValidConfigKey(k string) bool {
 	switch k {
 	case "boot.autostart":
@@ -45,12 +33,34 @@ func containerValidConfigKey(k string) bool {
 		return true
 	case "boot.autostart.priority":
 		return true
+	case "boot.restart.max_retries":
+		return true
+	case "boot.restart.policy":
+		return true
+	case "copy.identity_reset":
+		return true
+	case "driver":
+		return true
 	case "limits.cpu":
 		return true
 	case "limits.cpu.allowance":
 		return true
+	case "limits.cpu.nodes":
+		return true
 	case "limits.cpu.priority":
 		return true
+	case "hooks.post-start":
+		return true
+	case "hooks.pre-start":
+		return true
+	case "hooks.pre-stop":
+		return true
+	case "instance.type":
+		return true
+	case "limits.autofreeze.idle_threshold":
+		return true
+	case "limits.autofreeze.idle_timeout":
+		return true
 	case "limits.memory":
 		return true
 	case "limits.memory.enforce":
@@ -59,20 +69,64 @@ func containerValidConfigKey(k string) bool {
 		return true
 	case "limits.memory.swap.priority":
 		return true
+	case "linux.kernel_modules":
+		return true
+	case "security.acls":
+		return true
 	case "security.privileged":
 		return true
+	case "security.protection.delete":
+		return true
 	case "security.nesting":
 		return true
+	case "security.nesting.share_images":
+		return true
+	case "security.apparmor.profile":
+		return true
+	case "security.exec_record":
+		return true
+	case "security.readonly_rootfs":
+		return true
+	case "security.syscalls.blacklist":
+		return true
+	case "security.syscalls.intercept.mknod":
+		return true
+	case "security.syscalls.intercept.setxattr":
+		return true
+	case "security.syscalls.whitelist":
+		return true
+	case "security.readonly_rootfs.tmpfs":
+		return true
+	case "security.time_offset":
+		return true
 	case "raw.apparmor":
 		return true
 	case "raw.lxc":
 		return true
+	case "raw.qemu":
+		return true
+	case "raw.seccomp":
+		return true
 	case "volatile.base_image":
 		return true
 	case "volatile.last_state.idmap":
 		return true
+	case "volatile.last_state.idmapped":
+		return true
 	case "volatile.last_state.power":
 		return true
+	case "volatile.last_state.ready":
+		return true
+	case "volatile.last_state.frozen":
+		return true
+	case "timezone":
+		return true
+	case "vm.cmdline":
+		return true
+	case "vm.initrd":
+		return true
+	case "vm.kernel":
+		return true
 	}
 
 	if strings.HasPrefix(k, "volatile.") {
@@ -162,6 +216,12 @@ func containerValidDeviceConfigKey(t, k string) bool {
 			return true
 		case "script.down":
 			return true
+		case "vlan":
+			return true
+		case "bond.slaves":
+			return true
+		case "bond.mode":
+			return true
 		default:
 			return false
 		}
@@ -190,6 +250,11 @@ func containerValidConfig(config map[string]string, profile bool) error {
 		return nil
 	}
 
+	err := seccompValidConfig(config)
+	if err != nil {
+		return err
+	}
+
 	for k, _ := range config {
 		if profile && strings.HasPrefix(k, "volatile.") {
 			return fmt.Errorf("Volatile keys can only be set on containers.")
@@ -202,9 +267,119 @@ func containerValidConfig(config map[string]string, profile bool) error {
 			}
 		}
 
+		if k == "raw.qemu" {
+			_, err := qemuSplitArgs(config["raw.qemu"])
+			if err != nil {
+				return fmt.Errorf("Invalid raw.qemu: %s", err)
+			}
+		}
+
 		if !containerValidConfigKey(k) {
 			return fmt.Errorf("Bad key: %s", k)
 		}
+
+		if k == "instance.type" {
+			if profile {
+				return fmt.Errorf("The instance type can only be set on containers.")
+			}
+
+			if !shared.StringInSlice(config[k], []string{"container", "vm"}) {
+				return fmt.Errorf("Invalid instance type: %s", config[k])
+			}
+		}
+
+		if k == "driver" {
+			if profile {
+				return fmt.Errorf("The driver can only be set on containers.")
+			}
+
+			_, err := containerDriverFor(config)
+			if err != nil {
+				return err
+			}
+		}
+
+		if k == "boot.restart.policy" && !containerRestartPolicyValid(config[k]) {
+			return fmt.Errorf("Invalid restart policy: %s", config[k])
+		}
+
+		if k == "security.apparmor.profile" && strings.ContainsAny(config[k], "\n\r") {
+			return fmt.Errorf("Invalid AppArmor profile name: %s", config[k])
+		}
+
+		if k == "security.readonly_rootfs.tmpfs" {
+			for _, path := range strings.Split(config[k], ",") {
+				path = strings.TrimSpace(path)
+				if path != "" && (!filepath.IsAbs(path) || path == "/") {
+					return fmt.Errorf("Invalid tmpfs path in %s: %s", k, path)
+				}
+			}
+		}
+
+		if k == "copy.identity_reset" {
+			for _, path := range strings.Split(config[k], ",") {
+				path = strings.TrimSpace(path)
+				if path == "" {
+					continue
+				}
+
+				_, err := filepath.Match(path, "")
+				if err != nil || !filepath.IsAbs(path) || path == "/" {
+					return fmt.Errorf("Invalid path in %s: %s", k, path)
+				}
+			}
+		}
+
+		if k == "limits.cpu.nodes" {
+			nodes, err := parseCPUList(config[k])
+			if err != nil || len(nodes) == 0 {
+				return fmt.Errorf("Invalid NUMA node list: %s", config[k])
+			}
+		}
+
+		if k == "security.acls" {
+			for _, name := range networkACLNames(config[k]) {
+				err := shared.NetworkACLNameValid(name)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if k == "linux.kernel_modules" {
+			_, err := kernelModulesParse(config[k])
+			if err != nil {
+				return err
+			}
+		}
+
+		if k == "timezone" {
+			err := containerTimezoneValid(config[k])
+			if err != nil {
+				return err
+			}
+		}
+
+		if k == "security.time_offset" {
+			_, err := containerTimeOffset(config[k])
+			if err != nil {
+				return err
+			}
+		}
+
+		if k == "limits.autofreeze.idle_timeout" || k == "limits.autofreeze.idle_threshold" {
+			_, _, err := autofreezeConfig(map[string]string{k: config[k]})
+			if err != nil {
+				return err
+			}
+		}
+
+		if k == "boot.restart.max_retries" {
+			_, err := containerRestartMaxRetries(config[k])
+			if err != nil {
+				return err
+			}
+		}
 	}
 
 	return nil
@@ -236,6 +411,11 @@ func containerValidDevices(devices shared.Devices) error {
 			if shared.StringInSlice(m["nictype"], []string{"bridged", "physical", "macvlan"}) && m["parent"] == "" {
 				return fmt.Errorf("Missing parent for %s type nic.", m["nictype"])
 			}
+
+			err := networkNicValidate(m)
+			if err != nil {
+				return err
+			}
 		} else if m["type"] == "disk" {
 			if m["path"] == "" {
 				return fmt.Errorf("Disk entry is missing the required \"path\" property.")
@@ -266,17 +446,20 @@ type containerArgs struct {
 	Architecture int
 	BaseImage    string
 	Config       map[string]string
+	CreationDate time.Time
 	Ctype        containerType
 	Devices      shared.Devices
 	Ephemeral    bool
+	ExpiryDate   time.Time
 	Name         string
 	Profiles     []string
+	UUID         string
 }
 
 // The container interface
 type container interface {
 	// Container actions
-	Freeze() error
+	Freeze(timeout time.Duration) error
 	Shutdown(timeout time.Duration) error
 	Start() error
 	Stop() error
@@ -291,11 +474,13 @@ type container interface {
 	// Config handling
 	Rename(newName string) error
 	Update(newConfig containerArgs, userRequested bool) error
+	UpdatePreview(newConfig containerArgs, userRequested bool) (*shared.ContainerUpdatePreview, error)
 
 	Delete() error
 	Export(w io.Writer) error
 
 	// Live configuration
+	CGroupGet(key string) (string, error)
 	CGroupSet(key string, value string) error
 	ConfigKeySet(key string, value string) error
 
@@ -307,6 +492,12 @@ type container interface {
 	IsEphemeral() bool
 	IsSnapshot() bool
 	IsNesting() bool
+	IsVM() bool
+
+	// Processes and files
+	Exec(command []string, options lxc.AttachOptions, timeout int, cancel chan bool) (int, error)
+	FilePull(path string, target string) (string, os.FileMode, error)
+	FilePush(source string, path string, uid int, gid int, mode os.FileMode, fileType string) error
 
 	// Hooks
 	OnStart() error
@@ -315,9 +506,12 @@ type container interface {
 	// Properties
 	Id() int
 	Name() string
+	UUID() string
 	Architecture() int
+	CreationDate() time.Time
 	ExpandedConfig() map[string]string
 	ExpandedDevices() shared.Devices
+	ExpiryDate() time.Time
 	LocalConfig() map[string]string
 	LocalDevices() shared.Devices
 	Profiles() []string
@@ -339,8 +533,10 @@ type container interface {
 	Storage() storage
 	IdmapSet() *shared.IdmapSet
 	LastIdmapSet() (*shared.IdmapSet, error)
+	DiskIdmapSet() *shared.IdmapSet
 	TemplateApply(trigger string) error
 	Daemon() *Daemon
+	Driver() containerDriver
 }
 
 // Loader functions
@@ -412,6 +608,78 @@ func containerCreateAsCopy(d *Daemon, args containerArgs, sourceContainer contai
 	return c, nil
 }
 
+// containerRefreshFromCopy brings an existing copy of a container up to
+// date, making the snapshots it lacks from the source ones and syncing its
+// rootfs, so that only what changed gets copied. Its own snapshots are kept.
+func containerRefreshFromCopy(d *Daemon, c container, sourceContainer container) error {
+	existing := []string{}
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	for _, snap := range snapshots {
+		existing = append(existing, shared.ExtractSnapshotName(snap.Name()))
+	}
+
+	sourceSnapshots, err := sourceContainer.Snapshots()
+	if err != nil {
+		return err
+	}
+
+	srcIdmap := sourceContainer.DiskIdmapSet()
+	if srcIdmap == nil {
+		srcIdmap = new(shared.IdmapSet)
+	}
+
+	if err := c.StorageStart(); err != nil {
+		return err
+	}
+	defer c.StorageStop()
+
+	sync := func(source container) error {
+		if err := source.StorageStart(); err != nil {
+			return err
+		}
+		defer source.StorageStop()
+
+		output, err := storageRsyncCopy(source.Path(), c.Path())
+		if err != nil {
+			return fmt.Errorf("Failed to sync %s: %s: %s", source.Name(), err, output)
+		}
+
+		return ShiftIfNecessary(c, srcIdmap)
+	}
+
+	for _, snap := range sourceSnapshots {
+		name := shared.ExtractSnapshotName(snap.Name())
+		if shared.StringInSlice(name, existing) {
+			continue
+		}
+
+		if err := sync(snap); err != nil {
+			return err
+		}
+
+		args := containerArgs{
+			Architecture: snap.Architecture(),
+			Config:       snap.LocalConfig(),
+			CreationDate: snap.CreationDate(),
+			Ctype:        cTypeSnapshot,
+			Devices:      snap.LocalDevices(),
+			Ephemeral:    snap.IsEphemeral(),
+			Name:         c.Name() + shared.SnapshotDelimiter + name,
+			Profiles:     snap.Profiles(),
+		}
+
+		if _, err := containerCreateAsSnapshot(d, args, c, false); err != nil {
+			return err
+		}
+	}
+
+	return sync(sourceContainer)
+}
+
 func containerCreateAsSnapshot(d *Daemon, args containerArgs, sourceContainer container, stateful bool) (container, error) {
 	// Create the container
 	c, err := containerCreateInternal(d, args)
@@ -504,6 +772,17 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 		return nil, err
 	}
 
+	// Validate the driver and check it can be used on this host
+	driver, err := containerDriverFor(args.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = driver.Info(d)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate profiles
 	profiles, err := dbProfiles(d.db)
 	if err != nil {
@@ -524,6 +803,14 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 	// Wipe any existing log for this container name
 	os.RemoveAll(shared.LogPath(args.Name))
 
+	if args.CreationDate.IsZero() {
+		args.CreationDate = time.Now().UTC()
+	}
+
+	if args.UUID == "" {
+		args.UUID = uuid.NewRandom().String()
+	}
+
 	// Create the container entry
 	id, err := dbContainerCreate(d.db, args)
 	if err != nil {
@@ -531,7 +818,7 @@ func containerCreateInternal(d *Daemon, args containerArgs) (container, error) {
 	}
 	args.Id = id
 
-	return containerLXCCreate(d, args)
+	return driver.Create(d, args)
 }
 
 func containerLoadById(d *Daemon, id int) (container, error) {
@@ -551,5 +838,10 @@ func containerLoadByName(d *Daemon, name string) (container, error) {
 		return nil, err
 	}
 
-	return containerLXCLoad(d, args)
+	driver, err := containerDriverFor(args.Config)
+	if err != nil {
+		return nil, err
+	}
+
+	return driver.Load(d, args)
 }
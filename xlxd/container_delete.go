@@ -5,6 +5,8 @@ import (
 	"net/http"
 
 	"github.com/gorilla/mux"
+
+	"github.com/krschwab/xlxd/shared"
 )
 
 func containerDelete(d *Daemon, r *http.Request) Response {
@@ -14,6 +16,10 @@ func containerDelete(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
+	if shared.IsTrue(c.ExpandedConfig()["security.protection.delete"]) {
+		return BadRequest(fmt.Errorf("container is protected, unset security.protection.delete to delete it"))
+	}
+
 	if c.IsRunning() {
 		return BadRequest(fmt.Errorf("container is running"))
 	}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// containerDriver is a runtime containers can be run with, each container
+// picks its own with the driver config key.
+type containerDriver interface {
+	// Name is the value of the driver key selecting it
+	Name() string
+
+	// VM tells whether it runs virtual machines rather than containers
+	VM() bool
+
+	// Info checks that the runtime can be used on this host and returns
+	// its version and what it's capable of
+	Info(d *Daemon) (*shared.ServerStateDriver, error)
+
+	Create(d *Daemon, args containerArgs) (container, error)
+	Load(d *Daemon, args containerArgs) (container, error)
+}
+
+// The drivers a container can be run with, the first of each kind is the
+// default one.
+var containerDrivers = []containerDriver{
+	containerLXCDriverInstance,
+	containerQemuDriverInstance,
+}
+
+// containerDriverGet returns the driver with the name.
+func containerDriverGet(name string) (containerDriver, error) {
+	for _, driver := range containerDrivers {
+		if driver.Name() == name {
+			return driver, nil
+		}
+	}
+
+	return nil, fmt.Errorf("Unknown driver: %s", name)
+}
+
+// containerDriverFor returns the driver a container config asks for, the
+// default one for its instance type when it doesn't set driver.
+func containerDriverFor(config map[string]string) (containerDriver, error) {
+	vm := containerIsVM(config)
+
+	name := config["driver"]
+	if name == "" {
+		for _, driver := range containerDrivers {
+			if driver.VM() == vm {
+				return driver, nil
+			}
+		}
+	}
+
+	driver, err := containerDriverGet(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if driver.VM() != vm {
+		if vm {
+			return nil, fmt.Errorf("The %s driver doesn't run virtual machines", name)
+		}
+
+		return nil, fmt.Errorf("The %s driver only runs virtual machines", name)
+	}
+
+	return driver, nil
+}
+
+// containerDriversInfo returns the drivers which can be used on this host,
+// for the environment of the server.
+func containerDriversInfo(d *Daemon) map[string]shared.ServerStateDriver {
+	drivers := map[string]shared.ServerStateDriver{}
+	for _, driver := range containerDrivers {
+		info, err := driver.Info(d)
+		if err != nil {
+			continue
+		}
+
+		drivers[driver.Name()] = *info
+	}
+
+	return drivers
+}
+
+// containerCapabilityError is a request which the driver of the container
+// can't do, at all or for lack of a feature of the host.
+type containerCapabilityError struct {
+	Driver     string `json:"driver"`
+	Capability string `json:"capability"`
+	Feature    string `json:"feature,omitempty"`
+}
+
+func (e *containerCapabilityError) Error() string {
+	if e.Feature != "" {
+		return fmt.Sprintf("The %s driver can't do %s on this host, it lacks %s support", e.Driver, e.Capability, e.Feature)
+	}
+
+	return fmt.Sprintf("The %s driver doesn't support %s", e.Driver, e.Capability)
+}
+
+// containerCapabilityCheck fails if the driver of the container lacks the
+// capability.
+func containerCapabilityCheck(c container, capability string) error {
+	driver := c.Driver()
+	info, err := driver.Info(c.Daemon())
+	if err != nil {
+		return err
+	}
+
+	if !shared.StringInSlice(capability, info.Capabilities) {
+		return &containerCapabilityError{driver.Name(), capability, info.Missing[capability]}
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestContainerDriverFor(t *testing.T) {
+	tests := []struct {
+		config map[string]string
+		driver string
+	}{
+		{map[string]string{}, "lxc"},
+		{map[string]string{"instance.type": "container"}, "lxc"},
+		{map[string]string{"instance.type": "vm"}, "qemu"},
+		{map[string]string{"driver": "lxc"}, "lxc"},
+		{map[string]string{"instance.type": "vm", "driver": "qemu"}, "qemu"},
+	}
+
+	for _, test := range tests {
+		driver, err := containerDriverFor(test.config)
+		if err != nil {
+			t.Errorf("No driver for %v: %s", test.config, err)
+			continue
+		}
+
+		if driver.Name() != test.driver {
+			t.Errorf("Wrong driver for %v: %s", test.config, driver.Name())
+		}
+	}
+
+	// Drivers only run their kind of instances
+	for _, config := range []map[string]string{
+		{"driver": "qemu"},
+		{"instance.type": "vm", "driver": "lxc"},
+		{"driver": "bogus"},
+	} {
+		_, err := containerDriverFor(config)
+		if err == nil {
+			t.Errorf("Got a driver for %v", config)
+		}
+	}
+}
@@ -3,18 +3,23 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/gorilla/websocket"
 	"gopkg.in/lxc/go-lxc.v2"
 
 	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 type commandPostContent struct {
@@ -22,21 +27,189 @@ type commandPostContent struct {
 	WaitForWS   bool              `json:"wait-for-websocket"`
 	Interactive bool              `json:"interactive"`
 	Environment map[string]string `json:"environment"`
+	User        string            `json:"user"`
+	Group       string            `json:"group"`
+	Cwd         string            `json:"cwd"`
+	Timeout     int               `json:"timeout"`
+}
+
+// containerExecOpen opens a file of the container's rootfs one component at
+// a time, following no symlink since they'd be resolved on the host and could
+// lead out of the container.
+func containerExecOpen(c container, names ...string) (*os.File, error) {
+	fd, err := syscall.Open(c.RootfsPath(), syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, name := range names {
+		// Don't block on FIFOs either
+		flags := syscall.O_RDONLY | syscall.O_NOFOLLOW | syscall.O_CLOEXEC | syscall.O_NONBLOCK
+		if i < len(names)-1 {
+			flags |= syscall.O_DIRECTORY
+		}
+
+		next, err := syscall.Openat(fd, name, flags, 0)
+		syscall.Close(fd)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to open /%s: %s", strings.Join(names, "/"), err)
+		}
+		fd = next
+	}
+
+	return os.NewFile(uintptr(fd), "/"+strings.Join(names, "/")), nil
 }
 
-func runCommand(container *lxc.Container, command []string, options lxc.AttachOptions) (int, error) {
-	status, err := container.RunCommandStatus(command, options)
+// containerExecLookup finds an entry by name or numeric id in one of the
+// container's passwd style files (/etc/passwd or /etc/group).
+func containerExecLookup(c container, file string, key string) ([]string, error) {
+	f, err := containerExecOpen(c, "etc", file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	if !fi.Mode().IsRegular() {
+		return nil, fmt.Errorf("/etc/%s isn't a regular file", file)
+	}
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Split(line, ":")
+		if len(fields) < 4 {
+			continue
+		}
+
+		if fields[0] == key || fields[2] == key {
+			return fields, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No entry for '%s' in /etc/%s", key, file)
+}
+
+// containerExecCredentials resolves the user and group an exec request should
+// run as, returning the uid, gid and the user's passwd entry (if any).
+func containerExecCredentials(c container, user string, group string) (int, int, []string, error) {
+	uid := 0
+	gid := 0
+	var passwd []string
+
+	if user != "" {
+		fields, err := containerExecLookup(c, "passwd", user)
+		id, convErr := strconv.Atoi(user)
+		if err != nil && convErr != nil {
+			return -1, -1, nil, err
+		}
+
+		if err == nil {
+			uid, _ = strconv.Atoi(fields[2])
+			gid, _ = strconv.Atoi(fields[3])
+			passwd = fields
+		} else {
+			// Numeric ids don't need to exist in the container
+			uid = id
+			gid = id
+		}
+	}
+
+	if group != "" {
+		fields, err := containerExecLookup(c, "group", group)
+		id, convErr := strconv.Atoi(group)
+		if err != nil && convErr != nil {
+			return -1, -1, nil, err
+		}
+
+		if err == nil {
+			gid, _ = strconv.Atoi(fields[2])
+		} else {
+			gid = id
+		}
+	}
+
+	if uid < 0 || gid < 0 {
+		return -1, -1, nil, fmt.Errorf("Invalid uid/gid: %d/%d", uid, gid)
+	}
+
+	return uid, gid, passwd, nil
+}
+
+// runCommand runs a command in the container and returns its raw wait status.
+// The command gets killed if it's still running after timeout seconds (if
+// positive) or when something is received on cancel.
+func runCommand(container *lxc.Container, command []string, options lxc.AttachOptions, timeout int, cancel chan bool) (int, error) {
+	pid, err := container.RunCommandNoWait(command, options)
 	if err != nil {
 		shared.Debugf("Failed running command: %q", err.Error())
 		return 0, err
 	}
 
-	return status, nil
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return 0, err
+	}
+
+	done := make(chan bool)
+	timedOut := make(chan bool, 1)
+	go func() {
+		var timer <-chan time.Time
+		if timeout > 0 {
+			timer = time.After(time.Duration(timeout) * time.Second)
+		}
+
+		select {
+		case <-done:
+			timedOut <- false
+			return
+		case <-timer:
+			shared.Debugf("Killing command %q after %d seconds", command, timeout)
+			timedOut <- true
+		case <-cancel:
+			timedOut <- false
+			shared.Debugf("Killing command %q, the client went away", command)
+		}
+
+		// Take whatever it spawned down with it
+		syscall.Kill(-pid, syscall.SIGKILL)
+		proc.Kill()
+	}()
+
+	state, err := proc.Wait()
+	close(done)
+	if err != nil {
+		return 0, err
+	}
+
+	if <-timedOut {
+		return -1, fmt.Errorf("Command timed out after %d seconds", timeout)
+	}
+
+	return int(state.Sys().(syscall.WaitStatus)), nil
+}
+
+// execExitCode turns the raw wait status of a command into the exit code a
+// shell would report, 128+N if it got killed by signal N.
+func execExitCode(status int) int {
+	ws := syscall.WaitStatus(status)
+	if ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+
+	return ws.ExitStatus()
 }
 
 type execWs struct {
 	command          []string
-	container        *lxc.Container
+	container        container
 	rootUid          int
 	rootGid          int
 	options          lxc.AttachOptions
@@ -45,6 +218,8 @@ type execWs struct {
 	allConnected     chan bool
 	controlConnected chan bool
 	interactive      bool
+	record           bool
+	timeout          int
 	fds              map[int]string
 }
 
@@ -126,9 +301,27 @@ func (s *execWs) Do(op *operation) error {
 		s.options.StderrFd = ttys[2].Fd()
 	}
 
+	var recorder *execRecorder
+	if s.interactive && s.record {
+		recorder, err = execRecorderCreate(s.container.Name(), s.command, s.options.Env)
+		if err != nil {
+			return err
+		}
+		defer recorder.Close()
+
+		shared.Log.Info("Recording exec session", log.Ctx{"container": s.container.Name(), "path": recorder.path})
+	}
+
 	controlExit := make(chan bool)
 	var wgEOF sync.WaitGroup
 
+	// Closed when the client disconnects
+	clientGone := make(chan bool)
+	var clientGoneOnce sync.Once
+	markClientGone := func() {
+		clientGoneOnce.Do(func() { close(clientGone) })
+	}
+
 	if s.interactive {
 		wgEOF.Add(1)
 		go func() {
@@ -182,6 +375,10 @@ func (s *execWs) Do(op *operation) error {
 						shared.Debugf("Failed to set window size to: %dx%d", winchWidth, winchHeight)
 						continue
 					}
+
+					if recorder != nil {
+						recorder.Resize(winchWidth, winchHeight)
+					}
 				}
 
 				if err != nil {
@@ -189,15 +386,34 @@ func (s *execWs) Do(op *operation) error {
 					break
 				}
 			}
+
+			markClientGone()
 		}()
 		go func() {
-			readDone, writeDone := shared.WebsocketMirror(s.conns[0], ptys[0], ptys[0])
+			var output io.ReadCloser = ptys[0]
+			if recorder != nil {
+				output = &execRecordReader{ReadCloser: ptys[0], recorder: recorder}
+			}
+
+			readDone, writeDone := shared.WebsocketMirror(s.conns[0], ptys[0], output)
 			<-readDone
 			<-writeDone
 			s.conns[0].Close()
 			wgEOF.Done()
 		}()
 	} else {
+		// The client never sends anything on stdout, so a failed read
+		// means it's gone.
+		go func() {
+			for {
+				_, _, err := s.conns[1].NextReader()
+				if err != nil {
+					markClientGone()
+					return
+				}
+			}
+		}()
+
 		wgEOF.Add(len(ttys) - 1)
 		for i := 0; i < len(ttys); i++ {
 			go func(i int) {
@@ -213,10 +429,11 @@ func (s *execWs) Do(op *operation) error {
 		}
 	}
 
-	cmdResult, cmdErr := runCommand(
-		s.container,
+	cmdResult, cmdErr := s.container.Exec(
 		s.command,
 		s.options,
+		s.timeout,
+		clientGone,
 	)
 
 	for _, tty := range ttys {
@@ -237,7 +454,11 @@ func (s *execWs) Do(op *operation) error {
 		pty.Close()
 	}
 
+	// The raw wait status as always, along with the exit code of the command
 	metadata := shared.Jmap{"return": cmdResult}
+	if cmdErr == nil {
+		metadata["exit_code"] = execExitCode(cmdResult)
+	}
 	err = op.UpdateMetadata(metadata)
 	if err != nil {
 		return err
@@ -257,6 +478,11 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("Container is not running."))
 	}
 
+	// Containers frozen for being idle get woken up
+	if err := autofreezeThaw(c); err != nil {
+		return InternalError(err)
+	}
+
 	if c.IsFrozen() {
 		return BadRequest(fmt.Errorf("Container is frozen."))
 	}
@@ -271,13 +497,39 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	capability := "exec"
+	if post.Interactive {
+		capability = "exec.interactive"
+	}
+
+	err = containerCapabilityCheck(c, capability)
+	if err != nil {
+		return SmartError(err)
+	}
+
 	opts := lxc.DefaultAttachOptions
 	opts.ClearEnv = true
-	opts.Env = []string{}
+	opts.Env = containerEnvironment(c.ExpandedConfig())
 
-	for k, v := range c.ExpandedConfig() {
-		if strings.HasPrefix(k, "environment.") {
-			opts.Env = append(opts.Env, fmt.Sprintf("%s=%s", strings.TrimPrefix(k, "environment."), v))
+	uid, gid, passwd, err := containerExecCredentials(c, post.User, post.Group)
+	if err != nil {
+		return BadRequest(err)
+	}
+	opts.UID = uid
+	opts.GID = gid
+
+	// Default HOME and USER for the requested user
+	if passwd != nil {
+		if post.Environment == nil {
+			post.Environment = map[string]string{}
+		}
+
+		if _, ok := post.Environment["HOME"]; !ok && len(passwd) > 5 {
+			post.Environment["HOME"] = passwd[5]
+		}
+
+		if _, ok := post.Environment["USER"]; !ok {
+			post.Environment["USER"] = passwd[0]
 		}
 	}
 
@@ -290,12 +542,18 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 		}
 	}
 
+	if post.Cwd != "" {
+		opts.Cwd = post.Cwd
+	}
+
 	if post.WaitForWS {
 		ws := &execWs{}
 		ws.fds = map[int]string{}
 		idmapset := c.IdmapSet()
 		if idmapset != nil {
-			ws.rootUid, ws.rootGid = idmapset.ShiftIntoNs(0, 0)
+			ws.rootUid, ws.rootGid = idmapset.ShiftIntoNs(uid, gid)
+		} else {
+			ws.rootUid, ws.rootGid = uid, gid
 		}
 		ws.conns = map[int]*websocket.Conn{}
 		ws.conns[-1] = nil
@@ -307,6 +565,8 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 		ws.allConnected = make(chan bool, 1)
 		ws.controlConnected = make(chan bool, 1)
 		ws.interactive = post.Interactive
+		ws.timeout = post.Timeout
+		ws.record = shared.IsTrue(c.ExpandedConfig()["security.exec_record"])
 		ws.options = opts
 		for i := -1; i < len(ws.conns)-1; i++ {
 			ws.fds[i], err = shared.RandomCryptoString()
@@ -316,10 +576,10 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 		}
 
 		ws.command = post.Command
-		ws.container = c.LXContainerGet()
+		ws.container = c
 
 		resources := map[string][]string{}
-		resources["containers"] = []string{ws.container.Name()}
+		resources["containers"] = []string{c.Name()}
 
 		op, err := operationCreate(operationClassWebsocket, resources, ws.Metadata(), ws.Do, nil, ws.Connect)
 		if err != nil {
@@ -341,7 +601,7 @@ func containerExecPost(d *Daemon, r *http.Request) Response {
 		opts.StdoutFd = nullfd
 		opts.StderrFd = nullfd
 
-		_, cmdErr := runCommand(c.LXContainerGet(), post.Command, opts)
+		_, cmdErr := c.Exec(post.Command, opts, post.Timeout, nil)
 		return cmdErr
 	}
 
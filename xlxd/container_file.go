@@ -1,15 +1,14 @@
 package main
 
 import (
+	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strconv"
-	"strings"
 	"syscall"
 
 	"github.com/gorilla/mux"
@@ -33,45 +32,39 @@ func containerFileHandler(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("container is not running"))
 	}
 
-	initPid := c.InitPID()
+	err = containerCapabilityCheck(c, "file")
+	if err != nil {
+		return SmartError(err)
+	}
+
+	// Containers frozen for being idle get woken up
+	err = autofreezeThaw(c)
+	if err != nil {
+		return InternalError(err)
+	}
 
 	switch r.Method {
 	case "GET":
-		return containerFileGet(d, initPid, r, targetPath)
+		return containerFileGet(c, r, targetPath)
 	case "POST":
-		idmapset, err := c.LastIdmapSet()
-		if err != nil {
-			return InternalError(err)
-		}
-		return containerFilePut(d, initPid, r, targetPath, idmapset)
+		// The container sees its files with its map, whether they're
+		// shifted on disk or through an idmapped mount
+		return containerFilePut(c, r, targetPath, c.IdmapSet())
 	default:
 		return NotFound
 	}
 }
 
-func containerFileGet(d *Daemon, pid int, r *http.Request, path string) Response {
-	/*
-	 * Copy out of the ns to a temporary file, and then use that to serve
-	 * the request from. This prevents us from having to worry about stuff
-	 * like people breaking out of the container root by symlinks or
-	 * ../../../s etc. in the path, since we can just rely on the kernel
-	 * for correctness.
-	 */
+func containerFileGet(c container, r *http.Request, path string) Response {
 	temp, err := ioutil.TempFile("", "lxd_forkgetfile_")
 	if err != nil {
 		return InternalError(err)
 	}
 	defer temp.Close()
 
-	cmd := exec.Command(
-		d.execPath,
-		"forkgetfile",
-		temp.Name(),
-		fmt.Sprintf("%d", pid),
-		path,
-	)
-	if out, err := cmd.CombinedOutput(); err != nil {
-		return InternalError(fmt.Errorf(strings.TrimRight(string(out), "\n")))
+	fileType, mode, err := c.FilePull(path, temp.Name())
+	if err != nil {
+		return InternalError(err)
 	}
 
 	fi, err := temp.Stat()
@@ -79,6 +72,13 @@ func containerFileGet(d *Daemon, pid int, r *http.Request, path string) Response
 		return SmartError(err)
 	}
 
+	// Let the client check that nothing got lost on the way
+	hash := sha256.New()
+	_, err = io.Copy(hash, temp)
+	if err != nil {
+		return InternalError(err)
+	}
+
 	/*
 	 * Unfortunately, there's no portable way to do this:
 	 * https://groups.google.com/forum/#!topic/golang-nuts/tGYjYyrwsGM
@@ -86,9 +86,11 @@ func containerFileGet(d *Daemon, pid int, r *http.Request, path string) Response
 	 */
 	sb := fi.Sys().(*syscall.Stat_t)
 	headers := map[string]string{
-		"X-LXD-uid":  strconv.FormatUint(uint64(sb.Uid), 10),
-		"X-LXD-gid":  strconv.FormatUint(uint64(sb.Gid), 10),
-		"X-LXD-mode": fmt.Sprintf("%04o", fi.Mode()&os.ModePerm),
+		"X-LXD-uid":    strconv.FormatUint(uint64(sb.Uid), 10),
+		"X-LXD-gid":    strconv.FormatUint(uint64(sb.Gid), 10),
+		"X-LXD-mode":   fmt.Sprintf("%04o", mode&os.ModePerm),
+		"X-LXD-type":   fileType,
+		"X-LXD-sha256": fmt.Sprintf("%x", hash.Sum(nil)),
 	}
 
 	files := make([]fileResponseEntry, 1)
@@ -99,8 +101,12 @@ func containerFileGet(d *Daemon, pid int, r *http.Request, path string) Response
 	return FileResponse(r, files, headers, true)
 }
 
-func containerFilePut(d *Daemon, pid int, r *http.Request, p string, idmapset *shared.IdmapSet) Response {
-	uid, gid, mode := shared.ParseLXDFileHeaders(r.Header)
+func containerFilePut(c container, r *http.Request, p string, idmapset *shared.IdmapSet) Response {
+	uid, gid, mode, fileType := shared.ParseLXDFileHeaders(r.Header)
+
+	if !shared.StringInSlice(fileType, []string{"file", "symlink", "char", "block"}) {
+		return BadRequest(fmt.Errorf("Unknown file type: %s", fileType))
+	}
 
 	if idmapset != nil {
 		uid, gid = idmapset.ShiftIntoNs(uid, gid)
@@ -115,24 +121,23 @@ func containerFilePut(d *Daemon, pid int, r *http.Request, p string, idmapset *s
 		os.Remove(temp.Name())
 	}()
 
-	_, err = io.Copy(temp, r.Body)
+	hash := sha256.New()
+	_, err = shared.WriteSparse(temp, io.TeeReader(r.Body, hash))
 	if err != nil {
 		return InternalError(err)
 	}
 
-	cmd := exec.Command(
-		d.execPath,
-		"forkputfile",
-		temp.Name(),
-		fmt.Sprintf("%d", pid),
-		p,
-		fmt.Sprintf("%d", uid),
-		fmt.Sprintf("%d", gid),
-		fmt.Sprintf("%d", mode&os.ModePerm),
-	)
-	out, err := cmd.CombinedOutput()
+	// Don't write anything in the container if the content got corrupted
+	// on the way, the client can send it again
+	expected := r.Header.Get("X-LXD-sha256")
+	sum := fmt.Sprintf("%x", hash.Sum(nil))
+	if expected != "" && expected != sum {
+		return UnprocessableEntity(fmt.Errorf("checksum mismatch, got %s expected %s", sum, expected))
+	}
+
+	err = c.FilePush(temp.Name(), p, uid, gid, mode&os.ModePerm, fileType)
 	if err != nil {
-		return InternalError(fmt.Errorf(strings.TrimRight(string(out), "\n")))
+		return InternalError(err)
 	}
 
 	return EmptySyncResponse
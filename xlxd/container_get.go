@@ -18,5 +18,5 @@ func containerGet(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
-	return SyncResponse(true, state)
+	return SyncResponseETag(true, state, containerETag(c))
 }
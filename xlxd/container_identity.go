@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// containerIdentityDefaultReset lists what identifies a machine on the
+// network in the usual distributions, copy.identity_reset replaces it.
+var containerIdentityDefaultReset = []string{
+	"/etc/machine-id",
+	"/var/lib/dbus/machine-id",
+	"/etc/ssh/ssh_host_*",
+	"/var/lib/dhcp/*.leases",
+	"/var/lib/dhclient/*.lease*",
+	"/var/lib/NetworkManager/*.lease",
+}
+
+// containerIdentityPaths returns the paths (glob patterns) to reset in the
+// rootfs of a copy of the container.
+func containerIdentityPaths(c container) []string {
+	value := c.ExpandedConfig()["copy.identity_reset"]
+	if value == "" {
+		return containerIdentityDefaultReset
+	}
+
+	paths := []string{}
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			paths = append(paths, path)
+		}
+	}
+
+	return paths
+}
+
+// containerIdentityInRootfs returns whether path really is in the rootfs,
+// its parents being able to be symlinks to anywhere on the host.
+func containerIdentityInRootfs(rootfs string, path string) bool {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(path))
+	if err != nil {
+		return false
+	}
+
+	return dir == rootfs || strings.HasPrefix(dir, rootfs+"/")
+}
+
+// containerIdentityReset makes a copied container a machine of its own: the
+// machine-id files get emptied so that a new one is generated on boot, the
+// SSH host keys get regenerated and the rest of the paths get deleted.
+func containerIdentityReset(c container) error {
+	rootfs, err := filepath.EvalSymlinks(c.RootfsPath())
+	if err != nil {
+		return err
+	}
+
+	sshKeys := false
+	for _, pattern := range containerIdentityPaths(c) {
+		matches, err := filepath.Glob(filepath.Join(rootfs, pattern))
+		if err != nil {
+			return fmt.Errorf("Invalid path in copy.identity_reset: %s", pattern)
+		}
+
+		for _, path := range matches {
+			if !containerIdentityInRootfs(rootfs, path) {
+				shared.Log.Warn("Not resetting a path out of the container", log.Ctx{"container": c.Name(), "path": path})
+				continue
+			}
+
+			// Symlinks are left alone, dbus' machine-id often is one
+			fi, err := os.Lstat(path)
+			if err != nil || !fi.Mode().IsRegular() {
+				continue
+			}
+
+			if filepath.Base(path) == "machine-id" {
+				err = os.Truncate(path, 0)
+			} else {
+				err = os.Remove(path)
+				if strings.HasPrefix(filepath.Base(path), "ssh_host_") {
+					sshKeys = true
+				}
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if !sshKeys {
+		return nil
+	}
+
+	// Not all the distributions generate the missing ones on boot
+	_, err = exec.LookPath("ssh-keygen")
+	if err != nil {
+		shared.Log.Warn("No ssh-keygen to regenerate the SSH host keys", log.Ctx{"container": c.Name()})
+		return nil
+	}
+
+	// The keys go nowhere but in the real etc/ssh of the container
+	sshDir := filepath.Join(rootfs, "etc", "ssh")
+	dir, err := filepath.EvalSymlinks(sshDir)
+	if err != nil || dir != sshDir {
+		shared.Log.Warn("Not regenerating the SSH host keys out of the container", log.Ctx{"container": c.Name(), "path": sshDir})
+		return nil
+	}
+
+	// ssh-keygen follows the symlinks of the rootfs, the keys are generated
+	// in a directory of the daemon and then copied over
+	tmpDir, err := ioutil.TempDir(shared.VarPath("containers"), ".identity_")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	err = os.MkdirAll(filepath.Join(tmpDir, "etc", "ssh"), 0700)
+	if err != nil {
+		return err
+	}
+
+	output, err := exec.Command("ssh-keygen", "-A", "-f", tmpDir).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to regenerate the SSH host keys: %s", output)
+	}
+
+	uid, gid := 0, 0
+	idmapset := c.DiskIdmapSet()
+	if idmapset != nil {
+		uid, gid = idmapset.ShiftIntoNs(0, 0)
+	}
+
+	keys, err := filepath.Glob(filepath.Join(tmpDir, "etc", "ssh", "ssh_host_*"))
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		err := containerIdentityCopyKey(key, filepath.Join(sshDir, filepath.Base(key)), uid, gid)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// containerIdentityCopyKey copies a generated key into the container,
+// replacing whatever is left at target without following it.
+func containerIdentityCopyKey(key string, target string, uid int, gid int) error {
+	fi, err := os.Stat(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(target)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	src, err := os.Open(key)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, fi.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer dst.Close()
+
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		return err
+	}
+
+	return dst.Chown(uid, gid)
+}
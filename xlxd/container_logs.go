@@ -54,6 +54,9 @@ func validLogFileName(fname string) bool {
 	 */
 	return fname == "lxc.log" ||
 		fname == "lxc.conf" ||
+		fname == "console.log" ||
+		fname == "console.log.old" ||
+		strings.HasPrefix(fname, "exec_") ||
 		strings.HasPrefix(fname, "migration_") ||
 		strings.HasPrefix(fname, "snapshot_")
 }
@@ -90,6 +93,11 @@ func containerLogDelete(d *Daemon, r *http.Request) Response {
 		return BadRequest(fmt.Errorf("log file name %s not valid", file))
 	}
 
+	// Exec recordings are an audit trail, they go away with the container
+	if strings.HasPrefix(file, "exec_") {
+		return BadRequest(fmt.Errorf("Exec recordings can't be deleted"))
+	}
+
 	return SmartError(os.Remove(shared.LogPath(name, file)))
 }
 
@@ -98,3 +106,45 @@ var containerLogCmd = Command{
 	get:    containerLogGet,
 	delete: containerLogDelete,
 }
+
+// logRotate moves a log written by the container away before it starts,
+// keeping the one of the previous start as <name>.old. The console output
+// would otherwise keep growing with every start.
+func logRotate(path string) error {
+	err := os.Rename(path, path+".old")
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+// How many log lines are kept in the metadata of a failed start.
+const startFailureLogLines = 100
+
+// logTail returns at most the last count lines of a log file, starting from
+// offset.
+func logTail(path string, offset int64, count int) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	_, err = f.Seek(offset, 0)
+	if err != nil {
+		return "", err
+	}
+
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+	if len(lines) > count {
+		lines = lines[len(lines)-count:]
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
@@ -12,8 +12,10 @@ import (
 	"path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -71,6 +73,70 @@ func lxcValidConfig(rawLxc string) error {
 	return nil
 }
 
+// The capabilities of the LXC driver
+var containerLXCCapabilities = []string{
+	"checkpoint",
+	"devices.disk",
+	"devices.nic",
+	"devices.unix-block",
+	"devices.unix-char",
+	"exec",
+	"exec.interactive",
+	"file",
+	"freeze",
+	"network.acls",
+	"syscalls.intercept",
+}
+
+// The features of the host which some capabilities of the LXC driver need
+var containerLXCCapabilityFeatures = map[string]string{
+	"checkpoint":         "criu",
+	"freeze":             "freezer",
+	"syscalls.intercept": "seccomp_notify",
+}
+
+// containerLXCDriver runs the containers with liblxc.
+type containerLXCDriver struct{}
+
+var containerLXCDriverInstance = &containerLXCDriver{}
+
+func (driver *containerLXCDriver) Name() string {
+	return "lxc"
+}
+
+func (driver *containerLXCDriver) VM() bool {
+	return false
+}
+
+func (driver *containerLXCDriver) Info(d *Daemon) (*shared.ServerStateDriver, error) {
+	info := &shared.ServerStateDriver{
+		Version:      lxc.Version(),
+		Capabilities: []string{},
+		Missing:      map[string]string{},
+	}
+
+	features := lxcFeatures()
+	for _, capability := range containerLXCCapabilities {
+		feature := containerLXCCapabilityFeatures[capability]
+		if feature != "" && !features[feature] {
+			info.Missing[capability] = feature
+			continue
+		}
+
+		info.Capabilities = append(info.Capabilities, capability)
+	}
+
+	return info, nil
+}
+
+func (driver *containerLXCDriver) Create(d *Daemon, args containerArgs) (container, error) {
+	return containerLXCCreate(d, args)
+}
+
+func (driver *containerLXCDriver) Load(d *Daemon, args containerArgs) (container, error) {
+	return containerLXCLoad(d, args)
+}
+
 // Loader functions
 func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 	// Create the container struct
@@ -81,9 +147,12 @@ func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 		ephemeral:    args.Ephemeral,
 		architecture: args.Architecture,
 		cType:        args.Ctype,
+		creationDate: args.CreationDate,
+		expiryDate:   args.ExpiryDate,
 		profiles:     args.Profiles,
 		localConfig:  args.Config,
-		localDevices: args.Devices}
+		localDevices: args.Devices,
+		uuid:         args.UUID}
 
 	// No need to detect storage here, its a new container.
 	c.storage = d.Storage
@@ -96,20 +165,7 @@ func containerLXCCreate(d *Daemon, args containerArgs) (container, error) {
 	}
 
 	// Setup initial idmap config
-	idmap := c.IdmapSet()
-	var jsonIdmap string
-	if idmap != nil {
-		idmapBytes, err := json.Marshal(idmap.Idmap)
-		if err != nil {
-			c.Delete()
-			return nil, err
-		}
-		jsonIdmap = string(idmapBytes)
-	} else {
-		jsonIdmap = "[]"
-	}
-
-	err = c.ConfigKeySet("volatile.last_state.idmap", jsonIdmap)
+	err = c.idmapStateSet()
 	if err != nil {
 		c.Delete()
 		return nil, err
@@ -127,9 +183,12 @@ func containerLXCLoad(d *Daemon, args containerArgs) (container, error) {
 		ephemeral:    args.Ephemeral,
 		architecture: args.Architecture,
 		cType:        args.Ctype,
+		creationDate: args.CreationDate,
+		expiryDate:   args.ExpiryDate,
 		profiles:     args.Profiles,
 		localConfig:  args.Config,
-		localDevices: args.Devices}
+		localDevices: args.Devices,
+		uuid:         args.UUID}
 
 	// Detect the storage backend
 	s, err := storageForFilename(d, shared.VarPath("containers", strings.Split(c.name, "/")[0]))
@@ -152,9 +211,12 @@ type containerLXC struct {
 	// Properties
 	architecture int
 	cType        containerType
+	creationDate time.Time
 	ephemeral    bool
+	expiryDate   time.Time
 	id           int
 	name         string
+	uuid         string
 
 	// Config
 	expandedConfig  map[string]string
@@ -244,6 +306,13 @@ func (c *containerLXC) initLXC() error {
 		if err != nil {
 			return err
 		}
+
+		// Delegate the container's own cgroups so the nested containers
+		// can be placed below them
+		err = lxcSetConfigItem(cc, "lxc.mount.auto", "cgroup:mixed")
+		if err != nil {
+			return err
+		}
 	}
 
 	// Setup logging
@@ -290,7 +359,7 @@ func (c *containerLXC) initLXC() error {
 		return err
 	}
 
-	err = lxcSetConfigItem(cc, "lxc.console", "none")
+	err = lxcSetConfigItem(cc, "lxc.console", c.ConsoleLogFilePath())
 	if err != nil {
 		return err
 	}
@@ -301,11 +370,36 @@ func (c *containerLXC) initLXC() error {
 	}
 
 	// Setup the rootfs
-	err = lxcSetConfigItem(cc, "lxc.rootfs", c.RootfsPath())
+	rootfs := c.RootfsPath()
+	if c.idmappedRootfs() {
+		rootfs = c.idmappedRootfsPath()
+	}
+
+	err = lxcSetConfigItem(cc, "lxc.rootfs", rootfs)
 	if err != nil {
 		return err
 	}
 
+	// Read-only rootfs, with tmpfs where the container needs to write
+	if shared.IsTrue(c.expandedConfig["security.readonly_rootfs"]) {
+		err = lxcSetConfigItem(cc, "lxc.rootfs.options", "ro")
+		if err != nil {
+			return err
+		}
+
+		for _, path := range c.readonlyRootfsTmpfs() {
+			mode := "0755"
+			if path == "/tmp" || path == "/var/tmp" {
+				mode = "1777"
+			}
+
+			err = lxcSetConfigItem(cc, "lxc.mount.entry", fmt.Sprintf("tmpfs %s tmpfs rw,nosuid,nodev,mode=%s,optional 0 0", strings.TrimPrefix(path, "/"), mode))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
 	// Setup the hostname
 	err = lxcSetConfigItem(cc, "lxc.utsname", c.Name())
 	if err != nil {
@@ -320,7 +414,13 @@ func (c *containerLXC) initLXC() error {
 
 	// Setup AppArmor
 	if aaAvailable {
-		if aaConfined || !aaAdmin {
+		if AAProfileCustom(c) != "" {
+			// Switching to an existing profile doesn't need mac_admin
+			err = lxcSetConfigItem(cc, "lxc.aa_profile", AAProfileCustom(c))
+			if err != nil {
+				return err
+			}
+		} else if aaConfined || !aaAdmin {
 			// If confined but otherwise able to use AppArmor, use our own profile
 			curProfile := aaProfile()
 			curProfile = strings.TrimSuffix(curProfile, " (enforce)")
@@ -338,9 +438,24 @@ func (c *containerLXC) initLXC() error {
 	}
 
 	// Setup Seccomp
-	err = lxcSetConfigItem(cc, "lxc.seccomp", SeccompProfilePath(c))
-	if err != nil {
-		return err
+	if seccompAvailable {
+		err = lxcSetConfigItem(cc, "lxc.seccomp", SeccompProfilePath(c))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Forward the intercepted syscalls to the daemon
+	if seccompIntercepts(c, "mknod") || seccompIntercepts(c, "setxattr") {
+		err = lxcSetConfigItem(cc, "lxc.seccomp.notify.proxy", fmt.Sprintf("unix:%s", seccompSocketPath()))
+		if err != nil {
+			return err
+		}
+
+		err = lxcSetConfigItem(cc, "lxc.seccomp.notify.cookie", c.Name())
+		if err != nil {
+			return err
+		}
 	}
 
 	// Setup idmap
@@ -355,12 +470,10 @@ func (c *containerLXC) initLXC() error {
 	}
 
 	// Setup environment
-	for k, v := range c.expandedConfig {
-		if strings.HasPrefix(k, "environment.") {
-			err = lxcSetConfigItem(cc, "lxc.environment", fmt.Sprintf("%s=%s", strings.TrimPrefix(k, "environment."), v))
-			if err != nil {
-				return err
-			}
+	for _, env := range containerEnvironment(c.expandedConfig) {
+		err = lxcSetConfigItem(cc, "lxc.environment", env)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -468,6 +581,15 @@ func (c *containerLXC) initLXC() error {
 		}
 	}
 
+	// Keep the memory on the NUMA nodes, the balancer pins the container to
+	// their CPUs once started
+	if c.expandedConfig["limits.cpu.nodes"] != "" && cgCpusetController {
+		err = lxcSetConfigItem(cc, "lxc.cgroup.cpuset.mems", c.expandedConfig["limits.cpu.nodes"])
+		if err != nil {
+			return err
+		}
+	}
+
 	// Setup devices
 	for k, m := range c.expandedDevices {
 		if shared.StringInSlice(m["type"], []string{"unix-char", "unix-block"}) {
@@ -492,6 +614,13 @@ func (c *containerLXC) initLXC() error {
 				if err != nil {
 					return err
 				}
+
+				// Named upfront so that the firewall can be set up
+				// before the container starts
+				err = lxcSetConfigItem(cc, "lxc.network.veth.pair", deviceNextVeth())
+				if err != nil {
+					return err
+				}
 			} else if m["nictype"] == "physical" {
 				err = lxcSetConfigItem(cc, "lxc.network.type", "phys")
 				if err != nil {
@@ -509,7 +638,7 @@ func (c *containerLXC) initLXC() error {
 				}
 			}
 			if shared.StringInSlice(m["nictype"], []string{"bridged", "physical", "macvlan"}) {
-				err = lxcSetConfigItem(cc, "lxc.network.link", m["parent"])
+				err = lxcSetConfigItem(cc, "lxc.network.link", networkNicLink(m))
 				if err != nil {
 					return err
 				}
@@ -682,7 +811,8 @@ func (c *containerLXC) initLXC() error {
 func (c *containerLXC) expandConfig() error {
 	config := map[string]string{}
 
-	// Apply all the profiles
+	// Apply all the profiles, in order, a key set by several of them
+	// getting the value of the last one
 	for _, name := range c.profiles {
 		profileConfig, err := dbProfileConfig(c.daemon.db, name)
 		if err != nil {
@@ -706,7 +836,7 @@ func (c *containerLXC) expandConfig() error {
 func (c *containerLXC) expandDevices() error {
 	devices := shared.Devices{}
 
-	// Apply all the profiles
+	// Apply all the profiles, in order, like for the config
 	for _, p := range c.profiles {
 		profileDevices, err := dbDevices(c.daemon.db, p, true)
 		if err != nil {
@@ -740,23 +870,83 @@ func (c *containerLXC) startCommon() (string, error) {
 		return "", fmt.Errorf("The container is already running")
 	}
 
-	/* Deal with idmap changes */
-	idmap := c.IdmapSet()
+	// Load the kernel modules the container needs on the host
+	modules, err := kernelModulesParse(c.expandedConfig["linux.kernel_modules"])
+	if err != nil {
+		return "", err
+	}
 
-	lastIdmap, err := c.LastIdmapSet()
+	err = kernelModulesLoad(modules)
 	if err != nil {
 		return "", err
 	}
 
-	var jsonIdmap string
-	if idmap != nil {
-		idmapBytes, err := json.Marshal(idmap.Idmap)
+	// The NUMA nodes must exist on this host and hold the pinned CPUs
+	err = deviceNUMALimitsCheck(c.expandedConfig["limits.cpu"], c.expandedConfig["limits.cpu.nodes"])
+	if err != nil {
+		return "", err
+	}
+
+	// Create the bonds, VLAN interfaces and bridges the nics sit on
+	for _, m := range c.expandedDevices {
+		if m["type"] == "nic" {
+			err = networkNicSetup(m)
+			if err != nil {
+				return "", err
+			}
+		}
+	}
+
+	// The network ACLs must exist
+	for _, name := range networkACLNames(c.expandedConfig["security.acls"]) {
+		_, err = dbNetworkACLGet(c.daemon.db, name)
+		if err != nil {
+			return "", fmt.Errorf("Unknown network ACL: %s", name)
+		}
+	}
+
+	// Containers share the realtime clock of the host, a profile may still
+	// have set an offset
+	if c.expandedConfig["security.time_offset"] != "" {
+		err = containerCapabilityCheck(c, "time.offset")
 		if err != nil {
 			return "", err
 		}
-		jsonIdmap = string(idmapBytes)
-	} else {
-		jsonIdmap = "[]"
+	}
+
+	// Any previous stop request is now done with
+	containerStopRequestClear(c.id)
+
+	// Readiness has to be signaled again on every boot
+	if _, ok := c.localConfig["volatile.last_state.ready"]; ok {
+		delete(c.localConfig, "volatile.last_state.ready")
+
+		args := containerArgs{
+			Architecture: c.architecture,
+			Config:       c.localConfig,
+			Devices:      c.localDevices,
+			Ephemeral:    c.ephemeral,
+			Profiles:     c.profiles,
+		}
+
+		err = c.Update(args, false)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	// A container stopped while frozen isn't frozen anymore
+	err = c.frozenClear()
+	if err != nil {
+		return "", err
+	}
+
+	/* Deal with idmap changes */
+	idmap := c.DiskIdmapSet()
+
+	lastIdmap, err := c.lastDiskIdmapSet()
+	if err != nil {
+		return "", err
 	}
 
 	if !reflect.DeepEqual(idmap, lastIdmap) {
@@ -767,8 +957,9 @@ func (c *containerLXC) startCommon() (string, error) {
 			return "", err
 		}
 
+		progress := shiftProgress(c)
 		if lastIdmap != nil {
-			err = lastIdmap.UnshiftRootfs(c.RootfsPath())
+			err = lastIdmap.UnshiftRootfsWithProgress(c.RootfsPath(), progress)
 			if err != nil {
 				c.StorageStop()
 				return "", err
@@ -776,7 +967,7 @@ func (c *containerLXC) startCommon() (string, error) {
 		}
 
 		if idmap != nil {
-			err = idmap.ShiftRootfs(c.RootfsPath())
+			err = idmap.ShiftRootfsWithProgress(c.RootfsPath(), progress)
 			if err != nil {
 				c.StorageStop()
 				return "", err
@@ -784,7 +975,7 @@ func (c *containerLXC) startCommon() (string, error) {
 		}
 	}
 
-	err = c.ConfigKeySet("volatile.last_state.idmap", jsonIdmap)
+	err = c.idmapStateSet()
 	if err != nil {
 		return "", err
 	}
@@ -832,6 +1023,11 @@ func (c *containerLXC) startCommon() (string, error) {
 		return "", err
 	}
 
+	err = logRotate(c.ConsoleLogFilePath())
+	if err != nil {
+		return "", err
+	}
+
 	err = os.MkdirAll(shared.VarPath("devices", c.Name()), 0700)
 	if err != nil {
 		return "", err
@@ -906,9 +1102,71 @@ func (c *containerLXC) startCommon() (string, error) {
 		return "", err
 	}
 
+	// Record what it's started with to tell which changes need a restart
+	err = c.startConfigSave()
+	if err != nil {
+		os.Remove(configPath)
+		return "", err
+	}
+
 	return configPath, nil
 }
 
+// containerStartConfig is the config a container was last started with.
+type containerStartConfig struct {
+	Config  map[string]string `json:"config"`
+	Devices shared.Devices    `json:"devices"`
+}
+
+func (c *containerLXC) startConfigPath() string {
+	return filepath.Join(c.LogPath(), "start_config.json")
+}
+
+func (c *containerLXC) startConfigSave() error {
+	data, err := json.Marshal(containerStartConfig{Config: c.expandedConfig, Devices: c.expandedDevices})
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(c.startConfigPath(), data, 0600)
+}
+
+// pendingChanges returns the config keys and devices ("devices.<name>")
+// changed since the container was started which only apply on restart.
+func (c *containerLXC) pendingChanges() []string {
+	data, err := ioutil.ReadFile(c.startConfigPath())
+	if err != nil {
+		return []string{}
+	}
+
+	started := containerStartConfig{}
+	err = json.Unmarshal(data, &started)
+	if err != nil {
+		return []string{}
+	}
+
+	pending := []string{}
+	for _, key := range containerConfigDiff(started.Config, c.expandedConfig) {
+		if !containerLiveUpdatable(key) {
+			pending = append(pending, key)
+		}
+	}
+
+	devices := []string{}
+	removeDevices, addDevices := started.Devices.Update(c.expandedDevices)
+	for _, changed := range []shared.Devices{removeDevices, addDevices} {
+		for name, m := range changed {
+			key := fmt.Sprintf("devices.%s", name)
+			if !containerLiveUpdatableDevice(m) && !shared.StringInSlice(key, devices) {
+				devices = append(devices, key)
+			}
+		}
+	}
+	sort.Strings(devices)
+
+	return append(pending, devices...)
+}
+
 func (c *containerLXC) Start() error {
 	// Run the shared start code
 	configPath, err := c.startCommon()
@@ -916,6 +1174,24 @@ func (c *containerLXC) Start() error {
 		return err
 	}
 
+	// Run the pre-start hook, failing it aborts the start
+	err = c.runHook("pre-start")
+	if err != nil {
+		return err
+	}
+
+	err = c.startFirewall()
+	if err != nil {
+		return err
+	}
+
+	// Only the log lines of this attempt matter if it fails
+	var logOffset int64
+	fi, err := os.Stat(c.LogFilePath())
+	if err == nil {
+		logOffset = fi.Size()
+	}
+
 	// Start the LXC container
 	out, err := exec.Command(
 		c.daemon.execPath,
@@ -931,18 +1207,58 @@ func (c *containerLXC) Start() error {
 	}
 
 	if err != nil {
-		return fmt.Errorf(
+		return c.startFailure(fmt.Errorf(
 			"Error calling '%s forkstart %s %s %s': err='%v'",
 			c.daemon.execPath,
 			c.name,
 			c.daemon.lxcpath,
 			filepath.Join(c.LogPath(), "lxc.conf"),
-			err)
+			err), logOffset)
+	}
+
+	c.runHook("post-start")
+
+	return nil
+}
+
+// startFirewall filters the traffic of the nics of the container before it
+// starts, never letting it run unfiltered.
+func (c *containerLXC) startFirewall() error {
+	err := networkACLApply(c.daemon, c, "")
+	if err != nil {
+		shared.Log.Error("Failed to apply the network ACLs", log.Ctx{"container": c.name, "err": err})
+		if c.expandedConfig["security.acls"] != "" {
+			return err
+		}
 	}
 
 	return nil
 }
 
+// startFailure adds what's needed to find out why the container failed to
+// start to the error: the LXC log lines of the attempt, the end of the
+// console output and the LXC config it was started with.
+func (c *containerLXC) startFailure(err error, logOffset int64) error {
+	metadata := map[string]interface{}{}
+
+	lxcLog, logErr := logTail(c.LogFilePath(), logOffset, startFailureLogLines)
+	if logErr == nil {
+		metadata["lxc_log"] = lxcLog
+	}
+
+	consoleLog, logErr := logTail(c.ConsoleLogFilePath(), 0, startFailureLogLines)
+	if logErr == nil {
+		metadata["console_log"] = consoleLog
+	}
+
+	config, logErr := ioutil.ReadFile(filepath.Join(c.LogPath(), "lxc.conf"))
+	if logErr == nil {
+		metadata["lxc_config"] = string(config)
+	}
+
+	return operationMetadataError{err: err, metadata: metadata}
+}
+
 func (c *containerLXC) StartFromMigration(imagesDir string) error {
 	// Run the shared start code
 	configPath, err := c.startCommon()
@@ -950,6 +1266,17 @@ func (c *containerLXC) StartFromMigration(imagesDir string) error {
 		return err
 	}
 
+	// Run the pre-start hook, failing it aborts the start
+	err = c.runHook("pre-start")
+	if err != nil {
+		return err
+	}
+
+	err = c.startFirewall()
+	if err != nil {
+		return err
+	}
+
 	// Start the LXC container
 	out, err := exec.Command(
 		c.daemon.execPath,
@@ -975,6 +1302,8 @@ func (c *containerLXC) StartFromMigration(imagesDir string) error {
 			err)
 	}
 
+	c.runHook("post-start")
+
 	return nil
 }
 
@@ -988,9 +1317,19 @@ func (c *containerLXC) OnStart() error {
 		return err
 	}
 
+	// Show the unshifted rootfs to the container with its map
+	if c.idmappedRootfs() {
+		err = c.idmappedRootfsMount()
+		if err != nil {
+			c.StorageStop()
+			return err
+		}
+	}
+
 	// Load the container AppArmor profile
 	err = AALoadProfile(c)
 	if err != nil {
+		c.idmappedRootfsUnmount()
 		c.StorageStop()
 		return err
 	}
@@ -1002,6 +1341,9 @@ func (c *containerLXC) OnStart() error {
 		return err
 	}
 
+	// Register its name for the MAC addresses of its nics
+	networkDNSContainerUpdate(c)
+
 	// Trigger a rebalance
 	deviceTaskSchedulerTrigger("container", c.name, "started")
 
@@ -1016,8 +1358,15 @@ func (c *containerLXC) Stop() error {
 		return err
 	}
 
+	if c.IsRunning() {
+		c.runHook("pre-stop")
+	}
+
+	// Don't let the restart policy bring it back
+	containerStopRequestSet(c.id)
+
 	// Attempt to freeze the container first, helps massively with fork bombs
-	c.Freeze()
+	c.freeze(containerFreezeTimeout)
 
 	// Stop the container
 	if err := c.c.Stop(); err != nil {
@@ -1034,6 +1383,13 @@ func (c *containerLXC) Shutdown(timeout time.Duration) error {
 		return err
 	}
 
+	if c.IsRunning() {
+		c.runHook("pre-stop")
+	}
+
+	// Don't let the restart policy bring it back
+	containerStopRequestSet(c.id)
+
 	// Shutdown the container
 	if err := c.c.Shutdown(timeout); err != nil {
 		return err
@@ -1042,12 +1398,55 @@ func (c *containerLXC) Shutdown(timeout time.Duration) error {
 	return nil
 }
 
+// runHook runs one of the hooks.* scripts on the host. The value is either
+// the path to an executable or an inline shell script.
+func (c *containerLXC) runHook(hook string) error {
+	script := c.expandedConfig[fmt.Sprintf("hooks.%s", hook)]
+	if script == "" {
+		return nil
+	}
+
+	var cmd *exec.Cmd
+	if filepath.IsAbs(script) && shared.PathExists(script) {
+		cmd = exec.Command(script)
+	} else {
+		cmd = exec.Command("/bin/sh", "-c", script)
+	}
+
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("LXD_HOOK=%s", hook),
+		fmt.Sprintf("LXD_CONTAINER_NAME=%s", c.name),
+		fmt.Sprintf("LXD_CONTAINER_PATH=%s", c.Path()),
+		fmt.Sprintf("LXD_CONTAINER_ROOTFS=%s", c.RootfsPath()),
+		fmt.Sprintf("LXD_CONTAINER_PID=%d", c.InitPID()))
+
+	out, err := cmd.CombinedOutput()
+	if string(out) != "" {
+		for _, line := range strings.Split(strings.TrimRight(string(out), "\n"), "\n") {
+			shared.Debugf("hook %s: %s", hook, line)
+		}
+	}
+
+	if err != nil {
+		shared.Log.Error("Container hook failed", log.Ctx{"container": c.name, "hook": hook, "err": err})
+		return fmt.Errorf("The %s hook failed: %s", hook, err)
+	}
+
+	return nil
+}
+
 func (c *containerLXC) OnStop(target string) error {
 	// Make sure we can't call go-lxc functions by mistake
 	c.fromHook = true
 
+	// Release the rootfs before its storage
+	err := c.idmappedRootfsUnmount()
+	if err != nil {
+		return err
+	}
+
 	// Stop the storage for this container
-	err := c.StorageStop()
+	err = c.StorageStop()
 	if err != nil {
 		return err
 	}
@@ -1094,9 +1493,20 @@ func (c *containerLXC) OnStop(target string) error {
 		// Trigger a rebalance
 		deviceTaskSchedulerTrigger("container", c.name, "stopped")
 
+		// Drop the rules of its host interfaces, their names get reused
+		err = networkACLApply(c.daemon, nil, c.name)
+		if err != nil {
+			shared.Log.Error("Failed to apply the network ACLs", log.Ctx{"container": c.name, "err": err})
+		}
+
+		// Restart crashed containers according to their policy
+		if containerRestartPolicyApply(c) {
+			return
+		}
+
 		// Destroy ephemeral containers
 		if c.ephemeral {
-			c.Delete()
+			containerDeleteEphemeral(c, "stopped")
 		}
 	}(c, target)
 
@@ -1104,14 +1514,74 @@ func (c *containerLXC) OnStop(target string) error {
 }
 
 // Freezer functions
-func (c *containerLXC) Freeze() error {
+// freeze asks the freezer cgroup to freeze the container, thawing it back if
+// that didn't complete within the timeout. A process stuck in uninterruptible
+// sleep can't be frozen and would otherwise leave it half frozen forever.
+func (c *containerLXC) freeze(timeout time.Duration) error {
+	// cgroup2 has cgroup.freeze instead, cgroup.events telling when
+	// it's done
+	key, frozen, thawed := "freezer.state", "FROZEN", "THAWED"
+	if featureFreezerUnified {
+		key, frozen, thawed = "cgroup.freeze", "1", "0"
+	}
+
+	err := c.CGroupSet(key, frozen)
+	if err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		if featureFreezerUnified {
+			for _, event := range c.c.CgroupItem("cgroup.events") {
+				if strings.TrimSpace(event) == "frozen 1" {
+					return nil
+				}
+			}
+		} else {
+			state, err := c.CGroupGet(key)
+			if err == nil && state == frozen {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			break
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	err = c.CGroupSet(key, thawed)
+	if err != nil {
+		return fmt.Errorf("Failed to freeze the container within %s and to thaw it back: %s", timeout, err)
+	}
+
+	return fmt.Errorf("Failed to freeze the container within %s, it was thawed back", timeout)
+}
+
+func (c *containerLXC) Freeze(timeout time.Duration) error {
 	// Load the go-lxc struct
 	err := c.initLXC()
 	if err != nil {
 		return err
 	}
 
-	return c.c.Freeze()
+	if !c.IsRunning() {
+		return fmt.Errorf("The container isn't running")
+	}
+
+	if c.IsFrozen() {
+		return fmt.Errorf("The container is already frozen")
+	}
+
+	err = c.freeze(timeout)
+	if err != nil {
+		return err
+	}
+
+	// Record when it was frozen to report how long it has been
+	return c.ConfigKeySet("volatile.last_state.frozen", time.Now().UTC().Format(time.RFC3339))
 }
 
 func (c *containerLXC) Unfreeze() error {
@@ -1121,7 +1591,31 @@ func (c *containerLXC) Unfreeze() error {
 		return err
 	}
 
-	return c.c.Unfreeze()
+	err = c.c.Unfreeze()
+	if err != nil {
+		return err
+	}
+
+	return c.frozenClear()
+}
+
+// frozenClear forgets about the time the container was frozen.
+func (c *containerLXC) frozenClear() error {
+	if _, ok := c.localConfig["volatile.last_state.frozen"]; !ok {
+		return nil
+	}
+
+	delete(c.localConfig, "volatile.last_state.frozen")
+
+	args := containerArgs{
+		Architecture: c.architecture,
+		Config:       c.localConfig,
+		Devices:      c.localDevices,
+		Ephemeral:    c.ephemeral,
+		Profiles:     c.profiles,
+	}
+
+	return c.Update(args, false)
 }
 
 func (c *containerLXC) RenderState() (*shared.ContainerState, error) {
@@ -1136,6 +1630,7 @@ func (c *containerLXC) RenderState() (*shared.ContainerState, error) {
 	status := shared.ContainerStatus{
 		Status:     statusCode.String(),
 		StatusCode: statusCode,
+		Disk:       c.diskGet(),
 	}
 
 	if c.IsRunning() {
@@ -1143,6 +1638,19 @@ func (c *containerLXC) RenderState() (*shared.ContainerState, error) {
 		status.Init = pid
 		status.Processcount = c.processcountGet()
 		status.Ips = c.ipsGet()
+
+		// Containers which don't signal readiness are ready once running
+		status.Ready = !shared.IsTrue(c.expandedConfig["user.ready-signal"]) || c.localConfig["volatile.last_state.ready"] == "true"
+
+		status.PendingKeys = c.pendingChanges()
+		status.RestartRequired = len(status.PendingKeys) > 0
+	}
+
+	if statusCode == shared.Frozen {
+		frozenAt, err := time.Parse(time.RFC3339, c.localConfig["volatile.last_state.frozen"])
+		if err == nil {
+			status.FrozenDuration = int64(time.Since(frozenAt).Seconds())
+		}
 	}
 
 	return &shared.ContainerState{
@@ -1155,6 +1663,7 @@ func (c *containerLXC) RenderState() (*shared.ContainerState, error) {
 		Name:            c.name,
 		Profiles:        c.profiles,
 		Status:          status,
+		UUID:            c.uuid,
 	}, nil
 }
 
@@ -1272,6 +1781,8 @@ func (c *containerLXC) Delete() error {
 		if err := c.storage.ContainerDelete(c); err != nil {
 			return err
 		}
+
+		networkDNSContainerRemove(c.Name())
 	}
 
 	// Remove the database record
@@ -1286,8 +1797,11 @@ func (c *containerLXC) Rename(newName string) error {
 	oldName := c.Name()
 
 	// Sanity checks
-	if !c.IsSnapshot() && !shared.ValidHostname(newName) {
-		return fmt.Errorf("Invalid container name")
+	if !c.IsSnapshot() {
+		err := containerValidName(newName)
+		if err != nil {
+			return err
+		}
 	}
 
 	if c.IsRunning() {
@@ -1343,9 +1857,35 @@ func (c *containerLXC) Rename(newName string) error {
 	// Invalidate the go-lxc cache
 	c.c = nil
 
+	// Move its DNS name over
+	if !c.IsSnapshot() {
+		networkDNSContainerRemove(oldName)
+		networkDNSContainerUpdate(c)
+	}
+
 	return nil
 }
 
+func (c *containerLXC) CGroupGet(key string) (string, error) {
+	// Load the go-lxc struct
+	err := c.initLXC()
+	if err != nil {
+		return "", err
+	}
+
+	// Make sure the container is running
+	if !c.IsRunning() {
+		return "", fmt.Errorf("Can't get cgroups on a stopped container")
+	}
+
+	value := c.c.CgroupItem(key)
+	if len(value) < 1 {
+		return "", fmt.Errorf("Failed to get cgroup %s", key)
+	}
+
+	return strings.TrimSpace(value[0]), nil
+}
+
 func (c *containerLXC) CGroupSet(key string, value string) error {
 	// Load the go-lxc struct
 	err := c.initLXC()
@@ -1377,73 +1917,252 @@ func (c *containerLXC) ConfigKeySet(key string, value string) error {
 		Profiles:     c.profiles,
 	}
 
-	return c.Update(args, false)
-}
-
-func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
-	// Set sane defaults for unset keys
-	if args.Architecture == 0 {
-		args.Architecture = c.architecture
+	return c.Update(args, false)
+}
+
+// updateValidate fills the unset fields of an update with defaults and checks
+// that it can be applied.
+func (c *containerLXC) updateValidate(args *containerArgs, userRequested bool) error {
+	// Set sane defaults for unset keys
+	if args.Architecture == 0 {
+		args.Architecture = c.architecture
+	}
+
+	if args.Config == nil {
+		args.Config = map[string]string{}
+	}
+
+	if args.Devices == nil {
+		args.Devices = shared.Devices{}
+	}
+
+	if args.Profiles == nil {
+		args.Profiles = []string{}
+	}
+
+	// Validate the new config
+	err := containerValidConfig(args.Config, false)
+	if err != nil {
+		return err
+	}
+
+	// Validate the new devices
+	err = containerValidDevices(args.Devices)
+	if err != nil {
+		return err
+	}
+
+	// Validate the new profiles
+	profiles, err := dbProfiles(c.daemon.db)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args.Profiles {
+		if !shared.StringInSlice(name, profiles) {
+			return fmt.Errorf("Profile doesn't exist: %s", name)
+		}
+	}
+
+	// Validate the new architecture
+	if args.Architecture != 0 {
+		_, err = shared.ArchitectureName(args.Architecture)
+		if err != nil {
+			return fmt.Errorf("Invalid architecture id: %s", err)
+		}
+	}
+
+	// A container can't become a virtual machine or the other way around
+	if containerIsVM(args.Config) != c.IsVM() {
+		return fmt.Errorf("The instance type can't be changed.")
+	}
+
+	// Nor switch runtimes while running in one
+	if args.Config["driver"] != c.localConfig["driver"] && c.IsRunning() {
+		return fmt.Errorf("The driver can't be changed while the container is running.")
+	}
+
+	// Time namespaces can't shift the realtime clock
+	if args.Config["security.time_offset"] != "" && !c.IsVM() {
+		return fmt.Errorf("security.time_offset only applies to virtual machines.")
+	}
+
+	// The time zone is looked up in the rootfs of the container
+	zone := args.Config["timezone"]
+	if zone != "" && zone != c.localConfig["timezone"] && !c.IsVM() {
+		err = containerTimezoneCheck(c, zone)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Check that volatile wasn't modified
+	if userRequested {
+		for k, v := range args.Config {
+			if strings.HasPrefix(k, "volatile.") && c.localConfig[k] != v {
+				return fmt.Errorf("Volatile keys are read-only.")
+			}
+		}
+
+		for k, v := range c.localConfig {
+			if strings.HasPrefix(k, "volatile.") && args.Config[k] != v {
+				return fmt.Errorf("Volatile keys are read-only.")
+			}
+		}
+	}
+
+	return nil
+}
+
+// containerLiveUpdatable tells whether a change of the config key gets applied
+// to a running container, or doesn't matter to it, rather than needing a
+// restart.
+func containerLiveUpdatable(key string) bool {
+	if key == "raw.apparmor" || key == "limits.memory" || strings.HasPrefix(key, "limits.memory.") ||
+		key == "limits.cpu" || key == "limits.cpu.nodes" || key == "limits.cpu.priority" || key == "limits.cpu.allowance" ||
+		key == "security.acls" {
+		return true
+	}
+
+	// Only read when something happens to the container
+	for _, prefix := range []string{"boot.", "environment.", "hooks.", "user.", "volatile."} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containerLiveUpdatableDevice tells whether adding or removing the device
+// gets applied to a running container.
+func containerLiveUpdatableDevice(m shared.Device) bool {
+	return shared.StringInSlice(m["type"], []string{"unix-char", "unix-block", "disk", "nic"})
+}
+
+// containerConfigDiff returns the keys which differ between two configs.
+func containerConfigDiff(oldConfig map[string]string, newConfig map[string]string) []string {
+	changedConfig := []string{}
+	for key, _ := range oldConfig {
+		if oldConfig[key] != newConfig[key] {
+			if !shared.StringInSlice(key, changedConfig) {
+				changedConfig = append(changedConfig, key)
+			}
+		}
+	}
+
+	for key, _ := range newConfig {
+		if oldConfig[key] != newConfig[key] {
+			if !shared.StringInSlice(key, changedConfig) {
+				changedConfig = append(changedConfig, key)
+			}
+		}
+	}
+
+	sort.Strings(changedConfig)
+	return changedConfig
+}
+
+// UpdatePreview validates an update and reports what it would change,
+// without applying anything.
+func (c *containerLXC) UpdatePreview(args containerArgs, userRequested bool) (*shared.ContainerUpdatePreview, error) {
+	err := c.updateValidate(&args, userRequested)
+	if err != nil {
+		return nil, err
+	}
+
+	// Expand the new config on a copy of the container
+	preview := &containerLXC{
+		architecture: args.Architecture,
+		cType:        c.cType,
+		ephemeral:    args.Ephemeral,
+		id:           c.id,
+		name:         c.name,
+		localConfig:  args.Config,
+		localDevices: args.Devices,
+		profiles:     args.Profiles,
+		daemon:       c.daemon,
+		idmapset:     c.idmapset,
+		storage:      c.storage,
+	}
+
+	err = preview.expandConfig()
+	if err != nil {
+		return nil, err
 	}
 
-	if args.Config == nil {
-		args.Config = map[string]string{}
+	err = preview.expandDevices()
+	if err != nil {
+		return nil, err
 	}
 
-	if args.Devices == nil {
-		args.Devices = shared.Devices{}
+	err = preview.initLXC()
+	if err != nil {
+		return nil, err
 	}
 
-	if args.Profiles == nil {
-		args.Profiles = []string{}
+	for _, key := range containerConfigDiff(c.expandedConfig, preview.expandedConfig) {
+		if key == "raw.apparmor" {
+			err = AAParseProfile(preview)
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
-	// Validate the new config
-	err := containerValidConfig(args.Config, false)
+	// Render the LXC config it would get
+	f, err := ioutil.TempFile("", "lxd_lxc_previewconfig_")
 	if err != nil {
-		return err
+		return nil, err
 	}
+	f.Close()
+	defer os.Remove(f.Name())
 
-	// Validate the new devices
-	err = containerValidDevices(args.Devices)
+	err = preview.c.SaveConfigFile(f.Name())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// Validate the new profiles
-	profiles, err := dbProfiles(c.daemon.db)
+	lxcConfig, err := ioutil.ReadFile(f.Name())
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	for _, name := range args.Profiles {
-		if !shared.StringInSlice(name, profiles) {
-			return fmt.Errorf("Profile doesn't exist: %s", name)
-		}
+	result := shared.ContainerUpdatePreview{
+		Config:    containerConfigDiff(c.expandedConfig, preview.expandedConfig),
+		Devices:   []string{},
+		LxcConfig: string(lxcConfig),
 	}
 
-	// Validate the new architecture
-	if args.Architecture != 0 {
-		_, err = shared.ArchitectureName(args.Architecture)
-		if err != nil {
-			return fmt.Errorf("Invalid architecture id: %s", err)
+	running := c.IsRunning()
+	for _, key := range result.Config {
+		if running && !containerLiveUpdatable(key) {
+			result.RestartRequired = true
 		}
 	}
 
-	// Check that volatile wasn't modified
-	if userRequested {
-		for k, v := range args.Config {
-			if strings.HasPrefix(k, "volatile.") && c.localConfig[k] != v {
-				return fmt.Errorf("Volatile keys are read-only.")
+	removeDevices, addDevices := c.expandedDevices.Update(preview.expandedDevices)
+	for _, devices := range []shared.Devices{removeDevices, addDevices} {
+		for name, m := range devices {
+			if !shared.StringInSlice(name, result.Devices) {
+				result.Devices = append(result.Devices, name)
 			}
-		}
 
-		for k, v := range c.localConfig {
-			if strings.HasPrefix(k, "volatile.") && args.Config[k] != v {
-				return fmt.Errorf("Volatile keys are read-only.")
+			if running && !containerLiveUpdatableDevice(m) {
+				result.RestartRequired = true
 			}
 		}
 	}
+	sort.Strings(result.Devices)
+
+	return &result, nil
+}
+
+func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
+	err := c.updateValidate(&args, userRequested)
+	if err != nil {
+		return err
+	}
 
 	// Get a copy of the old configuration
 	oldArchitecture := 0
@@ -1528,22 +2247,7 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 	}
 
 	// Diff the configurations
-	changedConfig := []string{}
-	for key, _ := range oldExpandedConfig {
-		if oldExpandedConfig[key] != c.expandedConfig[key] {
-			if !shared.StringInSlice(key, changedConfig) {
-				changedConfig = append(changedConfig, key)
-			}
-		}
-	}
-
-	for key, _ := range c.expandedConfig {
-		if oldExpandedConfig[key] != c.expandedConfig[key] {
-			if !shared.StringInSlice(key, changedConfig) {
-				changedConfig = append(changedConfig, key)
-			}
-		}
-	}
+	changedConfig := containerConfigDiff(oldExpandedConfig, c.expandedConfig)
 
 	// Diff the devices
 	removeDevices, addDevices := oldExpandedDevices.Update(c.expandedDevices)
@@ -1683,7 +2387,38 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 					}
 				}
 			} else if key == "limits.cpu" {
+				err := deviceNUMALimitsCheck(c.expandedConfig["limits.cpu"], c.expandedConfig["limits.cpu.nodes"])
+				if err != nil {
+					undoChanges()
+					return err
+				}
+
 				// Trigger a scheduler re-run
+				deviceTaskSchedulerTrigger("container", c.name, "changed")
+			} else if key == "limits.cpu.nodes" {
+				// Skip if no cpuset CGroup
+				if !cgCpusetController {
+					continue
+				}
+
+				// Move the memory to the new nodes, all of them when unset
+				nodes := c.expandedConfig["limits.cpu.nodes"]
+				if nodes == "" {
+					nodes = readSysString("/sys/devices/system/node/online")
+				}
+
+				err := deviceNUMALimitsCheck(c.expandedConfig["limits.cpu"], nodes)
+				if err != nil {
+					undoChanges()
+					return err
+				}
+
+				err = c.CGroupSet("cpuset.mems", nodes)
+				if err != nil {
+					undoChanges()
+					return err
+				}
+
 				deviceTaskSchedulerTrigger("container", c.name, "changed")
 			} else if key == "limits.cpu.priority" || key == "limits.cpu.allowance" {
 				// Skip if no cpu CGroup
@@ -1804,6 +2539,14 @@ func (c *containerLXC) Update(args containerArgs, userRequested bool) error {
 		return err
 	}
 
+	// Recompile the firewall once the new ACLs and nics are in the database
+	if c.IsRunning() && (shared.StringInSlice("security.acls", changedConfig) || len(removeDevices) > 0 || len(addDevices) > 0) {
+		err = networkACLApply(c.daemon, nil, "")
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
@@ -1820,7 +2563,7 @@ func (c *containerLXC) Export(w io.Writer) error {
 	defer c.StorageStop()
 
 	// Unshift the container
-	idmap, err := c.LastIdmapSet()
+	idmap, err := c.lastDiskIdmapSet()
 	if err != nil {
 		return err
 	}
@@ -1941,6 +2684,73 @@ func (c *containerLXC) Export(w io.Writer) error {
 	return tw.Close()
 }
 
+// Exec runs a command in the container, see runCommand.
+func (c *containerLXC) Exec(command []string, options lxc.AttachOptions, timeout int, cancel chan bool) (int, error) {
+	// Load the go-lxc struct
+	err := c.initLXC()
+	if err != nil {
+		return -1, err
+	}
+
+	return runCommand(c.c, command, options, timeout, cancel)
+}
+
+// FilePull copies a file of the running container to the host path and
+// returns its type. It's done from within the mount namespace of the
+// container so that the kernel takes care of symlinks and ../ in the path.
+func (c *containerLXC) FilePull(path string, target string) (string, os.FileMode, error) {
+	out, err := exec.Command(
+		c.daemon.execPath,
+		"forkgetfile",
+		target,
+		fmt.Sprintf("%d", c.InitPID()),
+		path,
+	).CombinedOutput()
+	if err != nil {
+		return "", 0, fmt.Errorf(strings.TrimRight(string(out), "\n"))
+	}
+
+	// forkgetfile tells us what kind of file it found and its mode
+	fileType := "file"
+	mode := os.FileMode(0)
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "type: ") {
+			fileType = strings.TrimPrefix(line, "type: ")
+		}
+
+		if strings.HasPrefix(line, "mode: ") {
+			value, err := strconv.ParseUint(strings.TrimPrefix(line, "mode: "), 10, 32)
+			if err != nil {
+				return "", 0, fmt.Errorf("Bad mode from forkgetfile: %s", line)
+			}
+			mode = os.FileMode(value)
+		}
+	}
+
+	return fileType, mode, nil
+}
+
+// FilePush copies a file of the host into the running container, see
+// FilePull.
+func (c *containerLXC) FilePush(source string, path string, uid int, gid int, mode os.FileMode, fileType string) error {
+	out, err := exec.Command(
+		c.daemon.execPath,
+		"forkputfile",
+		source,
+		fmt.Sprintf("%d", c.InitPID()),
+		path,
+		fmt.Sprintf("%d", uid),
+		fmt.Sprintf("%d", gid),
+		fmt.Sprintf("%d", mode),
+		fileType,
+	).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf(strings.TrimRight(string(out), "\n"))
+	}
+
+	return nil
+}
+
 func (c *containerLXC) Checkpoint(opts lxc.CheckpointOptions) error {
 	// Load the go-lxc struct
 	err := c.initLXC()
@@ -2002,8 +2812,8 @@ func (c *containerLXC) TemplateApply(trigger string) error {
 			gid := 0
 
 			// Get the right uid and gid for the container
-			if !c.IsPrivileged() {
-				uid, gid = c.idmapset.ShiftIntoNs(0, 0)
+			if c.DiskIdmapSet() != nil {
+				uid, gid = c.DiskIdmapSet().ShiftIntoNs(0, 0)
 			}
 
 			// Create the directories leading to the file
@@ -2096,6 +2906,29 @@ func (c *containerLXC) ipsGet() []shared.Ip {
 		return ips
 	}
 
+	// Look for the host side interface names
+	veths := map[string]string{}
+	for i := 0; i < len(c.c.ConfigItem("lxc.network")); i++ {
+		interfaceType := c.c.RunningConfigItem(fmt.Sprintf("lxc.network.%d.type", i))
+		if interfaceType[0] != "veth" {
+			continue
+		}
+
+		nicName := c.c.RunningConfigItem(fmt.Sprintf("lxc.network.%d.name", i))[0]
+		veths[nicName] = c.c.RunningConfigItem(fmt.Sprintf("lxc.network.%d.veth.pair", i))[0]
+	}
+
+	// Prefer the detailed addresses, with their scope and lifetimes
+	detailed, err := networkAddressesGet(c.InitPID())
+	if err == nil {
+		for _, ip := range detailed {
+			ip.HostVeth = veths[ip.Interface]
+			ips = append(ips, ip)
+		}
+
+		return ips
+	}
+
 	// Get the list of interfaces
 	names, err := c.c.Interfaces()
 	if err != nil {
@@ -2110,26 +2943,9 @@ func (c *containerLXC) ipsGet() []shared.Ip {
 			continue
 		}
 
-		// Look for the host side interface name
-		veth := ""
-		for i := 0; i < len(c.c.ConfigItem("lxc.network")); i++ {
-			nicName := c.c.RunningConfigItem(fmt.Sprintf("lxc.network.%d.name", i))[0]
-			if nicName != n {
-				continue
-			}
-
-			interfaceType := c.c.RunningConfigItem(fmt.Sprintf("lxc.network.%d.type", i))
-			if interfaceType[0] != "veth" {
-				continue
-			}
-
-			veth = c.c.RunningConfigItem(fmt.Sprintf("lxc.network.%d.veth.pair", i))[0]
-			break
-		}
-
 		// Render the result
 		for _, a := range addresses {
-			ip := shared.Ip{Interface: n, Address: a, HostVeth: veth}
+			ip := shared.Ip{Interface: n, Address: a, HostVeth: veths[n], Scope: shared.IpScope(a), PreferredLifetime: -1}
 			if net.ParseIP(a).To4() == nil {
 				ip.Protocol = "IPV6"
 			} else {
@@ -2172,6 +2988,85 @@ func (c *containerLXC) processcountGet() int {
 	return len(pids)
 }
 
+// The disk usage is found by running du, zfs or lvs, which is too slow to
+// do on every list of the containers, so it's only refreshed once in a while.
+const diskUsageCacheExpiry = 30 * time.Second
+
+type diskUsageCacheEntry struct {
+	usage  int64
+	quota  int64
+	err    error
+	expiry time.Time
+}
+
+// The entries are keyed by the database id, which unlike the name doesn't get
+// reused by another container after a rename or delete.
+var diskUsageCache = map[int]diskUsageCacheEntry{}
+var diskUsageCacheLock sync.Mutex
+
+// diskUsageGet returns the disk usage and quota of a container or snapshot,
+// from the cache when it's recent enough.
+func diskUsageGet(s storage, c container) (int64, int64, error) {
+	diskUsageCacheLock.Lock()
+	entry, ok := diskUsageCache[c.Id()]
+	diskUsageCacheLock.Unlock()
+	if ok && time.Now().Before(entry.expiry) {
+		return entry.usage, entry.quota, entry.err
+	}
+
+	usage, quota, err := s.ContainerGetUsage(c)
+
+	diskUsageCacheLock.Lock()
+	defer diskUsageCacheLock.Unlock()
+
+	// Forget about the containers which are gone
+	for id, entry := range diskUsageCache {
+		if time.Now().After(entry.expiry) {
+			delete(diskUsageCache, id)
+		}
+	}
+
+	diskUsageCache[c.Id()] = diskUsageCacheEntry{usage: usage, quota: quota, err: err, expiry: time.Now().Add(diskUsageCacheExpiry)}
+
+	return usage, quota, err
+}
+
+func (c *containerLXC) diskGet() shared.ContainerDisk {
+	disk := shared.ContainerDisk{Usage: -1, Quota: -1}
+
+	usage, quota, err := diskUsageGet(c.storage, c)
+	if err != nil {
+		shared.Log.Warn("Couldn't get disk usage", log.Ctx{"container": c.name, "err": err})
+	} else {
+		disk.Usage = usage
+		disk.Quota = quota
+	}
+
+	if c.IsSnapshot() {
+		return disk
+	}
+
+	snaps, err := c.Snapshots()
+	if err != nil {
+		return disk
+	}
+
+	disk.Snapshots = map[string]shared.ContainerDisk{}
+	for _, snap := range snaps {
+		name := strings.SplitN(snap.Name(), shared.SnapshotDelimiter, 2)[1]
+		usage, quota, err := diskUsageGet(c.storage, snap)
+		if err != nil {
+			shared.Log.Warn("Couldn't get disk usage", log.Ctx{"container": snap.Name(), "err": err})
+			disk.Snapshots[name] = shared.ContainerDisk{Usage: -1, Quota: -1}
+			continue
+		}
+
+		disk.Snapshots[name] = shared.ContainerDisk{Usage: usage, Quota: quota}
+	}
+
+	return disk
+}
+
 func (c *containerLXC) tarStoreFile(linkmap map[uint64]string, offset int, tw *tar.Writer, path string, fi os.FileInfo) error {
 	var err error
 	var major, minor, nlink int
@@ -2573,6 +3468,12 @@ func (c *containerLXC) removeUnixDevices() error {
 func (c *containerLXC) createNetworkDevice(name string, m shared.Device) (string, error) {
 	var dev string
 
+	// Create the bond, VLAN interfaces and bridges
+	err := networkNicSetup(m)
+	if err != nil {
+		return "", err
+	}
+
 	// Handle bridged and p2p
 	if shared.StringInSlice(m["nictype"], []string{"bridged", "p2p"}) {
 		n1 := deviceNextVeth()
@@ -2583,8 +3484,8 @@ func (c *containerLXC) createNetworkDevice(name string, m shared.Device) (string
 			return "", fmt.Errorf("Failed to create the veth interface: %s", err)
 		}
 
-		if m["nictype"] == "bridge" {
-			err = exec.Command("brctl", "addif", m["parent"], n1).Run()
+		if m["nictype"] == "bridged" {
+			err = exec.Command("brctl", "addif", networkNicLink(m), n1).Run()
 			if err != nil {
 				deviceRemoveInterface(n2)
 				return "", fmt.Errorf("Failed to add interface to bridge: %s", err)
@@ -2596,14 +3497,14 @@ func (c *containerLXC) createNetworkDevice(name string, m shared.Device) (string
 
 	// Handle physical
 	if m["nictype"] == "physical" {
-		dev = m["parent"]
+		dev = networkNicLink(m)
 	}
 
 	// Handle macvlan
 	if m["nictype"] == "macvlan" {
 		n1 := deviceNextVeth()
 
-		err := exec.Command("ip", "link", "add", n1, "link", m["parent"], "type", "macvlan", "mode", "bridge").Run()
+		err := exec.Command("ip", "link", "add", n1, "link", networkNicLink(m), "type", "macvlan", "mode", "bridge").Run()
 		if err != nil {
 			return "", fmt.Errorf("Failed to create the new macvlan interface: %s", err)
 		}
@@ -2621,7 +3522,7 @@ func (c *containerLXC) createNetworkDevice(name string, m shared.Device) (string
 	}
 
 	// Bring the interface up
-	err := exec.Command("ip", "link", "set", "dev", dev, "up").Run()
+	err = exec.Command("ip", "link", "set", "dev", dev, "up").Run()
 	if err != nil {
 		deviceRemoveInterface(dev)
 		return "", fmt.Errorf("Failed to bring up the interface: %s", err)
@@ -2822,7 +3723,7 @@ func (c *containerLXC) removeNetworkDevice(name string, m shared.Device) error {
 	// Get a temporary device name
 	var hostName string
 	if m["nictype"] == "physical" {
-		hostName = m["parent"]
+		hostName = networkNicLink(m)
 	} else {
 		hostName = deviceNextVeth()
 	}
@@ -3008,6 +3909,27 @@ func (c *containerLXC) IsFrozen() bool {
 	return c.State() == "FROZEN"
 }
 
+// readonlyRootfsTmpfs returns the paths getting a tmpfs when the rootfs is
+// read-only, /run and /tmp unless configured otherwise. A tmpfs hides what
+// the image has in the directory, so /var, which holds state most images
+// need, only gets one when listed in security.readonly_rootfs.tmpfs.
+func (c *containerLXC) readonlyRootfsTmpfs() []string {
+	value, ok := c.expandedConfig["security.readonly_rootfs.tmpfs"]
+	if !ok {
+		return []string{"/run", "/tmp"}
+	}
+
+	paths := []string{}
+	for _, path := range strings.Split(value, ",") {
+		path = strings.TrimSpace(path)
+		if path != "" {
+			paths = append(paths, filepath.Clean(path))
+		}
+	}
+
+	return paths
+}
+
 func (c *containerLXC) IsNesting() bool {
 	switch strings.ToLower(c.expandedConfig["security.nesting"]) {
 	case "1":
@@ -3019,6 +3941,11 @@ func (c *containerLXC) IsNesting() bool {
 }
 
 func (c *containerLXC) IsPrivileged() bool {
+	// Virtual machines run their own kernel, their rootfs is never shifted
+	if c.IsVM() {
+		return true
+	}
+
 	switch strings.ToLower(c.expandedConfig["security.privileged"]) {
 	case "1":
 		return true
@@ -3036,11 +3963,19 @@ func (c *containerLXC) IsSnapshot() bool {
 	return c.cType == cTypeSnapshot
 }
 
+func (c *containerLXC) IsVM() bool {
+	return containerIsVM(c.localConfig)
+}
+
 // Various property query functions
 func (c *containerLXC) Architecture() int {
 	return c.architecture
 }
 
+func (c *containerLXC) CreationDate() time.Time {
+	return c.creationDate
+}
+
 func (c *containerLXC) ExpandedConfig() map[string]string {
 	return c.expandedConfig
 }
@@ -3049,10 +3984,18 @@ func (c *containerLXC) ExpandedDevices() shared.Devices {
 	return c.expandedDevices
 }
 
+func (c *containerLXC) ExpiryDate() time.Time {
+	return c.expiryDate
+}
+
 func (c *containerLXC) Id() int {
 	return c.id
 }
 
+func (c *containerLXC) UUID() string {
+	return c.uuid
+}
+
 func (c *containerLXC) IdmapSet() *shared.IdmapSet {
 	return c.idmapset
 }
@@ -3075,6 +4018,61 @@ func (c *containerLXC) LocalDevices() shared.Devices {
 	return c.localDevices
 }
 
+// idmappedRootfs tells whether the rootfs is left unshifted on disk and
+// shown to the container through an idmapped mount.
+func (c *containerLXC) idmappedRootfs() bool {
+	if !idmappedMountsAvailable || c.idmapset == nil {
+		return false
+	}
+
+	storageType := c.storage.GetStorageType()
+	return storageType == storageTypeDir || storageType == storageTypeBtrfs
+}
+
+func (c *containerLXC) idmappedRootfsPath() string {
+	return shared.VarPath("idmapped", c.Name())
+}
+
+func (c *containerLXC) idmappedRootfsMount() error {
+	target := c.idmappedRootfsPath()
+	if shared.IsMountPoint(target) {
+		return nil
+	}
+
+	err := os.MkdirAll(target, 0711)
+	if err != nil {
+		return err
+	}
+
+	return idmapMount(c.daemon.execPath, c.idmapset, c.RootfsPath(), target)
+}
+
+func (c *containerLXC) idmappedRootfsUnmount() error {
+	target := c.idmappedRootfsPath()
+	if !shared.PathExists(target) {
+		return nil
+	}
+
+	if shared.IsMountPoint(target) {
+		err := syscall.Unmount(target, syscall.MNT_DETACH)
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(target)
+}
+
+// DiskIdmapSet returns the map the rootfs is to be shifted to on disk, nil
+// when it's left as is.
+func (c *containerLXC) DiskIdmapSet() *shared.IdmapSet {
+	if c.idmappedRootfs() {
+		return nil
+	}
+
+	return c.idmapset
+}
+
 func (c *containerLXC) LastIdmapSet() (*shared.IdmapSet, error) {
 	lastJsonIdmap := c.LocalConfig()["volatile.last_state.idmap"]
 
@@ -3095,6 +4093,36 @@ func (c *containerLXC) LastIdmapSet() (*shared.IdmapSet, error) {
 	return lastIdmap, nil
 }
 
+// lastDiskIdmapSet returns the map the rootfs was last shifted to on disk,
+// nil when it was left unshifted for an idmapped mount.
+func (c *containerLXC) lastDiskIdmapSet() (*shared.IdmapSet, error) {
+	if shared.IsTrue(c.LocalConfig()["volatile.last_state.idmapped"]) {
+		return nil, nil
+	}
+
+	return c.LastIdmapSet()
+}
+
+// idmapStateSet records the map of the container and whether its rootfs is
+// shifted on disk to it or shown through an idmapped mount.
+func (c *containerLXC) idmapStateSet() error {
+	jsonIdmap := "[]"
+	if c.idmapset != nil {
+		idmapBytes, err := json.Marshal(c.idmapset.Idmap)
+		if err != nil {
+			return err
+		}
+		jsonIdmap = string(idmapBytes)
+	}
+
+	err := c.ConfigKeySet("volatile.last_state.idmap", jsonIdmap)
+	if err != nil {
+		return err
+	}
+
+	return c.ConfigKeySet("volatile.last_state.idmapped", fmt.Sprintf("%v", c.idmappedRootfs()))
+}
+
 func (c *containerLXC) LXContainerGet() *lxc.Container {
 	// FIXME: This function should go away
 
@@ -3107,6 +4135,10 @@ func (c *containerLXC) LXContainerGet() *lxc.Container {
 	return c.c
 }
 
+func (c *containerLXC) Driver() containerDriver {
+	return containerLXCDriverInstance
+}
+
 func (c *containerLXC) Daemon() *Daemon {
 	// FIXME: This function should go away
 	return c.daemon
@@ -3147,6 +4179,10 @@ func (c *containerLXC) LogFilePath() string {
 	return filepath.Join(c.LogPath(), "lxc.log")
 }
 
+func (c *containerLXC) ConsoleLogFilePath() string {
+	return filepath.Join(c.LogPath(), "console.log")
+}
+
 func (c *containerLXC) RootfsPath() string {
 	return filepath.Join(c.Path(), "rootfs")
 }
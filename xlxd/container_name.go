@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// containerNameError is a refused container name, with the rule it breaks so
+// that clients can tell what to change.
+type containerNameError struct {
+	Name   string `json:"name"`
+	Rule   string `json:"rule"`
+	Reason string `json:"reason"`
+}
+
+func (e *containerNameError) Error() string {
+	return fmt.Sprintf("Invalid container name '%s': %s", e.Name, e.Reason)
+}
+
+// containerValidName checks that a container name is a valid hostname as of
+// RFC 1123, which makes it safe in paths too. Names starting with a digit
+// are left to containerNameCheck, as the existing containers may have some.
+func containerValidName(name string) error {
+	if strings.Contains(name, shared.SnapshotDelimiter) {
+		return &containerNameError{name, "snapshot", fmt.Sprintf("the character '%s' is reserved for snapshots", shared.SnapshotDelimiter)}
+	}
+
+	if len(name) < 1 || len(name) > 63 {
+		return &containerNameError{name, "length", "it must be 1 to 63 characters long"}
+	}
+
+	for _, c := range name {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-') {
+			return &containerNameError{name, "charset", "only letters, digits and '-' are allowed"}
+		}
+	}
+
+	if strings.HasPrefix(name, "-") || strings.HasSuffix(name, "-") {
+		return &containerNameError{name, "hyphen", "it can't start or end with '-'"}
+	}
+
+	return nil
+}
+
+// containerNameCheck checks the name of a container being created or renamed
+// against the policy of the server: containers.names.leading_digit allows
+// names starting with a digit and containers.names.reserved lists the glob
+// patterns of the names which are refused, whatever their case.
+func containerNameCheck(d *Daemon, name string) error {
+	err := containerValidName(name)
+	if err != nil {
+		return err
+	}
+
+	leadingDigit, err := d.ConfigValueGet("containers.names.leading_digit")
+	if err != nil {
+		return err
+	}
+
+	if name[0] >= '0' && name[0] <= '9' && !shared.IsTrue(leadingDigit) {
+		return &containerNameError{name, "leading_digit", "it can't start with a digit"}
+	}
+
+	reserved, err := d.ConfigValueGet("containers.names.reserved")
+	if err != nil {
+		return err
+	}
+
+	for _, pattern := range containerNameReserved(reserved) {
+		ok, _ := path.Match(pattern, strings.ToLower(name))
+		if ok {
+			return &containerNameError{name, "reserved", fmt.Sprintf("names matching '%s' are reserved", pattern)}
+		}
+	}
+
+	return nil
+}
+
+// containerNameReserved parses containers.names.reserved, a comma separated
+// list of glob patterns.
+func containerNameReserved(value string) []string {
+	patterns := []string{}
+	for _, pattern := range strings.Split(value, ",") {
+		pattern = strings.ToLower(strings.TrimSpace(pattern))
+		if pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// containerNameReservedValidate checks a new value of containers.names.reserved.
+func containerNameReservedValidate(value string) error {
+	for _, pattern := range containerNameReserved(value) {
+		_, err := path.Match(pattern, "")
+		if err != nil {
+			return fmt.Errorf("Invalid reserved name pattern: %s", pattern)
+		}
+	}
+
+	return nil
+}
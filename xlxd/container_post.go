@@ -33,13 +33,13 @@ func containerPost(d *Daemon, r *http.Request) Response {
 	if body.Migration {
 		ws, err := NewMigrationSource(c)
 		if err != nil {
-			return InternalError(err)
+			return SmartError(err)
 		}
 
 		resources := map[string][]string{}
 		resources["containers"] = []string{name}
 
-		op, err := operationCreate(operationClassWebsocket, resources, ws.Metadata(), ws.Do, nil, ws.Connect)
+		op, err := operationCreate(operationClassWebsocket, resources, ws.Metadata(), ws.Do, ws.Cancel, ws.Connect)
 		if err != nil {
 			return InternalError(err)
 		}
@@ -47,6 +47,11 @@ func containerPost(d *Daemon, r *http.Request) Response {
 		return OperationResponse(op)
 	}
 
+	err = containerNameCheck(d, body.Name)
+	if err != nil {
+		return SmartError(err)
+	}
+
 	run := func(*operation) error {
 		return c.Rename(body.Name)
 	}
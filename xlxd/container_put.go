@@ -32,11 +32,38 @@ func containerPut(d *Daemon, r *http.Request) Response {
 		return NotFound
 	}
 
+	// Validate the ETag
+	err = etagCheck(r, containerETag(c))
+	if err != nil {
+		return PreconditionFailed(err)
+	}
+
 	configRaw := containerPutReq{}
 	if err := json.NewDecoder(r.Body).Decode(&configRaw); err != nil {
 		return BadRequest(err)
 	}
 
+	// Only report what the update would do
+	if shared.IsTrue(r.FormValue("dry-run")) {
+		if configRaw.Restore != "" {
+			return BadRequest(fmt.Errorf("Snapshot restores can't be dry-run"))
+		}
+
+		args := containerArgs{
+			Architecture: configRaw.Architecture,
+			Config:       configRaw.Config,
+			Devices:      configRaw.Devices,
+			Ephemeral:    configRaw.Ephemeral,
+			Profiles:     configRaw.Profiles}
+
+		preview, err := c.UpdatePreview(args, false)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		return SyncResponse(true, preview)
+	}
+
 	var do = func(*operation) error { return nil }
 
 	if configRaw.Restore == "" {
@@ -0,0 +1,1192 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// qemuArch is how qemu runs the guests of an architecture.
+type qemuArch struct {
+	binary  string
+	machine string
+	console string
+}
+
+var qemuArchs = map[int]qemuArch{
+	shared.ARCH_64BIT_INTEL_X86:             {"qemu-system-x86_64", "q35,accel=kvm", "ttyS0"},
+	shared.ARCH_64BIT_ARMV8_LITTLE_ENDIAN:   {"qemu-system-aarch64", "virt,accel=kvm,gic-version=host", "ttyAMA0"},
+	shared.ARCH_64BIT_POWERPC_LITTLE_ENDIAN: {"qemu-system-ppc64", "pseries,accel=kvm", "hvc0"},
+	shared.ARCH_64BIT_S390_BIG_ENDIAN:       {"qemu-system-s390x", "s390-ccw-virtio,accel=kvm", "hvc0"},
+}
+
+// Default resources of a virtual machine without limits
+const qemuDefaultMemory = 1024 * 1024 * 1024
+const qemuDefaultKernel = "/boot/vmlinuz"
+const qemuDefaultInitrd = "/boot/initrd.img"
+
+// qemuAgentLocks serializes the use of the guest agent of each virtual
+// machine, its socket only serves one client at a time.
+var qemuAgentLocks = map[string]*sync.Mutex{}
+var qemuAgentLocksLock sync.Mutex
+
+// How often the watchers check whether qemu is still running
+const qemuWatchInterval = time.Second
+
+// The qemu processes (by container id) being watched
+var qemuWatchers = map[int]int{}
+var qemuWatchersLock sync.Mutex
+
+// containerIsVM tells whether a container config is the one of a virtual
+// machine.
+func containerIsVM(config map[string]string) bool {
+	return config["instance.type"] == "vm"
+}
+
+// qemuCheck checks that virtual machines of the architecture can be run
+// on this host: with KVM, so only the architecture of the host.
+func qemuCheck(d *Daemon, architecture int) error {
+	arch, ok := qemuArchs[architecture]
+	if !ok || len(d.architectures) == 0 || architecture != d.architectures[0] {
+		name, _ := shared.ArchitectureName(architecture)
+		return fmt.Errorf("Virtual machines of architecture '%s' can't be run on this host", name)
+	}
+
+	_, err := exec.LookPath(arch.binary)
+	if err != nil {
+		return fmt.Errorf("Virtual machines need qemu, %s wasn't found", arch.binary)
+	}
+
+	if !shared.PathExists("/dev/kvm") {
+		return fmt.Errorf("Virtual machines need KVM, /dev/kvm is missing")
+	}
+
+	return nil
+}
+
+// qemuCPUCount returns the number of virtual CPUs for limits.cpu, either a
+// count or a set of CPUs like "0-3,6".
+func qemuCPUCount(value string) int {
+	if value == "" {
+		return 1
+	}
+
+	count, err := strconv.Atoi(value)
+	if err == nil {
+		if count < 1 {
+			return 1
+		}
+
+		return count
+	}
+
+	count = 0
+	for _, chunk := range strings.Split(value, ",") {
+		fields := strings.SplitN(chunk, "-", 2)
+		if len(fields) == 1 {
+			count++
+			continue
+		}
+
+		low, err1 := strconv.Atoi(fields[0])
+		high, err2 := strconv.Atoi(fields[1])
+		if err1 == nil && err2 == nil && high >= low {
+			count += high - low + 1
+		}
+	}
+
+	if count < 1 {
+		return 1
+	}
+
+	return count
+}
+
+// qemuMemory returns the memory of a virtual machine for limits.memory, in
+// bytes or in percent of the memory of the host.
+func qemuMemory(value string) (int64, error) {
+	if value == "" {
+		return qemuDefaultMemory, nil
+	}
+
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseInt(strings.TrimSuffix(value, "%"), 10, 64)
+		if err != nil {
+			return -1, err
+		}
+
+		total, err := deviceTotalMemory()
+		if err != nil {
+			return -1, err
+		}
+
+		return (total / 100) * percent, nil
+	}
+
+	return deviceParseBytes(value)
+}
+
+// qemuEscape escapes a value of a qemu option list, where commas separate
+// the options.
+func qemuEscape(value string) string {
+	return strings.Replace(value, ",", ",,", -1)
+}
+
+// qemuSplitArgs splits raw.qemu into arguments like a shell would, with
+// quotes and backslashes, but without any expansion.
+func qemuSplitArgs(value string) ([]string, error) {
+	args := []string{}
+	current := []rune{}
+	inArg := false
+	quote := rune(0)
+	escaped := false
+
+	for _, r := range value {
+		switch {
+		case escaped:
+			current = append(current, r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inArg = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				current = append(current, r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inArg = true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, string(current))
+				current = []rune{}
+				inArg = false
+			}
+		default:
+			current = append(current, r)
+			inArg = true
+		}
+	}
+
+	if escaped || quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote or escape")
+	}
+
+	if inArg {
+		args = append(args, string(current))
+	}
+
+	return args, nil
+}
+
+// qemuRootfsFile returns the path on the host of a file of the rootfs, like
+// the kernel, making sure its symlinks don't lead out of the rootfs.
+func qemuRootfsFile(rootfs string, path string) (string, error) {
+	rootfs, err := filepath.EvalSymlinks(rootfs)
+	if err != nil {
+		return "", err
+	}
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(rootfs, path))
+	if err != nil {
+		return "", err
+	}
+
+	if !strings.HasPrefix(resolved, rootfs+"/") {
+		return "", fmt.Errorf("%s is out of the rootfs", path)
+	}
+
+	return resolved, nil
+}
+
+// The capabilities of the qemu driver
+var containerQemuCapabilities = []string{
+	"devices.disk",
+	"devices.nic",
+	"exec",
+	"freeze",
+	"time.offset",
+}
+
+// containerQemuDriver runs virtual machines with qemu and KVM.
+type containerQemuDriver struct {
+	versionOnce sync.Once
+	version     string
+}
+
+var containerQemuDriverInstance = &containerQemuDriver{}
+
+func (driver *containerQemuDriver) Name() string {
+	return "qemu"
+}
+
+func (driver *containerQemuDriver) VM() bool {
+	return true
+}
+
+func (driver *containerQemuDriver) Info(d *Daemon) (*shared.ServerStateDriver, error) {
+	if len(d.architectures) == 0 {
+		return nil, fmt.Errorf("Unknown host architecture")
+	}
+
+	err := qemuCheck(d, d.architectures[0])
+	if err != nil {
+		return nil, err
+	}
+
+	// Like "QEMU emulator version 2.5.0 (Debian 1:2.5+dfsg-5ubuntu10)"
+	driver.versionOnce.Do(func() {
+		out, err := exec.Command(qemuArchs[d.architectures[0]].binary, "--version").Output()
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(string(out))
+		for i, field := range fields {
+			if field == "version" && i+1 < len(fields) {
+				driver.version = strings.TrimSuffix(fields[i+1], ",")
+				break
+			}
+		}
+	})
+
+	return &shared.ServerStateDriver{
+		Version:      driver.version,
+		VM:           true,
+		Capabilities: containerQemuCapabilities,
+	}, nil
+}
+
+func (driver *containerQemuDriver) Create(d *Daemon, args containerArgs) (container, error) {
+	return containerQemuCreate(d, args)
+}
+
+func (driver *containerQemuDriver) Load(d *Daemon, args containerArgs) (container, error) {
+	return containerQemuLoad(d, args)
+}
+
+// The qemu virtual machine driver. Virtual machines are stored and configured
+// like containers, so that's left to the LXC driver, while the guest runs its
+// own kernel from the image with the rootfs shared over 9p.
+type containerQemu struct {
+	*containerLXC
+}
+
+func containerQemuCreate(d *Daemon, args containerArgs) (container, error) {
+	c, err := containerLXCCreate(d, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &containerQemu{c.(*containerLXC)}, nil
+}
+
+func containerQemuLoad(d *Daemon, args containerArgs) (container, error) {
+	c, err := containerLXCLoad(d, args)
+	if err != nil {
+		return nil, err
+	}
+
+	return &containerQemu{c.(*containerLXC)}, nil
+}
+
+// Paths of the runtime files, next to the logs
+func (c *containerQemu) monitorPath() string {
+	return filepath.Join(c.LogPath(), "qemu.monitor")
+}
+
+func (c *containerQemu) agentPath() string {
+	return filepath.Join(c.LogPath(), "qemu.agent")
+}
+
+func (c *containerQemu) pidPath() string {
+	return filepath.Join(c.LogPath(), "qemu.pid")
+}
+
+func (c *containerQemu) qemuLogPath() string {
+	return filepath.Join(c.LogPath(), "qemu.log")
+}
+
+// pid returns the PID of the qemu process of the virtual machine, -1 if it
+// isn't running.
+func (c *containerQemu) pid() int {
+	content, err := ioutil.ReadFile(c.pidPath())
+	if err != nil {
+		return -1
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(content)))
+	if err != nil {
+		return -1
+	}
+
+	// The PID may have been reused since
+	cmdline, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cmdline", pid))
+	if err != nil || !strings.Contains(string(cmdline), c.monitorPath()) {
+		return -1
+	}
+
+	return pid
+}
+
+// monitor runs a QMP command on the virtual machine.
+func (c *containerQemu) monitor(command string, args interface{}, result interface{}) error {
+	m, err := qmpConnect(c.monitorPath())
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return m.Run(command, args, result)
+}
+
+// agent runs a command of the guest agent, which has to be running in the
+// virtual machine.
+func (c *containerQemu) agent(command string, args interface{}, result interface{}, timeout time.Duration) error {
+	qemuAgentLocksLock.Lock()
+	lock, ok := qemuAgentLocks[c.Name()]
+	if !ok {
+		lock = &sync.Mutex{}
+		qemuAgentLocks[c.Name()] = lock
+	}
+	qemuAgentLocksLock.Unlock()
+
+	lock.Lock()
+	defer lock.Unlock()
+
+	m, err := qmpAgentConnect(c.agentPath(), timeout)
+	if err != nil {
+		return err
+	}
+	defer m.Close()
+
+	return m.Run(command, args, result)
+}
+
+// qemuArgs returns the command line of qemu for the virtual machine, and the
+// tap devices to attach to their bridge once it's started.
+func (c *containerQemu) qemuArgs() ([]string, map[string]shared.Device, error) {
+	arch, ok := qemuArchs[c.Architecture()]
+	if !ok {
+		return nil, nil, fmt.Errorf("Unsupported architecture for a virtual machine")
+	}
+
+	config := c.ExpandedConfig()
+	memory, err := qemuMemory(config["limits.memory"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kernel := config["vm.kernel"]
+	if kernel == "" {
+		kernel = qemuDefaultKernel
+	}
+
+	initrd := config["vm.initrd"]
+	if initrd == "" {
+		initrd = qemuDefaultInitrd
+	}
+
+	kernelPath, err := qemuRootfsFile(c.RootfsPath(), kernel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("The virtual machine has no kernel at %s", kernel)
+	}
+
+	// The guest gets its files shared with security_model=mapped-xattr, the
+	// ownership and modes it sets being stored in extended attributes, so
+	// that it can't create setuid files or device nodes on the host
+	cmdline := fmt.Sprintf("root=rootfs rootfstype=9p rootflags=trans=virtio,version=9p2000.L rw console=%s", arch.console)
+	if config["vm.cmdline"] != "" {
+		cmdline = fmt.Sprintf("%s %s", cmdline, config["vm.cmdline"])
+	}
+
+	args := []string{
+		arch.binary,
+		"-name", c.Name(),
+		"-uuid", c.UUID(),
+		"-machine", arch.machine,
+		"-cpu", "host",
+		"-smp", strconv.Itoa(qemuCPUCount(config["limits.cpu"])),
+		"-m", fmt.Sprintf("%dM", memory/1024/1024),
+		"-nodefaults", "-no-user-config",
+		"-display", "none",
+		"-sandbox", "on,obsolete=deny,elevateprivileges=deny,resourcecontrol=deny",
+		"-serial", fmt.Sprintf("file:%s", c.ConsoleLogFilePath()),
+		"-kernel", kernelPath,
+		"-append", cmdline,
+		"-fsdev", fmt.Sprintf("local,id=rootfs,path=%s,security_model=mapped-xattr", qemuEscape(c.RootfsPath())),
+		"-device", "virtio-9p-pci,fsdev=rootfs,mount_tag=rootfs",
+		"-chardev", fmt.Sprintf("socket,id=monitor,path=%s,server=on,wait=off", qemuEscape(c.monitorPath())),
+		"-mon", "chardev=monitor,mode=control",
+		"-chardev", fmt.Sprintf("socket,id=agent,path=%s,server=on,wait=off", qemuEscape(c.agentPath())),
+		"-device", "virtio-serial-pci",
+		"-device", "virtserialport,chardev=agent,name=org.qemu.guest_agent.0",
+		"-pidfile", c.pidPath(),
+		"-daemonize",
+	}
+
+	// The clock of the guest starts off shifted, the realtime one too
+	offset, err := containerTimeOffset(config["security.time_offset"])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if offset != 0 {
+		base := time.Now().UTC().Add(offset).Format("2006-01-02T15:04:05")
+		args = append(args, "-rtc", fmt.Sprintf("base=%s,clock=host", base))
+	}
+
+	initrdPath, err := qemuRootfsFile(c.RootfsPath(), initrd)
+	if err == nil {
+		args = append(args, "-initrd", initrdPath)
+	}
+
+	// The subset of the devices a virtual machine can have
+	devices := c.ExpandedDevices()
+	names := []string{}
+	for name := range devices {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	taps := map[string]shared.Device{}
+	for i, name := range names {
+		m := devices[name]
+		id := fmt.Sprintf("dev%d", i)
+
+		switch m["type"] {
+		case "none":
+			continue
+		case "disk":
+			if m["path"] == "/" {
+				continue
+			}
+
+			if !shared.PathExists(m["source"]) {
+				if shared.IsTrue(m["optional"]) {
+					continue
+				}
+
+				return nil, nil, fmt.Errorf("Source path %s doesn't exist for device %s", m["source"], name)
+			}
+
+			readonly := ""
+			if shared.IsTrue(m["readonly"]) {
+				readonly = ",readonly=on"
+			}
+
+			// Block devices are disks of the guest, directories are
+			// shared with the device name as the 9p mount tag
+			if deviceIsDevice(m["source"]) {
+				args = append(args, "-drive", fmt.Sprintf("file=%s,id=%s,if=virtio,format=raw%s", qemuEscape(m["source"]), id, readonly))
+			} else {
+				args = append(args,
+					"-fsdev", fmt.Sprintf("local,id=%s,path=%s,security_model=mapped-xattr%s", id, qemuEscape(m["source"]), readonly),
+					"-device", fmt.Sprintf("virtio-9p-pci,fsdev=%s,mount_tag=%s", id, qemuEscape(name)))
+			}
+		case "nic":
+			if m["nictype"] != "bridged" {
+				return nil, nil, fmt.Errorf("Virtual machines only support bridged nics, %s is %s", name, m["nictype"])
+			}
+
+			m, err = c.fillNetworkDevice(name, m)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			// qemu creates the tap device and removes it when it exits
+			tap := strings.Replace(deviceNextVeth(), "veth", "tap", 1)
+			taps[tap] = m
+
+			args = append(args,
+				"-netdev", fmt.Sprintf("tap,id=%s,ifname=%s,script=no,downscript=no", id, tap),
+				"-device", fmt.Sprintf("virtio-net-pci,netdev=%s,mac=%s", id, m["hwaddr"]))
+		default:
+			return nil, nil, &containerCapabilityError{"qemu", fmt.Sprintf("devices.%s", m["type"]), ""}
+		}
+	}
+
+	if config["raw.qemu"] != "" {
+		raw, err := qemuSplitArgs(config["raw.qemu"])
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid raw.qemu: %s", err)
+		}
+
+		args = append(args, raw...)
+	}
+
+	return args, taps, nil
+}
+
+// confine runs qemu under the AppArmor profile of the virtual machine,
+// loading it first, when the daemon can.
+func (c *containerQemu) confine(args []string) ([]string, error) {
+	if !aaAvailable {
+		return args, nil
+	}
+
+	err := AALoadProfile(c)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := AAProfileCustom(c)
+	if profile == "" {
+		if !aaAdmin {
+			return args, nil
+		}
+
+		profile = AAProfileFull(c)
+	}
+
+	_, err = exec.LookPath("aa-exec")
+	if err != nil {
+		return nil, fmt.Errorf("Virtual machines need aa-exec to be confined by AppArmor")
+	}
+
+	return append([]string{"aa-exec", "-p", profile, "--"}, args...), nil
+}
+
+func (c *containerQemu) Start() error {
+	if c.IsRunning() {
+		return fmt.Errorf("The container is already running")
+	}
+
+	err := qemuCheck(c.daemon, c.Architecture())
+	if err != nil {
+		return err
+	}
+
+	if c.ExpandedConfig()["security.acls"] != "" {
+		err = containerCapabilityCheck(c, "network.acls")
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(c.LogPath(), 0700)
+	if err != nil {
+		return err
+	}
+
+	err = logRotate(c.ConsoleLogFilePath())
+	if err != nil {
+		return err
+	}
+
+	// Leftovers of a guest which powered itself off
+	c.runtimeCleanup()
+
+	// Any previous stop request is now done with
+	containerStopRequestClear(c.id)
+
+	err = c.StorageStart()
+	if err != nil {
+		return err
+	}
+
+	err = c.TemplateApply("start")
+	if err != nil {
+		c.StorageStop()
+		return err
+	}
+
+	args, taps, err := c.qemuArgs()
+	if err != nil {
+		c.StorageStop()
+		return err
+	}
+
+	args, err = c.confine(args)
+	if err != nil {
+		c.StorageStop()
+		return err
+	}
+
+	// Run the pre-start hook, failing it aborts the start
+	err = c.runHook("pre-start")
+	if err != nil {
+		c.StorageStop()
+		return err
+	}
+
+	// qemu daemonizes once the virtual machine is set up
+	out, err := exec.Command(args[0], args[1:]...).CombinedOutput()
+	ioutil.WriteFile(c.qemuLogPath(), out, 0600)
+	if err != nil {
+		c.StorageStop()
+		return c.startError(fmt.Errorf("Failed to start qemu: %s", err))
+	}
+
+	for tap, m := range taps {
+		err = c.attachTap(tap, m)
+		if err != nil {
+			c.Stop()
+			return err
+		}
+	}
+
+	networkDNSContainerUpdate(c)
+
+	go c.watch(c.pid())
+
+	c.runHook("post-start")
+
+	return nil
+}
+
+// watch waits for the qemu process to exit, which being daemonized it can't
+// be waited for, and then does what the stop hook does for containers when
+// the guest powered itself off or crashed: release what the virtual machine
+// used and apply its restart policy. The stops requested through the API
+// release it themselves.
+func (c *containerQemu) watch(pid int) {
+	if pid < 0 {
+		return
+	}
+
+	qemuWatchersLock.Lock()
+	if qemuWatchers[c.id] == pid {
+		qemuWatchersLock.Unlock()
+		return
+	}
+	qemuWatchers[c.id] = pid
+	qemuWatchersLock.Unlock()
+
+	for c.pid() == pid {
+		time.Sleep(qemuWatchInterval)
+	}
+
+	qemuWatchersLock.Lock()
+	if qemuWatchers[c.id] == pid {
+		delete(qemuWatchers, c.id)
+	}
+	qemuWatchersLock.Unlock()
+
+	if containerStopRequested(c.id) {
+		return
+	}
+
+	// The virtual machine may have been deleted or replaced meanwhile
+	id, err := dbContainerId(c.daemon.db, c.Name())
+	if err != nil || id != c.id {
+		return
+	}
+
+	shared.Log.Info("Virtual machine stopped on its own", log.Ctx{"container": c.Name()})
+	err = c.stopped()
+	if err != nil {
+		shared.Log.Error("Failed to release the virtual machine", log.Ctx{"container": c.Name(), "err": err})
+	}
+
+	if containerRestartPolicyApply(c) {
+		return
+	}
+
+	if c.ephemeral {
+		containerDeleteEphemeral(c, "stopped")
+	}
+}
+
+// startError adds the output of qemu and the end of the console to the
+// error.
+func (c *containerQemu) startError(err error) error {
+	metadata := map[string]interface{}{}
+
+	qemuLog, logErr := logTail(c.qemuLogPath(), 0, startFailureLogLines)
+	if logErr == nil {
+		metadata["qemu_log"] = qemuLog
+	}
+
+	consoleLog, logErr := logTail(c.ConsoleLogFilePath(), 0, startFailureLogLines)
+	if logErr == nil {
+		metadata["console_log"] = consoleLog
+	}
+
+	return operationMetadataError{err: err, metadata: metadata}
+}
+
+// attachTap plugs the tap device of a nic into its bridge.
+func (c *containerQemu) attachTap(tap string, m shared.Device) error {
+	if m["mtu"] != "" {
+		err := exec.Command("ip", "link", "set", "dev", tap, "mtu", m["mtu"]).Run()
+		if err != nil {
+			return fmt.Errorf("Failed to set the MTU of %s: %s", tap, err)
+		}
+	}
+
+	err := networkNicSetup(m)
+	if err != nil {
+		return err
+	}
+
+	bridge := networkNicLink(m)
+	out, err := exec.Command("ip", "link", "set", "dev", tap, "master", bridge).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to add %s to bridge %s: %s", tap, bridge, strings.TrimSpace(string(out)))
+	}
+
+	out, err = exec.Command("ip", "link", "set", "dev", tap, "up").CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to bring %s up: %s", tap, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// runtimeCleanup removes the runtime files of a virtual machine which isn't
+// running.
+func (c *containerQemu) runtimeCleanup() {
+	os.Remove(c.monitorPath())
+	os.Remove(c.agentPath())
+	os.Remove(c.pidPath())
+}
+
+// waitStop waits for qemu to exit, returning false if it's still running
+// after the timeout.
+func (c *containerQemu) waitStop(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for c.IsRunning() {
+		if time.Now().After(deadline) {
+			return false
+		}
+
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	return true
+}
+
+// stopped releases what the virtual machine used once qemu exited.
+func (c *containerQemu) stopped() error {
+	c.runtimeCleanup()
+	c.frozenClear()
+	AAUnloadProfile(c)
+	return c.StorageStop()
+}
+
+func (c *containerQemu) Stop() error {
+	pid := c.pid()
+	if pid < 0 {
+		return fmt.Errorf("The container isn't running")
+	}
+
+	c.runHook("pre-stop")
+
+	// Don't let the restart policy bring it back
+	containerStopRequestSet(c.id)
+
+	err := c.monitor("quit", nil, nil)
+	if err != nil || !c.waitStop(10*time.Second) {
+		syscall.Kill(pid, syscall.SIGKILL)
+		if !c.waitStop(10 * time.Second) {
+			return fmt.Errorf("Failed to stop qemu (PID %d)", pid)
+		}
+	}
+
+	return c.stopped()
+}
+
+func (c *containerQemu) Shutdown(timeout time.Duration) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("The container isn't running")
+	}
+
+	c.runHook("pre-stop")
+
+	// Don't let the restart policy bring it back
+	containerStopRequestSet(c.id)
+
+	// Sent as an ACPI power button press
+	err := c.monitor("system_powerdown", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	if !c.waitStop(timeout) {
+		return fmt.Errorf("The virtual machine didn't shut down within %s", timeout)
+	}
+
+	return c.stopped()
+}
+
+func (c *containerQemu) Freeze(timeout time.Duration) error {
+	if !c.IsRunning() {
+		return fmt.Errorf("The container isn't running")
+	}
+
+	if c.IsFrozen() {
+		return fmt.Errorf("The container is already frozen")
+	}
+
+	err := c.monitor("stop", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	// Record when it was frozen to report how long it has been
+	return c.ConfigKeySet("volatile.last_state.frozen", time.Now().UTC().Format(time.RFC3339))
+}
+
+func (c *containerQemu) Unfreeze() error {
+	err := c.monitor("cont", nil, nil)
+	if err != nil {
+		return err
+	}
+
+	return c.frozenClear()
+}
+
+func (c *containerQemu) Restore(sourceContainer container) error {
+	wasRunning := c.IsRunning()
+	if wasRunning {
+		err := c.Stop()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := c.containerLXC.Restore(sourceContainer)
+	if err != nil {
+		return err
+	}
+
+	if wasRunning {
+		return c.Start()
+	}
+
+	return nil
+}
+
+func (c *containerQemu) Rename(newName string) error {
+	if c.IsRunning() {
+		return fmt.Errorf("renaming of running container not allowed")
+	}
+
+	return c.containerLXC.Rename(newName)
+}
+
+func (c *containerQemu) Checkpoint(opts lxc.CheckpointOptions) error {
+	return &containerCapabilityError{"qemu", "checkpoint", ""}
+}
+
+func (c *containerQemu) StartFromMigration(imagesDir string) error {
+	return &containerCapabilityError{"qemu", "checkpoint", ""}
+}
+
+func (c *containerQemu) FilePull(path string, target string) (string, os.FileMode, error) {
+	return "", 0, &containerCapabilityError{"qemu", "file", ""}
+}
+
+func (c *containerQemu) FilePush(source string, path string, uid int, gid int, mode os.FileMode, fileType string) error {
+	return &containerCapabilityError{"qemu", "file", ""}
+}
+
+// Update only stores the changes, none of them applies to a running virtual
+// machine until it's restarted.
+func (c *containerQemu) Update(args containerArgs, userRequested bool) error {
+	if args.Config["driver"] != c.localConfig["driver"] && c.IsRunning() {
+		return fmt.Errorf("The driver can't be changed while the container is running.")
+	}
+
+	return c.containerLXC.Update(args, userRequested)
+}
+
+func (c *containerQemu) UpdatePreview(args containerArgs, userRequested bool) (*shared.ContainerUpdatePreview, error) {
+	result, err := c.containerLXC.UpdatePreview(args, userRequested)
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.IsRunning() {
+		return result, nil
+	}
+
+	if len(result.Devices) > 0 {
+		result.RestartRequired = true
+	}
+
+	for _, key := range result.Config {
+		if !qemuLiveUpdatable(key) {
+			result.RestartRequired = true
+		}
+	}
+
+	return result, nil
+}
+
+// qemuLiveUpdatable tells whether a change of the config key doesn't matter
+// to a running virtual machine.
+func qemuLiveUpdatable(key string) bool {
+	for _, prefix := range []string{"boot.", "environment.", "hooks.", "user.", "volatile."} {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (c *containerQemu) Export(w io.Writer) error {
+	if c.IsRunning() {
+		return fmt.Errorf("Cannot export a running container as image")
+	}
+
+	return c.containerLXC.Export(w)
+}
+
+func (c *containerQemu) Delete() error {
+	if c.IsRunning() {
+		return fmt.Errorf("Cannot delete a running virtual machine")
+	}
+
+	return c.containerLXC.Delete()
+}
+
+// IsPrivileged is always true, virtual machines run their own kernel and
+// their rootfs is never shifted.
+func (c *containerQemu) IsPrivileged() bool {
+	return true
+}
+
+// IsNesting is always false, a virtual machine has no container to nest
+// into.
+func (c *containerQemu) IsNesting() bool {
+	return false
+}
+
+func (c *containerQemu) OnStart() error {
+	return fmt.Errorf("Virtual machines have no LXC hooks")
+}
+
+func (c *containerQemu) OnStop(target string) error {
+	return fmt.Errorf("Virtual machines have no LXC hooks")
+}
+
+func (c *containerQemu) CGroupGet(key string) (string, error) {
+	return "", fmt.Errorf("Virtual machines have no cgroup")
+}
+
+func (c *containerQemu) CGroupSet(key string, value string) error {
+	return fmt.Errorf("Virtual machines have no cgroup")
+}
+
+// Exec runs a command through the guest agent. The agent returns the output
+// once the command exited, and stdin isn't forwarded.
+func (c *containerQemu) Exec(command []string, options lxc.AttachOptions, timeout int, cancel chan bool) (int, error) {
+	if len(command) == 0 {
+		return -1, fmt.Errorf("Missing command")
+	}
+
+	// The agent runs the commands as root in /
+	args := []string{}
+	if options.Cwd != "" {
+		args = append(args, "env", fmt.Sprintf("--chdir=%s", options.Cwd))
+	}
+
+	if options.UID != 0 || options.GID != 0 {
+		args = append(args, "setpriv", fmt.Sprintf("--reuid=%d", options.UID), fmt.Sprintf("--regid=%d", options.GID), "--clear-groups")
+	}
+
+	if timeout > 0 {
+		args = append(args, "timeout", "-s", "KILL", strconv.Itoa(timeout))
+	}
+	args = append(args, command...)
+
+	started := struct {
+		Pid int `json:"pid"`
+	}{}
+
+	err := c.agent("guest-exec", map[string]interface{}{
+		"path":           args[0],
+		"arg":            args[1:],
+		"env":            options.Env,
+		"capture-output": true,
+	}, &started, qmpTimeout)
+	if err != nil {
+		return -1, err
+	}
+
+	start := time.Now()
+	for {
+		select {
+		case <-cancel:
+			c.agent("guest-exec", map[string]interface{}{"path": "kill", "arg": []string{"-9", strconv.Itoa(started.Pid)}}, nil, qmpTimeout)
+			return int(syscall.SIGKILL), nil
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		status := struct {
+			Exited   bool   `json:"exited"`
+			ExitCode int    `json:"exitcode"`
+			Signal   int    `json:"signal"`
+			OutData  string `json:"out-data"`
+			ErrData  string `json:"err-data"`
+		}{}
+
+		err = c.agent("guest-exec-status", map[string]interface{}{"pid": started.Pid}, &status, qmpTimeout)
+		if err != nil {
+			return -1, err
+		}
+
+		if !status.Exited {
+			continue
+		}
+
+		qemuExecOutput(options.StdoutFd, status.OutData)
+		qemuExecOutput(options.StderrFd, status.ErrData)
+
+		if timeout > 0 && status.ExitCode == 128+int(syscall.SIGKILL) && time.Since(start) >= time.Duration(timeout)*time.Second {
+			return -1, fmt.Errorf("Command timed out after %d seconds", timeout)
+		}
+
+		// Same as the wait status of the lxc containers
+		if status.Signal > 0 {
+			return status.Signal, nil
+		}
+
+		return status.ExitCode << 8, nil
+	}
+}
+
+// qemuExecOutput writes the base64 encoded output of a command to the file
+// descriptor, which belongs to the caller.
+func qemuExecOutput(fd uintptr, data string) {
+	content, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return
+	}
+
+	for len(content) > 0 {
+		n, err := syscall.Write(int(fd), content)
+		if err != nil {
+			return
+		}
+
+		content = content[n:]
+	}
+}
+
+// ipsGet asks the guest agent for the addresses of the virtual machine.
+func (c *containerQemu) ipsGet() []shared.Ip {
+	ips := []shared.Ip{}
+
+	interfaces := []struct {
+		Name      string `json:"name"`
+		Addresses []struct {
+			Address string `json:"ip-address"`
+		} `json:"ip-addresses"`
+	}{}
+
+	err := c.agent("guest-network-get-interfaces", nil, &interfaces, time.Second)
+	if err != nil {
+		return ips
+	}
+
+	for _, iface := range interfaces {
+		if iface.Name == "lo" {
+			continue
+		}
+
+		for _, address := range iface.Addresses {
+			ip := shared.Ip{Interface: iface.Name, Address: address.Address, Scope: shared.IpScope(address.Address), PreferredLifetime: -1}
+			if net.ParseIP(address.Address).To4() == nil {
+				ip.Protocol = "IPV6"
+			} else {
+				ip.Protocol = "IPV4"
+			}
+			ips = append(ips, ip)
+		}
+	}
+
+	return ips
+}
+
+func (c *containerQemu) RenderState() (*shared.ContainerState, error) {
+	state, err := c.containerLXC.RenderState()
+	if err != nil {
+		return nil, err
+	}
+
+	statusCode := shared.Stopped
+	switch c.State() {
+	case "RUNNING":
+		statusCode = shared.Running
+	case "FROZEN":
+		statusCode = shared.Frozen
+	}
+
+	state.Status.Status = statusCode.String()
+	state.Status.StatusCode = statusCode
+
+	if statusCode != shared.Stopped {
+		state.Status.Init = c.InitPID()
+		state.Status.Ips = c.ipsGet()
+		state.Status.Ready = !shared.IsTrue(c.expandedConfig["user.ready-signal"]) || c.localConfig["volatile.last_state.ready"] == "true"
+	}
+
+	if statusCode == shared.Frozen {
+		frozenAt, err := time.Parse(time.RFC3339, c.localConfig["volatile.last_state.frozen"])
+		if err == nil {
+			state.Status.FrozenDuration = int64(time.Since(frozenAt).Seconds())
+		}
+	}
+
+	return state, nil
+}
+
+func (c *containerQemu) IsRunning() bool {
+	return c.pid() > 0
+}
+
+func (c *containerQemu) IsFrozen() bool {
+	return c.State() == "FROZEN"
+}
+
+func (c *containerQemu) InitPID() int {
+	return c.pid()
+}
+
+func (c *containerQemu) State() string {
+	if !c.IsRunning() {
+		return "STOPPED"
+	}
+
+	status := struct {
+		Status string `json:"status"`
+	}{}
+
+	err := c.monitor("query-status", nil, &status)
+	if err == nil && status.Status == "paused" {
+		return "FROZEN"
+	}
+
+	return "RUNNING"
+}
+
+func (c *containerQemu) Driver() containerDriver {
+	return containerQemuDriverInstance
+}
+
+func (c *containerQemu) LXContainerGet() *lxc.Container {
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestQemuCPUCount(t *testing.T) {
+	tests := map[string]int{
+		"":        1,
+		"4":       4,
+		"0":       1,
+		"0-3":     4,
+		"0-3,6":   5,
+		"1,3,5":   3,
+		"garbage": 1,
+	}
+
+	for value, expected := range tests {
+		count := qemuCPUCount(value)
+		if count != expected {
+			t.Errorf("Wrong CPU count for '%s': %d instead of %d", value, count, expected)
+		}
+	}
+}
+
+func TestQemuRootfsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_qemu_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rootfs := filepath.Join(dir, "rootfs")
+	err = os.MkdirAll(filepath.Join(rootfs, "boot"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(rootfs, "boot", "vmlinuz-4.4"), []byte("kernel"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, "host"), []byte("host"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Relative symlinks are followed within the rootfs
+	err = os.Symlink("vmlinuz-4.4", filepath.Join(rootfs, "boot", "vmlinuz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path, err := qemuRootfsFile(rootfs, "/boot/vmlinuz")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if filepath.Base(path) != "vmlinuz-4.4" {
+		t.Errorf("Wrong kernel path: %s", path)
+	}
+
+	// But not out of it
+	err = os.Symlink(filepath.Join(dir, "host"), filepath.Join(rootfs, "boot", "initrd.img"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = qemuRootfsFile(rootfs, "/boot/initrd.img")
+	if err == nil {
+		t.Errorf("A symlink out of the rootfs was followed")
+	}
+
+	_, err = qemuRootfsFile(rootfs, "/boot/missing")
+	if err == nil {
+		t.Errorf("A missing file was found")
+	}
+}
+
+func TestQemuSplitArgs(t *testing.T) {
+	tests := map[string][]string{
+		"":                          {},
+		"-smbios type=1":            {"-smbios", "type=1"},
+		"  -a   b ":                 {"-a", "b"},
+		`-append "quiet splash"`:    {"-append", "quiet splash"},
+		`-name 'a "b"' c\ d`:        {"-name", `a "b"`, "c d"},
+		`-x "" y`:                   {"-x", "", "y"},
+		`-object 'a\b'`:             {"-object", `a\b`},
+		"-device\tvirtio-rng-pci\n": {"-device", "virtio-rng-pci"},
+	}
+
+	for value, expected := range tests {
+		args, err := qemuSplitArgs(value)
+		if err != nil {
+			t.Errorf("Failed to split %q: %s", value, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(args, expected) {
+			t.Errorf("Split %q into %q instead of %q", value, args, expected)
+		}
+	}
+
+	for _, value := range []string{`-name "a`, `-name 'a`, `-name a\`} {
+		_, err := qemuSplitArgs(value)
+		if err == nil {
+			t.Errorf("Invalid %q was accepted", value)
+		}
+	}
+}
+
+func TestQemuEscape(t *testing.T) {
+	if qemuEscape("/dev/disk,id=x") != "/dev/disk,,id=x" {
+		t.Errorf("The commas weren't escaped: %s", qemuEscape("/dev/disk,id=x"))
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/mux"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+type containerRebuildReq struct {
+	Source containerImageSource `json:"source"`
+}
+
+// containerRebuild replaces the rootfs of a stopped container with a fresh
+// copy of an image, by default the one it was created from. The config,
+// devices and profiles are left alone, volatile keys included, so the
+// container keeps its MAC addresses and with them its IP addresses.
+func containerRebuild(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+	c, err := containerLoadByName(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	req := containerRebuildReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	if c.IsRunning() {
+		return BadRequest(fmt.Errorf("The container must be stopped to be rebuilt"))
+	}
+
+	// ZFS snapshots go away with the dataset they belong to
+	snapshots, err := c.Snapshots()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if len(snapshots) > 0 && c.Storage().GetStorageType() == storageTypeZfs {
+		return BadRequest(fmt.Errorf("Containers with snapshots can't be rebuilt on ZFS"))
+	}
+
+	hash := c.LocalConfig()["volatile.base_image"]
+	if req.Source.Alias != "" {
+		if req.Source.Mode == "pull" && req.Source.Server != "" {
+			hash, err = remoteGetImageFingerprint(d, req.Source.Server, req.Source.Alias)
+		} else {
+			hash, err = dbImageAliasGet(d.db, req.Source.Alias)
+		}
+
+		if err != nil {
+			return SmartError(err)
+		}
+	} else if req.Source.Fingerprint != "" {
+		hash = req.Source.Fingerprint
+	}
+
+	if hash == "" {
+		return BadRequest(fmt.Errorf("The container wasn't created from an image, one must be given"))
+	}
+
+	run := func(op *operation) error {
+		if req.Source.Server != "" {
+			err := d.ImageDownload(op, req.Source.Server, hash, req.Source.Secret, true, false, req.Source.BandwidthLimit)
+			if err != nil {
+				return err
+			}
+		}
+
+		imgInfo, err := dbImageGet(d.db, hash, false, false)
+		if err != nil {
+			return err
+		}
+		hash = imgInfo.Fingerprint
+
+		err = imagePolicyCheck(d, imgInfo)
+		if err != nil {
+			return err
+		}
+
+		if !shared.IntInSlice(imgInfo.Architecture, d.architectures) {
+			return fmt.Errorf("The image architecture isn't supported by this server")
+		}
+
+		err = c.Storage().ContainerDelete(c)
+		if err != nil {
+			return err
+		}
+
+		err = c.Storage().ContainerCreateFromImage(c, hash)
+		if err != nil {
+			return fmt.Errorf("Failed to unpack the image, the container has no rootfs until it's rebuilt again: %s", err)
+		}
+
+		err = dbImageLastAccessUpdate(d.db, hash)
+		if err != nil {
+			return err
+		}
+
+		// The new rootfs is shifted to the current map, unless it's shown
+		// through an idmapped mount
+		idmap := "[]"
+		if c.IdmapSet() != nil {
+			idmapBytes, err := json.Marshal(c.IdmapSet().Idmap)
+			if err != nil {
+				return err
+			}
+			idmap = string(idmapBytes)
+		}
+
+		config := map[string]string{}
+		for key, value := range c.LocalConfig() {
+			config[key] = value
+		}
+		config["volatile.base_image"] = hash
+		config["volatile.last_state.idmap"] = idmap
+		config["volatile.last_state.idmapped"] = fmt.Sprintf("%v", c.IdmapSet() != nil && c.DiskIdmapSet() == nil)
+
+		args := containerArgs{
+			Architecture: imgInfo.Architecture,
+			Config:       config,
+			Devices:      c.LocalDevices(),
+			Ephemeral:    c.IsEphemeral(),
+			Profiles:     c.Profiles(),
+		}
+
+		return c.Update(args, false)
+	}
+
+	resources := map[string][]string{}
+	resources["containers"] = []string{name}
+
+	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return OperationResponse(op)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Containers which stay up this long get their retry count reset.
+const containerRestartResetDelay = 10 * time.Minute
+
+// Upper bound for the delay between two restarts.
+const containerRestartMaxDelay = 5 * time.Minute
+
+type containerRestartState struct {
+	retries   int
+	lastStart time.Time
+}
+
+var containerRestartLock sync.Mutex
+
+// Containers (by id) for which the daemon itself requested a stop, those
+// are never restarted.
+var containerStopRequests = map[int]bool{}
+var containerRestartStates = map[int]*containerRestartState{}
+
+func containerStopRequestSet(id int) {
+	containerRestartLock.Lock()
+	defer containerRestartLock.Unlock()
+
+	containerStopRequests[id] = true
+}
+
+func containerStopRequestClear(id int) {
+	containerRestartLock.Lock()
+	defer containerRestartLock.Unlock()
+
+	delete(containerStopRequests, id)
+}
+
+func containerStopRequested(id int) bool {
+	containerRestartLock.Lock()
+	defer containerRestartLock.Unlock()
+
+	return containerStopRequests[id]
+}
+
+// containerRestartPolicyValid checks a boot.restart.policy value.
+func containerRestartPolicyValid(policy string) bool {
+	return shared.StringInSlice(policy, []string{"", "no", "on-failure", "always"})
+}
+
+// containerRestartMaxRetries parses a boot.restart.max_retries value, 0
+// meaning no retry and -1, the default, no limit.
+func containerRestartMaxRetries(value string) (int, error) {
+	if value == "" {
+		return -1, nil
+	}
+
+	maxRetries, err := strconv.Atoi(value)
+	if err != nil || maxRetries < -1 {
+		return -1, fmt.Errorf("Invalid value for boot.restart.max_retries, must be a number of retries or -1: %s", value)
+	}
+
+	return maxRetries, nil
+}
+
+// containerRestartDelay returns the backoff delay before the given retry.
+func containerRestartDelay(retry int) time.Duration {
+	if retry > 8 {
+		return containerRestartMaxDelay
+	}
+
+	delay := time.Duration(1<<uint(retry)) * time.Second
+	if delay > containerRestartMaxDelay {
+		return containerRestartMaxDelay
+	}
+
+	return delay
+}
+
+// next returns the delay before the next retry and its number, false once
+// maxRetries were made. The caller holds containerRestartLock.
+func (s *containerRestartState) next(maxRetries int) (time.Duration, int, bool) {
+	if maxRetries >= 0 && s.retries >= maxRetries {
+		return 0, s.retries, false
+	}
+
+	delay := containerRestartDelay(s.retries)
+	s.retries++
+
+	return delay, s.retries, true
+}
+
+// containerRestartWanted returns whether a container which stopped gets
+// restarted by its policy. Stops requested through the daemon never are.
+// Whatever the way the container went down, LXC stops it with the same
+// target and doesn't tell how init exited, so any other stop counts as a
+// failure, a poweroff from inside the container included.
+func containerRestartWanted(policy string, requested bool) bool {
+	if requested {
+		return false
+	}
+
+	return policy == "on-failure" || policy == "always"
+}
+
+// containerRestartPolicyApply is called once a container has stopped and
+// restarts it if its boot.restart.policy asks for it. It returns whether the
+// container was restarted.
+func containerRestartPolicyApply(c container) bool {
+	containerRestartLock.Lock()
+	requested := containerStopRequests[c.Id()]
+	delete(containerStopRequests, c.Id())
+	containerRestartLock.Unlock()
+
+	config := c.ExpandedConfig()
+	policy := config["boot.restart.policy"]
+	if !containerRestartPolicyValid(policy) {
+		shared.Log.Warn("Invalid restart policy", log.Ctx{"container": c.Name(), "policy": policy})
+		return false
+	}
+
+	if !containerRestartWanted(policy, requested) {
+		return false
+	}
+
+	maxRetries, err := containerRestartMaxRetries(config["boot.restart.max_retries"])
+	if err != nil {
+		shared.Log.Warn("Invalid restart retry count", log.Ctx{"container": c.Name(), "err": err})
+		return false
+	}
+
+	containerRestartLock.Lock()
+	state, ok := containerRestartStates[c.Id()]
+	if !ok || time.Since(state.lastStart) > containerRestartResetDelay {
+		state = &containerRestartState{}
+		containerRestartStates[c.Id()] = state
+	}
+	containerRestartLock.Unlock()
+
+	for {
+		containerRestartLock.Lock()
+		delay, retry, ok := state.next(maxRetries)
+		if !ok {
+			containerRestartLock.Unlock()
+			shared.Log.Error("Giving up on restarting container", log.Ctx{"container": c.Name(), "retries": state.retries})
+			eventSendLifecycle("container-restart-failed",
+				fmt.Sprintf("/%s/containers/%s", shared.APIVersion, c.Name()),
+				shared.Jmap{"uuid": c.UUID(), "policy": policy, "retries": state.retries})
+			return false
+		}
+
+		containerRestartLock.Unlock()
+
+		time.Sleep(delay)
+
+		// Things may have changed while we were waiting, this also gets
+		// us a container struct which isn't tied to the stop hook.
+		ct, err := containerLoadByName(c.Daemon(), c.Name())
+		if err != nil || ct.Id() != c.Id() || ct.IsRunning() || containerStopRequested(c.Id()) {
+			return false
+		}
+
+		shared.Log.Info("Restarting container", log.Ctx{"container": c.Name(), "policy": policy, "retry": retry})
+		containerRestartLock.Lock()
+		state.lastStart = time.Now()
+		containerRestartLock.Unlock()
+
+		err = ct.Start()
+		if err != nil {
+			// No stop hook will fire for this one, so retry from here
+			shared.Log.Error("Failed to restart container", log.Ctx{"container": c.Name(), "err": err})
+			continue
+		}
+
+		eventSendLifecycle("container-restarted",
+			fmt.Sprintf("/%s/containers/%s", shared.APIVersion, c.Name()),
+			shared.Jmap{"uuid": c.UUID(), "policy": policy, "retry": retry})
+
+		return true
+	}
+}
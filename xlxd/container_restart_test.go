@@ -0,0 +1,84 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestContainerRestartMaxRetries(t *testing.T) {
+	tests := map[string]int{
+		"":   -1,
+		"-1": -1,
+		"0":  0,
+		"3":  3,
+	}
+
+	for value, expected := range tests {
+		maxRetries, err := containerRestartMaxRetries(value)
+		if err != nil || maxRetries != expected {
+			t.Errorf("Got %d (%v) instead of %d for %q", maxRetries, err, expected, value)
+		}
+	}
+
+	for _, value := range []string{"-2", "many"} {
+		_, err := containerRestartMaxRetries(value)
+		if err == nil {
+			t.Errorf("Invalid retry count %q was accepted", value)
+		}
+	}
+}
+
+func TestContainerRestartRetries(t *testing.T) {
+	// No retry at all
+	state := &containerRestartState{}
+	_, _, ok := state.next(0)
+	if ok {
+		t.Errorf("Retried with max_retries set to 0")
+	}
+
+	// As many as asked for, with a growing delay
+	state = &containerRestartState{}
+	for _, expected := range []time.Duration{time.Second, 2 * time.Second} {
+		delay, retry, ok := state.next(2)
+		if !ok || delay != expected || retry != state.retries {
+			t.Errorf("Got retry %d after %s (%v) instead of %s", retry, delay, ok, expected)
+		}
+	}
+
+	_, retry, ok := state.next(2)
+	if ok || retry != 2 {
+		t.Errorf("Retried %d times with max_retries set to 2", retry)
+	}
+
+	// Forever, the delay staying capped
+	state = &containerRestartState{}
+	for i := 0; i < 20; i++ {
+		delay, _, ok := state.next(-1)
+		if !ok {
+			t.Fatalf("Gave up after %d retries without limit", i)
+		}
+
+		if delay > containerRestartMaxDelay {
+			t.Errorf("Waiting %s before retry %d", delay, i+1)
+		}
+	}
+}
+
+func TestContainerRestartWanted(t *testing.T) {
+	// A crash, or init getting killed, wasn't asked for
+	for _, policy := range []string{"on-failure", "always"} {
+		if !containerRestartWanted(policy, false) {
+			t.Errorf("A container with the %q policy which stopped on its own isn't restarted", policy)
+		}
+
+		if containerRestartWanted(policy, true) {
+			t.Errorf("A container with the %q policy which was asked to stop is restarted", policy)
+		}
+	}
+
+	for _, policy := range []string{"", "no"} {
+		if containerRestartWanted(policy, false) {
+			t.Errorf("A container with the %q policy is restarted", policy)
+		}
+	}
+}
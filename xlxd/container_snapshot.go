@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 
@@ -34,7 +35,7 @@ func containerSnapshotsGet(d *Daemon, r *http.Request) Response {
 	}
 
 	resultString := []string{}
-	resultMap := []shared.Jmap{}
+	resultMap := []shared.ContainerSnapshot{}
 
 	for _, name := range results {
 		sc, err := containerLoadByName(d, name)
@@ -48,7 +49,13 @@ func containerSnapshotsGet(d *Daemon, r *http.Request) Response {
 			url := fmt.Sprintf("/%s/containers/%s/snapshots/%s", shared.APIVersion, cname, snapName)
 			resultString = append(resultString, url)
 		} else {
-			body := shared.Jmap{"name": snapName, "stateful": shared.PathExists(sc.StatePath())}
+			body := shared.ContainerSnapshot{
+				Name:         snapName,
+				Stateful:     shared.PathExists(sc.StatePath()),
+				CreationDate: sc.CreationDate(),
+				ExpiresAt:    sc.ExpiryDate(),
+				Size:         snapshotSize(sc),
+			}
 			resultMap = append(resultMap, body)
 		}
 	}
@@ -127,6 +134,30 @@ func containerSnapshotsPost(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	if stateful {
+		err = containerCapabilityCheck(c, "checkpoint")
+		if err != nil {
+			return SmartError(err)
+		}
+	}
+
+	// Containers frozen for being idle get woken up
+	if c.IsRunning() {
+		err = autofreezeThaw(c)
+		if err != nil {
+			return InternalError(err)
+		}
+	}
+
+	expiry := time.Time{}
+	expiresAt, err := raw.GetString("expires_at")
+	if err == nil && expiresAt != "" {
+		expiry, err = time.Parse(time.RFC3339, expiresAt)
+		if err != nil {
+			return BadRequest(err)
+		}
+	}
+
 	fullName := name +
 		shared.SnapshotDelimiter +
 		snapshotName
@@ -142,6 +173,7 @@ func containerSnapshotsPost(d *Daemon, r *http.Request) Response {
 			BaseImage:    config["volatile.base_image"],
 			Architecture: c.Architecture(),
 			Devices:      c.ExpandedDevices(),
+			ExpiryDate:   expiry,
 		}
 
 		_, err := containerCreateAsSnapshot(d, args, c, stateful)
@@ -179,8 +211,10 @@ func snapshotHandler(d *Daemon, r *http.Request) Response {
 	switch r.Method {
 	case "GET":
 		return snapshotGet(sc, snapshotName)
+	case "PUT":
+		return snapshotPut(d, r, sc)
 	case "POST":
-		return snapshotPost(r, sc, containerName)
+		return snapshotPost(d, r, sc, containerName)
 	case "DELETE":
 		return snapshotDelete(sc, snapshotName)
 	default:
@@ -188,12 +222,53 @@ func snapshotHandler(d *Daemon, r *http.Request) Response {
 	}
 }
 
+// snapshotSize returns the disk space used by the snapshot, -1 if the
+// storage backend can't tell.
+func snapshotSize(sc container) int64 {
+	usage, _, err := sc.Storage().ContainerGetUsage(sc)
+	if err != nil {
+		return -1
+	}
+
+	return usage
+}
+
 func snapshotGet(sc container, name string) Response {
-	body := shared.Jmap{"name": name, "stateful": shared.PathExists(sc.StatePath())}
+	body := shared.ContainerSnapshot{
+		Name:         name,
+		Stateful:     shared.PathExists(sc.StatePath()),
+		CreationDate: sc.CreationDate(),
+		ExpiresAt:    sc.ExpiryDate(),
+		Size:         snapshotSize(sc),
+		Architecture: sc.Architecture(),
+		Config:       sc.LocalConfig(),
+		Devices:      sc.LocalDevices(),
+		Ephemeral:    sc.IsEphemeral(),
+		Profiles:     sc.Profiles(),
+	}
+
 	return SyncResponse(true, body)
 }
 
-func snapshotPost(r *http.Request, sc container, containerName string) Response {
+type snapshotPutReq struct {
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func snapshotPut(d *Daemon, r *http.Request, sc container) Response {
+	req := snapshotPutReq{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	err := dbSnapshotSetExpiry(d.db, sc.Id(), req.ExpiresAt)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+func snapshotPost(d *Daemon, r *http.Request, sc container, containerName string) Response {
 	raw := shared.Jmap{}
 	if err := json.NewDecoder(r.Body).Decode(&raw); err != nil {
 		return BadRequest(err)
@@ -209,7 +284,7 @@ func snapshotPost(r *http.Request, sc container, containerName string) Response
 		resources := map[string][]string{}
 		resources["containers"] = []string{containerName}
 
-		op, err := operationCreate(operationClassWebsocket, resources, ws.Metadata(), ws.Do, nil, ws.Connect)
+		op, err := operationCreate(operationClassWebsocket, resources, ws.Metadata(), ws.Do, ws.Cancel, ws.Connect)
 		if err != nil {
 			return InternalError(err)
 		}
@@ -222,8 +297,18 @@ func snapshotPost(r *http.Request, sc container, containerName string) Response
 		return BadRequest(err)
 	}
 
+	if newName == "" || strings.Contains(newName, "/") {
+		return BadRequest(fmt.Errorf("Invalid snapshot name: '%s'", newName))
+	}
+
+	fullName := containerName + shared.SnapshotDelimiter + newName
+	_, err = dbContainerId(d.db, fullName)
+	if err == nil {
+		return Conflict
+	}
+
 	rename := func(op *operation) error {
-		return sc.Rename(containerName + shared.SnapshotDelimiter + newName)
+		return sc.Rename(fullName)
 	}
 
 	resources := map[string][]string{}
@@ -252,3 +337,33 @@ func snapshotDelete(sc container, name string) Response {
 
 	return OperationResponse(op)
 }
+
+// snapshotsPruneTask periodically deletes the snapshots which expired.
+func snapshotsPruneTask(d *Daemon) {
+	for {
+		snapshotsPruneExpired(d)
+		time.Sleep(time.Minute)
+	}
+}
+
+func snapshotsPruneExpired(d *Daemon) {
+	names, err := dbSnapshotsExpired(d.db, time.Now())
+	if err != nil {
+		shared.Log.Error("Unable to list the expired snapshots", log.Ctx{"err": err})
+		return
+	}
+
+	for _, name := range names {
+		sc, err := containerLoadByName(d, name)
+		if err != nil {
+			shared.Log.Error("Failed to load snapshot", log.Ctx{"snapshot": name, "err": err})
+			continue
+		}
+
+		shared.Log.Info("Deleting expired snapshot", log.Ctx{"snapshot": name})
+		err = sc.Delete()
+		if err != nil {
+			shared.Log.Error("Failed to delete expired snapshot", log.Ctx{"snapshot": name, "err": err})
+		}
+	}
+}
@@ -4,10 +4,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 type containerStatePutReq struct {
@@ -16,6 +19,15 @@ type containerStatePutReq struct {
 	Force   bool   `json:"force"`
 }
 
+// How often the state is checked again while waiting for a condition.
+const containerStateWaitInterval = 500 * time.Millisecond
+
+// How long a wait lasts when the request doesn't say.
+const containerStateWaitTimeout = 30
+
+// How long freezing may take when the request doesn't say.
+const containerFreezeTimeout = 30 * time.Second
+
 func containerState(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
 	c, err := containerLoadByName(d, name)
@@ -28,7 +40,50 @@ func containerState(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
-	return SyncResponse(true, state.Status)
+	wait := r.FormValue("wait")
+	if wait == "" {
+		return SyncResponse(true, state.Status)
+	}
+
+	timeout := containerStateWaitTimeout
+	if r.FormValue("timeout") != "" {
+		timeout, err = strconv.Atoi(r.FormValue("timeout"))
+		if err != nil || timeout < 0 {
+			return BadRequest(fmt.Errorf("Invalid timeout: %s", r.FormValue("timeout")))
+		}
+	}
+
+	// Long-poll until the container gets there, the client going away or
+	// the container being deleted end the wait early
+	deadline := time.After(time.Duration(timeout) * time.Second)
+	for {
+		matches, err := shared.ContainerStatusMatches(&state.Status, wait)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		if matches {
+			return SyncResponse(true, state.Status)
+		}
+
+		select {
+		case <-deadline:
+			return RequestTimeout(fmt.Errorf("Timed out waiting for the container to reach: %s", wait))
+		case <-r.Context().Done():
+			return InternalError(r.Context().Err())
+		case <-time.After(containerStateWaitInterval):
+		}
+
+		c, err = containerLoadByName(d, name)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		state, err = c.RenderState()
+		if err != nil {
+			return InternalError(err)
+		}
+	}
 }
 
 func containerStatePut(d *Daemon, r *http.Request) Response {
@@ -66,19 +121,19 @@ func containerStatePut(d *Daemon, r *http.Request) Response {
 				}
 
 				if c.IsEphemeral() {
-					c.Delete()
+					containerDeleteEphemeral(c, "stopped")
 				}
 
 				return nil
 			}
 		} else {
 			do = func(op *operation) error {
-				if err = c.Shutdown(time.Duration(raw.Timeout) * time.Second); err != nil {
+				if err = containerShutdownOrStop(c, raw.Timeout); err != nil {
 					return err
 				}
 
 				if c.IsEphemeral() {
-					c.Delete()
+					containerDeleteEphemeral(c, "stopped")
 				}
 				return nil
 			}
@@ -90,7 +145,7 @@ func containerStatePut(d *Daemon, r *http.Request) Response {
 					return err
 				}
 			} else {
-				if err = c.Shutdown(time.Duration(raw.Timeout) * time.Second); err != nil {
+				if err = containerShutdownOrStop(c, raw.Timeout); err != nil {
 					return err
 				}
 			}
@@ -100,10 +155,25 @@ func containerStatePut(d *Daemon, r *http.Request) Response {
 			return nil
 		}
 	case shared.Freeze:
+		err = containerCapabilityCheck(c, "freeze")
+		if err != nil {
+			return SmartError(err)
+		}
+
+		timeout := containerFreezeTimeout
+		if raw.Timeout > 0 {
+			timeout = time.Duration(raw.Timeout) * time.Second
+		}
+
 		do = func(op *operation) error {
-			return c.Freeze()
+			return c.Freeze(timeout)
 		}
 	case shared.Unfreeze:
+		err = containerCapabilityCheck(c, "freeze")
+		if err != nil {
+			return SmartError(err)
+		}
+
 		do = func(op *operation) error {
 			return c.Unfreeze()
 		}
@@ -121,3 +191,20 @@ func containerStatePut(d *Daemon, r *http.Request) Response {
 
 	return OperationResponse(op)
 }
+
+// containerShutdownOrStop asks the container to shutdown cleanly and kills
+// it if it's still around once the timeout (in seconds) expired. A negative
+// timeout waits forever.
+func containerShutdownOrStop(c container, timeout int) error {
+	err := c.Shutdown(time.Duration(timeout) * time.Second)
+	if err == nil || timeout < 0 {
+		return err
+	}
+
+	if !c.IsRunning() {
+		return nil
+	}
+
+	shared.Log.Info("Container didn't shutdown in time, killing it", log.Ctx{"container": c.Name(), "timeout": timeout})
+	return c.Stop()
+}
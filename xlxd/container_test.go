@@ -1,6 +1,11 @@
 package main
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
 	"github.com/krschwab/xlxd/shared"
 )
 
@@ -217,3 +222,138 @@ func (suite *lxdTestSuite) TestContainer_Rename() {
 	suite.Req.Nil(c.Rename("testFoo2"), "Failed to rename the container.")
 	suite.Req.Equal(shared.VarPath("containers", "testFoo2"), c.Path())
 }
+
+func (suite *lxdTestSuite) TestContainer_RestartPolicyConfig() {
+	suite.Req.Nil(containerValidConfig(map[string]string{
+		"boot.restart.policy":      "on-failure",
+		"boot.restart.max_retries": "3"}, false))
+
+	suite.Req.NotNil(containerValidConfig(map[string]string{
+		"boot.restart.policy": "sometimes"}, false))
+
+	suite.Req.NotNil(containerValidConfig(map[string]string{
+		"boot.restart.max_retries": "many"}, false))
+
+	suite.Req.Nil(containerValidConfig(map[string]string{
+		"boot.restart.max_retries": "-1"}, false))
+
+	suite.Req.NotNil(containerValidConfig(map[string]string{
+		"boot.restart.max_retries": "-2"}, false))
+}
+
+func (suite *lxdTestSuite) TestContainer_IdentityReset() {
+	suite.Req.NotNil(containerValidConfig(map[string]string{
+		"copy.identity_reset": "etc/machine-id"}, false))
+
+	args := containerArgs{
+		Ctype:  cTypeRegular,
+		Config: map[string]string{"copy.identity_reset": "/etc/machine-id, /var/lib/dhcp/*.leases"},
+		Name:   "testFoo",
+	}
+
+	c, err := containerCreateInternal(suite.d, args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+
+	rootfs := c.RootfsPath()
+	suite.Req.Nil(os.MkdirAll(filepath.Join(rootfs, "etc"), 0755))
+	suite.Req.Nil(os.MkdirAll(filepath.Join(rootfs, "var", "lib"), 0755))
+	suite.Req.Nil(ioutil.WriteFile(filepath.Join(rootfs, "etc", "machine-id"), []byte("0123456789abcdef\n"), 0444))
+
+	// The leases are on the host as far as the container is concerned
+	outside, err := ioutil.TempDir("", "lxd_test_identity_")
+	suite.Req.Nil(err)
+	defer os.RemoveAll(outside)
+
+	suite.Req.Nil(ioutil.WriteFile(filepath.Join(outside, "eth0.leases"), []byte("lease\n"), 0644))
+	suite.Req.Nil(os.Symlink(outside, filepath.Join(rootfs, "var", "lib", "dhcp")))
+
+	suite.Req.Nil(containerIdentityReset(c))
+
+	content, err := ioutil.ReadFile(filepath.Join(rootfs, "etc", "machine-id"))
+	suite.Req.Nil(err)
+	suite.Equal("", string(content), "The machine-id wasn't emptied.")
+
+	suite.True(
+		shared.PathExists(filepath.Join(outside, "eth0.leases")),
+		"A file out of the container got deleted.")
+}
+
+func (suite *lxdTestSuite) TestContainer_IdentityCopyKey() {
+	dir, err := ioutil.TempDir("", "lxd_test_identity_")
+	suite.Req.Nil(err)
+	defer os.RemoveAll(dir)
+
+	key := filepath.Join(dir, "key")
+	outside := filepath.Join(dir, "outside")
+	target := filepath.Join(dir, "ssh_host_rsa_key")
+	suite.Req.Nil(ioutil.WriteFile(key, []byte("key\n"), 0600))
+	suite.Req.Nil(ioutil.WriteFile(outside, []byte("outside\n"), 0644))
+	suite.Req.Nil(os.Symlink(outside, target))
+
+	suite.Req.Nil(containerIdentityCopyKey(key, target, os.Getuid(), os.Getgid()))
+
+	content, err := ioutil.ReadFile(outside)
+	suite.Req.Nil(err)
+	suite.Equal("outside\n", string(content), "The key got written through a symlink.")
+
+	fi, err := os.Lstat(target)
+	suite.Req.Nil(err)
+	suite.True(fi.Mode().IsRegular(), "The symlink wasn't replaced.")
+}
+
+func (suite *lxdTestSuite) TestContainer_NameCheck() {
+	for _, name := range []string{"", "-foo", "foo-", "foo_bar", "foo/bar", strings.Repeat("a", 64)} {
+		suite.Req.NotNil(containerNameCheck(suite.d, name), name)
+	}
+
+	err := containerNameCheck(suite.d, "1foo")
+	suite.Req.NotNil(err)
+	suite.Req.Equal("leading_digit", err.(*containerNameError).Rule)
+
+	suite.Req.Nil(suite.d.ConfigValueSet("containers.names.leading_digit", "true"))
+	defer suite.d.ConfigValueSet("containers.names.leading_digit", "")
+	suite.Req.Nil(containerNameCheck(suite.d, "1foo"))
+
+	suite.Req.NotNil(containerNameReservedValidate("ci-[a"))
+	suite.Req.Nil(suite.d.ConfigValueSet("containers.names.reserved", "localhost, CI-*"))
+	defer suite.d.ConfigValueSet("containers.names.reserved", "")
+
+	err = containerNameCheck(suite.d, "ci-1")
+	suite.Req.NotNil(err)
+	suite.Req.Equal("reserved", err.(*containerNameError).Rule)
+	suite.Req.NotNil(containerNameCheck(suite.d, "LocalHost"))
+	suite.Req.Nil(containerNameCheck(suite.d, "ci"))
+}
+
+func (suite *lxdTestSuite) TestContainer_ExecLookup() {
+	args := containerArgs{
+		Ctype:     cTypeRegular,
+		Ephemeral: false,
+		Name:      "testFoo",
+	}
+
+	c, err := containerCreateInternal(suite.d, args)
+	suite.Req.Nil(err)
+	defer c.Delete()
+
+	outside, err := ioutil.TempDir("", "lxd_test_exec_")
+	suite.Req.Nil(err)
+	defer os.RemoveAll(outside)
+	suite.Req.Nil(ioutil.WriteFile(filepath.Join(outside, "passwd"), []byte("host:x:1000:1000::/home/host:/bin/sh\n"), 0644))
+
+	etc := filepath.Join(c.RootfsPath(), "etc")
+	suite.Req.Nil(os.MkdirAll(c.RootfsPath(), 0755))
+	suite.Req.Nil(os.Symlink(outside, etc))
+
+	_, err = containerExecLookup(c, "passwd", "host")
+	suite.Req.NotNil(err, "The lookup followed a symlink out of the container.")
+
+	suite.Req.Nil(os.Remove(etc))
+	suite.Req.Nil(os.Mkdir(etc, 0755))
+	suite.Req.Nil(ioutil.WriteFile(filepath.Join(etc, "passwd"), []byte("root:x:0:0:root:/root:/bin/sh\nubuntu:x:1000:1001::/home/ubuntu:/bin/sh\n"), 0644))
+
+	fields, err := containerExecLookup(c, "passwd", "1000")
+	suite.Req.Nil(err)
+	suite.Req.Equal("ubuntu", fields[0])
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Where the time zone database is, in the containers as on the host.
+var zoneinfoPath = "/usr/share/zoneinfo"
+
+// containerTimezoneValid checks the value of the timezone key, "host" or the
+// name of a zone.
+func containerTimezoneValid(zone string) error {
+	if zone == "host" {
+		return nil
+	}
+
+	if zone == "" || filepath.IsAbs(zone) || filepath.Clean(zone) != zone || strings.HasPrefix(zone, "..") {
+		return fmt.Errorf("Invalid time zone: %s", zone)
+	}
+
+	return nil
+}
+
+// containerTimezoneCheck checks that the zone exists in the time zone
+// database of the container, which is what TZ gets resolved against.
+func containerTimezoneCheck(c container, zone string) error {
+	if zone == "host" {
+		zone = hostTimezone()
+	}
+
+	err := containerTimezoneValid(zone)
+	if err != nil {
+		return err
+	}
+
+	err = c.StorageStart()
+	if err != nil {
+		return err
+	}
+	defer c.StorageStop()
+
+	// Zones may be symlinks to others, they get resolved in the container
+	names := strings.Split(strings.TrimPrefix(filepath.Join(zoneinfoPath, zone), "/"), "/")
+	dir, err := containerExecOpen(c, names[:len(names)-1]...)
+	if err != nil {
+		return fmt.Errorf("Unknown time zone in the container: %s", zone)
+	}
+	defer dir.Close()
+
+	fi, err := os.Lstat(fmt.Sprintf("/proc/self/fd/%d/%s", dir.Fd(), names[len(names)-1]))
+	if err != nil || fi.IsDir() {
+		return fmt.Errorf("Unknown time zone in the container: %s", zone)
+	}
+
+	return nil
+}
+
+// hostTimezone returns the time zone of the host, UTC if it can't be told.
+func hostTimezone() string {
+	content, err := ioutil.ReadFile("/etc/timezone")
+	if err == nil && strings.TrimSpace(string(content)) != "" {
+		return strings.TrimSpace(string(content))
+	}
+
+	target, err := os.Readlink("/etc/localtime")
+	if err == nil {
+		i := strings.Index(target, "zoneinfo/")
+		if i >= 0 {
+			return target[i+len("zoneinfo/"):]
+		}
+	}
+
+	return "UTC"
+}
+
+// containerEnvironment returns the environment of the processes of the
+// container, its environment.* keys along with TZ for its timezone key.
+func containerEnvironment(config map[string]string) []string {
+	env := []string{}
+	for k, v := range config {
+		if strings.HasPrefix(k, "environment.") {
+			env = append(env, fmt.Sprintf("%s=%s", strings.TrimPrefix(k, "environment."), v))
+		}
+	}
+
+	// An explicit environment.TZ wins
+	zone := config["timezone"]
+	_, ok := config["environment.TZ"]
+	if zone != "" && !ok {
+		if zone == "host" {
+			zone = hostTimezone()
+		}
+
+		env = append(env, fmt.Sprintf("TZ=%s", zone))
+	}
+
+	return env
+}
+
+// containerTimeOffset parses security.time_offset, a duration like 240h or
+// -30m by which the clock of a virtual machine is shifted.
+func containerTimeOffset(value string) (time.Duration, error) {
+	if value == "" {
+		return 0, nil
+	}
+
+	offset, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid time offset: %s", value)
+	}
+
+	if offset%time.Second != 0 {
+		return 0, fmt.Errorf("The time offset must be a whole number of seconds: %s", value)
+	}
+
+	return offset, nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestContainerTimeOffset(t *testing.T) {
+	valid := map[string]time.Duration{
+		"":       0,
+		"8760h":  8760 * time.Hour,
+		"-30m":   -30 * time.Minute,
+		"1h30m":  90 * time.Minute,
+		"45s":    45 * time.Second,
+		"2000ms": 2 * time.Second,
+	}
+
+	for value, expected := range valid {
+		offset, err := containerTimeOffset(value)
+		if err != nil {
+			t.Errorf("Refused time offset %q: %s", value, err)
+			continue
+		}
+
+		if offset != expected {
+			t.Errorf("Wrong time offset for %q: %s", value, offset)
+		}
+	}
+
+	for _, value := range []string{"soon", "10", "1500ms", "1d"} {
+		_, err := containerTimeOffset(value)
+		if err == nil {
+			t.Errorf("Accepted time offset %q", value)
+		}
+	}
+}
+
+func TestContainerTimezoneValid(t *testing.T) {
+	for _, zone := range []string{"", "/etc/localtime", "../etc/passwd", "Europe/../../etc"} {
+		if containerTimezoneValid(zone) == nil {
+			t.Errorf("Accepted time zone %q", zone)
+		}
+	}
+
+	// Whether the zone exists is up to the container
+	for _, zone := range []string{"host", "Europe/Paris", "Not/A_Zone"} {
+		if containerTimezoneValid(zone) != nil {
+			t.Errorf("Refused time zone %q", zone)
+		}
+	}
+}
+
+func TestContainerEnvironment(t *testing.T) {
+	env := containerEnvironment(map[string]string{"timezone": "Europe/Paris", "environment.LANG": "C"})
+	if len(env) != 2 || !shared.StringInSlice("TZ=Europe/Paris", env) || !shared.StringInSlice("LANG=C", env) {
+		t.Errorf("Wrong environment: %v", env)
+	}
+
+	env = containerEnvironment(map[string]string{"timezone": "Europe/Paris", "environment.TZ": "UTC"})
+	if len(env) != 1 || env[0] != "TZ=UTC" {
+		t.Errorf("The timezone key overrode environment.TZ: %v", env)
+	}
+}
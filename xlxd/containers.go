@@ -50,6 +50,7 @@ var containerSnapshotsCmd = Command{
 var containerSnapshotCmd = Command{
 	name:   "containers/{name}/snapshots/{snapshotName}",
 	get:    snapshotHandler,
+	put:    snapshotHandler,
 	post:   snapshotHandler,
 	delete: snapshotHandler,
 }
@@ -59,8 +60,13 @@ var containerExecCmd = Command{
 	post: containerExecPost,
 }
 
+var containerRebuildCmd = Command{
+	name: "containers/{name}/rebuild",
+	post: containerRebuild,
+}
+
 func containersRestart(d *Daemon) error {
-	containers, err := doContainersGet(d, true)
+	containers, _, err := doContainersGet(d, 1, "", paginationAll)
 
 	if err != nil {
 		return err
@@ -70,6 +76,17 @@ func containersRestart(d *Daemon) error {
 	sort.Sort(containerInfo)
 
 	for _, container := range containerInfo {
+		// qemu outlives the daemon, the virtual machines still running
+		// need watching again
+		if containerIsVM(container.State.ExpandedConfig) {
+			c, err := containerLoadByName(d, container.State.Name)
+			if err == nil && c.IsRunning() {
+				vm := c.(*containerQemu)
+				go vm.watch(vm.pid())
+				continue
+			}
+		}
+
 		lastState := container.State.Config["volatile.last_state.power"]
 
 		autoStart := container.State.ExpandedConfig["boot.autostart"]
@@ -102,6 +119,63 @@ func containersRestart(d *Daemon) error {
 	return nil
 }
 
+var containerEphemeralLock sync.Mutex
+
+// containerDeleteEphemeral removes a stopped ephemeral container, it's safe
+// to call it several times for the same container.
+func containerDeleteEphemeral(c container, reason string) error {
+	containerEphemeralLock.Lock()
+	defer containerEphemeralLock.Unlock()
+
+	// Already gone
+	if _, err := dbContainerId(c.Daemon().db, c.Name()); err != nil {
+		return nil
+	}
+
+	if c.IsRunning() {
+		return fmt.Errorf("Ephemeral container '%s' is still running", c.Name())
+	}
+
+	err := c.Delete()
+	if err != nil {
+		shared.Log.Error("Failed to delete ephemeral container", log.Ctx{"container": c.Name(), "err": err})
+		return err
+	}
+
+	shared.Log.Info("Deleted ephemeral container", log.Ctx{"container": c.Name(), "reason": reason})
+	eventSendLifecycle("container-deleted",
+		fmt.Sprintf("/%s/containers/%s", shared.APIVersion, c.Name()),
+		shared.Jmap{"uuid": c.UUID(), "ephemeral": true, "reason": reason})
+
+	return nil
+}
+
+// containersCleanupEphemeral deletes the ephemeral containers which aren't
+// running, those are left behind when the daemon or the host went away
+// while they were running.
+func containersCleanupEphemeral(d *Daemon) error {
+	names, err := dbContainersListEphemeral(d.db)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			shared.Log.Error("Failed to load ephemeral container", log.Ctx{"container": name, "err": err})
+			continue
+		}
+
+		if c.IsRunning() {
+			continue
+		}
+
+		containerDeleteEphemeral(c, "cleanup")
+	}
+
+	return nil
+}
+
 func containersShutdown(d *Daemon) error {
 	results, err := dbContainersList(d.db, cTypeRegular)
 	if err != nil {
@@ -198,11 +272,9 @@ func startContainer(args []string) error {
 	os.Stderr.Close()
 	os.Stdout.Close()
 	err = c.Start()
-	if err != nil {
-		os.Remove(configPath)
-	} else {
-		shared.FileMove(configPath, shared.LogPath(name, "lxc.conf"))
-	}
+
+	// Keep the config around even on failure, it helps figuring out why
+	shared.FileMove(configPath, shared.LogPath(name, "lxc.conf"))
 
 	return err
 }
@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"path"
 	"strings"
 	"time"
 
@@ -10,10 +11,36 @@ import (
 )
 
 func containersGet(d *Daemon, r *http.Request) Response {
+	page, err := paginationGet(r)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	pattern := r.FormValue("name")
+	_, err = path.Match(pattern, "")
+	if err != nil {
+		return BadRequest(fmt.Errorf("Invalid name pattern: %s", pattern))
+	}
+
+	if d.isNamesOnlyRequest(r) {
+		result, err := dbContainersList(d.db, cTypeRegular)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		result = containersMatch(result, pattern)
+		return SyncResponsePage(page.apply(result), len(result))
+	}
+
 	for {
-		result, err := doContainersGet(d, d.isRecursionRequest(r))
+		result, total, err := doContainersGet(d, d.recursionLevel(r), pattern, page)
 		if err == nil {
-			return SyncResponse(true, result)
+			result, err = fieldsFilter(r, result, containerFieldAliases)
+			if err != nil {
+				return InternalError(err)
+			}
+
+			return SyncResponsePage(result, total)
 		}
 		if !isDbLockedError(err) {
 			shared.Debugf("DBERR: containersGet: error %q", err)
@@ -27,23 +54,44 @@ func containersGet(d *Daemon, r *http.Request) Response {
 	}
 }
 
-func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
+// containersMatch only keeps the names matching the glob pattern, all of
+// them for an empty one.
+func containersMatch(names []string, pattern string) []string {
+	if pattern == "" {
+		return names
+	}
+
+	result := []string{}
+	for _, name := range names {
+		ok, _ := path.Match(pattern, name)
+		if ok {
+			result = append(result, name)
+		}
+	}
+
+	return result
+}
+
+// doContainersGet lists the containers of the page whose name matches the
+// pattern, as URLs without recursion, with their state with recursion=1 and
+// with their snapshots too with recursion=2. It also returns the number of
+// matching containers in all the pages.
+func doContainersGet(d *Daemon, recursion int, pattern string, page pagination) (interface{}, int, error) {
 	result, err := dbContainersList(d.db, cTypeRegular)
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 
+	result = containersMatch(result, pattern)
+
 	resultString := []string{}
 	resultMap := shared.ContainerInfoList{}
-	if err != nil {
-		return []string{}, err
-	}
-	for _, container := range result {
-		if !recursion {
+	for _, container := range page.apply(result) {
+		if recursion == 0 {
 			url := fmt.Sprintf("/%s/containers/%s", shared.APIVersion, container)
 			resultString = append(resultString, url)
 		} else {
-			container, response := doContainerGet(d, container)
+			container, response := doContainerGet(d, container, recursion > 1)
 			if response != nil {
 				continue
 			}
@@ -51,14 +99,14 @@ func doContainersGet(d *Daemon, recursion bool) (interface{}, error) {
 		}
 	}
 
-	if !recursion {
-		return resultString, nil
+	if recursion == 0 {
+		return resultString, len(result), nil
 	}
 
-	return resultMap, nil
+	return resultMap, len(result), nil
 }
 
-func doContainerGet(d *Daemon, cname string) (shared.ContainerInfo, Response) {
+func doContainerGet(d *Daemon, cname string, withSnapshots bool) (shared.ContainerInfo, Response) {
 	c, err := containerLoadByName(d, cname)
 	if err != nil {
 		return shared.ContainerInfo{}, SmartError(err)
@@ -70,10 +118,23 @@ func doContainerGet(d *Daemon, cname string) (shared.ContainerInfo, Response) {
 	}
 
 	var body []string
+	var snapshots []shared.ContainerSnapshot
 
 	for _, name := range results {
-		url := fmt.Sprintf("/%s/containers/%s/snapshots/%s", shared.APIVersion, cname, strings.SplitN(name, shared.SnapshotDelimiter, 2)[1])
+		snapName := strings.SplitN(name, shared.SnapshotDelimiter, 2)[1]
+		url := fmt.Sprintf("/%s/containers/%s/snapshots/%s", shared.APIVersion, cname, snapName)
 		body = append(body, url)
+
+		if withSnapshots {
+			sc, err := containerLoadByName(d, name)
+			if err != nil {
+				continue
+			}
+
+			snapshots = append(snapshots, shared.ContainerSnapshot{
+				Name:     snapName,
+				Stateful: shared.PathExists(sc.StatePath())})
+		}
 	}
 
 	cts, err := c.RenderState()
@@ -82,7 +143,8 @@ func doContainerGet(d *Daemon, cname string) (shared.ContainerInfo, Response) {
 	}
 
 	containerinfo := shared.ContainerInfo{State: *cts,
-		Snaps: body}
+		Snaps:     body,
+		Snapshots: snapshots}
 
 	return containerinfo, nil
 }
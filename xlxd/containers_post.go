@@ -1,6 +1,7 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -38,6 +39,20 @@ type containerImageSource struct {
 
 	/* for "copy" type */
 	Source string `json:"source"`
+
+	/* for "migration" and "copy" types, reset what identifies the
+	 * source machine on the network (see copy.identity_reset) */
+	RefreshIdentity bool `json:"refresh-identity"`
+
+	/* for "migration" and "copy" types, bring an existing copy up to
+	 * date rather than failing, only transferring the snapshots it
+	 * lacks and what changed in the container */
+	Refresh bool `json:"refresh"`
+
+	/* for "image" and "migration" types, the bytes per second the
+	 * download or the transfer is limited to, under the limit of the
+	 * server (images.bandwidth_limit or migration.bandwidth_limit) */
+	BandwidthLimit string `json:"bandwidth-limit"`
 }
 
 type containerPostReq struct {
@@ -73,9 +88,21 @@ func createFromImage(d *Daemon, req *containerPostReq) Response {
 		return BadRequest(fmt.Errorf("must specify one of alias or fingerprint for init from image"))
 	}
 
+	// Refuse blocked local images right away, the remote ones once
+	// downloaded
+	if req.Source.Server == "" {
+		imgInfo, err := dbImageGet(d.db, hash, false, false)
+		if err == nil {
+			err = imagePolicyCheck(d, imgInfo)
+			if err != nil {
+				return SmartError(err)
+			}
+		}
+	}
+
 	run := func(op *operation) error {
 		if req.Source.Server != "" {
-			err := d.ImageDownload(op, req.Source.Server, hash, req.Source.Secret, true, false)
+			err := d.ImageDownload(op, req.Source.Server, hash, req.Source.Secret, true, false, req.Source.BandwidthLimit)
 			if err != nil {
 				return err
 			}
@@ -86,6 +113,11 @@ func createFromImage(d *Daemon, req *containerPostReq) Response {
 			return err
 		}
 
+		err = imagePolicyCheck(d, imgInfo)
+		if err != nil {
+			return err
+		}
+
 		hash = imgInfo.Fingerprint
 
 		args := containerArgs{
@@ -140,10 +172,50 @@ func createFromNone(d *Daemon, req *containerPostReq) Response {
 }
 
 func createFromMigration(d *Daemon, req *containerPostReq) Response {
-	if req.Source.Mode != "pull" {
+	if req.Source.Mode != "pull" && req.Source.Mode != "push" {
 		return NotImplemented
 	}
 
+	target, resp := containerRefreshTarget(d, req)
+	if resp != nil {
+		return resp
+	}
+
+	config, err := shared.GetTLSConfig(d.certf, d.keyf)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	migrationArgs := MigrationSinkArgs{
+		Daemon: d,
+		Url:    req.Source.Operation,
+		Dialer: websocket.Dialer{
+			TLSClientConfig: config,
+			NetDial:         shared.RFC3493Dialer},
+		Secrets: req.Source.Websockets,
+		Refresh: target != nil,
+		Push:    req.Source.Mode == "push",
+	}
+
+	limit, err := bandwidthLimit(d, "migration.bandwidth_limit", req.Source.BandwidthLimit)
+	if err != nil {
+		return BadRequest(err)
+	}
+	// One limit for the whole transfer, dialed or relayed by the client
+	migrationArgs.Limiter = shared.NewBandwidthLimiter(limit)
+	migrationArgs.Dialer.NetDial = migrationArgs.Limiter.Dialer(migrationArgs.Dialer.NetDial)
+
+	/* In push mode, the client relays the websockets of the source, so
+	 * the sink must exist before the operation to give their secrets.
+	 */
+	var push *migrationSink
+	if migrationArgs.Push {
+		push, err = NewMigrationSink(&migrationArgs)
+		if err != nil {
+			return BadRequest(err)
+		}
+	}
+
 	run := func(op *operation) error {
 		args := containerArgs{
 			Architecture: req.Architecture,
@@ -172,7 +244,9 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 		 * point and just negotiate it over the migration control
 		 * socket. Anyway, it'll happen later :)
 		 */
-		if err == nil && d.Storage.MigrationType() == MigrationFSType_RSYNC {
+		if target != nil {
+			c = target
+		} else if err == nil && d.Storage.MigrationType() == MigrationFSType_RSYNC {
 			c, err = containerCreateFromImage(d, args, req.Source.BaseImage)
 			if err != nil {
 				return err
@@ -184,41 +258,46 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 			}
 		}
 
-		config, err := shared.GetTLSConfig(d.certf, d.keyf)
-		if err != nil {
-			c.Delete()
-			return err
-		}
-
-		migrationArgs := MigrationSinkArgs{
-			Url: req.Source.Operation,
-			Dialer: websocket.Dialer{
-				TLSClientConfig: config,
-				NetDial:         shared.RFC3493Dialer},
-			Container: c,
-			Secrets:   req.Source.Websockets,
+		// Only delete what we created when failing
+		cleanup := func() {
+			if target == nil {
+				c.Delete()
+			}
 		}
 
-		sink, err := NewMigrationSink(&migrationArgs)
-		if err != nil {
-			c.Delete()
-			return err
+		sink := push
+		if sink != nil {
+			sink.container = c
+		} else {
+			migrationArgs.Container = c
+			sink, err = NewMigrationSink(&migrationArgs)
+			if err != nil {
+				cleanup()
+				return err
+			}
 		}
 
 		// Start the storage for this container (LVM mount/umount)
 		c.StorageStart()
 
 		// And finaly run the migration.
-		err = sink()
+		err = sink.do()
 		if err != nil {
 			c.StorageStop()
 			shared.Log.Error("Error during migration sink", "err", err)
-			c.Delete()
+			cleanup()
 			return fmt.Errorf("Error transferring container data: %s", err)
 		}
 
 		defer c.StorageStop()
 
+		if req.Source.RefreshIdentity {
+			err = containerIdentityReset(c)
+			if err != nil {
+				return err
+			}
+		}
+
 		err = c.TemplateApply("copy")
 		if err != nil {
 			return err
@@ -230,7 +309,12 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 	resources := map[string][]string{}
 	resources["containers"] = []string{req.Name}
 
-	op, err := operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	var op *operation
+	if push != nil {
+		op, err = operationCreate(operationClassWebsocket, resources, push.Metadata(), run, push.Cancel, push.Connect)
+	} else {
+		op, err = operationCreate(operationClassTask, resources, nil, run, nil, nil)
+	}
 	if err != nil {
 		return InternalError(err)
 	}
@@ -238,6 +322,27 @@ func createFromMigration(d *Daemon, req *containerPostReq) Response {
 	return OperationResponse(op)
 }
 
+// containerRefreshTarget returns the existing container a copy is to
+// refresh, nil if it's to be created.
+func containerRefreshTarget(d *Daemon, req *containerPostReq) (container, Response) {
+	if !req.Source.Refresh {
+		return nil, nil
+	}
+
+	c, err := containerLoadByName(d, req.Name)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, SmartError(err)
+	}
+
+	if c.IsRunning() {
+		return nil, BadRequest(fmt.Errorf("Can't refresh the running container %s", req.Name))
+	}
+
+	return c, nil
+}
+
 func createFromCopy(d *Daemon, req *containerPostReq) Response {
 	if req.Source.Source == "" {
 		return BadRequest(fmt.Errorf("must specify a source container"))
@@ -248,6 +353,11 @@ func createFromCopy(d *Daemon, req *containerPostReq) Response {
 		return SmartError(err)
 	}
 
+	target, resp := containerRefreshTarget(d, req)
+	if resp != nil {
+		return resp
+	}
+
 	// Config override
 	sourceConfig := source.LocalConfig()
 
@@ -287,11 +397,31 @@ func createFromCopy(d *Daemon, req *containerPostReq) Response {
 	}
 
 	run := func(op *operation) error {
-		_, err := containerCreateAsCopy(d, args, source)
+		var c container
+		var err error
+		if target != nil {
+			c = target
+			err = containerRefreshFromCopy(d, c, source)
+		} else {
+			c, err = containerCreateAsCopy(d, args, source)
+		}
 		if err != nil {
 			return err
 		}
 
+		if req.Source.RefreshIdentity {
+			err = c.StorageStart()
+			if err != nil {
+				return err
+			}
+			defer c.StorageStop()
+
+			err = containerIdentityReset(c)
+			if err != nil {
+				return err
+			}
+		}
+
 		return nil
 	}
 
@@ -327,8 +457,14 @@ func containersPost(d *Daemon, r *http.Request) Response {
 		req.Config = map[string]string{}
 	}
 
-	if strings.Contains(req.Name, shared.SnapshotDelimiter) {
-		return BadRequest(fmt.Errorf("Invalid container name: '%s' is reserved for snapshots", shared.SnapshotDelimiter))
+	err := containerNameCheck(d, req.Name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = storageCreateCheck(d)
+	if err != nil {
+		return PreconditionFailed(err)
 	}
 
 	switch req.Source.Type {
@@ -9,6 +9,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
@@ -42,13 +43,22 @@ var aaConfined = false
 
 // CGroup
 var cgCpuController = false
+var cgCpuacctController = false
 var cgCpusetController = false
+var cgFreezerController = false
 var cgMemoryController = false
 var cgSwapAccounting = false
 
+// Seccomp
+var seccompAvailable = false
+var seccompNotifyAvailable = false
+
 // UserNS
 var runningInUserns = false
 
+// Protocols offered over TLS, HTTP/2 clients can reuse a single connection
+var daemonNextProtos = []string{"h2", "http/1.1"}
+
 const (
 	pwSaltBytes = 32
 	pwHashBytes = 64
@@ -57,6 +67,10 @@ const (
 type Socket struct {
 	Socket      net.Listener
 	CloseOnExit bool
+
+	// The core.https_address entry this socket was created for, if any.
+	Address string
+	Policy  string
 }
 
 // A Daemon can respond to requests from a shared client.
@@ -80,16 +94,32 @@ type Daemon struct {
 
 	Sockets []Socket
 
-	tlsconfig *tls.Config
+	// Fingerprints of the client certificates only allowed to read
+	clientCertsReadOnly map[string]bool
 
 	devlxd *net.UnixListener
 
+	seccomp *seccompServer
+
 	configValues map[string]string
 
 	IsMock bool
 
 	imagesDownloading     map[string]chan bool
 	imagesDownloadingLock sync.RWMutex
+
+	// The server certificate, replaced when it gets reloaded, and the TLS
+	// config of the requests made with it
+	cert      *tls.Certificate
+	tlsconfig *tls.Config
+	certLock  sync.RWMutex
+
+	// Held while the certificate files get replaced
+	certReplaceLock sync.Mutex
+
+	// Listeners closed on purpose, their errors are ignored
+	closedSockets     map[net.Listener]bool
+	closedSocketsLock sync.Mutex
 }
 
 // Command is the basic structure for every API call.
@@ -104,15 +134,13 @@ type Command struct {
 }
 
 func (d *Daemon) httpGetSync(url string) (*lxd.Response, error) {
-	var err error
-	if d.tlsconfig == nil {
-		d.tlsconfig, err = shared.GetTLSConfig(d.certf, d.keyf)
-		if err != nil {
-			return nil, err
-		}
+	tlsconfig, err := d.clientTLSConfig()
+	if err != nil {
+		return nil, err
 	}
+
 	tr := &http.Transport{
-		TLSClientConfig: d.tlsconfig,
+		TLSClientConfig: tlsconfig,
 		Dial:            shared.RFC3493Dialer,
 		Proxy:           http.ProxyFromEnvironment,
 	}
@@ -145,17 +173,18 @@ func (d *Daemon) httpGetSync(url string) (*lxd.Response, error) {
 }
 
 func (d *Daemon) httpGetFile(url string) (*http.Response, error) {
-	var err error
-	if d.tlsconfig == nil {
-		d.tlsconfig, err = shared.GetTLSConfig(d.certf, d.keyf)
-		if err != nil {
-			return nil, err
-		}
+	tlsconfig, err := d.clientTLSConfig()
+	if err != nil {
+		return nil, err
 	}
+
+	// Images are already compressed and their size is needed for
+	// tracking the progress of the download
 	tr := &http.Transport{
-		TLSClientConfig: d.tlsconfig,
-		Dial:            shared.RFC3493Dialer,
-		Proxy:           http.ProxyFromEnvironment,
+		TLSClientConfig:    tlsconfig,
+		Dial:               shared.RFC3493Dialer,
+		Proxy:              http.ProxyFromEnvironment,
+		DisableCompression: true,
 	}
 	myhttp := http.Client{
 		Transport: tr,
@@ -195,6 +224,178 @@ func readMyCert() (string, string, error) {
 	return certf, keyf, err
 }
 
+// CertificateLoad (re)loads the server certificate from disk. The HTTPS
+// listeners pick it up for all new connections.
+func (d *Daemon) CertificateLoad() error {
+	cert, err := tls.LoadX509KeyPair(d.certf, d.keyf)
+	if err != nil {
+		return err
+	}
+
+	d.certLock.Lock()
+	d.cert = &cert
+	d.tlsconfig = nil
+	d.certLock.Unlock()
+
+	shared.Log.Info("Loaded the server certificate", log.Ctx{"cert": d.certf})
+	return nil
+}
+
+// clientTLSConfig returns the TLS config of the requests the daemon makes
+// to other servers, presenting the current server certificate.
+func (d *Daemon) clientTLSConfig() (*tls.Config, error) {
+	d.certLock.Lock()
+	defer d.certLock.Unlock()
+
+	if d.tlsconfig == nil {
+		tlsconfig, err := shared.GetTLSConfig(d.certf, d.keyf)
+		if err != nil {
+			return nil, err
+		}
+
+		d.tlsconfig = tlsconfig
+	}
+
+	return d.tlsconfig, nil
+}
+
+// CertificateReplace validates and installs a new server certificate. The
+// certificate and key get renamed in place one after the other, the old
+// certificate being put back if the key can't be, so that the two always
+// match.
+func (d *Daemon) CertificateReplace(certPEM []byte, keyPEM []byte) error {
+	_, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return err
+	}
+
+	d.certReplaceLock.Lock()
+	defer d.certReplaceLock.Unlock()
+
+	oldCert, err := ioutil.ReadFile(d.certf)
+	if err != nil {
+		return err
+	}
+
+	for path, content := range map[string][]byte{d.certf + ".new": certPEM, d.keyf + ".new": keyPEM, d.certf + ".old": oldCert} {
+		err := ioutil.WriteFile(path, content, 0600)
+		if err != nil {
+			os.Remove(d.certf + ".new")
+			os.Remove(d.keyf + ".new")
+			os.Remove(d.certf + ".old")
+			return err
+		}
+	}
+	defer os.Remove(d.certf + ".old")
+
+	err = os.Rename(d.certf+".new", d.certf)
+	if err != nil {
+		os.Remove(d.certf + ".new")
+		os.Remove(d.keyf + ".new")
+		return err
+	}
+
+	err = os.Rename(d.keyf+".new", d.keyf)
+	if err != nil {
+		os.Remove(d.keyf + ".new")
+		os.Rename(d.certf+".old", d.certf)
+		return err
+	}
+
+	return d.CertificateLoad()
+}
+
+// serverTLSConfig returns the TLS configuration for the HTTPS listeners,
+// which always serve the current server certificate.
+func (d *Daemon) serverTLSConfig() (*tls.Config, error) {
+	tlsConfig, err := shared.GetTLSConfig(d.certf, d.keyf)
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig.NextProtos = daemonNextProtos
+	tlsConfig.Certificates = nil
+	tlsConfig.NameToCertificate = nil
+	tlsConfig.GetCertificate = func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+		d.certLock.RLock()
+		defer d.certLock.RUnlock()
+
+		return d.cert, nil
+	}
+
+	return tlsConfig, nil
+}
+
+// listenHTTPs starts listening on an HTTPS address, the default port being
+// used if none is given.
+func (d *Daemon) listenHTTPs(address string) (net.Listener, error) {
+	address = httpsAddressNormalize(address)
+
+	tlsConfig, err := d.serverTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tcpl, err := net.Listen(listenNetwork(address), address)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.NewListener(&proxyListener{Listener: tcpl, trusted: d.proxyTrusted}, tlsConfig), nil
+}
+
+// listenNetwork picks the network to listen on for an address. Go would
+// otherwise bind 0.0.0.0 as a dual-stack socket, only [::] (or no host at
+// all) should get IPv6 and IPv4 clients.
+func listenNetwork(address string) string {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return "tcp"
+	}
+
+	ip := net.ParseIP(host)
+	if ip == nil || (ip.IsUnspecified() && ip.To4() == nil) {
+		return "tcp"
+	}
+
+	if ip.To4() != nil {
+		return "tcp4"
+	}
+
+	return "tcp6"
+}
+
+// serveSocket serves the API on a listener until it gets closed, only the
+// requests allowed by the policy are passed on.
+func (d *Daemon) serveSocket(listener net.Listener, policy string) {
+	handler := uuidHandler(d, httpsPolicyHandler(policy, d.mux))
+
+	d.tomb.Go(func() error {
+		err := http.Serve(listener, handler)
+
+		d.closedSocketsLock.Lock()
+		defer d.closedSocketsLock.Unlock()
+		if d.closedSockets[listener] {
+			delete(d.closedSockets, listener)
+			return nil
+		}
+
+		return err
+	})
+}
+
+// closeSocket stops serving the API on a listener.
+func (d *Daemon) closeSocket(listener net.Listener) {
+	d.closedSocketsLock.Lock()
+	if d.closedSockets == nil {
+		d.closedSockets = map[net.Listener]bool{}
+	}
+	d.closedSockets[listener] = true
+	d.closedSocketsLock.Unlock()
+
+	listener.Close()
+}
+
 func (d *Daemon) isTrustedClient(r *http.Request) bool {
 	if r.RemoteAddr == "@" {
 		// Unix socket
@@ -211,6 +412,49 @@ func (d *Daemon) isTrustedClient(r *http.Request) bool {
 	return false
 }
 
+// isReadOnlyClient returns whether the trusted client of the request was
+// added with a read-only certificate, it may then only GET.
+func (d *Daemon) isReadOnlyClient(r *http.Request) bool {
+	if r.RemoteAddr == "@" || r.TLS == nil {
+		return false
+	}
+
+	readOnly := false
+	for _, cert := range r.TLS.PeerCertificates {
+		if !d.CheckTrustState(*cert) {
+			continue
+		}
+
+		if !d.clientCertsReadOnly[certGenerateFingerprint(cert)] {
+			return false
+		}
+		readOnly = true
+	}
+
+	return readOnly
+}
+
+// requestInitiator identifies the client of a request in the operations
+// history: "unix" for the unix socket, the fingerprint of its trusted
+// certificate otherwise.
+func (d *Daemon) requestInitiator(r *http.Request) string {
+	if r.RemoteAddr == "@" {
+		return "unix"
+	}
+
+	if r.TLS == nil {
+		return ""
+	}
+
+	for _, cert := range r.TLS.PeerCertificates {
+		if d.CheckTrustState(*cert) {
+			return certGenerateFingerprint(cert)
+		}
+	}
+
+	return ""
+}
+
 func isJSONRequest(r *http.Request) bool {
 	for k, vs := range r.Header {
 		if strings.ToLower(k) == "content-type" &&
@@ -223,13 +467,24 @@ func isJSONRequest(r *http.Request) bool {
 }
 
 func (d *Daemon) isRecursionRequest(r *http.Request) bool {
+	return d.recursionLevel(r) >= 1
+}
+
+// recursionLevel returns the value of the recursion parameter, 0 if missing.
+func (d *Daemon) recursionLevel(r *http.Request) int {
 	recursionStr := r.FormValue("recursion")
 	recursion, err := strconv.Atoi(recursionStr)
-	if err != nil {
-		return false
+	if err != nil || recursion < 0 {
+		return 0
 	}
 
-	return recursion == 1
+	return recursion
+}
+
+// isNamesOnlyRequest returns whether a listing should only contain the names
+// of the objects, this is what the shell completion uses.
+func (d *Daemon) isNamesOnlyRequest(r *http.Request) bool {
+	return shared.IsTrue(r.FormValue("names-only"))
 }
 
 func (d *Daemon) createCmd(version string, c Command) {
@@ -243,7 +498,23 @@ func (d *Daemon) createCmd(version string, c Command) {
 	d.mux.HandleFunc(uri, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
+		if !webuiSameOrigin(r) {
+			shared.Log.Warn(
+				"rejecting cross-origin request",
+				log.Ctx{"origin": r.Header.Get("Origin"), "ip": r.RemoteAddr})
+			Forbidden.Render(w)
+			return
+		}
+
 		if d.isTrustedClient(r) {
+			if r.Method != "GET" && d.isReadOnlyClient(r) {
+				shared.Log.Warn(
+					"rejecting request from read-only client",
+					log.Ctx{"method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
+				Forbidden.Render(w)
+				return
+			}
+
 			shared.Log.Info(
 				"handling",
 				log.Ctx{"method": r.Method, "url": r.URL.RequestURI(), "ip": r.RemoteAddr})
@@ -282,7 +553,7 @@ func (d *Daemon) createCmd(version string, c Command) {
 		switch r.Method {
 		case "GET":
 			if c.get != nil {
-				resp = c.get(d, r)
+				resp = etagConditional(r, c.get(d, r))
 			}
 		case "PUT":
 			if c.put != nil {
@@ -300,13 +571,26 @@ func (d *Daemon) createCmd(version string, c Command) {
 			resp = NotFound
 		}
 
-		if err := resp.Render(w); err != nil {
-			err := InternalError(err).Render(w)
+		if opResp, ok := resp.(*operationResponse); ok {
+			opResp.op.record(d, r)
+		}
+
+		// Large answers are compressed when the client supports it
+		rw := newCompressWriter(w, r)
+		if err := resp.Render(rw); err != nil {
+			err := InternalError(err).Render(rw)
 			if err != nil {
 				shared.Log.Error("Failed writing error for error, giving up")
 			}
 		}
 
+		if cw, ok := rw.(*compressWriter); ok {
+			err := cw.Close()
+			if err != nil {
+				shared.Log.Error("Failed compressing the response", log.Ctx{"err": err})
+			}
+		}
+
 		/*
 		 * When we create a new lxc.Container, it adds a finalizer (via
 		 * SetFinalizer) that frees the struct. However, it sometimes
@@ -394,13 +678,41 @@ func (d *Daemon) ListenAddresses() ([]string, error) {
 		return addresses, err
 	}
 
-	localHost, localPort, err := net.SplitHostPort(value)
+	entries, err := httpsAddressesParse(value)
 	if err != nil {
-		localHost = value
-		localPort = shared.DefaultPort
+		return addresses, err
 	}
 
-	if localHost == "0.0.0.0" || localHost == "::" || localHost == "[::]" {
+	for _, entry := range entries {
+		// Restricted listeners aren't advertised
+		if entry.policy != httpsPolicyAll {
+			continue
+		}
+
+		entryAddresses, err := listenAddressesExpand(entry.address)
+		if err != nil {
+			return addresses, err
+		}
+
+		addresses = append(addresses, entryAddresses...)
+	}
+
+	return addresses, nil
+}
+
+// listenAddressesExpand returns the addresses the daemon can be reached on through
+// a listener bound to the given address, wildcards are expanded.
+func listenAddressesExpand(address string) ([]string, error) {
+	addresses := make([]string, 0)
+
+	localHost, localPort, err := net.SplitHostPort(httpsAddressNormalize(address))
+	if err != nil {
+		return addresses, err
+	}
+
+	// A [::] listener is dual-stack, a 0.0.0.0 one is IPv4 only
+	localIP := net.ParseIP(localHost)
+	if localHost == "" || (localIP != nil && localIP.IsUnspecified()) {
 		ifaces, err := net.Interfaces()
 		if err != nil {
 			return addresses, err
@@ -425,18 +737,15 @@ func (d *Daemon) ListenAddresses() ([]string, error) {
 					continue
 				}
 
-				if ip.To4() == nil {
-					if localHost == "0.0.0.0" {
-						continue
-					}
-					addresses = append(addresses, fmt.Sprintf("[%s]:%s", ip, localPort))
-				} else {
-					addresses = append(addresses, fmt.Sprintf("%s:%s", ip, localPort))
+				if ip.To4() == nil && localIP != nil && localIP.To4() != nil {
+					continue
 				}
+
+				addresses = append(addresses, net.JoinHostPort(ip.String(), localPort))
 			}
 		}
 	} else {
-		addresses = append(addresses, fmt.Sprintf("%s:%s", localHost, localPort))
+		addresses = append(addresses, net.JoinHostPort(localHost, localPort))
 	}
 
 	return addresses, nil
@@ -492,71 +801,82 @@ func IpsEqual(ip1 net.IP, ip2 net.IP) bool {
 	return isZeroIP(ip1) && isZeroIP(ip2)
 }
 
+// UpdateHTTPsPort applies a new core.https_address value, listeners for
+// entries which went away are closed and new ones are opened. The unix socket,
+// the unchanged listeners and the requests being served are left alone.
 func (d *Daemon) UpdateHTTPsPort(oldAddress string, newAddress string) error {
 	var sockets []Socket
+	var closed []Socket
+	var created []Socket
 
 	if oldAddress == newAddress {
 		return nil
 	}
 
-	if oldAddress != "" {
-		oldHost, oldPort, err := net.SplitHostPort(oldAddress)
-		if err != nil {
-			oldHost = oldAddress
-			oldPort = shared.DefaultPort
-		}
+	entries, err := httpsAddressesParse(newAddress)
+	if err != nil {
+		return err
+	}
 
-		// Strip brackets around IPv6 once we've gotten rid of the port
-		oldHost = strings.TrimLeft(oldHost, "[")
-		oldHost = strings.TrimRight(oldHost, "]")
+	sdNotify("RELOADING=1")
+	defer sdNotify("READY=1")
 
-		for _, socket := range d.Sockets {
-			host, port, err := net.SplitHostPort(socket.Socket.Addr().String())
-			if err != nil {
-				host = socket.Socket.Addr().String()
-				port = shared.DefaultPort
-			}
+	wanted := map[httpsAddress]bool{}
+	for _, entry := range entries {
+		wanted[entry] = true
+	}
 
-			// Strip brackets around IPv6 once we've gotten rid of the port
-			host = strings.TrimLeft(host, "[")
-			host = strings.TrimRight(host, "]")
+	existing := map[httpsAddress]bool{}
+	for _, socket := range d.Sockets {
+		// The unix socket and socket activated listeners aren't ours
+		if socket.Address == "" {
+			sockets = append(sockets, socket)
+			continue
+		}
 
-			if !shared.PathExists(host) && IpsEqual(net.ParseIP(host), net.ParseIP(oldHost)) && port == oldPort {
-				socket.Socket.Close()
-			} else {
-				sockets = append(sockets, socket)
-			}
+		entry := httpsAddress{address: socket.Address, policy: socket.Policy}
+		if wanted[entry] {
+			existing[entry] = true
+			sockets = append(sockets, socket)
+		} else {
+			closed = append(closed, socket)
 		}
-	} else {
-		sockets = d.Sockets
 	}
 
-	if newAddress != "" {
-		_, _, err := net.SplitHostPort(newAddress)
-		if err != nil {
-			ip := net.ParseIP(newAddress)
-			if ip != nil && ip.To4() == nil {
-				newAddress = fmt.Sprintf("[%s]:%s", newAddress, shared.DefaultPort)
-			} else {
-				newAddress = fmt.Sprintf("%s:%s", newAddress, shared.DefaultPort)
-			}
-		}
+	// The old addresses have to be released first as they may overlap
+	for _, socket := range closed {
+		d.closeSocket(socket.Socket)
+	}
 
-		tlsConfig, err := shared.GetTLSConfig(d.certf, d.keyf)
-		if err != nil {
-			return err
+	for _, entry := range entries {
+		if existing[entry] {
+			continue
 		}
 
-		tcpl, err := tls.Listen("tcp", newAddress, tlsConfig)
+		tcpl, err := d.listenHTTPs(entry.address)
 		if err != nil {
-			return fmt.Errorf("cannot listen on https socket: %v", err)
+			// Try not to leave the daemon unreachable
+			for _, socket := range created {
+				d.closeSocket(socket.Socket)
+			}
+
+			for _, socket := range closed {
+				oldl, oldErr := d.listenHTTPs(socket.Address)
+				if oldErr == nil {
+					d.serveSocket(oldl, socket.Policy)
+					sockets = append(sockets, Socket{Socket: oldl, CloseOnExit: true, Address: socket.Address, Policy: socket.Policy})
+				}
+			}
+
+			d.Sockets = sockets
+			return fmt.Errorf("cannot listen on https socket %s: %v", entry.address, err)
 		}
 
-		d.tomb.Go(func() error { return http.Serve(tcpl, d.mux) })
-		sockets = append(sockets, Socket{Socket: tcpl, CloseOnExit: true})
+		d.serveSocket(tcpl, entry.policy)
+		created = append(created, Socket{Socket: tcpl, CloseOnExit: true, Address: entry.address, Policy: entry.policy})
 	}
 
-	d.Sockets = sockets
+	d.Sockets = append(sockets, created...)
 	return nil
 }
 
@@ -697,11 +1017,21 @@ func (d *Daemon) Init() error {
 		shared.Log.Warn("Couldn't find the CGroup CPU controller, CPU time limits will be ignored.")
 	}
 
+	cgCpuacctController = shared.PathExists("/sys/fs/cgroup/cpuacct/")
+	if !cgCpuacctController {
+		shared.Log.Warn("Couldn't find the CGroup CPUacct controller, idle auto-freeze will be ignored.")
+	}
+
 	cgCpusetController = shared.PathExists("/sys/fs/cgroup/cpuset/")
 	if !cgCpusetController {
 		shared.Log.Warn("Couldn't find the CGroup CPUset controller, CPU pinning will be ignored.")
 	}
 
+	cgFreezerController = freezerDetect()
+	if !cgFreezerController {
+		shared.Log.Warn("Couldn't find the CGroup freezer controller, containers can't be frozen.")
+	}
+
 	cgMemoryController = shared.PathExists("/sys/fs/cgroup/memory/")
 	if !cgMemoryController {
 		shared.Log.Warn("Couldn't find the CGroup memory controller, memory limits will be ignored.")
@@ -712,6 +1042,20 @@ func (d *Daemon) Init() error {
 		shared.Log.Warn("CGroup memory swap accounting is disabled, swap limits will be ignored.")
 	}
 
+	/* Detect seccomp support */
+	seccompAvailable = seccompDetect()
+	if !seccompAvailable {
+		shared.Log.Warn("The kernel doesn't support seccomp, syscall filtering will be disabled.")
+	}
+
+	seccompNotifyAvailable = seccompAvailable && seccompNotifyDetect()
+	if seccompAvailable && !seccompNotifyAvailable {
+		shared.Log.Warn("The kernel or LXC don't support seccomp notifications, syscall interception will be disabled.")
+	}
+
+	/* Detect the other kernel and LXC features */
+	featuresDetect()
+
 	/* Get the list of supported architectures */
 	var architectures = []int{}
 
@@ -759,6 +1103,9 @@ func (d *Daemon) Init() error {
 	if err := os.MkdirAll(shared.VarPath("images"), 0700); err != nil {
 		return err
 	}
+	if err := os.MkdirAll(shared.VarPath("networks"), 0711); err != nil {
+		return err
+	}
 	if err := os.MkdirAll(shared.VarPath("security"), 0700); err != nil {
 		return err
 	}
@@ -818,6 +1165,14 @@ func (d *Daemon) Init() error {
 		return err
 	}
 
+	/* Setup the syscall interception */
+	if seccompNotifyAvailable {
+		d.seccomp, err = seccompServerStart(d)
+		if err != nil {
+			return err
+		}
+	}
+
 	if err := setupSharedMounts(); err != nil {
 		return err
 	}
@@ -829,14 +1184,49 @@ func (d *Daemon) Init() error {
 			return fmt.Errorf("Failed to setup storage: %s", err)
 		}
 
-		/* Restart containers */
+		/* Detect idmapped mounts support */
+		idmappedMountsAvailable = d.idmapDetect()
+		if idmappedMountsAvailable {
+			shared.Log.Info("Using idmapped mounts for the rootfs of unprivileged containers")
+		}
+
+		/* Cleanup leftover ephemeral containers and restart the others */
 		go func() {
+			err := containersCleanupEphemeral(d)
+			if err != nil {
+				shared.Log.Error("Failed to cleanup ephemeral containers", log.Ctx{"err": err})
+			}
+
 			containersRestart(d)
 		}()
 
+		/* Remove the tunnels of the migrations of a previous run */
+		migrationWireGuardCleanup()
+
+		/* Replace the firewall rules of the ACLs of a previous run */
+		err = networkACLReset(d)
+		if err != nil {
+			shared.Log.Warn("Failed to apply the network ACLs", log.Ctx{"err": err})
+		}
+
+		/* Register the names of the containers with the bridges */
+		err = networkDNSSetup(d)
+		if err != nil {
+			shared.Log.Warn("Failed to register the DNS names of the containers", log.Ctx{"err": err})
+		}
+
 		/* Start the scheduler */
 		go deviceTaskScheduler(d)
 
+		/* Start the idle auto-freeze task */
+		go autofreezeTask(d)
+
+		/* Start the expired snapshots pruning task */
+		go snapshotsPruneTask(d)
+
+		/* Start the storage usage monitoring task */
+		go storageMonitorTask(d)
+
 		/* Setup the TLS authentication */
 		certf, keyf, err := readMyCert()
 		if err != nil {
@@ -846,7 +1236,12 @@ func (d *Daemon) Init() error {
 		d.keyf = keyf
 		readSavedClientCAList(d)
 
-		tlsConfig, err = shared.GetTLSConfig(d.certf, d.keyf)
+		err = d.CertificateLoad()
+		if err != nil {
+			return err
+		}
+
+		tlsConfig, err = d.serverTLSConfig()
 		if err != nil {
 			return err
 		}
@@ -868,6 +1263,9 @@ func (d *Daemon) Init() error {
 		d.createCmd("internal", c)
 	}
 
+	d.mux.PathPrefix("/ui/").Handler(webuiHandler(d))
+	d.mux.Handle("/ui", http.RedirectHandler("/ui/", http.StatusMovedPermanently))
+
 	d.mux.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		shared.Log.Debug("Sending top level 404", log.Ctx{"url": r.URL})
 		w.Header().Set("Content-Type", "application/json")
@@ -950,18 +1348,19 @@ func (d *Daemon) Init() error {
 		return err
 	}
 
-	if listenAddr != "" {
-		_, _, err := net.SplitHostPort(listenAddr)
-		if err != nil {
-			listenAddr = fmt.Sprintf("%s:%s", listenAddr, shared.DefaultPort)
-		}
+	entries, err := httpsAddressesParse(listenAddr)
+	if err != nil {
+		shared.Log.Error("invalid core.https_address, skipping...", log.Ctx{"err": err})
+	}
 
-		tcpl, err := tls.Listen("tcp", listenAddr, tlsConfig)
+	for _, entry := range entries {
+		tcpl, err := d.listenHTTPs(entry.address)
 		if err != nil {
-			shared.Log.Error("cannot listen on https socket, skipping...", log.Ctx{"err": err})
-		} else {
-			sockets = append(sockets, Socket{Socket: tcpl, CloseOnExit: true})
+			shared.Log.Error("cannot listen on https socket, skipping...", log.Ctx{"address": entry.address, "err": err})
+			continue
 		}
+
+		sockets = append(sockets, Socket{Socket: tcpl, CloseOnExit: true, Address: entry.address, Policy: entry.policy})
 	}
 
 	if !d.IsMock {
@@ -973,9 +1372,8 @@ func (d *Daemon) Init() error {
 	d.tomb.Go(func() error {
 		shared.Log.Info("REST API daemon:")
 		for _, socket := range d.Sockets {
-			shared.Log.Info(" - binding socket", log.Ctx{"socket": socket.Socket.Addr()})
-			current_socket := socket
-			d.tomb.Go(func() error { return http.Serve(current_socket.Socket, d.mux) })
+			shared.Log.Info(" - binding socket", log.Ctx{"socket": socket.Socket.Addr(), "policy": socket.Policy})
+			d.serveSocket(socket.Socket, socket.Policy)
 		}
 
 		d.tomb.Go(func() error {
@@ -985,6 +1383,11 @@ func (d *Daemon) Init() error {
 		return nil
 	})
 
+	// The API is up, containers may still be starting in the background
+	if !d.IsMock {
+		sdNotify("READY=1")
+	}
+
 	return nil
 }
 
@@ -1053,6 +1456,11 @@ func (d *Daemon) Stop() error {
 	shared.Log.Debug("Stopping /dev/xlxd handler")
 	d.devlxd.Close()
 
+	if d.seccomp != nil {
+		shared.Log.Debug("Stopping the syscall interception")
+		d.seccomp.Stop()
+	}
+
 	if d.IsMock || forceStop {
 		return nil
 	}
@@ -1072,16 +1480,48 @@ func (d *Daemon) ConfigKeyIsValid(key string) bool {
 		return true
 	case "core.trust_password":
 		return true
+	case "core.https_trusted_proxy":
+		return true
+	case "core.tunnel_addresses":
+		return true
+	case "core.webui":
+		return true
+	case "core.operations_history_expiry":
+		return true
+	case "containers.names.leading_digit":
+		return true
+	case "containers.names.reserved":
+		return true
 	case "storage.lvm_vg_name":
 		return true
 	case "storage.lvm_thinpool_name":
 		return true
 	case "storage.zfs_pool_name":
 		return true
+	case "storage.usage_threshold":
+		return true
+	case "storage.usage_refuse_create":
+		return true
 	case "images.remote_cache_expiry":
 		return true
 	case "images.compression_algorithm":
 		return true
+	case "images.compression_level":
+		return true
+	case "images.block_properties":
+		return true
+	case "images.bandwidth_limit":
+		return true
+	case "network.dns.domain":
+		return true
+	case "network.dns.host":
+		return true
+	case "migration.wireguard":
+		return true
+	case "migration.wireguard_address":
+		return true
+	case "migration.bandwidth_limit":
+		return true
 	}
 
 	return false
@@ -6,6 +6,8 @@ import (
 	"io"
 	"mime"
 	"mime/multipart"
+	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 
@@ -52,10 +54,39 @@ func (pt *Progress) Read(p []byte) (int, error) {
 	return n, err
 }
 
+// The /dev/xlxd socket of the host when running in a container.
+const hostDevLxdSock = "/dev/xlxd/sock"
+
+// imageFromHost gets an image from the host when running in a container
+// with security.nesting.share_images, rather than downloading it again.
+func imageFromHost(fp string) (*http.Response, error) {
+	if !shared.PathExists(hostDevLxdSock) {
+		return nil, fmt.Errorf("Not running in a container")
+	}
+
+	client := http.Client{Transport: &http.Transport{
+		Dial: func(network, addr string) (net.Conn, error) {
+			return net.Dial("unix", hostDevLxdSock)
+		},
+	}}
+
+	raw, err := client.Get(fmt.Sprintf("http://unix.socket/1.0/images/%s/export", fp))
+	if err != nil {
+		return nil, err
+	}
+
+	if raw.StatusCode != http.StatusOK {
+		raw.Body.Close()
+		return nil, fmt.Errorf("The host doesn't share the image: %s", raw.Status)
+	}
+
+	return raw, nil
+}
+
 // ImageDownload checks if we have that Image Fingerprint else
 // downloads the image from a remote server.
 func (d *Daemon) ImageDownload(op *operation,
-	server, fp string, secret string, forContainer bool, directDownload bool) error {
+	server, fp string, secret string, forContainer bool, directDownload bool, bwlimit string) error {
 
 	if _, err := dbImageGet(d.db, fp, false, false); err == nil {
 		shared.Log.Debug("Image already exists in the db", log.Ctx{"image": fp})
@@ -63,6 +94,11 @@ func (d *Daemon) ImageDownload(op *operation,
 		return nil
 	}
 
+	limit, err := bandwidthLimit(d, "images.bandwidth_limit", bwlimit)
+	if err != nil {
+		return err
+	}
+
 	shared.Log.Info(
 		"Image not in the db, downloading it",
 		log.Ctx{"image": fp, "server": server})
@@ -160,7 +196,16 @@ func (d *Daemon) ImageDownload(op *operation,
 		}
 	}
 
-	raw, err := d.httpGetFile(exporturl)
+	raw, err := imageFromHost(info.Fingerprint)
+	if err == nil {
+		shared.Log.Info("Using the image of the host", log.Ctx{"image": fp})
+
+		// Not going through the network
+		limit = 0
+	} else {
+		raw, err = d.httpGetFile(exporturl)
+	}
+
 	if err != nil {
 		shared.Log.Error(
 			"Failed to download image",
@@ -180,7 +225,7 @@ func (d *Daemon) ImageDownload(op *operation,
 		ctype = "application/octet-stream"
 	}
 
-	body := &Progress{Reader: raw.Body, length: raw.ContentLength, op: op}
+	body := &Progress{Reader: shared.BandwidthReader(raw.Body, limit), length: raw.ContentLength, op: op}
 
 	if ctype == "multipart/form-data" {
 		// Parse the POST data
@@ -23,6 +23,10 @@ var (
 	 * already do.
 	 */
 	NoSuchObjectError = fmt.Errorf("No such object")
+
+	// DbErrAmbiguousFingerprint happens when a short image fingerprint is
+	// the prefix of several fingerprints.
+	DbErrAmbiguousFingerprint = fmt.Errorf("The fingerprint prefix matches several images, more characters are needed")
 )
 
 // Profile is here to order Profiles.
@@ -34,7 +38,7 @@ type Profile struct {
 // Profiles will contain a list of all Profiles.
 type Profiles []Profile
 
-const DB_CURRENT_VERSION int = 20
+const DB_CURRENT_VERSION int = 26
 
 // CURRENT_SCHEMA contains the current SQLite SQL Schema.
 const CURRENT_SCHEMA string = `
@@ -44,6 +48,7 @@ CREATE TABLE IF NOT EXISTS certificates (
     type INTEGER NOT NULL,
     name VARCHAR(255) NOT NULL,
     certificate TEXT NOT NULL,
+    read_only INTEGER NOT NULL DEFAULT 0,
     UNIQUE (fingerprint)
 );
 CREATE TABLE IF NOT EXISTS config (
@@ -58,6 +63,9 @@ CREATE TABLE IF NOT EXISTS containers (
     architecture INTEGER NOT NULL,
     type INTEGER NOT NULL,
     ephemeral INTEGER NOT NULL DEFAULT 0,
+    creation_date DATETIME NOT NULL DEFAULT 0,
+    expiry_date DATETIME NOT NULL DEFAULT 0,
+    uuid VARCHAR(36) NOT NULL DEFAULT '',
     UNIQUE (name)
 );
 CREATE TABLE IF NOT EXISTS containers_config (
@@ -105,8 +113,30 @@ CREATE TABLE IF NOT EXISTS images (
     expiry_date DATETIME,
     upload_date DATETIME NOT NULL,
     last_use_date DATETIME,
+    uuid VARCHAR(36) NOT NULL DEFAULT '',
     UNIQUE (fingerprint)
 );
+CREATE TABLE IF NOT EXISTS network_acls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    rules TEXT NOT NULL DEFAULT '',
+    networks TEXT NOT NULL DEFAULT '',
+    UNIQUE (name)
+);
+CREATE TABLE IF NOT EXISTS operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    uuid VARCHAR(36) NOT NULL,
+    class VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    initiator VARCHAR(255) NOT NULL DEFAULT '',
+    resources TEXT NOT NULL DEFAULT '',
+    status_code INTEGER NOT NULL,
+    err TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE (uuid)
+);
 CREATE TABLE IF NOT EXISTS images_aliases (
     id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
     name VARCHAR(255) NOT NULL,
@@ -14,13 +14,14 @@ type dbCertInfo struct {
 	Type        int
 	Name        string
 	Certificate string
+	ReadOnly    bool
 }
 
 // dbCertsGet returns all certificates from the DB as CertBaseInfo objects.
 func dbCertsGet(db *sql.DB) (certs []*dbCertInfo, err error) {
 	rows, err := dbQuery(
 		db,
-		"SELECT id, fingerprint, type, name, certificate FROM certificates",
+		"SELECT id, fingerprint, type, name, certificate, read_only FROM certificates",
 	)
 	if err != nil {
 		return certs, err
@@ -36,6 +37,7 @@ func dbCertsGet(db *sql.DB) (certs []*dbCertInfo, err error) {
 			&cert.Type,
 			&cert.Name,
 			&cert.Certificate,
+			&cert.ReadOnly,
 		)
 		certs = append(certs, cert)
 	}
@@ -58,11 +60,12 @@ func dbCertGet(db *sql.DB, fingerprint string) (cert *dbCertInfo, err error) {
 		&cert.Type,
 		&cert.Name,
 		&cert.Certificate,
+		&cert.ReadOnly,
 	}
 
 	query := `
 		SELECT
-			id, fingerprint, type, name, certificate
+			id, fingerprint, type, name, certificate, read_only
 		FROM
 			certificates
 		WHERE fingerprint LIKE ?`
@@ -86,8 +89,9 @@ func dbCertSave(db *sql.DB, cert *dbCertInfo) error {
 				fingerprint,
 				type,
 				name,
-				certificate
-			) VALUES (?, ?, ?, ?)`,
+				certificate,
+				read_only
+			) VALUES (?, ?, ?, ?, ?)`,
 	)
 	if err != nil {
 		tx.Rollback()
@@ -99,6 +103,7 @@ func dbCertSave(db *sql.DB, cert *dbCertInfo) error {
 		cert.Type,
 		cert.Name,
 		cert.Certificate,
+		cert.ReadOnly,
 	)
 	if err != nil {
 		tx.Rollback()
@@ -3,6 +3,9 @@ package main
 import (
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/pborman/uuid"
 
 	"github.com/krschwab/xlxd/shared"
 
@@ -60,19 +63,35 @@ func dbContainerId(db *sql.DB, name string) (int, error) {
 	return id, err
 }
 
+// dbContainerNameFromUUID returns the name of the container, or snapshot,
+// with the UUID.
+func dbContainerNameFromUUID(db *sql.DB, containerUUID string) (string, error) {
+	q := "SELECT name FROM containers WHERE uuid=?"
+	name := ""
+	arg1 := []interface{}{containerUUID}
+	arg2 := []interface{}{&name}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	return name, err
+}
+
 func dbContainerGet(db *sql.DB, name string) (containerArgs, error) {
 	args := containerArgs{}
 	args.Name = name
 
 	ephemInt := -1
-	q := "SELECT id, architecture, type, ephemeral FROM containers WHERE name=?"
+	q := "SELECT id, architecture, type, ephemeral, creation_date, expiry_date, uuid FROM containers WHERE name=?"
 	arg1 := []interface{}{name}
-	arg2 := []interface{}{&args.Id, &args.Architecture, &args.Ctype, &ephemInt}
+	arg2 := []interface{}{&args.Id, &args.Architecture, &args.Ctype, &ephemInt, &args.CreationDate, &args.ExpiryDate, &args.UUID}
 	err := dbQueryRowScan(db, q, arg1, arg2)
 	if err != nil {
 		return args, err
 	}
 
+	// A zero expiry date means the snapshot never expires
+	if args.ExpiryDate.Unix() == 0 {
+		args.ExpiryDate = time.Time{}
+	}
+
 	if args.Id == -1 {
 		return args, fmt.Errorf("Unknown container")
 	}
@@ -123,14 +142,27 @@ func dbContainerCreate(db *sql.DB, args containerArgs) (int, error) {
 		ephemInt = 1
 	}
 
-	str := fmt.Sprintf("INSERT INTO containers (name, architecture, type, ephemeral) VALUES (?, ?, ?, ?)")
+	if args.CreationDate.IsZero() {
+		args.CreationDate = time.Now().UTC()
+	}
+
+	expiry := int64(0)
+	if !args.ExpiryDate.IsZero() {
+		expiry = args.ExpiryDate.Unix()
+	}
+
+	if args.UUID == "" {
+		args.UUID = uuid.NewRandom().String()
+	}
+
+	str := fmt.Sprintf("INSERT INTO containers (name, architecture, type, ephemeral, creation_date, expiry_date, uuid) VALUES (?, ?, ?, ?, ?, ?, ?)")
 	stmt, err := tx.Prepare(str)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
 	}
 	defer stmt.Close()
-	result, err := stmt.Exec(args.Name, args.Architecture, args.Ctype, ephemInt)
+	result, err := stmt.Exec(args.Name, args.Architecture, args.Ctype, ephemInt, args.CreationDate.Unix(), expiry, args.UUID)
 	if err != nil {
 		tx.Rollback()
 		return 0, err
@@ -300,6 +332,55 @@ func dbContainersList(db *sql.DB, cType containerType) ([]string, error) {
 	return ret, nil
 }
 
+func dbContainersListEphemeral(db *sql.DB) ([]string, error) {
+	q := fmt.Sprintf("SELECT name FROM containers WHERE type=? AND ephemeral=1 ORDER BY name")
+	inargs := []interface{}{cTypeRegular}
+	var container string
+	outfmt := []interface{}{container}
+	result, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, container := range result {
+		ret = append(ret, container[0].(string))
+	}
+
+	return ret, nil
+}
+
+// dbSnapshotsExpired returns the snapshots which expired by now.
+func dbSnapshotsExpired(db *sql.DB, now time.Time) ([]string, error) {
+	q := "SELECT name FROM containers WHERE type=? AND expiry_date>0 AND expiry_date<=? ORDER BY name"
+	inargs := []interface{}{cTypeSnapshot, now.Unix()}
+	var container string
+	outfmt := []interface{}{container}
+	result, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []string
+	for _, container := range result {
+		ret = append(ret, container[0].(string))
+	}
+
+	return ret, nil
+}
+
+// dbSnapshotSetExpiry changes when a snapshot expires, never if expiry is
+// the zero time.
+func dbSnapshotSetExpiry(db *sql.DB, id int, expiry time.Time) error {
+	value := int64(0)
+	if !expiry.IsZero() {
+		value = expiry.Unix()
+	}
+
+	_, err := dbExec(db, "UPDATE containers SET expiry_date=? WHERE id=?", value, id)
+	return err
+}
+
 func dbContainerRename(db *sql.DB, oldName string, newName string) error {
 	tx, err := dbBegin(db)
 	if err != nil {
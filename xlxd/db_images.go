@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"strings"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -10,9 +11,9 @@ import (
 )
 
 func dbImagesGet(db *sql.DB, public bool) ([]string, error) {
-	q := "SELECT fingerprint FROM images"
+	q := "SELECT fingerprint FROM images ORDER BY fingerprint"
 	if public == true {
-		q = "SELECT fingerprint FROM images WHERE public=1"
+		q = "SELECT fingerprint FROM images WHERE public=1 ORDER BY fingerprint"
 	}
 
 	var fp string
@@ -31,45 +32,91 @@ func dbImagesGet(db *sql.DB, public bool) ([]string, error) {
 	return results, nil
 }
 
+// dbImageFingerprintFromUUID returns the fingerprint of the image with the
+// UUID.
+func dbImageFingerprintFromUUID(db *sql.DB, imageUUID string) (string, error) {
+	q := "SELECT fingerprint FROM images WHERE uuid=?"
+	fingerprint := ""
+	arg1 := []interface{}{imageUUID}
+	arg2 := []interface{}{&fingerprint}
+	err := dbQueryRowScan(db, q, arg1, arg2)
+	return fingerprint, err
+}
+
+// dbImageFingerprint returns the full fingerprint of the image the given
+// fingerprint is the short form of, failing with DbErrAmbiguousFingerprint if
+// several images start with it.
+func dbImageFingerprint(db *sql.DB, fingerprint string, public bool) (string, error) {
+	if fingerprint == "" {
+		return "", sql.ErrNoRows
+	}
+
+	// The prefix is taken as is, not as a pattern
+	escaper := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+	prefix := escaper.Replace(fingerprint)
+
+	q := `SELECT fingerprint FROM images WHERE fingerprint LIKE ? ESCAPE '\'`
+	if public {
+		q = q + " AND public=1"
+	}
+
+	var fp string
+	inargs := []interface{}{prefix + "%"}
+	outfmt := []interface{}{fp}
+	results, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return "", err
+	}
+
+	if len(results) == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	for _, r := range results {
+		if r[0].(string) == fingerprint {
+			return fingerprint, nil
+		}
+	}
+
+	if len(results) > 1 {
+		return "", DbErrAmbiguousFingerprint
+	}
+
+	return results[0][0].(string), nil
+}
+
 // dbImageGet gets an ImageBaseInfo object from the database.
-// The argument fingerprint will be queried with a LIKE query, means you can
-// pass a shortform and will get the full fingerprint.
+// Without strictMatching, the argument fingerprint may be the shortform of
+// the fingerprint of a single image, see dbImageFingerprint.
 // There can never be more than one image with a given fingerprint, as it is
 // enforced by a UNIQUE constraint in the schema.
 func dbImageGet(db *sql.DB, fingerprint string, public bool, strictMatching bool) (*shared.ImageBaseInfo, error) {
 	var err error
 	var create, expire, upload *time.Time // These hold the db-returned times
 
+	if !strictMatching {
+		fingerprint, err = dbImageFingerprint(db, fingerprint, public)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	// The object we'll actually return
 	image := new(shared.ImageBaseInfo)
 
 	// These two humongous things will be filled by the call to DbQueryRowScan
 	outfmt := []interface{}{&image.Id, &image.Fingerprint, &image.Filename,
 		&image.Size, &image.Public, &image.Architecture,
-		&create, &expire, &upload}
-
-	var query string
+		&create, &expire, &upload, &image.UUID}
 
-	var inargs []interface{}
-	if strictMatching {
-		inargs = []interface{}{fingerprint}
-		query = `
+	inargs := []interface{}{fingerprint}
+	query := `
         SELECT
             id, fingerprint, filename, size, public, architecture,
-            creation_date, expiry_date, upload_date
+            creation_date, expiry_date, upload_date, uuid
         FROM
             images
         WHERE fingerprint = ?`
-	} else {
-		inargs = []interface{}{fingerprint + "%"}
-		query = `
-        SELECT
-            id, fingerprint, filename, size, public, architecture,
-            creation_date, expiry_date, upload_date
-        FROM
-            images
-        WHERE fingerprint LIKE ?`
-	}
 
 	if public {
 		query = query + " AND public=1"
@@ -98,6 +145,27 @@ func dbImageGet(db *sql.DB, fingerprint string, public bool, strictMatching bool
 	return image, nil
 }
 
+// dbImagePropertiesGet returns the properties of the image with the ID.
+func dbImagePropertiesGet(db *sql.DB, id int) (map[string]string, error) {
+	var key, value string
+	q := "SELECT key, value FROM images_properties where image_id=?"
+	inargs := []interface{}{id}
+	outfmt := []interface{}{key, value}
+	results, err := dbQueryScan(db, q, inargs, outfmt)
+	if err != nil {
+		return nil, err
+	}
+
+	properties := map[string]string{}
+	for _, r := range results {
+		key = r[0].(string)
+		value = r[1].(string)
+		properties[key] = value
+	}
+
+	return properties, nil
+}
+
 func dbImageDelete(db *sql.DB, id int) error {
 	tx, err := dbBegin(db)
 	if err != nil {
@@ -157,6 +225,13 @@ func dbImageAliasAdd(db *sql.DB, name string, imageID int, desc string) error {
 	return err
 }
 
+// Point an existing alias to another image or change its description.
+func dbImageAliasUpdate(db *sql.DB, name string, imageID int, desc string) error {
+	stmt := `UPDATE images_aliases SET image_id=?, description=? WHERE name=?`
+	_, err := dbExec(db, stmt, imageID, desc, name)
+	return err
+}
+
 func dbImageLastAccessUpdate(db *sql.DB, fingerprint string) error {
 	stmt := `UPDATE images SET last_use_date=strftime("%s") WHERE fingerprint=?`
 	_, err := dbExec(db, stmt, fingerprint)
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+const dbNetworkACLColumns = "name, description, rules, networks"
+
+// dbNetworkACLScan fills an ACL from a row of dbNetworkACLColumns, the rules
+// and networks being stored as JSON.
+func dbNetworkACLScan(scan func(dest ...interface{}) error) (*shared.NetworkACL, error) {
+	acl := shared.NetworkACL{Rules: []shared.NetworkACLRule{}, Networks: []string{}}
+	rules := ""
+	networks := ""
+
+	err := scan(&acl.Name, &acl.Description, &rules, &networks)
+	if err != nil {
+		return nil, err
+	}
+
+	if rules != "" {
+		err = json.Unmarshal([]byte(rules), &acl.Rules)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if networks != "" {
+		err = json.Unmarshal([]byte(networks), &acl.Networks)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &acl, nil
+}
+
+// dbNetworkACLs returns all the ACLs, by name.
+func dbNetworkACLs(db *sql.DB) ([]*shared.NetworkACL, error) {
+	rows, err := dbQuery(db, "SELECT "+dbNetworkACLColumns+" FROM network_acls ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	acls := []*shared.NetworkACL{}
+	for rows.Next() {
+		acl, err := dbNetworkACLScan(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+
+		acls = append(acls, acl)
+	}
+
+	return acls, rows.Err()
+}
+
+// dbNetworkACLGet returns the ACL with the name.
+func dbNetworkACLGet(db *sql.DB, name string) (*shared.NetworkACL, error) {
+	rows, err := dbQuery(db, "SELECT "+dbNetworkACLColumns+" FROM network_acls WHERE name=?", name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, NoSuchObjectError
+	}
+
+	return dbNetworkACLScan(rows.Scan)
+}
+
+// dbNetworkACLCreate adds an ACL, failing if its name is taken.
+func dbNetworkACLCreate(db *sql.DB, acl *shared.NetworkACL) error {
+	rules, networks, err := dbNetworkACLEncode(acl)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(db, "INSERT INTO network_acls ("+dbNetworkACLColumns+") VALUES (?, ?, ?, ?)",
+		acl.Name, acl.Description, rules, networks)
+	return err
+}
+
+// dbNetworkACLUpdate replaces the description, rules and networks of an ACL.
+func dbNetworkACLUpdate(db *sql.DB, acl *shared.NetworkACL) error {
+	rules, networks, err := dbNetworkACLEncode(acl)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(db, "UPDATE network_acls SET description=?, rules=?, networks=? WHERE name=?",
+		acl.Description, rules, networks, acl.Name)
+	return err
+}
+
+func dbNetworkACLDelete(db *sql.DB, name string) error {
+	_, err := dbExec(db, "DELETE FROM network_acls WHERE name=?", name)
+	return err
+}
+
+func dbNetworkACLEncode(acl *shared.NetworkACL) (string, string, error) {
+	rules, err := json.Marshal(acl.Rules)
+	if err != nil {
+		return "", "", err
+	}
+
+	networks, err := json.Marshal(acl.Networks)
+	if err != nil {
+		return "", "", err
+	}
+
+	return string(rules), string(networks), nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+const dbOperationColumns = "uuid, class, description, initiator, resources, status_code, err, created_at, updated_at"
+
+// dbOperationScan fills an operation of the history from a row of
+// dbOperationColumns.
+func dbOperationScan(scan func(dest ...interface{}) error) (*shared.Operation, error) {
+	op := shared.Operation{}
+	resources := ""
+
+	err := scan(&op.Id, &op.Class, &op.Description, &op.Initiator, &resources, &op.StatusCode, &op.Err, &op.CreatedAt, &op.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	op.Status = op.StatusCode.String()
+	if resources != "" {
+		err = json.Unmarshal([]byte(resources), &op.Resources)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &op, nil
+}
+
+// dbOperationSave adds a completed operation to the history. Its metadata
+// isn't kept, it may hold secrets.
+func dbOperationSave(db *sql.DB, op *shared.Operation) error {
+	resources, err := json.Marshal(op.Resources)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbExec(db, "INSERT OR REPLACE INTO operations ("+dbOperationColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		op.Id, op.Class, op.Description, op.Initiator, string(resources), op.StatusCode, op.Err,
+		op.CreatedAt.Unix(), op.UpdatedAt.Unix())
+	return err
+}
+
+// dbOperationGet returns the operation of the history with the UUID.
+func dbOperationGet(db *sql.DB, id string) (*shared.Operation, error) {
+	rows, err := dbQuery(db, "SELECT "+dbOperationColumns+" FROM operations WHERE uuid=?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, NoSuchObjectError
+	}
+
+	return dbOperationScan(rows.Scan)
+}
+
+// dbOperationsGet returns the operations of the history created since the
+// given time, oldest first.
+func dbOperationsGet(db *sql.DB, since time.Time) ([]*shared.Operation, error) {
+	rows, err := dbQuery(db, "SELECT "+dbOperationColumns+" FROM operations WHERE created_at >= ? ORDER BY created_at, id", since.Unix())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ops := []*shared.Operation{}
+	for rows.Next() {
+		op, err := dbOperationScan(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+
+		ops = append(ops, op)
+	}
+
+	return ops, rows.Err()
+}
+
+// dbOperationsPrune removes the operations of the history which were done
+// before the given time.
+func dbOperationsPrune(db *sql.DB, before time.Time) error {
+	_, err := dbExec(db, "DELETE FROM operations WHERE updated_at < ?", before.Unix())
+	return err
+}
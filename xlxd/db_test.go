@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/krschwab/xlxd/shared"
 	"github.com/krschwab/xlxd/shared/logging"
@@ -444,6 +445,39 @@ func Test_dbImageGet_for_missing_fingerprint(t *testing.T) {
 	}
 }
 
+func Test_dbImageGet_for_ambiguous_fingerprint(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	_, err = db.Exec("INSERT INTO images (fingerprint, filename, size, architecture, upload_date) VALUES ('finger_other', 'filename', 1024, 0, 1431547176);")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dbImageGet(db, "finger", false, false)
+	if err != DbErrAmbiguousFingerprint {
+		t.Fatal(fmt.Sprintf("Ambiguous prefix wasn't refused: %v", err))
+	}
+
+	// Wildcards of LIKE are just characters
+	result, err := dbImageGet(db, "finger_", false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Fingerprint != "finger_other" {
+		t.Fatal(fmt.Sprintf("Wrong image for the prefix: %s", result.Fingerprint))
+	}
+
+	_, err = dbImageGet(db, "%", false, false)
+	if err != sql.ErrNoRows {
+		t.Fatal("Wildcard prefix matched")
+	}
+}
+
 func Test_dbImageAliasGet_alias_exists(t *testing.T) {
 	var db *sql.DB
 	var err error
@@ -625,3 +659,257 @@ func Test_dbDevices_containers(t *testing.T) {
 	}
 
 }
+
+func Test_dbContainersListEphemeral(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	statements := `
+    INSERT INTO containers (name, architecture, type, ephemeral) VALUES ('eph', 1, 0, 1);
+    INSERT INTO containers (name, architecture, type, ephemeral) VALUES ('eph/snap0', 1, 1, 1);
+    INSERT INTO containers (name, architecture, type, ephemeral) VALUES ('regular', 1, 0, 0);`
+	_, err = db.Exec(statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := dbContainersListEphemeral(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || result[0] != "eph" {
+		t.Fatal(fmt.Sprintf("Unexpected ephemeral containers: %v", result))
+	}
+}
+
+func Test_dbContainerGet_creation_date(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	args := containerArgs{
+		Name:         "dated",
+		Architecture: 1,
+		Ctype:        cTypeRegular,
+		CreationDate: time.Unix(1431547174, 0),
+	}
+
+	_, err = dbContainerCreate(db, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := dbContainerGet(db, "dated")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.CreationDate.Unix() != 1431547174 {
+		t.Fatal(fmt.Sprintf("Unexpected creation date: %v", result.CreationDate))
+	}
+
+	// Containers from before creation dates were recorded don't have one
+	result, err = dbContainerGet(db, "thename")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.CreationDate.Unix() != 0 {
+		t.Fatal(fmt.Sprintf("Unexpected creation date: %v", result.CreationDate))
+	}
+}
+
+func Test_dbSnapshotsExpired(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	statements := `
+    INSERT INTO containers (name, architecture, type, expiry_date) VALUES ('c1/expired', 1, 1, 1431547174);
+    INSERT INTO containers (name, architecture, type, expiry_date) VALUES ('c1/later', 1, 1, 1431547176);
+    INSERT INTO containers (name, architecture, type) VALUES ('c1/never', 1, 1);`
+	_, err = db.Exec(statements)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := dbSnapshotsExpired(db, time.Unix(1431547175, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || result[0] != "c1/expired" {
+		t.Fatal(fmt.Sprintf("Unexpected expired snapshots: %v", result))
+	}
+
+	id, err := dbContainerId(db, "c1/later")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = dbSnapshotSetExpiry(db, id, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = dbSnapshotsExpired(db, time.Unix(1431547177, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result) != 1 || result[0] != "c1/expired" {
+		t.Fatal(fmt.Sprintf("Unexpected expired snapshots: %v", result))
+	}
+}
+
+func Test_dbCertSave_read_only(t *testing.T) {
+	db := createTestDb(t)
+	defer db.Close()
+
+	err := dbCertSave(db, &dbCertInfo{Fingerprint: "abcdef", Type: 1, Name: "dashboard", Certificate: "cert", ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := dbCertGet(db, "abc")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !cert.ReadOnly {
+		t.Fatal("The certificate isn't read-only")
+	}
+
+	certs, err := dbCertsGet(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(certs) != 1 || !certs[0].ReadOnly {
+		t.Fatal(fmt.Sprintf("Unexpected certificates: %v", certs))
+	}
+}
+
+func Test_dbContainerGet_uuid(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	args := containerArgs{
+		Name:         "tracked",
+		Architecture: 1,
+		Ctype:        cTypeRegular,
+	}
+
+	_, err = dbContainerCreate(db, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := dbContainerGet(db, "tracked")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(result.UUID) != 36 {
+		t.Fatal(fmt.Sprintf("Unexpected UUID: %q", result.UUID))
+	}
+
+	// The UUID stays the same across renames
+	err = dbContainerRename(db, "tracked", "renamed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, err := dbContainerNameFromUUID(db, result.UUID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if name != "renamed" {
+		t.Fatal(fmt.Sprintf("Unexpected container for the UUID: %s", name))
+	}
+
+	_, err = dbContainerNameFromUUID(db, "00000000-0000-0000-0000-000000000000")
+	if err != sql.ErrNoRows {
+		t.Fatal(fmt.Sprintf("Unexpected error for an unknown UUID: %v", err))
+	}
+}
+
+func Test_dbOperationSave(t *testing.T) {
+	var db *sql.DB
+	var err error
+
+	db = createTestDb(t)
+	defer db.Close()
+
+	now := time.Now()
+	op := &shared.Operation{
+		Id:          "4b1c6bb8-b6a0-44b8-9f1c-4d1a8c3a3b7e",
+		Class:       "task",
+		Description: "POST /1.0/containers",
+		Initiator:   "unix",
+		CreatedAt:   now.Add(-time.Hour),
+		UpdatedAt:   now.Add(-time.Hour),
+		Status:      shared.Failure.String(),
+		StatusCode:  shared.Failure,
+		Resources:   map[string][]string{"containers": []string{"/1.0/containers/c1"}},
+		Err:         "some error",
+	}
+
+	err = dbOperationSave(db, op)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := dbOperationGet(db, op.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if result.Status != "Failure" || result.Err != "some error" || result.Initiator != "unix" {
+		t.Fatal(fmt.Sprintf("Unexpected operation: %+v", result))
+	}
+
+	if len(result.Resources["containers"]) != 1 || result.Resources["containers"][0] != "/1.0/containers/c1" {
+		t.Fatal(fmt.Sprintf("Unexpected resources: %v", result.Resources))
+	}
+
+	ops, err := dbOperationsGet(db, now.Add(-2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 1 {
+		t.Fatal(fmt.Sprintf("Unexpected operations: %d", len(ops)))
+	}
+
+	ops, err = dbOperationsGet(db, now.Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ops) != 0 {
+		t.Fatal(fmt.Sprintf("Operations created before the date were listed: %d", len(ops)))
+	}
+
+	err = dbOperationsPrune(db, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = dbOperationGet(db, op.Id)
+	if err != NoSuchObjectError {
+		t.Fatal(fmt.Sprintf("The operation wasn't pruned: %v", err))
+	}
+}
@@ -10,11 +10,101 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/pborman/uuid"
+
 	"github.com/krschwab/xlxd/shared"
 
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
+func dbUpdateFromV25(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS network_acls (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    name VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    rules TEXT NOT NULL DEFAULT '',
+    networks TEXT NOT NULL DEFAULT '',
+    UNIQUE (name)
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 26)
+	return err
+}
+
+func dbUpdateFromV24(db *sql.DB) error {
+	stmt := `
+CREATE TABLE IF NOT EXISTS operations (
+    id INTEGER PRIMARY KEY AUTOINCREMENT NOT NULL,
+    uuid VARCHAR(36) NOT NULL,
+    class VARCHAR(255) NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    initiator VARCHAR(255) NOT NULL DEFAULT '',
+    resources TEXT NOT NULL DEFAULT '',
+    status_code INTEGER NOT NULL,
+    err TEXT NOT NULL DEFAULT '',
+    created_at DATETIME NOT NULL,
+    updated_at DATETIME NOT NULL,
+    UNIQUE (uuid)
+);
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 25)
+	return err
+}
+
+func dbUpdateFromV23(db *sql.DB) error {
+	stmt := `
+ALTER TABLE containers ADD COLUMN uuid VARCHAR(36) NOT NULL DEFAULT '';
+ALTER TABLE images ADD COLUMN uuid VARCHAR(36) NOT NULL DEFAULT '';`
+	_, err := db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+
+	// Give the existing containers and images their UUID
+	for _, table := range []string{"containers", "images"} {
+		var id int
+		rows, err := dbQueryScan(db, fmt.Sprintf("SELECT id FROM %s", table), nil, []interface{}{id})
+		if err != nil {
+			return err
+		}
+
+		for _, row := range rows {
+			_, err = db.Exec(fmt.Sprintf("UPDATE %s SET uuid=? WHERE id=?", table), uuid.NewRandom().String(), row[0].(int))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err = db.Exec("INSERT INTO schema (version, updated_at) VALUES (?, strftime(\"%s\"));", 24)
+	return err
+}
+
+func dbUpdateFromV22(db *sql.DB) error {
+	stmt := `
+ALTER TABLE certificates ADD COLUMN read_only INTEGER NOT NULL DEFAULT 0;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 23)
+	return err
+}
+
+func dbUpdateFromV21(db *sql.DB) error {
+	stmt := `
+ALTER TABLE containers ADD COLUMN expiry_date DATETIME NOT NULL DEFAULT 0;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 22)
+	return err
+}
+
+func dbUpdateFromV20(db *sql.DB) error {
+	stmt := `
+ALTER TABLE containers ADD COLUMN creation_date DATETIME NOT NULL DEFAULT 0;
+INSERT INTO schema (version, updated_at) VALUES (?, strftime("%s"));`
+	_, err := db.Exec(stmt, 21)
+	return err
+}
+
 func dbUpdateFromV19(db *sql.DB) error {
 	stmt := `
 DELETE FROM containers_config WHERE container_id NOT IN (SELECT id FROM containers);
@@ -840,6 +930,42 @@ func dbUpdate(d *Daemon, prevVersion int) error {
 			return err
 		}
 	}
+	if prevVersion < 21 {
+		err = dbUpdateFromV20(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 22 {
+		err = dbUpdateFromV21(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 23 {
+		err = dbUpdateFromV22(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 24 {
+		err = dbUpdateFromV23(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 25 {
+		err = dbUpdateFromV24(db)
+		if err != nil {
+			return err
+		}
+	}
+	if prevVersion < 26 {
+		err = dbUpdateFromV25(db)
+		if err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
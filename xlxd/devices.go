@@ -157,6 +157,7 @@ func deviceTaskBalance(d *Daemon) {
 	containers, err := dbContainersList(d.db, cTypeRegular)
 	fixedContainers := map[int][]container{}
 	balancedContainers := map[container]int{}
+	balancedNodeCPUs := map[container][]int{}
 	for _, name := range containers {
 		c, err := containerLoadByName(d, name)
 		if err != nil {
@@ -164,8 +165,26 @@ func deviceTaskBalance(d *Daemon) {
 		}
 
 		conf := c.ExpandedConfig()
+
+		// The CPUs of its NUMA nodes if any, all of them otherwise
+		nodeCPUs := cpus
+		if conf["limits.cpu.nodes"] != "" {
+			nodeCPUs, err = deviceNUMANodeCPUs(conf["limits.cpu.nodes"])
+			if err != nil {
+				shared.Log.Error("Invalid limits.cpu.nodes value.", log.Ctx{"container": c.Name(), "value": conf["limits.cpu.nodes"], "err": err})
+				nodeCPUs = cpus
+			}
+		}
+
 		cpu, ok := conf["limits.cpu"]
-		if !ok || cpu == "" {
+		if (!ok || cpu == "") && conf["limits.cpu.nodes"] != "" {
+			// Pinned to the CPUs of its NUMA nodes
+			ids := []string{}
+			for _, id := range nodeCPUs {
+				ids = append(ids, strconv.Itoa(id))
+			}
+			cpu = strings.Join(ids, ",")
+		} else if !ok || cpu == "" {
 			currentCPUs, err := deviceGetCurrentCPUs()
 			if err != nil {
 				shared.Debugf("Couldn't get current CPU list: %s", err)
@@ -175,15 +194,17 @@ func deviceTaskBalance(d *Daemon) {
 			}
 		}
 
-		if !c.IsRunning() {
+		// Virtual machines get their CPUs when they boot
+		if !c.IsRunning() || c.IsVM() {
 			continue
 		}
 
 		count, err := strconv.Atoi(cpu)
 		if err == nil {
-			// Load-balance
-			count = min(count, len(cpus))
+			// Load-balance, within the CPUs of its NUMA nodes
+			count = min(count, len(nodeCPUs))
 			balancedContainers[c] = count
+			balancedNodeCPUs[c] = nodeCPUs
 		} else {
 			// Pinned
 			chunks := strings.Split(cpu, ",")
@@ -209,7 +230,7 @@ func deviceTaskBalance(d *Daemon) {
 					}
 
 					for i := low; i <= high; i++ {
-						if !shared.IntInSlice(i, cpus) {
+						if !shared.IntInSlice(i, cpus) || !shared.IntInSlice(i, nodeCPUs) {
 							continue
 						}
 
@@ -228,7 +249,7 @@ func deviceTaskBalance(d *Daemon) {
 						continue
 					}
 
-					if !shared.IntInSlice(nr, cpus) {
+					if !shared.IntInSlice(nr, cpus) || !shared.IntInSlice(nr, nodeCPUs) {
 						continue
 					}
 
@@ -272,10 +293,15 @@ func deviceTaskBalance(d *Daemon) {
 
 	for ctn, count := range balancedContainers {
 		sort.Sort(usage)
+		nodeCPUs := balancedNodeCPUs[ctn]
 		for _, cpu := range usage {
 			if count == 0 {
 				break
 			}
+
+			if !shared.IntInSlice(cpu.id, nodeCPUs) {
+				continue
+			}
 			count -= 1
 
 			id := cpu.strId
@@ -299,6 +325,63 @@ func deviceTaskBalance(d *Daemon) {
 	}
 }
 
+// deviceNUMANodeCPUs returns the CPUs of a list of NUMA nodes like "0" or
+// "0-1", failing if one of the nodes doesn't exist.
+func deviceNUMANodeCPUs(nodes string) ([]int, error) {
+	ids, err := parseCPUList(nodes)
+	if err != nil || len(ids) == 0 {
+		return nil, fmt.Errorf("Invalid NUMA node list: %s", nodes)
+	}
+
+	cpus := []int{}
+	for _, id := range ids {
+		nodePath := fmt.Sprintf("/sys/devices/system/node/node%d", id)
+		if !shared.PathExists(nodePath) {
+			return nil, fmt.Errorf("NUMA node %d doesn't exist", id)
+		}
+
+		nodeCPUs, err := parseCPUList(readSysString(path.Join(nodePath, "cpulist")))
+		if err != nil {
+			return nil, err
+		}
+
+		cpus = append(cpus, nodeCPUs...)
+	}
+
+	return cpus, nil
+}
+
+// deviceNUMALimitsCheck checks that the NUMA nodes exist and that the CPUs
+// limits.cpu pins to, when it's a list rather than a count, are theirs.
+func deviceNUMALimitsCheck(cpu string, nodes string) error {
+	if nodes == "" {
+		return nil
+	}
+
+	nodeCPUs, err := deviceNUMANodeCPUs(nodes)
+	if err != nil {
+		return err
+	}
+
+	_, err = strconv.Atoi(cpu)
+	if cpu == "" || err == nil {
+		return nil
+	}
+
+	ids, err := parseCPUList(cpu)
+	if err != nil {
+		return fmt.Errorf("Invalid limits.cpu value: %s", cpu)
+	}
+
+	for _, id := range ids {
+		if !shared.IntInSlice(id, nodeCPUs) {
+			return fmt.Errorf("CPU %d isn't on the NUMA nodes %s", id, nodes)
+		}
+	}
+
+	return nil
+}
+
 func deviceGetCurrentCPUs() (string, error) {
 	// Open /proc/self/status
 	f, err := os.Open("/proc/self/status")
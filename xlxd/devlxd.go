@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net"
@@ -69,16 +70,67 @@ var metadataGet = devLxdHandler{"/1.0/meta-data", func(c container, r *http.Requ
 	return okResponse(fmt.Sprintf("#cloud-config\ninstance-id: %s\nlocal-hostname: %s\n%s", c.Name(), c.Name(), value), "raw")
 }}
 
+// stateSet lets the container tell when it's done booting, which is what
+// user.ready-signal containers are waited on for.
+func stateSet(c container, r *http.Request) *devLxdResponse {
+	req := struct {
+		State string `json:"state"`
+	}{}
+
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		return &devLxdResponse{"bad request", http.StatusBadRequest, "raw"}
+	}
+
+	var value string
+	switch req.State {
+	case "Ready":
+		value = "true"
+	case "Started":
+		value = "false"
+	default:
+		return &devLxdResponse{fmt.Sprintf("invalid state: %s", req.State), http.StatusBadRequest, "raw"}
+	}
+
+	err = c.ConfigKeySet("volatile.last_state.ready", value)
+	if err != nil {
+		return &devLxdResponse{err.Error(), http.StatusInternalServerError, "raw"}
+	}
+
+	return okResponse("", "raw")
+}
+
+// imageExportGet hands the host images over to a nested daemon, saving it
+// from downloading them again. Only the exact fingerprint is accepted.
+var imageExportGet = devLxdHandler{"/1.0/images/{fingerprint}/export", func(c container, r *http.Request) *devLxdResponse {
+	if !c.IsNesting() || !shared.IsTrue(c.ExpandedConfig()["security.nesting.share_images"]) {
+		return &devLxdResponse{"not authorized", http.StatusForbidden, "raw"}
+	}
+
+	fingerprint := mux.Vars(r)["fingerprint"]
+	imgInfo, err := dbImageGet(c.Daemon().db, fingerprint, false, true)
+	if err != nil {
+		return &devLxdResponse{"not found", http.StatusNotFound, "raw"}
+	}
+
+	return okResponse(imageExportResponse(r, imgInfo, fingerprint), "response")
+}}
+
 var handlers = []devLxdHandler{
 	devLxdHandler{"/", func(c container, r *http.Request) *devLxdResponse {
 		return okResponse([]string{"/1.0"}, "json")
 	}},
 	devLxdHandler{"/1.0", func(c container, r *http.Request) *devLxdResponse {
+		if r.Method == "PATCH" {
+			return stateSet(c, r)
+		}
+
 		return okResponse(shared.Jmap{"api_compat": 0}, "json")
 	}},
 	configGet,
 	configKeyGet,
 	metadataGet,
+	imageExportGet,
 	/* TODO: events */
 }
 
@@ -104,6 +156,8 @@ func hoistReq(f func(container, *http.Request) *devLxdResponse, d *Daemon) func(
 		} else if resp.ctype == "json" {
 			w.Header().Set("Content-Type", "application/json")
 			WriteJSON(w, resp.content)
+		} else if resp.ctype == "response" {
+			resp.content.(Response).Render(w)
 		} else {
 			w.Header().Set("Content-Type", "application/octet-stream")
 			fmt.Fprintf(w, resp.content.(string))
@@ -339,6 +393,11 @@ func findContainerForPid(pid int32, d *Daemon) (container, error) {
 			return nil, err
 		}
 
+		// Virtual machines don't get the devlxd socket
+		if c.IsVM() {
+			continue
+		}
+
 		initpid := c.InitPID()
 		pidNs, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/pid", initpid))
 		if err != nil {
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// etagHash returns the ETag of an object, a hash of its JSON representation.
+func etagHash(data interface{}) (string, error) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(body)), nil
+}
+
+// etagValue returns the ETag for a response. It's made of two hashes, the
+// first one only covers the fields which can be modified and is what PUT
+// requests are checked against, the second one covers the whole object so
+// that clients don't get a 304 when only its state changed.
+func etagValue(etag interface{}, metadata interface{}) (string, error) {
+	hash, err := etagHash(etag)
+	if err != nil {
+		return "", err
+	}
+
+	full, err := etagHash(metadata)
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%s-%s", hash, full), nil
+}
+
+// etagCheck validates the If-Match header of a request against the current
+// version of the object, so that concurrent updates don't clobber each other.
+func etagCheck(r *http.Request, data interface{}) error {
+	match := r.Header.Get("If-Match")
+	if match == "" {
+		return nil
+	}
+
+	hash, err := etagHash(data)
+	if err != nil {
+		return err
+	}
+
+	fields := strings.SplitN(strings.Trim(match, "\""), "-", 2)
+	if fields[0] != hash {
+		return fmt.Errorf("ETag doesn't match: %s vs %s", hash, fields[0])
+	}
+
+	return nil
+}
+
+// etagConditional turns a GET response into a 304 when the client already
+// has the current version of the object.
+func etagConditional(r *http.Request, resp Response) Response {
+	sync, ok := resp.(*syncResponse)
+	if !ok || sync.etag == nil {
+		return resp
+	}
+
+	match := r.Header.Get("If-None-Match")
+	if match == "" {
+		return resp
+	}
+
+	value, err := etagValue(sync.etag, sync.metadata)
+	if err != nil || strings.Trim(match, "\"") != value {
+		return resp
+	}
+
+	return &notModifiedResponse{value}
+}
+
+// etagConfig returns the config keys an ETag covers, leaving out the
+// volatile ones which the daemon changes on its own, like on every start.
+func etagConfig(config map[string]string) map[string]string {
+	result := map[string]string{}
+	for k, v := range config {
+		if !strings.HasPrefix(k, "volatile.") {
+			result[k] = v
+		}
+	}
+
+	return result
+}
+
+// The fields which can be changed through a container PUT
+func containerETag(c container) []interface{} {
+	return []interface{}{c.Architecture(), etagConfig(c.LocalConfig()), c.LocalDevices(), c.IsEphemeral(), c.Profiles()}
+}
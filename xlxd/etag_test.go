@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestEtagCheck(t *testing.T) {
+	editable := []interface{}{map[string]string{"limits.cpu": "2"}}
+	state := map[string]interface{}{"config": editable, "status": "Running"}
+
+	value, err := etagValue(editable, state)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest("PUT", "/1.0/containers/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No ETag, no check
+	if err := etagCheck(r, editable); err != nil {
+		t.Errorf("Request without If-Match was refused: %s", err)
+	}
+
+	r.Header.Set("If-Match", fmt.Sprintf("\"%s\"", value))
+	if err := etagCheck(r, editable); err != nil {
+		t.Errorf("Matching ETag was refused: %s", err)
+	}
+
+	// State changes don't matter for updates
+	state["status"] = "Stopped"
+	if err := etagCheck(r, editable); err != nil {
+		t.Errorf("ETag was refused after a state change: %s", err)
+	}
+
+	changed := []interface{}{map[string]string{"limits.cpu": "4"}}
+	if err := etagCheck(r, changed); err == nil {
+		t.Error("Stale ETag was accepted")
+	}
+}
+
+func TestEtagConditional(t *testing.T) {
+	editable := []interface{}{"foo"}
+	metadata := map[string]string{"name": "foo", "status": "Running"}
+
+	value, err := etagValue(editable, metadata)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := http.NewRequest("GET", "/1.0/profiles/foo", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("If-None-Match", fmt.Sprintf("\"%s\"", value))
+
+	resp := etagConditional(r, SyncResponseETag(true, metadata, editable))
+	if _, ok := resp.(*notModifiedResponse); !ok {
+		t.Error("Expected a 304 for an unchanged object")
+	}
+
+	metadata["status"] = "Stopped"
+	resp = etagConditional(r, SyncResponseETag(true, metadata, editable))
+	if _, ok := resp.(*syncResponse); !ok {
+		t.Error("Expected the full response after a state change")
+	}
+}
+
+func TestEtagConfig(t *testing.T) {
+	before := map[string]string{"limits.cpu": "2", "volatile.eth0.hwaddr": "00:16:3e:00:00:01"}
+	after := map[string]string{"limits.cpu": "2", "volatile.eth0.hwaddr": "00:16:3e:00:00:02", "volatile.last_state.power": "RUNNING"}
+
+	first, err := etagHash(etagConfig(before))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := etagHash(etagConfig(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first != second {
+		t.Error("The volatile keys changed the ETag")
+	}
+
+	after["limits.cpu"] = "4"
+	third, err := etagHash(etagConfig(after))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first == third {
+		t.Error("A config change didn't change the ETag")
+	}
+}
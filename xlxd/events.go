@@ -50,22 +50,24 @@ type eventListener struct {
 	messageTypes []string
 	active       chan bool
 	id           string
+	readOnly     bool
 }
 
 type eventsServe struct {
+	d   *Daemon
 	req *http.Request
 }
 
 func (r *eventsServe) Render(w http.ResponseWriter) error {
-	return eventsSocket(r.req, w)
+	return eventsSocket(r.d, r.req, w)
 }
 
-func eventsSocket(r *http.Request, w http.ResponseWriter) error {
-	listener := eventListener{}
+func eventsSocket(d *Daemon, r *http.Request, w http.ResponseWriter) error {
+	listener := eventListener{readOnly: d.isReadOnlyClient(r)}
 
 	typeStr := r.FormValue("type")
 	if typeStr == "" {
-		typeStr = "logging,operation"
+		typeStr = "logging,operation,lifecycle"
 	}
 
 	c, err := shared.WebsocketUpgrader.Upgrade(w, r, nil)
@@ -90,7 +92,7 @@ func eventsSocket(r *http.Request, w http.ResponseWriter) error {
 }
 
 func eventsGet(d *Daemon, r *http.Request) Response {
-	return &eventsServe{r}
+	return &eventsServe{d, r}
 }
 
 var eventsCmd = Command{name: "events", get: eventsGet}
@@ -106,6 +108,17 @@ func eventSend(eventType string, eventMessage interface{}) error {
 		return err
 	}
 
+	// Read-only listeners don't get the secrets of websocket operations
+	readOnlyBody := body
+	op, ok := eventMessage.(*shared.Operation)
+	if ok && eventType == "operation" {
+		event["metadata"] = operationHideSecrets(op)
+		readOnlyBody, err = json.Marshal(event)
+		if err != nil {
+			return err
+		}
+	}
+
 	eventsLock.Lock()
 	listeners := eventListeners
 	eventsLock.Unlock()
@@ -115,6 +128,11 @@ func eventSend(eventType string, eventMessage interface{}) error {
 			continue
 		}
 
+		body := body
+		if listener.readOnly {
+			body = readOnlyBody
+		}
+
 		go func(listener *eventListener, body []byte) {
 			err = listener.connection.WriteMessage(websocket.TextMessage, body)
 			if err != nil {
@@ -132,3 +150,10 @@ func eventSend(eventType string, eventMessage interface{}) error {
 
 	return nil
 }
+
+func eventSendLifecycle(action string, source string, context shared.Jmap) error {
+	return eventSend("lifecycle", shared.Jmap{
+		"action":  action,
+		"source":  source,
+		"context": context})
+}
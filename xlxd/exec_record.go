@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// execRecorder writes an interactive exec session to the container's log
+// directory using the asciicast v2 format.
+type execRecorder struct {
+	lock  sync.Mutex
+	file  *os.File
+	path  string
+	start time.Time
+}
+
+func execRecorderCreate(name string, command []string, env []string) (*execRecorder, error) {
+	start := time.Now()
+	fname := fmt.Sprintf("exec_%s.cast", start.UTC().Format("20060102T150405.000000000"))
+	path := shared.LogPath(name, fname)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	header := shared.Jmap{
+		"version":   2,
+		"width":     80,
+		"height":    24,
+		"timestamp": start.Unix(),
+		"command":   strings.Join(command, " "),
+	}
+
+	for _, entry := range env {
+		if strings.HasPrefix(entry, "TERM=") {
+			header["env"] = shared.Jmap{"TERM": strings.TrimPrefix(entry, "TERM=")}
+		}
+	}
+
+	body, err := json.Marshal(header)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	_, err = f.Write(append(body, '\n'))
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return &execRecorder{file: f, path: path, start: start}, nil
+}
+
+func (r *execRecorder) event(eventType string, data string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return
+	}
+
+	elapsed := time.Since(r.start).Seconds()
+	body, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err != nil {
+		return
+	}
+
+	_, err = r.file.Write(append(body, '\n'))
+	if err != nil {
+		shared.Debugf("Failed to record exec session to %s: %s", r.path, err)
+	}
+}
+
+func (r *execRecorder) Resize(width int, height int) {
+	r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+func (r *execRecorder) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+// execRecordReader records everything read through it as output. A
+// character split across reads is held back until it's complete, asciicast
+// events being JSON strings.
+type execRecordReader struct {
+	io.ReadCloser
+	recorder *execRecorder
+	pending  []byte
+}
+
+func (r *execRecordReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	data := append(r.pending, p[:n]...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+
+	// Whatever is left gets recorded once nothing more comes
+	if err != nil {
+		cut = len(data)
+	}
+
+	if cut > 0 {
+		r.recorder.event("o", string(data[:cut]))
+	}
+	r.pending = append([]byte{}, data[cut:]...)
+
+	return n, err
+}
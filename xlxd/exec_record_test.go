@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing/iotest"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func (suite *lxdTestSuite) TestExecRecorder() {
+	suite.Req.Nil(os.MkdirAll(shared.LogPath("testrec"), 0700))
+	defer os.RemoveAll(shared.LogPath("testrec"))
+
+	recorder, err := execRecorderCreate("testrec", []string{"/bin/bash"}, []string{"TERM=xterm"})
+	suite.Req.Nil(err)
+
+	reader := &execRecordReader{
+		ReadCloser: ioutil.NopCloser(strings.NewReader("hello")),
+		recorder:   recorder}
+	_, err = ioutil.ReadAll(reader)
+	suite.Req.Nil(err)
+
+	recorder.Resize(100, 40)
+	suite.Req.Nil(recorder.Close())
+
+	content, err := ioutil.ReadFile(recorder.path)
+	suite.Req.Nil(err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	suite.Req.Len(lines, 3)
+
+	header := map[string]interface{}{}
+	suite.Req.Nil(json.Unmarshal([]byte(lines[0]), &header))
+	suite.Req.Equal(float64(2), header["version"])
+	suite.Req.Equal("/bin/bash", header["command"])
+
+	event := []interface{}{}
+	suite.Req.Nil(json.Unmarshal([]byte(lines[1]), &event))
+	suite.Req.Equal("o", event[1])
+	suite.Req.Equal("hello", event[2])
+
+	suite.Req.Nil(json.Unmarshal([]byte(lines[2]), &event))
+	suite.Req.Equal("r", event[1])
+	suite.Req.Equal("100x40", event[2])
+}
+
+func (suite *lxdTestSuite) TestExecRecorderSplitCharacter() {
+	suite.Req.Nil(os.MkdirAll(shared.LogPath("testrec"), 0700))
+	defer os.RemoveAll(shared.LogPath("testrec"))
+
+	recorder, err := execRecorderCreate("testrec", []string{"/bin/bash"}, nil)
+	suite.Req.Nil(err)
+
+	// The euro sign comes one byte at a time
+	reader := &execRecordReader{
+		ReadCloser: ioutil.NopCloser(iotest.OneByteReader(strings.NewReader("a€"))),
+		recorder:   recorder}
+	_, err = ioutil.ReadAll(reader)
+	suite.Req.Nil(err)
+	suite.Req.Nil(recorder.Close())
+
+	content, err := ioutil.ReadFile(recorder.path)
+	suite.Req.Nil(err)
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	suite.Req.Len(lines, 3)
+
+	event := []interface{}{}
+	suite.Req.Nil(json.Unmarshal([]byte(lines[2]), &event))
+	suite.Req.Equal("€", event[2])
+}
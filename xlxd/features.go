@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// Features of the kernel and liblxc which some requests need, probed when
+// the daemon starts next to the cgroup and seccomp ones.
+var featureCgroup2 = false
+var featureCRIU = false
+var featurePidfd = false
+
+// Whether the containers get frozen through cgroup.freeze, of cgroup2
+var featureFreezerUnified = false
+
+// The magic number of cgroup2 filesystems, from linux/magic.h
+const cgroup2SuperMagic = 0x63677270
+
+// The number of pidfd_open, the same on all architectures
+const sysPidfdOpen = 434
+
+// versionAtLeast tells whether a version like "3.2.1" or "4.0.0~devel" is at
+// least major.minor.
+func versionAtLeast(version string, major int, minor int) bool {
+	var vMajor, vMinor int
+	_, err := fmt.Sscanf(version, "%d.%d", &vMajor, &vMinor)
+	if err != nil {
+		return false
+	}
+
+	return vMajor > major || (vMajor == major && vMinor >= minor)
+}
+
+// lxcVersionAtLeast tells whether the installed liblxc is at least
+// major.minor.
+func lxcVersionAtLeast(major int, minor int) bool {
+	return versionAtLeast(lxc.Version(), major, minor)
+}
+
+// cgroup2Detect checks whether the kernel has a cgroup2 hierarchy, on its own
+// or next to the v1 controllers, and LXC knows how to use it.
+func cgroup2Detect() bool {
+	for _, path := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		fs := syscall.Statfs_t{}
+		err := syscall.Statfs(path, &fs)
+		if err == nil && fs.Type == cgroup2SuperMagic {
+			return lxcVersionAtLeast(4, 0)
+		}
+	}
+
+	return false
+}
+
+// freezerDetect checks whether containers can be frozen, with the v1 freezer
+// controller or with the cgroup.freeze files the cgroup2 hierarchy has in
+// all its cgroups but the root one.
+func freezerDetect() bool {
+	if shared.PathExists("/sys/fs/cgroup/freezer/") {
+		return true
+	}
+
+	for _, path := range []string{"/sys/fs/cgroup", "/sys/fs/cgroup/unified"} {
+		matches, _ := filepath.Glob(filepath.Join(path, "*", "cgroup.freeze"))
+		if len(matches) > 0 {
+			featureFreezerUnified = true
+			return true
+		}
+	}
+
+	return false
+}
+
+// pidfdDetect checks whether processes can be tracked with pidfds, which LXC
+// uses from 4.0 on so that the PIDs can't get reused under its feet.
+func pidfdDetect() bool {
+	fd, _, errno := syscall.Syscall(sysPidfdOpen, uintptr(syscall.Getpid()), 0, 0)
+	if errno != 0 {
+		return false
+	}
+	syscall.Close(int(fd))
+
+	return lxcVersionAtLeast(4, 0)
+}
+
+// featuresDetect probes the features which aren't probed along with the
+// cgroup controllers and seccomp.
+func featuresDetect() {
+	featureCgroup2 = cgroup2Detect()
+	if featureCgroup2 {
+		shared.Log.Info("Using the cgroup2 hierarchy")
+	}
+
+	_, err := exec.LookPath("criu")
+	featureCRIU = err == nil
+	if !featureCRIU {
+		shared.Log.Warn("Couldn't find CRIU, stateful snapshots and live migration will be unavailable.")
+	}
+
+	featurePidfd = pidfdDetect()
+}
+
+// lxcFeatures returns what the installed liblxc and kernel can do, as shown
+// in environment.driver_features.
+func lxcFeatures() map[string]bool {
+	return map[string]bool{
+		"apparmor":        aaAvailable,
+		"cgroup2":         featureCgroup2,
+		"criu":            featureCRIU,
+		"freezer":         cgFreezerController,
+		"idmapped_mounts": idmappedMountsAvailable,
+		"pidfd":           featurePidfd,
+		"seccomp":         seccompAvailable,
+		"seccomp_notify":  seccompNotifyAvailable,
+	}
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		major   int
+		minor   int
+		result  bool
+	}{
+		{"4.0.2", 4, 0, true},
+		{"4.0.2", 3, 2, true},
+		{"3.2", 3, 2, true},
+		{"3.1.0", 3, 2, false},
+		{"2.1.1~devel", 3, 2, false},
+		{"5.0.0~git2209", 4, 0, true},
+		{"garbage", 1, 0, false},
+		{"", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		if versionAtLeast(test.version, test.major, test.minor) != test.result {
+			t.Errorf("versionAtLeast(%q, %d, %d) isn't %t", test.version, test.major, test.minor, test.result)
+		}
+	}
+}
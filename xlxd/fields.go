@@ -0,0 +1,95 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// containerFieldAliases are short names for the fields of the containers,
+// which are in their state.
+var containerFieldAliases = map[string]string{
+	"name":         "state.name",
+	"uuid":         "state.uuid",
+	"status":       "state.status.status",
+	"ips":          "state.status.ips",
+	"ready":        "state.status.ready",
+	"architecture": "state.architecture",
+	"config":       "state.config",
+	"devices":      "state.devices",
+	"ephemeral":    "state.ephemeral",
+	"profiles":     "state.profiles",
+}
+
+// fieldsFilter only keeps the fields given in the fields parameter of the
+// request, comma separated, of the objects of a listing. Each object then
+// becomes a map of the requested fields to their values, the fields missing
+// from it being left out.
+//
+// Fields are the JSON keys of the objects, dotted for nested ones, or one of
+// the aliases of the listing. Listings of URLs are left alone.
+func fieldsFilter(r *http.Request, list interface{}, aliases map[string]string) (interface{}, error) {
+	fields := []string{}
+	for _, field := range strings.Split(r.FormValue("fields"), ",") {
+		field = strings.TrimSpace(field)
+		if field != "" {
+			fields = append(fields, field)
+		}
+	}
+
+	if len(fields) == 0 {
+		return list, nil
+	}
+
+	data, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []interface{}{}
+	err = json.Unmarshal(data, &objects)
+	if err != nil {
+		return list, nil
+	}
+
+	result := []interface{}{}
+	for _, entry := range objects {
+		object, ok := entry.(map[string]interface{})
+		if !ok {
+			result = append(result, entry)
+			continue
+		}
+
+		filtered := map[string]interface{}{}
+		for _, field := range fields {
+			path, ok := aliases[field]
+			if !ok {
+				path = field
+			}
+
+			value, ok := fieldsLookup(object, strings.Split(path, "."))
+			if ok {
+				filtered[field] = value
+			}
+		}
+
+		result = append(result, filtered)
+	}
+
+	return result, nil
+}
+
+// fieldsLookup returns the value at the given path of keys of the object.
+func fieldsLookup(object map[string]interface{}, path []string) (interface{}, bool) {
+	value, ok := object[path[0]]
+	if !ok || len(path) == 1 {
+		return value, ok
+	}
+
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+
+	return fieldsLookup(nested, path[1:])
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestFieldsFilter(t *testing.T) {
+	list := shared.ContainerInfoList{
+		{State: shared.ContainerState{
+			Name:   "c1",
+			Config: map[string]string{"limits.cpu": "2"},
+			Status: shared.ContainerStatus{Status: "Running", Ips: []shared.Ip{{Interface: "eth0", Address: "10.0.3.2"}}},
+		}},
+	}
+
+	r, err := http.NewRequest("GET", "/1.0/containers?recursion=1&fields=name,status,ips,state.config.limits.cpu,bogus", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := fieldsFilter(r, list, containerFieldAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Config keys have dots of their own, they can't be selected one by one
+	expected := `[{"ips":[{"address":"10.0.3.2","host_veth":"","interface":"eth0","protocol":""}],"name":"c1","status":"Running"}]`
+	if string(data) != expected {
+		t.Errorf("Unexpected filtered containers: %s", data)
+	}
+
+	// Without fields, the listing is untouched
+	r, err = http.NewRequest("GET", "/1.0/containers?recursion=1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = fieldsFilter(r, list, containerFieldAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := result.(shared.ContainerInfoList); !ok {
+		t.Errorf("The listing was changed without fields: %v", result)
+	}
+
+	// Nor are listings of URLs
+	r, err = http.NewRequest("GET", "/1.0/containers?fields=name", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = fieldsFilter(r, []string{"/1.0/containers/c1"}, containerFieldAliases)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data, err = json.Marshal(result)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != `["/1.0/containers/c1"]` {
+		t.Errorf("Unexpected filtered URLs: %s", data)
+	}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+/*
+ * Idmapped mounts (Linux 5.12) show the files of a mount with their owners
+ * shifted through the map of a user namespace. The rootfs of unprivileged
+ * containers can then be left owned by the ids of the image rather than
+ * having every file chowned to the container map, which takes minutes for
+ * large images.
+ */
+
+// Syscall numbers of the unified table, MIPS being the only architecture
+// Go runs on which shifts them, by its ABI base.
+var (
+	sysOpenTree     = 428 + idmapSyscallBase[runtime.GOARCH]
+	sysMoveMount    = 429 + idmapSyscallBase[runtime.GOARCH]
+	sysMountSetattr = 442 + idmapSyscallBase[runtime.GOARCH]
+)
+
+var idmapSyscallBase = map[string]uintptr{
+	"mips":     4000,
+	"mipsle":   4000,
+	"mips64":   5000,
+	"mips64le": 5000,
+}
+
+const (
+	openTreeClone       = 0x1
+	openTreeCloexec     = 0x80000
+	moveMountFEmptyPath = 0x4
+	mountAttrIdmap      = 0x100000
+	atEmptyPath         = 0x1000
+	atFdcwd             = -100
+)
+
+// struct mount_attr
+type mountAttr struct {
+	attrSet     uint64
+	attrClr     uint64
+	propagation uint64
+	usernsFd    uint64
+}
+
+var idmappedMountsAvailable = false
+
+// idmapUserns returns a reference to a user namespace with the given map,
+// held by a short lived child.
+func idmapUserns(execPath string, set *shared.IdmapSet) (*os.File, error) {
+	cmd := exec.Command(execPath, "forkuserns")
+	cmd.SysProcAttr = &syscall.SysProcAttr{Cloneflags: syscall.CLONE_NEWUSER}
+
+	for _, entry := range set.Idmap {
+		m := syscall.SysProcIDMap{ContainerID: entry.Nsid, HostID: entry.Hostid, Size: entry.Maprange}
+		if entry.Isuid {
+			cmd.SysProcAttr.UidMappings = append(cmd.SysProcAttr.UidMappings, m)
+		}
+
+		if entry.Isgid {
+			cmd.SysProcAttr.GidMappings = append(cmd.SysProcAttr.GidMappings, m)
+		}
+	}
+
+	// The child lives until its stdin gets closed
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	defer func(stdin io.Closer) {
+		stdin.Close()
+		cmd.Wait()
+	}(stdin)
+
+	return os.Open(fmt.Sprintf("/proc/%d/ns/user", cmd.Process.Pid))
+}
+
+// idmapMount mounts source on target with the ownership shifted by set, an
+// id of the source being shown as its host id in set.
+func idmapMount(execPath string, set *shared.IdmapSet, source string, target string) error {
+	userns, err := idmapUserns(execPath, set)
+	if err != nil {
+		return err
+	}
+	defer userns.Close()
+
+	sourcePtr, err := syscall.BytePtrFromString(source)
+	if err != nil {
+		return err
+	}
+
+	targetPtr, err := syscall.BytePtrFromString(target)
+	if err != nil {
+		return err
+	}
+
+	emptyPtr, err := syscall.BytePtrFromString("")
+	if err != nil {
+		return err
+	}
+
+	dirfd := atFdcwd
+	fd, _, errno := syscall.Syscall(sysOpenTree, uintptr(dirfd), uintptr(unsafe.Pointer(sourcePtr)), openTreeClone|openTreeCloexec)
+	if errno != 0 {
+		return fmt.Errorf("Failed to clone %s: %s", source, errno)
+	}
+	defer syscall.Close(int(fd))
+
+	attr := mountAttr{attrSet: mountAttrIdmap, usernsFd: uint64(userns.Fd())}
+	_, _, errno = syscall.Syscall6(sysMountSetattr, fd, uintptr(unsafe.Pointer(emptyPtr)), atEmptyPath, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("Failed to idmap %s: %s", source, errno)
+	}
+
+	_, _, errno = syscall.Syscall6(sysMoveMount, fd, uintptr(unsafe.Pointer(emptyPtr)), uintptr(dirfd), uintptr(unsafe.Pointer(targetPtr)), moveMountFEmptyPath, 0)
+	if errno != 0 {
+		return fmt.Errorf("Failed to mount %s: %s", target, errno)
+	}
+
+	return nil
+}
+
+// idmapDetect checks whether the filesystem of the containers supports
+// idmapped mounts, only the dir and btrfs backends keep them there.
+func (d *Daemon) idmapDetect() bool {
+	if os.Getenv("LXD_IDMAPPED_MOUNTS") == "false" || d.IdmapSet == nil {
+		return false
+	}
+
+	storageType := d.Storage.GetStorageType()
+	if storageType != storageTypeDir && storageType != storageTypeBtrfs {
+		return false
+	}
+
+	target := shared.VarPath("idmapped", ".probe")
+	err := os.MkdirAll(target, 0711)
+	if err != nil {
+		return false
+	}
+	defer os.Remove(target)
+
+	err = idmapMount(d.execPath, d.IdmapSet, shared.VarPath("containers"), target)
+	if err != nil {
+		shared.Log.Debug("Idmapped mounts aren't supported", log.Ctx{"err": err})
+		return false
+	}
+
+	syscall.Unmount(target, syscall.MNT_DETACH)
+	return true
+}
@@ -18,6 +18,7 @@ import (
 	"sync"
 
 	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
 	"gopkg.in/yaml.v2"
 
 	"github.com/krschwab/xlxd/shared"
@@ -47,6 +48,7 @@ func detectCompression(fname string) ([]string, string, error) {
 	// gz - 2 bytes, 0x1f 0x8b
 	// lzma - 6 bytes, { [0x000, 0xE0], '7', 'z', 'X', 'Z', 0x00 } -
 	// xy - 6 bytes,  header format { 0xFD, '7', 'z', 'X', 'Z', 0x00 }
+	// zstd - 4 bytes, 0x28 0xb5 0x2f 0xfd
 	// tar - 263 bytes, trying to get ustar from 257 - 262
 	header := make([]byte, 263)
 	_, err = f.Read(header)
@@ -63,6 +65,8 @@ func detectCompression(fname string) ([]string, string, error) {
 		return []string{"-Jxf"}, ".tar.xz", nil
 	case (bytes.Equal(header[1:5], []byte{'7', 'z', 'X', 'Z'}) && header[0] != 0xFD):
 		return []string{"--lzma", "-xf"}, ".tar.lzma", nil
+	case bytes.Equal(header[0:4], []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return []string{"--zstd", "-xf"}, ".tar.zst", nil
 	case bytes.Equal(header[257:262], []byte{'u', 's', 't', 'a', 'r'}):
 		return []string{"-xf"}, ".tar", nil
 	default:
@@ -122,8 +126,80 @@ func untarImage(imagefname string, destpath string) error {
 	return nil
 }
 
-func compressFile(path string, compress string) (string, error) {
-	cmd := exec.Command(compress, path, "-c", "-n")
+// imageCompressionLevels are the levels accepted by each of the algorithms
+// images can be compressed with, all of them detected by detectCompression.
+var imageCompressionLevels = map[string][2]int{
+	"bzip2": {1, 9},
+	"gzip":  {1, 9},
+	"lzma":  {0, 9},
+	"xz":    {0, 9},
+	"zstd":  {1, 19},
+	"none":  {0, -1},
+}
+
+// imageCompressionValidate checks an algorithm and its level, empty for the
+// default one of the algorithm.
+func imageCompressionValidate(compress string, level string) error {
+	levels, ok := imageCompressionLevels[compress]
+	if !ok {
+		return fmt.Errorf("Unsupported compression algorithm: %s", compress)
+	}
+
+	if level == "" {
+		return nil
+	}
+
+	value, err := strconv.Atoi(level)
+	if err != nil || value < levels[0] || value > levels[1] {
+		return fmt.Errorf("Invalid compression level for %s: %s", compress, level)
+	}
+
+	return nil
+}
+
+// imageCompressionConfigValidate checks a value of images.compression_algorithm
+// or images.compression_level. As either may be changed first, the level is
+// only checked against the algorithm when publishing.
+func imageCompressionConfigValidate(key string, value string) error {
+	if value == "" {
+		return nil
+	}
+
+	if key == "images.compression_algorithm" {
+		return imageCompressionValidate(value, "")
+	}
+
+	for compress := range imageCompressionLevels {
+		if imageCompressionValidate(compress, value) == nil {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("Invalid compression level: %s", value)
+}
+
+func compressFile(path string, compress string, level string) (string, error) {
+	err := imageCompressionValidate(compress, level)
+	if err != nil {
+		return "", err
+	}
+
+	args := []string{"-c"}
+	switch compress {
+	case "gzip":
+		args = append(args, "-n")
+	case "xz":
+		args = append(args, "-T0")
+	case "zstd":
+		// Spread the work over all the CPUs
+		args = append(args, "-q", "-T0")
+	}
+
+	if level != "" {
+		args = append(args, "-"+level)
+	}
+
+	cmd := exec.Command(compress, append(args, path)...)
 
 	outfile, err := os.Create(path + ".compressed")
 	if err != nil {
@@ -153,6 +229,11 @@ type imagePostReq struct {
 	Public     bool              `json:"public"`
 	Source     map[string]string `json:"source"`
 	Properties map[string]string `json:"properties"`
+
+	// Override images.compression_algorithm and images.compression_level
+	// when publishing a container
+	CompressionAlgorithm string `json:"compression_algorithm"`
+	CompressionLevel     string `json:"compression_level"`
 }
 
 type imageMetadata struct {
@@ -216,9 +297,20 @@ func imgPostContInfo(d *Daemon, r *http.Request, req imagePostReq,
 	}
 	tarfile.Close()
 
-	compress, err := d.ConfigValueGet("images.compression_algorithm")
-	if err != nil {
-		return info, err
+	compress := req.CompressionAlgorithm
+	level := req.CompressionLevel
+	if compress == "" {
+		compress, err = d.ConfigValueGet("images.compression_algorithm")
+		if err != nil {
+			return info, err
+		}
+
+		if level == "" {
+			level, err = d.ConfigValueGet("images.compression_level")
+			if err != nil {
+				return info, err
+			}
+		}
 	}
 
 	// Default to gzip for this
@@ -228,7 +320,7 @@ func imgPostContInfo(d *Daemon, r *http.Request, req imagePostReq,
 
 	var compressedPath string
 	if compress != "none" {
-		compressedPath, err = compressFile(tarfile.Name(), compress)
+		compressedPath, err = compressFile(tarfile.Name(), compress, level)
 		if err != nil {
 			return info, err
 		}
@@ -290,7 +382,7 @@ func imgPostRemoteInfo(d *Daemon, req imagePostReq, op *operation) error {
 	}
 
 	err = d.ImageDownload(op,
-		req.Source["server"], hash, req.Source["secret"], false, false)
+		req.Source["server"], hash, req.Source["secret"], false, false, req.Source["bandwidth-limit"])
 
 	if err != nil {
 		return err
@@ -324,15 +416,13 @@ func imgPostURLInfo(d *Daemon, req imagePostReq, op *operation) error {
 	}
 
 	// Resolve the image URL
-	if d.tlsconfig == nil {
-		d.tlsconfig, err = shared.GetTLSConfig(d.certf, d.keyf)
-		if err != nil {
-			return err
-		}
+	tlsconfig, err := d.clientTLSConfig()
+	if err != nil {
+		return err
 	}
 
 	tr := &http.Transport{
-		TLSClientConfig: d.tlsconfig,
+		TLSClientConfig: tlsconfig,
 		Dial:            shared.RFC3493Dialer,
 		Proxy:           http.ProxyFromEnvironment,
 	}
@@ -372,7 +462,7 @@ func imgPostURLInfo(d *Daemon, req imagePostReq, op *operation) error {
 
 	// Import the image
 	err = d.ImageDownload(op,
-		url, hash, "", false, true)
+		url, hash, "", false, true, req.Source["bandwidth-limit"])
 
 	if err != nil {
 		return err
@@ -585,6 +675,11 @@ func getImgPostInfo(d *Daemon, r *http.Request,
 		}
 	}
 
+	err = imagePropertiesValidate(info.Properties)
+	if err != nil {
+		return info, err
+	}
+
 	return info, nil
 }
 
@@ -600,14 +695,14 @@ func dbInsertImage(d *Daemon, fp string, fname string, sz int64, public bool,
 		sqlPublic = 1
 	}
 
-	stmt, err := tx.Prepare(`INSERT INTO images (fingerprint, filename, size, public, architecture, creation_date, expiry_date, upload_date) VALUES (?, ?, ?, ?, ?, ?, ?, strftime("%s"))`)
+	stmt, err := tx.Prepare(`INSERT INTO images (fingerprint, filename, size, public, architecture, creation_date, expiry_date, upload_date, uuid) VALUES (?, ?, ?, ?, ?, ?, ?, strftime("%s"), ?)`)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
 	defer stmt.Close()
 
-	result, err := stmt.Exec(fp, fname, sz, sqlPublic, arch, creationDate, expiryDate)
+	result, err := stmt.Exec(fp, fname, sz, sqlPublic, arch, creationDate, expiryDate, uuid.NewRandom().String())
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -723,6 +818,15 @@ func imagesPost(d *Daemon, r *http.Request) Response {
 		return InternalError(fmt.Errorf("Invalid images JSON"))
 	}
 
+	// The algorithm ends up being run, only take known ones
+	if !imageUpload && req.CompressionAlgorithm != "" {
+		err = imageCompressionValidate(req.CompressionAlgorithm, req.CompressionLevel)
+		if err != nil {
+			cleanup(builddir, post)
+			return BadRequest(err)
+		}
+	}
+
 	// Begin background operation
 	run := func(op *operation) error {
 		var info shared.ImageInfo
@@ -816,12 +920,16 @@ func getImageMetadata(fname string) (*imageMetadata, error) {
 	return metadata, nil
 }
 
-func doImagesGet(d *Daemon, recursion bool, public bool) (interface{}, error) {
-	results, err := dbImagesGet(d.db, public)
+// doImagesGet lists the images of the page, as URLs without recursion. It also
+// returns the number of images in all the pages.
+func doImagesGet(d *Daemon, recursion bool, public bool, page pagination) (interface{}, int, error) {
+	all, err := dbImagesGet(d.db, public)
 	if err != nil {
-		return []string{}, err
+		return []string{}, 0, err
 	}
 
+	results := page.apply(all)
+
 	resultString := make([]string, len(results))
 	resultMap := make([]shared.ImageInfo, len(results))
 	i := 0
@@ -841,20 +949,40 @@ func doImagesGet(d *Daemon, recursion bool, public bool) (interface{}, error) {
 	}
 
 	if !recursion {
-		return resultString, nil
+		return resultString, len(all), nil
 	}
 
-	return resultMap, nil
+	return resultMap, len(all), nil
 }
 
 func imagesGet(d *Daemon, r *http.Request) Response {
 	public := !d.isTrustedClient(r)
 
-	result, err := doImagesGet(d, d.isRecursionRequest(r), public)
+	page, err := paginationGet(r)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	if d.isNamesOnlyRequest(r) {
+		result, err := dbImagesGet(d.db, public)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		return SyncResponsePage(page.apply(result), len(result))
+	}
+
+	result, total, err := doImagesGet(d, d.isRecursionRequest(r), public, page)
 	if err != nil {
 		return SmartError(err)
 	}
-	return SyncResponse(true, result)
+
+	result, err = fieldsFilter(r, result, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponsePage(result, total)
 }
 
 var imagesCmd = Command{name: "images", post: imagesPost, untrustedGet: true, get: imagesGet}
@@ -890,6 +1018,10 @@ func doDeleteImage(d *Daemon, fingerprint string) error {
 		}
 	}
 
+	// Not while the cached volume is being unpacked
+	unlock := imageLockAcquire(imgInfo.Fingerprint)
+	defer unlock()
+
 	if err = s.ImageDelete(imgInfo.Fingerprint); err != nil {
 		return err
 	}
@@ -913,25 +1045,16 @@ func doImageGet(d *Daemon, fingerprint string, public bool) (shared.ImageInfo, R
 		return shared.ImageInfo{}, SmartError(err)
 	}
 
-	q := "SELECT key, value FROM images_properties where image_id=?"
-	var key, value, name, desc string
-	inargs := []interface{}{imgInfo.Id}
-	outfmt := []interface{}{key, value}
-	results, err := dbQueryScan(d.db, q, inargs, outfmt)
+	properties, err := dbImagePropertiesGet(d.db, imgInfo.Id)
 	if err != nil {
 		return shared.ImageInfo{}, SmartError(err)
 	}
-	properties := map[string]string{}
-	for _, r := range results {
-		key = r[0].(string)
-		value = r[1].(string)
-		properties[key] = value
-	}
 
-	q = "SELECT name, description FROM images_aliases WHERE image_id=?"
-	inargs = []interface{}{imgInfo.Id}
-	outfmt = []interface{}{name, desc}
-	results, err = dbQueryScan(d.db, q, inargs, outfmt)
+	var name, desc string
+	q := "SELECT name, description FROM images_aliases WHERE image_id=?"
+	inargs := []interface{}{imgInfo.Id}
+	outfmt := []interface{}{name, desc}
+	results, err := dbQueryScan(d.db, q, inargs, outfmt)
 	if err != nil {
 		return shared.ImageInfo{}, InternalError(err)
 	}
@@ -952,7 +1075,8 @@ func doImageGet(d *Daemon, fingerprint string, public bool) (shared.ImageInfo, R
 		Architecture: imgInfo.Architecture,
 		CreationDate: imgInfo.CreationDate,
 		ExpiryDate:   imgInfo.ExpiryDate,
-		UploadDate:   imgInfo.UploadDate}
+		UploadDate:   imgInfo.UploadDate,
+		UUID:         imgInfo.UUID}
 
 	return info, nil
 }
@@ -1001,7 +1125,8 @@ func imageGet(d *Daemon, r *http.Request) Response {
 		return response
 	}
 
-	return SyncResponse(true, info)
+	etag := []interface{}{info.Public, info.Properties}
+	return SyncResponseETag(true, info, etag)
 }
 
 type imagePutReq struct {
@@ -1012,11 +1137,27 @@ type imagePutReq struct {
 func imagePut(d *Daemon, r *http.Request) Response {
 	fingerprint := mux.Vars(r)["fingerprint"]
 
+	// Validate the ETag
+	info, response := doImageGet(d, fingerprint, false)
+	if response != nil {
+		return response
+	}
+
+	err := etagCheck(r, []interface{}{info.Public, info.Properties})
+	if err != nil {
+		return PreconditionFailed(err)
+	}
+
 	imageRaw := imagePutReq{}
 	if err := json.NewDecoder(r.Body).Decode(&imageRaw); err != nil {
 		return BadRequest(err)
 	}
 
+	err = imagePropertiesValidate(imageRaw.Properties)
+	if err != nil {
+		return BadRequest(err)
+	}
+
 	imgInfo, err := dbImageGet(d.db, fingerprint, false, false)
 	if err != nil {
 		return SmartError(err)
@@ -1111,7 +1252,9 @@ func aliasesGet(d *Daemon, r *http.Request) Response {
 	responseMap := []shared.ImageAlias{}
 	for _, res := range results {
 		name = res[0].(string)
-		if !recursion {
+		if d.isNamesOnlyRequest(r) {
+			responseStr = append(responseStr, name)
+		} else if !recursion {
 			url := fmt.Sprintf("/%s/images/aliases/%s", shared.APIVersion, name)
 			responseStr = append(responseStr, url)
 
@@ -1124,7 +1267,7 @@ func aliasesGet(d *Daemon, r *http.Request) Response {
 		}
 	}
 
-	if !recursion {
+	if !recursion || d.isNamesOnlyRequest(r) {
 		return SyncResponse(true, responseStr)
 	}
 
@@ -1185,6 +1328,12 @@ func imageExport(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
+	return imageExportResponse(r, imgInfo, imgInfo.Fingerprint)
+}
+
+// imageExportResponse serves the files of an image, split images being sent
+// as multipart.
+func imageExportResponse(r *http.Request, imgInfo *shared.ImageBaseInfo, fingerprint string) Response {
 	filename := imgInfo.Filename
 	imagePath := shared.VarPath("images", imgInfo.Fingerprint)
 	rootfsPath := imagePath + ".rootfs"
@@ -1219,8 +1368,7 @@ func imageExport(d *Daemon, r *http.Request) Response {
 }
 
 func imageSecret(d *Daemon, r *http.Request) Response {
-	fingerprint := mux.Vars(r)["fingerprint"]
-	_, err := dbImageGet(d.db, fingerprint, false, false)
+	imgInfo, err := dbImageGet(d.db, mux.Vars(r)["fingerprint"], false, false)
 	if err != nil {
 		return SmartError(err)
 	}
@@ -1235,7 +1383,7 @@ func imageSecret(d *Daemon, r *http.Request) Response {
 	meta["secret"] = secret
 
 	resources := map[string][]string{}
-	resources["images"] = []string{fingerprint}
+	resources["images"] = []string{imgInfo.Fingerprint}
 
 	op, err := operationCreate(operationClassToken, resources, meta, nil, nil, nil)
 	if err != nil {
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// imagePropertyValidators checks the values of the structured image
+// properties, those the daemon and its policies give a meaning to. The user.*
+// ones are free-form, like user.scan.status set by a scanner.
+var imagePropertyValidators = map[string]func(value string) error{
+	"requirements.secureboot": imagePropertyBool,
+	"requirements.privileged": imagePropertyBool,
+}
+
+func imagePropertyBool(value string) error {
+	if !shared.StringInSlice(strings.ToLower(value), []string{"true", "false", "1", "0", "yes", "no", "on", "off"}) {
+		return fmt.Errorf("Invalid value for a boolean: %s", value)
+	}
+
+	return nil
+}
+
+// imagePropertiesValidate checks the properties given to an image.
+func imagePropertiesValidate(properties map[string]string) error {
+	for key, value := range properties {
+		validator, ok := imagePropertyValidators[key]
+		if !ok {
+			continue
+		}
+
+		err := validator(value)
+		if err != nil {
+			return fmt.Errorf("Invalid image property %s: %s", key, err)
+		}
+	}
+
+	return nil
+}
+
+// imagePolicyError is a container creation refused by images.block_properties,
+// with the condition the image matched.
+type imagePolicyError struct {
+	Fingerprint string `json:"fingerprint"`
+	Rule        string `json:"rule"`
+	Reason      string `json:"reason"`
+}
+
+func (e *imagePolicyError) Error() string {
+	return fmt.Sprintf("Image %s is blocked by images.block_properties: %s", e.Fingerprint, e.Reason)
+}
+
+// imagePolicyRule is a condition of images.block_properties: the image is
+// blocked when its property key has the value (key=value), lacks it
+// (key!=value) or is missing (!key).
+type imagePolicyRule struct {
+	rule  string
+	key   string
+	op    string
+	value string
+}
+
+// imagePolicyRules parses images.block_properties, a comma separated list of
+// conditions.
+func imagePolicyRules(value string) ([]imagePolicyRule, error) {
+	rules := []imagePolicyRule{}
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		rule := imagePolicyRule{rule: entry}
+		if strings.HasPrefix(entry, "!") {
+			rule.key, rule.op = entry[1:], "!"
+		} else if fields := strings.SplitN(entry, "!=", 2); len(fields) == 2 {
+			rule.key, rule.op, rule.value = fields[0], "!=", fields[1]
+		} else if fields := strings.SplitN(entry, "=", 2); len(fields) == 2 {
+			rule.key, rule.op, rule.value = fields[0], "=", fields[1]
+		} else {
+			return nil, fmt.Errorf("Invalid image property condition, must be key=value, key!=value or !key: %s", entry)
+		}
+
+		if rule.key == "" || strings.ContainsAny(rule.key, "=! ") {
+			return nil, fmt.Errorf("Invalid image property condition: %s", entry)
+		}
+
+		rules = append(rules, rule)
+	}
+
+	return rules, nil
+}
+
+// blocks returns why the rule blocks an image with the properties, "" if it
+// doesn't.
+func (rule imagePolicyRule) blocks(properties map[string]string) string {
+	value, ok := properties[rule.key]
+	switch rule.op {
+	case "!":
+		if !ok {
+			return fmt.Sprintf("property %s is missing", rule.key)
+		}
+	case "!=":
+		if !ok {
+			return fmt.Sprintf("property %s is missing, it must be %s", rule.key, rule.value)
+		} else if value != rule.value {
+			return fmt.Sprintf("property %s is %s, it must be %s", rule.key, value, rule.value)
+		}
+	case "=":
+		if ok && value == rule.value {
+			return fmt.Sprintf("property %s is %s", rule.key, value)
+		}
+	}
+
+	return ""
+}
+
+// imagePolicyCheck checks that containers may be created from the image as of
+// images.block_properties.
+func imagePolicyCheck(d *Daemon, info *shared.ImageBaseInfo) error {
+	value, err := d.ConfigValueGet("images.block_properties")
+	if err != nil {
+		return err
+	}
+
+	rules, err := imagePolicyRules(value)
+	if err != nil {
+		return err
+	}
+
+	if len(rules) == 0 {
+		return nil
+	}
+
+	properties, err := dbImagePropertiesGet(d.db, info.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, rule := range rules {
+		reason := rule.blocks(properties)
+		if reason != "" {
+			return &imagePolicyError{info.Fingerprint, rule.rule, reason}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestImagePolicyRules(t *testing.T) {
+	rules, err := imagePolicyRules("user.scan.status=failed, user.scan.status!=passed,!requirements.secureboot,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rules) != 3 {
+		t.Fatalf("Unexpected rules: %v", rules)
+	}
+
+	tests := []struct {
+		properties map[string]string
+		blocked    []bool
+	}{
+		{map[string]string{"user.scan.status": "passed", "requirements.secureboot": "true"}, []bool{false, false, false}},
+		{map[string]string{"user.scan.status": "failed"}, []bool{true, true, true}},
+		{map[string]string{"user.scan.status": "pending", "requirements.secureboot": "false"}, []bool{false, true, false}},
+		{map[string]string{}, []bool{false, true, true}},
+	}
+
+	for _, test := range tests {
+		for i, rule := range rules {
+			reason := rule.blocks(test.properties)
+			if (reason != "") != test.blocked[i] {
+				t.Errorf("Rule %q on %v: unexpected reason %q", rule.rule, test.properties, reason)
+			}
+		}
+	}
+
+	for _, invalid := range []string{"user.scan.status", "!", "=failed", "a b=c"} {
+		_, err := imagePolicyRules(invalid)
+		if err == nil {
+			t.Errorf("Invalid condition %q was accepted", invalid)
+		}
+	}
+}
+
+func TestImagePropertiesValidate(t *testing.T) {
+	err := imagePropertiesValidate(map[string]string{"requirements.secureboot": "false", "user.scan.status": "anything"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = imagePropertiesValidate(map[string]string{"requirements.secureboot": "maybe"})
+	if err == nil {
+		t.Fatal("An invalid boolean was accepted")
+	}
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// Architecture names used by LXC templates which we call differently.
+var lxcImportArchitectures = map[string]string{
+	"amd64":     "x86_64",
+	"x86":       "i686",
+	"i386":      "i686",
+	"i486":      "i686",
+	"i586":      "i686",
+	"armhf":     "armv7l",
+	"armel":     "armv7l",
+	"arm64":     "aarch64",
+	"powerpc":   "ppc",
+	"powerpc64": "ppc64",
+	"ppc64el":   "ppc64le",
+}
+
+// Keys which have no meaning for us or which we set up ourselves. lxc.include
+// pulls in the distribution defaults, LXD has its own.
+var lxcImportIgnored = []string{
+	"lxc.include",
+	"lxc.utsname",
+	"lxc.uts.name",
+	"lxc.logfile",
+	"lxc.log.file",
+	"lxc.mount",
+	"lxc.mount.fstab",
+}
+
+// lxcImportConfig is what a classic LXC container config translates to.
+type lxcImportConfig struct {
+	Architecture int
+	Config       map[string]string
+	Devices      shared.Devices
+	Rootfs       string
+	Idmap        []shared.IdmapEntry
+
+	// Keys which couldn't be carried over
+	Ignored []string
+}
+
+type lxcImportReq struct {
+	LxcPath string   `json:"lxcpath"`
+	Names   []string `json:"names"`
+}
+
+type lxcImportResult struct {
+	Name    string   `json:"name"`
+	Error   string   `json:"error"`
+	Moved   bool     `json:"moved"`
+	Ignored []string `json:"ignored"`
+}
+
+// lxcImportMemory converts a memory cgroup limit into a limits.memory value.
+func lxcImportMemory(value string) (string, bool) {
+	suffixes := map[string]string{"k": "kB", "K": "kB", "m": "MB", "M": "MB", "g": "GB", "G": "GB"}
+	if len(value) > 1 {
+		suffix, ok := suffixes[value[len(value)-1:]]
+		if ok {
+			_, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+			return value[:len(value)-1] + suffix, err == nil
+		}
+	}
+
+	valueInt, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || valueInt <= 0 {
+		return "", false
+	}
+
+	if valueInt%(1024*1024) == 0 {
+		return fmt.Sprintf("%dMB", valueInt/(1024*1024)), true
+	}
+
+	if valueInt%1024 == 0 {
+		return fmt.Sprintf("%dkB", valueInt/1024), true
+	}
+
+	return "", false
+}
+
+// lxcImportIdmap parses an "u|g nsid hostid range" map entry.
+func lxcImportIdmap(value string) (*shared.IdmapEntry, error) {
+	fields := strings.Fields(value)
+	if len(fields) != 4 || (fields[0] != "u" && fields[0] != "g") {
+		return nil, fmt.Errorf("Invalid id map: %s", value)
+	}
+
+	ids := []int{}
+	for _, field := range fields[1:] {
+		id, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid id map: %s", value)
+		}
+
+		ids = append(ids, id)
+	}
+
+	return &shared.IdmapEntry{
+		Isuid:    fields[0] == "u",
+		Isgid:    fields[0] == "g",
+		Nsid:     ids[0],
+		Hostid:   ids[1],
+		Maprange: ids[2],
+	}, nil
+}
+
+// How LXC network settings map to nic device keys.
+var lxcImportNicKeys = map[string]string{
+	"link":         "parent",
+	"hwaddr":       "hwaddr",
+	"name":         "name",
+	"mtu":          "mtu",
+	"flags":        "flags",
+	"ipv4":         "ipv4",
+	"ipv4.address": "ipv4",
+	"ipv4.gateway": "ipv4.gateway",
+	"ipv6":         "ipv6",
+	"ipv6.address": "ipv6",
+	"ipv6.gateway": "ipv6.gateway",
+	"script.up":    "script.up",
+	"script.down":  "script.down",
+}
+
+// lxcImportNic turns the settings of a LXC network into a nic device, nil
+// meaning the network is left out.
+func lxcImportNic(network map[string]string) (shared.Device, error) {
+	device := shared.Device{"type": "nic"}
+	switch network["type"] {
+	case "", "empty", "none":
+		return nil, nil
+	case "veth":
+		device["nictype"] = "bridged"
+		if network["link"] == "" {
+			device["nictype"] = "p2p"
+		}
+	case "macvlan":
+		device["nictype"] = "macvlan"
+	case "phys":
+		device["nictype"] = "physical"
+	default:
+		return nil, fmt.Errorf("Unsupported network type: %s", network["type"])
+	}
+
+	for key, value := range network {
+		if lxcImportNicKeys[key] != "" && value != "" {
+			device[lxcImportNicKeys[key]] = value
+		}
+	}
+
+	return device, nil
+}
+
+// lxcImportParse translates the config of a classic LXC container. Anything
+// without an equivalent which still makes sense for us ends up in raw.lxc.
+func lxcImportParse(r io.Reader) (*lxcImportConfig, error) {
+	result := &lxcImportConfig{
+		Config:  map[string]string{},
+		Devices: shared.Devices{},
+		Ignored: []string{},
+	}
+
+	raw := []string{}
+	networks := []map[string]string{}
+	network := func(index int) map[string]string {
+		for len(networks) <= index {
+			networks = append(networks, map[string]string{})
+		}
+
+		return networks[index]
+	}
+
+	mounts := 0
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, "=", 2)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("Invalid config line: %s", line)
+		}
+
+		key := strings.TrimSpace(fields[0])
+		value := strings.TrimSpace(fields[1])
+
+		switch {
+		case shared.StringInSlice(key, lxcImportIgnored):
+			result.Ignored = append(result.Ignored, key)
+		case key == "lxc.arch":
+			arch := value
+			if lxcImportArchitectures[arch] != "" {
+				arch = lxcImportArchitectures[arch]
+			}
+
+			id, err := shared.ArchitectureId(arch)
+			if err != nil {
+				return nil, err
+			}
+			result.Architecture = id
+		case key == "lxc.rootfs" || key == "lxc.rootfs.path":
+			rootfs := strings.TrimPrefix(value, "dir:")
+			if !filepath.IsAbs(rootfs) {
+				return nil, fmt.Errorf("Unsupported rootfs: %s", value)
+			}
+			result.Rootfs = rootfs
+		case key == "lxc.rootfs.backend":
+			if value != "dir" && value != "btrfs" {
+				return nil, fmt.Errorf("Unsupported rootfs backend: %s", value)
+			}
+		case strings.HasPrefix(key, "lxc.rootfs."):
+			result.Ignored = append(result.Ignored, key)
+		case key == "lxc.id_map" || key == "lxc.idmap":
+			entry, err := lxcImportIdmap(value)
+			if err != nil {
+				return nil, err
+			}
+			result.Idmap = append(result.Idmap, *entry)
+		case key == "lxc.network.type":
+			// Legacy networks are started by their type
+			network(len(networks))["type"] = value
+		case strings.HasPrefix(key, "lxc.network."):
+			if len(networks) == 0 {
+				return nil, fmt.Errorf("%s is set before lxc.network.type", key)
+			}
+			networks[len(networks)-1][strings.TrimPrefix(key, "lxc.network.")] = value
+		case strings.HasPrefix(key, "lxc.net."):
+			parts := strings.SplitN(strings.TrimPrefix(key, "lxc.net."), ".", 2)
+			index, err := strconv.Atoi(parts[0])
+			if err != nil || len(parts) != 2 {
+				return nil, fmt.Errorf("Invalid network key: %s", key)
+			}
+			network(index)[parts[1]] = value
+		case key == "lxc.mount.entry":
+			fields := strings.Fields(value)
+			if len(fields) < 4 {
+				return nil, fmt.Errorf("Invalid mount entry: %s", value)
+			}
+
+			options := strings.Split(fields[3], ",")
+			if !shared.StringInSlice("bind", options) && !shared.StringInSlice("rbind", options) {
+				raw = append(raw, line)
+				continue
+			}
+
+			device := shared.Device{
+				"type":   "disk",
+				"source": fields[0],
+				"path":   "/" + strings.TrimPrefix(fields[1], "/"),
+			}
+
+			if shared.StringInSlice("ro", options) {
+				device["readonly"] = "true"
+			}
+
+			if shared.StringInSlice("optional", options) {
+				device["optional"] = "true"
+			}
+
+			mounts++
+			result.Devices[fmt.Sprintf("mount%d", mounts)] = device
+		case key == "lxc.start.auto":
+			result.Config["boot.autostart"] = strconv.FormatBool(value == "1")
+		case key == "lxc.start.delay":
+			result.Config["boot.autostart.delay"] = value
+		case key == "lxc.start.order":
+			result.Config["boot.autostart.priority"] = value
+		case key == "lxc.cgroup.memory.limit_in_bytes":
+			memory, ok := lxcImportMemory(value)
+			if !ok {
+				raw = append(raw, line)
+				continue
+			}
+			result.Config["limits.memory"] = memory
+		case key == "lxc.cgroup.cpuset.cpus":
+			// A single number is a CPU id, not a count
+			if _, err := strconv.Atoi(value); err == nil {
+				value = value + "-" + value
+			}
+			result.Config["limits.cpu"] = value
+		case strings.HasPrefix(key, "lxc."):
+			raw = append(raw, line)
+		default:
+			result.Ignored = append(result.Ignored, key)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	for i, settings := range networks {
+		device, err := lxcImportNic(settings)
+		if err != nil {
+			return nil, err
+		}
+
+		if device == nil {
+			continue
+		}
+
+		name := device["name"]
+		if name == "" {
+			name = fmt.Sprintf("eth%d", i)
+		}
+
+		for key := range settings {
+			if key != "type" && lxcImportNicKeys[key] == "" {
+				result.Ignored = append(result.Ignored, fmt.Sprintf("network %s: %s", name, key))
+			}
+		}
+
+		result.Devices[name] = device
+	}
+
+	if len(raw) > 0 {
+		result.Config["raw.lxc"] = strings.Join(raw, "\n") + "\n"
+	}
+
+	// Containers without an id map are privileged
+	if len(result.Idmap) == 0 {
+		result.Config["security.privileged"] = "true"
+	}
+
+	return result, nil
+}
+
+// lxcImportContainer creates a container out of a stopped classic LXC
+// container. On the dir backend the rootfs is moved in place when it lives
+// on the same filesystem, leaving the LXC container behind as config.imported,
+// otherwise it's copied and the LXC container is left alone. Snapshots aren't
+// imported.
+func lxcImportContainer(d *Daemon, lxcpath string, name string) (*lxcImportResult, error) {
+	result := &lxcImportResult{Name: name}
+
+	configPath := filepath.Join(lxcpath, name, "config")
+	f, err := os.Open(configPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	config, err := lxcImportParse(f)
+	if err != nil {
+		return nil, err
+	}
+	result.Ignored = config.Ignored
+
+	if config.Rootfs == "" {
+		config.Rootfs = filepath.Join(lxcpath, name, "rootfs")
+	}
+
+	if !shared.IsDir(config.Rootfs) {
+		return nil, fmt.Errorf("Rootfs %s isn't a directory", config.Rootfs)
+	}
+
+	cc, err := lxc.NewContainer(name, lxcpath)
+	if err != nil {
+		return nil, err
+	}
+
+	if cc.Running() {
+		return nil, fmt.Errorf("The container must be stopped first")
+	}
+
+	_, err = dbContainerId(d.db, name)
+	if err == nil {
+		return nil, fmt.Errorf("A container named %s already exists", name)
+	}
+
+	args := containerArgs{
+		Architecture: config.Architecture,
+		Config:       config.Config,
+		Ctype:        cTypeRegular,
+		Devices:      config.Devices,
+		Name:         name,
+	}
+
+	c, err := containerCreateAsEmpty(d, args)
+	if err != nil {
+		return nil, err
+	}
+
+	// The rootfs gets shifted to our map on first start
+	idmap := "[]"
+	if len(config.Idmap) > 0 {
+		idmapBytes, err := json.Marshal(config.Idmap)
+		if err != nil {
+			c.Delete()
+			return nil, err
+		}
+		idmap = string(idmapBytes)
+	}
+
+	err = c.ConfigKeySet("volatile.last_state.idmap", idmap)
+	if err != nil {
+		c.Delete()
+		return nil, err
+	}
+
+	if d.Storage.GetStorageType() == storageTypeDir {
+		err = os.Rename(config.Rootfs, c.RootfsPath())
+		if err == nil {
+			result.Moved = true
+			err = os.Rename(configPath, configPath+".imported")
+			if err != nil {
+				shared.Log.Warn("Failed to disable the imported LXC container", log.Ctx{"name": name, "err": err})
+			}
+
+			return result, nil
+		}
+	}
+
+	err = c.StorageStart()
+	if err != nil {
+		c.Delete()
+		return nil, err
+	}
+
+	output, err := storageRsyncCopy(config.Rootfs, c.RootfsPath())
+	c.StorageStop()
+	if err != nil {
+		c.Delete()
+		return nil, fmt.Errorf("Failed to copy the rootfs: %s: %s", err, output)
+	}
+
+	return result, nil
+}
+
+func internalImportLXC(d *Daemon, r *http.Request) Response {
+	req := lxcImportReq{}
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	if req.LxcPath == "" {
+		req.LxcPath = lxc.DefaultConfigPath()
+	}
+
+	if req.LxcPath == d.lxcpath {
+		return BadRequest(fmt.Errorf("%s holds LXD's own containers", req.LxcPath))
+	}
+
+	names := req.Names
+	if len(names) == 0 {
+		entries, err := ioutil.ReadDir(req.LxcPath)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		for _, entry := range entries {
+			if entry.IsDir() && shared.PathExists(filepath.Join(req.LxcPath, entry.Name(), "config")) {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+
+	results := []*lxcImportResult{}
+	for _, name := range names {
+		result, err := lxcImportContainer(d, req.LxcPath, name)
+		if err != nil {
+			result = &lxcImportResult{Name: name, Error: err.Error()}
+		}
+
+		results = append(results, result)
+	}
+
+	return SyncResponse(true, results)
+}
+
+var internalImportLXCCmd = Command{name: "import-lxc", post: internalImportLXC}
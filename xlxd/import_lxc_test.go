@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+const lxcImportTestConfig = `# Template used to create this container
+lxc.include = /usr/share/lxc/config/ubuntu.common.conf
+lxc.arch = amd64
+lxc.rootfs = dir:/srv/lxc/c1/rootfs
+lxc.utsname = c1
+lxc.id_map = u 0 100000 65536
+lxc.id_map = g 0 100000 65536
+
+lxc.network.type = veth
+lxc.network.link = lxcbr0
+lxc.network.hwaddr = 00:16:3e:00:00:01
+lxc.network.flags = up
+lxc.network.type = empty
+
+lxc.mount.entry = /srv/data srv/data none bind,ro,create=dir 0 0
+lxc.mount.entry = tmpfs tmp tmpfs defaults 0 0
+lxc.start.auto = 1
+lxc.cgroup.memory.limit_in_bytes = 536870912
+lxc.cgroup.cpuset.cpus = 1
+lxc.cap.drop = sys_time
+`
+
+func TestLxcImportParse(t *testing.T) {
+	config, err := lxcImportParse(strings.NewReader(lxcImportTestConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Architecture != shared.ARCH_64BIT_INTEL_X86 {
+		t.Errorf("Wrong architecture: %d", config.Architecture)
+	}
+
+	if config.Rootfs != "/srv/lxc/c1/rootfs" {
+		t.Errorf("Wrong rootfs: %s", config.Rootfs)
+	}
+
+	if len(config.Idmap) != 2 || !config.Idmap[0].Isuid || config.Idmap[1].Hostid != 100000 {
+		t.Errorf("Wrong id map: %v", config.Idmap)
+	}
+
+	expectedConfig := map[string]string{
+		"boot.autostart": "true",
+		"limits.memory":  "512MB",
+		"limits.cpu":     "1-1",
+		"raw.lxc":        "lxc.mount.entry = tmpfs tmp tmpfs defaults 0 0\nlxc.cap.drop = sys_time\n",
+	}
+
+	if !reflect.DeepEqual(config.Config, expectedConfig) {
+		t.Errorf("Wrong config: %v", config.Config)
+	}
+
+	expectedDevices := shared.Devices{
+		"eth0": shared.Device{
+			"type":    "nic",
+			"nictype": "bridged",
+			"parent":  "lxcbr0",
+			"hwaddr":  "00:16:3e:00:00:01",
+			"flags":   "up",
+		},
+		"mount1": shared.Device{
+			"type":     "disk",
+			"source":   "/srv/data",
+			"path":     "/srv/data",
+			"readonly": "true",
+		},
+	}
+
+	if !reflect.DeepEqual(config.Devices, expectedDevices) {
+		t.Errorf("Wrong devices: %v", config.Devices)
+	}
+
+	if !reflect.DeepEqual(config.Ignored, []string{"lxc.include", "lxc.utsname"}) {
+		t.Errorf("Wrong ignored keys: %v", config.Ignored)
+	}
+}
+
+func TestLxcImportParsePrivileged(t *testing.T) {
+	config, err := lxcImportParse(strings.NewReader("lxc.net.0.type = macvlan\nlxc.net.0.link = eth0\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if config.Config["security.privileged"] != "true" {
+		t.Errorf("Container without an id map isn't privileged")
+	}
+
+	if config.Devices["eth0"]["nictype"] != "macvlan" {
+		t.Errorf("Wrong devices: %v", config.Devices)
+	}
+
+	_, err = lxcImportParse(strings.NewReader("lxc.rootfs = zfs:tank/c1\n"))
+	if err == nil {
+		t.Error("Unsupported rootfs wasn't caught")
+	}
+}
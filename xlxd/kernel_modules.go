@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+var kernelModuleName = regexp.MustCompile(`^[a-zA-Z0-9_][a-zA-Z0-9_-]*$`)
+
+// kernelModulesParse returns the modules listed in linux.kernel_modules,
+// separated by commas.
+func kernelModulesParse(value string) ([]string, error) {
+	modules := []string{}
+	for _, module := range strings.Split(value, ",") {
+		module = strings.TrimSpace(module)
+		if module == "" {
+			continue
+		}
+
+		if !kernelModuleName.MatchString(module) {
+			return nil, fmt.Errorf("Invalid kernel module name: %s", module)
+		}
+
+		modules = append(modules, module)
+	}
+
+	return modules, nil
+}
+
+// kernelModuleLoaded tells whether the module is loaded or built in, the
+// kernel showing both under /sys/module with dashes turned to underscores.
+func kernelModuleLoaded(module string) bool {
+	return shared.PathExists(fmt.Sprintf("/sys/module/%s", strings.Replace(module, "-", "_", -1)))
+}
+
+// kernelModulesLoad loads the modules which aren't already on the host.
+func kernelModulesLoad(modules []string) error {
+	for _, module := range modules {
+		if kernelModuleLoaded(module) {
+			continue
+		}
+
+		out, err := exec.Command("modprobe", "-b", module).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to load the kernel module %s: %s", module, strings.TrimSpace(string(out)))
+		}
+
+		shared.Log.Info("Loaded a kernel module", log.Ctx{"module": module})
+	}
+
+	return nil
+}
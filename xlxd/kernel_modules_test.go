@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestKernelModulesParse(t *testing.T) {
+	valid := map[string][]string{
+		"":                    {},
+		"ip_vs":               {"ip_vs"},
+		"ip_vs, wireguard,,":  {"ip_vs", "wireguard"},
+		"nf-nat,br_netfilter": {"nf-nat", "br_netfilter"},
+	}
+
+	for value, expected := range valid {
+		modules, err := kernelModulesParse(value)
+		if err != nil {
+			t.Errorf("Refused the kernel modules %q: %s", value, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(modules, expected) {
+			t.Errorf("Wrong kernel modules for %q: %v", value, modules)
+		}
+	}
+
+	for _, value := range []string{"ip_vs nf_nat", "../evil", "ip_vs,-r", "mod;reboot"} {
+		_, err := kernelModulesParse(value)
+		if err == nil {
+			t.Errorf("Accepted the kernel modules %q", value)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// Policies which can be attached to a core.https_address entry.
+const (
+	// Full API access, subject to the usual trust checks.
+	httpsPolicyAll = "all"
+
+	// Only the image listing and download endpoints.
+	httpsPolicyImages = "images"
+
+	// Only GET requests.
+	httpsPolicyReadOnly = "readonly"
+)
+
+// httpsAddress is one entry of core.https_address, the value is a comma
+// separated list of entries, each being "ADDRESS[;POLICY]".
+type httpsAddress struct {
+	address string
+	policy  string
+}
+
+// httpsAddressNormalize adds the default port to an address lacking one.
+func httpsAddressNormalize(address string) string {
+	_, _, err := net.SplitHostPort(address)
+	if err == nil {
+		return address
+	}
+
+	address = strings.TrimSuffix(strings.TrimPrefix(address, "["), "]")
+	ip := net.ParseIP(address)
+	if ip != nil && ip.To4() == nil {
+		return fmt.Sprintf("[%s]:%s", address, shared.DefaultPort)
+	}
+
+	return fmt.Sprintf("%s:%s", address, shared.DefaultPort)
+}
+
+func httpsAddressesParse(value string) ([]httpsAddress, error) {
+	entries := []httpsAddress{}
+	seen := map[string]bool{}
+
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		entry := httpsAddress{policy: httpsPolicyAll}
+		fields := strings.SplitN(field, ";", 2)
+		if len(fields) == 2 {
+			entry.policy = strings.TrimSpace(fields[1])
+		}
+
+		if !shared.StringInSlice(entry.policy, []string{httpsPolicyAll, httpsPolicyImages, httpsPolicyReadOnly}) {
+			return nil, fmt.Errorf("Invalid policy for %s: %s", fields[0], entry.policy)
+		}
+
+		entry.address = httpsAddressNormalize(strings.TrimSpace(fields[0]))
+		_, _, err := net.SplitHostPort(entry.address)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid address %s: %v", fields[0], err)
+		}
+
+		if seen[entry.address] {
+			return nil, fmt.Errorf("Address %s is listed more than once", fields[0])
+		}
+		seen[entry.address] = true
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// httpsPolicyAllows returns whether a listener with the given policy may serve
+// the request. Only the listeners without restriction relay connections:
+// tunnels and websockets, like the ones of exec, come through a GET.
+func httpsPolicyAllows(policy string, r *http.Request) bool {
+	if policy == "" || policy == httpsPolicyAll {
+		return true
+	}
+
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		return false
+	}
+
+	switch policy {
+	case httpsPolicyReadOnly:
+		if strings.TrimSuffix(r.URL.Path, "/") == fmt.Sprintf("/%s/tunnel", shared.APIVersion) {
+			return false
+		}
+
+		return r.Method == "GET" || r.Method == "HEAD"
+	case httpsPolicyImages:
+		if r.Method != "GET" && r.Method != "HEAD" {
+			return false
+		}
+
+		path := strings.TrimSuffix(r.URL.Path, "/")
+		prefix := fmt.Sprintf("/%s/images", shared.APIVersion)
+		return path == "" || path == "/"+shared.APIVersion || path == prefix || strings.HasPrefix(path, prefix+"/")
+	}
+
+	return false
+}
+
+// httpsPolicyHandler restricts a handler to the requests allowed by a policy.
+func httpsPolicyHandler(policy string, handler http.Handler) http.Handler {
+	if policy == "" || policy == httpsPolicyAll {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !httpsPolicyAllows(policy, r) {
+			shared.Debugf("Rejecting %s %s on a %s listener", r.Method, r.URL.Path, policy)
+			Forbidden.Render(w)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
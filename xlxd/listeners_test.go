@@ -0,0 +1,93 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestHttpsAddressesParse(t *testing.T) {
+	entries, err := httpsAddressesParse("127.0.0.1, [::1]:8444;images,::2;readonly")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []httpsAddress{
+		{address: "127.0.0.1:9443", policy: httpsPolicyAll},
+		{address: "[::1]:8444", policy: httpsPolicyImages},
+		{address: "[::2]:9443", policy: httpsPolicyReadOnly},
+	}
+
+	if len(entries) != len(expected) {
+		t.Fatalf("Got %d entries instead of %d", len(entries), len(expected))
+	}
+
+	for i := range expected {
+		if entries[i] != expected[i] {
+			t.Errorf("Got %v instead of %v", entries[i], expected[i])
+		}
+	}
+
+	entries, err = httpsAddressesParse("")
+	if err != nil || len(entries) != 0 {
+		t.Errorf("Empty value didn't give an empty list: %v, %v", entries, err)
+	}
+
+	for _, value := range []string{"127.0.0.1;bogus", ":::", "127.0.0.1,127.0.0.1:9443"} {
+		_, err := httpsAddressesParse(value)
+		if err == nil {
+			t.Errorf("Invalid value %q was accepted", value)
+		}
+	}
+}
+
+func TestHttpsPolicyAllows(t *testing.T) {
+	tests := []struct {
+		policy  string
+		method  string
+		path    string
+		allowed bool
+	}{
+		{httpsPolicyAll, "POST", "/1.0/containers", true},
+		{httpsPolicyReadOnly, "GET", "/1.0/containers", true},
+		{httpsPolicyReadOnly, "PUT", "/1.0", false},
+		{httpsPolicyImages, "GET", "/1.0", true},
+		{httpsPolicyImages, "GET", "/1.0/images/abcd/export", true},
+		{httpsPolicyImages, "GET", "/1.0/containers", false},
+		{httpsPolicyImages, "GET", "/1.0/imagesfoo", false},
+		{httpsPolicyImages, "DELETE", "/1.0/images/abcd", false},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if httpsPolicyAllows(test.policy, r) != test.allowed {
+			t.Errorf("%s %s on a %s listener: expected allowed=%v", test.method, test.path, test.policy, test.allowed)
+		}
+	}
+
+	// Nothing gets relayed through the restricted listeners
+	for _, policy := range []string{httpsPolicyReadOnly, httpsPolicyImages} {
+		r, err := http.NewRequest("GET", "/1.0/tunnel?address=127.0.0.1:22", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if httpsPolicyAllows(policy, r) {
+			t.Errorf("Tunnel allowed on a %s listener", policy)
+		}
+
+		r, err = http.NewRequest("GET", "/1.0/operations/1234/websocket?secret=abcd", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Connection", "Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+
+		if httpsPolicyAllows(policy, r) {
+			t.Errorf("Websocket allowed on a %s listener", policy)
+		}
+	}
+}
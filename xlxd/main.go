@@ -2,12 +2,16 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"math/rand"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"runtime/pprof"
 	"strconv"
 	"strings"
@@ -16,6 +20,8 @@ import (
 	"time"
 
 	"golang.org/x/crypto/ssh/terminal"
+	log "gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/yaml.v2"
 
 	"github.com/krschwab/xlxd"
 	"github.com/krschwab/xlxd/shared"
@@ -30,9 +36,12 @@ var argDebug = gnuflag.Bool("debug", false, "")
 var argGroup = gnuflag.String("group", "", "")
 var argHelp = gnuflag.Bool("help", false, "")
 var argLogfile = gnuflag.String("logfile", "", "")
+var argLxcPath = gnuflag.String("lxcpath", "", "")
 var argMemProfile = gnuflag.String("memprofile", "", "")
 var argNetworkAddress = gnuflag.String("network-address", "", "")
+var argNetworkBridge = gnuflag.String("network-bridge", "", "")
 var argNetworkPort = gnuflag.Int("network-port", -1, "")
+var argPreseed = gnuflag.Bool("preseed", false, "")
 var argPrintGoroutinesEvery = gnuflag.Int("print-goroutines-every", -1, "")
 var argStorageBackend = gnuflag.String("storage-backend", "dir", "")
 var argStorageCreateDevice = gnuflag.String("storage-create-device", "", "")
@@ -67,12 +76,20 @@ func run() error {
 		fmt.Printf("\nCommands:\n")
 		fmt.Printf("    activateifneeded\n")
 		fmt.Printf("        Check if LXD should be started (at boot) and if so, spawns it through socket activation\n")
+		fmt.Printf("    backup create|restore PATH\n")
+		fmt.Printf("        Backup or restore the database, certificates and configuration (not the containers)\n")
 		fmt.Printf("    daemon [--group=lxd] (default command)\n")
 		fmt.Printf("        Start the main LXD daemon\n")
-		fmt.Printf("    init [--auto] [--network-address=IP] [--network-port=9443] [--storage-backend=dir]\n")
-		fmt.Printf("         [--storage-create-device=DEVICE] [--storage-create-loop=SIZE] [--storage-pool=POOL]\n")
-		fmt.Printf("         [--trust-password=]\n")
-		fmt.Printf("        Setup storage and networking\n")
+		fmt.Printf("    import-lxc [--lxcpath=/var/lib/lxc] [NAME...]\n")
+		fmt.Printf("        Import stopped LXC containers (all of them if none is given)\n")
+		fmt.Printf("    init [--auto] [--network-address=IP] [--network-port=9443] [--network-bridge=BRIDGE]\n")
+		fmt.Printf("         [--storage-backend=dir] [--storage-create-device=DEVICE] [--storage-create-loop=SIZE]\n")
+		fmt.Printf("         [--storage-pool=POOL] [--trust-password=]\n")
+		fmt.Printf("        Setup storage, networking and the default profile\n")
+		fmt.Printf("    init --preseed < FILE\n")
+		fmt.Printf("        Apply the server config, networks and profiles described by a YAML document\n")
+		fmt.Printf("    recover [--auto]\n")
+		fmt.Printf("        Re-create the database records of containers found on the storage backend\n")
 		fmt.Printf("    shutdown [--timeout=60]\n")
 		fmt.Printf("        Perform a clean shutdown of LXD and all running containers\n")
 		fmt.Printf("    waitready [--timeout=15]\n")
@@ -104,6 +121,10 @@ func run() error {
 		fmt.Printf("    --print-goroutines-every SECONDS\n")
 		fmt.Printf("        For debugging, print a complete stack trace every n seconds\n")
 
+		fmt.Printf("\nImport-lxc options:\n")
+		fmt.Printf("    --lxcpath PATH\n")
+		fmt.Printf("        Where the LXC containers are (default: LXC's lxcpath)\n")
+
 		fmt.Printf("\nInit options:\n")
 		fmt.Printf("    --auto\n")
 		fmt.Printf("        Automatic (non-interactive) mode\n")
@@ -111,6 +132,10 @@ func run() error {
 		fmt.Printf("        Address to bind LXD to (default: none)\n")
 		fmt.Printf("    --network-port PORT\n")
 		fmt.Printf("        Port to bind LXD to (default: 9443)\n")
+		fmt.Printf("    --preseed\n")
+		fmt.Printf("        Read the YAML preseed document from stdin\n")
+		fmt.Printf("    --network-bridge BRIDGE\n")
+		fmt.Printf("        Bridge the default profile connects containers to, created if missing\n")
 		fmt.Printf("    --storage-backend NAME\n")
 		fmt.Printf("        Storage backend to use (zfs or dir, default: dir)\n")
 		fmt.Printf("    --storage-create-device DEVICE\n")
@@ -122,6 +147,10 @@ func run() error {
 		fmt.Printf("    --trust-password PASSWORD\n")
 		fmt.Printf("        Password required to add new clients\n")
 
+		fmt.Printf("\nRecover options:\n")
+		fmt.Printf("    --auto\n")
+		fmt.Printf("        Recover everything without asking\n")
+
 		fmt.Printf("\nShutdown options:\n")
 		fmt.Printf("    --timeout SECONDS\n")
 		fmt.Printf("        How long to wait before failing\n")
@@ -135,10 +164,16 @@ func run() error {
 		fmt.Printf("        Grab a file from a running container\n")
 		fmt.Printf("    forkmigrate\n")
 		fmt.Printf("        Restore a container after migration\n")
+		fmt.Printf("    forkmknod\n")
+		fmt.Printf("        Create a device node for a container\n")
 		fmt.Printf("    forkputfile\n")
 		fmt.Printf("        Push a file to a running container\n")
+		fmt.Printf("    forksetxattr\n")
+		fmt.Printf("        Set an extended attribute for a container\n")
 		fmt.Printf("    forkstart\n")
 		fmt.Printf("        Start a container\n")
+		fmt.Printf("    forkuserns\n")
+		fmt.Printf("        Hold a user namespace for an idmapped mount\n")
 		fmt.Printf("    callhook\n")
 		fmt.Printf("        Call a container hook\n")
 	}
@@ -188,6 +223,8 @@ func run() error {
 		switch os.Args[1] {
 		case "activateifneeded":
 			return activateIfNeeded()
+		case "backup":
+			return backup(gnuflag.Args())
 		case "daemon":
 			return daemon()
 		case "forkmigrate":
@@ -196,8 +233,12 @@ func run() error {
 			return startContainer(os.Args[1:])
 		case "callhook":
 			return callHook(os.Args[1:])
+		case "import-lxc":
+			return importLXC(gnuflag.Args()[1:])
 		case "init":
 			return setupLXD()
+		case "recover":
+			return recoverVolumes()
 		case "shutdown":
 			return cleanShutdown()
 		case "waitready":
@@ -311,11 +352,27 @@ func daemon() error {
 	var wg sync.WaitGroup
 	wg.Add(1)
 
+	go func() {
+		ch := make(chan os.Signal)
+		signal.Notify(ch, syscall.SIGHUP)
+		for range ch {
+			shared.Log.Info("Received 'hangup signal', reloading the server certificate.")
+
+			sdNotify("RELOADING=1")
+			err := d.CertificateLoad()
+			if err != nil {
+				shared.Log.Error("Failed to reload the server certificate", log.Ctx{"err": err})
+			}
+			sdNotify("READY=1")
+		}
+	}()
+
 	go func() {
 		ch := make(chan os.Signal)
 		signal.Notify(ch, syscall.SIGPWR)
 		sig := <-ch
 
+		sdNotify("STOPPING=1")
 		shared.Log.Info(
 			fmt.Sprintf("Received '%s signal', shutting down containers.", sig))
 
@@ -328,6 +385,7 @@ func daemon() error {
 	go func() {
 		<-d.shutdownChan
 
+		sdNotify("STOPPING=1")
 		shared.Log.Info(
 			fmt.Sprintf("Asked to shutdown by API, shutting down containers."))
 
@@ -344,6 +402,7 @@ func daemon() error {
 		signal.Notify(ch, syscall.SIGTERM)
 		sig := <-ch
 
+		sdNotify("STOPPING=1")
 		shared.Log.Info(fmt.Sprintf("Received '%s signal', exiting.\n", sig))
 		ret = d.Stop()
 		wg.Done()
@@ -392,6 +451,158 @@ func cleanShutdown() error {
 	return nil
 }
 
+func importLXC(names []string) error {
+	c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+	if err != nil {
+		return err
+	}
+
+	body, err := json.Marshal(lxcImportReq{LxcPath: *argLxcPath, Names: names})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", c.BaseURL+"/internal/import-lxc", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	raw, err := c.Http.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := lxd.HoistResponse(raw, lxd.Sync)
+	if err != nil {
+		return err
+	}
+
+	results := []lxcImportResult{}
+	err = json.Unmarshal(resp.Metadata, &results)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+			fmt.Printf("%s: failed: %s\n", result.Name, result.Error)
+			continue
+		}
+
+		if result.Moved {
+			fmt.Printf("%s: imported, the rootfs was moved\n", result.Name)
+		} else {
+			fmt.Printf("%s: imported, the rootfs was copied\n", result.Name)
+		}
+
+		for _, key := range result.Ignored {
+			fmt.Printf("    ignored %s\n", key)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to import %d of %d containers", failed, len(results))
+	}
+
+	return nil
+}
+
+func recoverVolumes() error {
+	c, err := lxd.NewClient(&lxd.DefaultConfig, "local")
+	if err != nil {
+		return err
+	}
+
+	query := func(method string, body interface{}, target interface{}) error {
+		data := []byte{}
+		if body != nil {
+			data, err = json.Marshal(body)
+			if err != nil {
+				return err
+			}
+		}
+
+		req, err := http.NewRequest(method, c.BaseURL+"/internal/recover", bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+
+		raw, err := c.Http.Do(req)
+		if err != nil {
+			return err
+		}
+
+		resp, err := lxd.HoistResponse(raw, lxd.Sync)
+		if err != nil {
+			return err
+		}
+
+		return json.Unmarshal(resp.Metadata, target)
+	}
+
+	volumes := []recoverVolume{}
+	err = query("GET", nil, &volumes)
+	if err != nil {
+		return err
+	}
+
+	if len(volumes) == 0 {
+		fmt.Printf("No unknown containers were found.\n")
+		return nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	names := []string{}
+	for _, volume := range volumes {
+		fmt.Printf("Found %s", volume.Name)
+		if len(volume.Snapshots) > 0 {
+			fmt.Printf(" (snapshots: %s)", strings.Join(volume.Snapshots, ", "))
+		}
+		fmt.Printf("\n")
+
+		if !*argAuto {
+			fmt.Printf("Recover it? (yes/no) [default=yes]: ")
+			input, _ := reader.ReadString('\n')
+			input = strings.TrimSpace(input)
+			if input != "" && !shared.StringInSlice(strings.ToLower(input), []string{"yes", "y"}) {
+				continue
+			}
+		}
+
+		names = append(names, volume.Name)
+	}
+
+	if len(names) == 0 {
+		return nil
+	}
+
+	results := []recoverResult{}
+	err = query("POST", recoverReq{Names: names}, &results)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+			fmt.Printf("%s: failed: %s\n", result.Name, result.Error)
+			continue
+		}
+
+		fmt.Printf("%s: recovered\n", result.Name)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("Failed to recover %d of %d containers", failed, len(results))
+	}
+
+	fmt.Printf("The recovered containers only use the default profile, check their configuration before starting them.\n")
+	return nil
+}
+
 func activateIfNeeded() error {
 	// Don't start a full daemon, we just need DB access
 	d := &Daemon{
@@ -423,7 +634,7 @@ func activateIfNeeded() error {
 		return err
 	}
 
-	containers, err := doContainersGet(d, true)
+	containers, _, err := doContainersGet(d, 1, "", paginationAll)
 	if err != nil {
 		return err
 	}
@@ -492,6 +703,8 @@ func setupLXD() error {
 	var networkAddress string // Address
 	var networkPort int       // Port
 	var trustPassword string  // Trust password
+	var bridgeName string     // Bridge of the default profile
+	var bridgeCreate bool     // Whether to create the bridge
 
 	reader := bufio.NewReader(os.Stdin)
 
@@ -579,6 +792,11 @@ func setupLXD() error {
 		return fmt.Errorf("Unable to talk to LXD: %s", err)
 	}
 
+	// Preseeding only changes what differs, so it can be re-applied
+	if *argPreseed {
+		return setupLXDPreseed(c)
+	}
+
 	// Check that we have no containers or images in the store
 	containers, err := c.ListContainers()
 	if err != nil {
@@ -637,6 +855,8 @@ func setupLXD() error {
 		networkAddress = *argNetworkAddress
 		networkPort = *argNetworkPort
 		trustPassword = *argTrustPassword
+		bridgeName = *argNetworkBridge
+		bridgeCreate = bridgeName != "" && !shared.PathExists(filepath.Join("/sys/class/net", bridgeName))
 	} else {
 		storageBackend = askChoice("Name of the storage backend to use (dir or zfs): ", []string{"dir", "zfs"})
 
@@ -661,6 +881,20 @@ func setupLXD() error {
 			networkPort = askInt("Port to bind LXD to: ", 1, 65535)
 			trustPassword = askPassword("Trust password for new clients: ")
 		}
+
+		if askBool("Would you like to choose the bridge containers get connected to (yes/no)? ") {
+			bridgeName = askString("Name of the bridge: ")
+			if !shared.PathExists(filepath.Join("/sys/class/net", bridgeName)) {
+				bridgeCreate = askBool(fmt.Sprintf("%s doesn't exist, would you like to create it (yes/no)? ", bridgeName))
+				if !bridgeCreate {
+					return fmt.Errorf("The bridge %s doesn't exist", bridgeName)
+				}
+			}
+		}
+	}
+
+	if bridgeName != "" && !bridgeCreate && !shared.PathExists(filepath.Join("/sys/class/net", bridgeName, "bridge")) {
+		return fmt.Errorf("%s isn't a bridge", bridgeName)
 	}
 
 	if !shared.StringInSlice(storageBackend, []string{"dir", "zfs"}) {
@@ -735,6 +969,71 @@ func setupLXD() error {
 		}
 	}
 
+	if bridgeName != "" {
+		if bridgeCreate {
+			output, err := exec.Command("ip", "link", "add", "dev", bridgeName, "type", "bridge").CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("Failed to create the bridge: %s", output)
+			}
+
+			output, err = exec.Command("ip", "link", "set", "dev", bridgeName, "up").CombinedOutput()
+			if err != nil {
+				return fmt.Errorf("Failed to bring the bridge up: %s", output)
+			}
+		}
+
+		// Connect the containers using the default profile to it
+		profile, err := c.ProfileConfig("default")
+		if err != nil {
+			return err
+		}
+
+		if profile.Devices == nil {
+			profile.Devices = shared.Devices{}
+		}
+
+		profile.Devices["eth0"] = shared.Device{
+			"type":    "nic",
+			"nictype": "bridged",
+			"parent":  bridgeName,
+		}
+
+		err = c.PutProfile("default", *profile)
+		if err != nil {
+			return err
+		}
+	}
+
 	fmt.Printf("LXD has been succesfuly configured.\n")
 	return nil
 }
+
+// setupLXDPreseed applies the YAML preseed document read from stdin.
+func setupLXDPreseed(c *lxd.Client) error {
+	content, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return err
+	}
+
+	preseed := shared.Preseed{}
+	err = yaml.Unmarshal(content, &preseed)
+	if err != nil {
+		return fmt.Errorf("Failed to parse the preseed: %s", err)
+	}
+
+	changes, err := c.ApplyPreseed(preseed)
+	if err != nil {
+		return err
+	}
+
+	if len(changes) == 0 {
+		fmt.Printf("LXD is already configured that way.\n")
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Printf("%s\n", change)
+	}
+
+	return nil
+}
@@ -16,6 +16,7 @@ import (
 	"path"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/golang/protobuf/proto"
@@ -38,6 +39,9 @@ type migrationFields struct {
 	fsSecret string
 	fsConn   *websocket.Conn
 
+	// The tunnel the transfer goes through, see migrate_wireguard.go
+	wireguard *migrationWireGuard
+
 	container container
 }
 
@@ -157,14 +161,21 @@ func GetCRIULogErrors(imagesDir string, method string) string {
 	return strings.Join(ret, "\n")
 }
 
+// How long a source waits for the sink to connect before giving up
+const migrationConnectTimeout = 10 * time.Minute
+
 type migrationSourceWs struct {
 	migrationFields
 
 	allConnected chan bool
+
+	// Only a migration still waiting for its sink can be cancelled
+	cancel  chan bool
+	running chan bool
 }
 
 func NewMigrationSource(c container) (*migrationSourceWs, error) {
-	ret := migrationSourceWs{migrationFields{container: c}, make(chan bool, 1)}
+	ret := migrationSourceWs{migrationFields{container: c}, make(chan bool, 1), make(chan bool), make(chan bool)}
 
 	var err error
 	ret.controlSecret, err = shared.RandomCryptoString()
@@ -178,7 +189,7 @@ func NewMigrationSource(c container) (*migrationSourceWs, error) {
 	}
 
 	if c.IsRunning() {
-		if err := findCriu("source"); err != nil {
+		if err := containerCapabilityCheck(c, "checkpoint"); err != nil {
 			return nil, err
 		}
 
@@ -191,9 +202,52 @@ func NewMigrationSource(c container) (*migrationSourceWs, error) {
 		c.StorageStart()
 	}
 
+	enabled, _ := c.Daemon().ConfigValueGet("migration.wireguard")
+	if shared.IsTrue(enabled) {
+		err = ret.wireguardSetup()
+		if err != nil {
+			if !ret.live {
+				c.StorageStop()
+			}
+			return nil, err
+		}
+	}
+
 	return &ret, nil
 }
 
+// wireguardSetup creates the source end of the tunnel, serving the transfer
+// websockets in it.
+func (s *migrationSourceWs) wireguardSetup() error {
+	err := migrationWireGuardCheck()
+	if err != nil {
+		return err
+	}
+
+	subnet, err := migrationWireGuardSubnet()
+	if err != nil {
+		return err
+	}
+
+	s.wireguard, err = migrationWireGuardCreate(s.container.Daemon(), subnet, true)
+	if err != nil {
+		return err
+	}
+
+	err = s.wireguard.serve(func(w http.ResponseWriter, r *http.Request) {
+		err := s.connect(r, w, true)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+		}
+	})
+	if err != nil {
+		s.wireguard.remove()
+		return err
+	}
+
+	return nil
+}
+
 func (s *migrationSourceWs) Metadata() interface{} {
 	secrets := shared.Jmap{
 		"control": s.controlSecret,
@@ -204,10 +258,23 @@ func (s *migrationSourceWs) Metadata() interface{} {
 		secrets["criu"] = s.criuSecret
 	}
 
+	if s.wireguard != nil {
+		address, _ := s.container.Daemon().ConfigValueGet("migration.wireguard_address")
+		for k, v := range s.wireguard.secrets(address) {
+			secrets[k] = v
+		}
+	}
+
 	return secrets
 }
 
 func (s *migrationSourceWs) Connect(op *operation, r *http.Request, w http.ResponseWriter) error {
+	return s.connect(r, w, false)
+}
+
+// connect accepts a websocket, only the transfer ones coming through the
+// tunnel when there's one.
+func (s *migrationSourceWs) connect(r *http.Request, w http.ResponseWriter, tunnel bool) error {
 	secret := r.FormValue("secret")
 	if secret == "" {
 		return fmt.Errorf("missing secret")
@@ -217,11 +284,33 @@ func (s *migrationSourceWs) Connect(op *operation, r *http.Request, w http.Respo
 
 	switch secret {
 	case s.controlSecret:
+		if tunnel {
+			return os.ErrPermission
+		}
+
+		// The sink gives its key along with the control websocket
+		if s.wireguard != nil {
+			key := r.FormValue("wireguard_key")
+			err := migrationWireGuardVerify(s.container.Daemon().clientCerts, r.FormValue("wireguard_signature"), key, s.wireguard.subnet.String())
+			if err == nil {
+				err = s.wireguard.peer(key, "")
+			}
+
+			if err != nil {
+				return fmt.Errorf("The source requires a WireGuard tunnel: %s", err)
+			}
+		}
+
 		conn = &s.controlConn
-	case s.criuSecret:
-		conn = &s.criuConn
-	case s.fsSecret:
+	case s.criuSecret, s.fsSecret:
+		if s.wireguard != nil && !tunnel {
+			return fmt.Errorf("The transfer must go through the WireGuard tunnel")
+		}
+
 		conn = &s.fsConn
+		if secret == s.criuSecret {
+			conn = &s.criuConn
+		}
 	default:
 		/* If we didn't find the right secret, the user provided a bad one,
 		 * which 403, not 404, since this operation actually exists */
@@ -242,8 +331,22 @@ func (s *migrationSourceWs) Connect(op *operation, r *http.Request, w http.Respo
 	return nil
 }
 
+// Cancel gives up on a migration whose sink didn't connect yet.
+func (s *migrationSourceWs) Cancel(op *operation) error {
+	select {
+	case s.cancel <- true:
+		return nil
+	case <-s.running:
+		return fmt.Errorf("The sink is already connected")
+	}
+}
+
 func (s *migrationSourceWs) Do(op *operation) error {
-	<-s.allConnected
+	// The tunnel and the storage go away however the migration ends,
+	// including when the sink never shows up
+	if s.wireguard != nil {
+		defer s.wireguard.remove()
+	}
 
 	criuType := CRIUType_CRIU_RSYNC.Enum()
 	if !s.live {
@@ -251,6 +354,15 @@ func (s *migrationSourceWs) Do(op *operation) error {
 		defer s.container.StorageStop()
 	}
 
+	select {
+	case <-s.allConnected:
+	case <-s.cancel:
+		return fmt.Errorf("The migration was cancelled")
+	case <-time.After(migrationConnectTimeout):
+		return fmt.Errorf("The sink didn't connect within %s", migrationConnectTimeout)
+	}
+	close(s.running)
+
 	idmaps := make([]*IDMapType, 0)
 
 	idmapset := s.container.IdmapSet()
@@ -296,6 +408,7 @@ func (s *migrationSourceWs) Do(op *operation) error {
 		Criu:      criuType,
 		Idmap:     idmaps,
 		Snapshots: snapshots,
+		Delta:     proto.Bool(true),
 	}
 
 	if err := s.send(&header); err != nil {
@@ -313,11 +426,36 @@ func (s *migrationSourceWs) Do(op *operation) error {
 		return err
 	}
 
-	// TODO: actually fall back on rsync.
-	if *header.Fs != myType {
-		err := fmt.Errorf("mismatched storage types not supported yet")
-		s.sendControl(err)
-		return err
+	/* The sink can't receive what our storage sends (e.g. ZFS to
+	 * btrfs), or it's refreshing an existing copy and lists the snapshots
+	 * it already has, so we fall back on rsync. Only the sinks which
+	 * agreed to the delta mode get it, the others get the whole objects
+	 * in the order they always did.
+	 */
+	if header.GetDelta() || *header.Fs != myType {
+		if *header.Fs != MigrationFSType_RSYNC {
+			err := fmt.Errorf("Unsupported storage type for migration: %s", header.Fs.String())
+			s.sendControl(err)
+			return err
+		}
+
+		for _, source := range sources {
+			source.Cleanup()
+		}
+
+		missing := []string{}
+		for _, name := range snapshots {
+			if !shared.StringInSlice(name, header.Snapshots) {
+				missing = append(missing, name)
+			}
+		}
+
+		var err error
+		sources, err = rsyncMigrationFallbackSource(s.container, missing, header.GetDelta())
+		if err != nil {
+			s.sendControl(err)
+			return err
+		}
 	}
 
 	if s.live {
@@ -360,7 +498,7 @@ func (s *migrationSourceWs) Do(op *operation) error {
 		 * no reason to do these in parallel. In the future when we're using
 		 * p.haul's protocol, it will make sense to do these in parallel.
 		 */
-		if err := RsyncSend(shared.AddSlash(checkpointDir), s.criuConn); err != nil {
+		if err := RsyncSend(shared.AddSlash(checkpointDir), s.criuConn, false); err != nil {
 			s.sendControl(err)
 			return err
 		}
@@ -392,22 +530,56 @@ func (s *migrationSourceWs) Do(op *operation) error {
 type migrationSink struct {
 	migrationFields
 
-	url    string
-	dialer websocket.Dialer
+	daemon  *Daemon
+	url     string
+	dialer  websocket.Dialer
+	refresh bool
+
+	// What the source gave to set up its tunnel
+	secrets map[string]string
+
+	// In push mode, the client relays the websockets of the source to
+	// the ones of the sink, which have secrets of their own
+	push         bool
+	pushSecrets  map[string]string
+	allConnected chan bool
+	connectLock  sync.Mutex
+
+	// Only a sink still waiting for the client can be cancelled
+	cancel  chan bool
+	running chan bool
+
+	// Limits what the client relays, the dialer limiting the rest
+	limiter *shared.BandwidthLimiter
 }
 
 type MigrationSinkArgs struct {
+	Daemon    *Daemon
 	Url       string
 	Dialer    websocket.Dialer
 	Container container
 	Secrets   map[string]string
+
+	// The container is an existing copy to bring up to date
+	Refresh bool
+
+	// The websockets get connected by the client rather than dialed
+	Push bool
+
+	// What the transfer is limited to, whichever way it comes
+	Limiter *shared.BandwidthLimiter
 }
 
-func NewMigrationSink(args *MigrationSinkArgs) (func() error, error) {
+func NewMigrationSink(args *MigrationSinkArgs) (*migrationSink, error) {
 	sink := migrationSink{
-		migrationFields{container: args.Container},
-		args.Url,
-		args.Dialer,
+		migrationFields: migrationFields{container: args.Container},
+		daemon:          args.Daemon,
+		url:             args.Url,
+		dialer:          args.Dialer,
+		refresh:         args.Refresh,
+		secrets:         args.Secrets,
+		push:            args.Push,
+		limiter:         args.Limiter,
 	}
 
 	var ok bool
@@ -428,12 +600,123 @@ func NewMigrationSink(args *MigrationSinkArgs) (func() error, error) {
 		return nil, err
 	}
 
-	return sink.do, nil
+	if sink.push {
+		err := sink.pushSetup()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &sink, nil
+}
+
+// pushSetup creates the secrets of the websockets the client connects.
+func (c *migrationSink) pushSetup() error {
+	// The keys of the tunnel would go through the client, and the
+	// servers can't reach each other anyway when it relays
+	if c.secrets["wireguard_key"] != "" {
+		return fmt.Errorf("The source requires a WireGuard tunnel (migration.wireguard), which can't be relayed by the client")
+	}
+
+	names := []string{"control", "fs"}
+	if c.live {
+		names = append(names, "criu")
+	}
+
+	var err error
+	c.pushSecrets = map[string]string{}
+	for _, name := range names {
+		c.pushSecrets[name], err = shared.RandomCryptoString()
+		if err != nil {
+			return err
+		}
+	}
+
+	c.allConnected = make(chan bool, 1)
+	c.cancel = make(chan bool)
+	c.running = make(chan bool)
+
+	return nil
+}
+
+// Metadata returns the secrets of the websockets of a sink in push mode.
+func (c *migrationSink) Metadata() interface{} {
+	secrets := shared.Jmap{}
+	for name, secret := range c.pushSecrets {
+		secrets[name] = secret
+	}
+
+	return secrets
+}
+
+// Cancel gives up on a sink in push mode the client didn't connect yet.
+func (c *migrationSink) Cancel(op *operation) error {
+	select {
+	case c.cancel <- true:
+		return nil
+	case <-c.running:
+		return fmt.Errorf("The source is already connected")
+	}
+}
+
+// Connect accepts the websockets of the source relayed by the client, in
+// push mode.
+func (c *migrationSink) Connect(op *operation, r *http.Request, w http.ResponseWriter) error {
+	secret := r.FormValue("secret")
+	if secret == "" {
+		return fmt.Errorf("missing secret")
+	}
+
+	var conn **websocket.Conn
+
+	switch secret {
+	case c.pushSecrets["control"]:
+		conn = &c.controlConn
+	case c.pushSecrets["fs"]:
+		conn = &c.fsConn
+		w = c.limiter.ResponseWriter(w)
+	case c.pushSecrets["criu"]:
+		conn = &c.criuConn
+		w = c.limiter.ResponseWriter(w)
+	default:
+		return os.ErrPermission
+	}
+
+	c.connectLock.Lock()
+	defer c.connectLock.Unlock()
+
+	// Each websocket gets relayed once
+	if *conn != nil {
+		return fmt.Errorf("The websocket is already connected")
+	}
+
+	ws, err := shared.WebsocketUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+
+	*conn = ws
+
+	if c.controlConn != nil && c.fsConn != nil && (!c.live || c.criuConn != nil) {
+		c.allConnected <- true
+	}
+
+	return nil
 }
 
 func (c *migrationSink) connectWithSecret(secret string) (*websocket.Conn, error) {
 	query := url.Values{"secret": []string{secret}}
 
+	// Only the control websocket goes outside of the tunnel
+	if c.wireguard != nil {
+		if secret != c.controlSecret {
+			return lxd.WebsocketDial(c.dialer, c.wireguard.url(secret))
+		}
+
+		query.Set("wireguard_key", c.wireguard.publicKey)
+		query.Set("wireguard_signature", c.wireguard.signature)
+	}
+
 	// The URL is a https URL to the operation, mangle to be a wss URL to the secret
 	url := c.url
 	if strings.HasPrefix(url, "https://") {
@@ -453,19 +736,50 @@ func (c *migrationSink) connectWithSecret(secret string) (*websocket.Conn, error
 
 func (c *migrationSink) do() error {
 	var err error
-	c.controlConn, err = c.connectWithSecret(c.controlSecret)
-	if err != nil {
-		return err
+	if c.secrets["wireguard_key"] != "" {
+		c.wireguard, err = migrationWireGuardSinkCreate(c.daemon, c.secrets, c.url)
+		if err != nil {
+			return err
+		}
+		defer c.wireguard.remove()
+	}
+
+	if c.push {
+		select {
+		case <-c.allConnected:
+		case <-c.cancel:
+			return fmt.Errorf("The migration was cancelled")
+		case <-time.After(migrationConnectTimeout):
+			return fmt.Errorf("The client didn't relay the migration within %s", migrationConnectTimeout)
+		}
+		close(c.running)
+	} else {
+		c.controlConn, err = c.connectWithSecret(c.controlSecret)
+		if err != nil {
+			return err
+		}
 	}
 	defer c.disconnect()
 
-	c.fsConn, err = c.connectWithSecret(c.fsSecret)
-	if err != nil {
-		c.sendControl(err)
-		return err
+	// The source has our key once it has the control websocket
+	if c.wireguard != nil {
+		err = c.wireguard.check()
+		if err != nil {
+			c.sendControl(err)
+			return err
+		}
 	}
 
-	if c.live {
+	// Relayed when in push mode
+	if c.fsConn == nil {
+		c.fsConn, err = c.connectWithSecret(c.fsSecret)
+		if err != nil {
+			c.sendControl(err)
+			return err
+		}
+	}
+
+	if c.live && c.criuConn == nil {
 		c.criuConn, err = c.connectWithSecret(c.criuSecret)
 		if err != nil {
 			c.sendControl(err)
@@ -489,9 +803,37 @@ func (c *migrationSink) do() error {
 		Criu: criuType,
 	}
 	// If the storage type the source has doesn't match what we have, then
-	// we have to use rsync.
+	// we have to use rsync, in delta mode if the source supports it.
 	if *header.Fs != *resp.Fs {
 		resp.Fs = MigrationFSType_RSYNC.Enum()
+		resp.Delta = proto.Bool(header.GetDelta())
+	}
+
+	/* When refreshing, we tell the source which snapshots we have so it
+	 * only sends the others, and use rsync to only get what changed in
+	 * the container.
+	 */
+	existing := []string{}
+	if c.refresh {
+		if !header.GetDelta() {
+			err := fmt.Errorf("The source is too old to refresh a copy")
+			c.sendControl(err)
+			return err
+		}
+
+		snaps, err := c.container.Snapshots()
+		if err != nil {
+			c.sendControl(err)
+			return err
+		}
+
+		for _, snap := range snaps {
+			existing = append(existing, shared.ExtractSnapshotName(snap.Name()))
+		}
+
+		resp.Fs = MigrationFSType_RSYNC.Enum()
+		resp.Snapshots = existing
+		resp.Delta = proto.Bool(true)
 	}
 
 	if err := c.send(&resp); err != nil {
@@ -526,7 +868,7 @@ func (c *migrationSink) do() error {
 				os.RemoveAll(imagesDir)
 			}()
 
-			if err := RsyncRecv(shared.AddSlash(imagesDir), c.criuConn); err != nil {
+			if err := RsyncRecv(shared.AddSlash(imagesDir), c.criuConn, false); err != nil {
 				restore <- err
 				os.RemoveAll(imagesDir)
 				c.sendControl(err)
@@ -549,8 +891,22 @@ func (c *migrationSink) do() error {
 			}
 		}
 
-		snapshots := []container{}
+		for _, idmap := range header.Idmap {
+			e := shared.IdmapEntry{
+				Isuid:    *idmap.Isuid,
+				Isgid:    *idmap.Isgid,
+				Nsid:     int(*idmap.Nsid),
+				Hostid:   int(*idmap.Hostid),
+				Maprange: int(*idmap.Maprange)}
+			srcIdmap.Idmap = shared.Extend(srcIdmap.Idmap, e)
+		}
+
+		snapshotArgs := []containerArgs{}
 		for _, snap := range header.Snapshots {
+			if shared.StringInSlice(snap, existing) {
+				continue
+			}
+
 			// TODO: we need to propagate snapshot configurations
 			// as well. Right now the container configuration is
 			// done through the initial migration post. Should we
@@ -566,44 +922,51 @@ func (c *migrationSink) do() error {
 				Devices:      c.container.LocalDevices(),
 				Name:         name,
 			}
+			snapshotArgs = append(snapshotArgs, args)
+		}
 
-			ct, err := containerCreateEmptySnapshot(c.container.Daemon(), args)
+		/* We're getting rsync in delta mode rather than what our
+		 * storage sends, the snapshots get made as their content is
+		 * received.
+		 */
+		if resp.GetDelta() {
+			err := rsyncMigrationFallbackSink(c.container, snapshotArgs, srcIdmap, c.fsConn)
 			if err != nil {
 				restore <- err
 				c.sendControl(err)
 				return
 			}
-			snapshots = append(snapshots, ct)
-		}
-
-		for _, idmap := range header.Idmap {
-			e := shared.IdmapEntry{
-				Isuid:    *idmap.Isuid,
-				Isgid:    *idmap.Isgid,
-				Nsid:     int(*idmap.Nsid),
-				Hostid:   int(*idmap.Hostid),
-				Maprange: int(*idmap.Maprange)}
-			srcIdmap.Idmap = shared.Extend(srcIdmap.Idmap, e)
-		}
-
-		if err := c.container.Storage().MigrationSink(c.container, snapshots, c.fsConn); err != nil {
-			restore <- err
-			c.sendControl(err)
-			return
-		}
+		} else {
+			snapshots := []container{}
+			for _, args := range snapshotArgs {
+				ct, err := containerCreateEmptySnapshot(c.container.Daemon(), args)
+				if err != nil {
+					restore <- err
+					c.sendControl(err)
+					return
+				}
+				snapshots = append(snapshots, ct)
+			}
 
-		if err := ShiftIfNecessary(c.container, srcIdmap); err != nil {
-			restore <- err
-			c.sendControl(err)
-			return
-		}
+			if err := c.container.Storage().MigrationSink(c.container, snapshots, c.fsConn); err != nil {
+				restore <- err
+				c.sendControl(err)
+				return
+			}
 
-		for _, snap := range snapshots {
-			if err := ShiftIfNecessary(snap, srcIdmap); err != nil {
+			if err := ShiftIfNecessary(c.container, srcIdmap); err != nil {
 				restore <- err
 				c.sendControl(err)
 				return
 			}
+
+			for _, snap := range snapshots {
+				if err := ShiftIfNecessary(snap, srcIdmap); err != nil {
+					restore <- err
+					c.sendControl(err)
+					return
+				}
+			}
 		}
 
 		if c.live {
@@ -144,6 +144,10 @@ type MigrationHeader struct {
 	Criu             *CRIUType        `protobuf:"varint,2,opt,name=criu,enum=main.CRIUType" json:"criu,omitempty"`
 	Idmap            []*IDMapType     `protobuf:"bytes,3,rep,name=idmap" json:"idmap,omitempty"`
 	Snapshots        []string         `protobuf:"bytes,4,rep,name=snapshots" json:"snapshots,omitempty"`
+	// rsync in delta mode: compressed, deleting what the source doesn't
+	// have and sending the snapshots first. Offered by the source and only
+	// used if the sink agrees to it in its reply.
+	Delta            *bool            `protobuf:"varint,5,opt,name=delta" json:"delta,omitempty"`
 	XXX_unrecognized []byte           `json:"-"`
 }
 
@@ -179,6 +183,13 @@ func (m *MigrationHeader) GetSnapshots() []string {
 	return nil
 }
 
+func (m *MigrationHeader) GetDelta() bool {
+	if m != nil && m.Delta != nil {
+		return *m.Delta
+	}
+	return false
+}
+
 type MigrationControl struct {
 	Success *bool `protobuf:"varint,1,req,name=success" json:"success,omitempty"`
 	// optional failure message if sending a failure
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// With migration.wireguard set, the sources of migrations create a WireGuard
+// interface of their own for each one, which the sink connects to. The keys
+// and the addresses are negotiated with the secrets of the operation and the
+// control websocket, while the filesystem and CRIU websockets only go through
+// the tunnel. Each end signs what it gives with the key of its server, the
+// other one only accepting it when it comes from a server it trusts, so that
+// the client passing it along can't put itself in the middle. The two
+// servers must then trust each other and the sink must reach the source over
+// UDP.
+
+// Prefix of the names of the interfaces
+const migrationWireGuardPrefix = "xwg"
+
+// Port the source serves the transfer websockets on, in the tunnel
+const migrationWireGuardPort = 8443
+
+// How long the sink waits for the tunnel to come up
+const migrationWireGuardTimeout = 10 * time.Second
+
+type migrationWireGuard struct {
+	iface string
+
+	// The /64 of the tunnel, the source being ::1 and the sink ::2
+	subnet *net.IPNet
+
+	publicKey string
+	port      int
+
+	// The fields of this end the other one needs, signed by the server
+	signature string
+
+	// Where the source is reached, on the sink
+	endpoint string
+
+	// Where the transfer websockets are served, on the source
+	listener net.Listener
+}
+
+// migrationWireGuardCheck tells whether this host can set up tunnels.
+func migrationWireGuardCheck() error {
+	_, err := exec.LookPath("wg")
+	if err != nil {
+		return fmt.Errorf("WireGuard tunnels need the wg tool")
+	}
+
+	return kernelModulesLoad([]string{"wireguard"})
+}
+
+// migrationWireGuardAddressValidate checks migration.wireguard_address, an
+// address or host name without port.
+func migrationWireGuardAddressValidate(value string) error {
+	if value == "" || net.ParseIP(value) != nil {
+		return nil
+	}
+
+	if strings.ContainsAny(value, ":/[] \t") || strings.HasPrefix(value, "-") {
+		return fmt.Errorf("Invalid migration.wireguard_address, must be an address or host name without port: %s", value)
+	}
+
+	return nil
+}
+
+// migrationWireGuardKeyValid checks a public key coming from the other end.
+func migrationWireGuardKeyValid(key string) error {
+	data, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(data) != 32 {
+		return fmt.Errorf("Invalid WireGuard key: %s", key)
+	}
+
+	return nil
+}
+
+// migrationWireGuardSign signs the fields an end of a tunnel gives to the
+// other one with the key of the server.
+func migrationWireGuardSign(certf string, keyf string, fields ...string) (string, error) {
+	cert, err := tls.LoadX509KeyPair(certf, keyf)
+	if err != nil {
+		return "", err
+	}
+
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return "", fmt.Errorf("The key of the server can't sign")
+	}
+
+	digest := sha256.Sum256([]byte(strings.Join(fields, "\n")))
+	signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(signature), nil
+}
+
+// migrationWireGuardVerify checks the fields given by the other end of a
+// tunnel were signed by one of the trusted certificates.
+func migrationWireGuardVerify(certs []x509.Certificate, signature string, fields ...string) error {
+	data, err := base64.StdEncoding.DecodeString(signature)
+	if err == nil && len(data) > 0 {
+		signed := []byte(strings.Join(fields, "\n"))
+		for _, cert := range certs {
+			algorithm := x509.SHA256WithRSA
+			if cert.PublicKeyAlgorithm == x509.ECDSA {
+				algorithm = x509.ECDSAWithSHA256
+			}
+
+			if cert.CheckSignature(algorithm, signed, data) == nil {
+				return nil
+			}
+		}
+	}
+
+	return fmt.Errorf("The WireGuard key isn't signed by a trusted server")
+}
+
+// migrationWireGuardAddress returns the address of the source or of the sink
+// in the subnet of a tunnel.
+func migrationWireGuardAddress(subnet *net.IPNet, source bool) net.IP {
+	ip := make(net.IP, net.IPv6len)
+	copy(ip, subnet.IP)
+	ip[15] = 2
+	if source {
+		ip[15] = 1
+	}
+
+	return ip
+}
+
+// migrationWireGuardSubnet returns a random unique local /64.
+func migrationWireGuardSubnet() (*net.IPNet, error) {
+	ip := make(net.IP, net.IPv6len)
+	ip[0] = 0xfd
+	_, err := rand.Read(ip[1:8])
+	if err != nil {
+		return nil, err
+	}
+
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(64, 128)}, nil
+}
+
+func migrationWireGuardRun(stdin string, command string, args ...string) (string, error) {
+	cmd := exec.Command(command, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return "", fmt.Errorf("Failed to run %s %s: %s", command, strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// migrationWireGuardCreate sets up an interface with a new key pair and its
+// address in the subnet, leaving its peer to be added.
+func migrationWireGuardCreate(d *Daemon, subnet *net.IPNet, source bool) (*migrationWireGuard, error) {
+	randBytes := make([]byte, 4)
+	_, err := rand.Read(randBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &migrationWireGuard{iface: migrationWireGuardPrefix + hex.EncodeToString(randBytes), subnet: subnet}
+
+	privateKey, err := migrationWireGuardRun("", "wg", "genkey")
+	if err != nil {
+		return nil, err
+	}
+
+	w.publicKey, err = migrationWireGuardRun(privateKey, "wg", "pubkey")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = migrationWireGuardRun("", "ip", "link", "add", w.iface, "type", "wireguard")
+	if err != nil {
+		return nil, err
+	}
+
+	address := fmt.Sprintf("%s/64", migrationWireGuardAddress(subnet, source))
+	for _, args := range [][]string{
+		{"wg", "set", w.iface, "private-key", "/dev/stdin"},
+		{"ip", "-6", "addr", "add", address, "dev", w.iface, "nodad"},
+		{"ip", "link", "set", "dev", w.iface, "up"},
+	} {
+		_, err = migrationWireGuardRun(privateKey, args[0], args[1:]...)
+		if err != nil {
+			w.remove()
+			return nil, err
+		}
+	}
+
+	port, err := migrationWireGuardRun("", "wg", "show", w.iface, "listen-port")
+	if err == nil {
+		w.port, err = strconv.Atoi(port)
+	}
+
+	if err != nil {
+		w.remove()
+		return nil, err
+	}
+
+	// The sink has no port of interest to the source
+	fields := []string{w.publicKey, w.subnet.String()}
+	if source {
+		fields = []string{w.publicKey, port, w.subnet.String()}
+	}
+
+	w.signature, err = migrationWireGuardSign(d.certf, d.keyf, fields...)
+	if err != nil {
+		w.remove()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// peer lets the other end of the tunnel in, the sink also giving the
+// endpoint of the source and keeping the tunnel alive through NAT.
+func (w *migrationWireGuard) peer(publicKey string, endpoint string) error {
+	err := migrationWireGuardKeyValid(publicKey)
+	if err != nil {
+		return err
+	}
+
+	w.endpoint = endpoint
+
+	args := []string{"set", w.iface, "peer", publicKey}
+	if endpoint == "" {
+		args = append(args, "allowed-ips", fmt.Sprintf("%s/128", migrationWireGuardAddress(w.subnet, false)))
+	} else {
+		args = append(args,
+			"allowed-ips", fmt.Sprintf("%s/128", migrationWireGuardAddress(w.subnet, true)),
+			"endpoint", endpoint,
+			"persistent-keepalive", "25")
+	}
+
+	_, err = migrationWireGuardRun("", "wg", args...)
+	return err
+}
+
+// serve serves the transfer websockets on the tunnel address of the source.
+func (w *migrationWireGuard) serve(handler http.HandlerFunc) error {
+	listener, err := net.Listen("tcp", net.JoinHostPort(migrationWireGuardAddress(w.subnet, true).String(), fmt.Sprintf("%d", migrationWireGuardPort)))
+	if err != nil {
+		return err
+	}
+
+	w.listener = listener
+	go http.Serve(listener, handler)
+
+	return nil
+}
+
+func (w *migrationWireGuard) host() string {
+	return net.JoinHostPort(migrationWireGuardAddress(w.subnet, true).String(), fmt.Sprintf("%d", migrationWireGuardPort))
+}
+
+// url returns the URL of a transfer websocket of the source, in the tunnel.
+func (w *migrationWireGuard) url(secret string) string {
+	query := url.Values{"secret": []string{secret}}
+
+	return fmt.Sprintf("ws://%s/websocket?%s", w.host(), query.Encode())
+}
+
+// check makes sure the source answers through the tunnel, from the sink,
+// rather than having the transfer hang when the servers can't reach each
+// other.
+func (w *migrationWireGuard) check() error {
+	conn, err := net.DialTimeout("tcp", w.host(), migrationWireGuardTimeout)
+	if err != nil {
+		return fmt.Errorf("The WireGuard tunnel to %s doesn't work, the sink must reach the source over UDP: %s", w.endpoint, err)
+	}
+
+	return conn.Close()
+}
+
+func (w *migrationWireGuard) remove() {
+	if w.listener != nil {
+		w.listener.Close()
+	}
+
+	deviceRemoveInterface(w.iface)
+}
+
+// secrets returns what the sink needs to connect, sent along with the
+// secrets of the websockets.
+func (w *migrationWireGuard) secrets(address string) map[string]string {
+	secrets := map[string]string{
+		"wireguard_key":       w.publicKey,
+		"wireguard_port":      fmt.Sprintf("%d", w.port),
+		"wireguard_subnet":    w.subnet.String(),
+		"wireguard_signature": w.signature,
+	}
+
+	if address != "" {
+		secrets["wireguard_address"] = address
+	}
+
+	return secrets
+}
+
+// migrationWireGuardSinkCreate sets up the sink end of the tunnel of a
+// source, reached at the host of its URL unless it gave another address.
+func migrationWireGuardSinkCreate(d *Daemon, secrets map[string]string, sourceUrl string) (*migrationWireGuard, error) {
+	_, subnet, err := net.ParseCIDR(secrets["wireguard_subnet"])
+	if err != nil {
+		return nil, fmt.Errorf("Invalid WireGuard subnet: %s", secrets["wireguard_subnet"])
+	}
+
+	err = migrationWireGuardVerify(d.clientCerts, secrets["wireguard_signature"], secrets["wireguard_key"], secrets["wireguard_port"], secrets["wireguard_subnet"])
+	if err != nil {
+		return nil, fmt.Errorf("The source requires a WireGuard tunnel: %s", err)
+	}
+
+	host := secrets["wireguard_address"]
+	if host == "" {
+		u, err := url.Parse(sourceUrl)
+		if err != nil {
+			return nil, err
+		}
+
+		host = u.Host
+		h, _, err := net.SplitHostPort(u.Host)
+		if err == nil {
+			host = h
+		}
+	}
+
+	err = migrationWireGuardCheck()
+	if err != nil {
+		return nil, fmt.Errorf("The source requires a WireGuard tunnel: %s", err)
+	}
+
+	w, err := migrationWireGuardCreate(d, subnet, false)
+	if err != nil {
+		return nil, err
+	}
+
+	err = w.peer(secrets["wireguard_key"], net.JoinHostPort(host, secrets["wireguard_port"]))
+	if err != nil {
+		w.remove()
+		return nil, err
+	}
+
+	return w, nil
+}
+
+// migrationWireGuardCleanup removes the interfaces of the migrations a
+// previous run of the daemon didn't finish.
+func migrationWireGuardCleanup() {
+	entries, err := ioutil.ReadDir("/sys/class/net")
+	if err != nil {
+		return
+	}
+
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), migrationWireGuardPrefix) {
+			shared.Debugf("Removing the leftover WireGuard interface %s", entry.Name())
+			deviceRemoveInterface(entry.Name())
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestMigrationWireGuardAddress(t *testing.T) {
+	_, subnet, err := net.ParseCIDR("fd12:3456:789a:bcde::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source := migrationWireGuardAddress(subnet, true).String()
+	if source != "fd12:3456:789a:bcde::1" {
+		t.Errorf("Wrong source address: %s", source)
+	}
+
+	sink := migrationWireGuardAddress(subnet, false).String()
+	if sink != "fd12:3456:789a:bcde::2" {
+		t.Errorf("Wrong sink address: %s", sink)
+	}
+
+	// The subnet itself is left alone
+	if subnet.IP.String() != "fd12:3456:789a:bcde::" {
+		t.Errorf("The subnet changed: %s", subnet)
+	}
+}
+
+func TestMigrationWireGuardSubnet(t *testing.T) {
+	first, err := migrationWireGuardSubnet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	second, err := migrationWireGuardSubnet()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ones, bits := first.Mask.Size()
+	if first.IP[0] != 0xfd || ones != 64 || bits != 128 {
+		t.Errorf("Not a unique local /64: %s", first)
+	}
+
+	if first.String() == second.String() {
+		t.Errorf("Got the same subnet twice: %s", first)
+	}
+}
+
+func TestMigrationWireGuardAddressValidate(t *testing.T) {
+	for _, value := range []string{"", "203.0.113.10", "fd00::1", "lxd1.example"} {
+		err := migrationWireGuardAddressValidate(value)
+		if err != nil {
+			t.Errorf("Refused %q: %s", value, err)
+		}
+	}
+
+	for _, value := range []string{"203.0.113.10:51820", "[fd00::1]", "lxd1.example:51820", "-lxd1", "a b"} {
+		if migrationWireGuardAddressValidate(value) == nil {
+			t.Errorf("Accepted %q", value)
+		}
+	}
+}
+
+func TestMigrationWireGuardKeyValid(t *testing.T) {
+	if migrationWireGuardKeyValid("xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=") != nil {
+		t.Errorf("Refused a valid key")
+	}
+
+	for _, key := range []string{"", "-h", "c2hvcnQ=", "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg"} {
+		if migrationWireGuardKeyValid(key) == nil {
+			t.Errorf("Accepted the key %q", key)
+		}
+	}
+}
+
+func TestMigrationWireGuardVerify(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lxd_wireguard_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certf := filepath.Join(dir, "server.crt")
+	keyf := filepath.Join(dir, "server.key")
+	err = shared.GenCert(certf, keyf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := shared.ReadCert(certf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := "xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg="
+	signature, err := migrationWireGuardSign(certf, keyf, key, "fd12:3456:789a:bcde::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = migrationWireGuardVerify([]x509.Certificate{*cert}, signature, key, "fd12:3456:789a:bcde::/64")
+	if err != nil {
+		t.Errorf("Refused a valid signature: %s", err)
+	}
+
+	// Another key, an untrusted server or no signature at all
+	if migrationWireGuardVerify([]x509.Certificate{*cert}, signature, key, "fd00::/64") == nil {
+		t.Errorf("Accepted the signature of other fields")
+	}
+
+	if migrationWireGuardVerify([]x509.Certificate{}, signature, key, "fd12:3456:789a:bcde::/64") == nil {
+		t.Errorf("Accepted a signature without trusted certificate")
+	}
+
+	if migrationWireGuardVerify([]x509.Certificate{*cert}, "", key, "fd12:3456:789a:bcde::/64") == nil {
+		t.Errorf("Accepted a missing signature")
+	}
+}
@@ -36,16 +36,18 @@ package main
 #include <errno.h>
 #include <alloca.h>
 #include <libgen.h>
+#include <sys/sysmacros.h>
+#include <sys/xattr.h>
 
 // This expects:
-//  ./lxd forkputfile /source/path <pid> /target/path
+//  ./lxd forkputfile /source/path <pid> /target/path <uid> <gid> <mode> <type>
 // or
-//  ./lxd forkgetfile /target/path <pid> /soruce/path <uid> <gid> <mode>
-// i.e. 8 arguments, each which have a max length of PATH_MAX.
+//  ./lxd forkgetfile /target/path <pid> /soruce/path
+// i.e. 9 arguments, each which have a max length of PATH_MAX.
 // Unfortunately, lseek() and fstat() both fail (EINVAL and 0 size) for
 // procfs. Also, we can't mmap, because procfs doesn't support that, either.
 //
-#define CMDLINE_SIZE (8 * PATH_MAX)
+#define CMDLINE_SIZE (9 * PATH_MAX)
 
 int mkdir_p(const char *dir, mode_t mode)
 {
@@ -70,12 +72,29 @@ int mkdir_p(const char *dir, mode_t mode)
 	return 0;
 }
 
+// Copy source into target, seeking over blocks of zeroes rather than writing
+// them so that sparse files stay sparse. The buffer matches the usual
+// filesystem block size so that the holes we leave line up with real blocks.
 int copy(int target, int source)
 {
 	ssize_t n;
-	char buf[1024];
+	char buf[4096];
+	char zero[4096];
+	off_t total = 0;
+
+	memset(zero, 0, sizeof(zero));
+
+	while ((n = read(source, buf, sizeof(buf))) > 0) {
+		total += n;
+
+		if (memcmp(buf, zero, n) == 0) {
+			if (lseek(target, n, SEEK_CUR) < 0) {
+				perror("lseek");
+				return -1;
+			}
+			continue;
+		}
 
-	while ((n = read(source, buf, 1024)) > 0) {
 		if (write(target, buf, n) != n) {
 			perror("write");
 			return -1;
@@ -87,6 +106,12 @@ int copy(int target, int source)
 		return -1;
 	}
 
+	// Account for any trailing hole and drop leftovers from a longer file
+	if (ftruncate(target, total) < 0) {
+		perror("ftruncate");
+		return -1;
+	}
+
 	return 0;
 }
 
@@ -111,10 +136,99 @@ int dosetns(int pid, char *nstype) {
 	return 0;
 }
 
-int manip_file_in_ns(char *host, int pid, char *container, bool is_put, uid_t uid, gid_t gid, mode_t mode) {
+int get_symlink(int host_fd, char *container)
+{
+	char target[PATH_MAX];
+	ssize_t len;
+
+	len = readlink(container, target, sizeof(target));
+	if (len < 0) {
+		fprintf(stderr, "%s\n", strerror(errno));
+		return -1;
+	}
+
+	if (write(host_fd, target, len) != len) {
+		perror("write");
+		return -1;
+	}
+
+	return 0;
+}
+
+int get_device(int host_fd, struct stat *sb)
+{
+	if (dprintf(host_fd, "%u:%u", major(sb->st_rdev), minor(sb->st_rdev)) < 0) {
+		perror("dprintf");
+		return -1;
+	}
+
+	return 0;
+}
+
+int put_symlink(int host_fd, char *container, uid_t uid, gid_t gid)
+{
+	char target[PATH_MAX];
+	ssize_t len;
+
+	memset(target, 0, sizeof(target));
+	len = read(host_fd, target, sizeof(target)-1);
+	if (len <= 0) {
+		fprintf(stderr, "invalid symlink target\n");
+		return -1;
+	}
+
+	if (unlink(container) < 0 && errno != ENOENT) {
+		fprintf(stderr, "%s\n", strerror(errno));
+		return -1;
+	}
+
+	if (symlink(target, container) < 0) {
+		fprintf(stderr, "%s\n", strerror(errno));
+		return -1;
+	}
+
+	if (lchown(container, uid, gid) < 0) {
+		perror("lchown");
+		return -1;
+	}
+
+	return 0;
+}
+
+int put_device(int host_fd, char *container, mode_t type, uid_t uid, gid_t gid, mode_t mode)
+{
+	char buf[64];
+	unsigned int dev_major, dev_minor;
+
+	memset(buf, 0, sizeof(buf));
+	if (read(host_fd, buf, sizeof(buf)-1) <= 0 || sscanf(buf, "%u:%u", &dev_major, &dev_minor) != 2) {
+		fprintf(stderr, "invalid device number\n");
+		return -1;
+	}
+
+	if (unlink(container) < 0 && errno != ENOENT) {
+		fprintf(stderr, "%s\n", strerror(errno));
+		return -1;
+	}
+
+	if (mknod(container, type | mode, makedev(dev_major, dev_minor)) < 0) {
+		fprintf(stderr, "%s\n", strerror(errno));
+		return -1;
+	}
+
+	if (chown(container, uid, gid) < 0) {
+		perror("chown");
+		return -1;
+	}
+
+	return 0;
+}
+
+int manip_file_in_ns(char *host, int pid, char *container, bool is_put, char *type, uid_t uid, gid_t gid, mode_t mode) {
 	int host_fd, container_fd;
 	int ret = -1;
 	int container_open_flags;
+	struct stat sb;
 
 	host_fd = open(host, O_RDWR);
 	if (host_fd < 0) {
@@ -129,6 +243,55 @@ int manip_file_in_ns(char *host, int pid, char *container, bool is_put, uid_t ui
 	if (dosetns(pid, "mnt") < 0)
 		goto close_host;
 
+	if (is_put) {
+		if (strcmp(type, "symlink") == 0) {
+			ret = put_symlink(host_fd, container, uid, gid);
+			goto close_host;
+		} else if (strcmp(type, "char") == 0) {
+			ret = put_device(host_fd, container, S_IFCHR, uid, gid, mode);
+			goto close_host;
+		} else if (strcmp(type, "block") == 0) {
+			ret = put_device(host_fd, container, S_IFBLK, uid, gid, mode);
+			goto close_host;
+		} else if (strcmp(type, "file") != 0) {
+			fprintf(stderr, "unknown file type: %s\n", type);
+			goto close_host;
+		}
+	} else {
+		// Don't follow symlinks or open device nodes, describe them instead
+		if (lstat(container, &sb) < 0) {
+			fprintf(stderr, "%s\n", strerror(errno));
+			goto close_host;
+		}
+
+		// The permissions of the file itself, not of the copy
+		printf("mode: %d\n", sb.st_mode & 0777);
+
+		switch (sb.st_mode & S_IFMT) {
+		case S_IFREG:
+			printf("type: file\n");
+			break;
+		case S_IFLNK:
+			printf("type: symlink\n");
+			ret = get_symlink(host_fd, container);
+			goto close_host;
+		case S_IFCHR:
+			printf("type: char\n");
+			ret = get_device(host_fd, &sb);
+			goto close_host;
+		case S_IFBLK:
+			printf("type: block\n");
+			ret = get_device(host_fd, &sb);
+			goto close_host;
+		case S_IFDIR:
+			fprintf(stderr, "%s\n", strerror(EISDIR));
+			goto close_host;
+		default:
+			fprintf(stderr, "unsupported file type\n");
+			goto close_host;
+		}
+	}
+
 	container_fd = open(container, container_open_flags, mode);
 	if (container_fd < 0) {
 		fprintf(stderr, "%s\n", strerror(errno));
@@ -295,8 +458,9 @@ void forkdofile(char *buf, char *cur, bool is_put, ssize_t size) {
 	uid_t uid = 0;
 	gid_t gid = 0;
 	mode_t mode = 0;
-	char *command = cur, *source = NULL, *target = NULL;
+	char *command = cur, *source = NULL, *target = NULL, *type = "file";
 	pid_t pid;
+	int ret;
 
 	ADVANCE_ARG_REQUIRED();
 	source = cur;
@@ -316,17 +480,223 @@ void forkdofile(char *buf, char *cur, bool is_put, ssize_t size) {
 
 		ADVANCE_ARG_REQUIRED();
 		mode = atoi(cur);
+
+		ADVANCE_ARG_REQUIRED();
+		type = cur;
 	}
 
 	printf("command: %s\n", command);
 	printf("source: %s\n", source);
 	printf("pid: %d\n", pid);
 	printf("target: %s\n", target);
-	printf("uid: %d\n", uid);
-	printf("gid: %d\n", gid);
-	printf("mode: %d\n", mode);
+	if (is_put) {
+		printf("uid: %d\n", uid);
+		printf("gid: %d\n", gid);
+		printf("mode: %d\n", mode);
+	}
+
+	ret = manip_file_in_ns(source, pid, target, is_put, type, uid, gid, mode);
+
+	// _exit() doesn't flush stdio and the daemon parses our output
+	fflush(stdout);
+	_exit(ret);
+}
+
+// Enter the mount namespace of pid chrooted into its root, with the working
+// directory dir, which is opened beforehand so that it can be a path under
+// /proc/<pid>/. The paths, symlinks included, then can't resolve to anything
+// outside of what the process sees.
+int enter_cwd(int pid, char *dir) {
+	char path[PATH_MAX];
+	int root, cwd, saved;
+
+	snprintf(path, sizeof(path), "/proc/%d/root", pid);
+	root = open(path, O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+	if (root < 0)
+		return -1;
+
+	cwd = open(dir, O_RDONLY | O_DIRECTORY | O_CLOEXEC);
+	if (cwd < 0) {
+		saved = errno;
+		close(root);
+		errno = saved;
+		return -1;
+	}
+
+	if (dosetns(pid, "mnt") < 0 || fchdir(root) < 0 || chroot(".") < 0 || fchdir(cwd) < 0) {
+		saved = errno;
+		close(root);
+		close(cwd);
+		errno = saved;
+		return -1;
+	}
+
+	close(root);
+	close(cwd);
+	return 0;
+}
+
+// Whether id is in one of the host ranges of map, "<start>:<range>" separated
+// by commas, "-" standing for any id.
+bool id_mapped(const char *map, unsigned long id) {
+	unsigned long start, range;
+	const char *cur = map;
+
+	if (strcmp(map, "-") == 0)
+		return true;
+
+	while (sscanf(cur, "%lu:%lu", &start, &range) == 2) {
+		if (id >= start && id < start + range)
+			return true;
+
+		cur = strchr(cur, ',');
+		if (!cur)
+			break;
+		cur++;
+	}
+
+	return false;
+}
+
+// This expects:
+//  ./lxd forkmknod <pid> <dir> <path> <mode> <major> <minor> <uid> <gid> <uidmap>
+// and exits with the errno of what failed. The parent directory must belong
+// to a uid of the container, as given by uidmap.
+void forkmknod(char *buf, char *cur, ssize_t size) {
+	char *dir, *target, *uidmap;
+	char parent[PATH_MAX];
+	struct stat st;
+	int pid;
+	mode_t mode;
+	unsigned int dev_major, dev_minor;
+	uid_t uid;
+	gid_t gid;
+
+	ADVANCE_ARG_REQUIRED();
+	pid = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	dir = cur;
+
+	ADVANCE_ARG_REQUIRED();
+	target = cur;
+
+	ADVANCE_ARG_REQUIRED();
+	mode = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	dev_major = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	dev_minor = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	uid = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	gid = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	uidmap = cur;
+
+	if (enter_cwd(pid, dir) < 0)
+		_exit(errno);
+
+	snprintf(parent, sizeof(parent), "%s", target);
+	if (stat(dirname(parent), &st) < 0)
+		_exit(errno);
 
-	_exit(manip_file_in_ns(source, pid, target, is_put, uid, gid, mode));
+	if (!id_mapped(uidmap, st.st_uid))
+		_exit(EPERM);
+
+	if (mknod(target, mode, makedev(dev_major, dev_minor)) < 0)
+		_exit(errno);
+
+	if (lchown(target, uid, gid) < 0)
+		_exit(errno);
+
+	_exit(0);
+}
+
+// This expects:
+//  ./lxd forksetxattr <pid> <dir> <path> <name> <hex value> <flags> <follow> <uidmap>
+// with dir set to "-" when path can be used from the host, and exits with
+// the errno of what failed. The file must belong to a uid of the container,
+// as given by uidmap.
+void forksetxattr(char *buf, char *cur, ssize_t size) {
+	char *dir, *target, *name, *hexval, *uidmap;
+	char value[4096];
+	struct stat st;
+	size_t len, i;
+	int pid, flags, follow, ret;
+
+	ADVANCE_ARG_REQUIRED();
+	pid = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	dir = cur;
+
+	ADVANCE_ARG_REQUIRED();
+	target = cur;
+
+	ADVANCE_ARG_REQUIRED();
+	name = cur;
+
+	ADVANCE_ARG_REQUIRED();
+	hexval = cur;
+
+	ADVANCE_ARG_REQUIRED();
+	flags = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	follow = atoi(cur);
+
+	ADVANCE_ARG_REQUIRED();
+	uidmap = cur;
+
+	len = strlen(hexval) / 2;
+	if (len > sizeof(value))
+		_exit(E2BIG);
+
+	for (i = 0; i < len; i++) {
+		if (sscanf(hexval + 2 * i, "%2hhx", (unsigned char *)&value[i]) != 1)
+			_exit(EINVAL);
+	}
+
+	if (strcmp(dir, "-") != 0 && enter_cwd(pid, dir) < 0)
+		_exit(errno);
+
+	if (follow)
+		ret = stat(target, &st);
+	else
+		ret = lstat(target, &st);
+
+	if (ret < 0)
+		_exit(errno);
+
+	if (!id_mapped(uidmap, st.st_uid))
+		_exit(EPERM);
+
+	if (follow)
+		ret = setxattr(target, name, value, len, flags);
+	else
+		ret = lsetxattr(target, name, value, len, flags);
+
+	if (ret < 0)
+		_exit(errno);
+
+	_exit(0);
+}
+
+// Stays in its user namespace until stdin gets closed, for the daemon to
+// get a reference to the namespace.
+void forkuserns(void) {
+	char c;
+
+	while (read(0, &c, 1) > 0)
+		;
+
+	_exit(0);
 }
 
 __attribute__((constructor)) void init(void) {
@@ -365,6 +735,12 @@ __attribute__((constructor)) void init(void) {
 		forkmount(buf, cur, size);
 	} else if (strcmp(cur, "forkumount") == 0) {
 		forkumount(buf, cur, size);
+	} else if (strcmp(cur, "forkmknod") == 0) {
+		forkmknod(buf, cur, size);
+	} else if (strcmp(cur, "forksetxattr") == 0) {
+		forksetxattr(buf, cur, size);
+	} else if (strcmp(cur, "forkuserns") == 0) {
+		forkuserns();
 	}
 }
 */
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// networkACLValidate checks the fields of an ACL which can be set.
+func networkACLValidate(acl *shared.NetworkACL) error {
+	err := shared.NetworkACLNameValid(acl.Name)
+	if err != nil {
+		return err
+	}
+
+	for i, rule := range acl.Rules {
+		err := rule.Validate()
+		if err != nil {
+			return fmt.Errorf("Rule %d: %s", i, err)
+		}
+	}
+
+	for _, network := range acl.Networks {
+		if network == "" {
+			return fmt.Errorf("Empty network name")
+		}
+	}
+
+	if acl.Rules == nil {
+		acl.Rules = []shared.NetworkACLRule{}
+	}
+
+	if acl.Networks == nil {
+		acl.Networks = []string{}
+	}
+
+	return nil
+}
+
+// networkACLUsedBy returns the URLs of the containers and profiles listing
+// the ACL in security.acls.
+func networkACLUsedBy(d *Daemon, name string) ([]string, error) {
+	usedBy := []string{}
+
+	containers, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ct := range containers {
+		id, err := dbContainerId(d.db, ct)
+		if err != nil {
+			return nil, err
+		}
+
+		config, err := dbContainerConfig(d.db, id)
+		if err != nil {
+			return nil, err
+		}
+
+		if shared.StringInSlice(name, networkACLNames(config["security.acls"])) {
+			usedBy = append(usedBy, fmt.Sprintf("/%s/containers/%s", shared.APIVersion, ct))
+		}
+	}
+
+	profiles, err := dbProfiles(d.db)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, profile := range profiles {
+		config, err := dbProfileConfig(d.db, profile)
+		if err != nil {
+			return nil, err
+		}
+
+		if shared.StringInSlice(name, networkACLNames(config["security.acls"])) {
+			usedBy = append(usedBy, fmt.Sprintf("/%s/profiles/%s", shared.APIVersion, profile))
+		}
+	}
+
+	return usedBy, nil
+}
+
+func doNetworkACLGet(d *Daemon, name string) (*shared.NetworkACL, error) {
+	acl, err := dbNetworkACLGet(d.db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	acl.UsedBy, err = networkACLUsedBy(d, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return acl, nil
+}
+
+// networkACLsChanged recompiles the firewall after a change of the ACLs.
+func networkACLsChanged(d *Daemon) Response {
+	err := networkACLApply(d, nil, "")
+	if err != nil {
+		shared.Log.Error("Failed to apply the network ACLs", log.Ctx{"err": err})
+		return InternalError(err)
+	}
+
+	return EmptySyncResponse
+}
+
+func networkACLsGet(d *Daemon, r *http.Request) Response {
+	acls, err := dbNetworkACLs(d.db)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if !d.isRecursionRequest(r) {
+		urls := []string{}
+		for _, acl := range acls {
+			urls = append(urls, fmt.Sprintf("/%s/network-acls/%s", shared.APIVersion, acl.Name))
+		}
+
+		return SyncResponse(true, urls)
+	}
+
+	for _, acl := range acls {
+		acl.UsedBy, err = networkACLUsedBy(d, acl.Name)
+		if err != nil {
+			return InternalError(err)
+		}
+	}
+
+	result, err := fieldsFilter(r, acls, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, result)
+}
+
+func networkACLsPost(d *Daemon, r *http.Request) Response {
+	req := shared.NetworkACL{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	err := networkACLValidate(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	_, err = dbNetworkACLGet(d.db, req.Name)
+	if err == nil {
+		return Conflict
+	}
+
+	err = dbNetworkACLCreate(d.db, &req)
+	if err != nil {
+		return InternalError(fmt.Errorf("Error inserting %s into database: %s", req.Name, err))
+	}
+
+	return networkACLsChanged(d)
+}
+
+var networkACLsCmd = Command{name: "network-acls", get: networkACLsGet, post: networkACLsPost}
+
+func networkACLGet(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	acl, err := doNetworkACLGet(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	etag := []interface{}{acl.Description, acl.Rules, acl.Networks}
+	return SyncResponseETag(true, acl, etag)
+}
+
+func networkACLPut(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	acl, err := dbNetworkACLGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = etagCheck(r, []interface{}{acl.Description, acl.Rules, acl.Networks})
+	if err != nil {
+		return PreconditionFailed(err)
+	}
+
+	req := shared.NetworkACL{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	req.Name = name
+	err = networkACLValidate(&req)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	err = dbNetworkACLUpdate(d.db, &req)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return networkACLsChanged(d)
+}
+
+func networkACLDelete(d *Daemon, r *http.Request) Response {
+	name := mux.Vars(r)["name"]
+
+	_, err := dbNetworkACLGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	// The containers listing it would silently lose its rules
+	usedBy, err := networkACLUsedBy(d, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	if len(usedBy) > 0 {
+		return BadRequest(fmt.Errorf("The ACL is in use by: %s", strings.Join(usedBy, ", ")))
+	}
+
+	err = dbNetworkACLDelete(d.db, name)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return networkACLsChanged(d)
+}
+
+var networkACLCmd = Command{name: "network-acls/{name}", get: networkACLGet, put: networkACLPut, delete: networkACLDelete}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"strings"
+	"sync"
+
+	"gopkg.in/lxc/go-lxc.v2"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The ACLs get compiled into a table of the bridge family with nftables,
+// which sees the traffic between the containers as well as the one going
+// to the host, or into a chain of iptables matching the bridge ports with
+// br_netfilter when nftables isn't available. Either way, the whole set of
+// rules is replaced each time something changes.
+
+const networkACLTable = "xlxd"
+const networkACLChain = "xlxd-acl"
+
+var networkACLLock sync.Mutex
+
+// Whether the firewall holds rules of the daemon
+var networkACLActive = false
+
+// networkACLPort is the host side of a nic of a running container, with the
+// rules it gets, those of the ACLs of its network first.
+type networkACLPort struct {
+	container string
+	iface     string
+	rules     []shared.NetworkACLRule
+}
+
+// networkACLNames returns the ACLs listed in security.acls.
+func networkACLNames(value string) []string {
+	names := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// networkACLPorts returns the ports of the running containers which get
+// rules, including the one being started, whose nics are known from its
+// config, and leaving out the one being stopped.
+func networkACLPorts(d *Daemon, list []*shared.NetworkACL, starting container, stopping string) ([]networkACLPort, error) {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return nil, err
+	}
+
+	acls := map[string]*shared.NetworkACL{}
+	for _, acl := range list {
+		acls[acl.Name] = acl
+	}
+
+	ports := []networkACLPort{}
+	for _, name := range names {
+		if name == stopping {
+			continue
+		}
+
+		var c container
+		item := func(cc *lxc.Container, key string) []string { return cc.RunningConfigItem(key) }
+		if starting != nil && starting.Name() == name {
+			c = starting
+			item = func(cc *lxc.Container, key string) []string { return cc.ConfigItem(key) }
+		} else {
+			c, err = containerLoadByName(d, name)
+			if err != nil || !c.IsRunning() || c.IsVM() {
+				continue
+			}
+		}
+
+		own := []shared.NetworkACLRule{}
+		for _, aclName := range networkACLNames(c.ExpandedConfig()["security.acls"]) {
+			acl, ok := acls[aclName]
+			if !ok {
+				shared.Log.Warn("Unknown network ACL", log.Ctx{"container": name, "acl": aclName})
+				continue
+			}
+
+			own = append(own, acl.Rules...)
+		}
+
+		cc := c.LXContainerGet()
+		for i := 0; i < len(cc.ConfigItem("lxc.network")); i++ {
+			if item(cc, fmt.Sprintf("lxc.network.%d.type", i))[0] != "veth" {
+				continue
+			}
+
+			bridge := item(cc, fmt.Sprintf("lxc.network.%d.link", i))[0]
+			rules := []shared.NetworkACLRule{}
+			for _, acl := range list {
+				if shared.StringInSlice(bridge, acl.Networks) {
+					rules = append(rules, acl.Rules...)
+				}
+			}
+			rules = append(rules, own...)
+
+			if len(rules) == 0 {
+				continue
+			}
+
+			ports = append(ports, networkACLPort{
+				container: name,
+				iface:     item(cc, fmt.Sprintf("lxc.network.%d.veth.pair", i))[0],
+				rules:     rules,
+			})
+		}
+	}
+
+	return ports, nil
+}
+
+// networkACLNftMatches returns the nft matches of a rule on a port, one per
+// address family it needs.
+func networkACLNftMatches(port networkACLPort, rule shared.NetworkACLRule) []string {
+	iface := fmt.Sprintf("iifname \"%s\"", port.iface)
+	addr := "daddr"
+	if rule.Direction == "ingress" {
+		iface = fmt.Sprintf("oifname \"%s\"", port.iface)
+		addr = "saddr"
+	}
+
+	proto := ""
+	switch rule.Protocol {
+	case "tcp", "udp":
+		proto = fmt.Sprintf(" meta l4proto %s", rule.Protocol)
+		if rule.Port != "" {
+			ports, _ := shared.NetworkACLPorts(rule.Port)
+			proto = fmt.Sprintf("%s %s dport { %s }", proto, rule.Protocol, strings.Join(ports, ", "))
+		}
+	case "icmp":
+		proto = " meta l4proto icmp"
+	case "icmpv6":
+		proto = " meta l4proto ipv6-icmp"
+	}
+
+	v4, v6, _ := shared.NetworkACLSubjects(rule.Subject)
+	if len(v4) == 0 && len(v6) == 0 {
+		return []string{iface + proto}
+	}
+
+	matches := []string{}
+	if len(v4) > 0 && rule.Protocol != "icmpv6" {
+		matches = append(matches, fmt.Sprintf("%s ip %s { %s }%s", iface, addr, strings.Join(v4, ", "), proto))
+	}
+
+	if len(v6) > 0 && rule.Protocol != "icmp" {
+		matches = append(matches, fmt.Sprintf("%s ip6 %s { %s }%s", iface, addr, strings.Join(v6, ", "), proto))
+	}
+
+	return matches
+}
+
+// networkACLNftRuleset returns the nft script replacing the table of the
+// daemon, only deleting it when there's no rule.
+func networkACLNftRuleset(ports []networkACLPort) string {
+	buf := bytes.Buffer{}
+	fmt.Fprintf(&buf, "table bridge %s\ndelete table bridge %s\n", networkACLTable, networkACLTable)
+	if len(ports) == 0 {
+		return buf.String()
+	}
+
+	// Egress is matched when coming from the port, ingress when going to it.
+	// Accepting only ends the chains of this table, those of the other
+	// tables of the host still see the traffic.
+	chains := map[string][]string{}
+	for _, port := range ports {
+		for _, rule := range port.rules {
+			verdict := "accept"
+			if rule.Action == "drop" {
+				verdict = "drop"
+			}
+
+			for _, match := range networkACLNftMatches(port, rule) {
+				line := fmt.Sprintf("%s %s", match, verdict)
+				chains["forward"] = append(chains["forward"], line)
+				if rule.Direction == "egress" {
+					chains["input"] = append(chains["input"], line)
+				} else {
+					chains["output"] = append(chains["output"], line)
+				}
+			}
+		}
+	}
+
+	fmt.Fprintf(&buf, "table bridge %s {\n", networkACLTable)
+	for _, chain := range []string{"forward", "input", "output"} {
+		fmt.Fprintf(&buf, "\tchain %s {\n\t\ttype filter hook %s priority 0; policy accept;\n", chain, chain)
+		for _, line := range chains[chain] {
+			fmt.Fprintf(&buf, "\t\t%s\n", line)
+		}
+		fmt.Fprintf(&buf, "\t}\n")
+	}
+	fmt.Fprintf(&buf, "}\n")
+
+	return buf.String()
+}
+
+// networkACLIptablesRules returns the rules of the chain of the daemon, for
+// iptables and ip6tables.
+func networkACLIptablesRules(ports []networkACLPort) map[string][][]string {
+	rules := map[string][][]string{"iptables": {}, "ip6tables": {}}
+	for _, port := range ports {
+		for _, rule := range port.rules {
+			args := []string{"-A", networkACLChain, "-m", "physdev"}
+			addr := "-d"
+			if rule.Direction == "egress" {
+				args = append(args, "--physdev-in", port.iface)
+			} else {
+				args = append(args, "--physdev-out", port.iface, "--physdev-is-bridged")
+				addr = "-s"
+			}
+
+			v4, v6, _ := shared.NetworkACLSubjects(rule.Subject)
+			for _, family := range []struct {
+				command  string
+				subjects []string
+				skip     string
+				icmp     string
+			}{
+				{"iptables", v4, "icmpv6", "icmp"},
+				{"ip6tables", v6, "icmp", "ipv6-icmp"},
+			} {
+				if rule.Protocol == family.skip || (len(family.subjects) == 0 && len(v4)+len(v6) > 0) {
+					continue
+				}
+
+				familyArgs := append([]string{}, args...)
+				if len(family.subjects) > 0 {
+					familyArgs = append(familyArgs, addr, strings.Join(family.subjects, ","))
+				}
+
+				switch rule.Protocol {
+				case "tcp", "udp":
+					familyArgs = append(familyArgs, "-p", rule.Protocol)
+					if rule.Port != "" {
+						ports, _ := shared.NetworkACLPorts(rule.Port)
+						familyArgs = append(familyArgs, "-m", "multiport", "--dports", strings.Replace(strings.Join(ports, ","), "-", ":", -1))
+					}
+				case "icmp", "icmpv6":
+					familyArgs = append(familyArgs, "-p", family.icmp)
+				}
+
+				// The allowed traffic goes on through the rest of
+				// the firewall of the host
+				target := "RETURN"
+				if rule.Action == "drop" {
+					target = "DROP"
+				}
+
+				rules[family.command] = append(rules[family.command], append(familyArgs, "-j", target))
+			}
+		}
+	}
+
+	return rules
+}
+
+func networkACLRun(command string, args ...string) error {
+	out, err := exec.Command(command, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to run %s %s: %s", command, strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// networkACLIptablesApply replaces the rules of the chain of the daemon,
+// hooking it up to the bridged traffic.
+func networkACLIptablesApply(ports []networkACLPort) error {
+	if len(ports) > 0 {
+		err := kernelModulesLoad([]string{"br_netfilter"})
+		if err != nil {
+			return err
+		}
+
+		for _, family := range []string{"iptables", "ip6tables"} {
+			err = ioutil.WriteFile(fmt.Sprintf("/proc/sys/net/bridge/bridge-nf-call-%s", family), []byte("1"), 0644)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for command, rules := range networkACLIptablesRules(ports) {
+		// The chain may not exist yet
+		exec.Command(command, "-w", "-N", networkACLChain).Run()
+
+		err := networkACLRun(command, "-w", "-F", networkACLChain)
+		if err != nil {
+			return err
+		}
+
+		for _, chain := range []string{"FORWARD", "INPUT"} {
+			if exec.Command(command, "-w", "-C", chain, "-j", networkACLChain).Run() != nil {
+				err = networkACLRun(command, "-w", "-I", chain, "-j", networkACLChain)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		for _, rule := range rules {
+			err = networkACLRun(command, append([]string{"-w"}, rule...)...)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// networkACLApply compiles the ACLs of the running containers into the
+// firewall of the host. The firewall is left alone until ACLs get used.
+func networkACLApply(d *Daemon, starting container, stopping string) error {
+	networkACLLock.Lock()
+	defer networkACLLock.Unlock()
+
+	list, err := dbNetworkACLs(d.db)
+	if err != nil {
+		return err
+	}
+
+	if len(list) == 0 && !networkACLActive {
+		return nil
+	}
+
+	ports, err := networkACLPorts(d, list, starting, stopping)
+	if err != nil {
+		return err
+	}
+
+	if len(ports) == 0 && !networkACLActive {
+		return nil
+	}
+
+	_, err = exec.LookPath("nft")
+	if err == nil {
+		cmd := exec.Command("nft", "-f", "-")
+		cmd.Stdin = strings.NewReader(networkACLNftRuleset(ports))
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to load the ACLs into nftables: %s", strings.TrimSpace(string(out)))
+		}
+	} else {
+		_, err = exec.LookPath("iptables")
+		if err != nil {
+			return fmt.Errorf("Network ACLs need nftables or iptables")
+		}
+
+		err = networkACLIptablesApply(ports)
+		if err != nil {
+			return err
+		}
+	}
+
+	networkACLActive = len(ports) > 0
+	return nil
+}
+
+// networkACLReset replaces the rules a previous run of the daemon may have
+// left, when ACLs exist.
+func networkACLReset(d *Daemon) error {
+	acls, err := dbNetworkACLs(d.db)
+	if err != nil {
+		return err
+	}
+
+	if len(acls) == 0 {
+		return nil
+	}
+
+	networkACLLock.Lock()
+	networkACLActive = true
+	networkACLLock.Unlock()
+
+	return networkACLApply(d, nil, "")
+}
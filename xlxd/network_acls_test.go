@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+var networkACLTestPorts = []networkACLPort{{
+	container: "c1",
+	iface:     "veth1",
+	rules: []shared.NetworkACLRule{
+		{Direction: "ingress", Action: "allow", Protocol: "tcp", Port: "80,8000-8099"},
+		{Direction: "egress", Action: "drop", Subject: "10.0.0.0/8,fd00::1"},
+	},
+}}
+
+func TestNetworkACLNftRuleset(t *testing.T) {
+	ruleset := networkACLNftRuleset(networkACLTestPorts)
+
+	for _, line := range []string{
+		"\t\toifname \"veth1\" meta l4proto tcp tcp dport { 80, 8000-8099 } accept\n",
+		"\t\tiifname \"veth1\" ip daddr { 10.0.0.0/8 } drop\n",
+		"\t\tiifname \"veth1\" ip6 daddr { fd00::1/128 } drop\n",
+	} {
+		if !strings.Contains(ruleset, line) {
+			t.Errorf("Missing %q in:\n%s", line, ruleset)
+		}
+	}
+
+	// Only egress goes to the host, only ingress comes from it
+	input := ruleset[strings.Index(ruleset, "chain input"):strings.Index(ruleset, "chain output")]
+	if strings.Contains(input, "oifname") || !strings.Contains(input, "iifname") {
+		t.Errorf("Wrong input chain:\n%s", input)
+	}
+
+	empty := networkACLNftRuleset(nil)
+	if empty != "table bridge xlxd\ndelete table bridge xlxd\n" {
+		t.Errorf("Wrong empty ruleset:\n%s", empty)
+	}
+}
+
+func TestNetworkACLIptablesRules(t *testing.T) {
+	rules := networkACLIptablesRules(networkACLTestPorts)
+
+	expected := map[string][][]string{
+		"iptables": {
+			{"-A", "xlxd-acl", "-m", "physdev", "--physdev-out", "veth1", "--physdev-is-bridged", "-p", "tcp", "-m", "multiport", "--dports", "80,8000:8099", "-j", "RETURN"},
+			{"-A", "xlxd-acl", "-m", "physdev", "--physdev-in", "veth1", "-d", "10.0.0.0/8", "-j", "DROP"},
+		},
+		"ip6tables": {
+			{"-A", "xlxd-acl", "-m", "physdev", "--physdev-out", "veth1", "--physdev-is-bridged", "-p", "tcp", "-m", "multiport", "--dports", "80,8000:8099", "-j", "RETURN"},
+			{"-A", "xlxd-acl", "-m", "physdev", "--physdev-in", "veth1", "-d", "fd00::1/128", "-j", "DROP"},
+		},
+	}
+
+	if !reflect.DeepEqual(rules, expected) {
+		t.Errorf("Wrong rules: %v", rules)
+	}
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// The addresses of a container are read over netlink from within its
+// network namespace, which unlike getifaddrs tells their scope, how they
+// were configured and their lifetimes.
+
+// Address attributes and flags missing from the syscall package
+const (
+	networkIFAFlags = 8
+	networkIFAProto = 11
+
+	networkIFAProtoKernelRA = 2
+
+	networkIFAFTemporary     = 0x01
+	networkIFAFDadFailed     = 0x08
+	networkIFAFTentative     = 0x40
+	networkIFAFPermanent     = 0x80
+	networkIFAFManageTmpAddr = 0x100
+)
+
+// setns(2), which the syscall package doesn't have
+var networkSysSetns = map[string]uintptr{
+	"386":      346,
+	"amd64":    308,
+	"arm":      375,
+	"arm64":    268,
+	"mips":     4344,
+	"mipsle":   4344,
+	"mips64":   5303,
+	"mips64le": 5303,
+	"ppc64":    350,
+	"ppc64le":  350,
+	"riscv64":  268,
+	"s390x":    339,
+}
+
+// The lifetime of the addresses which never expire
+const networkAddressForever = 0xffffffff
+
+var networkAddressScopes = map[uint8]string{
+	syscall.RT_SCOPE_UNIVERSE: "global",
+	syscall.RT_SCOPE_SITE:     "site",
+	syscall.RT_SCOPE_LINK:     "link",
+	syscall.RT_SCOPE_HOST:     "host",
+	syscall.RT_SCOPE_NOWHERE:  "nowhere",
+}
+
+// networkAddress is an address as the kernel reports it.
+type networkAddress struct {
+	iface     string
+	family    int
+	address   net.IP
+	prefix    int
+	scope     uint8
+	proto     uint8
+	flags     uint32
+	preferred uint32
+}
+
+// networkAddressSource tells how a global address was configured from its
+// flags. Addresses with a lifetime come from DHCP or from the router
+// advertisements, DHCPv6 leasing single addresses where SLAAC works on
+// prefixes.
+func networkAddressSource(a networkAddress) string {
+	if a.flags&networkIFAFPermanent != 0 {
+		return "static"
+	}
+
+	if a.family == syscall.AF_INET {
+		return "dhcp"
+	}
+
+	if a.proto == networkIFAProtoKernelRA || a.flags&(networkIFAFManageTmpAddr|networkIFAFTemporary) != 0 || a.prefix < 128 {
+		return "slaac"
+	}
+
+	return "dhcp"
+}
+
+// networkAddressesInfo turns the addresses into their API representation,
+// leaving out the ones which can't be used, still going through duplicate
+// address detection or which failed it.
+func networkAddressesInfo(addresses []networkAddress) []shared.Ip {
+	ips := []shared.Ip{}
+	for _, a := range addresses {
+		if a.flags&(networkIFAFTentative|networkIFAFDadFailed) != 0 {
+			continue
+		}
+
+		ip := shared.Ip{
+			Interface:         a.iface,
+			Protocol:          "IPV4",
+			Address:           a.address.String(),
+			Scope:             networkAddressScopes[a.scope],
+			PreferredLifetime: -1,
+		}
+
+		if a.family == syscall.AF_INET6 {
+			ip.Protocol = "IPV6"
+		}
+
+		if ip.Scope == "" {
+			ip.Scope = shared.IpScope(ip.Address)
+		}
+
+		if ip.Scope == "global" {
+			ip.Source = networkAddressSource(a)
+		}
+
+		if a.preferred != networkAddressForever {
+			ip.PreferredLifetime = int64(a.preferred)
+		}
+
+		ips = append(ips, ip)
+	}
+
+	return ips
+}
+
+// networkAddressesRead lists the addresses of the network namespace of the
+// calling thread.
+func networkAddressesRead() ([]networkAddress, error) {
+	// The interface names, which only the IPv4 addresses come with
+	tab, err := syscall.NetlinkRIB(syscall.RTM_GETLINK, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+
+	names := map[int32]string{}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWLINK || len(m.Data) < syscall.SizeofIfInfomsg {
+			continue
+		}
+
+		info := (*syscall.IfInfomsg)(unsafe.Pointer(&m.Data[0]))
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, attr := range attrs {
+			if attr.Attr.Type == syscall.IFLA_IFNAME {
+				names[info.Index] = strings.TrimRight(string(attr.Value), "\x00")
+			}
+		}
+	}
+
+	tab, err = syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err = syscall.ParseNetlinkMessage(tab)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := []networkAddress{}
+	for _, m := range msgs {
+		if m.Header.Type != syscall.RTM_NEWADDR || len(m.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+
+		ifa := (*syscall.IfAddrmsg)(unsafe.Pointer(&m.Data[0]))
+		if ifa.Family != syscall.AF_INET && ifa.Family != syscall.AF_INET6 {
+			continue
+		}
+
+		a := networkAddress{
+			iface:     names[int32(ifa.Index)],
+			family:    int(ifa.Family),
+			prefix:    int(ifa.Prefixlen),
+			scope:     ifa.Scope,
+			flags:     uint32(ifa.Flags),
+			preferred: networkAddressForever,
+		}
+
+		attrs, err := syscall.ParseNetlinkRouteAttr(&m)
+		if err != nil {
+			return nil, err
+		}
+
+		// The local address comes first, the address being the one of
+		// the peer on point to point links
+		var local net.IP
+		for _, attr := range attrs {
+			switch attr.Attr.Type {
+			case syscall.IFA_ADDRESS:
+				a.address = net.IP(attr.Value)
+			case syscall.IFA_LOCAL:
+				local = net.IP(attr.Value)
+			case syscall.IFA_CACHEINFO:
+				// ifa_prefered is the first field of the struct
+				if len(attr.Value) >= 4 {
+					a.preferred = *(*uint32)(unsafe.Pointer(&attr.Value[0]))
+				}
+			case networkIFAFlags:
+				if len(attr.Value) >= 4 {
+					a.flags = *(*uint32)(unsafe.Pointer(&attr.Value[0]))
+				}
+			case networkIFAProto:
+				if len(attr.Value) >= 1 {
+					a.proto = attr.Value[0]
+				}
+			}
+		}
+
+		if local != nil {
+			a.address = local
+		}
+
+		if a.address == nil {
+			continue
+		}
+
+		addresses = append(addresses, a)
+	}
+
+	return addresses, nil
+}
+
+// networkAddressesGet returns the addresses of the network namespace of a
+// process. They're read from a thread moved to that namespace, which never
+// gets back to the daemon.
+func networkAddressesGet(pid int) ([]shared.Ip, error) {
+	type result struct {
+		addresses []networkAddress
+		err       error
+	}
+
+	setns, ok := networkSysSetns[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("Reading the addresses of containers isn't supported on %s", runtime.GOARCH)
+	}
+
+	ch := make(chan result, 1)
+	go func() {
+		// Without unlocking, the thread exits along with the goroutine
+		runtime.LockOSThread()
+
+		f, err := os.Open(fmt.Sprintf("/proc/%d/ns/net", pid))
+		if err != nil {
+			ch <- result{err: err}
+			return
+		}
+		defer f.Close()
+
+		_, _, errno := syscall.Syscall(setns, f.Fd(), syscall.CLONE_NEWNET, 0)
+		if errno != 0 {
+			ch <- result{err: fmt.Errorf("Failed to enter the network namespace: %s", errno)}
+			return
+		}
+
+		addresses, err := networkAddressesRead()
+		ch <- result{addresses: addresses, err: err}
+	}()
+
+	res := <-ch
+	if res.err != nil {
+		return nil, fmt.Errorf("Failed to list the addresses: %s", res.err)
+	}
+
+	return networkAddressesInfo(res.addresses), nil
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"net"
+	"reflect"
+	"syscall"
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestNetworkAddressesInfo(t *testing.T) {
+	addresses := []networkAddress{
+		{iface: "lo", family: syscall.AF_INET, address: net.ParseIP("127.0.0.1"), prefix: 8, scope: syscall.RT_SCOPE_HOST, flags: networkIFAFPermanent, preferred: networkAddressForever},
+		{iface: "lo", family: syscall.AF_INET6, address: net.ParseIP("::1"), prefix: 128, scope: syscall.RT_SCOPE_HOST, flags: networkIFAFPermanent, preferred: networkAddressForever},
+		{iface: "eth0", family: syscall.AF_INET, address: net.ParseIP("10.0.3.5"), prefix: 24, preferred: 3590},
+		{iface: "eth0", family: syscall.AF_INET6, address: net.ParseIP("fd42:1::216:3eff:fe00:1"), prefix: 64, flags: networkIFAFManageTmpAddr, preferred: 14390},
+		{iface: "eth0", family: syscall.AF_INET6, address: net.ParseIP("fd42:1::10"), prefix: 128, preferred: 0},
+		{iface: "eth0", family: syscall.AF_INET6, address: net.ParseIP("fd42:1::20"), prefix: 64, flags: networkIFAFPermanent | networkIFAFTentative, preferred: networkAddressForever},
+		{iface: "eth0", family: syscall.AF_INET6, address: net.ParseIP("fe80::216:3eff:fe00:1"), prefix: 64, scope: syscall.RT_SCOPE_LINK, flags: networkIFAFPermanent, preferred: networkAddressForever},
+		{iface: "eth1", family: syscall.AF_INET, address: net.ParseIP("192.168.1.10"), prefix: 24, flags: networkIFAFPermanent, preferred: networkAddressForever},
+	}
+
+	expected := []shared.Ip{
+		{Interface: "lo", Protocol: "IPV4", Address: "127.0.0.1", Scope: "host", PreferredLifetime: -1},
+		{Interface: "lo", Protocol: "IPV6", Address: "::1", Scope: "host", PreferredLifetime: -1},
+		{Interface: "eth0", Protocol: "IPV4", Address: "10.0.3.5", Scope: "global", Source: "dhcp", PreferredLifetime: 3590},
+		{Interface: "eth0", Protocol: "IPV6", Address: "fd42:1::216:3eff:fe00:1", Scope: "global", Source: "slaac", PreferredLifetime: 14390},
+		{Interface: "eth0", Protocol: "IPV6", Address: "fd42:1::10", Scope: "global", Source: "dhcp", PreferredLifetime: 0},
+		{Interface: "eth0", Protocol: "IPV6", Address: "fe80::216:3eff:fe00:1", Scope: "link", PreferredLifetime: -1},
+		{Interface: "eth1", Protocol: "IPV4", Address: "192.168.1.10", Scope: "global", Source: "static", PreferredLifetime: -1},
+	}
+
+	ips := networkAddressesInfo(addresses)
+	if !reflect.DeepEqual(ips, expected) {
+		t.Errorf("Wrong addresses:\n%+v\nexpected:\n%+v", ips, expected)
+	}
+}
+
+func TestNetworkAddressesRead(t *testing.T) {
+	addresses, err := networkAddressesRead()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ip := range networkAddressesInfo(addresses) {
+		if ip.Interface == "lo" && ip.Address == "127.0.0.1" && ip.Scope == "host" {
+			return
+		}
+	}
+
+	t.Errorf("The loopback address is missing from %+v", addresses)
+}
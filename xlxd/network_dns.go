@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// The dnsmasq instances serving the bridges aren't run by the daemon, they
+// get the names of the containers by including networkDNSConfigPath(), which
+// points them at a dhcp-hostsdir holding a file per container. dnsmasq
+// watches that directory, the leases of the containers get their names and
+// <name>.<domain> resolves to them without restarting it.
+
+var networkDNSLock sync.Mutex
+
+var networkDNSLabel = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?$`)
+
+func networkDNSHostsPath() string {
+	return shared.VarPath("networks", "dhcp-hosts")
+}
+
+func networkDNSConfigPath() string {
+	return shared.VarPath("networks", "dnsmasq.conf")
+}
+
+// networkDNSDomainValidate checks the value of network.dns.domain.
+func networkDNSDomainValidate(domain string) error {
+	if domain == "" {
+		return nil
+	}
+
+	for _, label := range strings.Split(domain, ".") {
+		if !networkDNSLabel.MatchString(label) {
+			return fmt.Errorf("Invalid DNS domain: %s", domain)
+		}
+	}
+
+	return nil
+}
+
+// networkDNSDomain returns the domain of the names of the containers.
+func networkDNSDomain(d *Daemon) string {
+	domain, _ := d.ConfigValueGet("network.dns.domain")
+	if domain == "" {
+		return "lxd"
+	}
+
+	return domain
+}
+
+// networkDNSHosts returns the dhcp-host lines of a container, one per nic
+// on a bridge.
+func networkDNSHosts(c container) []string {
+	hosts := []string{}
+	if c.IsSnapshot() {
+		return hosts
+	}
+
+	config := c.ExpandedConfig()
+	for name, m := range c.ExpandedDevices() {
+		if m["type"] != "nic" || m["nictype"] != "bridged" {
+			continue
+		}
+
+		hwaddr := m["hwaddr"]
+		if hwaddr == "" {
+			hwaddr = config[fmt.Sprintf("volatile.%s.hwaddr", name)]
+		}
+
+		if hwaddr == "" {
+			continue
+		}
+
+		hosts = append(hosts, fmt.Sprintf("%s,%s", hwaddr, c.Name()))
+	}
+
+	sort.Strings(hosts)
+	return hosts
+}
+
+// networkDNSBridges returns the bridges the nics of the container are on.
+func networkDNSBridges(c container) []string {
+	bridges := []string{}
+	for _, m := range c.ExpandedDevices() {
+		if m["type"] == "nic" && m["nictype"] == "bridged" && !shared.StringInSlice(m["parent"], bridges) {
+			bridges = append(bridges, m["parent"])
+		}
+	}
+
+	return bridges
+}
+
+// networkDNSContainerUpdate registers the name of the container for the
+// MAC addresses of its nics, which get known when it first starts.
+func networkDNSContainerUpdate(c container) {
+	networkDNSLock.Lock()
+	defer networkDNSLock.Unlock()
+
+	hosts := networkDNSHosts(c)
+	path := shared.VarPath("networks", "dhcp-hosts", c.Name())
+	if len(hosts) == 0 && !shared.PathExists(path) {
+		return
+	}
+
+	content := ""
+	if len(hosts) > 0 {
+		content = strings.Join(hosts, "\n") + "\n"
+	}
+
+	err := ioutil.WriteFile(path, []byte(content), 0644)
+	if err != nil {
+		shared.Log.Error("Failed to register the DNS name of the container", log.Ctx{"container": c.Name(), "err": err})
+		return
+	}
+
+	if shared.IsTrue(networkDNSHostConfig(c.Daemon())) {
+		for _, bridge := range networkDNSBridges(c) {
+			err := networkDNSHostResolve(bridge, networkDNSDomain(c.Daemon()), true)
+			if err != nil {
+				shared.Log.Warn("Couldn't set up the host to resolve the container names", log.Ctx{"bridge": bridge, "err": err})
+			}
+		}
+	}
+}
+
+// networkDNSContainerRemove unregisters the name of a container. The file
+// is emptied rather than deleted, dnsmasq only notices changed files.
+func networkDNSContainerRemove(name string) {
+	networkDNSLock.Lock()
+	defer networkDNSLock.Unlock()
+
+	path := shared.VarPath("networks", "dhcp-hosts", name)
+	if !shared.PathExists(path) {
+		return
+	}
+
+	err := ioutil.WriteFile(path, []byte{}, 0644)
+	if err != nil {
+		shared.Log.Error("Failed to unregister the DNS name of the container", log.Ctx{"container": name, "err": err})
+	}
+}
+
+func networkDNSHostConfig(d *Daemon) string {
+	value, _ := d.ConfigValueGet("network.dns.host")
+	return value
+}
+
+// networkDNSHostResolve makes the host resolve the names of the containers
+// through the dnsmasq of the bridge, with systemd-resolved, or reverts it.
+func networkDNSHostResolve(bridge string, domain string, enable bool) error {
+	_, err := exec.LookPath("resolvectl")
+	if err != nil {
+		return fmt.Errorf("The host resolver can only be set up with systemd-resolved")
+	}
+
+	if !enable {
+		out, err := exec.Command("resolvectl", "revert", bridge).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to revert the DNS settings of %s: %s", bridge, strings.TrimSpace(string(out)))
+		}
+
+		return nil
+	}
+
+	iface, err := net.InterfaceByName(bridge)
+	if err != nil {
+		return err
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	servers := []string{}
+	for _, addr := range addrs {
+		ip, _, err := net.ParseCIDR(addr.String())
+		if err == nil && ip.IsGlobalUnicast() {
+			servers = append(servers, ip.String())
+		}
+	}
+
+	if len(servers) == 0 {
+		return fmt.Errorf("The bridge %s has no address to query", bridge)
+	}
+
+	for _, args := range [][]string{
+		append([]string{"dns", bridge}, servers...),
+		{"domain", bridge, fmt.Sprintf("~%s", domain)},
+	} {
+		out, err := exec.Command("resolvectl", args...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("Failed to set the DNS settings of %s: %s", bridge, strings.TrimSpace(string(out)))
+		}
+	}
+
+	return nil
+}
+
+// networkDNSSetup writes the dnsmasq config for the current domain and
+// registers the names of all the containers, when the daemon starts or the
+// network.dns keys change.
+func networkDNSSetup(d *Daemon) error {
+	err := os.MkdirAll(networkDNSHostsPath(), 0755)
+	if err != nil {
+		return err
+	}
+
+	domain := networkDNSDomain(d)
+	conf := fmt.Sprintf(`# Include this in the dnsmasq config of the bridges with conf-file=%s
+domain=%s
+local=/%s/
+dhcp-hostsdir=%s
+`, networkDNSConfigPath(), domain, domain, networkDNSHostsPath())
+
+	err = ioutil.WriteFile(networkDNSConfigPath(), []byte(conf), 0644)
+	if err != nil {
+		return err
+	}
+
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			continue
+		}
+
+		networkDNSContainerUpdate(c)
+	}
+
+	return nil
+}
+
+// networkDNSHostRevert stops the host from resolving the names of the
+// containers, when network.dns.host gets turned off.
+func networkDNSHostRevert(d *Daemon) error {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return err
+	}
+
+	bridges := []string{}
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			continue
+		}
+
+		for _, bridge := range networkDNSBridges(c) {
+			if !shared.StringInSlice(bridge, bridges) {
+				bridges = append(bridges, bridge)
+			}
+		}
+	}
+
+	for _, bridge := range bridges {
+		err := networkDNSHostResolve(bridge, "", false)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
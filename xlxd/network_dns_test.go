@@ -0,0 +1,20 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestNetworkDNSDomainValidate(t *testing.T) {
+	for _, domain := range []string{"", "lxd", "containers.example", "dc-1.example.org"} {
+		err := networkDNSDomainValidate(domain)
+		if err != nil {
+			t.Errorf("Refused the domain %q: %s", domain, err)
+		}
+	}
+
+	for _, domain := range []string{".lxd", "lxd.", "bad..domain", "-lxd", "lxd-", "under_score", "with space"} {
+		if networkDNSDomainValidate(domain) == nil {
+			t.Errorf("Accepted the domain %q", domain)
+		}
+	}
+}
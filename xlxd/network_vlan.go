@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// Nics can sit on a VLAN, getting a tagged sub-interface of their parent
+// when macvlan or physical. Bridged nics get plugged into a bridge of their
+// own for the VLAN, its only uplink being the tagged sub-interface of their
+// bridge, which leaves the VLAN filtering of the bridge, often shared with
+// the host, alone. The parent of macvlan and physical nics can also be a
+// bond of host interfaces. The sub-interfaces, VLAN bridges and bonds get
+// created when first needed and are then kept for the other containers.
+
+var networkBondModes = []string{"balance-rr", "active-backup", "balance-xor", "broadcast", "802.3ad", "balance-tlb", "balance-alb"}
+
+// Linux's IFNAMSIZ, less the terminating null
+const networkInterfaceNameMax = 15
+
+// networkVLANParse returns the VLAN ID of the vlan key of a nic.
+func networkVLANParse(value string) (int, error) {
+	id, err := strconv.Atoi(value)
+	if err != nil || id < 1 || id > 4094 {
+		return -1, fmt.Errorf("Invalid VLAN ID, must be between 1 and 4094: %s", value)
+	}
+
+	return id, nil
+}
+
+// networkBondSlaves returns the interfaces listed in bond.slaves.
+func networkBondSlaves(value string) []string {
+	slaves := []string{}
+	for _, slave := range strings.Split(value, ",") {
+		slave = strings.TrimSpace(slave)
+		if slave != "" {
+			slaves = append(slaves, slave)
+		}
+	}
+
+	return slaves
+}
+
+// networkNicValidate checks the VLAN and bond keys of a nic.
+func networkNicValidate(m shared.Device) error {
+	if m["vlan"] != "" {
+		if !shared.StringInSlice(m["nictype"], []string{"bridged", "macvlan", "physical"}) {
+			return fmt.Errorf("Only bridged, macvlan and physical nics can use a VLAN")
+		}
+
+		_, err := networkVLANParse(m["vlan"])
+		if err != nil {
+			return err
+		}
+
+		for _, name := range []string{networkVLANInterface(m), networkNicLink(m)} {
+			if len(name) > networkInterfaceNameMax {
+				return fmt.Errorf("The name of the VLAN interface %s is too long", name)
+			}
+		}
+	}
+
+	if m["bond.mode"] != "" {
+		if m["bond.slaves"] == "" {
+			return fmt.Errorf("A bond mode needs bond.slaves")
+		}
+
+		if !shared.StringInSlice(m["bond.mode"], networkBondModes) {
+			return fmt.Errorf("Invalid bond mode, must be one of %s: %s", strings.Join(networkBondModes, ", "), m["bond.mode"])
+		}
+	}
+
+	if m["bond.slaves"] != "" {
+		if !shared.StringInSlice(m["nictype"], []string{"macvlan", "physical"}) {
+			return fmt.Errorf("Only macvlan and physical nics can have a bond parent")
+		}
+
+		slaves := networkBondSlaves(m["bond.slaves"])
+		if len(slaves) == 0 || shared.StringInSlice(m["parent"], slaves) {
+			return fmt.Errorf("Invalid bond slaves: %s", m["bond.slaves"])
+		}
+	}
+
+	return nil
+}
+
+// networkVLANInterface returns the tagged sub-interface of the parent of a
+// nic with a VLAN.
+func networkVLANInterface(m shared.Device) string {
+	return fmt.Sprintf("%s.%s", m["parent"], m["vlan"])
+}
+
+// networkNicLink returns the host interface a nic uses: its parent, or when
+// it has a VLAN, the VLAN sub-interface of its parent for macvlan and
+// physical nics and the bridge of the VLAN for bridged ones.
+func networkNicLink(m shared.Device) string {
+	if m["vlan"] == "" {
+		return m["parent"]
+	}
+
+	if m["nictype"] == "bridged" {
+		return fmt.Sprintf("%sv%s", m["parent"], m["vlan"])
+	}
+
+	return networkVLANInterface(m)
+}
+
+func networkInterfaceExists(name string) bool {
+	return shared.PathExists(filepath.Join("/sys/class/net", name))
+}
+
+func networkIPLink(args ...string) error {
+	out, err := exec.Command("ip", append([]string{"link"}, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("Failed to run ip link %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// networkBondSetup creates the bond parent of a nic if it's missing.
+func networkBondSetup(m shared.Device) error {
+	bond := m["parent"]
+	if networkInterfaceExists(bond) {
+		if !shared.PathExists(filepath.Join("/sys/class/net", bond, "bonding")) {
+			return fmt.Errorf("The parent %s exists and isn't a bond", bond)
+		}
+
+		return nil
+	}
+
+	// Never take over interfaces the host uses
+	slaves := networkBondSlaves(m["bond.slaves"])
+	for _, slave := range slaves {
+		err := networkBondSlaveCheck(slave)
+		if err != nil {
+			return err
+		}
+	}
+
+	mode := m["bond.mode"]
+	if mode == "" {
+		mode = "active-backup"
+	}
+
+	err := networkIPLink("add", bond, "type", "bond", "mode", mode)
+	if err != nil {
+		return err
+	}
+
+	// Interfaces must be down to be enslaved
+	for _, slave := range slaves {
+		err = networkIPLink("set", "dev", slave, "down")
+		if err == nil {
+			err = networkIPLink("set", "dev", slave, "master", bond)
+		}
+
+		if err != nil {
+			deviceRemoveInterface(bond)
+			return err
+		}
+	}
+
+	return networkIPLink("set", "dev", bond, "up")
+}
+
+// networkBondSlaveCheck makes sure an interface can be enslaved to a new
+// bond, existing without addresses nor master.
+func networkBondSlaveCheck(slave string) error {
+	iface, err := net.InterfaceByName(slave)
+	if err != nil {
+		return fmt.Errorf("The bond slave %s doesn't exist", slave)
+	}
+
+	if shared.PathExists(filepath.Join("/sys/class/net", slave, "master")) {
+		return fmt.Errorf("The bond slave %s already has a master", slave)
+	}
+
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return err
+	}
+
+	if len(addrs) > 0 {
+		return fmt.Errorf("The bond slave %s is in use, it has addresses", slave)
+	}
+
+	return nil
+}
+
+// networkNicSetup creates the bond and VLAN interfaces a nic needs before it
+// can be created on its link, along with the bridge of the VLAN of bridged
+// nics.
+func networkNicSetup(m shared.Device) error {
+	if !shared.StringInSlice(m["nictype"], []string{"bridged", "macvlan", "physical"}) {
+		return nil
+	}
+
+	if m["bond.slaves"] != "" {
+		err := networkBondSetup(m)
+		if err != nil {
+			return err
+		}
+	}
+
+	if m["vlan"] == "" {
+		return nil
+	}
+
+	vlan := networkVLANInterface(m)
+	if !networkInterfaceExists(vlan) {
+		err := networkIPLink("add", "link", m["parent"], "name", vlan, "type", "vlan", "id", m["vlan"])
+		if err != nil {
+			return err
+		}
+
+		err = networkIPLink("set", "dev", vlan, "up")
+		if err != nil {
+			return err
+		}
+	}
+
+	bridge := networkNicLink(m)
+	if bridge == vlan || networkInterfaceExists(bridge) {
+		return nil
+	}
+
+	err := networkIPLink("add", bridge, "type", "bridge")
+	if err != nil {
+		return err
+	}
+
+	err = networkIPLink("set", "dev", vlan, "master", bridge)
+	if err != nil {
+		deviceRemoveInterface(bridge)
+		return err
+	}
+
+	return networkIPLink("set", "dev", bridge, "up")
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+func TestNetworkNicValidate(t *testing.T) {
+	for _, m := range []shared.Device{
+		{"nictype": "bridged", "parent": "lxcbr0", "vlan": "10"},
+		{"nictype": "macvlan", "parent": "eth0", "vlan": "4094"},
+		{"nictype": "macvlan", "parent": "bond0", "bond.slaves": "eth0, eth1", "vlan": "10"},
+		{"nictype": "physical", "parent": "bond0", "bond.slaves": "eth0", "bond.mode": "802.3ad"},
+	} {
+		err := networkNicValidate(m)
+		if err != nil {
+			t.Errorf("Refused the nic %v: %s", m, err)
+		}
+	}
+
+	for _, m := range []shared.Device{
+		{"nictype": "bridged", "parent": "lxcbr0", "vlan": "0"},
+		{"nictype": "bridged", "parent": "lxcbr0", "vlan": "4095"},
+		{"nictype": "p2p", "vlan": "10"},
+		{"nictype": "macvlan", "parent": "enp0s31f6u1u2", "vlan": "100"},
+		{"nictype": "bridged", "parent": "enp0s31f6br", "vlan": "1000"},
+		{"nictype": "bridged", "parent": "lxcbr0", "bond.slaves": "eth0"},
+		{"nictype": "macvlan", "parent": "bond0", "bond.slaves": "bond0,eth0"},
+		{"nictype": "macvlan", "parent": "bond0", "bond.slaves": " , "},
+		{"nictype": "macvlan", "parent": "bond0", "bond.mode": "802.3ad"},
+		{"nictype": "macvlan", "parent": "bond0", "bond.slaves": "eth0", "bond.mode": "fast"},
+	} {
+		if networkNicValidate(m) == nil {
+			t.Errorf("Accepted the nic %v", m)
+		}
+	}
+}
+
+func TestNetworkNicLink(t *testing.T) {
+	for expected, m := range map[string]shared.Device{
+		"lxcbr0":    {"nictype": "bridged", "parent": "lxcbr0"},
+		"lxcbr0v10": {"nictype": "bridged", "parent": "lxcbr0", "vlan": "10"},
+		"eth0":      {"nictype": "macvlan", "parent": "eth0"},
+		"eth0.10":   {"nictype": "macvlan", "parent": "eth0", "vlan": "10"},
+		"bond0.20":  {"nictype": "physical", "parent": "bond0", "bond.slaves": "eth0", "vlan": "20"},
+	} {
+		link := networkNicLink(m)
+		if link != expected {
+			t.Errorf("Wrong link for %v: %s", m, link)
+		}
+	}
+}
@@ -45,7 +45,12 @@ func networksGet(d *Daemon, r *http.Request) Response {
 		return SyncResponse(true, resultString)
 	}
 
-	return SyncResponse(true, resultMap)
+	result, err := fieldsFilter(r, resultMap, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, result)
 }
 
 var networksCmd = Command{name: "networks", get: networksGet}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"net/http"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +14,8 @@ import (
 	"github.com/pborman/uuid"
 
 	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
 )
 
 var operationsLock sync.Mutex
@@ -33,6 +37,17 @@ func (t operationClass) String() string {
 	}[t]
 }
 
+// operationMetadataError is an error adding details to the metadata of the
+// operation it makes fail.
+type operationMetadataError struct {
+	err      error
+	metadata map[string]interface{}
+}
+
+func (e operationMetadataError) Error() string {
+	return e.err.Error()
+}
+
 type operation struct {
 	id        string
 	class     operationClass
@@ -45,6 +60,12 @@ type operation struct {
 	err       string
 	readonly  bool
 
+	// The request which created the operation and its client, the daemon
+	// is set to keep the operation in the history once done
+	description string
+	initiator   string
+	daemon      *Daemon
+
 	// Those functions are called at various points in the operation lifecycle
 	onRun     func(*operation) error
 	onCancel  func(*operation) error
@@ -64,12 +85,17 @@ func (op *operation) done() {
 
 	op.lock.Lock()
 	op.readonly = true
+	op.updatedAt = time.Now()
 	op.onRun = nil
 	op.onCancel = nil
 	op.onConnect = nil
 	close(op.chanDone)
 	op.lock.Unlock()
 
+	if op.daemon != nil {
+		operationHistorySave(op)
+	}
+
 	time.AfterFunc(time.Second*5, func() {
 		operationsLock.Lock()
 		_, ok := operations[op.id]
@@ -112,6 +138,15 @@ func (op *operation) Run() (chan error, error) {
 				op.lock.Lock()
 				op.status = shared.Failure
 				op.err = err.Error()
+				if merr, ok := err.(operationMetadataError); ok {
+					if op.metadata == nil {
+						op.metadata = map[string]interface{}{}
+					}
+
+					for k, v := range merr.metadata {
+						op.metadata[k] = v
+					}
+				}
 				op.lock.Unlock()
 				op.done()
 				chanRun <- err
@@ -276,9 +311,93 @@ func (op *operation) Render() (string, *shared.Operation, error) {
 		Metadata:   &md,
 		MayCancel:  op.mayCancel(),
 		Err:        op.err,
+
+		Description: op.description,
+		Initiator:   op.initiator,
 	}, nil
 }
 
+// record notes the request which created the operation and its client, and
+// has the operation kept in the history once done.
+func (op *operation) record(d *Daemon, r *http.Request) {
+	op.lock.Lock()
+	op.description = fmt.Sprintf("%s %s", r.Method, r.URL.Path)
+	op.initiator = d.requestInitiator(r)
+	op.daemon = d
+	op.lock.Unlock()
+}
+
+// operationHistorySave adds a done operation to the history, which keeps
+// them for core.operations_history_expiry days (7 by default, 0 disables it).
+func operationHistorySave(op *operation) {
+	d := op.daemon
+
+	value, err := d.ConfigValueGet("core.operations_history_expiry")
+	if err != nil {
+		return
+	}
+
+	days := 7
+	if value != "" {
+		days, err = strconv.Atoi(value)
+		if err != nil {
+			return
+		}
+	}
+
+	if days == 0 {
+		return
+	}
+
+	_, body, err := op.Render()
+	if err != nil {
+		return
+	}
+
+	err = dbOperationSave(d.db, body)
+	if err != nil {
+		shared.Log.Warn("Failed to add the operation to the history", log.Ctx{"operation": op.id, "err": err})
+		return
+	}
+
+	err = dbOperationsPrune(d.db, time.Now().AddDate(0, 0, -days))
+	if err != nil {
+		shared.Log.Warn("Failed to prune the operations history", log.Ctx{"err": err})
+	}
+}
+
+// operationsSince parses the since parameter of the operations listing, a
+// date in RFC3339 format or a duration before now like 2h.
+func operationsSince(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	duration, err := time.ParseDuration(value)
+	if err == nil {
+		return time.Now().Add(-duration), nil
+	}
+
+	since, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("Invalid since, must be a RFC3339 date or a duration: %s", value)
+	}
+
+	return since, nil
+}
+
+// operationHideSecrets removes the metadata of websocket operations, the
+// secrets to connect to them, from what read-only clients get to see.
+func operationHideSecrets(body *shared.Operation) *shared.Operation {
+	if body.Class != operationClassWebsocket.String() {
+		return body
+	}
+
+	hidden := *body
+	hidden.Metadata = nil
+	return &hidden
+}
+
 func (op *operation) WaitFinal(timeout int) (bool, error) {
 	// Check current state
 	if op.status.IsFinal() {
@@ -424,13 +543,36 @@ func operationGet(id string) (*operation, error) {
 	return op, nil
 }
 
+// operationForResource returns the running operation acting on a resource,
+// if any.
+func operationForResource(kind string, name string) *operation {
+	operationsLock.Lock()
+	defer operationsLock.Unlock()
+
+	for _, op := range operations {
+		if op.status != shared.Running {
+			continue
+		}
+
+		op.lock.Lock()
+		resources := op.resources[kind]
+		op.lock.Unlock()
+
+		if shared.StringInSlice(name, resources) {
+			return op
+		}
+	}
+
+	return nil
+}
+
 // API functions
 func operationAPIGet(d *Daemon, r *http.Request) Response {
 	id := mux.Vars(r)["id"]
 
 	op, err := operationGet(id)
 	if err != nil {
-		return NotFound
+		return operationHistoryGet(d, id)
 	}
 
 	_, body, err := op.Render()
@@ -438,6 +580,10 @@ func operationAPIGet(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
+	if d.isReadOnlyClient(r) {
+		body = operationHideSecrets(body)
+	}
+
 	return SyncResponse(true, body)
 }
 
@@ -459,19 +605,56 @@ func operationAPIDelete(d *Daemon, r *http.Request) Response {
 
 var operationCmd = Command{name: "operations/{id}", get: operationAPIGet, delete: operationAPIDelete}
 
+// operationHistoryGet returns an operation which is done and gone, from the
+// history.
+func operationHistoryGet(d *Daemon, id string) Response {
+	body, err := dbOperationGet(d.db, id)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, body)
+}
+
+// operationsByCreation sorts operations from the oldest to the newest.
+type operationsByCreation []*shared.Operation
+
+func (a operationsByCreation) Len() int           { return len(a) }
+func (a operationsByCreation) Swap(i, j int)      { a[i], a[j] = a[j], a[i] }
+func (a operationsByCreation) Less(i, j int) bool { return a[i].CreatedAt.Before(a[j].CreatedAt) }
+
+// operationsAPIGet lists the operations by status. Given a status or a since
+// parameter, only the matching ones are listed, those in the history too.
 func operationsAPIGet(d *Daemon, r *http.Request) Response {
 	var md shared.Jmap
 
 	recursion := d.isRecursionRequest(r)
+	readOnly := d.isReadOnlyClient(r)
+
+	statusFilter := strings.ToLower(r.FormValue("status"))
+	since, err := operationsSince(r.FormValue("since"))
+	if err != nil {
+		return BadRequest(err)
+	}
+	history := statusFilter != "" || r.FormValue("since") != ""
 
 	md = shared.Jmap{}
 
 	operationsLock.Lock()
-	ops := operations
+	ops := []*operation{}
+	for _, v := range operations {
+		ops = append(ops, v)
+	}
 	operationsLock.Unlock()
 
+	seen := map[string]bool{}
 	for _, v := range ops {
 		status := strings.ToLower(v.status.String())
+		if (statusFilter != "" && status != statusFilter) || v.createdAt.Before(since) {
+			continue
+		}
+		seen[v.id] = true
+
 		_, ok := md[status]
 		if !ok {
 			if recursion {
@@ -491,9 +674,45 @@ func operationsAPIGet(d *Daemon, r *http.Request) Response {
 			continue
 		}
 
+		if readOnly {
+			body = operationHideSecrets(body)
+		}
+
 		md[status] = append(md[status].([]*shared.Operation), body)
 	}
 
+	if !history {
+		return SyncResponse(true, md)
+	}
+
+	done, err := dbOperationsGet(d.db, since)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	for _, body := range done {
+		status := strings.ToLower(body.Status)
+		if (statusFilter != "" && status != statusFilter) || seen[body.Id] {
+			continue
+		}
+
+		if !recursion {
+			urls, _ := md[status].([]string)
+			md[status] = append(urls, fmt.Sprintf("/%s/operations/%s", shared.APIVersion, body.Id))
+			continue
+		}
+
+		bodies, _ := md[status].([]*shared.Operation)
+		md[status] = append(bodies, body)
+	}
+
+	// The operations being in a map, only the history is in order
+	for _, list := range md {
+		if bodies, ok := list.([]*shared.Operation); ok {
+			sort.Stable(operationsByCreation(bodies))
+		}
+	}
+
 	return SyncResponse(true, md)
 }
 
@@ -508,7 +727,7 @@ func operationAPIWaitGet(d *Daemon, r *http.Request) Response {
 	id := mux.Vars(r)["id"]
 	op, err := operationGet(id)
 	if err != nil {
-		return NotFound
+		return operationHistoryGet(d, id)
 	}
 
 	_, err = op.WaitFinal(timeout)
@@ -521,6 +740,10 @@ func operationAPIWaitGet(d *Daemon, r *http.Request) Response {
 		return InternalError(err)
 	}
 
+	if d.isReadOnlyClient(r) {
+		body = operationHideSecrets(body)
+	}
+
 	return SyncResponse(true, body)
 }
 
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// pagination is the page of a listing asked for with the offset and limit
+// parameters, a limit of -1 meaning up to the end.
+type pagination struct {
+	offset int
+	limit  int
+}
+
+var paginationAll = pagination{offset: 0, limit: -1}
+
+// paginationGet returns the page the request asks for, everything by default.
+func paginationGet(r *http.Request) (pagination, error) {
+	offset, err := shared.AtoiEmptyDefault(r.FormValue("offset"), 0)
+	if err != nil || offset < 0 {
+		return pagination{}, fmt.Errorf("Invalid offset: %s", r.FormValue("offset"))
+	}
+
+	limit, err := shared.AtoiEmptyDefault(r.FormValue("limit"), -1)
+	if err != nil || limit < -1 {
+		return pagination{}, fmt.Errorf("Invalid limit: %s", r.FormValue("limit"))
+	}
+
+	return pagination{offset: offset, limit: limit}, nil
+}
+
+// apply returns the names of the page out of all the names of the listing,
+// which must always be in the same order.
+func (p pagination) apply(names []string) []string {
+	if p.offset >= len(names) {
+		return []string{}
+	}
+
+	names = names[p.offset:]
+	if p.limit >= 0 && p.limit < len(names) {
+		names = names[:p.limit]
+	}
+
+	return names
+}
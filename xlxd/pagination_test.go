@@ -0,0 +1,52 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestPagination(t *testing.T) {
+	names := []string{"a", "b", "c", "d", "e"}
+
+	tests := []struct {
+		query    string
+		expected []string
+	}{
+		{"", names},
+		{"limit=2", []string{"a", "b"}},
+		{"limit=2&offset=4", []string{"e"}},
+		{"offset=1", []string{"b", "c", "d", "e"}},
+		{"offset=5", []string{}},
+		{"limit=0", []string{}},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "/1.0/containers?"+test.query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		page, err := paginationGet(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		result := page.apply(names)
+		if !reflect.DeepEqual(result, test.expected) {
+			t.Errorf("Unexpected page for %q: %v", test.query, result)
+		}
+	}
+
+	for _, query := range []string{"offset=-1", "limit=-2", "limit=abc"} {
+		r, err := http.NewRequest("GET", "/1.0/containers?"+query, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = paginationGet(r)
+		if err == nil {
+			t.Errorf("Invalid page %q was accepted", query)
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+var preseedCmd = Command{name: "preseed", put: preseedPut}
+
+// preseedPut applies a declarative description of the daemon's setup and
+// reports what it had to change, applying the same one again changes nothing.
+func preseedPut(d *Daemon, r *http.Request) Response {
+	req := shared.Preseed{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		return BadRequest(err)
+	}
+
+	// Validate everything before changing anything
+	for key := range req.Config {
+		if !d.ConfigKeyIsValid(key) {
+			return BadRequest(fmt.Errorf("Bad server config key: '%s'", key))
+		}
+	}
+
+	for _, network := range req.Networks {
+		if network.Name == "" {
+			return BadRequest(fmt.Errorf("No network name provided"))
+		}
+
+		if network.Type != "bridge" {
+			return BadRequest(fmt.Errorf("Unsupported type for network %s: %s", network.Name, network.Type))
+		}
+	}
+
+	for _, profile := range req.Profiles {
+		if profile.Name == "" {
+			return BadRequest(fmt.Errorf("No profile name provided"))
+		}
+
+		err := containerValidConfig(profile.Config, true)
+		if err != nil {
+			return BadRequest(err)
+		}
+
+		err = containerValidDevices(profile.Devices)
+		if err != nil {
+			return BadRequest(err)
+		}
+	}
+
+	for _, alias := range req.Aliases {
+		if alias.Name == "" || alias.Target == "" {
+			return BadRequest(fmt.Errorf("name and target are required for image aliases"))
+		}
+	}
+
+	changes := []string{}
+
+	// Server config, the storage backend being set there
+	keys := []string{}
+	for key := range req.Config {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		value := req.Config[key]
+		current, err := d.ConfigValueGet(key)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		// Only the hash of the password is stored
+		if key == "core.trust_password" && value != "" {
+			if d.PasswordCheck(value) {
+				continue
+			}
+		} else if current == value {
+			continue
+		}
+
+		resp := api10ConfigSet(d, key, value)
+		if resp != nil {
+			return resp
+		}
+
+		changes = append(changes, fmt.Sprintf("config %s", key))
+	}
+
+	// Networks
+	for _, network := range req.Networks {
+		if shared.PathExists(filepath.Join("/sys/class/net", network.Name)) {
+			if !shared.PathExists(filepath.Join("/sys/class/net", network.Name, "bridge")) {
+				return BadRequest(fmt.Errorf("%s isn't a bridge", network.Name))
+			}
+
+			continue
+		}
+
+		output, err := exec.Command("ip", "link", "add", "dev", network.Name, "type", "bridge").CombinedOutput()
+		if err != nil {
+			return InternalError(fmt.Errorf("Failed to create the bridge %s: %s", network.Name, output))
+		}
+
+		output, err = exec.Command("ip", "link", "set", "dev", network.Name, "up").CombinedOutput()
+		if err != nil {
+			return InternalError(fmt.Errorf("Failed to bring the bridge %s up: %s", network.Name, output))
+		}
+
+		changes = append(changes, fmt.Sprintf("network %s created", network.Name))
+	}
+
+	// Profiles
+	for _, profile := range req.Profiles {
+		if profile.Config == nil {
+			profile.Config = map[string]string{}
+		}
+
+		if profile.Devices == nil {
+			profile.Devices = shared.Devices{}
+		}
+
+		current, err := doProfileGet(d, profile.Name)
+		if err == NoSuchObjectError {
+			_, err = dbProfileCreate(d.db, profile.Name, profile.Config, profile.Devices)
+			if err != nil {
+				return SmartError(err)
+			}
+
+			changes = append(changes, fmt.Sprintf("profile %s created", profile.Name))
+			continue
+		} else if err != nil {
+			return SmartError(err)
+		}
+
+		if current.Config == nil {
+			current.Config = map[string]string{}
+		}
+
+		if current.Devices == nil {
+			current.Devices = shared.Devices{}
+		}
+
+		if reflect.DeepEqual(current.Config, profile.Config) && reflect.DeepEqual(current.Devices, profile.Devices) {
+			continue
+		}
+
+		resp := doProfileUpdate(d, profile.Name, profilesPostReq{Name: profile.Name, Config: profile.Config, Devices: profile.Devices})
+		if resp != EmptySyncResponse {
+			return resp
+		}
+
+		changes = append(changes, fmt.Sprintf("profile %s updated", profile.Name))
+	}
+
+	// Image aliases, to images which have to be there already
+	for _, alias := range req.Aliases {
+		if alias.Description == "" {
+			alias.Description = alias.Name
+		}
+
+		image, err := dbImageGet(d.db, alias.Target, false, false)
+		if err != nil {
+			return SmartError(err)
+		}
+
+		current, err := doAliasGet(d, alias.Name, true)
+		if err == sql.ErrNoRows {
+			err = dbImageAliasAdd(d.db, alias.Name, image.Id, alias.Description)
+			if err != nil {
+				return InternalError(err)
+			}
+
+			changes = append(changes, fmt.Sprintf("alias %s created", alias.Name))
+			continue
+		} else if err != nil {
+			return InternalError(err)
+		}
+
+		if current.Name == image.Fingerprint && current.Description == alias.Description {
+			continue
+		}
+
+		err = dbImageAliasUpdate(d.db, alias.Name, image.Id, alias.Description)
+		if err != nil {
+			return InternalError(err)
+		}
+
+		changes = append(changes, fmt.Sprintf("alias %s updated", alias.Name))
+	}
+
+	return SyncResponse(true, shared.PreseedResult{Changes: changes})
+}
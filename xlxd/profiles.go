@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
@@ -26,6 +27,10 @@ func profilesGet(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
+	if d.isNamesOnlyRequest(r) {
+		return SyncResponse(true, results)
+	}
+
 	recursion := d.isRecursionRequest(r)
 
 	resultString := make([]string, len(results))
@@ -50,7 +55,12 @@ func profilesGet(d *Daemon, r *http.Request) Response {
 		return SyncResponse(true, resultString)
 	}
 
-	return SyncResponse(true, resultMap)
+	result, err := fieldsFilter(r, resultMap, nil)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, result)
 }
 
 func profilesPost(d *Daemon, r *http.Request) Response {
@@ -100,10 +110,26 @@ func doProfileGet(d *Daemon, name string) (*shared.ProfileConfig, error) {
 		return nil, err
 	}
 
+	containers, err := dbProfileContainersGet(d.db, name)
+	if err != nil {
+		return nil, err
+	}
+
+	usedBy := []string{}
+	for _, ct := range containers {
+		fields := strings.SplitN(ct, shared.SnapshotDelimiter, 2)
+		if len(fields) == 2 {
+			usedBy = append(usedBy, fmt.Sprintf("/%s/containers/%s/snapshots/%s", shared.APIVersion, fields[0], fields[1]))
+		} else {
+			usedBy = append(usedBy, fmt.Sprintf("/%s/containers/%s", shared.APIVersion, ct))
+		}
+	}
+
 	return &shared.ProfileConfig{
 		Name:    name,
 		Config:  config,
 		Devices: devices,
+		UsedBy:  usedBy,
 	}, nil
 }
 
@@ -115,7 +141,8 @@ func profileGet(d *Daemon, r *http.Request) Response {
 		return SmartError(err)
 	}
 
-	return SyncResponse(true, resp)
+	etag := []interface{}{resp.Config, resp.Devices}
+	return SyncResponseETag(true, resp, etag)
 }
 
 func getRunningContainersWithProfile(d *Daemon, profile string) []container {
@@ -140,13 +167,24 @@ func getRunningContainersWithProfile(d *Daemon, profile string) []container {
 func profilePut(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
 
+	// Validate the ETag
+	profile, err := doProfileGet(d, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	err = etagCheck(r, []interface{}{profile.Config, profile.Devices})
+	if err != nil {
+		return PreconditionFailed(err)
+	}
+
 	req := profilesPostReq{}
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		return BadRequest(err)
 	}
 
 	// Sanity checks
-	err := containerValidConfig(req.Config, true)
+	err = containerValidConfig(req.Config, true)
 	if err != nil {
 		return BadRequest(err)
 	}
@@ -156,6 +194,12 @@ func profilePut(d *Daemon, r *http.Request) Response {
 		return BadRequest(err)
 	}
 
+	return doProfileUpdate(d, name, req)
+}
+
+// doProfileUpdate replaces the config and devices of a validated profile and
+// applies them to the running containers using it.
+func doProfileUpdate(d *Daemon, name string, req profilesPostReq) Response {
 	// Update the database
 	id, err := dbProfileID(d.db, name)
 	if err != nil {
@@ -215,12 +259,49 @@ func profilePut(d *Daemon, r *http.Request) Response {
 // The handler for the delete operation.
 func profileDelete(d *Daemon, r *http.Request) Response {
 	name := mux.Vars(r)["name"]
-	err := dbProfileDelete(d.db, name)
 
+	// The containers using it would silently lose its config
+	usedBy, err := dbProfileContainersGet(d.db, name)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	if len(usedBy) > 0 && !shared.IsTrue(r.FormValue("force")) {
+		return BadRequest(fmt.Errorf("Profile is in use by: %s", strings.Join(usedBy, ", ")))
+	}
+
+	clist := getRunningContainersWithProfile(d, name)
+
+	err = dbProfileDelete(d.db, name)
 	if err != nil {
 		return InternalError(err)
 	}
 
+	// Drop it from the running containers
+	for _, c := range clist {
+		if !c.IsRunning() {
+			continue
+		}
+
+		profiles := []string{}
+		for _, profile := range c.Profiles() {
+			if profile != name {
+				profiles = append(profiles, profile)
+			}
+		}
+
+		err = c.Update(containerArgs{
+			Architecture: c.Architecture(),
+			Ephemeral:    c.IsEphemeral(),
+			Config:       c.LocalConfig(),
+			Devices:      c.LocalDevices(),
+			Profiles:     profiles}, true)
+
+		if err != nil {
+			return SmartError(fmt.Errorf("Failed to update container '%s': %s", c.Name(), err))
+		}
+	}
+
 	return EmptySyncResponse
 }
 
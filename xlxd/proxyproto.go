@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// How long a trusted proxy gets to send the PROXY protocol header.
+const proxyHeaderTimeout = 10 * time.Second
+
+// The PROXY protocol v2 signature.
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// proxyTrustedParse parses core.https_trusted_proxy, a comma separated list
+// of addresses or subnets of the proxies allowed to send a PROXY header.
+func proxyTrustedParse(value string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid proxy address: %s", entry)
+			}
+
+			if ip.To4() != nil {
+				entry = fmt.Sprintf("%s/32", entry)
+			} else {
+				entry = fmt.Sprintf("%s/128", entry)
+			}
+		}
+
+		_, subnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid proxy subnet: %s", entry)
+		}
+
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets, nil
+}
+
+// proxyTrusted returns whether connections from this address are expected to
+// start with a PROXY protocol header.
+func (d *Daemon) proxyTrusted(ip net.IP) bool {
+	value, err := d.ConfigValueGet("core.https_trusted_proxy")
+	if err != nil || value == "" {
+		return false
+	}
+
+	subnets, err := proxyTrustedParse(value)
+	if err != nil {
+		return false
+	}
+
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// proxyListener hands out connections which report the client address sent
+// by a trusted proxy (HAProxy and friends) through the PROXY protocol.
+type proxyListener struct {
+	net.Listener
+	trusted func(ip net.IP) bool
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok || !l.trusted(addr.IP) {
+		return conn, nil
+	}
+
+	return &proxyConn{Conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+// proxyConn reads the PROXY header on first use, so a slow proxy doesn't
+// hold up the accept loop.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+
+	once       sync.Once
+	remoteAddr net.Addr
+	err        error
+}
+
+func (c *proxyConn) readHeader() {
+	c.once.Do(func() {
+		c.Conn.SetReadDeadline(time.Now().Add(proxyHeaderTimeout))
+		c.remoteAddr, c.err = proxyHeaderRead(c.reader)
+		c.Conn.SetReadDeadline(time.Time{})
+
+		if c.err != nil {
+			shared.Log.Warn("Invalid PROXY protocol header", log.Ctx{"proxy": c.Conn.RemoteAddr(), "err": c.err})
+		}
+	})
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	c.readHeader()
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	return c.reader.Read(b)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	c.readHeader()
+	if c.remoteAddr == nil {
+		return c.Conn.RemoteAddr()
+	}
+
+	return c.remoteAddr
+}
+
+// proxyHeaderRead consumes a PROXY protocol (v1 or v2) header and returns the
+// client address it carries, nil if the proxy didn't pass one on (health
+// checks and such).
+func proxyHeaderRead(r *bufio.Reader) (net.Addr, error) {
+	sig, err := r.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.Equal(sig, proxyV2Signature) {
+		return proxyHeaderReadV2(r)
+	}
+
+	if bytes.HasPrefix(sig, []byte("PROXY ")) {
+		return proxyHeaderReadV1(r)
+	}
+
+	return nil, fmt.Errorf("Missing PROXY protocol header")
+}
+
+func proxyHeaderReadV1(r *bufio.Reader) (net.Addr, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+
+	// The specification caps the line at 107 bytes
+	if len(line) > 107 || !strings.HasSuffix(line, "\r\n") {
+		return nil, fmt.Errorf("Invalid PROXY v1 header")
+	}
+
+	fields := strings.Split(strings.TrimSuffix(line, "\r\n"), " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return nil, nil
+	}
+
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return nil, fmt.Errorf("Invalid PROXY v1 header")
+	}
+
+	ip := net.ParseIP(fields[2])
+	if ip == nil {
+		return nil, fmt.Errorf("Invalid PROXY v1 source address: %s", fields[2])
+	}
+
+	port, err := strconv.ParseUint(fields[4], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid PROXY v1 source port: %s", fields[4])
+	}
+
+	return &net.TCPAddr{IP: ip, Port: int(port)}, nil
+}
+
+func proxyHeaderReadV2(r *bufio.Reader) (net.Addr, error) {
+	header := make([]byte, 16)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return nil, err
+	}
+
+	if header[12]>>4 != 2 {
+		return nil, fmt.Errorf("Unsupported PROXY protocol version: %d", header[12]>>4)
+	}
+
+	body := make([]byte, binary.BigEndian.Uint16(header[14:16]))
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// LOCAL connections come from the proxy itself
+	if header[12]&0x0F == 0 {
+		return nil, nil
+	}
+
+	switch header[13] {
+	case 0x11: // TCP over IPv4
+		if len(body) < 12 {
+			return nil, fmt.Errorf("Truncated PROXY v2 header")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:4]), Port: int(binary.BigEndian.Uint16(body[8:10]))}, nil
+	case 0x21: // TCP over IPv6
+		if len(body) < 36 {
+			return nil, fmt.Errorf("Truncated PROXY v2 header")
+		}
+
+		return &net.TCPAddr{IP: net.IP(body[0:16]), Port: int(binary.BigEndian.Uint16(body[32:34]))}, nil
+	}
+
+	return nil, nil
+}
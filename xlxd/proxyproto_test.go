@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestProxyHeaderReadV1(t *testing.T) {
+	r := bufio.NewReader(bytes.NewBufferString("PROXY TCP6 2001:db8::1 2001:db8::2 51234 8443\r\nhello"))
+	addr, err := proxyHeaderRead(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr.String() != "[2001:db8::1]:51234" {
+		t.Errorf("Got %s instead of [2001:db8::1]:51234", addr)
+	}
+
+	rest, _ := r.ReadString('\n')
+	if rest != "hello" {
+		t.Errorf("The connection data was mangled: %q", rest)
+	}
+
+	addr, err = proxyHeaderRead(bufio.NewReader(bytes.NewBufferString("PROXY UNKNOWN\r\nhello")))
+	if err != nil || addr != nil {
+		t.Errorf("UNKNOWN header gave %v, %v", addr, err)
+	}
+}
+
+func TestProxyHeaderReadV2(t *testing.T) {
+	header := append([]byte{}, proxyV2Signature...)
+	header = append(header, 0x21, 0x11, 0, 12)
+	header = append(header, 192, 0, 2, 1, 192, 0, 2, 2, 0xc8, 0x22, 0x20, 0xfb)
+	header = append(header, []byte("hello")...)
+
+	r := bufio.NewReader(bytes.NewBuffer(header))
+	addr, err := proxyHeaderRead(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr.String() != "192.0.2.1:51234" {
+		t.Errorf("Got %s instead of 192.0.2.1:51234", addr)
+	}
+
+	rest, _ := r.ReadString('\n')
+	if rest != "hello" {
+		t.Errorf("The connection data was mangled: %q", rest)
+	}
+}
+
+func TestProxyHeaderReadMissing(t *testing.T) {
+	_, err := proxyHeaderRead(bufio.NewReader(bytes.NewBufferString("GET / HTTP/1.1\r\n\r\n")))
+	if err == nil {
+		t.Error("A connection without a PROXY header was accepted")
+	}
+}
+
+func TestProxyTrustedParse(t *testing.T) {
+	subnets, err := proxyTrustedParse("192.0.2.1, 2001:db8::/64")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subnets) != 2 || !subnets[0].Contains(net.ParseIP("192.0.2.1")) || subnets[0].Contains(net.ParseIP("192.0.2.2")) || !subnets[1].Contains(net.ParseIP("2001:db8::5")) {
+		t.Errorf("Unexpected subnets: %v", subnets)
+	}
+
+	_, err = proxyTrustedParse("proxy.example.com")
+	if err == nil {
+		t.Error("A hostname was accepted")
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	tests := map[string]string{
+		"0.0.0.0:8443":     "tcp4",
+		"[::]:8443":        "tcp",
+		":8443":            "tcp",
+		"127.0.0.1:8443":   "tcp4",
+		"[2001:db8::1]:80": "tcp6",
+	}
+
+	for address, network := range tests {
+		if listenNetwork(address) != network {
+			t.Errorf("Got %s instead of %s for %s", listenNetwork(address), network, address)
+		}
+	}
+}
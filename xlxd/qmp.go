@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// qmpTimeout bounds each exchange with qemu, a guest agent which isn't
+// running would otherwise never answer.
+const qmpTimeout = 5 * time.Second
+
+// qmpMonitor is a connection to one of the JSON sockets of a qemu process:
+// the QMP monitor or the guest agent, they speak the same protocol.
+type qmpMonitor struct {
+	conn    net.Conn
+	decoder *json.Decoder
+	timeout time.Duration
+}
+
+type qmpResponse struct {
+	Return json.RawMessage `json:"return"`
+	Error  *struct {
+		Class string `json:"class"`
+		Desc  string `json:"desc"`
+	} `json:"error"`
+	Event string          `json:"event"`
+	QMP   json.RawMessage `json:"QMP"`
+}
+
+// qmpConnect connects to the QMP monitor of a qemu process and negotiates
+// the capabilities.
+func qmpConnect(path string) (*qmpMonitor, error) {
+	m, err := qmpDial(path, qmpTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	// qemu greets first
+	resp := qmpResponse{}
+	m.conn.SetDeadline(time.Now().Add(m.timeout))
+	err = m.decoder.Decode(&resp)
+	if err != nil || resp.QMP == nil {
+		m.Close()
+		return nil, fmt.Errorf("Unexpected greeting from the qemu monitor: %v", err)
+	}
+
+	err = m.Run("qmp_capabilities", nil, nil)
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// qmpAgentConnect connects to the guest agent of a virtual machine, syncing
+// the stream so that answers left over by a previous client are skipped.
+func qmpAgentConnect(path string, timeout time.Duration) (*qmpMonitor, error) {
+	m, err := qmpDial(path, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	id := rand.Int63n(1 << 31)
+	err = m.send("guest-sync", map[string]interface{}{"id": id})
+	if err != nil {
+		m.Close()
+		return nil, err
+	}
+
+	for {
+		resp, err := m.receive()
+		if err != nil {
+			m.Close()
+			return nil, fmt.Errorf("The guest agent isn't answering: %s", err)
+		}
+
+		var value int64
+		if json.Unmarshal(resp.Return, &value) == nil && value == id {
+			return m, nil
+		}
+	}
+}
+
+func qmpDial(path string, timeout time.Duration) (*qmpMonitor, error) {
+	conn, err := net.DialTimeout("unix", path, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	return &qmpMonitor{conn: conn, decoder: json.NewDecoder(conn), timeout: timeout}, nil
+}
+
+func (m *qmpMonitor) Close() error {
+	return m.conn.Close()
+}
+
+func (m *qmpMonitor) send(command string, args interface{}) error {
+	request := map[string]interface{}{"execute": command}
+	if args != nil {
+		request["arguments"] = args
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	m.conn.SetDeadline(time.Now().Add(m.timeout))
+	_, err = m.conn.Write(data)
+	return err
+}
+
+// receive returns the next answer, skipping the asynchronous events.
+func (m *qmpMonitor) receive() (*qmpResponse, error) {
+	for {
+		resp := qmpResponse{}
+		err := m.decoder.Decode(&resp)
+		if err != nil {
+			return nil, err
+		}
+
+		if resp.Event == "" {
+			return &resp, nil
+		}
+	}
+}
+
+// Run runs a command, decoding what it returns into result unless nil.
+func (m *qmpMonitor) Run(command string, args interface{}, result interface{}) error {
+	err := m.send(command, args)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.receive()
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return fmt.Errorf("%s failed: %s", command, resp.Error.Desc)
+	}
+
+	if result == nil || resp.Return == nil {
+		return nil
+	}
+
+	return json.Unmarshal(resp.Return, result)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// recoverVolume is a container found on the storage backend which the
+// database doesn't know about, along with its unknown snapshots.
+type recoverVolume struct {
+	Name      string   `json:"name"`
+	Snapshots []string `json:"snapshots"`
+}
+
+type recoverReq struct {
+	Names []string `json:"names"`
+}
+
+type recoverResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error"`
+}
+
+// recoverScan compares what's on the storage backend with the database.
+// Snapshots of known containers are reported under their container too.
+func recoverScan(d *Daemon) ([]recoverVolume, error) {
+	names, err := d.Storage.ContainerList()
+	if err != nil {
+		return nil, err
+	}
+
+	known := []string{}
+	for _, cType := range []containerType{cTypeRegular, cTypeSnapshot} {
+		list, err := dbContainersList(d.db, cType)
+		if err != nil {
+			return nil, err
+		}
+
+		known = append(known, list...)
+	}
+
+	volumes := []recoverVolume{}
+	index := map[string]int{}
+	for _, name := range names {
+		if shared.StringInSlice(name, known) {
+			continue
+		}
+
+		fields := strings.SplitN(name, shared.SnapshotDelimiter, 2)
+		i, ok := index[fields[0]]
+		if !ok {
+			i = len(volumes)
+			index[fields[0]] = i
+			volumes = append(volumes, recoverVolume{Name: fields[0], Snapshots: []string{}})
+		}
+
+		if len(fields) == 2 {
+			volumes[i].Snapshots = append(volumes[i].Snapshots, fields[1])
+		}
+	}
+
+	return volumes, nil
+}
+
+// recoverInspect looks at the content of a volume for what the database
+// used to record: the architecture and the map the rootfs is shifted to.
+func recoverInspect(d *Daemon, name string, cType containerType) (containerArgs, error) {
+	args := containerArgs{
+		Architecture: d.architectures[0],
+		Config:       map[string]string{},
+		Ctype:        cType,
+		Name:         name,
+		Profiles:     []string{"default"},
+	}
+
+	// Just enough of a container to get at its storage
+	c := &containerLXC{daemon: d, name: name, cType: cType, storage: d.Storage}
+	err := c.StorageStart()
+	if err != nil {
+		return args, err
+	}
+	defer c.StorageStop()
+
+	content, err := ioutil.ReadFile(filepath.Join(c.Path(), "metadata.yaml"))
+	if err == nil {
+		metadata := imageMetadata{}
+		err = yaml.Unmarshal(content, &metadata)
+		if err == nil && metadata.Architecture != "" {
+			arch, err := shared.ArchitectureId(metadata.Architecture)
+			if err == nil {
+				args.Architecture = arch
+			}
+		}
+	}
+
+	fi, err := os.Stat(c.RootfsPath())
+	if err != nil {
+		return args, err
+	}
+
+	uid := int(fi.Sys().(*syscall.Stat_t).Uid)
+	if uid == 0 {
+		args.Config["security.privileged"] = "true"
+		args.Config["volatile.last_state.idmap"] = "[]"
+		return args, nil
+	}
+
+	// Have the rootfs shifted on start if it isn't on our map anymore
+	size := 65536
+	if d.IdmapSet != nil && len(d.IdmapSet.Idmap) > 0 {
+		size = d.IdmapSet.Idmap[0].Maprange
+	}
+
+	idmap, err := json.Marshal([]shared.IdmapEntry{{Isuid: true, Isgid: true, Hostid: uid, Nsid: 0, Maprange: size}})
+	if err != nil {
+		return args, err
+	}
+	args.Config["volatile.last_state.idmap"] = string(idmap)
+
+	return args, nil
+}
+
+// recoverContainer re-creates the database record of a volume. Nothing is
+// ever done to the volume itself, a failed recovery only leaves it unknown.
+func recoverContainer(d *Daemon, name string, cType containerType) error {
+	args, err := recoverInspect(d, name, cType)
+	if err != nil {
+		return err
+	}
+
+	_, err = dbContainerCreate(d.db, args)
+	if err != nil {
+		return err
+	}
+
+	_, err = containerLoadByName(d, name)
+	if err != nil {
+		dbContainerRemove(d.db, name)
+		return err
+	}
+
+	return nil
+}
+
+func internalRecoverGet(d *Daemon, r *http.Request) Response {
+	volumes, err := recoverScan(d)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	return SyncResponse(true, volumes)
+}
+
+func internalRecoverPost(d *Daemon, r *http.Request) Response {
+	req := recoverReq{}
+	if err := shared.ReadToJSON(r.Body, &req); err != nil {
+		return BadRequest(err)
+	}
+
+	volumes, err := recoverScan(d)
+	if err != nil {
+		return SmartError(err)
+	}
+
+	results := []recoverResult{}
+	for _, volume := range volumes {
+		if !shared.StringInSlice(volume.Name, req.Names) {
+			continue
+		}
+
+		// The container may just be missing some snapshots
+		_, err := dbContainerId(d.db, volume.Name)
+		if err != nil {
+			err = recoverContainer(d, volume.Name, cTypeRegular)
+			if err != nil {
+				results = append(results, recoverResult{Name: volume.Name, Error: err.Error()})
+				continue
+			}
+		}
+
+		results = append(results, recoverResult{Name: volume.Name})
+
+		for _, snapshot := range volume.Snapshots {
+			name := volume.Name + shared.SnapshotDelimiter + snapshot
+			result := recoverResult{Name: name}
+			err := recoverContainer(d, name, cTypeSnapshot)
+			if err != nil {
+				result.Error = err.Error()
+			}
+
+			results = append(results, result)
+		}
+	}
+
+	return SyncResponse(true, results)
+}
+
+var internalRecoverCmd = Command{name: "recover", get: internalRecoverGet, post: internalRecoverPost}
@@ -0,0 +1,547 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+var resourcesCmd = Command{name: "resources", get: resourcesGet}
+
+func resourcesGet(d *Daemon, r *http.Request) Response {
+	res, err := doResourcesGet()
+	if err != nil {
+		return InternalError(err)
+	}
+
+	err = resourcesNodeContainers(d, &res)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, res)
+}
+
+func doResourcesGet() (shared.Resources, error) {
+	res := shared.Resources{}
+
+	cpu, err := resourcesGetCPU()
+	if err != nil {
+		return res, err
+	}
+	res.CPU = cpu
+
+	mem, err := resourcesGetMemory()
+	if err != nil {
+		return res, err
+	}
+	res.Memory = mem
+
+	// The following are best effort, missing entries just mean the
+	// hardware isn't there (or isn't exposed to us).
+	res.Disks = resourcesGetDisks()
+	res.Network = resourcesGetNetwork()
+	res.GPUs = resourcesGetGPUs()
+
+	return res, nil
+}
+
+func readSysString(p string) string {
+	content, err := ioutil.ReadFile(p)
+	if err != nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(content))
+}
+
+func readSysInt(p string) (int64, error) {
+	return strconv.ParseInt(readSysString(p), 10, 64)
+}
+
+// parseCPUList parses a kernel cpu list (e.g. "0-3,8,10-11").
+func parseCPUList(list string) ([]int, error) {
+	cpus := []int{}
+
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return cpus, nil
+	}
+
+	for _, chunk := range strings.Split(list, ",") {
+		fields := strings.SplitN(chunk, "-", 2)
+
+		start, err := strconv.Atoi(fields[0])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid cpu list: %s", list)
+		}
+
+		end := start
+		if len(fields) == 2 {
+			end, err = strconv.Atoi(fields[1])
+			if err != nil || end < start {
+				return nil, fmt.Errorf("Invalid cpu list: %s", list)
+			}
+		}
+
+		for i := start; i <= end; i++ {
+			cpus = append(cpus, i)
+		}
+	}
+
+	return cpus, nil
+}
+
+type procCPUInfo struct {
+	vendor    string
+	name      string
+	frequency int64
+}
+
+// parseProcCPUInfo extracts the per-thread vendor and model from /proc/cpuinfo.
+func parseProcCPUInfo() (map[int]procCPUInfo, error) {
+	f, err := os.Open("/proc/cpuinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info := map[int]procCPUInfo{}
+	thread := -1
+	cur := procCPUInfo{}
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), ":", 2)
+		if len(fields) != 2 {
+			continue
+		}
+
+		key := strings.TrimSpace(fields[0])
+		value := strings.TrimSpace(fields[1])
+
+		switch key {
+		case "processor":
+			if thread != -1 {
+				info[thread] = cur
+			}
+
+			thread, err = strconv.Atoi(value)
+			if err != nil {
+				thread = -1
+			}
+			cur = procCPUInfo{}
+		case "vendor_id":
+			cur.vendor = value
+		case "model name":
+			cur.name = value
+		case "cpu MHz":
+			mhz, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				cur.frequency = int64(mhz)
+			}
+		}
+	}
+
+	if thread != -1 {
+		info[thread] = cur
+	}
+
+	return info, scanner.Err()
+}
+
+func resourcesGetCPU() (shared.ResourcesCPU, error) {
+	res := shared.ResourcesCPU{Sockets: []shared.ResourcesCPUSocket{}}
+
+	cpuinfo, err := parseProcCPUInfo()
+	if err != nil {
+		return res, err
+	}
+
+	dents, err := ioutil.ReadDir("/sys/bus/cpu/devices/")
+	if err != nil {
+		return res, err
+	}
+
+	sockets := map[int]*shared.ResourcesCPUSocket{}
+	cores := map[int]map[int]*shared.ResourcesCPUCore{}
+
+	for _, f := range dents {
+		id := -1
+		count, err := fmt.Sscanf(f.Name(), "cpu%d", &id)
+		if count != 1 || id == -1 {
+			continue
+		}
+
+		cpuPath := path.Join("/sys/bus/cpu/devices", f.Name())
+
+		// CPUs without an online file are non-hotplug so are always online
+		if shared.PathExists(path.Join(cpuPath, "online")) && readSysString(path.Join(cpuPath, "online")) == "0" {
+			continue
+		}
+
+		socketID, err := readSysInt(path.Join(cpuPath, "topology", "physical_package_id"))
+		if err != nil {
+			socketID = 0
+		}
+
+		coreID, err := readSysInt(path.Join(cpuPath, "topology", "core_id"))
+		if err != nil {
+			coreID = int64(id)
+		}
+
+		node := 0
+		entries, _ := shared.ReadDir(cpuPath)
+		for _, entry := range entries {
+			if n, err := fmt.Sscanf(entry, "node%d", &node); n == 1 && err == nil {
+				break
+			}
+		}
+
+		socket, ok := sockets[int(socketID)]
+		if !ok {
+			socket = &shared.ResourcesCPUSocket{Socket: int(socketID)}
+			sockets[int(socketID)] = socket
+			cores[int(socketID)] = map[int]*shared.ResourcesCPUCore{}
+
+			info := cpuinfo[id]
+			socket.Vendor = info.vendor
+			socket.Name = info.name
+			socket.Frequency = info.frequency
+
+			// Prefer the maximum frequency (in kHz) if cpufreq is available
+			maxFreq, err := readSysInt(path.Join(cpuPath, "cpufreq", "cpuinfo_max_freq"))
+			if err == nil {
+				socket.Frequency = maxFreq / 1000
+			}
+		}
+
+		core, ok := cores[int(socketID)][int(coreID)]
+		if !ok {
+			core = &shared.ResourcesCPUCore{Core: int(coreID), NUMANode: node, Threads: []int{}}
+			cores[int(socketID)][int(coreID)] = core
+		}
+
+		core.Threads = append(core.Threads, id)
+		res.Total++
+	}
+
+	socketIDs := []int{}
+	for id := range sockets {
+		socketIDs = append(socketIDs, id)
+	}
+	sort.Ints(socketIDs)
+
+	for _, socketID := range socketIDs {
+		socket := sockets[socketID]
+		socket.Cores = []shared.ResourcesCPUCore{}
+
+		coreIDs := []int{}
+		for id := range cores[socketID] {
+			coreIDs = append(coreIDs, id)
+		}
+		sort.Ints(coreIDs)
+
+		for _, coreID := range coreIDs {
+			core := cores[socketID][coreID]
+			sort.Ints(core.Threads)
+			socket.Cores = append(socket.Cores, *core)
+		}
+
+		res.Sockets = append(res.Sockets, *socket)
+	}
+
+	return res, nil
+}
+
+// parseMeminfo returns the values (in bytes) of a meminfo style file, the
+// NUMA node files prefix each line with "Node <id>".
+func parseMeminfo(p string) (map[string]uint64, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 4 && fields[0] == "Node" {
+			fields = fields[2:]
+		}
+
+		if len(fields) < 2 {
+			continue
+		}
+
+		value, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		if len(fields) == 3 && fields[2] == "kB" {
+			value *= 1024
+		}
+
+		values[strings.TrimSuffix(fields[0], ":")] = value
+	}
+
+	return values, scanner.Err()
+}
+
+func resourcesGetMemory() (shared.ResourcesMemory, error) {
+	res := shared.ResourcesMemory{Nodes: []shared.ResourcesMemoryNode{}}
+
+	meminfo, err := parseMeminfo("/proc/meminfo")
+	if err != nil {
+		return res, err
+	}
+
+	res.Total = meminfo["MemTotal"]
+	available, ok := meminfo["MemAvailable"]
+	if !ok {
+		available = meminfo["MemFree"] + meminfo["Buffers"] + meminfo["Cached"]
+	}
+	res.Used = res.Total - available
+
+	// Systems without NUMA support don't have this
+	dents, err := ioutil.ReadDir("/sys/devices/system/node/")
+	if err != nil {
+		return res, nil
+	}
+
+	for _, f := range dents {
+		id := -1
+		count, err := fmt.Sscanf(f.Name(), "node%d", &id)
+		if count != 1 || id == -1 {
+			continue
+		}
+
+		nodePath := path.Join("/sys/devices/system/node", f.Name())
+		nodeinfo, err := parseMeminfo(path.Join(nodePath, "meminfo"))
+		if err != nil {
+			return res, err
+		}
+
+		cpus, err := parseCPUList(readSysString(path.Join(nodePath, "cpulist")))
+		if err != nil {
+			return res, err
+		}
+
+		res.Nodes = append(res.Nodes, shared.ResourcesMemoryNode{
+			Node:       id,
+			CPUs:       cpus,
+			Total:      nodeinfo["MemTotal"],
+			Used:       nodeinfo["MemTotal"] - nodeinfo["MemFree"],
+			Free:       nodeinfo["MemFree"],
+			Containers: []string{}})
+	}
+
+	return res, nil
+}
+
+// resourcesNodeContainers lists on each NUMA node the containers which
+// limits.cpu.nodes pins to it.
+func resourcesNodeContainers(d *Daemon, res *shared.Resources) error {
+	names, err := dbContainersList(d.db, cTypeRegular)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		c, err := containerLoadByName(d, name)
+		if err != nil {
+			continue
+		}
+
+		nodes, err := parseCPUList(c.ExpandedConfig()["limits.cpu.nodes"])
+		if err != nil {
+			continue
+		}
+
+		for i := range res.Memory.Nodes {
+			if shared.IntInSlice(res.Memory.Nodes[i].Node, nodes) {
+				res.Memory.Nodes[i].Containers = append(res.Memory.Nodes[i].Containers, name)
+			}
+		}
+	}
+
+	return nil
+}
+
+// udevProperty looks up a property of a device in the udev database.
+func udevProperty(device string, key string) string {
+	f, err := os.Open(fmt.Sprintf("/run/udev/data/%s", device))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, fmt.Sprintf("E:%s=", key)) {
+			return strings.SplitN(line, "=", 2)[1]
+		}
+	}
+
+	return ""
+}
+
+func resourcesGetDisks() []shared.ResourcesDisk {
+	disks := []shared.ResourcesDisk{}
+
+	entries, err := shared.ReadDir("/sys/class/block/")
+	if err != nil {
+		return disks
+	}
+
+	for _, name := range entries {
+		blockPath := path.Join("/sys/class/block", name)
+
+		// Skip partitions and virtual devices (loop, dm, ...)
+		if shared.PathExists(path.Join(blockPath, "partition")) || !shared.PathExists(path.Join(blockPath, "device")) {
+			continue
+		}
+
+		disk := shared.ResourcesDisk{
+			Name:      name,
+			Device:    readSysString(path.Join(blockPath, "dev")),
+			Model:     readSysString(path.Join(blockPath, "device", "model")),
+			Serial:    readSysString(path.Join(blockPath, "device", "serial")),
+			Removable: readSysString(path.Join(blockPath, "removable")) == "1",
+		}
+
+		if disk.Serial == "" {
+			disk.Serial = udevProperty(fmt.Sprintf("b%s", disk.Device), "ID_SERIAL_SHORT")
+		}
+
+		if disk.Serial == "" {
+			disk.Serial = udevProperty(fmt.Sprintf("b%s", disk.Device), "ID_SERIAL")
+		}
+
+		if readSysString(path.Join(blockPath, "queue", "rotational")) == "1" {
+			disk.Type = "hdd"
+		} else {
+			disk.Type = "ssd"
+		}
+
+		// The size is always in 512 bytes sectors
+		sectors, err := readSysInt(path.Join(blockPath, "size"))
+		if err == nil {
+			disk.Size = uint64(sectors) * 512
+		}
+
+		disks = append(disks, disk)
+	}
+
+	return disks
+}
+
+// resourcesPCIDevice returns the sysfs path of the PCI device backing a
+// device (e.g. the PCI function of a virtio device), if any.
+func resourcesPCIDevice(devPath string) string {
+	devPath, err := filepath.EvalSymlinks(devPath)
+	if err != nil {
+		return ""
+	}
+
+	for strings.HasPrefix(devPath, "/sys/devices/") {
+		subsystem, err := os.Readlink(path.Join(devPath, "subsystem"))
+		if err == nil && filepath.Base(subsystem) == "pci" {
+			return devPath
+		}
+
+		devPath = filepath.Dir(devPath)
+	}
+
+	return ""
+}
+
+func resourcesGetNetwork() []shared.ResourcesNetworkCard {
+	cards := []shared.ResourcesNetworkCard{}
+
+	entries, err := shared.ReadDir("/sys/class/net/")
+	if err != nil {
+		return cards
+	}
+
+	for _, name := range entries {
+		netPath := path.Join("/sys/class/net", name)
+
+		// Only physical cards have a device
+		if !shared.PathExists(path.Join(netPath, "device")) {
+			continue
+		}
+
+		card := shared.ResourcesNetworkCard{
+			Name:    name,
+			Address: readSysString(path.Join(netPath, "address")),
+		}
+
+		driver, err := os.Readlink(path.Join(netPath, "device", "driver"))
+		if err == nil {
+			card.Driver = filepath.Base(driver)
+		}
+
+		pciPath := resourcesPCIDevice(path.Join(netPath, "device"))
+		if pciPath != "" {
+			card.PCIAddress = filepath.Base(pciPath)
+			card.VendorID = strings.TrimPrefix(readSysString(path.Join(pciPath, "vendor")), "0x")
+			card.ProductID = strings.TrimPrefix(readSysString(path.Join(pciPath, "device")), "0x")
+		}
+
+		// Reading the speed fails when the link is down
+		speed, err := readSysInt(path.Join(netPath, "speed"))
+		if err == nil && speed > 0 {
+			card.Speed = int(speed)
+		}
+
+		cards = append(cards, card)
+	}
+
+	return cards
+}
+
+func resourcesGetGPUs() []shared.ResourcesGPU {
+	gpus := []shared.ResourcesGPU{}
+
+	entries, err := shared.ReadDir("/sys/bus/pci/devices/")
+	if err != nil {
+		return gpus
+	}
+
+	for _, name := range entries {
+		devPath := path.Join("/sys/bus/pci/devices", name)
+
+		// PCI class 0x03 is for display controllers
+		if !strings.HasPrefix(readSysString(path.Join(devPath, "class")), "0x03") {
+			continue
+		}
+
+		gpu := shared.ResourcesGPU{
+			PCIAddress: name,
+			VendorID:   strings.TrimPrefix(readSysString(path.Join(devPath, "vendor")), "0x"),
+			ProductID:  strings.TrimPrefix(readSysString(path.Join(devPath, "device")), "0x"),
+		}
+
+		driver, err := os.Readlink(path.Join(devPath, "driver"))
+		if err == nil {
+			gpu.Driver = filepath.Base(driver)
+		}
+
+		gpus = append(gpus, gpu)
+	}
+
+	return gpus
+}
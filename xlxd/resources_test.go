@@ -0,0 +1,34 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := map[string][]int{
+		"":          {},
+		"0":         {0},
+		"0-3":       {0, 1, 2, 3},
+		"0-1,4,6-7": {0, 1, 4, 6, 7},
+	}
+
+	for input, expected := range tests {
+		cpus, err := parseCPUList(input)
+		if err != nil {
+			t.Errorf("%s: %s", input, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(cpus, expected) {
+			t.Errorf("%s: got %v expected %v", input, cpus, expected)
+		}
+	}
+
+	for _, input := range []string{"a", "3-1", "1-b"} {
+		_, err := parseCPUList(input)
+		if err == nil {
+			t.Errorf("%s: should have failed", input)
+		}
+	}
+}
@@ -22,6 +22,9 @@ type resp struct {
 	StatusCode shared.StatusCode `json:"status_code"`
 	Metadata   interface{}       `json:"metadata"`
 	Operation  string            `json:"operation"`
+
+	// The number of objects of a paginated listing, of all its pages
+	Total *int `json:"total,omitempty"`
 }
 
 type Response interface {
@@ -32,6 +35,8 @@ type Response interface {
 type syncResponse struct {
 	success  bool
 	metadata interface{}
+	etag     interface{}
+	total    *int
 }
 
 func (r *syncResponse) Render(w http.ResponseWriter) error {
@@ -40,15 +45,46 @@ func (r *syncResponse) Render(w http.ResponseWriter) error {
 		status = shared.Failure
 	}
 
-	resp := resp{Type: lxd.Sync, Status: status.String(), StatusCode: status, Metadata: r.metadata}
+	if r.etag != nil {
+		value, err := etagValue(r.etag, r.metadata)
+		if err == nil {
+			w.Header().Set("ETag", fmt.Sprintf("\"%s\"", value))
+		}
+	}
+
+	resp := resp{Type: lxd.Sync, Status: status.String(), StatusCode: status, Metadata: r.metadata, Total: r.total}
 	return WriteJSON(w, resp)
 }
 
 func SyncResponse(success bool, metadata interface{}) Response {
-	return &syncResponse{success, metadata}
+	return &syncResponse{success, metadata, nil, nil}
+}
+
+// SyncResponseETag is a sync response carrying an ETag computed from etag,
+// usually the subset of the object which can be modified.
+func SyncResponseETag(success bool, metadata interface{}, etag interface{}) Response {
+	return &syncResponse{success, metadata, etag, nil}
+}
+
+// SyncResponsePage is a sync response carrying a page of a listing, with the
+// number of objects in all the pages.
+func SyncResponsePage(metadata interface{}, total int) Response {
+	return &syncResponse{true, metadata, nil, &total}
+}
+
+var EmptySyncResponse = &syncResponse{true, make(map[string]interface{}), nil, nil}
+
+// Not modified response
+type notModifiedResponse struct {
+	etag string
 }
 
-var EmptySyncResponse = &syncResponse{true, make(map[string]interface{})}
+func (r *notModifiedResponse) Render(w http.ResponseWriter) error {
+	w.Header().Del("Content-Type")
+	w.Header().Set("ETag", fmt.Sprintf("\"%s\"", r.etag))
+	w.WriteHeader(http.StatusNotModified)
+	return nil
+}
 
 // File transfer response
 type fileResponseEntry struct {
@@ -100,32 +136,36 @@ func (r *fileResponse) Render(w http.ResponseWriter) error {
 		return nil
 	}
 
-	// Now the complex multipart answer
-	body := &bytes.Buffer{}
-	mw := multipart.NewWriter(body)
-
-	for _, entry := range r.files {
+	// Now the complex multipart answer, streamed rather than built in
+	// memory as images can be large. The files are all opened first so
+	// that a missing one is still reported as an error.
+	fds := make([]*os.File, len(r.files))
+	for i, entry := range r.files {
 		fd, err := os.Open(entry.path)
 		if err != nil {
 			return err
 		}
 		defer fd.Close()
 
+		fds[i] = fd
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", mw.FormDataContentType())
+
+	for i, entry := range r.files {
 		fw, err := mw.CreateFormFile(entry.identifier, entry.filename)
 		if err != nil {
 			return err
 		}
 
-		_, err = io.Copy(fw, fd)
+		_, err = io.Copy(fw, fds[i])
 		if err != nil {
 			return err
 		}
 	}
 
-	mw.Close()
-	w.Header().Set("Content-Type", mw.FormDataContentType())
-	_, err := io.Copy(w, body)
-	return err
+	return mw.Close()
 }
 
 func FileResponse(r *http.Request, files []fileResponseEntry, headers map[string]string, removeAfterServe bool) Response {
@@ -167,8 +207,9 @@ func OperationResponse(op *operation) Response {
 
 // Error response
 type errorResponse struct {
-	code int
-	msg  string
+	code     int
+	msg      string
+	metadata interface{}
 }
 
 func (r *errorResponse) Render(w http.ResponseWriter) error {
@@ -182,7 +223,12 @@ func (r *errorResponse) Render(w http.ResponseWriter) error {
 		output = io.MultiWriter(buf, captured)
 	}
 
-	err := json.NewEncoder(output).Encode(shared.Jmap{"type": lxd.Error, "error": r.msg, "error_code": r.code})
+	body := shared.Jmap{"type": lxd.Error, "error": r.msg, "error_code": r.code}
+	if r.metadata != nil {
+		body["metadata"] = r.metadata
+	}
+
+	err := json.NewEncoder(output).Encode(body)
 
 	if err != nil {
 		return err
@@ -196,23 +242,53 @@ func (r *errorResponse) Render(w http.ResponseWriter) error {
 }
 
 /* Some standard responses */
-var NotImplemented = &errorResponse{http.StatusNotImplemented, "not implemented"}
-var NotFound = &errorResponse{http.StatusNotFound, "not found"}
-var Forbidden = &errorResponse{http.StatusForbidden, "not authorized"}
-var Conflict = &errorResponse{http.StatusConflict, "already exists"}
+var NotImplemented = &errorResponse{http.StatusNotImplemented, "not implemented", nil}
+var NotFound = &errorResponse{http.StatusNotFound, "not found", nil}
+var Forbidden = &errorResponse{http.StatusForbidden, "not authorized", nil}
+var Conflict = &errorResponse{http.StatusConflict, "already exists", nil}
 
 func BadRequest(err error) Response {
-	return &errorResponse{http.StatusBadRequest, err.Error()}
+	return &errorResponse{http.StatusBadRequest, err.Error(), nil}
+}
+
+// BadRequestDetails is a bad request error with metadata telling what's wrong
+// with the request, for the clients to act on.
+func BadRequestDetails(err error, metadata interface{}) Response {
+	return &errorResponse{http.StatusBadRequest, err.Error(), metadata}
+}
+
+func PreconditionFailed(err error) Response {
+	return &errorResponse{http.StatusPreconditionFailed, err.Error(), nil}
+}
+
+func UnprocessableEntity(err error) Response {
+	return &errorResponse{http.StatusUnprocessableEntity, err.Error(), nil}
+}
+
+func RequestTimeout(err error) Response {
+	return &errorResponse{http.StatusRequestTimeout, err.Error(), nil}
 }
 
 func InternalError(err error) Response {
-	return &errorResponse{http.StatusInternalServerError, err.Error()}
+	return &errorResponse{http.StatusInternalServerError, err.Error(), nil}
 }
 
 /*
  * SmartError returns the right error message based on err.
  */
 func SmartError(err error) Response {
+	if nameErr, ok := err.(*containerNameError); ok {
+		return BadRequestDetails(nameErr, nameErr)
+	}
+
+	if policyErr, ok := err.(*imagePolicyError); ok {
+		return BadRequestDetails(policyErr, policyErr)
+	}
+
+	if capErr, ok := err.(*containerCapabilityError); ok {
+		return BadRequestDetails(capErr, capErr)
+	}
+
 	switch err {
 	case nil:
 		return EmptySyncResponse
@@ -222,6 +298,8 @@ func SmartError(err error) Response {
 		return NotFound
 	case NoSuchObjectError:
 		return NotFound
+	case DbErrAmbiguousFingerprint:
+		return BadRequest(err)
 	case os.ErrPermission:
 		return Forbidden
 	case DbErrAlreadyDefined:
@@ -51,7 +51,7 @@ func rsyncWebsocket(path string, cmd *exec.Cmd, conn *websocket.Conn) error {
 	return err
 }
 
-func rsyncSendSetup(path string) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
+func rsyncSendSetup(path string, delta bool) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
 	/*
 	 * It's sort of unfortunate, but there's no library call to get a
 	 * temporary name, so we get the file and close it and use its name.
@@ -94,16 +94,22 @@ func rsyncSendSetup(path string) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
 	 * hardcoding that at the other end, so we can just ignore it.
 	 */
 	rsyncCmd := fmt.Sprintf("sh -c \"nc -U %s\"", f.Name())
-	cmd := exec.Command(
-		"rsync",
-		"-arvP",
-		"--devices",
-		"--numeric-ids",
-		"--partial",
-		path,
-		"localhost:/tmp/foo",
-		"-e",
-		rsyncCmd)
+
+	/*
+	 * In delta mode, which both ends have to agree on, the transfer is
+	 * compressed and deletes what the source doesn't have, so that rsync
+	 * only sends a delta over whatever the sink already has (an image or a
+	 * previous snapshot) while still ending up with an exact copy. These
+	 * are done on the --server end too, so any change to the flags has to
+	 * be mirrored in rsyncRecvCmd.
+	 */
+	args := []string{"-arvP", "--devices", "--numeric-ids", "--partial"}
+	if delta {
+		args = append(args, "--compress", "--delete")
+	}
+	args = append(args, path, "localhost:/tmp/foo", "-e", rsyncCmd)
+
+	cmd := exec.Command("rsync", args...)
 
 	stderr, err := cmd.StderrPipe()
 	if err != nil {
@@ -124,9 +130,10 @@ func rsyncSendSetup(path string) (*exec.Cmd, net.Conn, io.ReadCloser, error) {
 }
 
 // RsyncSend sets up the sending half of an rsync, to recursively send the
-// directory pointed to by path over the websocket.
-func RsyncSend(path string, conn *websocket.Conn) error {
-	cmd, dataSocket, stderr, err := rsyncSendSetup(path)
+// directory pointed to by path over the websocket, in delta mode if the
+// receiving end agreed to it.
+func RsyncSend(path string, conn *websocket.Conn, delta bool) error {
+	cmd, dataSocket, stderr, err := rsyncSendSetup(path, delta)
 	if dataSocket != nil {
 		defer dataSocket.Close()
 	}
@@ -151,7 +158,19 @@ func RsyncSend(path string, conn *websocket.Conn) error {
 	return err
 }
 
-func rsyncRecvCmd(path string) *exec.Cmd {
+func rsyncRecvCmd(path string, delta bool) *exec.Cmd {
+	if delta {
+		return exec.Command("rsync",
+			"--server",
+			"-vlogDtprze.iLsfx",
+			"--numeric-ids",
+			"--devices",
+			"--partial",
+			"--delete",
+			".",
+			path)
+	}
+
 	return exec.Command("rsync",
 		"--server",
 		"-vlogDtpre.iLsfx",
@@ -165,6 +184,6 @@ func rsyncRecvCmd(path string) *exec.Cmd {
 // RsyncRecv sets up the receiving half of the websocket to rsync (the other
 // half set up by RsyncSend), putting the contents in the directory specified
 // by path.
-func RsyncRecv(path string, conn *websocket.Conn) error {
-	return rsyncWebsocket(path, rsyncRecvCmd(path), conn)
+func RsyncRecv(path string, conn *websocket.Conn, delta bool) error {
+	return rsyncWebsocket(path, rsyncRecvCmd(path, delta), conn)
 }
@@ -36,13 +36,21 @@ func TestRsyncSendRecv(t *testing.T) {
 	f.Write([]byte(helloWorld))
 	f.Close()
 
-	send, sendConn, _, err := rsyncSendSetup(shared.AddSlash(source))
+	/* and something the source doesn't have to the sink */
+	f, err = os.Create(path.Join(sink, "stale"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	f.Close()
+
+	send, sendConn, _, err := rsyncSendSetup(shared.AddSlash(source), true)
 	if err != nil {
 		t.Error(err)
 		return
 	}
 
-	recv := rsyncRecvCmd(sink)
+	recv := rsyncRecvCmd(sink, true)
 
 	recvOut, err := recv.StdoutPipe()
 	if err != nil {
@@ -103,4 +111,8 @@ func TestRsyncSendRecv(t *testing.T) {
 		t.Errorf("expected %s got %s", helloWorld, buf)
 		return
 	}
+
+	if shared.PathExists(path.Join(sink, "stale")) {
+		t.Errorf("stale file wasn't deleted from the sink")
+	}
 }
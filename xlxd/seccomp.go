@@ -1,9 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path"
+	"regexp"
+	"strings"
 
 	"github.com/krschwab/xlxd/shared"
 )
@@ -20,15 +23,144 @@ finit_module errno 1
 delete_module errno 1
 `
 
+// Only character and block devices get intercepted, fifos and sockets
+// don't need any privilege.
+const SECCOMP_NOTIFY_MKNOD = `mknod notify [1,8192,SCMP_CMP_MASKED_EQ,61440]
+mknod notify [1,24576,SCMP_CMP_MASKED_EQ,61440]
+mknodat notify [2,8192,SCMP_CMP_MASKED_EQ,61440]
+mknodat notify [2,24576,SCMP_CMP_MASKED_EQ,61440]
+`
+
+const SECCOMP_NOTIFY_SETXATTR = `setxattr notify
+lsetxattr notify
+fsetxattr notify
+`
+
 var seccompPath = shared.VarPath("security", "seccomp")
 
+var seccompSyscallName = regexp.MustCompile("^[a-z0-9_]+$")
+
+// The config keys turned into the seccomp policy of a container.
+var seccompConfigKeys = []string{"raw.seccomp", "security.syscalls.blacklist", "security.syscalls.whitelist"}
+
+// seccompDetect checks whether the kernel supports seccomp filters.
+func seccompDetect() bool {
+	content, err := ioutil.ReadFile("/proc/self/status")
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "Seccomp:") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// seccompNotifyDetect checks whether both the kernel and LXC can forward
+// syscalls to the daemon.
+func seccompNotifyDetect() bool {
+	content, err := ioutil.ReadFile("/proc/sys/kernel/seccomp/actions_avail")
+	if err != nil || !shared.StringInSlice("user_notif", strings.Fields(string(content))) {
+		return false
+	}
+
+	return lxcVersionAtLeast(3, 2)
+}
+
+// seccompIntercepts tells whether a syscall family is to be handled by the
+// daemon for the container, which is pointless for privileged ones.
+func seccompIntercepts(c container, name string) bool {
+	if c.IsPrivileged() || !seccompNotifyAvailable {
+		return false
+	}
+
+	return shared.IsTrue(c.ExpandedConfig()[fmt.Sprintf("security.syscalls.intercept.%s", name)])
+}
+
+// seccompSyscallList parses a comma separated list of syscall names.
+func seccompSyscallList(value string) ([]string, error) {
+	syscalls := []string{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		if !seccompSyscallName.MatchString(name) {
+			return nil, fmt.Errorf("Invalid syscall name: %s", name)
+		}
+
+		syscalls = append(syscalls, name)
+	}
+
+	return syscalls, nil
+}
+
+// seccompValidConfig checks the seccomp related keys of a container or
+// profile config.
+func seccompValidConfig(config map[string]string) error {
+	if config["security.syscalls.blacklist"] != "" && config["security.syscalls.whitelist"] != "" {
+		return fmt.Errorf("security.syscalls.blacklist and security.syscalls.whitelist are mutually exclusive")
+	}
+
+	for _, key := range []string{"security.syscalls.blacklist", "security.syscalls.whitelist"} {
+		_, err := seccompSyscallList(config[key])
+		if err != nil {
+			return fmt.Errorf("Invalid value for %s: %s", key, err)
+		}
+	}
+
+	raw := strings.TrimSpace(config["raw.seccomp"])
+	if raw != "" {
+		version := strings.TrimSpace(strings.SplitN(raw, "\n", 2)[0])
+		if version != "1" && version != "2" {
+			return fmt.Errorf("raw.seccomp must start with the policy version, 1 or 2")
+		}
+	}
+
+	return nil
+}
+
 func SeccompProfilePath(c container) string {
 	return path.Join(seccompPath, c.Name())
 }
 
-func getSeccompProfileContent(c container) string {
-	/* for now there are no seccomp knobs. */
-	return DEFAULT_SECCOMP_POLICY
+func getSeccompProfileContent(c container) (string, error) {
+	config := c.ExpandedConfig()
+
+	// A raw policy replaces everything else
+	if config["raw.seccomp"] != "" {
+		return strings.TrimSpace(config["raw.seccomp"]) + "\n", nil
+	}
+
+	err := seccompValidConfig(config)
+	if err != nil {
+		return "", err
+	}
+
+	policy := DEFAULT_SECCOMP_POLICY
+	whitelist, _ := seccompSyscallList(config["security.syscalls.whitelist"])
+	if len(whitelist) > 0 {
+		policy = fmt.Sprintf("2\nwhitelist\n[all]\n%s\n", strings.Join(whitelist, "\n"))
+	} else {
+		blacklist, _ := seccompSyscallList(config["security.syscalls.blacklist"])
+		for _, name := range blacklist {
+			policy += fmt.Sprintf("%s errno 1\n", name)
+		}
+	}
+
+	if seccompIntercepts(c, "mknod") {
+		policy += SECCOMP_NOTIFY_MKNOD
+	}
+
+	if seccompIntercepts(c, "setxattr") {
+		policy += SECCOMP_NOTIFY_SETXATTR
+	}
+
+	return policy, nil
 }
 
 func SeccompCreateProfile(c container) error {
@@ -38,7 +170,28 @@ func SeccompCreateProfile(c container) error {
 	 * the mtime on the file for any compiler purpose, so let's just write
 	 * out the profile.
 	 */
-	profile := getSeccompProfileContent(c)
+	for _, name := range []string{"mknod", "setxattr"} {
+		key := fmt.Sprintf("security.syscalls.intercept.%s", name)
+		if shared.IsTrue(c.ExpandedConfig()[key]) && !c.IsPrivileged() && !seccompNotifyAvailable {
+			return &containerCapabilityError{"lxc", "syscalls.intercept", "seccomp_notify"}
+		}
+	}
+
+	if !seccompAvailable {
+		for _, key := range seccompConfigKeys {
+			if c.ExpandedConfig()[key] != "" {
+				return fmt.Errorf("The kernel doesn't support seccomp, %s can't be applied", key)
+			}
+		}
+
+		return nil
+	}
+
+	profile, err := getSeccompProfileContent(c)
+	if err != nil {
+		return err
+	}
+
 	if err := os.MkdirAll(seccompPath, 0700); err != nil {
 		return err
 	}
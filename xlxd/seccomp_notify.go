@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+/*
+ * Syscalls flagged with "notify" in the seccomp policy of a container are
+ * suspended by the kernel and handed over to LXC, which forwards them to the
+ * socket below along with an fd to the memory of the calling process. The
+ * daemon performs the few it knows to be safe on behalf of the caller and
+ * answers with the result, the attributes it doesn't manage are left to the
+ * kernel and everything else is refused.
+ */
+
+// The header LXC puts in front of every request, seccomp_notify_proxy_msg.
+type seccompNotifyHeader struct {
+	Reserved   uint64
+	MonitorPid int32
+	InitPid    int32
+	SizeNotif  uint16
+	SizeResp   uint16
+	SizeData   uint16
+	Padding    uint16
+	CookieLen  uint64
+}
+
+// struct seccomp_notif
+type seccompNotif struct {
+	Id    uint64
+	Pid   uint32
+	Flags uint32
+	Nr    int32
+	Arch  uint32
+	Ip    uint64
+	Args  [6]uint64
+}
+
+// struct seccomp_notif_resp
+type seccompNotifResp struct {
+	Id    uint64
+	Val   int64
+	Error int32
+	Flags uint32
+}
+
+// SECCOMP_USER_NOTIF_FLAG_CONTINUE, letting the kernel run the syscall with
+// its usual permission checks.
+const seccompUserNotifFlagContinue = 1
+
+// Returned by the handlers to answer with seccompUserNotifFlagContinue.
+const seccompContinue = syscall.Errno(^uintptr(0))
+
+// SECCOMP_IOCTL_NOTIF_ID_VALID, whose direction bits differ on powerpc.
+var seccompIoctlNotifIdValid = map[string]uintptr{
+	"ppc64":   0x80082102,
+	"ppc64le": 0x80082102,
+}
+
+// The extended attributes set on behalf of a container, by prefix.
+var seccompXattrWhitelist = []string{
+	"trusted.overlay.",
+}
+
+// The number of mknod on the architectures which still have it, mknodat
+// being the only one elsewhere.
+var seccompSysMknod = map[string]int32{
+	"386":     14,
+	"amd64":   133,
+	"arm":     14,
+	"ppc64":   14,
+	"ppc64le": 14,
+	"s390x":   14,
+}
+
+// The device nodes containers may create, major and minor.
+var seccompMknodWhitelist = [][2]uint32{
+	{0, 0},    // overlayfs whiteouts
+	{1, 3},    // null
+	{1, 5},    // zero
+	{1, 7},    // full
+	{1, 8},    // random
+	{1, 9},    // urandom
+	{5, 0},    // tty
+	{5, 1},    // console
+	{5, 2},    // ptmx
+	{10, 229}, // fuse
+}
+
+// The largest extended attribute value set on behalf of a container.
+const seccompXattrMax = 4096
+
+// The audit architecture of native syscalls, the numbers of compat ones
+// differ and aren't handled.
+var seccompNativeArch = map[string]uint32{
+	"386":     0x40000003,
+	"amd64":   0xc000003e,
+	"arm":     0x40000028,
+	"arm64":   0xc00000b7,
+	"ppc64":   0x80000015,
+	"ppc64le": 0xc0000015,
+	"s390x":   0x80000016,
+}
+
+var seccompEndian binary.ByteOrder = binary.LittleEndian
+
+func init() {
+	x := uint16(1)
+	if *(*byte)(unsafe.Pointer(&x)) == 0 {
+		seccompEndian = binary.BigEndian
+	}
+}
+
+// seccompRequest is a suspended syscall along with what's needed to check on
+// its caller.
+type seccompRequest struct {
+	notif    seccompNotif
+	memFd    int
+	notifyFd int
+	initPid  int32
+
+	// The start time of the caller when the request came in, to detect a
+	// reused pid when there's no notify fd to ask the kernel.
+	start string
+}
+
+type seccompServer struct {
+	d    *Daemon
+	path string
+	l    *net.UnixListener
+}
+
+func seccompSocketPath() string {
+	return shared.VarPath("seccomp.socket")
+}
+
+func seccompServerStart(d *Daemon) (*seccompServer, error) {
+	s := &seccompServer{d: d, path: seccompSocketPath()}
+
+	// A previous daemon may have left its socket behind
+	err := os.Remove(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	s.l, err = net.ListenUnix("unixpacket", &net.UnixAddr{Name: s.path, Net: "unixpacket"})
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.Chmod(s.path, 0700)
+	if err != nil {
+		s.l.Close()
+		return nil, err
+	}
+
+	go func() {
+		for {
+			conn, err := s.l.AcceptUnix()
+			if err != nil {
+				return
+			}
+
+			go s.serve(conn)
+		}
+	}()
+
+	return s, nil
+}
+
+func (s *seccompServer) Stop() error {
+	return s.l.Close()
+}
+
+// serve handles the requests of a container, LXC opens one connection per
+// container and keeps it for as long as the container runs.
+func (s *seccompServer) serve(conn *net.UnixConn) {
+	defer conn.Close()
+
+	buf := make([]byte, 4096)
+	oob := make([]byte, syscall.CmsgSpace(2*4))
+
+	for {
+		n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+		if err != nil {
+			if err != io.EOF {
+				shared.Log.Debug("Seccomp notification socket failed", log.Ctx{"err": err})
+			}
+			return
+		}
+
+		// The memory of the caller, then the notify fd with newer LXC
+		fds := []int{}
+		msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+		if err == nil && len(msgs) > 0 {
+			fds, err = syscall.ParseUnixRights(&msgs[0])
+			if err != nil {
+				fds = []int{}
+			}
+		}
+
+		req := seccompRequest{memFd: -1, notifyFd: -1}
+		if len(fds) > 0 {
+			req.memFd = fds[0]
+		}
+
+		if len(fds) > 1 {
+			req.notifyFd = fds[1]
+		}
+
+		reply, err := s.handle(buf[:n], &req)
+		for _, fd := range fds {
+			syscall.Close(fd)
+		}
+
+		if err != nil {
+			shared.Log.Error("Invalid seccomp notification", log.Ctx{"err": err})
+			return
+		}
+
+		_, err = conn.Write(reply)
+		if err != nil {
+			return
+		}
+	}
+}
+
+// handle parses a request and returns it with the response filled in.
+func (s *seccompServer) handle(msg []byte, req *seccompRequest) ([]byte, error) {
+	r := bytes.NewReader(msg)
+
+	hdr := seccompNotifyHeader{}
+	err := binary.Read(r, seccompEndian, &hdr)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kernel structs may grow, only their start is known
+	notifOffset := binary.Size(hdr)
+	respOffset := notifOffset + int(hdr.SizeNotif)
+	cookieOffset := respOffset + int(hdr.SizeResp)
+	if int(hdr.SizeNotif) < binary.Size(seccompNotif{}) || int(hdr.SizeResp) < binary.Size(seccompNotifResp{}) || cookieOffset+int(hdr.CookieLen) > len(msg) {
+		return nil, fmt.Errorf("Unexpected message size")
+	}
+
+	err = binary.Read(bytes.NewReader(msg[notifOffset:respOffset]), seccompEndian, &req.notif)
+	if err != nil {
+		return nil, err
+	}
+
+	req.initPid = hdr.InitPid
+	req.start, _ = seccompStartTime(req.notif.Pid)
+
+	name := string(msg[cookieOffset : cookieOffset+int(hdr.CookieLen)])
+	name = strings.TrimRight(name, "\x00")
+
+	errno := syscall.EPERM
+	c, err := containerLoadByName(s.d, name)
+	if err != nil {
+		shared.Log.Error("Seccomp notification for an unknown container", log.Ctx{"container": name})
+	} else if req.memFd < 0 {
+		shared.Log.Error("Seccomp notification without the memory of the caller", log.Ctx{"container": name})
+	} else {
+		errno = s.handleSyscall(c, req)
+	}
+
+	resp := seccompNotifResp{Id: req.notif.Id}
+	if errno == seccompContinue {
+		resp.Flags = seccompUserNotifFlagContinue
+	} else {
+		resp.Error = -int32(errno)
+	}
+
+	out := bytes.Buffer{}
+	err = binary.Write(&out, seccompEndian, &resp)
+	if err != nil {
+		return nil, err
+	}
+
+	reply := make([]byte, len(msg))
+	copy(reply, msg)
+	copy(reply[respOffset:], out.Bytes())
+
+	return reply, nil
+}
+
+func (s *seccompServer) handleSyscall(c container, req *seccompRequest) syscall.Errno {
+	notif := &req.notif
+	if notif.Arch != seccompNativeArch[runtime.GOARCH] {
+		return syscall.ENOSYS
+	}
+
+	mknod, hasMknod := seccompSysMknod[runtime.GOARCH]
+
+	switch {
+	case notif.Nr == syscall.SYS_SETXATTR || notif.Nr == syscall.SYS_LSETXATTR || notif.Nr == syscall.SYS_FSETXATTR:
+		if !seccompIntercepts(c, "setxattr") {
+			return syscall.EPERM
+		}
+
+		return s.handleSetxattr(c, req)
+	case notif.Nr == syscall.SYS_MKNODAT:
+		if !seccompIntercepts(c, "mknod") {
+			return syscall.EPERM
+		}
+
+		return s.handleMknod(c, req, int32(notif.Args[0]), notif.Args[1:])
+	case hasMknod && notif.Nr == mknod:
+		if !seccompIntercepts(c, "mknod") {
+			return syscall.EPERM
+		}
+
+		return s.handleMknod(c, req, -100, notif.Args[:])
+	default:
+		return syscall.ENOSYS
+	}
+}
+
+// valid tells whether the caller is still suspended in the syscall, its pid
+// not having been reused since the request came in.
+func (req *seccompRequest) valid() bool {
+	if req.notifyFd >= 0 {
+		cmd, ok := seccompIoctlNotifIdValid[runtime.GOARCH]
+		if !ok {
+			cmd = 0x40082102
+		}
+
+		id := req.notif.Id
+		_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(req.notifyFd), cmd, uintptr(unsafe.Pointer(&id)))
+		return errno == 0
+	}
+
+	start, err := seccompStartTime(req.notif.Pid)
+	return err == nil && req.start != "" && start == req.start
+}
+
+// seccompStartTime returns the start time of a process, in clock ticks since
+// boot, which tells apart the processes getting the same pid.
+func seccompStartTime(pid uint32) (string, error) {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return "", err
+	}
+
+	// The command can contain anything, parentheses and spaces included
+	end := bytes.LastIndexByte(content, ')')
+	if end < 0 {
+		return "", fmt.Errorf("Invalid stat of process %d", pid)
+	}
+
+	// starttime is the 22nd field, the 20th after the command
+	fields := strings.Fields(string(content[end+1:]))
+	if len(fields) < 20 {
+		return "", fmt.Errorf("Invalid stat of process %d", pid)
+	}
+
+	return fields[19], nil
+}
+
+// seccompSameUserns tells whether the caller is in the user namespace of the
+// container, the only one whose capabilities are worth anything here.
+func seccompSameUserns(req *seccompRequest) bool {
+	caller, err := os.Stat(fmt.Sprintf("/proc/%d/ns/user", req.notif.Pid))
+	if err != nil {
+		return false
+	}
+
+	initNs, err := os.Stat(fmt.Sprintf("/proc/%d/ns/user", req.initPid))
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(caller, initNs)
+}
+
+// seccompCapable tells whether the caller has the capability cap in the user
+// namespace of the container.
+func seccompCapable(req *seccompRequest, caller *seccompCaller, cap uint) bool {
+	return caller.capEff&(1<<cap) != 0 && seccompSameUserns(req)
+}
+
+// seccompUidMap returns the host uids of a container for the fork helpers,
+// "-" when it isn't mapped.
+func seccompUidMap(c container) string {
+	idmap := c.IdmapSet()
+	if idmap == nil {
+		return "-"
+	}
+
+	ranges := []string{}
+	for _, entry := range idmap.Idmap {
+		if entry.Isuid {
+			ranges = append(ranges, fmt.Sprintf("%d:%d", entry.Hostid, entry.Maprange))
+		}
+	}
+
+	if len(ranges) == 0 {
+		return "-"
+	}
+
+	return strings.Join(ranges, ",")
+}
+
+// seccompCaller is what the daemon needs to know about the calling process.
+type seccompCaller struct {
+	fsuid  int
+	fsgid  int
+	capEff uint64
+	umask  uint32
+}
+
+const (
+	capMknod    = 27
+	capSysAdmin = 21
+)
+
+func seccompCallerStatus(pid uint32) (*seccompCaller, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	caller := seccompCaller{umask: 0022}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "Uid:", "Gid:":
+			// real, effective, saved and filesystem ids
+			if len(fields) != 5 {
+				return nil, fmt.Errorf("Invalid %s line", fields[0])
+			}
+
+			id, err := strconv.Atoi(fields[4])
+			if err != nil {
+				return nil, err
+			}
+
+			if fields[0] == "Uid:" {
+				caller.fsuid = id
+			} else {
+				caller.fsgid = id
+			}
+		case "CapEff:":
+			caller.capEff, err = strconv.ParseUint(fields[1], 16, 64)
+			if err != nil {
+				return nil, err
+			}
+		case "Umask:":
+			umask, err := strconv.ParseUint(fields[1], 8, 32)
+			if err != nil {
+				return nil, err
+			}
+			caller.umask = uint32(umask)
+		}
+	}
+
+	return &caller, scanner.Err()
+}
+
+// seccompReadString reads a NUL terminated string out of the caller memory.
+func seccompReadString(memFd int, addr uint64, max int) (string, error) {
+	buf := make([]byte, max)
+	n, err := syscall.Pread(memFd, buf, int64(addr))
+	if err != nil {
+		return "", err
+	}
+
+	end := bytes.IndexByte(buf[:n], 0)
+	if end < 0 {
+		return "", syscall.ENAMETOOLONG
+	}
+
+	return string(buf[:end]), nil
+}
+
+// seccompDirPath returns where relative paths of a *at syscall start from.
+func seccompDirPath(pid uint32, dirfd int32) string {
+	if dirfd == -100 {
+		// AT_FDCWD
+		return fmt.Sprintf("/proc/%d/cwd", pid)
+	}
+
+	return fmt.Sprintf("/proc/%d/fd/%d", pid, dirfd)
+}
+
+// seccompForkCall runs one of the fork helpers, which exit with the errno
+// of the failed call.
+func seccompForkCall(d *Daemon, args ...string) syscall.Errno {
+	out, err := exec.Command(d.execPath, args...).CombinedOutput()
+	if err == nil {
+		return 0
+	}
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		status, ok := exitErr.Sys().(syscall.WaitStatus)
+		if ok && status.ExitStatus() > 0 {
+			return syscall.Errno(status.ExitStatus())
+		}
+	}
+
+	shared.Log.Error("Failed to run a syscall for a container", log.Ctx{"command": args[0], "output": string(out), "err": err})
+	return syscall.EPERM
+}
+
+// handleMknod creates whitelisted device nodes, args being the path, mode
+// and device of mknod, in a directory owned by the container.
+func (s *seccompServer) handleMknod(c container, req *seccompRequest, dirfd int32, args []uint64) syscall.Errno {
+	notif := &req.notif
+	caller, err := seccompCallerStatus(notif.Pid)
+	if err != nil {
+		return syscall.EPERM
+	}
+
+	if !seccompCapable(req, caller, capMknod) {
+		return syscall.EPERM
+	}
+
+	path, err := seccompReadString(req.memFd, args[0], syscall.PathMax)
+	if err != nil {
+		return syscall.EFAULT
+	}
+
+	mode := uint32(args[1])
+	dev := args[2]
+	major := uint32((dev >> 8) & 0xfff)
+	minor := uint32((dev & 0xff) | ((dev >> 12) & 0xfff00))
+
+	allowed := false
+	for _, entry := range seccompMknodWhitelist {
+		if mode&syscall.S_IFMT == syscall.S_IFCHR && entry[0] == major && entry[1] == minor {
+			allowed = true
+			break
+		}
+	}
+
+	if !allowed {
+		return syscall.EPERM
+	}
+
+	// The memory may have been read from another process
+	if !req.valid() {
+		return syscall.EPERM
+	}
+
+	shared.Log.Debug("Creating a device node for a container",
+		log.Ctx{"container": c.Name(), "path": path, "major": major, "minor": minor})
+
+	return seccompForkCall(s.d, "forkmknod",
+		fmt.Sprintf("%d", notif.Pid),
+		seccompDirPath(notif.Pid, dirfd),
+		path,
+		fmt.Sprintf("%d", mode&^caller.umask),
+		fmt.Sprintf("%d", major),
+		fmt.Sprintf("%d", minor),
+		fmt.Sprintf("%d", caller.fsuid),
+		fmt.Sprintf("%d", caller.fsgid),
+		seccompUidMap(c))
+}
+
+// seccompXattrAllowed tells whether the daemon sets the attribute name on
+// behalf of a container.
+func seccompXattrAllowed(name string) bool {
+	for _, prefix := range seccompXattrWhitelist {
+		if strings.HasPrefix(name, prefix) && len(name) > len(prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// handleSetxattr sets the whitelisted attributes, which require privileges
+// over the filesystem an unprivileged container doesn't have, on the files
+// owned by the container. The other ones are left to the kernel.
+func (s *seccompServer) handleSetxattr(c container, req *seccompRequest) syscall.Errno {
+	notif := &req.notif
+	name, err := seccompReadString(req.memFd, notif.Args[1], 256)
+	if err != nil {
+		return syscall.EFAULT
+	}
+
+	if !seccompXattrAllowed(name) {
+		return seccompContinue
+	}
+
+	caller, err := seccompCallerStatus(notif.Pid)
+	if err != nil {
+		return syscall.EPERM
+	}
+
+	if !seccompCapable(req, caller, capSysAdmin) {
+		return syscall.EPERM
+	}
+
+	size := notif.Args[3]
+	if size > seccompXattrMax {
+		return syscall.E2BIG
+	}
+
+	value := make([]byte, size)
+	if size > 0 {
+		n, err := syscall.Pread(req.memFd, value, int64(notif.Args[2]))
+		if err != nil || uint64(n) != size {
+			return syscall.EFAULT
+		}
+	}
+
+	dir := "-"
+	path := ""
+	follow := "1"
+	switch notif.Nr {
+	case syscall.SYS_FSETXATTR:
+		// The fd of the caller is reachable from the host
+		path = fmt.Sprintf("/proc/%d/fd/%d", notif.Pid, int32(notif.Args[0]))
+	default:
+		dir = seccompDirPath(notif.Pid, -100)
+		path, err = seccompReadString(req.memFd, notif.Args[0], syscall.PathMax)
+		if err != nil {
+			return syscall.EFAULT
+		}
+
+		if notif.Nr == syscall.SYS_LSETXATTR {
+			follow = "0"
+		}
+	}
+
+	if !req.valid() {
+		return syscall.EPERM
+	}
+
+	shared.Log.Debug("Setting an extended attribute for a container",
+		log.Ctx{"container": c.Name(), "path": filepath.Clean(path), "name": name})
+
+	return seccompForkCall(s.d, "forksetxattr",
+		fmt.Sprintf("%d", notif.Pid),
+		dir,
+		path,
+		name,
+		hex.EncodeToString(value),
+		fmt.Sprintf("%d", int32(notif.Args[4])),
+		follow,
+		seccompUidMap(c))
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSeccompSyscallList(t *testing.T) {
+	syscalls, err := seccompSyscallList(" mount, umount2,,keyctl ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(syscalls, []string{"mount", "umount2", "keyctl"}) {
+		t.Errorf("Wrong syscalls: %v", syscalls)
+	}
+
+	_, err = seccompSyscallList("mount errno 1")
+	if err == nil {
+		t.Errorf("A policy line was accepted as a syscall name")
+	}
+}
+
+func TestSeccompValidConfig(t *testing.T) {
+	valid := []map[string]string{
+		{},
+		{"security.syscalls.blacklist": "mount,keyctl"},
+		{"security.syscalls.whitelist": "read,write"},
+		{"raw.seccomp": "2\nblacklist\n[all]\nmount errno 1\n"},
+	}
+
+	for _, config := range valid {
+		if err := seccompValidConfig(config); err != nil {
+			t.Errorf("%v: %s", config, err)
+		}
+	}
+
+	invalid := []map[string]string{
+		{"security.syscalls.blacklist": "mount", "security.syscalls.whitelist": "read"},
+		{"security.syscalls.whitelist": "read;write"},
+		{"raw.seccomp": "blacklist\n[all]\nmount errno 1\n"},
+	}
+
+	for _, config := range invalid {
+		if err := seccompValidConfig(config); err == nil {
+			t.Errorf("%v: should have failed", config)
+		}
+	}
+}
+
+func TestSeccompXattrAllowed(t *testing.T) {
+	for _, name := range []string{"trusted.overlay.opaque", "trusted.overlay.redirect"} {
+		if !seccompXattrAllowed(name) {
+			t.Errorf("%s should be allowed", name)
+		}
+	}
+
+	for _, name := range []string{"trusted.overlay.", "trusted.foo", "security.selinux", "security.capability", "user.foo"} {
+		if seccompXattrAllowed(name) {
+			t.Errorf("%s shouldn't be allowed", name)
+		}
+	}
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 
 	"github.com/gorilla/websocket"
@@ -82,6 +86,74 @@ func storageRsyncCopy(source string, dest string) (string, error) {
 	return string(output), err
 }
 
+// storageDiskUsage returns the disk space used by a path using du, this is
+// the fallback for backends which don't do their own accounting.
+func storageDiskUsage(path string) (int64, error) {
+	output, err := exec.Command("du", "-s", "-x", "-B1", path).Output()
+	if err != nil {
+		return -1, fmt.Errorf("Failed to get disk usage of '%s': %v", path, err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) < 1 {
+		return -1, fmt.Errorf("Unexpected du output: %s", output)
+	}
+
+	return strconv.ParseInt(fields[0], 10, 64)
+}
+
+// storagePathSpace returns the total, used and available space of the
+// filesystem holding path.
+func storagePathSpace(path string) (uint64, uint64, uint64, error) {
+	fs := syscall.Statfs_t{}
+
+	err := syscall.Statfs(path, &fs)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	total := fs.Blocks * uint64(fs.Bsize)
+	free := fs.Bfree * uint64(fs.Bsize)
+	available := fs.Bavail * uint64(fs.Bsize)
+
+	return total, total - free, available, nil
+}
+
+// storageContainerListPaths lists the containers and snapshots for the
+// backends which keep them as directories of containers/ and snapshots/.
+func storageContainerListPaths() ([]string, error) {
+	names := []string{}
+	entries, err := ioutil.ReadDir(shared.VarPath("containers"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		names = append(names, entry.Name())
+
+		snapshots, err := ioutil.ReadDir(shared.VarPath("snapshots", entry.Name()))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			return nil, err
+		}
+
+		for _, snapshot := range snapshots {
+			if snapshot.IsDir() {
+				names = append(names, entry.Name()+shared.SnapshotDelimiter+snapshot.Name())
+			}
+		}
+	}
+
+	return names, nil
+}
+
 // storageType defines the type of a storage
 type storageType int
 
@@ -112,6 +184,10 @@ type MigrationStorageSource interface {
 	Name() string
 	IsSnapshot() bool
 	Send(conn *websocket.Conn) error
+
+	// Cleanup releases what was set up for sending, for the sources which
+	// end up not being sent.
+	Cleanup()
 }
 
 type storage interface {
@@ -135,6 +211,18 @@ type storage interface {
 	ContainerRename(container container, newName string) error
 	ContainerRestore(container container, sourceContainer container) error
 
+	// ContainerGetUsage returns the disk space used by a container or
+	// snapshot and its quota in bytes (-1 when there's no quota).
+	ContainerGetUsage(container container) (int64, int64, error)
+
+	// ContainerList returns the name of every container and snapshot
+	// found on the backend, whether or not the database knows about it.
+	ContainerList() ([]string, error)
+
+	// PoolInfo returns the space left on the backend, the driver fields
+	// are filled by the caller.
+	PoolInfo() (shared.StoragePool, error)
+
 	ContainerSnapshotCreate(
 		snapshotContainer container, sourceContainer container) error
 	ContainerSnapshotDelete(snapshotContainer container) error
@@ -276,6 +364,86 @@ func (ss *storageShared) GetStorageTypeVersion() string {
 	return ss.sTypeVersion
 }
 
+// shiftProgress reports the progress of a uid/gid shift of the container
+// through the metadata of the operation acting on it.
+func shiftProgress(c container) func(percent int) {
+	op := operationForResource("containers", c.Name())
+	if op == nil {
+		return nil
+	}
+
+	return func(percent int) {
+		meta := make(map[string]interface{})
+		op.lock.Lock()
+		for k, v := range op.metadata {
+			meta[k] = v
+		}
+		op.lock.Unlock()
+
+		meta["shift_progress"] = fmt.Sprintf("%d%%", percent)
+		op.UpdateMetadata(meta)
+	}
+}
+
+// imageLocks serialize the creation and deletion of the cached volume of each
+// image, an entry being dropped once nobody uses it anymore.
+var imageLocks = map[string]*imageLock{}
+var imageLocksMutex sync.Mutex
+
+type imageLock struct {
+	sync.Mutex
+	users int
+}
+
+// imageLockAcquire takes the lock of the cached volume of an image and
+// returns the function releasing it.
+func imageLockAcquire(fingerprint string) func() {
+	imageLocksMutex.Lock()
+	lock := imageLocks[fingerprint]
+	if lock == nil {
+		lock = &imageLock{}
+		imageLocks[fingerprint] = lock
+	}
+	lock.users++
+	imageLocksMutex.Unlock()
+
+	lock.Lock()
+
+	return func() {
+		lock.Unlock()
+
+		imageLocksMutex.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(imageLocks, fingerprint)
+		}
+		imageLocksMutex.Unlock()
+	}
+}
+
+// imageEnsure makes sure that the cached volume of an image, found at path,
+// exists for containers to be cloned from. The containers created from the
+// same image at the same time wait for a single unpack rather than each
+// doing its own, a failed one being cleaned up with remove so that the next
+// try starts over.
+func (ss *storageShared) imageEnsure(fingerprint string, path string, create func(string) error, remove func(string) error) error {
+	unlock := imageLockAcquire(fingerprint)
+	defer unlock()
+
+	if shared.PathExists(path) {
+		return nil
+	}
+
+	err := create(fingerprint)
+	if err != nil {
+		ss.log.Error("Failed to unpack image", log.Ctx{"image": fingerprint, "err": err})
+		remove(fingerprint)
+		return err
+	}
+
+	return nil
+}
+
 func (ss *storageShared) shiftRootfs(c container) error {
 	dpath := c.Path()
 	rpath := c.RootfsPath()
@@ -289,10 +457,13 @@ func (ss *storageShared) shiftRootfs(c container) error {
 		return fmt.Errorf("IdmapSet of container '%s' is nil", c.Name())
 	}
 
-	err := idmapset.ShiftRootfs(rpath)
-	if err != nil {
-		shared.Debugf("Shift of rootfs %s failed: %s", rpath, err)
-		return err
+	// Idmapped mounts take care of it when the container starts
+	if c.DiskIdmapSet() != nil {
+		err := idmapset.ShiftRootfsWithProgress(rpath, shiftProgress(c))
+		if err != nil {
+			shared.Debugf("Shift of rootfs %s failed: %s", rpath, err)
+			return err
+		}
 	}
 
 	/* Set an acl so the container root can descend the container dir */
@@ -433,6 +604,19 @@ func (lw *storageLogWrapper) ContainerRestore(
 	return lw.w.ContainerRestore(container, sourceContainer)
 }
 
+func (lw *storageLogWrapper) ContainerGetUsage(container container) (int64, int64, error) {
+	return lw.w.ContainerGetUsage(container)
+}
+
+func (lw *storageLogWrapper) PoolInfo() (shared.StoragePool, error) {
+	return lw.w.PoolInfo()
+}
+
+func (lw *storageLogWrapper) ContainerList() ([]string, error) {
+	lw.log.Debug("ContainerList")
+	return lw.w.ContainerList()
+}
+
 func (lw *storageLogWrapper) ContainerSnapshotCreate(
 	snapshotContainer container, sourceContainer container) error {
 
@@ -517,17 +701,18 @@ func (lw *storageLogWrapper) MigrationSink(container container, objects []contai
 }
 
 func ShiftIfNecessary(container container, srcIdmap *shared.IdmapSet) error {
-	dstIdmap := container.IdmapSet()
+	dstIdmap := container.DiskIdmapSet()
 	if dstIdmap == nil {
 		dstIdmap = new(shared.IdmapSet)
 	}
 
 	if !reflect.DeepEqual(srcIdmap, dstIdmap) {
-		if err := srcIdmap.UnshiftRootfs(container.Path()); err != nil {
+		progress := shiftProgress(container)
+		if err := srcIdmap.UnshiftRootfsWithProgress(container.Path(), progress); err != nil {
 			return err
 		}
 
-		if err := dstIdmap.ShiftRootfs(container.Path()); err != nil {
+		if err := dstIdmap.ShiftRootfsWithProgress(container.Path(), progress); err != nil {
 			return err
 		}
 	}
@@ -537,6 +722,7 @@ func ShiftIfNecessary(container container, srcIdmap *shared.IdmapSet) error {
 
 type rsyncStorageSource struct {
 	container container
+	delta     bool
 }
 
 func (s *rsyncStorageSource) Name() string {
@@ -549,37 +735,107 @@ func (s *rsyncStorageSource) IsSnapshot() bool {
 
 func (s *rsyncStorageSource) Send(conn *websocket.Conn) error {
 	path := s.container.Path()
-	return RsyncSend(shared.AddSlash(path), conn)
+	return RsyncSend(shared.AddSlash(path), conn, s.delta)
+}
+
+func (s *rsyncStorageSource) Cleanup() {
+}
+
+// rsyncSnapshotStorageSource sends a snapshot which the storage only mounts
+// on demand (ZFS).
+type rsyncSnapshotStorageSource struct {
+	rsyncStorageSource
+}
+
+func (s *rsyncSnapshotStorageSource) Send(conn *websocket.Conn) error {
+	if err := s.container.StorageStart(); err != nil {
+		return err
+	}
+	defer s.container.StorageStop()
+
+	return s.rsyncStorageSource.Send(conn)
 }
 
 func rsyncMigrationSource(container container) ([]MigrationStorageSource, error) {
 	sources := []MigrationStorageSource{}
 
 	/* transfer the container, and then all the snapshots */
-	sources = append(sources, &rsyncStorageSource{container})
+	sources = append(sources, &rsyncStorageSource{container: container})
 	snaps, err := container.Snapshots()
 	if err != nil {
 		return nil, err
 	}
 
 	for _, snap := range snaps {
-		sources = append(sources, &rsyncStorageSource{snap})
+		sources = append(sources, &rsyncStorageSource{container: snap})
 	}
 
 	return sources, nil
 }
 
+// rsyncMigrationFallbackSource returns the rsync sources of a container for
+// a sink which can only take rsync, or which only wants some of the
+// snapshots. The snapshots are sent in the order they were announced in,
+// before the container in delta mode and after it otherwise, as the sinks
+// without delta mode expect.
+func rsyncMigrationFallbackSource(container container, snapshots []string, delta bool) ([]MigrationStorageSource, error) {
+	sources := []MigrationStorageSource{}
+
+	for _, name := range snapshots {
+		snap, err := containerLoadByName(container.Daemon(), container.Name()+shared.SnapshotDelimiter+name)
+		if err != nil {
+			return nil, err
+		}
+
+		sources = append(sources, &rsyncSnapshotStorageSource{rsyncStorageSource{container: snap, delta: delta}})
+	}
+
+	source := &rsyncStorageSource{container: container, delta: delta}
+	if delta {
+		return append(sources, source), nil
+	}
+
+	return append([]MigrationStorageSource{source}, sources...), nil
+}
+
 func rsyncMigrationSink(container container, snapshots []container, conn *websocket.Conn) error {
 	/* the first object is the actual container */
-	if err := RsyncRecv(shared.AddSlash(container.Path()), conn); err != nil {
+	if err := RsyncRecv(shared.AddSlash(container.Path()), conn, false); err != nil {
 		return err
 	}
 
 	for _, snap := range snapshots {
-		if err := RsyncRecv(shared.AddSlash(snap.Path()), conn); err != nil {
+		if err := RsyncRecv(shared.AddSlash(snap.Path()), conn, false); err != nil {
 			return err
 		}
 	}
 
 	return nil
 }
+
+// rsyncMigrationFallbackSink receives what an rsync source sends in delta
+// mode into a container whose storage has its own migration type, or which
+// is being refreshed. Not all of these can write to empty snapshots, so
+// each snapshot is received into the container and then snapshotted, which
+// also only transfers the delta from the previous one.
+func rsyncMigrationFallbackSink(container container, snapshots []containerArgs, srcIdmap *shared.IdmapSet, conn *websocket.Conn) error {
+	for _, args := range snapshots {
+		if err := RsyncRecv(shared.AddSlash(container.Path()), conn, true); err != nil {
+			return err
+		}
+
+		if err := ShiftIfNecessary(container, srcIdmap); err != nil {
+			return err
+		}
+
+		if _, err := containerCreateAsSnapshot(container.Daemon(), args, container, false); err != nil {
+			return err
+		}
+	}
+
+	if err := RsyncRecv(shared.AddSlash(container.Path()), conn, true); err != nil {
+		return err
+	}
+
+	return ShiftIfNecessary(container, srcIdmap)
+}
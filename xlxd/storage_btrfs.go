@@ -78,14 +78,13 @@ func (s *storageBtrfs) ContainerCreateFromImage(
 		shared.VarPath("images", imageFingerprint))
 
 	// Create the btrfs subvol of the image first if it doesn exists.
-	if !shared.PathExists(imageSubvol) {
-		if err := s.ImageCreate(imageFingerprint); err != nil {
-			return err
-		}
+	err := s.imageEnsure(imageFingerprint, imageSubvol, s.ImageCreate, s.ImageDelete)
+	if err != nil {
+		return err
 	}
 
 	// Now make a snapshot of the image subvol
-	err := s.subvolsSnapshot(imageSubvol, container.Path(), false)
+	err = s.subvolsSnapshot(imageSubvol, container.Path(), false)
 	if err != nil {
 		return err
 	}
@@ -139,6 +138,8 @@ func (s *storageBtrfs) ContainerCopy(container container, sourceContainer contai
 			return err
 		}
 	} else {
+		s.log.Info("Copy from non-subvolume container, using rsync", log.Ctx{"container": container.Name(), "sourceContainer": sourceContainer.Name()})
+
 		// Create the BTRFS Container.
 		if err := s.ContainerCreate(container); err != nil {
 			return err
@@ -253,6 +254,36 @@ func (s *storageBtrfs) ContainerRestore(
 	return failure
 }
 
+func (s *storageBtrfs) ContainerGetUsage(container container) (int64, int64, error) {
+	// qgroups aren't necessarily enabled, so just use du.
+	usage, err := storageDiskUsage(container.Path())
+	if err != nil {
+		return -1, -1, err
+	}
+
+	return usage, -1, nil
+}
+
+func (s *storageBtrfs) PoolInfo() (shared.StoragePool, error) {
+	pool := shared.StoragePool{Source: shared.VarPath("containers")}
+
+	// statfs is only an estimate on btrfs but doesn't need root
+	total, used, available, err := storagePathSpace(pool.Source)
+	if err != nil {
+		return pool, err
+	}
+
+	pool.Total = total
+	pool.Used = used
+	pool.Available = available
+
+	return pool, nil
+}
+
+func (s *storageBtrfs) ContainerList() ([]string, error) {
+	return storageContainerListPaths()
+}
+
 func (s *storageBtrfs) ContainerSnapshotCreate(
 	snapshotContainer container, sourceContainer container) error {
 
@@ -170,6 +170,34 @@ func (s *storageDir) ContainerRestore(
 	return nil
 }
 
+func (s *storageDir) ContainerGetUsage(container container) (int64, int64, error) {
+	usage, err := storageDiskUsage(container.Path())
+	if err != nil {
+		return -1, -1, err
+	}
+
+	return usage, -1, nil
+}
+
+func (s *storageDir) PoolInfo() (shared.StoragePool, error) {
+	pool := shared.StoragePool{Source: shared.VarPath("containers")}
+
+	total, used, available, err := storagePathSpace(pool.Source)
+	if err != nil {
+		return pool, err
+	}
+
+	pool.Total = total
+	pool.Used = used
+	pool.Available = available
+
+	return pool, nil
+}
+
+func (s *storageDir) ContainerList() ([]string, error) {
+	return storageContainerListPaths()
+}
+
 func (s *storageDir) ContainerSnapshotCreate(
 	snapshotContainer container, sourceContainer container) error {
 
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// Default usage (in percent) of the storage pool from which warnings are
+// emitted.
+const storageUsageDefaultThreshold = 90.0
+
+var storageCmd = Command{name: "storage", get: storageGet}
+
+func storageGet(d *Daemon, r *http.Request) Response {
+	pool, err := doStorageGet(d)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return SyncResponse(true, pool)
+}
+
+func doStorageGet(d *Daemon) (shared.StoragePool, error) {
+	pool, err := d.Storage.PoolInfo()
+	if err != nil {
+		return pool, err
+	}
+
+	pool.Driver = d.Storage.GetStorageTypeName()
+	pool.DriverVersion = d.Storage.GetStorageTypeVersion()
+
+	return pool, nil
+}
+
+// storageUsage returns how full the pool is in percent, for LVM that's the
+// fullest of the thin pool data and metadata as either running out breaks
+// the containers.
+func storageUsage(pool shared.StoragePool) float64 {
+	if pool.ThinPool != nil {
+		if pool.ThinPool.MetadataUsage > pool.ThinPool.DataUsage {
+			return pool.ThinPool.MetadataUsage
+		}
+
+		return pool.ThinPool.DataUsage
+	}
+
+	if pool.Total == 0 {
+		return 0
+	}
+
+	return float64(pool.Used) * 100 / float64(pool.Total)
+}
+
+func storageUsageThresholdValidate(value string) error {
+	if value == "" {
+		return nil
+	}
+
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil || threshold <= 0 || threshold > 100 {
+		return fmt.Errorf("Invalid usage threshold, must be a percentage: %s", value)
+	}
+
+	return nil
+}
+
+func storageUsageThreshold(d *Daemon) float64 {
+	value, err := d.ConfigValueGet("storage.usage_threshold")
+	if err != nil || value == "" {
+		return storageUsageDefaultThreshold
+	}
+
+	threshold, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return storageUsageDefaultThreshold
+	}
+
+	return threshold
+}
+
+// storageCreateCheck refuses the creation of containers when the pool is
+// above the usage threshold and storage.usage_refuse_create is set.
+func storageCreateCheck(d *Daemon) error {
+	value, err := d.ConfigValueGet("storage.usage_refuse_create")
+	if err != nil || !shared.IsTrue(value) {
+		return err
+	}
+
+	pool, err := d.Storage.PoolInfo()
+	if err != nil {
+		// Not knowing isn't a reason to refuse
+		shared.Log.Warn("Unable to get the storage usage", log.Ctx{"err": err})
+		return nil
+	}
+
+	usage := storageUsage(pool)
+	threshold := storageUsageThreshold(d)
+	if usage >= threshold {
+		return fmt.Errorf("The storage pool is %.1f%% full, above the %.1f%% threshold", usage, threshold)
+	}
+
+	return nil
+}
+
+var storageMonitorLock sync.Mutex
+var storageMonitorAlerted = false
+
+// storageMonitorTask periodically checks the usage of the storage pool and
+// warns when it goes above the threshold, before writes start failing
+// inside the containers.
+func storageMonitorTask(d *Daemon) {
+	for {
+		storageMonitorCheck(d)
+		time.Sleep(time.Minute)
+	}
+}
+
+func storageMonitorCheck(d *Daemon) {
+	pool, err := doStorageGet(d)
+	if err != nil {
+		shared.Log.Debug("Unable to get the storage usage", log.Ctx{"err": err})
+		return
+	}
+
+	storageMonitorLock.Lock()
+	defer storageMonitorLock.Unlock()
+
+	usage := storageUsage(pool)
+	threshold := storageUsageThreshold(d)
+	source := fmt.Sprintf("/%s/storage", shared.APIVersion)
+	context := shared.Jmap{"usage": usage, "threshold": threshold, "source": pool.Source}
+
+	// Only the crossings of the threshold are reported
+	if usage >= threshold && !storageMonitorAlerted {
+		storageMonitorAlerted = true
+		shared.Log.Warn("Storage pool usage is above the threshold", log.Ctx{"source": pool.Source, "usage": usage, "threshold": threshold})
+		eventSendLifecycle("storage-usage-exceeded", source, context)
+	} else if usage < threshold && storageMonitorAlerted {
+		storageMonitorAlerted = false
+		shared.Log.Info("Storage pool usage is back below the threshold", log.Ctx{"source": pool.Source, "usage": usage, "threshold": threshold})
+		eventSendLifecycle("storage-usage-recovered", source, context)
+	}
+}
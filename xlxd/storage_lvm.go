@@ -6,6 +6,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -165,6 +167,18 @@ func containerNameToLVName(containerName string) string {
 	return strings.Replace(lvName, shared.SnapshotDelimiter, "-", -1)
 }
 
+var lvmImageName = regexp.MustCompile("^[0-9a-f]{64}$")
+
+// lvNameToContainerName is the reverse of containerNameToLVName.
+func lvNameToContainerName(lvName string) string {
+	fields := strings.Split(lvName, "--")
+	for i, field := range fields {
+		fields[i] = strings.Replace(field, "-", shared.SnapshotDelimiter, -1)
+	}
+
+	return strings.Join(fields, "-")
+}
+
 type storageLvm struct {
 	d      *Daemon
 	vgName string
@@ -255,10 +269,9 @@ func (s *storageLvm) ContainerCreateFromImage(
 	imageLVFilename := shared.VarPath(
 		"images", fmt.Sprintf("%s.lv", imageFingerprint))
 
-	if !shared.PathExists(imageLVFilename) {
-		if err := s.ImageCreate(imageFingerprint); err != nil {
-			return err
-		}
+	err := s.imageEnsure(imageFingerprint, imageLVFilename, s.ImageCreate, s.ImageDelete)
+	if err != nil {
+		return err
 	}
 
 	containerName := containerNameToLVName(container.Name())
@@ -548,6 +561,127 @@ func (s *storageLvm) ContainerRestore(
 	return nil
 }
 
+func (s *storageLvm) ContainerGetUsage(container container) (int64, int64, error) {
+	lvName := containerNameToLVName(container.Name())
+	output, err := exec.Command(
+		"lvs",
+		"--noheadings",
+		"--nosuffix",
+		"--units", "b",
+		"-o", "lv_size,data_percent",
+		fmt.Sprintf("%s/%s", s.vgName, lvName)).Output()
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to get LVM usage of '%s': %v", lvName, err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return -1, -1, fmt.Errorf("Unexpected lvs output: %s", output)
+	}
+
+	size, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	// The decimal separator depends on the locale
+	percent, err := strconv.ParseFloat(strings.Replace(fields[1], ",", ".", 1), 64)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	// The LV size is the most the container can ever use
+	return int64(float64(size) * percent / 100), size, nil
+}
+
+func (s *storageLvm) PoolInfo() (shared.StoragePool, error) {
+	pool := shared.StoragePool{Source: s.vgName}
+
+	output, err := exec.Command(
+		"vgs",
+		"--noheadings",
+		"--nosuffix",
+		"--units", "b",
+		"-o", "vg_size,vg_free",
+		s.vgName).Output()
+	if err != nil {
+		return pool, fmt.Errorf("Failed to get the size of '%s': %v", s.vgName, err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return pool, fmt.Errorf("Unexpected vgs output: %s", output)
+	}
+
+	pool.Total, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return pool, err
+	}
+
+	pool.Available, err = strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return pool, err
+	}
+	pool.Used = pool.Total - pool.Available
+
+	// The thin pool is only created along with the first container
+	poolName, err := s.d.ConfigValueGet("storage.lvm_thinpool_name")
+	if err != nil || poolName == "" {
+		return pool, err
+	}
+
+	output, err = exec.Command(
+		"lvs",
+		"--noheadings",
+		"-o", "data_percent,metadata_percent",
+		fmt.Sprintf("%s/%s", s.vgName, poolName)).Output()
+	if err != nil {
+		return pool, fmt.Errorf("Failed to get the usage of thin pool '%s': %v", poolName, err)
+	}
+
+	fields = strings.Fields(string(output))
+	if len(fields) != 2 {
+		return pool, fmt.Errorf("Unexpected lvs output: %s", output)
+	}
+
+	// The decimal separator depends on the locale
+	thin := shared.StoragePoolThin{Name: poolName}
+	thin.DataUsage, err = strconv.ParseFloat(strings.Replace(fields[0], ",", ".", 1), 64)
+	if err != nil {
+		return pool, err
+	}
+
+	thin.MetadataUsage, err = strconv.ParseFloat(strings.Replace(fields[1], ",", ".", 1), 64)
+	if err != nil {
+		return pool, err
+	}
+	pool.ThinPool = &thin
+
+	return pool, nil
+}
+
+func (s *storageLvm) ContainerList() ([]string, error) {
+	output, err := s.tryExec("lvs", "--noheadings", "-o", "lv_name,pool_lv", s.vgName)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the LVs of '%s': %v", s.vgName, err)
+	}
+
+	names := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := strings.Fields(line)
+
+		// Only thin volumes hold containers, the images are named after
+		// their fingerprint
+		if len(fields) != 2 || lvmImageName.MatchString(fields[0]) {
+			continue
+		}
+
+		names = append(names, lvNameToContainerName(fields[0]))
+	}
+
+	return names, nil
+}
+
 func (s *storageLvm) ContainerSnapshotCreate(
 	snapshotContainer container, sourceContainer container) error {
 	return s.createSnapshotContainer(snapshotContainer, sourceContainer, true)
@@ -2,9 +2,18 @@ package main
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
 
 	"github.com/gorilla/websocket"
 
+	"github.com/krschwab/xlxd/shared"
+
 	log "gopkg.in/inconshreveable/log15.v2"
 )
 
@@ -79,6 +88,18 @@ func (s *storageMock) ContainerRestore(
 	return nil
 }
 
+func (s *storageMock) ContainerGetUsage(container container) (int64, int64, error) {
+	return 0, -1, nil
+}
+
+func (s *storageMock) ContainerList() ([]string, error) {
+	return []string{}, nil
+}
+
+func (s *storageMock) PoolInfo() (shared.StoragePool, error) {
+	return shared.StoragePool{}, nil
+}
+
 func (s *storageMock) ContainerSnapshotCreate(
 	snapshotContainer container, sourceContainer container) error {
 
@@ -126,3 +147,66 @@ func (s *storageMock) MigrationSource(container container) ([]MigrationStorageSo
 func (s *storageMock) MigrationSink(container container, snapshots []container, conn *websocket.Conn) error {
 	return nil
 }
+
+func TestLvNameToContainerName(t *testing.T) {
+	for _, name := range []string{"c1", "my-container", "c1/snap0", "my-c1/my-snap", "a-/b"} {
+		lvName := containerNameToLVName(name)
+		if lvNameToContainerName(lvName) != name {
+			t.Errorf("%s became %s and then %s", name, lvName, lvNameToContainerName(lvName))
+		}
+	}
+}
+
+func TestImageEnsure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ss := storageShared{}
+	if err := ss.initShared(); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, "image.btrfs")
+	var creations int32
+	create := func(fingerprint string) error {
+		atomic.AddInt32(&creations, 1)
+		time.Sleep(10 * time.Millisecond)
+		return os.Mkdir(path, 0700)
+	}
+
+	// Concurrent creations from the same image unpack it once
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := ss.imageEnsure("image", path, create, os.Remove); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if creations != 1 {
+		t.Errorf("The image was unpacked %d times", creations)
+	}
+
+	if len(imageLocks) != 0 {
+		t.Errorf("%d image locks were left behind", len(imageLocks))
+	}
+
+	// A failed unpack is cleaned up for the next try
+	failed := filepath.Join(dir, "failed.btrfs")
+	err = ss.imageEnsure("failed", failed, func(fingerprint string) error {
+		os.Mkdir(failed, 0700)
+		return fmt.Errorf("unpack failed")
+	}, func(fingerprint string) error {
+		return os.Remove(failed)
+	})
+	if err == nil || shared.PathExists(failed) {
+		t.Errorf("The failed unpack was kept: %v", err)
+	}
+}
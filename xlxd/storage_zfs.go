@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -127,14 +128,17 @@ func (s *storageZfs) ContainerCreateFromImage(container container, fingerprint s
 	fs := fmt.Sprintf("containers/%s", container.Name())
 	fsImage := fmt.Sprintf("images/%s", fingerprint)
 
-	if !shared.PathExists(subvol) {
-		err := s.ImageCreate(fingerprint)
-		if err != nil {
-			return err
-		}
+	// A half unpacked image has no snapshot yet, it's simply destroyed
+	err := s.imageEnsure(fingerprint, subvol, s.ImageCreate, func(fingerprint string) error {
+		err := s.zfsDestroy(fsImage)
+		os.Remove(subvol)
+		return err
+	})
+	if err != nil {
+		return err
 	}
 
-	err := s.zfsClone(fsImage, "readonly", fs, true)
+	err = s.zfsClone(fsImage, "readonly", fs, true)
 	if err != nil {
 		return err
 	}
@@ -205,7 +209,7 @@ func (s *storageZfs) ContainerDelete(container container) error {
 	}
 
 	if removable {
-		origin, err := s.zfsGet(fs, "origin")
+		origin, err := s.zfsGet(fs, "origin", false)
 		if err != nil {
 			return err
 		}
@@ -281,12 +285,11 @@ func (s *storageZfs) ContainerCopy(container container, sourceContainer containe
 		}
 	}
 
-	if sourceFs != "" {
-		err := s.zfsClone(sourceFs, sourceSnap, destFs, true)
-		if err != nil {
-			return err
-		}
-	} else {
+	// Only sources which aren't on ZFS need copying, the others are
+	// cloned in no time whatever their size
+	if sourceFs == "" {
+		s.log.Info("Copy from non-ZFS container, using rsync", log.Ctx{"container": container.Name(), "sourceContainer": sourceContainer.Name()})
+
 		err := s.ContainerCreate(container)
 		if err != nil {
 			return err
@@ -294,12 +297,21 @@ func (s *storageZfs) ContainerCopy(container container, sourceContainer containe
 
 		output, err := storageRsyncCopy(sourceContainer.Path(), container.Path())
 		if err != nil {
+			s.ContainerDelete(container)
+			s.log.Error("ContainerCopy: rsync failed", log.Ctx{"output": string(output)})
 			return fmt.Errorf("rsync failed: %s", string(output))
 		}
+
+		return container.TemplateApply("copy")
+	}
+
+	err := s.zfsClone(sourceFs, sourceSnap, destFs, true)
+	if err != nil {
+		return err
 	}
 
 	cPath := container.Path()
-	err := os.Symlink(cPath+".zfs", cPath)
+	err = os.Symlink(cPath+".zfs", cPath)
 	if err != nil {
 		return err
 	}
@@ -415,6 +427,110 @@ func (s *storageZfs) ContainerRestore(container container, sourceContainer conta
 	return nil
 }
 
+func (s *storageZfs) PoolInfo() (shared.StoragePool, error) {
+	pool := shared.StoragePool{Source: s.zfsPool}
+
+	// The dataset may have a quota, so use its numbers rather than the
+	// zpool ones
+	output, err := exec.Command(
+		"zfs",
+		"get",
+		"-H",
+		"-p",
+		"-o", "value",
+		"used,available",
+		s.zfsPool).Output()
+	if err != nil {
+		return pool, fmt.Errorf("Failed to get the usage of '%s': %v", s.zfsPool, err)
+	}
+
+	fields := strings.Fields(string(output))
+	if len(fields) != 2 {
+		return pool, fmt.Errorf("Unexpected zfs output: %s", output)
+	}
+
+	pool.Used, err = strconv.ParseUint(fields[0], 10, 64)
+	if err != nil {
+		return pool, err
+	}
+
+	pool.Available, err = strconv.ParseUint(fields[1], 10, 64)
+	if err != nil {
+		return pool, err
+	}
+	pool.Total = pool.Used + pool.Available
+
+	zpool := strings.Split(s.zfsPool, "/")[0]
+	output, err = exec.Command("zpool", "list", "-H", "-o", "health", zpool).Output()
+	if err != nil {
+		return pool, fmt.Errorf("Failed to get the health of '%s': %v", zpool, err)
+	}
+	pool.Health = strings.TrimSpace(string(output))
+
+	return pool, nil
+}
+
+func (s *storageZfs) ContainerList() ([]string, error) {
+	subvols, err := s.zfsListSubvolumes("containers")
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, subvol := range subvols {
+		name := strings.TrimPrefix(subvol, "containers/")
+		if strings.Contains(name, "/") {
+			continue
+		}
+
+		names = append(names, name)
+
+		snapshots, err := s.zfsListSnapshots(subvol)
+		if err != nil {
+			return nil, err
+		}
+
+		// Other snapshots are internal, e.g. the source of copies
+		for _, snapshot := range snapshots {
+			if strings.HasPrefix(snapshot, "snapshot-") {
+				names = append(names, name+shared.SnapshotDelimiter+strings.TrimPrefix(snapshot, "snapshot-"))
+			}
+		}
+	}
+
+	return names, nil
+}
+
+func (s *storageZfs) ContainerGetUsage(container container) (int64, int64, error) {
+	fs := fmt.Sprintf("containers/%s", container.Name())
+	if container.IsSnapshot() {
+		fields := strings.SplitN(container.Name(), shared.SnapshotDelimiter, 2)
+		fs = fmt.Sprintf("containers/%s@snapshot-%s", fields[0], fields[1])
+	}
+
+	value, err := s.zfsGet(fs, "used", true)
+	if err != nil {
+		return -1, -1, fmt.Errorf("Failed to get ZFS usage of '%s': %s (%s)", fs, strings.TrimSpace(value), err)
+	}
+
+	usage, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return -1, -1, err
+	}
+
+	// Snapshots don't have a quota ("-")
+	quota := int64(-1)
+	value, err = s.zfsGet(fs, "quota", true)
+	if err == nil {
+		size, err := strconv.ParseInt(value, 10, 64)
+		if err == nil && size > 0 {
+			quota = size
+		}
+	}
+
+	return usage, quota, nil
+}
+
 func (s *storageZfs) ContainerSnapshotCreate(snapshotContainer container, sourceContainer container) error {
 	fields := strings.SplitN(snapshotContainer.Name(), shared.SnapshotDelimiter, 2)
 	cName := fields[0]
@@ -724,7 +840,7 @@ func (s *storageZfs) zfsCreate(path string) error {
 }
 
 func (s *storageZfs) zfsDestroy(path string) error {
-	mountpoint, err := s.zfsGet(path, "mountpoint")
+	mountpoint, err := s.zfsGet(path, "mountpoint", false)
 	if err != nil {
 		return err
 	}
@@ -770,7 +886,7 @@ func (s *storageZfs) zfsCleanup(path string) error {
 		if removablePath {
 			subPath := strings.SplitN(path, "@", 2)[0]
 
-			origin, err := s.zfsGet(subPath, "origin")
+			origin, err := s.zfsGet(subPath, "origin", false)
 			if err != nil {
 				return err
 			}
@@ -791,7 +907,7 @@ func (s *storageZfs) zfsCleanup(path string) error {
 }
 
 func (s *storageZfs) zfsExists(path string) bool {
-	output, _ := s.zfsGet(path, "name")
+	output, _ := s.zfsGet(path, "name", false)
 
 	if output == fmt.Sprintf("%s/%s", s.zfsPool, path) {
 		return true
@@ -800,14 +916,17 @@ func (s *storageZfs) zfsExists(path string) bool {
 	return false
 }
 
-func (s *storageZfs) zfsGet(path string, key string) (string, error) {
+// zfsGet returns a property of a dataset, with its exact value, like a size
+// in bytes, when parsable is set.
+func (s *storageZfs) zfsGet(path string, key string, parsable bool) (string, error) {
+	args := []string{"get", "-H", "-o", "value"}
+	if parsable {
+		args = append(args, "-p")
+	}
+
 	output, err := exec.Command(
 		"zfs",
-		"get",
-		"-H",
-		"-o", "value",
-		key,
-		fmt.Sprintf("%s/%s", s.zfsPool, path)).CombinedOutput()
+		append(args, key, fmt.Sprintf("%s/%s", s.zfsPool, path))...).CombinedOutput()
 	if err != nil {
 		return string(output), err
 	}
@@ -1033,7 +1152,7 @@ func (s *storageZfs) zfsSnapshotRemovable(path string, name string) (bool, error
 		snap = fmt.Sprintf("%s@%s", path, name)
 	}
 
-	clones, err := s.zfsGet(snap, "clones")
+	clones, err := s.zfsGet(snap, "clones", false)
 	if err != nil {
 		return false, err
 	}
@@ -1196,6 +1315,12 @@ func (s zfsMigrationSource) Send(conn *websocket.Conn) error {
 	return err
 }
 
+func (s zfsMigrationSource) Cleanup() {
+	if s.deleteAfterSending {
+		s.zfs.zfsDestroy(s.zfsName)
+	}
+}
+
 func (s *storageZfs) MigrationType() MigrationFSType {
 	return MigrationFSType_ZFS
 }
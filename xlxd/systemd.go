@@ -0,0 +1,33 @@
+package main
+
+import (
+	"net"
+	"os"
+
+	"github.com/krschwab/xlxd/shared"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+)
+
+// sdNotify tells systemd about a state change ("READY=1", "RELOADING=1" or
+// "STOPPING=1"), this is a no-op unless running as a Type=notify unit.
+func sdNotify(state string) {
+	socketPath := os.Getenv("NOTIFY_SOCKET")
+	if socketPath == "" {
+		return
+	}
+
+	// A leading @ is an abstract socket, which the net package handles
+	addr := &net.UnixAddr{Name: socketPath, Net: "unixgram"}
+	conn, err := net.DialUnix("unixgram", nil, addr)
+	if err != nil {
+		shared.Log.Warn("Failed to notify systemd", log.Ctx{"state": state, "err": err})
+		return
+	}
+	defer conn.Close()
+
+	_, err = conn.Write([]byte(state))
+	if err != nil {
+		shared.Log.Warn("Failed to notify systemd", log.Ctx{"state": state, "err": err})
+	}
+}
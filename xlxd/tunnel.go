@@ -0,0 +1,143 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+type tunnelServe struct {
+	req  *http.Request
+	conn net.Conn
+}
+
+func (r *tunnelServe) Render(w http.ResponseWriter) error {
+	c, err := shared.WebsocketUpgrader.Upgrade(w, r.req, nil)
+	if err != nil {
+		r.conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	readDone, writeDone := shared.WebsocketMirror(c, r.conn, r.conn)
+	<-readDone
+	<-writeDone
+
+	return nil
+}
+
+// tunnelAddressesParse parses core.tunnel_addresses, the addresses and
+// subnets the daemon relays connections to.
+func tunnelAddressesParse(value string) ([]*net.IPNet, error) {
+	subnets := []*net.IPNet{}
+
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("Invalid tunnel address: %s", entry)
+			}
+
+			if ip.To4() != nil {
+				entry = fmt.Sprintf("%s/32", entry)
+			} else {
+				entry = fmt.Sprintf("%s/128", entry)
+			}
+		}
+
+		_, subnet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid tunnel subnet: %s", entry)
+		}
+
+		subnets = append(subnets, subnet)
+	}
+
+	return subnets, nil
+}
+
+// tunnelAddress returns the address to connect to for the one requested,
+// its host resolved to an IP the daemon relays connections to.
+func tunnelAddress(d *Daemon, address string) (string, error) {
+	value, err := d.ConfigValueGet("core.tunnel_addresses")
+	if err != nil {
+		return "", err
+	}
+
+	subnets, err := tunnelAddressesParse(value)
+	if err != nil {
+		return "", err
+	}
+
+	if len(subnets) == 0 {
+		return "", fmt.Errorf("This daemon doesn't relay connections, see core.tunnel_addresses")
+	}
+
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", err
+	}
+
+	// The IP checked is the one connected to, whatever the host resolves
+	// to later on
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		ips, err = net.LookupIP(host)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	for _, ip := range ips {
+		for _, subnet := range subnets {
+			if subnet.Contains(ip) {
+				return net.JoinHostPort(ip.String(), port), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("This daemon doesn't relay connections to %s", address)
+}
+
+// tunnelGet relays a TCP connection from the daemon to the address over a
+// websocket, for the clients which can't reach it on their own: those of the
+// remotes with this daemon as their "via". Only the addresses allowed by
+// core.tunnel_addresses can be reached, none by default.
+func tunnelGet(d *Daemon, r *http.Request) Response {
+	if d.isReadOnlyClient(r) {
+		return Forbidden
+	}
+
+	address := r.FormValue("address")
+	if address == "" {
+		return BadRequest(fmt.Errorf("No address to connect to"))
+	}
+
+	_, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return BadRequest(err)
+	}
+
+	target, err := tunnelAddress(d, address)
+	if err != nil {
+		return &errorResponse{http.StatusForbidden, err.Error(), nil}
+	}
+
+	conn, err := net.DialTimeout("tcp", target, 10*time.Second)
+	if err != nil {
+		return InternalError(err)
+	}
+
+	return &tunnelServe{r, conn}
+}
+
+var tunnelCmd = Command{name: "tunnel", get: tunnelGet}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestTunnelAddressesParse(t *testing.T) {
+	subnets, err := tunnelAddressesParse("192.0.2.1, 2001:db8::/64,")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(subnets) != 2 || subnets[0].String() != "192.0.2.1/32" || subnets[1].String() != "2001:db8::/64" {
+		t.Errorf("Wrong subnets: %v", subnets)
+	}
+
+	subnets, err = tunnelAddressesParse("")
+	if err != nil || len(subnets) != 0 {
+		t.Errorf("An empty value should allow nothing: %v, %v", subnets, err)
+	}
+
+	_, err = tunnelAddressesParse("lxd.example.com")
+	if err == nil {
+		t.Errorf("A hostname was accepted")
+	}
+}
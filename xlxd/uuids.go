@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pborman/uuid"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// uuidHandler lets the containers, snapshots and images be addressed by their
+// UUID, which doesn't change when they get renamed: /1.0/containers/<uuid>
+// and /1.0/images/<uuid> are served as the URLs with their current name or
+// fingerprint.
+func uuidHandler(d *Daemon, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fields := strings.SplitN(r.URL.Path, "/", 5)
+		if len(fields) < 4 || fields[1] != shared.APIVersion || uuid.Parse(fields[3]) == nil {
+			handler.ServeHTTP(w, r)
+			return
+		}
+
+		var name string
+		var err error
+		switch fields[2] {
+		case "containers":
+			name, err = dbContainerNameFromUUID(d.db, fields[3])
+			if err == nil && shared.IsSnapshot(name) {
+				parts := strings.SplitN(name, shared.SnapshotDelimiter, 2)
+				name = fmt.Sprintf("%s/snapshots/%s", parts[0], parts[1])
+			}
+		case "images":
+			name, err = dbImageFingerprintFromUUID(d.db, fields[3])
+		default:
+			err = NoSuchObjectError
+		}
+
+		// Names may look like UUIDs too
+		if err == nil {
+			fields[3] = name
+			r.URL.Path = strings.Join(fields, "/")
+			r.URL.RawPath = ""
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "gopkg.in/inconshreveable/log15.v2"
+
+	"github.com/krschwab/xlxd/shared"
+)
+
+// webuiAsset is a file of the web UI, built in so that there's nothing to
+// install to use it.
+type webuiAsset struct {
+	contentType string
+	content     string
+}
+
+var webuiAssets = map[string]webuiAsset{
+	"":          {"text/html; charset=utf-8", webuiIndex},
+	"webui.css": {"text/css; charset=utf-8", webuiStyle},
+	"webui.js":  {"application/javascript; charset=utf-8", webuiScript},
+}
+
+// webuiHandler serves the web UI on /ui/ when core.webui is set, to trusted
+// clients only. The page then uses the API like any other client.
+func webuiHandler(d *Daemon) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		enabled, err := d.ConfigValueGet("core.webui")
+		if err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			InternalError(err).Render(w)
+			return
+		}
+
+		asset, ok := webuiAssets[strings.TrimPrefix(r.URL.Path, "/ui/")]
+		if !shared.IsTrue(enabled) || !ok || r.Method != "GET" {
+			w.Header().Set("Content-Type", "application/json")
+			NotFound.Render(w)
+			return
+		}
+
+		if !d.isTrustedClient(r) {
+			shared.Log.Warn(
+				"rejecting web UI request from untrusted client",
+				log.Ctx{"ip": r.RemoteAddr})
+			w.Header().Set("Content-Type", "application/json")
+			Forbidden.Render(w)
+			return
+		}
+
+		w.Header().Set("Content-Type", asset.contentType)
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Write([]byte(asset.content))
+	}
+}
+
+// webuiSameOrigin returns whether a request sent by a browser comes from a
+// page of the daemon. Browsers given a client certificate for the web UI
+// present it to the daemon whatever the site making the request, which
+// mustn't be able to use the API with it.
+func webuiSameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return u.Host == r.Host
+}
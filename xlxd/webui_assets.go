@@ -0,0 +1,417 @@
+package main
+
+// The web UI is a single page talking to the API from the browser, with the
+// client certificate the browser was given.
+
+const webuiIndex = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>LXD</title>
+<link rel="stylesheet" href="/ui/webui.css">
+<script src="/ui/webui.js" defer></script>
+</head>
+<body>
+<header>
+<h1>LXD <small id="server"></small></h1>
+<nav>
+<a href="#containers">Containers</a>
+<a href="#images">Images</a>
+</nav>
+</header>
+<p id="error" hidden></p>
+
+<section id="containers-view">
+<table>
+<thead><tr><th>Name</th><th>Status</th><th>Addresses</th><th></th></tr></thead>
+<tbody id="containers"></tbody>
+</table>
+</section>
+
+<section id="terminal-view" hidden>
+<h2>Terminal of <span id="terminal-name"></span> <button id="terminal-close">Close</button></h2>
+<pre id="terminal" tabindex="0"></pre>
+</section>
+
+<section id="images-view" hidden>
+<table>
+<thead><tr><th>Aliases</th><th>Fingerprint</th><th>Description</th><th>Public</th><th>Size</th><th>Uploaded</th><th></th></tr></thead>
+<tbody id="images"></tbody>
+</table>
+</section>
+</body>
+</html>
+`
+
+const webuiStyle = `body {
+  font-family: sans-serif;
+  margin: 0 2em;
+}
+
+header {
+  display: flex;
+  align-items: baseline;
+  justify-content: space-between;
+}
+
+nav a {
+  margin-left: 1em;
+}
+
+table {
+  border-collapse: collapse;
+  width: 100%;
+}
+
+th, td {
+  border-bottom: 1px solid #ddd;
+  padding: 0.4em;
+  text-align: left;
+}
+
+td button {
+  margin-right: 0.3em;
+}
+
+#error {
+  background: #fdd;
+  padding: 0.5em;
+}
+
+#terminal {
+  background: #000;
+  color: #ddd;
+  height: 30em;
+  overflow-y: scroll;
+  padding: 0.5em;
+  white-space: pre-wrap;
+}
+
+#terminal:focus {
+  outline: 2px solid #48f;
+}
+`
+
+const webuiScript = `(function() {
+  "use strict";
+
+  var view = "containers";
+  var terminal = null;
+  var refreshTimer = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  // el builds an element, text always being set as such
+  function el(tag, text, children) {
+    var node = document.createElement(tag);
+    if (text) {
+      node.textContent = text;
+    }
+
+    (children || []).forEach(function(child) {
+      node.appendChild(child);
+    });
+
+    return node;
+  }
+
+  function button(text, action) {
+    var node = el("button", text);
+    node.addEventListener("click", action);
+    return node;
+  }
+
+  function showError(err) {
+    $("error").textContent = err.message || String(err);
+    $("error").hidden = false;
+  }
+
+  function api(method, path, body) {
+    var options = {method: method, credentials: "same-origin", headers: {}};
+    if (body !== undefined) {
+      options.body = JSON.stringify(body);
+      options.headers["Content-Type"] = "application/json";
+    }
+
+    return fetch("/1.0" + path, options).then(function(resp) {
+      return resp.json();
+    }).then(function(data) {
+      if (data.type === "error") {
+        throw new Error(data.error);
+      }
+
+      return data;
+    });
+  }
+
+  // wait returns once the operation of an async response is done
+  function wait(data) {
+    if (data.type !== "async") {
+      return Promise.resolve(data);
+    }
+
+    return api("GET", data.operation.replace(/^\/1.0/, "") + "/wait").then(function(op) {
+      if (op.metadata.status_code >= 400) {
+        throw new Error(op.metadata.err);
+      }
+
+      return op;
+    });
+  }
+
+  function websocketURL(path) {
+    var scheme = location.protocol === "https:" ? "wss://" : "ws://";
+    return scheme + location.host + path;
+  }
+
+  function loadContainers() {
+    return api("GET", "/containers?recursion=1").then(function(data) {
+      var rows = $("containers");
+      rows.textContent = "";
+
+      data.metadata.forEach(function(info) {
+        var name = info.state.name;
+        var status = info.state.status;
+        var path = "/containers/" + encodeURIComponent(name) + "/state";
+
+        var addresses = (status.ips || []).filter(function(ip) {
+          return ip.interface !== "lo";
+        }).map(function(ip) {
+          return ip.address;
+        });
+
+        var state = function(action) {
+          return function() {
+            api("PUT", path, {action: action, timeout: 30}).then(wait).then(refresh).catch(showError);
+          };
+        };
+
+        var actions = [];
+        if (status.status === "Running") {
+          actions.push(button("Stop", state("stop")));
+          actions.push(button("Restart", state("restart")));
+          actions.push(button("Terminal", function() {
+            openTerminal(name);
+          }));
+        } else if (status.status === "Frozen") {
+          actions.push(button("Unfreeze", state("unfreeze")));
+        } else {
+          actions.push(button("Start", state("start")));
+        }
+
+        rows.appendChild(el("tr", "", [
+          el("td", name),
+          el("td", status.status),
+          el("td", addresses.join(" ")),
+          el("td", "", actions)
+        ]));
+      });
+    });
+  }
+
+  function loadImages() {
+    return api("GET", "/images?recursion=1").then(function(data) {
+      var rows = $("images");
+      rows.textContent = "";
+
+      data.metadata.forEach(function(image) {
+        var path = "/images/" + image.fingerprint;
+        var aliases = (image.aliases || []).map(function(alias) {
+          return alias.target;
+        });
+
+        var togglePublic = button(image.public ? "Make private" : "Make public", function() {
+          api("PUT", path, {properties: image.properties, public: !image.public}).then(refresh).catch(showError);
+        });
+
+        var remove = button("Delete", function() {
+          if (!confirm("Delete the image " + image.fingerprint.substr(0, 12) + "?")) {
+            return;
+          }
+
+          api("DELETE", path).then(wait).then(refresh).catch(showError);
+        });
+
+        rows.appendChild(el("tr", "", [
+          el("td", aliases.join(", ")),
+          el("td", image.fingerprint.substr(0, 12)),
+          el("td", (image.properties || {}).description || ""),
+          el("td", image.public ? "yes" : "no"),
+          el("td", (image.size / 1024 / 1024).toFixed(2) + "MB"),
+          el("td", new Date(image.uploaded_at * 1000).toLocaleString()),
+          el("td", "", [togglePublic, remove])
+        ]));
+      });
+    });
+  }
+
+  function refresh() {
+    if (view === "images") {
+      return loadImages().catch(showError);
+    }
+
+    return loadContainers().catch(showError);
+  }
+
+  // Keys which don't produce their own character
+  var keys = {
+    Enter: "\r",
+    Backspace: "\x7f",
+    Tab: "\t",
+    Escape: "\x1b",
+    ArrowUp: "\x1b[A",
+    ArrowDown: "\x1b[B",
+    ArrowRight: "\x1b[C",
+    ArrowLeft: "\x1b[D",
+    Home: "\x1b[H",
+    End: "\x1b[F",
+    Delete: "\x1b[3~"
+  };
+
+  // write adds output to the terminal, the escape sequences of which it
+  // doesn't interpret are dropped
+  function write(text) {
+    var output = $("terminal");
+    var content = output.textContent;
+
+    text = text.replace(/\x1b\[[0-9;?]*[A-Za-z]/g, "").replace(/\x1b\][^\x07]*\x07/g, "");
+    for (var i = 0; i < text.length; i++) {
+      var c = text.charAt(i);
+      if (c === "\b") {
+        content = content.slice(0, -1);
+      } else if (c !== "\r" && c !== "\x07") {
+        content += c;
+      }
+    }
+
+    output.textContent = content.slice(-100000);
+    output.scrollTop = output.scrollHeight;
+  }
+
+  function closeTerminal() {
+    if (terminal) {
+      terminal.data.close();
+      terminal.control.close();
+      terminal = null;
+    }
+
+    $("terminal-view").hidden = true;
+  }
+
+  function openTerminal(name) {
+    closeTerminal();
+
+    var exec = {
+      command: ["/bin/sh", "-c", "command -v bash >/dev/null && exec bash -l || exec sh -l"],
+      environment: {TERM: "dumb", HOME: "/root", USER: "root"},
+      "wait-for-websocket": true,
+      interactive: true
+    };
+
+    api("POST", "/containers/" + encodeURIComponent(name) + "/exec", exec).then(function(data) {
+      var fds = data.metadata.metadata.fds;
+      var base = websocketURL(data.operation + "/websocket?secret=");
+      var decoder = new TextDecoder();
+
+      $("terminal").textContent = "";
+      $("terminal-name").textContent = name;
+      $("terminal-view").hidden = false;
+      $("terminal").focus();
+
+      var session = {
+        control: new WebSocket(base + fds.control),
+        data: new WebSocket(base + fds["0"]),
+        encoder: new TextEncoder()
+      };
+      terminal = session;
+
+      session.control.onopen = function() {
+        session.control.send(JSON.stringify({command: "window-resize", args: {width: "100", height: "30"}}));
+      };
+
+      session.data.binaryType = "arraybuffer";
+      session.data.onmessage = function(e) {
+        if (typeof e.data === "string") {
+          write(e.data);
+        } else {
+          write(decoder.decode(new Uint8Array(e.data), {stream: true}));
+        }
+      };
+
+      session.data.onclose = function() {
+        write("\n[session closed]\n");
+      };
+    }).catch(showError);
+  }
+
+  function send(text) {
+    if (terminal && terminal.data.readyState === WebSocket.OPEN) {
+      terminal.data.send(terminal.encoder.encode(text));
+    }
+  }
+
+  $("terminal").addEventListener("keydown", function(e) {
+    var text = null;
+    if (e.ctrlKey && e.key.length === 1 && /[a-z]/i.test(e.key)) {
+      text = String.fromCharCode(e.key.toLowerCase().charCodeAt(0) - 96);
+    } else if (keys[e.key]) {
+      text = keys[e.key];
+    } else if (e.key.length === 1 && !e.altKey && !e.metaKey) {
+      text = e.key;
+    }
+
+    if (text !== null) {
+      e.preventDefault();
+      send(text);
+    }
+  });
+
+  $("terminal").addEventListener("paste", function(e) {
+    e.preventDefault();
+    send(e.clipboardData.getData("text"));
+  });
+
+  $("terminal-close").addEventListener("click", closeTerminal);
+
+  function showView() {
+    view = location.hash === "#images" ? "images" : "containers";
+    $("containers-view").hidden = view !== "containers";
+    $("images-view").hidden = view !== "images";
+    if (view !== "containers") {
+      closeTerminal();
+    }
+
+    $("error").hidden = true;
+    refresh();
+  }
+
+  // The views are refreshed once operations are done
+  function listen() {
+    var events = new WebSocket(websocketURL("/1.0/events?type=operation"));
+    events.onmessage = function(e) {
+      var event = JSON.parse(e.data);
+      if (event.metadata.status_code < 200) {
+        return;
+      }
+
+      clearTimeout(refreshTimer);
+      refreshTimer = setTimeout(refresh, 500);
+    };
+
+    events.onclose = function() {
+      setTimeout(listen, 5000);
+    };
+  }
+
+  window.addEventListener("hashchange", showView);
+
+  api("GET", "").then(function(data) {
+    $("server").textContent = data.metadata.environment.server_version;
+  }).catch(showError);
+
+  showView();
+  listen();
+})();
+`